@@ -7,26 +7,69 @@ import (
 	"github.com/nextdns/nextdns/config"
 	"github.com/nextdns/nextdns/host"
 	"github.com/nextdns/nextdns/hosts"
+	"github.com/nextdns/nextdns/proxy"
+	"github.com/nextdns/nextdns/router"
 )
 
 func activation(args []string) error {
 	cmd := args[0]
 	var c config.Config
 	c.Parse("nextdns "+cmd, nil, true)
-	defer c.Save()
 	switch cmd {
 	case "activate":
 		c.AutoActivate = true
-		return activate(c)
 	case "deactivate":
 		c.AutoActivate = false
-		return deactivate()
 	default:
-		return fmt.Errorf("%s: unknown command", cmd)
+		return withCode(exitUsage, fmt.Errorf("%s: unknown command", cmd))
 	}
+	changes, err := configChanges(c)
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 && !checkMode {
+		defer c.Save()
+	}
+	// The resolver changes are applied again even when already active as
+	// the listen address may have changed.
+	active := len(host.DNSResidue()) > 0
+	if c.AutoActivate && !active {
+		changes = append(changes, "set system resolver")
+	} else if !c.AutoActivate && active {
+		changes = append(changes, "restore system resolver")
+	}
+	if checkMode {
+		return reportChanges(changes)
+	}
+	if c.AutoActivate {
+		if c.SetupRouter {
+			// Configure may change the listen address, do not save it.
+			rc := c
+			r := router.New()
+			if err := r.Configure(&rc); err != nil {
+				return withCode(exitSystem, fmt.Errorf("activate: router: %w", err))
+			}
+			if err := r.Setup(); err != nil {
+				return withCode(exitSystem, fmt.Errorf("activate: router: %w", err))
+			}
+		}
+		if err := activate(c); err != nil {
+			return withCode(exitSystem, err)
+		}
+	} else {
+		if c.SetupRouter {
+			if err := restoreRouter(router.New(), c); err != nil {
+				return withCode(exitSystem, fmt.Errorf("deactivate: router: %w", err))
+			}
+		}
+		if err := deactivate(); err != nil {
+			return withCode(exitSystem, err)
+		}
+	}
+	return reportChanges(changes)
 }
 
-func listenIP(listen string) (string, error) {
+func listenIPOf(listen string) (string, error) {
 	host, port, err := net.SplitHostPort(listen)
 	if err != nil {
 		return "127.0.0.1", nil
@@ -44,6 +87,14 @@ func listenIP(listen string) (string, error) {
 		return "::1", nil
 	}
 	addrs := hosts.LookupHost(host)
+	if iface, err := net.InterfaceByName(host); err == nil && len(addrs) == 0 {
+		ifaddrs, _ := iface.Addrs()
+		for _, a := range ifaddrs {
+			if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLinkLocalUnicast() {
+				addrs = append(addrs, ipnet.IP.String())
+			}
+		}
+	}
 	if len(addrs) == 0 {
 		return "", fmt.Errorf("activate: %s: no address found", listen)
 	}
@@ -59,13 +110,36 @@ func activate(c config.Config) error {
 		// from dnsmasq cache.
 		listen = "127.0.0.1:53"
 	}
-	listenIP, err := listenIP(listen)
+	// Use the first address suitable for activation.
+	var listenIP string
+	var err error
+	for _, addr := range proxy.SplitAddr(listen) {
+		if listenIP, err = listenIPOf(addr); err == nil {
+			break
+		}
+	}
 	if err != nil {
 		return err
 	}
+	if err := setupFirewall(c.Listen); err != nil {
+		return fmt.Errorf("activate: firewall: %v", err)
+	}
 	return host.SetDNS(listenIP)
 }
 
+// setupFirewall opens the firewall for the ports listened on by the proxy.
+func setupFirewall(listen string) error {
+	udp, tcp, err := proxy.ListenPorts(listen)
+	if err != nil {
+		return err
+	}
+	return host.SetupFirewall(udp, tcp)
+}
+
 func deactivate() error {
-	return host.ResetDNS()
+	err := host.ResetDNS()
+	if e := host.ResetFirewall(); e != nil && err == nil {
+		err = fmt.Errorf("deactivate: firewall: %v", e)
+	}
+	return err
 }
@@ -0,0 +1,265 @@
+// Package anomaly implements a detector flagging LAN clients whose DNS query
+// volume or number of unique domains deviates significantly from their
+// baseline, which can be a sign of malware beaconing or data exfiltration over
+// DNS.
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metrics checked by the detector.
+const (
+	Queries = "queries"
+	Domains = "domains"
+)
+
+const (
+	// alpha is the smoothing factor of the baselines.
+	alpha = 0.1
+
+	// maxEvidence is the maximum number of domains attached to an anomaly.
+	maxEvidence = 10
+
+	// maxDomains is the maximum number of unique domains tracked per client
+	// and interval.
+	maxDomains = 10000
+
+	// idleIntervals is the number of intervals without queries after which a
+	// client is forgotten.
+	idleIntervals = 1440
+)
+
+// Anomaly is reported when a client metric starts deviating from its
+// baseline.
+type Anomaly struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Name     string    `json:"name,omitempty"`
+	Metric   string    `json:"metric"`
+	Value    float64   `json:"value"`
+	Baseline float64   `json:"baseline"`
+	// Deviation is the number of standard deviations Value is above Baseline.
+	Deviation float64 `json:"deviation"`
+	// Domains holds the most queried domains of the interval.
+	Domains []string `json:"domains,omitempty"`
+}
+
+func (a Anomaly) String() string {
+	client := a.Client
+	if a.Name != "" {
+		client = fmt.Sprintf("%s (%s)", a.Client, a.Name)
+	}
+	return fmt.Sprintf("Anomaly detected for %s: %.0f %s (baseline %.1f, %.1f deviations), top domains: %v",
+		client, a.Value, a.Metric, a.Baseline, a.Deviation, a.Domains)
+}
+
+// Detector baselines per client query volume and unique domain counts over
+// fixed intervals.
+type Detector struct {
+	// Interval is the duration over which queries are counted. Default is
+	// one minute.
+	Interval time.Duration
+
+	// Threshold is the number of standard deviations above the baseline a
+	// metric must reach to be flagged. Default is 4.
+	Threshold float64
+
+	// MinQueries is the minimum value a metric must reach to be flagged, so
+	// low traffic clients are not reported for insignificant variations.
+	// Default is 50.
+	MinQueries int
+
+	// Warmup is the number of intervals a client baseline is learned before
+	// being checked. Default is 30.
+	Warmup int
+
+	// Sample tracks only one out of Sample domains, based on a hash of the
+	// domain name, to reduce the cost of the detector. Counts are scaled
+	// accordingly. Default is 1 (all domains).
+	Sample int
+
+	// OnAnomaly is called when a client metric starts deviating.
+	OnAnomaly func(Anomaly)
+
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+type client struct {
+	name    string
+	queries int
+	domains map[string]int
+	idle    int
+
+	intervals int
+	baselines map[string]*baseline
+}
+
+type baseline struct {
+	mean, variance float64
+	flagged        bool
+}
+
+func (b *baseline) update(v float64) {
+	d := v - b.mean
+	b.mean += alpha * d
+	b.variance = (1 - alpha) * (b.variance + alpha*d*d)
+}
+
+// Record records a query for domain from the client identified by ip. The
+// optional name is reported with anomalies.
+func (d *Detector) Record(ip, name, domain string) {
+	if d.Sample > 1 {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(domain))
+		if h.Sum32()%uint32(d.Sample) != 0 {
+			return
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.clients == nil {
+		d.clients = map[string]*client{}
+	}
+	c := d.clients[ip]
+	if c == nil {
+		c = &client{
+			domains:   map[string]int{},
+			baselines: map[string]*baseline{Queries: {}, Domains: {}},
+		}
+		d.clients[ip] = c
+	}
+	if name != "" {
+		c.name = name
+	}
+	c.queries++
+	if _, found := c.domains[domain]; found || len(c.domains) < maxDomains {
+		c.domains[domain]++
+	}
+}
+
+// Reset forgets the counts and baselines of all clients.
+func (d *Detector) Reset() {
+	d.mu.Lock()
+	d.clients = nil
+	d.mu.Unlock()
+}
+
+// Start checks clients at the end of every interval until ctx is cancelled.
+func (d *Detector) Start(ctx context.Context) {
+	t := time.NewTicker(d.interval())
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			d.Check(now)
+		}
+	}
+}
+
+// Check closes the current interval: metrics of each client are compared to
+// their baseline, OnAnomaly is called for new deviations, and baselines are
+// updated.
+func (d *Detector) Check(now time.Time) {
+	threshold := d.Threshold
+	if threshold <= 0 {
+		threshold = 4
+	}
+	minQueries := float64(d.MinQueries)
+	if minQueries <= 0 {
+		minQueries = 50
+	}
+	warmup := d.Warmup
+	if warmup <= 0 {
+		warmup = 30
+	}
+	scale := 1.0
+	if d.Sample > 1 {
+		scale = float64(d.Sample)
+	}
+
+	var anomalies []Anomaly
+	d.mu.Lock()
+	for ip, c := range d.clients {
+		if c.queries == 0 {
+			if c.idle++; c.idle > idleIntervals {
+				delete(d.clients, ip)
+				continue
+			}
+		} else {
+			c.idle = 0
+		}
+		values := map[string]float64{
+			Queries: float64(c.queries) * scale,
+			Domains: float64(len(c.domains)) * scale,
+		}
+		c.intervals++
+		for _, metric := range []string{Queries, Domains} {
+			v, b := values[metric], c.baselines[metric]
+			if c.intervals > warmup {
+				// Use a floor on the deviation so a perfectly stable client
+				// is not flagged for a handful of extra queries.
+				std := math.Max(math.Sqrt(b.variance), math.Max(math.Sqrt(b.mean), 1))
+				dev := (v - b.mean) / std
+				flagged := v >= minQueries && dev > threshold
+				if flagged && !b.flagged {
+					anomalies = append(anomalies, Anomaly{
+						Time:      now,
+						Client:    ip,
+						Name:      c.name,
+						Metric:    metric,
+						Value:     v,
+						Baseline:  b.mean,
+						Deviation: dev,
+						Domains:   topDomains(c.domains),
+					})
+				}
+				b.flagged = flagged
+			}
+			b.update(v)
+		}
+		c.queries = 0
+		c.domains = map[string]int{}
+	}
+	d.mu.Unlock()
+
+	if d.OnAnomaly != nil {
+		for _, a := range anomalies {
+			d.OnAnomaly(a)
+		}
+	}
+}
+
+func (d *Detector) interval() time.Duration {
+	if d.Interval <= 0 {
+		return time.Minute
+	}
+	return d.Interval
+}
+
+// topDomains returns the most queried domains.
+func topDomains(domains map[string]int) []string {
+	top := make([]string, 0, len(domains))
+	for domain := range domains {
+		top = append(top, domain)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if domains[top[i]] != domains[top[j]] {
+			return domains[top[i]] > domains[top[j]]
+		}
+		return top[i] < top[j]
+	})
+	if len(top) > maxEvidence {
+		top = top[:maxEvidence]
+	}
+	return top
+}
@@ -0,0 +1,52 @@
+package anomaly
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDetector(t *testing.T) {
+	var got []Anomaly
+	d := &Detector{
+		Warmup:    10,
+		OnAnomaly: func(a Anomaly) { got = append(got, a) },
+	}
+	now := time.Now()
+	interval := func(queries, domains int) {
+		for i := 0; i < queries; i++ {
+			d.Record("192.168.0.2", "laptop", fmt.Sprintf("d%d.example.com.", i%domains))
+		}
+		d.Record("192.168.0.3", "", "example.com.")
+		now = now.Add(time.Minute)
+		d.Check(now)
+	}
+
+	for i := 0; i < 20; i++ {
+		interval(40+i%5, 5)
+	}
+	if len(got) != 0 {
+		t.Fatalf("unexpected anomalies during baseline: %v", got)
+	}
+
+	// Beaconing to many unique sub-domains.
+	interval(600, 400)
+	if len(got) != 2 {
+		t.Fatalf("got %d anomalies, want 2: %v", len(got), got)
+	}
+	for i, metric := range []string{Queries, Domains} {
+		a := got[i]
+		if a.Client != "192.168.0.2" || a.Name != "laptop" || a.Metric != metric {
+			t.Errorf("anomaly %d = %+v", i, a)
+		}
+		if len(a.Domains) != maxEvidence {
+			t.Errorf("anomaly %d has %d domains, want %d", i, len(a.Domains), maxEvidence)
+		}
+	}
+
+	// Still deviating, not reported again.
+	interval(600, 400)
+	if len(got) != 2 {
+		t.Errorf("got %d anomalies, want 2", len(got))
+	}
+}
@@ -0,0 +1,198 @@
+// Package answerwatch periodically resolves a set of domains and reports when
+// their answers change, to catch hijacks of one's own domains or follow CDN
+// changes.
+package answerwatch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// DefaultInterval is the interval used when Interval is zero.
+const DefaultInterval = 5 * time.Minute
+
+// Change is reported when the answer for a watched domain changes.
+type Change struct {
+	Time time.Time `json:"time"`
+	Name string    `json:"name"`
+	Type string    `json:"type"`
+	Old  []string  `json:"old"`
+	New  []string  `json:"new"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("Answer changed for %s %s: [%s] -> [%s]",
+		c.Name, c.Type, strings.Join(c.Old, " "), strings.Join(c.New, " "))
+}
+
+// Watcher resolves Domains every Interval and calls OnChange when the set of
+// addresses returned for one of them differs from the previous resolution.
+// The first resolution of each domain sets the reference answer.
+type Watcher struct {
+	// Domains is the list of domains to watch.
+	Domains []string
+
+	// Interval is the time between two resolutions of the domains.
+	Interval time.Duration
+
+	// Upstream is the resolver used to resolve the domains.
+	Upstream resolver.Resolver
+
+	// OnChange is called when the answer for a domain changes.
+	OnChange func(Change)
+
+	// ErrorLog specifies an optional log function for resolution errors.
+	ErrorLog func(error)
+
+	last map[string][]string
+}
+
+// watchedTypes are the query types resolved for each domain.
+var watchedTypes = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+
+// Start resolves the domains until ctx is canceled.
+func (w *Watcher) Start(ctx context.Context) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		w.Check(ctx)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check resolves the domains once, reporting the changes since the previous
+// call.
+func (w *Watcher) Check(ctx context.Context) {
+	if w.last == nil {
+		w.last = map[string][]string{}
+	}
+	for _, domain := range w.Domains {
+		name := strings.TrimSuffix(strings.ToLower(domain), ".") + "."
+		for _, typ := range watchedTypes {
+			answer, err := w.resolve(ctx, name, typ)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				w.logErr(fmt.Errorf("answerwatch: %s %s: %v", name, typeString(typ), err))
+				continue
+			}
+			key := name + typeString(typ)
+			old, found := w.last[key]
+			w.last[key] = answer
+			if found && !equal(old, answer) && w.OnChange != nil {
+				w.OnChange(Change{
+					Time: time.Now(),
+					Name: name,
+					Type: typeString(typ),
+					Old:  old,
+					New:  answer,
+				})
+			}
+		}
+	}
+}
+
+// resolve returns the sorted addresses answered for name and typ. A name
+// that does not exist has no address.
+func (w *Watcher) resolve(ctx context.Context, name string, typ dnsmessage.Type) ([]string, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: qname, Type: typ, Class: dnsmessage.ClassINET})
+	payload, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	q, err := resolver.NewQuery(payload, net.IPv6loopback)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, _, err := w.Upstream.Resolve(ctx, q, buf)
+	if err != nil {
+		return nil, err
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	if h.RCode != dnsmessage.RCodeSuccess && h.RCode != dnsmessage.RCodeNameError {
+		return nil, fmt.Errorf("rcode %s", h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	answer := []string{}
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch rh.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil, err
+			}
+			answer = append(answer, net.IP(r.A[:]).String())
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return nil, err
+			}
+			answer = append(answer, net.IP(r.AAAA[:]).String())
+		default:
+			// CNAMEs are followed by the upstream.
+			if err := p.SkipAnswer(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(answer)
+	return answer, nil
+}
+
+func (w *Watcher) logErr(err error) {
+	if w.ErrorLog != nil {
+		w.ErrorLog(err)
+	}
+}
+
+func typeString(typ dnsmessage.Type) string {
+	return strings.TrimPrefix(typ.String(), "Type")
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
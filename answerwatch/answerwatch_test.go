@@ -0,0 +1,84 @@
+package answerwatch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// staticResolver answers A queries with ips, or fails if err is set.
+type staticResolver struct {
+	ips [][4]byte
+	err error
+}
+
+func (r *staticResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	if r.err != nil {
+		return -1, resolver.ResolveInfo{}, r.err
+	}
+	var p dnsmessage.Parser
+	h, _ := p.Start(q.Payload)
+	question, _ := p.Question()
+	b := dnsmessage.NewBuilder(buf[:0], dnsmessage.Header{ID: h.ID, Response: true})
+	_ = b.StartQuestions()
+	_ = b.Question(question)
+	_ = b.StartAnswers()
+	if question.Type == dnsmessage.TypeA {
+		for _, ip := range r.ips {
+			_ = b.AResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60},
+				dnsmessage.AResource{A: ip})
+		}
+	}
+	resp, err := b.Finish()
+	return len(resp), resolver.ResolveInfo{}, err
+}
+
+func TestWatcher_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers []*staticResolver
+		want    []Change
+	}{
+		{"Unchanged", []*staticResolver{
+			{ips: [][4]byte{{192, 0, 2, 1}, {192, 0, 2, 2}}},
+			{ips: [][4]byte{{192, 0, 2, 2}, {192, 0, 2, 1}}},
+		}, nil},
+		{"Changed", []*staticResolver{
+			{ips: [][4]byte{{192, 0, 2, 1}}},
+			{ips: [][4]byte{{198, 51, 100, 1}}},
+		}, []Change{{Name: "example.com.", Type: "A", Old: []string{"192.0.2.1"}, New: []string{"198.51.100.1"}}}},
+		{"Removed", []*staticResolver{
+			{ips: [][4]byte{{192, 0, 2, 1}}},
+			{},
+		}, []Change{{Name: "example.com.", Type: "A", Old: []string{"192.0.2.1"}, New: []string{}}}},
+		{"Error", []*staticResolver{
+			{ips: [][4]byte{{192, 0, 2, 1}}},
+			{err: errors.New("timeout")},
+			{ips: [][4]byte{{192, 0, 2, 1}}},
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Change
+			w := &Watcher{
+				Domains: []string{"Example.com"},
+				OnChange: func(c Change) {
+					c.Time = time.Time{}
+					got = append(got, c)
+				},
+			}
+			for _, r := range tt.answers {
+				w.Upstream = r
+				w.Check(context.Background())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"bufio"
 	"net"
 	"os"
+	"os/exec"
 	"strings"
 )
 
@@ -38,5 +39,32 @@ func Get() (Table, error) {
 		})
 	}
 
+	// IPv6 neighbors are not exposed in /proc, fallback on iproute2 if
+	// available so clients using SLAAC privacy addresses can be identified by
+	// their MAC too.
+	if data, err := exec.Command("ip", "-6", "neigh", "show").Output(); err == nil {
+		t = append(t, parseIPNeigh(string(data))...)
+	}
+
 	return t, nil
 }
+
+// parseIPNeigh parses the output of the ip neigh command:
+//
+//	2001:db8::1 dev br-lan lladdr 00:11:22:33:44:55 STALE
+func parseIPNeigh(data string) Table {
+	var t Table
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		for i := 1; i < len(fields)-1; i++ {
+			if fields[i] != "lladdr" {
+				continue
+			}
+			if ip, mac := net.ParseIP(fields[0]), parseMAC(fields[i+1]); ip != nil && mac != nil {
+				t = append(t, Entry{IP: ip, MAC: mac})
+			}
+			break
+		}
+	}
+	return t
+}
@@ -38,5 +38,32 @@ func Get() (Table, error) {
 		})
 	}
 
+	// IPv6 neighbors are listed by ndp.
+	if data, err := exec.Command("ndp", "-an").Output(); err == nil {
+		t = append(t, parseNDP(string(data))...)
+	}
+
 	return t, nil
 }
+
+// parseNDP parses the output of the ndp -an command:
+//
+//	Neighbor                 Linklayer Address  Netif Expire    St Flgs Prbs
+//	fe80::1%en0              0:11:22:33:44:55     en0 23h59m58s S  R
+func parseNDP(data string) Table {
+	var t Table
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := fields[0]
+		if idx := strings.IndexByte(ip, '%'); idx != -1 {
+			ip = ip[:idx]
+		}
+		if ip, mac := net.ParseIP(ip), parseMAC(fields[1]); ip != nil && mac != nil {
+			t = append(t, Entry{IP: ip, MAC: mac})
+		}
+	}
+	return t
+}
@@ -47,5 +47,18 @@ func Get() (Table, error) {
 		})
 	}
 
+	// IPv6 neighbors are listed by netsh.
+	if data, err := exec.Command("netsh", "interface", "ipv6", "show", "neighbors").Output(); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[1] == "00-00-00-00-00-00" {
+				continue
+			}
+			if ip, mac := net.ParseIP(fields[0]), parseMAC(fields[1]); ip != nil && mac != nil {
+				t = append(t, Entry{IP: ip, MAC: mac})
+			}
+		}
+	}
+
 	return t, nil
 }
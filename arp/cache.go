@@ -2,32 +2,132 @@ package arp
 
 import (
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+const (
+	// refreshInterval is the maximum age in seconds of the neighbor tables.
+	refreshInterval = 30
+
+	// missInterval is the minimum interval in seconds between two refreshes
+	// triggered by lookups of clients on a directly connected subnet not found
+	// in the tables.
+	missInterval = 5
+
+	// learnTTL is the time an IP to MAC association is kept once gone from
+	// the neighbor tables, so clients are still identified by their MAC when
+	// the kernel expires idle entries.
+	learnTTL = time.Hour
+)
+
 type cache struct {
 	lastUpdate int64
 	table      atomic.Value
+	nets       atomic.Value // []*net.IPNet of the directly connected subnets
+
+	mu      sync.Mutex
+	learned map[string]learnedEntry
+}
+
+type learnedEntry struct {
+	mac  net.HardwareAddr
+	seen time.Time
 }
 
 func (c *cache) get() Table {
+	c.refresh(refreshInterval)
+	t, _ := c.table.Load().(Table)
+	return t
+}
+
+// refresh reloads the tables in the background if they are older than
+// maxAge seconds.
+func (c *cache) refresh(maxAge int64) {
 	now := time.Now().UTC().Unix()
 	last := atomic.LoadInt64(&c.lastUpdate)
-	if now-last > 30 && atomic.SwapInt64(&c.lastUpdate, now) == last {
+	if now-last > maxAge && atomic.CompareAndSwapInt64(&c.lastUpdate, last, now) {
 		go func() {
 			t, _ := Get()
+			c.learn(t, time.Now())
 			c.table.Store(t)
+			c.nets.Store(connectedNets())
 		}()
 	}
-	t, _ := c.table.Load().(Table)
-	return t
+}
+
+// learn records the associations of t seen at now and forgets the ones not
+// seen for learnTTL.
+func (c *cache) learn(t Table, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.learned == nil {
+		c.learned = map[string]learnedEntry{}
+	}
+	for _, e := range t {
+		if e.IP != nil && e.MAC != nil {
+			c.learned[e.IP.String()] = learnedEntry{mac: e.MAC, seen: now}
+		}
+	}
+	for ip, e := range c.learned {
+		if now.Sub(e.seen) > learnTTL {
+			delete(c.learned, ip)
+		}
+	}
+}
+
+// searchLearned returns the MAC learned for ip, or nil.
+func (c *cache) searchLearned(ip net.IP, now time.Time) net.HardwareAddr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, found := c.learned[ip.String()]; found && now.Sub(e.seen) <= learnTTL {
+		return e.mac
+	}
+	return nil
+}
+
+func (c *cache) searchMAC(ip net.IP) net.HardwareAddr {
+	if mac := c.get().SearchMAC(ip); mac != nil {
+		return mac
+	}
+	if mac := c.searchLearned(ip, time.Now()); mac != nil {
+		return mac
+	}
+	// The client just sent a packet, the kernel likely resolved it already:
+	// reload the tables without waiting for the next refresh.
+	nets, _ := c.nets.Load().([]*net.IPNet)
+	for _, n := range nets {
+		if n.Contains(ip) {
+			c.refresh(missInterval)
+			break
+		}
+	}
+	return nil
+}
+
+// connectedNets returns the subnets of the addresses of the interfaces, other
+// than the loopback.
+func connectedNets() []*net.IPNet {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var nets []*net.IPNet
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && !n.IP.IsLoopback() {
+			nets = append(nets, n)
+		}
+	}
+	return nets
 }
 
 var global = &cache{}
 
+// SearchMAC returns the MAC address of the client with ip found in the
+// neighbor tables (ARP/NDP), or learned from them within the last hour.
 func SearchMAC(ip net.IP) net.HardwareAddr {
-	return global.get().SearchMAC(ip)
+	return global.searchMAC(ip)
 }
 
 func SearchIP(mac net.HardwareAddr) net.IP {
@@ -0,0 +1,32 @@
+package arp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCache_learn(t *testing.T) {
+	c := &cache{}
+	ip := net.ParseIP("192.168.1.10")
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	now := time.Now()
+	c.learn(Table{{IP: ip, MAC: mac}}, now)
+	tests := []struct {
+		name  string
+		table Table
+		at    time.Duration
+		want  net.HardwareAddr
+	}{
+		{"gone from table", nil, time.Minute, mac},
+		{"expired", nil, learnTTL + 2*time.Minute, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.learn(tt.table, now.Add(tt.at))
+			if got := c.searchLearned(ip, now.Add(tt.at)); got.String() != tt.want.String() {
+				t.Errorf("searchLearned() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/bench"
+	"github.com/nextdns/nextdns/config"
+)
+
+// benchCmd sends a synthetic query load to the daemon or another server and
+// reports its latency percentiles and error rate.
+func benchCmd(args []string) error {
+	fs := flag.NewFlagSet("nextdns bench", flag.ExitOnError)
+	server := fs.String("server", "", "Address of the server to load. Defaults to the configured listen address.")
+	qps := fs.Int("qps", 100, "Number of queries sent per second.")
+	duration := fs.Duration("duration", 10*time.Second, "Duration of the load.")
+	concurrency := fs.Int("concurrency", 100, "Maximum number of queries in flight. Queries due above are skipped.")
+	timeout := fs.Duration("timeout", 2*time.Second, "Maximum duration of a query before considering it timed out.")
+	protocols := fs.String("protocols", "udp", "Comma separated list of protocols the queries are spread over: udp, tcp.")
+	domains := fs.String("domains", strings.Join(compareDomains, ","), "Comma separated list of domains to query.")
+	domainsFile := fs.String("domains-file", "", "File listing the domains to query, one per line, instead of domains.")
+	types := fs.String("types", "A", "Comma separated list of query types the queries are spread over (i.e. A,AAAA).")
+	distribution := fs.String("distribution", bench.Uniform, "How domains are picked: uniform, or zipf to query the first ones much\n"+
+		"more often, like real traffic.")
+	unique := fs.Float64("unique", 0, "Ratio of queries (0 to 1) sent for a random sub-domain, to measure cache misses.")
+	_ = fs.Parse(args[1:])
+	if fs.NArg() > 0 {
+		return withCode(exitUsage, fmt.Errorf("%s: unexpected argument", fs.Arg(0)))
+	}
+
+	if *server == "" {
+		var c config.Config
+		c.Parse("nextdns bench", nil, true)
+		*server = compareListenAddr(c.Listen)
+	}
+	bc := bench.Config{
+		Server:       compareAddr(*server),
+		QPS:          *qps,
+		Duration:     *duration,
+		Concurrency:  *concurrency,
+		Timeout:      *timeout,
+		Distribution: *distribution,
+		Unique:       *unique,
+	}
+	for _, proto := range strings.Split(*protocols, ",") {
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto != "" {
+			bc.Protocols = append(bc.Protocols, proto)
+		}
+	}
+	for _, typ := range strings.Split(*types, ",") {
+		if typ = strings.ToUpper(strings.TrimSpace(typ)); typ == "" {
+			continue
+		}
+		t, found := traceTypes[typ]
+		if !found {
+			return withCode(exitUsage, fmt.Errorf("%s: unsupported query type", typ))
+		}
+		bc.Types = append(bc.Types, t)
+	}
+	if *domainsFile != "" {
+		names, err := benchReadDomains(*domainsFile)
+		if err != nil {
+			return err
+		}
+		bc.Names = names
+	} else {
+		for _, d := range strings.Split(*domains, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				bc.Names = append(bc.Names, d)
+			}
+		}
+	}
+	if err := bc.Validate(); err != nil {
+		return withCode(exitUsage, err)
+	}
+
+	// Interrupting the load still prints the report of the queries sent.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if !jsonOutput {
+		fmt.Printf("Sending %d queries/s to %s for %v over %s...\n", bc.QPS, bc.Server, bc.Duration, strings.Join(bc.Protocols, ", "))
+	}
+	r, err := bench.Run(ctx, bc)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return json.NewEncoder(os.Stdout).Encode(r)
+	}
+	fmt.Printf("\n%d queries sent in %v (%.0f queries/s)\n", r.Sent, r.Duration.Round(time.Millisecond), r.QPS)
+	fmt.Printf("  answered   %d\n", r.Answered)
+	fmt.Printf("  timeouts   %d\n", r.Timeouts)
+	fmt.Printf("  errors     %d\n", r.Errors)
+	fmt.Printf("  skipped    %d (concurrency limit reached)\n", r.Skipped)
+	if r.Truncated > 0 {
+		fmt.Printf("  truncated  %d\n", r.Truncated)
+	}
+	fmt.Printf("  error rate %.2f%% (timeouts, errors and SERVFAIL)\n", r.ErrorRate*100)
+	if r.Answered == 0 {
+		return nil
+	}
+	fmt.Println("\nResponse codes:")
+	var rcodes []string
+	for rcode := range r.RCodes {
+		rcodes = append(rcodes, rcode)
+	}
+	sort.Strings(rcodes)
+	for _, rcode := range rcodes {
+		fmt.Printf("  %-10s %d\n", rcode, r.RCodes[rcode])
+	}
+	fmt.Println("\nLatency:")
+	for _, p := range bench.Percentiles() {
+		fmt.Printf("  %-10s %.2fms\n", p, float64(r.Latency[p])/1000)
+	}
+	return nil
+}
+
+// benchReadDomains returns the domains listed in the file at path, ignoring
+// empty lines and # comments.
+func benchReadDomains(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var names []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, strings.Fields(line)[0])
+	}
+	return names, s.Err()
+}
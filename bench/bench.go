@@ -0,0 +1,390 @@
+// Package bench generates a synthetic DNS query load against a server and
+// measures its latency and error rate, to size the host running the proxy
+// before deploying it.
+package bench
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// Distributions of the queried names.
+const (
+	// Uniform queries all the names equally.
+	Uniform = "uniform"
+	// Zipf queries the first names much more often than the last ones, like
+	// the traffic of real networks.
+	Zipf = "zipf"
+)
+
+// Config defines the load sent to a server.
+type Config struct {
+	// Server is the address of the server, as IP:PORT.
+	Server string
+
+	// Protocols are the protocols the queries are spread over: udp or tcp.
+	// Default is udp.
+	Protocols []string
+
+	// QPS is the number of queries sent per second.
+	QPS int
+
+	// Duration is the duration of the load.
+	Duration time.Duration
+
+	// Concurrency is the maximum number of queries in flight. The queries
+	// due while it is reached are not sent and counted as skipped, so an
+	// overloaded server does not slow down the load. Default is 100.
+	Concurrency int
+
+	// Timeout is the maximum duration of a query before it is counted as
+	// timed out. Default is 2s.
+	Timeout time.Duration
+
+	// Names are the queried names.
+	Names []string
+
+	// Types are the query types the queries are spread over. Default is A.
+	Types []dnsmessage.Type
+
+	// Distribution is how the names are picked: Uniform or Zipf. Default is
+	// Uniform.
+	Distribution string
+
+	// Unique is the ratio of queries (0 to 1) sent for a random sub-domain of
+	// the names, so they miss the caches.
+	Unique float64
+}
+
+// Report is the outcome of a load.
+type Report struct {
+	Server    string           `json:"server"`
+	Duration  time.Duration    `json:"duration"`
+	Sent      int              `json:"sent"`
+	Answered  int              `json:"answered"`
+	Timeouts  int              `json:"timeouts"`
+	Errors    int              `json:"errors"`
+	Skipped   int              `json:"skipped"`
+	Truncated int              `json:"truncated"`
+	RCodes    map[string]int   `json:"rcodes"`
+	Protocols map[string]int   `json:"protocols"`
+	QPS       float64          `json:"qps"`
+	ErrorRate float64          `json:"error_rate"`
+	Latency   map[string]int64 `json:"latency_us"`
+
+	latencies []time.Duration
+}
+
+// maxQPS is the maximum rate of queries of a load.
+const maxQPS = 1000000
+
+// percentiles are the latency percentiles of Report.Latency.
+var percentiles = []float64{50, 90, 95, 99, 99.9, 100}
+
+// Validate checks c is a valid load and sets its defaults.
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Server); err != nil {
+		return fmt.Errorf("%s: invalid server address: %v", c.Server, err)
+	}
+	if len(c.Protocols) == 0 {
+		c.Protocols = []string{"udp"}
+	}
+	for _, proto := range c.Protocols {
+		if proto != "udp" && proto != "tcp" {
+			return fmt.Errorf("%s: unsupported protocol", proto)
+		}
+	}
+	if c.QPS <= 0 || c.QPS > maxQPS {
+		return fmt.Errorf("%d: invalid qps, must be between 1 and %d", c.QPS, maxQPS)
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("%v: invalid duration", c.Duration)
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = 100
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 2 * time.Second
+	}
+	if len(c.Names) == 0 {
+		return errors.New("no name to query")
+	}
+	for _, name := range c.Names {
+		if _, err := dnsmessage.NewName(fqdn(name)); err != nil {
+			return fmt.Errorf("%s: invalid name", name)
+		}
+	}
+	if len(c.Types) == 0 {
+		c.Types = []dnsmessage.Type{dnsmessage.TypeA}
+	}
+	switch c.Distribution {
+	case "":
+		c.Distribution = Uniform
+	case Uniform, Zipf:
+	default:
+		return fmt.Errorf("%s: unsupported distribution", c.Distribution)
+	}
+	if c.Unique < 0 || c.Unique > 1 {
+		return fmt.Errorf("%v: invalid unique ratio, must be between 0 and 1", c.Unique)
+	}
+	return nil
+}
+
+func fqdn(name string) string {
+	if name == "" || name[len(name)-1] != '.' {
+		return name + "."
+	}
+	return name
+}
+
+// picker returns a function picking the index of the next queried name
+// according to c.Distribution.
+func (c *Config) picker(rnd *rand.Rand) func() int {
+	n := len(c.Names)
+	if c.Distribution == Zipf && n > 1 {
+		z := rand.NewZipf(rnd, 1.1, 1, uint64(n-1))
+		return func() int { return int(z.Uint64()) }
+	}
+	return func() int { return rnd.Intn(n) }
+}
+
+// Run sends the load defined by c until c.Duration elapsed or ctx is
+// cancelled, and returns its report.
+func Run(ctx context.Context, c Config) (Report, error) {
+	if err := c.Validate(); err != nil {
+		return Report{}, err
+	}
+	r := Report{
+		Server:    c.Server,
+		RCodes:    map[string]int{},
+		Protocols: map[string]int{},
+		Latency:   map[string]int64{},
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, c.Concurrency)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	pick := c.picker(rnd)
+	interval := time.Second / time.Duration(c.QPS)
+	total := int(c.Duration / interval)
+
+	start := time.Now()
+	timer := time.NewTimer(0)
+	<-timer.C
+	defer timer.Stop()
+loop:
+	for i := 0; i < total; i++ {
+		// Queries are scheduled from the start, not from the previous one,
+		// so the rate is kept when sending takes time.
+		if d := time.Until(start.Add(time.Duration(i) * interval)); d > 0 {
+			timer.Reset(d)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				break loop
+			}
+		} else if ctx.Err() != nil {
+			break loop
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			mu.Lock()
+			r.Skipped++
+			mu.Unlock()
+			continue
+		}
+		name := fqdn(c.Names[pick()])
+		if c.Unique > 0 && rnd.Float64() < c.Unique {
+			name = strconv.FormatUint(rnd.Uint64(), 36) + "." + name
+		}
+		typ := c.Types[rnd.Intn(len(c.Types))]
+		proto := c.Protocols[rnd.Intn(len(c.Protocols))]
+		id := uint16(rnd.Uint32())
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			qctx, cancel := context.WithTimeout(ctx, c.Timeout)
+			rcode, truncated, d, err := query(qctx, proto, c.Server, name, typ, id)
+			cancel()
+			mu.Lock()
+			r.record(proto, rcode, truncated, d, err)
+			mu.Unlock()
+		}()
+	}
+	// The rate is measured over the duration of the load, the queries
+	// in flight at its end being waited for.
+	r.Duration = time.Since(start)
+	if ctx.Err() == nil {
+		r.Duration = c.Duration
+	}
+	wg.Wait()
+	r.summarize()
+	return r, nil
+}
+
+// record records the outcome of a query.
+func (r *Report) record(proto string, rcode dnsmessage.RCode, truncated bool, d time.Duration, err error) {
+	r.Sent++
+	r.Protocols[proto]++
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+			r.Timeouts++
+		} else {
+			r.Errors++
+		}
+		return
+	}
+	r.Answered++
+	r.RCodes[rcodeName(rcode)]++
+	if truncated {
+		r.Truncated++
+	}
+	r.latencies = append(r.latencies, d)
+}
+
+// summarize computes the rates and latency percentiles of r.
+func (r *Report) summarize() {
+	if r.Duration > 0 {
+		r.QPS = float64(r.Sent) / r.Duration.Seconds()
+	}
+	if r.Sent > 0 {
+		failed := r.Timeouts + r.Errors + r.RCodes["SERVFAIL"]
+		r.ErrorRate = float64(failed) / float64(r.Sent)
+	}
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	for _, p := range percentiles {
+		r.Latency[percentileName(p)] = percentile(r.latencies, p).Microseconds()
+	}
+}
+
+// Percentiles returns the names of the latency percentiles of the report, in
+// increasing order (i.e. p50).
+func Percentiles() []string {
+	names := make([]string, 0, len(percentiles))
+	for _, p := range percentiles {
+		names = append(names, percentileName(p))
+	}
+	return names
+}
+
+func percentileName(p float64) string {
+	if p == 100 {
+		return "max"
+	}
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// percentile returns the p percentile of the sorted durations d, using the
+// nearest rank method.
+func percentile(d []time.Duration, p float64) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(d))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(d) {
+		rank = len(d) - 1
+	}
+	return d[rank]
+}
+
+func rcodeName(rcode dnsmessage.RCode) string {
+	switch rcode {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
+
+// query sends a query for name and typ with the given id to server over
+// proto, and returns the rcode of the response and its latency.
+func query(ctx context.Context, proto, server, name string, typ dnsmessage.Type, id uint16) (rcode dnsmessage.RCode, truncated bool, d time.Duration, err error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{ID: id, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: qname, Type: typ, Class: dnsmessage.ClassINET})
+	q, err := b.Finish()
+	if err != nil {
+		return 0, false, 0, err
+	}
+
+	start := time.Now()
+	var dialer net.Dialer
+	c, err := dialer.DialContext(ctx, proto, server)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	defer c.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.SetDeadline(deadline)
+	}
+	buf := make([]byte, 65535)
+	var n int
+	if proto == "tcp" {
+		msg := make([]byte, 2, len(q)+2)
+		binary.BigEndian.PutUint16(msg, uint16(len(q)))
+		if _, err = c.Write(append(msg, q...)); err != nil {
+			return 0, false, 0, err
+		}
+		if _, err = io.ReadFull(c, buf[:2]); err != nil {
+			return 0, false, 0, err
+		}
+		n = int(binary.BigEndian.Uint16(buf))
+		if _, err = io.ReadFull(c, buf[:n]); err != nil {
+			return 0, false, 0, err
+		}
+	} else {
+		if _, err = c.Write(q); err != nil {
+			return 0, false, 0, err
+		}
+		for {
+			if n, err = c.Read(buf); err != nil {
+				return 0, false, 0, err
+			}
+			// Ignore the late responses to other queries.
+			if n >= 2 && binary.BigEndian.Uint16(buf) == id {
+				break
+			}
+		}
+	}
+	d = time.Since(start)
+	var p dnsmessage.Parser
+	h, err := p.Start(buf[:n])
+	if err != nil {
+		return 0, false, d, err
+	}
+	if h.ID != id || !h.Response {
+		return 0, false, d, errors.New("mismatched response")
+	}
+	return h.RCode, h.Truncated, d, nil
+}
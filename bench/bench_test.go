@@ -0,0 +1,118 @@
+package bench
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+func TestPercentile(t *testing.T) {
+	var d []time.Duration
+	for i := 1; i <= 100; i++ {
+		d = append(d, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99.9, 100 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+		{0, time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(d, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile(nil) = %v, want 0", got)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       Config
+		wantErr bool
+	}{
+		{"defaults", Config{Server: "127.0.0.1:53", QPS: 10, Duration: time.Second, Names: []string{"example.com"}}, false},
+		{"no port", Config{Server: "127.0.0.1", QPS: 10, Duration: time.Second, Names: []string{"example.com"}}, true},
+		{"protocol", Config{Server: "127.0.0.1:53", Protocols: []string{"dot"}, QPS: 10, Duration: time.Second, Names: []string{"example.com"}}, true},
+		{"no qps", Config{Server: "127.0.0.1:53", Duration: time.Second, Names: []string{"example.com"}}, true},
+		{"no names", Config{Server: "127.0.0.1:53", QPS: 10, Duration: time.Second}, true},
+		{"distribution", Config{Server: "127.0.0.1:53", QPS: 10, Duration: time.Second, Names: []string{"example.com"}, Distribution: "normal"}, true},
+		{"unique", Config{Server: "127.0.0.1:53", QPS: 10, Duration: time.Second, Names: []string{"example.com"}, Unique: 2}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// serveUDP answers the queries received on c with NXDOMAIN for the names
+// starting with nx and NOERROR for the others.
+func serveUDP(c net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil {
+			continue
+		}
+		q, err := p.Question()
+		if err != nil {
+			continue
+		}
+		h.Response = true
+		if name := q.Name.String(); len(name) > 2 && name[:2] == "nx" {
+			h.RCode = dnsmessage.RCodeNameError
+		}
+		b := dnsmessage.NewBuilder(nil, h)
+		_ = b.StartQuestions()
+		_ = b.Question(q)
+		resp, _ := b.Finish()
+		_, _ = c.WriteTo(resp, addr)
+	}
+}
+
+func TestRun(t *testing.T) {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	go serveUDP(c)
+
+	r, err := Run(context.Background(), Config{
+		Server:       c.LocalAddr().String(),
+		QPS:          200,
+		Duration:     250 * time.Millisecond,
+		Names:        []string{"example.com", "nx.example.com"},
+		Types:        []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA},
+		Distribution: Zipf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Sent != 50 || r.Answered+r.Timeouts+r.Errors != r.Sent {
+		t.Errorf("sent = %d, answered = %d, timeouts = %d, errors = %d", r.Sent, r.Answered, r.Timeouts, r.Errors)
+	}
+	if r.RCodes["NOERROR"]+r.RCodes["NXDOMAIN"] != r.Answered {
+		t.Errorf("rcodes = %v, answered %d", r.RCodes, r.Answered)
+	}
+	if r.Answered > 0 && (r.Latency["p50"] <= 0 || r.Latency["max"] < r.Latency["p50"]) {
+		t.Errorf("latency = %v", r.Latency)
+	}
+}
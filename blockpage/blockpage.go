@@ -0,0 +1,406 @@
+// Package blockpage serves a page explaining why a domain is blocked to the
+// browsers sent to it by the answers to blocked queries, with an optional
+// button allowing the domain for a while.
+package blockpage
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// ttl is the TTL of the answers pointing to the block page, kept low so
+// allowed domains are reachable again quickly.
+const ttl = 60
+
+// maxUpstreamBlocked is the maximum number of domains blocked upstream
+// remembered to explain their block.
+const maxUpstreamBlocked = 1000
+
+// upstreamBlockedTTL is how long a domain blocked upstream is remembered.
+const upstreamBlockedTTL = 10 * time.Minute
+
+// maxPasswordFailures is the number of invalid passwords a client can enter
+// within passwordFailureWindow before its attempts are refused.
+const maxPasswordFailures = 5
+
+// passwordFailureWindow is how long the invalid passwords of a client are
+// counted.
+const passwordFailureWindow = 15 * time.Minute
+
+// maxPasswordClients is the maximum number of clients whose invalid passwords
+// are tracked.
+const maxPasswordClients = 1000
+
+// Server answers the queries for domains blocked upstream with IP and serves
+// the block page on the HTTP and HTTPS ports of IP. Domains blocked locally
+// must be answered with IP by the filter.
+type Server struct {
+	// IP is the address the blocked domains are answered with. Can be an IPv4
+	// or IPv6.
+	IP net.IP
+
+	// Password specifies the password to enter on the page to allow a
+	// blocked domain. The allow button is not shown if empty.
+	Password string
+
+	// AllowDuration specifies how long a domain is allowed from the page.
+	// Default is one hour.
+	AllowDuration time.Duration
+
+	// Reason returns why host is blocked locally, or an empty string if it is
+	// not.
+	Reason func(host string) string
+
+	// Allow allows the domain blocked locally host for d on behalf of client.
+	Allow func(host string, client net.IP, d time.Duration)
+
+	// Upstream is the resolver used for all queries. Its answers made of
+	// unspecified addresses (0.0.0.0 or ::) only, the way NextDNS answers
+	// blocked domains, are replaced by IP.
+	Upstream resolver.Resolver
+
+	// ErrorLog specifies an optional log function for errors.
+	ErrorLog func(error)
+
+	mu              sync.Mutex
+	upstreamBlocked map[string]time.Time
+	failures        map[string]*passwordFailures
+}
+
+// passwordFailures counts the invalid passwords of a client since start.
+type passwordFailures struct {
+	start time.Time
+	count int
+}
+
+// Resolve implements resolver.Resolver interface.
+func (s *Server) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	n, i, err = s.Upstream.Resolve(ctx, q, buf)
+	if err != nil || (q.Type != "A" && q.Type != "AAAA") {
+		return n, i, err
+	}
+	if rn, blocked := s.rewrite(buf, n); blocked {
+		s.mu.Lock()
+		if s.upstreamBlocked == nil || len(s.upstreamBlocked) >= maxUpstreamBlocked {
+			s.upstreamBlocked = map[string]time.Time{}
+		}
+		s.upstreamBlocked[strings.ToLower(strings.TrimSuffix(q.Name, "."))] = time.Now()
+		s.mu.Unlock()
+		n = rn
+	}
+	return n, i, err
+}
+
+// rewrite replaces the answers of the response in buf[:n] with IP if they are
+// all unspecified addresses. It returns the new size of the response and true
+// if it was rewritten.
+func (s *Server) rewrite(buf []byte, n int) (int, bool) {
+	var m dnsmessage.Message
+	if m.Unpack(buf[:n]) != nil || len(m.Answers) == 0 {
+		return n, false
+	}
+	for _, rr := range m.Answers {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			if body.A != [4]byte{} {
+				return n, false
+			}
+		case *dnsmessage.AAAAResource:
+			if body.AAAA != [16]byte{} {
+				return n, false
+			}
+		default:
+			return n, false
+		}
+	}
+	answers := m.Answers[:0]
+	ip4 := s.IP.To4()
+	for _, rr := range m.Answers {
+		rr.Header.TTL = ttl
+		switch {
+		case rr.Header.Type == dnsmessage.TypeA && ip4 != nil:
+			var a [4]byte
+			copy(a[:], ip4)
+			rr.Body = &dnsmessage.AResource{A: a}
+		case rr.Header.Type == dnsmessage.TypeAAAA && ip4 == nil:
+			var aaaa [16]byte
+			copy(aaaa[:], s.IP.To16())
+			rr.Body = &dnsmessage.AAAAResource{AAAA: aaaa}
+		default:
+			// Answered with no record for the other family.
+			continue
+		}
+		answers = append(answers, rr)
+	}
+	m.Answers = answers
+	b, err := m.AppendPack(buf[:0])
+	if err != nil || len(b) > len(buf) {
+		return n, false
+	}
+	return len(b), true
+}
+
+// blockedUpstream returns true if host was recently answered as blocked by
+// Upstream.
+func (s *Server) blockedUpstream(host string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, found := s.upstreamBlocked[host]
+	return found && time.Since(t) < upstreamBlockedTTL
+}
+
+// locked returns true if client entered too many invalid passwords recently.
+func (s *Server) locked(client string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.failures[client]
+	return f != nil && f.count >= maxPasswordFailures && time.Since(f.start) < passwordFailureWindow
+}
+
+// fail records an invalid password entered by client. It returns false if the
+// client could not be tracked, in which case its attempt must be refused.
+func (s *Server) fail(client string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	f := s.failures[client]
+	if f == nil || now.Sub(f.start) >= passwordFailureWindow {
+		if s.failures == nil {
+			s.failures = map[string]*passwordFailures{}
+		}
+		if f == nil && len(s.failures) >= maxPasswordClients {
+			for c, f := range s.failures {
+				if now.Sub(f.start) >= passwordFailureWindow {
+					delete(s.failures, c)
+				}
+			}
+			if len(s.failures) >= maxPasswordClients {
+				return false
+			}
+		}
+		f = &passwordFailures{start: now}
+		s.failures[client] = f
+	}
+	f.count++
+	return true
+}
+
+type pageData struct {
+	Host      string
+	Reason    string
+	CanAllow  bool
+	HTTPSOnly bool
+	Duration  string
+	Allowed   bool
+	Until     string
+	BadPasswd bool
+	Locked    bool
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Blocked: {{.Host}}</title>
+<style>
+body{font-family:sans-serif;margin:3em auto;max-width:600px;padding:1em;color:#222}
+h1{font-size:1.4em}.err{color:#c00}code{background:#f4f4f4;padding:0 .2em}
+</style>
+</head>
+<body>
+{{if .Allowed}}
+<h1><code>{{.Host}}</code> is allowed</h1>
+<p>The domain is allowed until {{.Until}}. It may take a minute before it can be reached.</p>
+{{else}}
+<h1><code>{{.Host}}</code> is blocked</h1>
+<p>{{.Reason}}</p>
+{{if .CanAllow}}
+{{if .HTTPSOnly}}
+<p><a href="https://{{.Host}}/">Open this page over HTTPS</a> to allow the domain.</p>
+{{else}}
+<form method="post">
+{{if .BadPasswd}}<p class="err">Invalid password.</p>{{end}}
+{{if .Locked}}<p class="err">Too many invalid passwords, try again later.</p>{{end}}
+<input type="password" name="password" placeholder="Password" required>
+<button type="submit">Allow for {{.Duration}}</button>
+</form>
+{{end}}
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	d := pageData{Host: host}
+	var local bool
+	if s.Reason != nil {
+		d.Reason = s.Reason(host)
+		local = d.Reason != ""
+	}
+	switch {
+	case local:
+		d.Reason = "This domain is " + d.Reason + " on this network."
+	case s.blockedUpstream(host):
+		d.Reason = "This domain is blocked by the NextDNS configuration of this network."
+	default:
+		d.Reason = "This domain is blocked on this network."
+	}
+	d.CanAllow = local && s.Password != "" && s.Allow != nil
+	// The password is only accepted over HTTPS so it is not sent in clear
+	// on the network.
+	d.HTTPSOnly = r.TLS == nil
+	var client string
+	if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = h
+	}
+	status := http.StatusForbidden
+	duration := s.AllowDuration
+	if duration <= 0 {
+		duration = time.Hour
+	}
+	d.Duration = duration.String()
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		if !d.CanAllow || d.HTTPSOnly {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if s.locked(client) {
+			d.Locked = true
+			status = http.StatusTooManyRequests
+			break
+		}
+		// The password is sent with the form rather than with HTTP
+		// authentication so other sites cannot post it on the user's behalf.
+		if subtle.ConstantTimeCompare([]byte(r.PostFormValue("password")), []byte(s.Password)) != 1 {
+			if !s.fail(client) {
+				d.Locked = true
+				status = http.StatusTooManyRequests
+				break
+			}
+			d.BadPasswd = true
+			break
+		}
+		s.Allow(host, net.ParseIP(client), duration)
+		d.Allowed = true
+		d.Until = time.Now().Add(duration).Format("15:04")
+		status = http.StatusOK
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	if err := pageTemplate.Execute(w, d); err != nil {
+		s.logErr(err)
+	}
+}
+
+// ListenAndServe serves the block page on the ports 80 and 443 of IP until
+// ctx is cancelled. Over HTTPS, a self-signed certificate is used: browsers
+// show the page once the certificate warning is accepted.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if s.IP == nil {
+		return errors.New("missing block page address")
+	}
+	cert, err := selfSignedCert()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(s.IP.String(), "80"))
+	if err != nil {
+		return err
+	}
+	tl, err := net.Listen("tcp", net.JoinHostPort(s.IP.String(), "443"))
+	if err != nil {
+		l.Close()
+		return err
+	}
+	tl = tls.NewListener(tl, &tls.Config{Certificates: []tls.Certificate{cert}})
+	srv := &http.Server{
+		Handler:      s,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  time.Minute,
+		// Browsers rejecting the certificate would flood the logs with
+		// handshake errors.
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	errs := make(chan error, 2)
+	go func() { errs <- srv.Serve(l) }()
+	go func() { errs <- srv.Serve(tl) }()
+	if err = <-errs; err == http.ErrServerClosed {
+		err = ctx.Err()
+	}
+	_ = srv.Close()
+	<-errs
+	return err
+}
+
+// selfSignedCert returns a certificate for the block page, valid for any
+// name as browsers reject it anyway.
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "NextDNS block page"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+func (s *Server) logErr(err error) {
+	if err != nil && s.ErrorLog != nil {
+		s.ErrorLog(err)
+	}
+}
@@ -0,0 +1,154 @@
+package blockpage
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// staticResolver answers A queries with ip.
+type staticResolver struct {
+	ip [4]byte
+}
+
+func (r staticResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	var p dnsmessage.Parser
+	h, _ := p.Start(q.Payload)
+	q1, _ := p.Question()
+	h.Response = true
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	_ = b.StartAnswers()
+	_ = b.AResource(dnsmessage.ResourceHeader{Name: q1.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300}, dnsmessage.AResource{A: r.ip})
+	out, err := b.Finish()
+	return len(out), resolver.ResolveInfo{}, err
+}
+
+func TestServer_Resolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      [4]byte
+		want    [4]byte
+		blocked bool
+	}{
+		{"blocked.example.com.", [4]byte{}, [4]byte{192, 168, 1, 1}, true},
+		{"www.example.com.", [4]byte{93, 184, 216, 34}, [4]byte{93, 184, 216, 34}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{IP: net.IPv4(192, 168, 1, 1), Upstream: staticResolver{tt.ip}}
+			bld := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+			_ = bld.StartQuestions()
+			_ = bld.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(tt.name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+			q, _ := bld.Finish()
+			buf := make([]byte, 512)
+			n, _, err := s.Resolve(context.Background(), resolver.Query{Name: tt.name, Type: "A", Payload: q}, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m dnsmessage.Message
+			if err := m.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Answers) != 1 {
+				t.Fatalf("got %d answers, want 1", len(m.Answers))
+			}
+			if got := m.Answers[0].Body.(*dnsmessage.AResource).A; got != tt.want {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+			if got := s.blockedUpstream(strings.TrimSuffix(tt.name, ".")); got != tt.blocked {
+				t.Errorf("blockedUpstream = %v, want %v", got, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestServer_ServeHTTP(t *testing.T) {
+	var allowed string
+	s := &Server{
+		Password: "secret",
+		Reason: func(host string) string {
+			if host == "ads.example.com" {
+				return "blocked by rule ads.example.com."
+			}
+			return ""
+		},
+		Allow: func(host string, client net.IP, d time.Duration) {
+			allowed = host
+		},
+	}
+	tests := []struct {
+		name     string
+		scheme   string
+		host     string
+		password string
+		status   int
+		want     string
+		allowed  string
+	}{
+		{"Page", "https", "ads.example.com", "", http.StatusForbidden, "Allow for 1h0m0s", ""},
+		{"PageHTTP", "http", "ads.example.com", "", http.StatusForbidden, "Open this page over HTTPS", ""},
+		{"Upstream", "https", "tracker.example.com", "", http.StatusForbidden, "This domain is blocked on this network.", ""},
+		{"BadPassword", "https", "ads.example.com", "wrong", http.StatusForbidden, "Invalid password.", ""},
+		{"Allow", "https", "ads.example.com", "secret", http.StatusOK, "is allowed", "ads.example.com"},
+		{"AllowHTTP", "http", "ads.example.com", "secret", http.StatusForbidden, "Forbidden", ""},
+		{"AllowNotLocal", "https", "tracker.example.com", "secret", http.StatusForbidden, "Forbidden", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed = ""
+			r := httptest.NewRequest("GET", tt.scheme+"://"+tt.host+"/ads.js", nil)
+			if tt.password != "" {
+				r = postPassword(tt.scheme+"://"+tt.host+"/ads.js", tt.password)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) || allowed != tt.allowed {
+				t.Errorf("got %d %q (allowed %q), want %d with %q (allowed %q)", w.Code, w.Body.String(), allowed, tt.status, tt.want, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestServer_ServeHTTP_Locked(t *testing.T) {
+	var allowed int
+	s := &Server{
+		Password: "secret",
+		Reason:   func(host string) string { return "blocked by rule ads.example.com." },
+		Allow:    func(host string, client net.IP, d time.Duration) { allowed++ },
+	}
+	serve := func(password, client string) int {
+		r := postPassword("https://ads.example.com/", password)
+		r.RemoteAddr = client + ":1234"
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+	for i := 0; i < maxPasswordFailures; i++ {
+		if code := serve("wrong", "192.168.1.10"); code != http.StatusForbidden {
+			t.Fatalf("attempt %d: got %d, want %d", i, code, http.StatusForbidden)
+		}
+	}
+	if code := serve("secret", "192.168.1.10"); code != http.StatusTooManyRequests || allowed != 0 {
+		t.Errorf("locked client: got %d (%d allowed), want %d", code, allowed, http.StatusTooManyRequests)
+	}
+	if code := serve("secret", "192.168.1.11"); code != http.StatusOK || allowed != 1 {
+		t.Errorf("other client: got %d (%d allowed), want %d", code, allowed, http.StatusOK)
+	}
+}
+
+func postPassword(target, password string) *http.Request {
+	form := url.Values{"password": {password}}
+	r := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/events"
+	"github.com/nextdns/nextdns/host"
+	"github.com/nextdns/nextdns/host/service"
+)
+
+// fetchBundle reads the configuration bundle at src, a local path or an
+// HTTP(S) URL.
+func fetchBundle(ctx context.Context, src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return ioutil.ReadFile(src)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &http.Client{Timeout: 30 * time.Second}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", res.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+// configLines returns the settings of c in a stable order so two
+// configurations can be compared.
+func configLines(c *config.Config) string {
+	var b bytes.Buffer
+	_ = c.Write(&b)
+	lines := strings.Split(b.String(), "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// applyBundle fetches the bundle of c and applies it if it is validly signed
+// and changes the configuration. It returns true if the configuration was
+// updated.
+func applyBundle(ctx context.Context, c config.Config) (bool, error) {
+	b, err := fetchBundle(ctx, c.BundleURL)
+	if err != nil {
+		return false, err
+	}
+	nc := c
+	if err := nc.ApplyBundle(b); err != nil {
+		return false, err
+	}
+	if configLines(&nc) == configLines(&c) {
+		return false, nil
+	}
+	return true, nc.Save()
+}
+
+// setupBundle applies the bundle of c at startup and every BundleRefresh, and
+// restarts the service when the configuration is updated. The c must not have
+// been modified since it was loaded so it can be compared with the bundle.
+func setupBundle(p *proxySvc, c config.Config) {
+	p.OnInit = append(p.OnInit, func(ctx context.Context) {
+		for {
+			updated, err := applyBundle(ctx, c)
+			if err != nil {
+				p.log.Errorf("Configuration bundle: %v", err)
+				p.events.Emit(events.Error, events.Data{"error": err.Error(), "op": "bundle"})
+			} else if updated {
+				p.log.Info("Configuration updated from bundle")
+				p.events.Emit(events.ConfigUpdated, events.Data{"source": c.BundleURL})
+				if service.CurrentRunMode() != service.RunModeService {
+					p.log.Warning("Not running as a service, restart nextdns to use the new configuration")
+					return
+				}
+				s, err := host.NewService(service.Config{Name: "nextdns"})
+				if err == nil {
+					err = s.Restart()
+				}
+				if err != nil {
+					p.log.Errorf("Restarting after configuration update: %v", err)
+				}
+				return
+			}
+			if c.BundleRefresh <= 0 {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(c.BundleRefresh):
+			}
+		}
+	})
+}
@@ -0,0 +1,53 @@
+// Package cache caches positive DNS answers in a pluggable backend: in
+// process, or shared by several instances in Redis or memcached.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cache stores values for a limited time. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	// Get returns the value of key, or nil if not found or expired.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set stores value for key for ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// New returns the Cache defined by backend: memory, redis://[:PASSWORD@]HOST:PORT[/DB]
+// or memcached://HOST:PORT.
+func New(backend string) (Cache, error) {
+	if backend == "memory" {
+		return &Memory{}, nil
+	}
+	u, err := url.Parse(backend)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%s: invalid cache backend", backend)
+	}
+	switch u.Scheme {
+	case "redis":
+		r := &Redis{Addr: u.Host}
+		if u.User != nil {
+			r.Password, _ = u.User.Password()
+			if r.Password == "" {
+				r.Password = u.User.Username()
+			}
+		}
+		if db := strings.Trim(u.Path, "/"); db != "" {
+			if r.DB, err = strconv.Atoi(db); err != nil {
+				return nil, fmt.Errorf("%s: invalid redis database", db)
+			}
+		}
+		return r, nil
+	case "memcached":
+		return &Memcached{Addr: u.Host}, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported cache backend", u.Scheme)
+}
@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+func query(t *testing.T, name string, peer string) resolver.Query {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := resolver.NewQuery(payload, net.ParseIP(peer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestResolver(t *testing.T) {
+	for _, backend := range []string{"memory", "redis"} {
+		t.Run(backend, func(t *testing.T) {
+			var c Cache = &Memory{}
+			if backend == "redis" {
+				l := fakeRedis(t)
+				defer l.Close()
+				c = &Redis{Addr: l.Addr().String()}
+			}
+			upstreamCalls := 0
+			now := time.Now()
+			r := &Resolver{
+				Cache: c,
+				Upstream: resolver.ResolverFunc(func(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+					upstreamCalls++
+					var m dnsmessage.Message
+					if err := m.Unpack(q.Payload); err != nil {
+						return 0, resolver.ResolveInfo{}, err
+					}
+					m.Header.Response = true
+					if strings.HasPrefix(q.Name, "nx.") {
+						m.Header.RCode = dnsmessage.RCodeNameError
+					} else {
+						m.Answers = []dnsmessage.Resource{{
+							Header: dnsmessage.ResourceHeader{Name: m.Questions[0].Name, Class: dnsmessage.ClassINET, TTL: 300},
+							Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+						}}
+					}
+					b, err := m.AppendPack(buf[:0])
+					return len(b), resolver.ResolveInfo{Transport: "HTTP/2.0"}, err
+				}),
+				ClientKey: func(q resolver.Query) string {
+					return q.PeerIP.String()
+				},
+				now: func() time.Time { return now },
+			}
+			tests := []struct {
+				name      string
+				peer      string
+				elapsed   time.Duration
+				wantCalls int
+				wantTTL   uint32
+			}{
+				{"example.com.", "10.0.0.1", 0, 1, 300},
+				{"Example.COM.", "10.0.0.1", 100 * time.Second, 1, 200},
+				{"example.com.", "10.0.0.2", 0, 2, 300},
+				{"nx.example.com.", "10.0.0.1", 0, 3, 0},
+				{"nx.example.com.", "10.0.0.1", 0, 4, 0},
+			}
+			for _, tt := range tests {
+				now = now.Add(tt.elapsed)
+				buf := make([]byte, 512)
+				n, _, err := r.Resolve(context.Background(), query(t, tt.name, tt.peer), buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if upstreamCalls != tt.wantCalls {
+					t.Errorf("%s: upstream calls = %d, want %d", tt.name, upstreamCalls, tt.wantCalls)
+				}
+				var m dnsmessage.Message
+				if err := m.Unpack(buf[:n]); err != nil {
+					t.Fatal(err)
+				}
+				if m.ID != 42 || m.Questions[0].Name.String() != tt.name {
+					t.Errorf("%s: got ID %d and question %s", tt.name, m.ID, m.Questions[0].Name)
+				}
+				var ttl uint32
+				if len(m.Answers) > 0 {
+					ttl = m.Answers[0].Header.TTL
+				}
+				if ttl != tt.wantTTL {
+					t.Errorf("%s: TTL = %d, want %d", tt.name, ttl, tt.wantTTL)
+				}
+			}
+		})
+	}
+}
+
+func TestResolver_truncated(t *testing.T) {
+	upstreamCalls := 0
+	r := &Resolver{
+		Cache: &Memory{},
+		Upstream: resolver.ResolverFunc(func(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+			upstreamCalls++
+			var m dnsmessage.Message
+			if err := m.Unpack(q.Payload); err != nil {
+				return 0, resolver.ResolveInfo{}, err
+			}
+			m.Header.Response = true
+			records := 100
+			if m.Questions[0].Name.String() == "large.example.com." {
+				// Larger than retryBufSize.
+				records = 400
+			}
+			for i := 0; i < records; i++ {
+				m.Answers = append(m.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: m.Questions[0].Name, Class: dnsmessage.ClassINET, TTL: 300},
+					Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, byte(i)}},
+				})
+			}
+			var opt dnsmessage.Resource
+			_ = opt.Header.SetEDNS0(1232, dnsmessage.RCodeSuccess, false)
+			opt.Body = &dnsmessage.OPTResource{}
+			m.Additionals = []dnsmessage.Resource{opt}
+			b, err := m.AppendPack(buf[:0])
+			if len(b) > len(buf) {
+				// Cut as the upstream resolvers do.
+				n := copy(buf, b)
+				buf[2] |= 0x2
+				return n, resolver.ResolveInfo{Transport: "HTTP/2.0"}, err
+			}
+			return len(b), resolver.ResolveInfo{Transport: "HTTP/2.0"}, err
+		}),
+	}
+	tests := []struct {
+		name      string
+		qname     string
+		bufSize   int
+		wantCalls int
+		wantTC    bool
+		wantOPT   bool
+	}{
+		{"UDP miss", "example.com.", 512, 1, true, true},
+		{"TCP retry", "example.com.", 65535, 1, false, true},
+		{"UDP hit", "example.com.", 512, 1, true, true},
+		{"UDP miss large", "large.example.com.", 512, 2, true, true},
+		{"TCP retry large", "large.example.com.", 65535, 3, false, true},
+	}
+	for _, tt := range tests {
+		buf := make([]byte, tt.bufSize)
+		q := query(t, tt.qname, "10.0.0.1")
+		// The OPT record of the query is kept when the one of a cut answer
+		// is lost.
+		var qm dnsmessage.Message
+		_ = qm.Unpack(q.Payload)
+		var opt dnsmessage.Resource
+		_ = opt.Header.SetEDNS0(4096, dnsmessage.RCodeSuccess, false)
+		opt.Body = &dnsmessage.OPTResource{}
+		qm.Additionals = []dnsmessage.Resource{opt}
+		q.Payload, _ = qm.Pack()
+		n, _, err := r.Resolve(context.Background(), q, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if upstreamCalls != tt.wantCalls {
+			t.Errorf("%s: upstream calls = %d, want %d", tt.name, upstreamCalls, tt.wantCalls)
+		}
+		var m dnsmessage.Message
+		if err := m.Unpack(buf[:n]); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		wantAnswers := 0
+		if !tt.wantTC {
+			wantAnswers = 100
+			if tt.qname == "large.example.com." {
+				wantAnswers = 400
+			}
+		}
+		if m.Truncated != tt.wantTC || len(m.Questions) != 1 || len(m.Answers) != wantAnswers {
+			t.Errorf("%s: got tc=%v with %d questions and %d answers, want tc=%v with 1 question and %d answers",
+				tt.name, m.Truncated, len(m.Questions), len(m.Answers), tt.wantTC, wantAnswers)
+		}
+		if opt := len(m.Additionals) == 1 && m.Additionals[0].Header.Type == dnsmessage.TypeOPT; opt != tt.wantOPT {
+			t.Errorf("%s: OPT = %v, want %v", tt.name, opt, tt.wantOPT)
+		}
+	}
+}
+
+// fakeRedis starts a server implementing the GET and SET commands of Redis
+// and returns its listener.
+func fakeRedis(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	data := map[string]string{}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					var n int
+					if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+						return
+					}
+					args := make([]string, n)
+					for i := range args {
+						var l int
+						if _, err := fmt.Fscanf(r, "$%d\r\n", &l); err != nil {
+							return
+						}
+						b := make([]byte, l+2)
+						if _, err := io.ReadFull(r, b); err != nil {
+							return
+						}
+						args[i] = string(b[:l])
+					}
+					mu.Lock()
+					switch args[0] {
+					case "GET":
+						if v, found := data[args[1]]; found {
+							fmt.Fprintf(c, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							fmt.Fprint(c, "$-1\r\n")
+						}
+					case "SET":
+						if ms, err := strconv.Atoi(args[4]); err != nil || args[3] != "PX" || ms <= 0 {
+							fmt.Fprint(c, "-ERR syntax error\r\n")
+						} else {
+							data[args[1]] = args[2]
+							fmt.Fprint(c, "+OK\r\n")
+						}
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func TestMemory_MaxBytes(t *testing.T) {
+	ctx := context.Background()
+	value := make([]byte, 100)
+	size := entrySize("key-00", value)
+	m := &Memory{MaxBytes: 10 * size}
+	for i := 0; i < 50; i++ {
+		if err := m.Set(ctx, fmt.Sprintf("key-%02d", i%20), value, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if m.Size() > m.MaxBytes {
+			t.Fatalf("size %d exceeds MaxBytes %d", m.Size(), m.MaxBytes)
+		}
+	}
+	if m.Size() != 10*size || len(m.entries) != 10 {
+		t.Errorf("%d entries using %d bytes, want 10 using %d", len(m.entries), m.Size(), 10*size)
+	}
+	if err := m.Set(ctx, "large", make([]byte, 20*size), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.Get(ctx, "large"); v != nil {
+		t.Error("entry larger than MaxBytes cached")
+	}
+	m.Flush()
+	if m.Size() != 0 {
+		t.Errorf("size %d after flush, want 0", m.Size())
+	}
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the timeout of the operations of remote backends when
+// their Timeout is zero.
+const DefaultTimeout = 500 * time.Millisecond
+
+// maxIdleConns is the maximum number of idle connections kept to a remote
+// backend.
+const maxIdleConns = 8
+
+// conn is a connection to a remote backend.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// pool keeps idle connections to a remote backend for reuse.
+type pool struct {
+	mu   sync.Mutex
+	idle []*conn
+}
+
+// do runs f with a connection to addr, initialized with init when new, and
+// keeps the connection for reuse if f succeeds.
+func (p *pool) do(ctx context.Context, addr string, timeout time.Duration, init func(c *conn) error, f func(c *conn) error) error {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	c, err := p.get(ctx, addr, init)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	_ = c.SetDeadline(deadline)
+	if err = f(c); err != nil {
+		c.Close()
+		return err
+	}
+	p.put(c)
+	return nil
+}
+
+func (p *pool) get(ctx context.Context, addr string, init func(c *conn) error) (*conn, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if init != nil {
+		deadline, _ := ctx.Deadline()
+		_ = c.SetDeadline(deadline)
+		if err := init(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *pool) put(c *conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) >= maxIdleConns {
+		c.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxMemcachedTTL is the maximum expiration time in seconds memcached takes
+// as relative (30 days).
+const maxMemcachedTTL = 30 * 24 * 3600
+
+// Memcached is a Cache stored in a memcached server, shared by the instances
+// using it. Keys must not contain spaces or control characters.
+type Memcached struct {
+	// Addr is the host:port of the server.
+	Addr string
+
+	// Timeout is the maximum duration of an operation. DefaultTimeout is
+	// used if zero.
+	Timeout time.Duration
+
+	pool pool
+}
+
+// Get implements Cache interface.
+func (m *Memcached) Get(ctx context.Context, key string) (value []byte, err error) {
+	err = m.pool.do(ctx, m.Addr, m.Timeout, nil, func(c *conn) error {
+		fmt.Fprintf(c.w, "get %s\r\n", key)
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+		for {
+			line, err := readLine(c)
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return nil
+			}
+			// VALUE <key> <flags> <bytes>
+			f := strings.Fields(line)
+			if len(f) != 4 || f[0] != "VALUE" {
+				return fmt.Errorf("memcached: unexpected reply: %q", line)
+			}
+			l, err := strconv.Atoi(f[3])
+			if err != nil || l < 0 {
+				return fmt.Errorf("memcached: invalid value length: %s", f[3])
+			}
+			b := make([]byte, l+2)
+			if _, err := io.ReadFull(c.r, b); err != nil {
+				return err
+			}
+			value = b[:l]
+		}
+	})
+	return value, err
+}
+
+// Set implements Cache interface.
+func (m *Memcached) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	secs := int64(ttl / time.Second)
+	if secs <= 0 {
+		return nil
+	}
+	if secs > maxMemcachedTTL {
+		// Larger values are taken as a Unix time.
+		secs = maxMemcachedTTL
+	}
+	return m.pool.do(ctx, m.Addr, m.Timeout, nil, func(c *conn) error {
+		fmt.Fprintf(c.w, "set %s 0 %d %d\r\n", key, secs, len(value))
+		_, _ = c.w.Write(value)
+		_, _ = c.w.WriteString("\r\n")
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+		line, err := readLine(c)
+		if err != nil {
+			return err
+		}
+		if line != "STORED" {
+			return errors.New("memcached: " + line)
+		}
+		return nil
+	})
+}
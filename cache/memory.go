@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultMaxEntries is the number of entries kept by Memory when MaxEntries
+// is zero.
+const DefaultMaxEntries = 10000
+
+// entryOverhead is the memory used by an entry besides its key and value: the
+// map slot, the entry struct and the headers of the key and value.
+const entryOverhead = 96
+
+// Memory is an in process Cache.
+type Memory struct {
+	// MaxEntries is the maximum number of entries. DefaultMaxEntries is used
+	// if zero and MaxBytes is not set.
+	MaxEntries int
+
+	// MaxBytes is the maximum memory used by the entries, keys and values
+	// included. No limit if zero.
+	MaxBytes int
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	size    int
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// Get implements Cache interface.
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, found := m.entries[key]
+	if !found {
+		return nil, nil
+	}
+	if !time.Now().Before(e.expires) {
+		m.deleteLocked(key, e)
+		return nil, nil
+	}
+	return e.value, nil
+}
+
+// Set implements Cache interface.
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	max := m.MaxEntries
+	if max <= 0 && m.MaxBytes <= 0 {
+		max = DefaultMaxEntries
+	}
+	size := entrySize(key, value)
+	if m.MaxBytes > 0 && size > m.MaxBytes {
+		return nil
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = map[string]memoryEntry{}
+	}
+	if e, found := m.entries[key]; found {
+		m.deleteLocked(key, e)
+	}
+	full := func() bool {
+		return (max > 0 && len(m.entries) >= max) || (m.MaxBytes > 0 && m.size+size > m.MaxBytes)
+	}
+	if full() {
+		for k, e := range m.entries {
+			if !now.Before(e.expires) {
+				m.deleteLocked(k, e)
+			}
+		}
+		// Evict random entries if not enough expired.
+		for k, e := range m.entries {
+			if !full() {
+				break
+			}
+			m.deleteLocked(k, e)
+		}
+	}
+	m.entries[key] = memoryEntry{value: value, expires: now.Add(ttl)}
+	m.size += size
+	return nil
+}
+
+// entrySize returns the memory used by an entry.
+func entrySize(key string, value []byte) int {
+	return len(key) + cap(value) + entryOverhead
+}
+
+// deleteLocked removes the entry e of key. Must be called with m.mu held.
+func (m *Memory) deleteLocked(key string, e memoryEntry) {
+	delete(m.entries, key)
+	m.size -= entrySize(key, e.value)
+}
+
+// Size returns the memory used by the entries in bytes.
+func (m *Memory) Size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.size
+}
+
+// Flush removes all the entries and returns their number.
+func (m *Memory) Flush() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := len(m.entries)
+	m.entries = nil
+	m.size = 0
+	return n
+}
+
+// Purge removes the expired entries and returns their number.
+func (m *Memory) Purge() int {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for k, e := range m.entries {
+		if !now.Before(e.expires) {
+			m.deleteLocked(k, e)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Redis is a Cache stored in a Redis server, shared by the instances using
+// it.
+type Redis struct {
+	// Addr is the host:port of the server.
+	Addr string
+
+	// Password is sent with the AUTH command if not empty.
+	Password string
+
+	// DB is the database selected if not zero.
+	DB int
+
+	// Timeout is the maximum duration of an operation. DefaultTimeout is
+	// used if zero.
+	Timeout time.Duration
+
+	pool pool
+}
+
+// Get implements Cache interface.
+func (r *Redis) Get(ctx context.Context, key string) (value []byte, err error) {
+	err = r.pool.do(ctx, r.Addr, r.Timeout, r.init, func(c *conn) error {
+		value, err = redisCommand(c, "GET", key)
+		return err
+	})
+	return value, err
+}
+
+// Set implements Cache interface.
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		return nil
+	}
+	return r.pool.do(ctx, r.Addr, r.Timeout, r.init, func(c *conn) error {
+		_, err := redisCommand(c, "SET", key, string(value), "PX", strconv.FormatInt(ms, 10))
+		return err
+	})
+}
+
+func (r *Redis) init(c *conn) error {
+	if r.Password != "" {
+		if _, err := redisCommand(c, "AUTH", r.Password); err != nil {
+			return err
+		}
+	}
+	if r.DB != 0 {
+		if _, err := redisCommand(c, "SELECT", strconv.Itoa(r.DB)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redisCommand sends a command and returns its reply, nil for a nil reply.
+func redisCommand(c *conn, args ...string) ([]byte, error) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	line, err := readLine(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, errors.New("redis: " + line[1:])
+	case '$':
+		l, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length: %s", line[1:])
+		}
+		if l < 0 {
+			return nil, nil
+		}
+		b := make([]byte, l+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:l], nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply: %q", line)
+}
+
+// readLine returns the next line sent by the server without its CRLF.
+func readLine(c *conn) (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("invalid line: %q", line)
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,322 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// retryInterval is the time Cache is not used after an error, so an
+// unreachable backend does not delay every query.
+const retryInterval = 5 * time.Second
+
+// retryBufSize is the size of the buffers the upstream answers to the queries
+// received with a smaller buffer (i.e. over UDP) are read into, so the answers
+// up to this size are cached for the retry of the client over TCP. Larger
+// answers are sent upstream again on retry, which bounds the memory held by
+// each query in flight.
+const retryBufSize = 4096
+
+// bufPool pools the buffers of size retryBufSize.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, retryBufSize)
+		return &b
+	},
+}
+
+var errTooLarge = errors.New("truncated answer too large")
+
+// Resolver answers the queries for which Upstream returned a positive answer
+// from Cache until the lowest TTL of the answer expires.
+type Resolver struct {
+	// failedUntil is the Unix time in nanoseconds until which Cache is not
+	// used after an error. First for 64-bit alignment of atomic operations.
+	failedUntil int64
+
+	// Cache is where answers are stored.
+	Cache Cache
+
+	// Upstream is the resolver queries are sent to.
+	Upstream resolver.Resolver
+
+	// MaxTTL caps the time answers are cached if not zero.
+	MaxTTL time.Duration
+
+	// ClientKey specifies an optional function returning a key identifying
+	// how Upstream handles the queries of the client of q (i.e. its
+	// configuration). Answers are only shared by queries with the same key.
+	ClientKey func(q resolver.Query) string
+
+	// ErrorLog is called with the errors of Cache. Queries are sent to
+	// Upstream when Cache fails.
+	ErrorLog func(error)
+
+	// now is used by tests.
+	now func() time.Time
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	question, err := p.Question()
+	if err != nil {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	key := r.key(q, question, &p)
+	now := r.timeNow()
+
+	available := now.UnixNano() >= atomic.LoadInt64(&r.failedUntil)
+	if t := resolver.TraceFrom(ctx); t != nil && t.NoCache {
+		t.Add("cache", "skipped")
+	} else if available {
+		v, err := r.Cache.Get(ctx, key)
+		if err != nil {
+			r.fail(err, now)
+			available = false
+		} else if v != nil {
+			if n, err := reply(v, h.ID, question, now, buf); err == nil {
+				resolver.Tracef(ctx, "cache", "hit")
+				return n, resolver.ResolveInfo{Transport: "cache"}, nil
+			}
+		}
+		resolver.Tracef(ctx, "cache", "miss")
+	} else {
+		resolver.Tracef(ctx, "cache", "unavailable after an error")
+	}
+
+	// Answers too large for buf (i.e. UDP clients) are read in full up to
+	// retryBufSize, so they are cached for the retry of the client over TCP
+	// and not sent upstream twice.
+	rbuf := buf
+	if available && len(buf) < retryBufSize {
+		bp := bufPool.Get().(*[]byte)
+		defer bufPool.Put(bp)
+		rbuf = *bp
+	}
+	n, i, err := r.Upstream.Resolve(ctx, q, rbuf)
+	if err != nil || n <= 0 {
+		return n, i, err
+	}
+	if available && i.Transport != "UDP" {
+		// Answers of the plain DNS fallback are not filtered.
+		var msg dnsmessage.Message
+		if msg.Unpack(rbuf[:n]) == nil {
+			if ttl := r.ttl(msg); ttl > 0 {
+				if err := r.Cache.Set(ctx, key, encode(msg, now), ttl); err != nil {
+					r.fail(err, now)
+				}
+			}
+		}
+	}
+	if len(rbuf) != len(buf) {
+		n, err = cut(buf, rbuf[:n], q.Payload)
+	}
+	return n, i, err
+}
+
+// cut copies the response m to buf. If it does not fit, buf gets an empty
+// response to the question of m with the TC bit set instead, so the client
+// retries over TCP. The OPT record of m is kept, or the one of the query if m
+// was itself cut by Upstream, so the client does not retry without EDNS
+// instead.
+func cut(buf, m, query []byte) (int, error) {
+	if len(m) <= len(buf) {
+		return copy(buf, m), nil
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(m)
+	if err != nil {
+		return -1, err
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return -1, err
+	}
+	rh, opt, found := findOPT(&p)
+	if !found && query != nil {
+		if _, err := p.Start(query); err == nil && p.SkipAllQuestions() == nil {
+			// The options of the query are not echoed.
+			rh, _, found = findOPT(&p)
+			opt = dnsmessage.OPTResource{}
+		}
+	}
+	h.Truncated = true
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	for _, q := range questions {
+		if err := b.Question(q); err != nil {
+			return -1, err
+		}
+	}
+	if found {
+		_ = b.StartAdditionals()
+		if err := b.OPTResource(rh, opt); err != nil {
+			return -1, err
+		}
+	}
+	out, err := b.Finish()
+	if err != nil {
+		return -1, err
+	}
+	if len(out) > len(buf) {
+		return -1, errTooLarge
+	}
+	return len(out), nil
+}
+
+// findOPT returns the OPT record of the message parsed by p, positioned after
+// its questions.
+func findOPT(p *dnsmessage.Parser) (dnsmessage.ResourceHeader, dnsmessage.OPTResource, bool) {
+	if p.SkipAllAnswers() != nil || p.SkipAllAuthorities() != nil {
+		return dnsmessage.ResourceHeader{}, dnsmessage.OPTResource{}, false
+	}
+	for {
+		rh, err := p.AdditionalHeader()
+		if err != nil {
+			break
+		}
+		if rh.Type == dnsmessage.TypeOPT {
+			opt, err := p.OPTResource()
+			return rh, opt, err == nil
+		}
+		if p.SkipAdditional() != nil {
+			break
+		}
+	}
+	return dnsmessage.ResourceHeader{}, dnsmessage.OPTResource{}, false
+}
+
+// key returns the cache key of the query q, hashed so it can be used with any
+// backend without exposing client information. Queries with or without EDNS,
+// asking for DNSSEC records or disabling validation get answers of their own.
+func (r *Resolver) key(q resolver.Query, question dnsmessage.Question, p *dnsmessage.Parser) string {
+	flags := "-"
+	if q.Payload[3]&0x10 != 0 { // CD
+		flags = "c"
+	}
+	_ = p.SkipAllQuestions()
+	_ = p.SkipAllAnswers()
+	_ = p.SkipAllAuthorities()
+	for {
+		rh, err := p.AdditionalHeader()
+		if err != nil {
+			break
+		}
+		if rh.Type == dnsmessage.TypeOPT {
+			flags += "e"
+			if rh.DNSSECAllowed() {
+				flags += "d"
+			}
+		}
+		if p.SkipAdditional() != nil {
+			break
+		}
+	}
+	key := strings.ToLower(question.Name.String()) + "|" + question.Type.String() + "|" + question.Class.String() + "|" + flags
+	if r.ClientKey != nil {
+		key = r.ClientKey(q) + "\x00" + key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "nextdns:" + hex.EncodeToString(sum[:16])
+}
+
+// ttl returns the time msg can be cached, or 0 if msg is not a cacheable
+// positive answer.
+func (r *Resolver) ttl(msg dnsmessage.Message) time.Duration {
+	if msg.Truncated || msg.RCode != dnsmessage.RCodeSuccess || len(msg.Answers) == 0 {
+		return 0
+	}
+	min := ^uint32(0)
+	for _, rrs := range [][]dnsmessage.Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+		for _, rr := range rrs {
+			if rr.Header.Type != dnsmessage.TypeOPT && rr.Header.TTL < min {
+				min = rr.Header.TTL
+			}
+		}
+	}
+	d := time.Duration(min) * time.Second
+	if r.MaxTTL > 0 && d > r.MaxTTL {
+		d = r.MaxTTL
+	}
+	return d
+}
+
+// encode returns the cached form of msg stored at now: the Unix time followed
+// by the packed message.
+func encode(msg dnsmessage.Message, now time.Time) []byte {
+	b := make([]byte, 8, 512)
+	binary.BigEndian.PutUint64(b, uint64(now.Unix()))
+	b, err := msg.AppendPack(b)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// reply writes the cached answer v to buf with the given ID and question, and
+// its TTLs decremented by the time spent in cache.
+func reply(v []byte, id uint16, question dnsmessage.Question, now time.Time, buf []byte) (int, error) {
+	if len(v) < 8 {
+		return -1, errors.New("invalid cached answer")
+	}
+	stored := time.Unix(int64(binary.BigEndian.Uint64(v)), 0)
+	var msg dnsmessage.Message
+	if err := msg.Unpack(v[8:]); err != nil {
+		return -1, err
+	}
+	msg.ID = id
+	msg.Questions = []dnsmessage.Question{question}
+	age := uint32(now.Sub(stored) / time.Second)
+	for _, rrs := range [][]dnsmessage.Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+		for i := range rrs {
+			rh := &rrs[i].Header
+			if rh.Type == dnsmessage.TypeOPT {
+				continue
+			}
+			if rh.TTL <= age {
+				// Expired, i.e. backend clock ahead.
+				return -1, errors.New("expired cached answer")
+			}
+			rh.TTL -= age
+		}
+	}
+	// buf is not packed into directly as it can share its memory with the
+	// query.
+	b, err := msg.Pack()
+	if err != nil {
+		return -1, err
+	}
+	// Answers larger than buf (i.e. UDP client of an answer first received
+	// over TCP) are cut, the retry over TCP being answered from the cache.
+	return cut(buf, b, nil)
+}
+
+// fail stops using Cache for retryInterval after err. Consecutive errors are
+// logged once.
+func (r *Resolver) fail(err error, now time.Time) {
+	last := atomic.SwapInt64(&r.failedUntil, now.Add(retryInterval).UnixNano())
+	if last < now.Add(-retryInterval).UnixNano() && r.ErrorLog != nil {
+		r.ErrorLog(err)
+	}
+}
+
+func (r *Resolver) timeNow() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/nextdns/nextdns/ctl"
+)
+
+// cacheOnlyCmd enables, disables or shows the cache-only mode of the running
+// daemon, where queries are answered from the cache and local records only.
+func cacheOnlyCmd(args []string) error {
+	fs := flag.NewFlagSet("nextdns cache-only", flag.ExitOnError)
+	addr := ctl.DefaultAddr
+	if runtime.GOOS == "windows" {
+		addr = ""
+	}
+	fs.StringVar(&addr, "control", addr, "Path to the control socket of the daemon.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: nextdns cache-only [on|off]\n\n")
+		fmt.Fprintf(fs.Output(), "Answer queries from the cache and local records only, never contacting\nthe upstreams. Shows the current mode without argument.\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args[1:])
+	var mode string
+	if fs.NArg() > 0 {
+		// The mode can be followed by flags.
+		mode = fs.Arg(0)
+		if mode != "on" && mode != "off" {
+			return withCode(exitUsage, fmt.Errorf("%s: invalid mode, expected on or off", mode))
+		}
+		_ = fs.Parse(fs.Args()[1:])
+	}
+	if fs.NArg() > 0 {
+		return withCode(exitUsage, fmt.Errorf("%s: unexpected argument", fs.Arg(0)))
+	}
+	if addr == "" {
+		return withCode(exitUsage, errors.New("missing control socket path"))
+	}
+
+	var data []byte
+	var err error
+	if mode == "" {
+		data, err = sendControl(addr, "cache-only.status")
+	} else {
+		data, err = sendControl(addr, "cache-only", mode)
+	}
+	if err != nil {
+		return err
+	}
+	var res struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+	if jsonOutput {
+		return json.NewEncoder(os.Stdout).Encode(res)
+	}
+	if res.Enabled {
+		fmt.Println("Cache-only mode enabled: queries are answered from the cache and local records only")
+	} else {
+		fmt.Println("Cache-only mode disabled")
+	}
+	return nil
+}
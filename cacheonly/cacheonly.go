@@ -0,0 +1,77 @@
+// Package cacheonly implements a mode where queries are never sent to the
+// upstreams, so only the cached and local answers are served. It is useful
+// for offline labs and demos, or to tell whether a problem comes from the
+// upstream or the local setup.
+package cacheonly
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// Source is the ResolveInfo source of the responses generated while the mode
+// is enabled.
+const Source = "cache-only"
+
+// Switch enables or disables the cache-only mode. It is shared by all the
+// resolvers of the mode. The zero value is disabled.
+type Switch struct {
+	on int32
+}
+
+// Set enables or disables the mode, and returns true if it changed.
+func (s *Switch) Set(on bool) bool {
+	var v int32
+	if on {
+		v = 1
+	}
+	return atomic.SwapInt32(&s.on, v) != v
+}
+
+// On returns true if the mode is enabled.
+func (s *Switch) On() bool {
+	return atomic.LoadInt32(&s.on) == 1
+}
+
+// Resolver sends the queries to Upstream unless the mode is enabled, in
+// which case they are answered with SERVFAIL without contacting it. It must be
+// placed below the caches, so cached answers are still served.
+type Resolver struct {
+	Switch *Switch
+
+	Upstream resolver.Resolver
+}
+
+// Resolve implements the resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	if !r.Switch.On() {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	resolver.Tracef(ctx, "cache-only", "not sent upstream")
+	n, err := replyServFail(q.Payload, buf)
+	return n, resolver.ResolveInfo{Source: Source}, err
+}
+
+// replyServFail writes a SERVFAIL response to the query into buf.
+func replyServFail(query, buf []byte) (int, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return 0, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return 0, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = dnsmessage.RCodeServerFailure
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q)
+	res, err := b.Finish()
+	return len(res), err
+}
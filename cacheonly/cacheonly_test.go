@@ -0,0 +1,69 @@
+package cacheonly
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+type countResolver int
+
+func (r *countResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	*r++
+	return copy(buf, q.Payload), resolver.ResolveInfo{}, nil
+}
+
+func TestResolver(t *testing.T) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := resolver.Query{Name: "example.com.", Payload: payload}
+
+	var up countResolver
+	var s Switch
+	r := &Resolver{Switch: &s, Upstream: &up}
+	buf := make([]byte, 512)
+	tests := []struct {
+		name     string
+		on       bool
+		changed  bool
+		upstream int
+		rcode    dnsmessage.RCode
+	}{
+		{"disabled", false, false, 1, dnsmessage.RCodeSuccess},
+		{"enabled", true, true, 1, dnsmessage.RCodeServerFailure},
+		{"enabled again", true, false, 1, dnsmessage.RCodeServerFailure},
+		{"disabled again", false, true, 2, dnsmessage.RCodeSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if changed := s.Set(tt.on); changed != tt.changed {
+				t.Errorf("Set() = %v, want %v", changed, tt.changed)
+			}
+			n, i, err := r.Resolve(context.Background(), q, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int(up) != tt.upstream {
+				t.Errorf("upstream queries = %d, want %d", up, tt.upstream)
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.ID != 42 || h.RCode != tt.rcode {
+				t.Errorf("response ID %d rcode %v, want 42 %v", h.ID, h.RCode, tt.rcode)
+			}
+			if tt.on && i.Source != Source {
+				t.Errorf("Source = %q, want %q", i.Source, Source)
+			}
+		})
+	}
+}
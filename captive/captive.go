@@ -0,0 +1,158 @@
+// Package captive handles networks behind a captive portal by resolving the
+// names used by operating systems to detect captive portals using the network
+// provided DNS servers, until the secure upstream is reachable again.
+package captive
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/endpoint"
+)
+
+// DefaultThreshold is the default value for Resolver Threshold.
+const DefaultThreshold = 3
+
+// ProbeNames lists the names queried by common operating systems and browsers
+// to detect captive portals.
+var ProbeNames = []string{
+	"captive.apple.com",
+	"connectivitycheck.gstatic.com",
+	"connectivitycheck.android.com",
+	"clients3.google.com",
+	"www.msftconnecttest.com",
+	"www.msftncsi.com",
+	"dns.msftncsi.com",
+	"detectportal.firefox.com",
+	"nmcheck.gnome.org",
+	"connectivity-check.ubuntu.com",
+	"network-test.debian.org",
+}
+
+// Resolver sends queries to Upstream. When Threshold consecutive queries failed
+// with an error typical of a captive portal (invalid certificate, HTTP
+// redirect…), a captive portal is considered detected and queries for
+// captive portal probe names are sent in plain DNS to the servers returned by
+// Servers, so the operating system can show the portal login page. Other
+// queries are still sent to Upstream only, and the detection ends with the
+// first query successfully resolved by Upstream.
+type Resolver struct {
+	// Names are the probe names resolved using Servers while a captive portal
+	// is detected, in addition to their sub-domains.
+	Names []string
+
+	// Servers returns the addresses of the network provided DNS servers, as
+	// IP or IP:PORT.
+	Servers func() []string
+
+	// Threshold is the number of consecutive captive portal errors required
+	// to detect a captive portal. If zero, DefaultThreshold is used.
+	Threshold int
+
+	// Upstream is the secure resolver.
+	Upstream resolver.Resolver
+
+	// OnChange is called when a captive portal starts or stops being
+	// detected.
+	OnChange func(detected bool)
+
+	dns53 resolver.DNS53
+
+	mu       sync.Mutex
+	errors   int
+	detected bool
+}
+
+// Detected returns true if a captive portal is currently detected.
+func (r *Resolver) Detected() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.detected
+}
+
+// Resolve implements the resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	probe := r.match(q.Name)
+	if probe && r.Detected() {
+		return r.resolvePlain(ctx, q, buf)
+	}
+	if probe {
+		// The payload may share its memory with buf, keep a copy in case the
+		// query has to be sent again in plain DNS.
+		q.Payload = append([]byte(nil), q.Payload...)
+	}
+	n, i, err = r.Upstream.Resolve(ctx, q, buf)
+	if r.record(err) && probe {
+		return r.resolvePlain(ctx, q, buf)
+	}
+	return n, i, err
+}
+
+// record records the result of an upstream query and returns true if a
+// captive portal is detected.
+func (r *Resolver) record(err error) bool {
+	r.mu.Lock()
+	detected := r.detected
+	switch {
+	case err == nil:
+		r.errors = 0
+		detected = false
+	case endpoint.IsCaptivePortalError(err):
+		r.errors++
+		threshold := r.Threshold
+		if threshold == 0 {
+			threshold = DefaultThreshold
+		}
+		if r.errors >= threshold {
+			detected = true
+		}
+	}
+	changed := detected != r.detected
+	r.detected = detected
+	r.mu.Unlock()
+	if changed && r.OnChange != nil {
+		r.OnChange(detected)
+	}
+	return detected
+}
+
+func (r *Resolver) resolvePlain(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var servers []string
+	if r.Servers != nil {
+		servers = r.Servers()
+	}
+	if len(servers) == 0 {
+		return -1, i, errors.New("captive: no network DNS server")
+	}
+	payload := q.Payload
+	for _, s := range servers {
+		// Keep the payload intact for the next server.
+		q.Payload = append([]byte(nil), payload...)
+		addr := s
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			addr = net.JoinHostPort(s, "53")
+		}
+		if n, i, err = r.dns53.Exchange(ctx, q, buf, addr); err == nil {
+			return n, i, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return n, i, err
+}
+
+func (r *Resolver) match(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, n := range r.Names {
+		n = strings.TrimSuffix(strings.ToLower(n), ".")
+		if name == n || strings.HasSuffix(name, "."+n) {
+			return true
+		}
+	}
+	return false
+}
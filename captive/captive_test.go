@@ -0,0 +1,109 @@
+package captive
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/resolver"
+)
+
+type fakeUpstream struct {
+	err error
+}
+
+func (u *fakeUpstream) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	if u.err != nil {
+		return -1, resolver.ResolveInfo{}, u.err
+	}
+	return copy(buf, q.Payload), resolver.ResolveInfo{Transport: "HTTP/2.0"}, nil
+}
+
+// serveDNS53 starts a UDP server echoing queries as responses.
+func serveDNS53(t *testing.T) net.PacketConn {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			buf[2] |= 0x80
+			_, _ = c.WriteTo(buf[:n], addr)
+		}
+	}()
+	return c
+}
+
+func query(name string) resolver.Query {
+	payload := []byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	for _, l := range []string{name[:len(name)-4], "com"} {
+		payload = append(payload, byte(len(l)))
+		payload = append(payload, l...)
+	}
+	payload = append(payload, 0, 0x00, 0x01, 0x00, 0x01)
+	return resolver.Query{Name: name + ".", Payload: payload}
+}
+
+func TestResolver(t *testing.T) {
+	c := serveDNS53(t)
+	defer c.Close()
+	up := &fakeUpstream{}
+	var changes []bool
+	r := &Resolver{
+		Names: ProbeNames,
+		Servers: func() []string {
+			return []string{c.LocalAddr().String()}
+		},
+		Upstream: up,
+		OnChange: func(detected bool) {
+			changes = append(changes, detected)
+		},
+	}
+	resolve := func(name, wantTransport string, wantErr bool) {
+		t.Helper()
+		buf := make([]byte, 512)
+		_, i, err := r.Resolve(context.Background(), query(name), buf)
+		if (err != nil) != wantErr {
+			t.Fatalf("Resolve(%s) err = %v, wantErr %v", name, err, wantErr)
+		}
+		if i.Transport != wantTransport {
+			t.Errorf("Resolve(%s) transport = %q, want %q", name, i.Transport, wantTransport)
+		}
+	}
+
+	up.err = fmt.Errorf("doh resolve: %w", x509.UnknownAuthorityError{})
+	resolve("captive.apple.com", "", true)
+	resolve("example.com", "", true)
+	if r.Detected() {
+		t.Fatal("detected before threshold")
+	}
+	// The third error detects the portal and the probe is sent in plain DNS.
+	resolve("captive.apple.com", "UDP", false)
+	resolve("example.com", "", true)
+	resolve("captive.apple.com", "UDP", false)
+
+	// Other errors do not end the detection.
+	up.err = errors.New("timeout")
+	resolve("example.com", "", true)
+	if !r.Detected() {
+		t.Fatal("detection ended on unrelated error")
+	}
+
+	// Recovery.
+	up.err = nil
+	resolve("example.com", "HTTP/2.0", false)
+	resolve("captive.apple.com", "HTTP/2.0", false)
+
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("changes = %v, want [true false]", changes)
+	}
+}
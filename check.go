@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/nextdns/nextdns/config"
+)
+
+// checkMode is set when the -check flag is passed to a command supporting it.
+// The command then only reports the changes it would make to the system.
+var checkMode bool
+
+// checkCommands lists the commands accepting the global -check flag.
+var checkCommands = map[string]bool{
+	"install":    true,
+	"activate":   true,
+	"deactivate": true,
+	"config":     true,
+	"tune":       true,
+}
+
+// configChanges returns a change for each setting of c differing from the
+// stored configuration.
+func configChanges(c config.Config) ([]string, error) {
+	names, err := c.Diff()
+	if err != nil {
+		return nil, withCode(exitConfig, err)
+	}
+	changes := make([]string, 0, len(names))
+	for _, name := range names {
+		changes = append(changes, "set "+name)
+	}
+	return changes, nil
+}
+
+// reportChanges prints the changes made by a command, or the ones it would
+// make in check mode. Outside of check mode, nothing is printed in text
+// output as commands report their actions themselves.
+func reportChanges(changes []string) error {
+	if jsonOutput {
+		if changes == nil {
+			changes = []string{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(struct {
+			Changed bool     `json:"changed"`
+			Check   bool     `json:"check"`
+			Changes []string `json:"changes"`
+		}{len(changes) > 0, checkMode, changes})
+	}
+	if !checkMode {
+		return nil
+	}
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+		return nil
+	}
+	for _, c := range changes {
+		fmt.Printf("would %s\n", c)
+	}
+	return nil
+}
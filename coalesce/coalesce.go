@@ -0,0 +1,106 @@
+// Package coalesce deduplicates identical queries in flight, so a burst of
+// clients asking for the same name at once (i.e. after waking from sleep)
+// results in a single upstream query.
+package coalesce
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// Resolver sends the queries identical to one already in flight to Upstream
+// only once. The response is copied to each client with its transaction ID.
+type Resolver struct {
+	// Upstream is the resolver queries are sent to.
+	Upstream resolver.Resolver
+
+	// ClientKey specifies an optional function returning a key identifying
+	// how Upstream handles the queries of the client of q (i.e. its
+	// configuration or the client information reported with the query).
+	// Only queries with the same key are coalesced.
+	ClientKey func(q resolver.Query) string
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a query in flight.
+type call struct {
+	done chan struct{}
+	dups int
+
+	// Set before done is closed.
+	resp []byte
+	info resolver.ResolveInfo
+	err  error
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	if len(q.Payload) < 12 {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	// Queries differing only by their ID are identical. The question is
+	// compared case sensitively, so the response has the case of the query.
+	key := string(q.Payload[2:])
+	if r.ClientKey != nil {
+		key = r.ClientKey(q) + "\x00" + key
+	}
+
+	r.mu.Lock()
+	if c, found := r.calls[key]; found {
+		c.dups++
+		r.mu.Unlock()
+		resolver.Tracef(ctx, "coalesce", "waiting for an identical query in flight")
+		return r.wait(ctx, c, q, buf)
+	}
+	c := &call{done: make(chan struct{})}
+	if r.calls == nil {
+		r.calls = map[string]*call{}
+	}
+	r.calls[key] = c
+	r.mu.Unlock()
+
+	n, i, err := r.Upstream.Resolve(ctx, q, buf)
+
+	r.mu.Lock()
+	delete(r.calls, key)
+	dups := c.dups
+	r.mu.Unlock()
+	if dups > 0 {
+		c.info, c.err = i, err
+		if err == nil && n > 0 {
+			c.resp = append([]byte(nil), buf[:n]...)
+		}
+	}
+	close(c.done)
+	return n, i, err
+}
+
+// wait waits for the call c and writes its response to buf with the ID of q.
+func (r *Resolver) wait(ctx context.Context, c *call, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	// buf can share its memory with the payload.
+	id0, id1 := q.Payload[0], q.Payload[1]
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return -1, resolver.ResolveInfo{}, ctx.Err()
+	}
+	// The query was not sent upstream for this client.
+	i := resolver.ResolveInfo{Transport: c.info.Transport, Endpoint: c.info.Endpoint}
+	if c.err != nil {
+		return -1, i, c.err
+	}
+	if len(c.resp) > len(buf) {
+		// The response does not fit (i.e. UDP client of a query first
+		// received over TCP), let the upstream truncate it.
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	n := copy(buf, c.resp)
+	if n >= 2 {
+		buf[0], buf[1] = id0, id1
+	}
+	return n, i, nil
+}
@@ -0,0 +1,85 @@
+package coalesce
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// slowResolver echoes the queries after a delay, counting them.
+type slowResolver struct {
+	count int32
+}
+
+func (r *slowResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	atomic.AddInt32(&r.count, 1)
+	time.Sleep(50 * time.Millisecond)
+	n := copy(buf, q.Payload)
+	buf[2] |= 0x80 // QR
+	return n, resolver.ResolveInfo{Transport: "slow", Attempts: 1}, nil
+}
+
+func newQuery(t *testing.T, id uint16, name string) resolver.Query {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolver.Query{Name: name, Payload: payload}
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		names     []string
+		clientKey func(q resolver.Query) string
+		want      int32
+	}{
+		{"Identical", []string{"example.com.", "example.com.", "example.com."}, nil, 1},
+		{"DifferentNames", []string{"example.com.", "example.net.", "example.com."}, nil, 2},
+		{"DifferentCase", []string{"example.com.", "EXAMPLE.com."}, nil, 2},
+		{"DifferentClients", []string{"example.com.", "example.com."}, func(q resolver.Query) string {
+			return string(q.Payload[:2])
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := &slowResolver{}
+			r := &Resolver{Upstream: up, ClientKey: tt.clientKey}
+			var wg sync.WaitGroup
+			for i, name := range tt.names {
+				wg.Add(1)
+				go func(id uint16, name string) {
+					defer wg.Done()
+					buf := make([]byte, 512)
+					n, _, err := r.Resolve(context.Background(), newQuery(t, id, name), buf)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if got := binary.BigEndian.Uint16(buf[:n]); got != id {
+						t.Errorf("response ID = %d, want %d", got, id)
+					}
+				}(uint16(i+1), name)
+				// Make sure the first query is in flight.
+				time.Sleep(5 * time.Millisecond)
+			}
+			wg.Wait()
+			if got := atomic.LoadInt32(&up.count); got != tt.want {
+				t.Errorf("upstream queries = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/host"
+	"github.com/nextdns/nextdns/proxy"
+)
+
+// compareDomains is the default set of domains resolved by compare, popular
+// enough to be representative of everyday browsing.
+var compareDomains = []string{
+	"google.com", "youtube.com", "facebook.com", "instagram.com",
+	"wikipedia.org", "amazon.com", "apple.com", "microsoft.com",
+	"netflix.com", "reddit.com", "github.com", "cloudflare.com",
+}
+
+// compareTiming is the outcome of the queries for a domain sent to a resolver.
+type compareTiming struct {
+	Cold  time.Duration `json:"cold"`
+	Warm  time.Duration `json:"warm"`
+	Hit   bool          `json:"cache_hit"`
+	Error string        `json:"error,omitempty"`
+}
+
+// compareDomain is the comparison of the resolvers for a domain.
+type compareDomain struct {
+	Domain  string        `json:"domain"`
+	NextDNS compareTiming `json:"nextdns"`
+	System  compareTiming `json:"system"`
+}
+
+// compareSummary summarizes the timings of a resolver.
+type compareSummary struct {
+	Server   string        `json:"server"`
+	ColdP50  time.Duration `json:"cold_p50"`
+	WarmP50  time.Duration `json:"warm_p50"`
+	HitRatio float64       `json:"cache_hit_ratio"`
+	Errors   int           `json:"errors"`
+}
+
+// compare resolves a set of domains through the daemon and through the
+// resolver of the network, and compares their latency and cache hits.
+func compare(args []string) error {
+	fs := flag.NewFlagSet("nextdns compare", flag.ExitOnError)
+	daemon := fs.String("daemon", "", "Address of the nextdns daemon. Defaults to the configured listen address.")
+	system := fs.String("system", "", "Address of the system resolver. Defaults to the DNS server provided by the network.")
+	domains := fs.String("domains", strings.Join(compareDomains, ","), "Comma separated list of domains to resolve.")
+	timeout := fs.Duration("timeout", 2*time.Second, "Maximum duration of a query before considering it failed.")
+	hitThreshold := fs.Duration("hit-threshold", 5*time.Millisecond, "Duration under which an answer is considered served from a cache.")
+	_ = fs.Parse(args[1:])
+
+	if *daemon == "" {
+		var c config.Config
+		c.Parse("nextdns compare", nil, true)
+		*daemon = compareListenAddr(c.Listen)
+	}
+	if *system == "" {
+		for _, dns := range host.DNS() {
+			if ip := net.ParseIP(dns); ip != nil && !ip.IsLoopback() {
+				*system = dns
+				break
+			}
+		}
+		if *system == "" {
+			return errors.New("system resolver not found, set it with -system")
+		}
+	}
+	servers := []string{compareAddr(*daemon), compareAddr(*system)}
+
+	var results []compareDomain
+	for _, domain := range strings.Split(*domains, ",") {
+		domain = strings.TrimSpace(domain)
+		if domain == "" {
+			continue
+		}
+		r := compareDomain{Domain: domain}
+		timings := []*compareTiming{&r.NextDNS, &r.System}
+		// The first query may be answered from the cache if the domain was
+		// recently resolved, the second one should.
+		for i, server := range servers {
+			t := timings[i]
+			var err error
+			if t.Cold, err = compareQuery(server, domain, *timeout); err == nil {
+				t.Warm, err = compareQuery(server, domain, *timeout)
+			}
+			if err != nil {
+				t.Error = err.Error()
+				continue
+			}
+			t.Hit = t.Warm < *hitThreshold
+		}
+		results = append(results, r)
+	}
+	summaries := []compareSummary{
+		compareSummarize(servers[0], results, func(r compareDomain) compareTiming { return r.NextDNS }),
+		compareSummarize(servers[1], results, func(r compareDomain) compareTiming { return r.System }),
+	}
+
+	if jsonOutput {
+		return json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+			"domains": results,
+			"summary": summaries,
+		})
+	}
+	ms := func(t compareTiming) string {
+		if t.Error != "" {
+			return "failed"
+		}
+		s := fmt.Sprintf("%d/%dms", t.Cold.Milliseconds(), t.Warm.Milliseconds())
+		if t.Hit {
+			s += " (hit)"
+		}
+		return s
+	}
+	fmt.Printf("%-20s %-20s %s\n", "DOMAIN", "NEXTDNS COLD/WARM", "SYSTEM COLD/WARM")
+	for _, r := range results {
+		fmt.Printf("%-20s %-20s %s\n", r.Domain, ms(r.NextDNS), ms(r.System))
+	}
+	fmt.Println()
+	for i, s := range summaries {
+		name := "nextdns"
+		if i == 1 {
+			name = "system"
+		}
+		fmt.Printf("%-8s %-22s cold p50=%dms warm p50=%dms cache hits=%.0f%% errors=%d\n",
+			name, s.Server, s.ColdP50.Milliseconds(), s.WarmP50.Milliseconds(), s.HitRatio*100, s.Errors)
+	}
+	return nil
+}
+
+// compareQuery returns the duration of an A query for domain sent to server.
+func compareQuery(server, domain string, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	err := watchProbe(ctx, "do53", server, domain, nil)
+	return time.Since(start), err
+}
+
+// compareSummarize summarizes the timings returned by timing for results.
+func compareSummarize(server string, results []compareDomain, timing func(compareDomain) compareTiming) compareSummary {
+	s := compareSummary{Server: server}
+	var cold, warm []time.Duration
+	hits := 0
+	for _, r := range results {
+		t := timing(r)
+		if t.Error != "" {
+			s.Errors++
+			continue
+		}
+		cold = append(cold, t.Cold)
+		warm = append(warm, t.Warm)
+		if t.Hit {
+			hits++
+		}
+	}
+	s.ColdP50, s.WarmP50 = median(cold), median(warm)
+	if len(cold) > 0 {
+		s.HitRatio = float64(hits) / float64(len(cold))
+	}
+	return s
+}
+
+func median(d []time.Duration) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	return d[len(d)/2]
+}
+
+// compareListenAddr returns the address to query the daemon listening on
+// listen.
+func compareListenAddr(listen string) string {
+	addrs := proxy.SplitAddr(listen)
+	if len(addrs) == 0 {
+		return "127.0.0.1:53"
+	}
+	h, port, err := net.SplitHostPort(addrs[0])
+	if err != nil {
+		return addrs[0]
+	}
+	if h == "" || h == "localhost" || net.ParseIP(h).IsUnspecified() {
+		h = "127.0.0.1"
+	}
+	return net.JoinHostPort(h, port)
+}
+
+// compareAddr adds the default DNS port to addr if it has none.
+func compareAddr(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, "53")
+	}
+	return addr
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_compareListenAddr(t *testing.T) {
+	tests := []struct {
+		listen string
+		want   string
+	}{
+		{"", "127.0.0.1:53"},
+		{":53", "127.0.0.1:53"},
+		{"localhost:5353", "127.0.0.1:5353"},
+		{"0.0.0.0:53", "127.0.0.1:53"},
+		{"[::]:53", "127.0.0.1:53"},
+		{"192.168.1.1:53", "192.168.1.1:53"},
+		{"10.0.0.1:53,127.0.0.1:53", "10.0.0.1:53"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.listen, func(t *testing.T) {
+			if got := compareListenAddr(tt.listen); got != tt.want {
+				t.Errorf("compareListenAddr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_compareAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"192.168.1.1", "192.168.1.1:53"},
+		{"192.168.1.1:5353", "192.168.1.1:5353"},
+		{"fe80::1", "[fe80::1]:53"},
+		{"[fe80::1]:5353", "[fe80::1]:5353"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := compareAddr(tt.addr); got != tt.want {
+				t.Errorf("compareAddr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_median(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		d    []time.Duration
+		want time.Duration
+	}{
+		{"empty", nil, 0},
+		{"one", []time.Duration{3 * ms}, 3 * ms},
+		{"odd", []time.Duration{9 * ms, 1 * ms, 5 * ms}, 5 * ms},
+		{"even", []time.Duration{4 * ms, 1 * ms, 3 * ms, 2 * ms}, 3 * ms},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := median(tt.d); got != tt.want {
+				t.Errorf("median() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_compareSummarize(t *testing.T) {
+	ms := time.Millisecond
+	results := []compareDomain{
+		{Domain: "a.com", NextDNS: compareTiming{Cold: 30 * ms, Warm: 1 * ms, Hit: true}, System: compareTiming{Error: "timeout"}},
+		{Domain: "b.com", NextDNS: compareTiming{Cold: 10 * ms, Warm: 2 * ms, Hit: true}, System: compareTiming{Cold: 40 * ms, Warm: 20 * ms}},
+		{Domain: "c.com", NextDNS: compareTiming{Cold: 20 * ms, Warm: 8 * ms}, System: compareTiming{Error: "timeout"}},
+	}
+	got := compareSummarize("127.0.0.1:53", results, func(r compareDomain) compareTiming { return r.NextDNS })
+	want := compareSummary{Server: "127.0.0.1:53", ColdP50: 20 * ms, WarmP50: 2 * ms, HitRatio: 2.0 / 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("compareSummarize(nextdns) = %+v, want %+v", got, want)
+	}
+	got = compareSummarize("192.168.1.1:53", results, func(r compareDomain) compareTiming { return r.System })
+	want = compareSummary{Server: "192.168.1.1:53", ColdP50: 40 * ms, WarmP50: 20 * ms, Errors: 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("compareSummarize(system) = %+v, want %+v", got, want)
+	}
+	got = compareSummarize("192.168.1.1:53", nil, func(r compareDomain) compareTiming { return r.System })
+	if want := (compareSummary{Server: "192.168.1.1:53"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("compareSummarize(nil) = %+v, want %+v", got, want)
+	}
+}
@@ -1,10 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/secret"
 )
 
 func cfg(args []string) error {
@@ -19,13 +31,209 @@ func cfg(args []string) error {
 		var c config.Config
 		c.Parse("nextdns config list", args, true)
 		return c.Write(os.Stdout)
+	case "show":
+		var c config.Config
+		c.Parse("nextdns config show", args, true)
+		return c.Show(os.Stdout)
+	case "get":
+		var c config.Config
+		names := args
+		c.Parse("nextdns config get", nil, true)
+		if len(names) == 0 {
+			return errors.New("usage: config get NAME...")
+		}
+		for _, name := range names {
+			values, err := c.Get(name)
+			if err != nil {
+				return err
+			}
+			for _, v := range values {
+				fmt.Println(v)
+			}
+		}
+		return nil
 	case "set":
 		var c config.Config
 		c.Parse("nextdns config set", args, true)
-		return c.Save()
+		return saveConfig(c)
+	case "edit":
+		var c config.Config
+		c.Parse("nextdns config edit", args, true)
+		return editConfig(c)
+	case "secret":
+		if len(args) != 1 {
+			return errors.New("usage: config secret NAME")
+		}
+		return storeSecret(args[0])
+	case "keygen":
+		pub, priv, err := config.GenerateBundleKey()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("public-key %s\nprivate-key %s\n", pub, priv)
+		return nil
+	case "sign":
+		fs := flag.NewFlagSet("nextdns config sign", flag.ExitOnError)
+		keyFile := fs.String("key-file", "", "Path to the file containing the base64 encoded private key.")
+		serial := config.BundleSerial(time.Now().Unix())
+		fs.Var(&serial, "serial", "Serial of the bundle, higher than the one of the previous bundle.\n"+
+			"Defaults to the current time.")
+		_ = fs.Parse(args)
+		if *keyFile == "" || fs.NArg() != 1 {
+			return errors.New("usage: config sign -key-file FILE [-serial N] CONFIG_FILE")
+		}
+		key, err := ioutil.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		conf, err := ioutil.ReadFile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		b, err := config.SignBundle(conf, serial, string(key))
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(b)
+		return err
+	case "apply":
+		var c config.Config
+		c.Parse("nextdns config apply", args, true)
+		src := c.BundleURL
+		if src == "" {
+			return errors.New("usage: config apply -bundle-url URL_OR_PATH [-bundle-key KEY]")
+		}
+		b, err := fetchBundle(context.Background(), src)
+		if err != nil {
+			return err
+		}
+		if err := c.ApplyBundle(b); err != nil {
+			return err
+		}
+		return saveConfig(c)
 	default:
 		return errors.New("usage: \n" +
 			"  config [list]\n" +
-			"  config set [options]")
+			"  config show [options]\n" +
+			"  config get NAME...\n" +
+			"  config set [options]\n" +
+			"  config edit\n" +
+			"  config secret NAME\n" +
+			"  config keygen\n" +
+			"  config sign -key-file FILE [-serial N] CONFIG_FILE\n" +
+			"  config apply [-bundle-url URL_OR_PATH] [-bundle-key KEY]")
+	}
+}
+
+// editConfig opens the configuration of c in the user editor and saves it
+// once valid.
+func editConfig(c config.Config) error {
+	f, err := ioutil.TempFile("", "nextdns-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintln(f, "# Edit the nextdns configuration, one \"name value\" pair per line.")
+	fmt.Fprintln(f, "# List settings (i.e. forwarder) can be repeated. Lines starting with # are ignored.")
+	if err := c.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	p := prompter{r: bufio.NewReader(os.Stdin), w: os.Stdout}
+	var nc config.Config
+	for {
+		if err := runEditor(f.Name()); err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			return err
+		}
+		if err = nc.Read(bytes.NewReader(b)); err == nil {
+			break
+		}
+		fmt.Printf("Invalid configuration: %v\n", err)
+		if ok, err := p.confirm("Edit again?", true); err != nil {
+			return err
+		} else if !ok {
+			return errors.New("configuration not saved")
+		}
+	}
+	nc.File = c.File
+	return saveConfig(nc)
+}
+
+// runEditor opens file in the editor defined by VISUAL or EDITOR.
+func runEditor(file string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], file)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", editor, err)
+	}
+	return nil
+}
+
+// saveConfig saves c and reloads the service if the stored configuration
+// changed. In check mode, it only reports the settings that would change.
+func saveConfig(c config.Config) error {
+	if err := checkUpgradeKey(c); err != nil {
+		return withCode(exitConfig, err)
+	}
+	changes, err := configChanges(c)
+	if err != nil {
+		return err
+	}
+	if checkMode || len(changes) == 0 {
+		return reportChanges(changes)
+	}
+	if err := c.Save(); err != nil {
+		return withCode(exitConfig, err)
+	}
+	if err := reloadService(); err != nil {
+		return withCode(exitSystem, err)
+	}
+	return reportChanges(changes)
+}
+
+// reloadService restarts the service so it picks up the saved configuration.
+func reloadService() error {
+	if err := restartService(); err != nil {
+		return fmt.Errorf("configuration saved but the service could not be reloaded: %v", err)
+	}
+	return nil
+}
+
+// storeSecret stores the secret name read from stdin in the OS keychain.
+func storeSecret(name string) error {
+	st, ok := secret.Get("keychain").(secret.Storer)
+	if !ok {
+		return errors.New("keychain not supported on this platform")
+	}
+	fmt.Fprintf(os.Stderr, "Value for %s: ", name)
+	value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (err != io.EOF || value == "") {
+		return err
+	}
+	if err := st.Store(name, strings.TrimRight(value, "\r\n")); err != nil {
+		return err
 	}
+	fmt.Printf("Secret stored, reference it as ${secret:keychain:%s}\n", name)
+	return nil
 }
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/nextdns/nextdns/proxy"
+)
+
+// ACLs is a list of listener access control lists.
+type ACLs []proxy.ACL
+
+// String is the method to format the flag's value
+func (a *ACLs) String() string {
+	return fmt.Sprint(*a)
+}
+
+func (a *ACLs) Strings() []string {
+	if a == nil {
+		return nil
+	}
+	var ss []string
+	for _, acl := range *a {
+		ss = append(ss, acl.String())
+	}
+	return ss
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (a *ACLs) Set(value string) error {
+	acl, err := proxy.ParseACL(value)
+	if err != nil {
+		return err
+	}
+	for _, _a := range *a {
+		if acl.String() == _a.String() {
+			return nil
+		}
+	}
+	*a = append(*a, acl)
+	return nil
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nextdns/nextdns/host/service"
+)
+
+// A configuration bundle is a configuration in the configuration file format
+// followed by a serial and a signature line:
+//
+//	serial N
+//	signature BASE64
+//
+// The signature is the ed25519 signature of all the bytes preceding the
+// signature line, made with the operator private key. The serial must increase
+// with each bundle so an older bundle cannot be replayed.
+
+const (
+	bundleSerialPrefix    = "serial "
+	bundleSignaturePrefix = "signature "
+)
+
+// BundleSerial is the serial of a configuration bundle.
+type BundleSerial uint64
+
+func (s *BundleSerial) Set(v string) error {
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return err
+	}
+	*s = BundleSerial(n)
+	return nil
+}
+
+func (s BundleSerial) String() string {
+	return strconv.FormatUint(uint64(s), 10)
+}
+
+// ErrBundleUnsigned is returned by VerifyBundle when the bundle has no
+// signature.
+var ErrBundleUnsigned = errors.New("bundle is not signed")
+
+// GenerateBundleKey generates a new key pair to sign bundles with. Both keys
+// are base64 encoded.
+func GenerateBundleKey() (publicKey, privateKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv), nil
+}
+
+// SignBundle returns a bundle of the configuration conf with serial, signed
+// with the base64 encoded privateKey.
+func SignBundle(conf []byte, serial BundleSerial, privateKey string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(privateKey))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid private key")
+	}
+	b := append([]byte{}, conf...)
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		b = append(b, '\n')
+	}
+	b = append(b, bundleSerialPrefix...)
+	b = strconv.AppendUint(b, uint64(serial), 10)
+	b = append(b, '\n')
+	sig := ed25519.Sign(ed25519.PrivateKey(key), b)
+	b = append(b, bundleSignaturePrefix...)
+	b = append(b, base64.StdEncoding.EncodeToString(sig)...)
+	return append(b, '\n'), nil
+}
+
+// VerifyBundle checks the signature of bundle b against the base64 encoded
+// publicKey and returns the configuration it contains and its serial.
+func VerifyBundle(b []byte, publicKey string) (conf []byte, serial BundleSerial, err error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, 0, errors.New("invalid bundle key")
+	}
+	b = bytes.TrimRight(b, "\r\n")
+	idx := bytes.LastIndexByte(b, '\n') + 1
+	line := b[idx:]
+	if !bytes.HasPrefix(line, []byte(bundleSignaturePrefix)) {
+		return nil, 0, ErrBundleUnsigned
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(line[len(bundleSignaturePrefix):])))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid bundle signature: %v", err)
+	}
+	signed := b[:idx]
+	if !ed25519.Verify(ed25519.PublicKey(key), signed, sig) {
+		return nil, 0, errors.New("invalid bundle signature")
+	}
+	idx = bytes.LastIndexByte(bytes.TrimRight(signed, "\n"), '\n') + 1
+	line = bytes.TrimSpace(signed[idx:])
+	if !bytes.HasPrefix(line, []byte(bundleSerialPrefix)) {
+		return nil, 0, errors.New("bundle has no serial")
+	}
+	if err = serial.Set(string(line[len(bundleSerialPrefix):])); err != nil {
+		return nil, 0, fmt.Errorf("invalid bundle serial: %v", err)
+	}
+	return signed[:idx], serial, nil
+}
+
+// ApplyBundle verifies bundle b with the bundle key of c and replaces the
+// configuration of c with the one it contains. Settings not defined by the
+// bundle are reset to their default, except the vars file and bundle settings
+// which are kept so the device can receive the next updates: a bundle cannot
+// change them, rotating the bundle key is done on the device. Variables are
+// resolved using the vars file of c.
+//
+// A bundle with a serial lower than the one of the last applied bundle is
+// refused, and one with the same serial is ignored.
+func (c *Config) ApplyBundle(b []byte) error {
+	if c.BundleKey == "" {
+		return errors.New("missing bundle key")
+	}
+	conf, serial, err := VerifyBundle(b, c.BundleKey)
+	if err != nil {
+		return err
+	}
+	if serial < c.BundleSerial {
+		return fmt.Errorf("bundle serial %d is older than the applied one (%d)", serial, c.BundleSerial)
+	}
+	if serial == c.BundleSerial {
+		return nil
+	}
+	nc := Config{}
+	fs := nc.flagSet("bundle")
+	vars, err := loadVars(c.VarsFile)
+	if err != nil {
+		return err
+	}
+	if err := service.ReadConfig(bytes.NewReader(conf), nc.withVars(fs.storage, vars)); err != nil {
+		return err
+	}
+	nc.File = c.File
+	nc.VarsFile = c.VarsFile
+	nc.BundleURL = c.BundleURL
+	nc.BundleKey = c.BundleKey
+	nc.BundleRefresh = c.BundleRefresh
+	nc.BundleSerial = serial
+	*c = nc
+	return nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	pub, priv, err := GenerateBundleKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := GenerateBundleKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := []byte("listen :53\nconfig abcdef\nlog-queries true")
+	b, err := SignBundle(conf, 42, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		bundle  []byte
+		key     string
+		wantErr bool
+	}{
+		{"valid", b, pub, false},
+		{"wrong key", b, otherPub, true},
+		{"tampered", bytes.Replace(b, []byte("abcdef"), []byte("123456"), 1), pub, true},
+		{"tampered serial", bytes.Replace(b, []byte("serial 42"), []byte("serial 43"), 1), pub, true},
+		{"unsigned", conf, pub, true},
+		{"invalid key", b, "foo", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, serial, err := VerifyBundle(tt.bundle, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyBundle() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (string(got) != string(conf)+"\n" || serial != 42) {
+				t.Errorf("VerifyBundle() = %q, %d, want %q, 42", got, serial, conf)
+			}
+		})
+	}
+}
+
+func TestConfig_ApplyBundle(t *testing.T) {
+	pub, priv, err := GenerateBundleKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := GenerateBundleKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := SignBundle([]byte("listen :53\nlog-queries true\nbundle-url https://evil.example/bundle\nbundle-key "+otherPub+"\nbundle-serial 100\n"), 2, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Config{
+		Listen:       "localhost:53",
+		UseHosts:     false,
+		BundleURL:    "https://example.com/bundle",
+		BundleKey:    pub,
+		BundleSerial: 1,
+	}
+	if err := c.ApplyBundle(b); err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != ":53" || !c.LogQueries {
+		t.Errorf("bundle not applied: listen=%q log-queries=%v", c.Listen, c.LogQueries)
+	}
+	if !c.UseHosts {
+		t.Errorf("use-hosts not reset to its default")
+	}
+	if c.BundleURL != "https://example.com/bundle" || c.BundleKey != pub {
+		t.Errorf("bundle settings not kept: %q %q", c.BundleURL, c.BundleKey)
+	}
+	if c.BundleSerial != 2 {
+		t.Errorf("bundle-serial = %d, want 2", c.BundleSerial)
+	}
+
+	// The same bundle is ignored, an older one refused.
+	c.LogQueries = false
+	if err := c.ApplyBundle(b); err != nil || c.LogQueries {
+		t.Errorf("same bundle applied again: err=%v log-queries=%v", err, c.LogQueries)
+	}
+	old, err := SignBundle([]byte("listen :5353\n"), 1, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ApplyBundle(old); err == nil || c.Listen != ":53" {
+		t.Errorf("older bundle applied: err=%v listen=%q", err, c.Listen)
+	}
+}
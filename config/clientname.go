@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ClientName is a name set for the client with Addr as IP or MAC address.
+type ClientName struct {
+	Addr string
+	Name string
+}
+
+func (n ClientName) String() string {
+	return n.Addr + "=" + n.Name
+}
+
+// ClientNames is a list of client names set by the user.
+type ClientNames []ClientName
+
+// String is the method to format the flag's value
+func (c *ClientNames) String() string {
+	return fmt.Sprint(*c)
+}
+
+func (c *ClientNames) Strings() []string {
+	if c == nil {
+		return nil
+	}
+	var s []string
+	for _, n := range *c {
+		s = append(s, n.String())
+	}
+	return s
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (c *ClientNames) Set(value string) error {
+	idx := strings.IndexByte(value, '=')
+	if idx == -1 {
+		return fmt.Errorf("%s: missing name", value)
+	}
+	n := ClientName{
+		Addr: strings.ToLower(strings.TrimSpace(value[:idx])),
+		Name: strings.TrimSpace(value[idx+1:]),
+	}
+	if ip := net.ParseIP(n.Addr); ip != nil {
+		n.Addr = ip.String()
+	} else if mac, err := net.ParseMAC(n.Addr); err == nil {
+		n.Addr = mac.String()
+	} else {
+		return fmt.Errorf("%s: invalid IP or MAC address", n.Addr)
+	}
+	if n.Name == "" {
+		return fmt.Errorf("%s: missing name", value)
+	}
+	for i, _n := range *c {
+		if n.Addr == _n.Addr {
+			(*c)[i] = n
+			return nil
+		}
+	}
+	*c = append(*c, n)
+	return nil
+}
@@ -5,26 +5,183 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"runtime"
 	"time"
 
+	"github.com/nextdns/nextdns/ctl"
 	"github.com/nextdns/nextdns/host"
 	"github.com/nextdns/nextdns/host/service"
 )
 
 type Config struct {
 	File                 string
+	VarsFile             string
+	BundleURL            string
+	BundleKey            string
+	BundleRefresh        time.Duration
+	BundleSerial         BundleSerial
+	AutoUpgrade          bool
+	UpgradeChannel       string
+	UpgradeKey           string
 	Listen               string
+	ListenUnix           string
+	Instances            Instances
+	ACLs                 ACLs
+	ACLAction            string
+	ForwardedBy          StringList
+	RefuseAny            bool
+	MinimalResponses     bool
+	ExtendedErrors       bool
+	MaxUDPSize           int
+	MaxLabels            int
+	MaxOptions           int
+	MaxQuerySize         StringList
+	ParseErrorQuota      int
+	ParseErrorBan        time.Duration
+	ListenXDP            string
 	Conf                 Configs
+	DomainProfiles       DomainProfiles
 	Forwarders           Forwarders
+	Upstreams            Upstreams
+	WireGuard            string
 	LogQueries           bool
+	QueryHistory         string
+	HistoryRetention     time.Duration
+	LogAnonymize         string
+	LogExclude           StringList
 	ReportClientInfo     bool
+	ForwarderClientInfo  bool
+	StableClientID       bool
 	DetectCaptivePortals bool
+	CaptivePortalProbes  bool
+	FailMode             FailModes
 	HPM                  bool
+	UpstreamInterface    string
+	UpstreamBackup       string
+	UpstreamSource       StringList
+	UpstreamProxy        StringList
+	UpstreamFastOpen     bool
+	UpstreamKeepAlive    time.Duration
+	UpstreamECH          string
 	BogusPriv            bool
+	TrackPrefix          StringList
+	RebindProtection     bool
+	SpecialDomains       SpecialDomains
+	DoHCanary            bool
+	BlockDoHBypass       bool
+	BlockDDR             bool
+	PrivateRelay         string
+	CoalesceQueries      bool
+	NegativeCacheMaxTTL  time.Duration
+	Cache                string
+	CacheMaxTTL          time.Duration
+	CacheMaxMemory       int
+	DiscoveryPTR         bool
+	ClientNames          ClientNames
+	ClientsFile          string
+	DHCPLeaseFiles       StringList
 	UseHosts             bool
 	Timeout              time.Duration
+	AttemptTimeout       time.Duration
+	MaxAttempts          int
+	RetryBackoff         time.Duration
+	RetryMaxBackoff      time.Duration
+	SteeringInterval     time.Duration
+	LowPriority          StringList
+	MaxConcurrent        int
 	SetupRouter          bool
+	RouterMode           string
+	RouterHijack         bool
+	Intercept            StringList
+	InterceptExclude     StringList
 	AutoActivate         bool
+	DNSSEC               bool
+	DNSSECAnchorFile     string
+	Blocklists           StringList
+	Allowlists           StringList
+	BlocklistRefresh     time.Duration
+	QuietMaintenance     bool
+	BlockResponse        string
+	RulesSync            string
+	RulesSyncToken       string
+	RulesSyncListen      string
+	Rewrites             Rewrites
+	ResponseRewrites     ResponseRewrites
+	TTLMin               time.Duration
+	TTLMax               time.Duration
+	TTLExclude           StringList
+	SearchDomains        SearchDomains
+	Script               string
+	ZoneFiles            StringList
+	BlockAAAA            StringList
+	SVCBStrip            StringList
+	Provenance           StringList
+	Schedules            Schedules
+	User                 string
+	Group                string
+	Nice                 int
+	MemoryLimit          int
+	MaxInflight          int
+	GOGC                 int
+	GCBallast            int
+	IOClass              string
+	MDNSReflector        StringList
+	MDNSAdvertise        StringList
+	RDNSS                StringList
+	RDNSSDHCPv6          bool
+	Mirror               string
+	HealthLEDs           HealthLEDs
+	HealthCommand        string
+	HealthCheck          string
+	Container            bool
+	Docker               bool
+	DockerSocket         string
+	MirrorDomains        StringList
+	SLOWindow            time.Duration
+	SLOP50               time.Duration
+	SLOP95               time.Duration
+	SLOErrorRate         float64
+	SLOWebhook           string
+	DowngradeAlert       time.Duration
+	DowngradeWebhook     string
+	HijackCheck          time.Duration
+	AnomalyThreshold     float64
+	AnomalyInterval      time.Duration
+	AnomalySample        int
+	AnomalyWebhook       string
+	TunnelAction         string
+	TunnelLabelLength    int
+	TunnelEntropy        float64
+	TunnelTXTRate        int
+	WatchDomains         StringList
+	WatchInterval        time.Duration
+	WatchWebhook         string
+	ShareDomainCap       int
+	ShareAggregate       bool
+	EventsFile           string
+	EventsSocket         string
+	Control              string
+	Kiosk                bool
+	API                  string
+	APIToken             string
+	APIReadOnlyToken     string
+	APICert              string
+	APIKey               string
+	WebUI                string
+	WebUIPassword        string
+	Portal               string
+	PortalStateFile      string
+	BlockPage            string
+	BlockPagePassword    string
+	BlockPageAllow       time.Duration
+
+	// templates holds the values loaded from the configuration using
+	// variables.
+	templates map[string][]template
+
+	// sources holds the source of the settings not set to their default
+	// value.
+	sources map[string]string
 }
 
 func (c *Config) Parse(cmd string, args []string, useStorage bool) {
@@ -41,12 +198,12 @@ func (c *Config) Save() error {
 	if err != nil {
 		return err
 	}
-	return cs.SaveConfig(fs.storage)
+	return cs.SaveConfig(c.withTemplates(fs.storage))
 }
 
 func (c *Config) Write(w io.Writer) error {
 	fs := c.flagSet("")
-	for name, entry := range fs.storage {
+	for name, entry := range c.withSecretsHidden(fs.storage) {
 		if entry, ok := entry.(service.ConfigListEntry); ok {
 			for _, value := range entry.Strings() {
 				fmt.Fprintf(w, "%s %s\n", name, value)
@@ -67,7 +224,101 @@ func (c *Config) flagSet(cmd string) flagSet {
 		fs.flag = flag.NewFlagSet(" "+cmd, flag.ExitOnError)
 		fs.flag.StringVar(&c.File, "config-file", "", "Custom path to configuration file.")
 	}
-	fs.StringVar(&c.Listen, "listen", "localhost:53", "Listen address for UDP DNS proxy server.")
+	fs.StringVar(&c.VarsFile, "vars-file", "", "Path to a file defining the variables used in the configuration.\n"+
+		"\n"+
+		"Configuration values can reference variables as ${name}, resolved from the\n"+
+		"environment or from this file, composed of one \"name value\" pair per line. This\n"+
+		"lets a single configuration template be deployed on many sites.")
+	fs.StringVar(&c.BundleURL, "bundle-url", "", "URL or path of a signed configuration bundle to apply automatically.\n"+
+		"\n"+
+		"The bundle is checked every bundle-refresh and, when changed, applied if its signature\n"+
+		"is valid for bundle-key. The service is then restarted with the new configuration.\n"+
+		"Unsigned or invalid bundles are refused, as well as bundles with a serial lower than\n"+
+		"bundle-serial. The bundle settings are never changed by a bundle. See the config sign\n"+
+		"command to create a bundle.")
+	fs.StringVar(&c.BundleKey, "bundle-key", "", "Base64 encoded ed25519 public key bundles must be signed with.\n"+
+		"See the config keygen command to generate a key pair.")
+	fs.DurationVar(&c.BundleRefresh, "bundle-refresh", time.Hour, "Interval at which bundle-url is checked for updates.")
+	fs.Var(&c.BundleSerial, "bundle-serial", "Serial of the last applied bundle, set when a bundle is applied.")
+	fs.BoolVar(&c.AutoUpgrade, "auto-upgrade", false, "Automatically upgrade to the latest release of upgrade-channel.\n"+
+		"\n"+
+		"Releases are checked daily. The signature of the release is verified before the binary\n"+
+		"is replaced and the service restarted. Refused when no release key is available, see\n"+
+		"upgrade-key.")
+	fs.StringVar(&c.UpgradeChannel, "upgrade-channel", "stable", "Release channel used by auto-upgrade, stable or beta.")
+	fs.StringVar(&c.UpgradeKey, "upgrade-key", "", "Base64 encoded ed25519 public key releases must be signed with.\n"+
+		"Defaults to the key of the official releases, required for builds without it.")
+	fs.StringVar(&c.Listen, "listen", "localhost:53", "Listen address for UDP DNS proxy server.\n"+
+		"Multiple addresses can be specified as a comma separated list. The host\n"+
+		"can be an interface name (i.e. eth0:53) and an address can be prefixed by\n"+
+		"udp:// or tcp:// to only listen on this protocol.")
+	fs.Var(&c.Instances, "instance", "A named set of listen addresses resolved with its own configuration, as a name\n"+
+		"followed by space separated parameters: listen=ADDR (required, in the same format as\n"+
+		"listen), profile=ID and filter=off.\n"+
+		"\n"+
+		"The queries received on the addresses of an instance use its NextDNS configuration\n"+
+		"instead of the config conditions, and skip the local blocklists with filter=off. All\n"+
+		"instances share the cache and the upstream connections. For instance:\n"+
+		"\"unfiltered listen=192.168.1.2:53 profile=abcdef filter=off\".\n"+
+		"This parameter can be repeated.")
+	fs.StringVar(&c.ListenUnix, "listen-unix", "", "Path of a Unix domain socket to receive DNS queries on, using the DNS over TCP\n"+
+		"framing.\n"+
+		"\n"+
+		"Local processes and containers the socket is mounted into can query the proxy\n"+
+		"without network access. Queries are considered as coming from the loopback\n"+
+		"interface. The socket is writable by all users and must be in a directory writable\n"+
+		"by the user the daemon runs as. Windows named pipes are not supported: Windows 10\n"+
+		"and later support Unix domain sockets instead.")
+	fs.Var(&c.ACLs, "acl", "Networks allowed to send queries, as [LISTEN=]NET[,NET...].\n"+
+		"\n"+
+		"A NET can be a CIDR, an IP or private for private and link-local networks. When\n"+
+		"prefixed by one of the listen addresses (i.e. eth0:53=192.168.1.0/24), the ACL only\n"+
+		"applies to this listener, otherwise it applies to all listeners without their own ACL.\n"+
+		"Queries from other clients are handled according to acl-action, queries from the\n"+
+		"loopback interface are always allowed. This parameter can be repeated.")
+	fs.StringVar(&c.ACLAction, "acl-action", "refuse", "Action taken for queries denied by an ACL: refuse to answer them with REFUSED or\n"+
+		"drop to ignore them.")
+	fs.Var(&c.ForwardedBy, "forwarded-by", "IP or CIDR of a filtering resolver (i.e. AdGuard Home or Pi-hole) NextDNS is the\n"+
+		"upstream of. This parameter can be repeated.\n"+
+		"\n"+
+		"Only the forwarders (and the loopback interface) are allowed to send queries,\n"+
+		"replacing the acl parameter. Client discovery, setup-router and auto-activate are\n"+
+		"disabled, and the client identity passed by the forwarders with the EDNS client\n"+
+		"subnet (full address or network) and MAC (dnsmasq add-mac) options is used.")
+	fs.BoolVar(&c.RefuseAny, "refuse-any", false, "Answer ANY queries with a synthesized HINFO record (RFC 8482) instead of resolving them.")
+	fs.BoolVar(&c.MinimalResponses, "minimal-responses", false, "Remove the authority and additional records not needed by clients from responses.\n"+
+		"\n"+
+		"The SOA record of negative answers and the EDNS OPT record are kept.")
+	fs.BoolVar(&c.ExtendedErrors, "extended-errors", false, "Answer queries failing upstream with SERVFAIL instead of dropping them, and attach an\n"+
+		"Extended DNS Error (RFC 8914) to these responses and the blocked ones.\n"+
+		"\n"+
+		"The error code tells clients using EDNS whether a query failed on an upstream timeout\n"+
+		"(No Reachable Authority), a TLS or network error (Network Error), or was blocked\n"+
+		"(Blocked) or rate limited (Prohibited). Codes are also reported in query logs.")
+	fs.IntVar(&c.MaxUDPSize, "max-udp-size", 512, "Maximum size of the responses sent over UDP, from 64 to 512 bytes.\n"+
+		"\n"+
+		"Larger responses are replaced by an empty truncated response so clients retry over\n"+
+		"TCP. Lower values reduce the amplification of publicly reachable instances.")
+	fs.IntVar(&c.MaxLabels, "max-labels", 0, "Maximum number of labels of the queried names (0 for no limit).\n"+
+		"\n"+
+		"Queries exceeding the max-labels, max-options or max-query-size limits are refused\n"+
+		"and counted as parse errors.")
+	fs.IntVar(&c.MaxOptions, "max-options", 0, "Maximum number of EDNS options of a query (0 for no limit).")
+	fs.Var(&c.MaxQuerySize, "max-query-size", "Maximum size of a query in bytes, in the PROTOCOL=SIZE form (i.e. TCP=1024) with\n"+
+		"PROTOCOL either UDP or TCP. This parameter can be repeated.")
+	fs.IntVar(&c.ParseErrorQuota, "parse-error-quota", 0, "Number of queries failing to parse or exceeding the limits a client can send\n"+
+		"per minute before being banned (0 to disable).\n"+
+		"\n"+
+		"The queries of a banned client are dropped for parse-error-ban. Bans are logged\n"+
+		"and emitted as client.banned events.")
+	fs.DurationVar(&c.ParseErrorBan, "parse-error-ban", 10*time.Minute, "Duration of the bans triggered by parse-error-quota.")
+	fs.StringVar(&c.ListenXDP, "listen-xdp", "", "Experimental: network interface to receive DNS over UDP queries on with AF_XDP\n"+
+		"sockets, as IFACE[:PORT] (port 53 by default). Linux 4.18 or later only.\n"+
+		"\n"+
+		"The queries sent to the port of any address of the interface bypass the network\n"+
+		"stack of the kernel, for very high query rates. Firewall rules are not applied to\n"+
+		"them, and responses larger than about 2KB are truncated. The interface must not\n"+
+		"have another XDP program attached.")
 	fs.Var(&c.Conf, "config", "NextDNS custom configuration id.\n"+
 		"\n"+
 		"The configuration id can be prefixed with a condition that is match for each query:\n"+
@@ -76,39 +327,565 @@ func (c *Config) flagSet(cmd string) flagSet {
 		" to a specific host on the LAN.\n"+
 		"\n"+
 		"This parameter can be repeated. The first match wins.")
+	fs.Var(&c.DomainProfiles, "domain-profile", "NextDNS configuration id used for the queries of a domain, as DOMAIN=ID.\n"+
+		"\n"+
+		"The domain matches itself and its sub-domains, or only its sub-domains when prefixed\n"+
+		"with *. (i.e. *.work.example=abcdef). Domain rules take precedence over the config\n"+
+		"conditions and schedules, and apply to all clients. Use forwarder to send a domain\n"+
+		"to another upstream.\n"+
+		"\n"+
+		"This parameter can be repeated. The first match wins.")
 	fs.Var(&c.Forwarders, "forwarder", "A DNS server to use for a specified domain.\n"+
 		"\n"+
 		"Forwarders can be defined to send proxy DNS traffic to an alternative DNS upstream\n"+
-		"resolver for specific domains. The format of this parameter is \n"+
+		"resolver for specific domains. The format of this parameter is\n"+
 		"[DOMAIN=]SERVER_ADDR[,SERVER_ADDR...].\n"+
 		"\n"+
-		"A SERVER_ADDR can ben either an IP[:PORT] for DNS53 (unencrypted UDP, TCP), or a HTTPS\n"+
-		"URL for a DNS over HTTPS server. For DoH, a bootstrap IP can be specified as follow:\n"+
-		"https://dns.nextdns.io#45.90.28.0. Several servers can be specified, separated by\n"+
-		"comas to implement failover."+
+		"A SERVER_ADDR can be either an IP[:PORT] for DNS53 (unencrypted UDP, TCP), a HTTPS\n"+
+		"URL for a DNS over HTTPS server, a tls://HOST[:PORT] URL for a DNS over TLS server\n"+
+		"or the name of an upstream defined with -upstream. For DoH and DoT, a bootstrap IP\n"+
+		"can be specified as follow: https://dns.nextdns.io#45.90.28.0. Several servers can\n"+
+		"be specified, separated by comas to implement failover.\n"+
 		"\n"+
 		"This parameter can be repeated. The first match wins.")
+	fs.Var(&c.Upstreams, "upstream", "A named DNS upstream to use in forwarder rules.\n"+
+		"\n"+
+		"The format of this parameter is NAME SERVER_ADDR[,SERVER_ADDR...] [KEY=VALUE...],\n"+
+		"with SERVER_ADDR as for forwarder. Supported keys are:\n"+
+		"\n"+
+		"* bootstrap=IP[,IP...]: the IPs of the DoH and DoT servers, so no DNS request is\n"+
+		"  needed to reach them.\n"+
+		"* pin=BASE64: the base64 encoded SHA-256 digest of a public key the certificate\n"+
+		"  chain of the servers must contain. Can be repeated.\n"+
+		"* header=NAME:VALUE: an HTTP header (with URL encoded value) added to DoH requests.\n"+
+		"  Can be repeated.\n"+
+		"\n"+
+		"The upstream can then be referenced by NAME in place of the server address of a\n"+
+		"forwarder (i.e. corp.example=work). This parameter can be repeated.")
+	fs.StringVar(&c.WireGuard, "wireguard", "", "Path of a wg-quick configuration file of a WireGuard peer the DNS53 forwarders are\n"+
+		"reached through.\n"+
+		"\n"+
+		"The tunnel is run from user space, only the queries sent to the forwarders go through\n"+
+		"it and no interface or route is created on the system. The forwarder servers must be\n"+
+		"IPs reachable through the peer, DoH forwarders and named upstreams are not affected.")
 	fs.BoolVar(&c.LogQueries, "log-queries", false, "Log DNS query.")
+	fs.StringVar(&c.QueryHistory, "query-history", "", "Directory to retain the query history in for export (i.e. /var/lib/nextdns/history).\n"+
+		"\n"+
+		"Queries are written in one newline delimited JSON file per day, and can be exported\n"+
+		"to CSV or Parquet with the export command. If empty, no history is retained.")
+	fs.DurationVar(&c.HistoryRetention, "query-history-retention", 7*24*time.Hour, "Duration the query history is retained (0 to keep it forever).")
+	fs.StringVar(&c.LogAnonymize, "log-anonymize", "", "Anonymize the clients of the queries recorded locally (log-queries, query-history\n"+
+		"and web-ui).\n"+
+		"\n"+
+		"* truncate: only keep the network part of client IPs (/24 for IPv4, /48 for IPv6).\n"+
+		"* hash: replace client IPs with a pseudonym derived from a keyed hash. The key is\n"+
+		"  random and renewed each time the daemon starts.\n"+
+		"\n"+
+		"In both modes, MAC addresses and device names are not recorded.")
+	fs.Var(&c.LogExclude, "log-exclude", "A domain which queries, sub-domains included, are never recorded locally (log-queries,\n"+
+		"query-history and web-ui). This parameter can be repeated.")
 	fs.BoolVar(&c.ReportClientInfo, "report-client-info", false, "Embed clients information with queries.")
+	fs.BoolVar(&c.ForwarderClientInfo, "forwarder-client-info", true, "Identify clients to forwarders when report-client-info is enabled.\n"+
+		"\n"+
+		"Queries sent to forwarders carry the MAC address of the client when known and its\n"+
+		"device ID as EDNS0 options, in the format of dnsmasq add-mac and add-cpe-id, so the\n"+
+		"upstream can apply per-device settings. Set to false to keep them private.")
+	fs.BoolVar(&c.StableClientID, "stable-client-id", false,
+		"Identify LAN clients by their MAC address rather than their IP in query logs and anomaly\n"+
+			"detection, so the rotating IPv6 privacy addresses of a device are aggregated into a\n"+
+			"single client. The MAC is learned from the neighbor tables (SLAAC) or from the DUID of\n"+
+			"DHCPv6 leases.")
 	fs.BoolVar(&c.DetectCaptivePortals, "detect-captive-portals", false,
 		"Automatic detection of captive portals and fallback on system DNS to allow the connection.\n"+
 			"\n"+
 			"Beware that enabling this feature can allow an attacker to force nextdns to disable DoH\n"+
 			"and leak unencrypted DNS traffic.")
+	fs.BoolVar(&c.CaptivePortalProbes, "captive-portal-probes", true,
+		"Resolve the names used by operating systems to detect captive portals with the network\n"+
+			"provided DNS servers while DoH is intercepted by a captive portal, so the portal login\n"+
+			"page can show up. Other queries stay on DoH and the portal detection ends as soon as\n"+
+			"DoH works again.")
+	fs.Var(&c.FailMode, "fail-mode", "Behavior when NextDNS is unreachable, as MODE or CONDITION=MODE.\n"+
+		"\n"+
+		"MODE is one of auto (fall back on system DNS for 10 minutes after startup or a\n"+
+		"network change, i.e. to get through a captive portal, and fail otherwise), open\n"+
+		"(always fall back on system DNS, resolving without filtering) or closed (answer\n"+
+		"queries with SERVFAIL). CONDITION is a client subnet or MAC address overriding the\n"+
+		"global mode for some networks. This parameter can be repeated. Defaults to auto.")
 	fs.BoolVar(&c.HPM, "hardened-privacy", false,
 		"When enabled, use DNS servers located in jurisdictions with strong privacy laws.\n"+
 			"Available locations are: Switzerland, Iceland, Finland, Panama and Hong Kong.")
+	fs.StringVar(&c.UpstreamInterface, "upstream-interface", "", "Network interface the DoH traffic to NextDNS is sent through (i.e. wan2 or tun0),\n"+
+		"for multi-WAN routers or VPN setups. Supported on Linux (SO_BINDTODEVICE) and macOS\n"+
+		"(IP_BOUND_IF). The plain DNS fallback is not pinned.")
+	fs.StringVar(&c.UpstreamBackup, "upstream-backup-interface", "", "Network interface the DoH traffic to NextDNS is moved to while the path through\n"+
+		"upstream-interface is down (i.e. an LTE dongle backing up the WAN link). The primary\n"+
+		"path is checked every 10s, the traffic is moved after 3 failed checks and back after\n"+
+		"3 successful ones. Requires upstream-interface and cannot be combined with\n"+
+		"upstream-source.")
+	fs.Var(&c.UpstreamSource, "upstream-source", "Source IP address of the DoH traffic to NextDNS. Can be repeated to set both an\n"+
+		"IPv4 and an IPv6 address. When set, upstream servers of a family without source\n"+
+		"address are not reachable.")
+	fs.Var(&c.UpstreamProxy, "upstream-proxy", "Route DoH connections through a SOCKS5 or HTTP CONNECT proxy, for networks where\n"+
+		"direct 443 egress is blocked. The value is [DOMAIN=]URL, with URL in the\n"+
+		"socks5://[user:pass@]host:port or http://[user:pass@]host:port form. When DOMAIN is\n"+
+		"set, only the upstream servers with this hostname or a subdomain of it (i.e.\n"+
+		"nextdns.io, or dns.google for a forwarder) use the proxy. This parameter can be\n"+
+		"repeated, the first matching rule applies. The plain DNS fallback is not proxied.")
+	fs.BoolVar(&c.UpstreamFastOpen, "upstream-fast-open", true, "Use TCP Fast Open for the DoH connections, sending the TLS handshake in the SYN\n"+
+		"when reconnecting to a known server. Supported on Linux 4.11+ (TCP_FASTOPEN_CONNECT),\n"+
+		"ignored elsewhere or when disabled by the net.ipv4.tcp_fastopen sysctl.")
+	fs.DurationVar(&c.UpstreamKeepAlive, "upstream-keepalive", 30*time.Second, "Interval of the TCP keepalive probes sent on idle DoH connections, keeping them\n"+
+		"open through NATs dropping idle flows. Set to 0 to disable.")
+	fs.StringVar(&c.UpstreamECH, "upstream-ech", "off", "Hide the hostname of the DoH and DoT upstream servers from on-path observers with\n"+
+		"Encrypted Client Hello: off, prefer or require. The ECH configuration is looked up\n"+
+		"in the HTTPS record of the server, asked to the server itself over DoH or DoT so\n"+
+		"the answer is authenticated by its certificate. With prefer, servers without a\n"+
+		"configuration are contacted without ECH; with require, the connections to them\n"+
+		"fail.")
 	fs.BoolVar(&c.BogusPriv, "bogus-priv", true, "Bogus private reverse lookups.\n"+
 		"\n"+
 		"All reverse lookups for private IP ranges (ie 192.168.x.x, etc.) are answered with\n"+
 		"\"no such domain\" rather than being forwarded upstream. The set of prefixes affected\n"+
 		"is the list given in RFC6303, for IPv4 and IPv6.")
+	fs.BoolVar(&c.DiscoveryPTR, "discovery-ptr", false, "Answer reverse lookups on private subnets using discovered LAN client names.\n"+
+		"\n"+
+		"Client names are learned from DHCP leases, mDNS, NetBIOS, LLMNR and the router host\n"+
+		"table. Addresses with no known name fall back to bogus-priv behavior.")
+	fs.Var(&c.ClientNames, "client-name", "Name of a LAN client, as IP or MAC address=name (i.e. 00:11:22:33:44:55=tv).\n"+
+		"\n"+
+		"Names set this way take precedence over the discovered ones. Discovered names are\n"+
+		"picked from the most trusted source knowing the client: hosts file, DHCP leases,\n"+
+		"mDNS, local DNS and finally LLMNR and NetBIOS probes. Use \"nextdns ctl clients\"\n"+
+		"to see the winning source of each client. This parameter can be repeated.")
+	fs.StringVar(&c.ClientsFile, "clients-file", "", "Path to a file naming LAN clients and optionally setting their profile, for\n"+
+		"networks where clients cannot be discovered (i.e. behind another router).\n"+
+		"\n"+
+		"Each line holds an IP or MAC address, a name and an optional profile separated\n"+
+		"by spaces (i.e. \"00:11:22:33:44:55 tv abcdef\"). Lines starting with # are\n"+
+		"ignored. Profiles set in the file take precedence over the -config conditions.\n"+
+		"The file is reloaded when modified.")
+	fs.Var(&c.DHCPLeaseFiles, "dhcp-lease-file", "Lease file of a DHCP server to name LAN clients from, as PATH or FORMAT=PATH.\n"+
+		"\n"+
+		"FORMAT is isc-dhcpd, dnsmasq or kea (memfile CSV or lease4-get-all JSON output),\n"+
+		"and is detected from the content when omitted. The file can be shared from another\n"+
+		"host (i.e. over NFS) when the DHCP server does not run on this one. Files are\n"+
+		"reloaded when modified. This parameter can be repeated. By default, the lease file\n"+
+		"of a local DHCP server is searched in the well known locations.")
+	fs.Var(&c.TrackPrefix, "track-prefix", "Track the IPv6 prefix delegated to this LAN interface (i.e. br-lan).\n"+
+		"\n"+
+		"When the ISP rotates the prefix, rewrite rules with prefix relative IPv6 addresses\n"+
+		"(i.e. nas.lan=::10) follow the new prefix, and reverse lookups and rebinding\n"+
+		"protection cover the addresses of the new prefix. This parameter can be repeated,\n"+
+		"relative addresses are completed with the prefix of the first interface.")
+	fs.BoolVar(&c.RebindProtection, "rebind-protection", false, "Remove private and LAN addresses from the answers of the upstream resolver.\n"+
+		"\n"+
+		"Protects LAN devices against DNS rebinding attacks. Answers of conditional\n"+
+		"forwarders, rewrite rules and /etc/hosts are not affected.")
+	fs.Var(&c.SpecialDomains, "special-domain", "Action for the queries of a special-use or private zone, as ZONE=ACTION.\n"+
+		"\n"+
+		"Actions are nxdomain, refuse, nodata, forward (send upstream) and local (send to the\n"+
+		"network provided DNS servers). Built-in rules answer NXDOMAIN for .local, .onion,\n"+
+		".invalid, home.arpa, wpad and the reverse zones of private addresses instead of\n"+
+		"leaking them upstream. They are overridden by rules for the same zone (i.e.\n"+
+		"home.arpa=local).\n"+
+		"Conditional forwarders take precedence. This parameter can be repeated.")
+	fs.BoolVar(&c.DoHCanary, "doh-canary", true, "Answer NXDOMAIN for the browser DoH canary domain (use-application-dns.net).\n"+
+		"\n"+
+		"Browsers like Firefox check this domain before enabling their own DoH resolver by\n"+
+		"default, which would bypass this resolver and its configuration.")
+	fs.BoolVar(&c.BlockDoHBypass, "block-doh-bypass", false, "Answer NXDOMAIN for the hostnames of well-known public DoH resolvers (i.e. dns.google or\n"+
+		"cloudflare-dns.com), so clients cannot bypass this resolver with their own encrypted\n"+
+		"DNS. The queries of the host itself are not affected. Use -special-domain to allow one\n"+
+		"of them (i.e. dns.google=forward).")
+	fs.BoolVar(&c.BlockDDR, "block-ddr", false, "Answer NODATA for resolver.arpa, queried by clients supporting Discovery of\n"+
+		"Designated Resolvers (DDR, RFC 9462).\n"+
+		"\n"+
+		"Without it, the query is forwarded upstream, which may designate its own encrypted\n"+
+		"endpoints: clients upgrading to them would bypass the proxy and its client\n"+
+		"identification. Compliant clients only upgrade when the certificate of the designated\n"+
+		"resolver covers the IP of the proxy, which the upstream one does not, so the query is\n"+
+		"left open by default. Enable for clients doing less strict checks; nextdns has no\n"+
+		"local encrypted endpoint to designate itself.")
+	fs.StringVar(&c.PrivateRelay, "private-relay", "", "Detect Apple devices checking if iCloud Private Relay can be used, and log or block.\n"+
+		"\n"+
+		"With block, mask.icloud.com and mask-h2.icloud.com are answered with NXDOMAIN, which\n"+
+		"tells the devices Private Relay is not allowed on the network so their queries keep\n"+
+		"following its DNS policy. Users are notified on their device. Detections are logged\n"+
+		"once per hour per client.")
+	fs.BoolVar(&c.CoalesceQueries, "coalesce-queries", true, "Send identical queries received at the same time upstream only once.\n"+
+		"\n"+
+		"Clients asking for the same name while the query is in flight (i.e. after waking from\n"+
+		"sleep) share the upstream response. Queries of clients using different configurations\n"+
+		"or reported separately with report-client-info are not coalesced.")
+	fs.DurationVar(&c.NegativeCacheMaxTTL, "negative-cache-max-ttl", 5*time.Minute, "Maximum duration NXDOMAIN and NODATA answers are cached (0 to disable).\n"+
+		"\n"+
+		"Negative answers are cached for the TTL given by the SOA record of their authority\n"+
+		"section (RFC 2308), capped by this value, so clients repeatedly asking for names that\n"+
+		"do not exist do not generate constant upstream traffic.")
+	fs.StringVar(&c.Cache, "cache", "", "Cache positive answers in a backend: memory, redis://[:PASSWORD@]HOST:PORT[/DB]\n"+
+		"or memcached://HOST:PORT.\n"+
+		"\n"+
+		"Answers are cached for their lowest TTL, capped by cache-max-ttl, separately for each\n"+
+		"configuration (and client with report-client-info). Redis and memcached let several\n"+
+		"instances behind a load balancer share their cache. Queries are sent upstream while\n"+
+		"the backend is unreachable.")
+	fs.DurationVar(&c.CacheMaxTTL, "cache-max-ttl", time.Hour, "Maximum duration answers are kept in the cache (0 for no limit).")
+	fs.IntVar(&c.CacheMaxMemory, "cache-max-memory", 0, "Maximum memory used by the memory cache in MB, keys and answers included.\n"+
+		"\n"+
+		"The least useful entries are evicted to stay under it. If 0, the memory cache is\n"+
+		"limited to 10000 answers.")
 	fs.BoolVar(&c.UseHosts, "use-hosts", true, "Lookup /etc/hosts before sending queries to upstream resolver.")
 	fs.DurationVar(&c.Timeout, "timeout", 5*time.Second, "Maximum duration allowed for a request before failing.")
+	fs.DurationVar(&c.AttemptTimeout, "attempt-timeout", 2*time.Second, "Maximum duration of each attempt to send a request upstream (0 for timeout).")
+	fs.IntVar(&c.MaxAttempts, "max-attempts", 3, "Maximum number of attempts to send a request upstream within timeout.\n"+
+		"\n"+
+		"Requests failed with a connection error are retried right away on the next\n"+
+		"upstream server. Other errors, like timeouts, are retried on the same server after\n"+
+		"an exponential backoff with jitter.")
+	fs.DurationVar(&c.RetryBackoff, "retry-backoff", 100*time.Millisecond, "Delay before the first retry, doubled on each retry up to retry-max-backoff.")
+	fs.DurationVar(&c.RetryMaxBackoff, "retry-max-backoff", time.Second, "Maximum delay between two retries.")
+	fs.DurationVar(&c.SteeringInterval, "steering-interval", 5*time.Minute, "Interval between two RTT measurements of the NextDNS endpoints (0 to disable).\n"+
+		"\n"+
+		"Queries are steered to the fastest endpoint instead of only failing over on\n"+
+		"errors. An endpoint must be consistently faster by 20% to be switched to, and RTTs\n"+
+		"are measured again right away when the network changes.")
+	fs.Var(&c.LowPriority, "low-priority", "An IP, CIDR or MAC address of low priority clients (i.e. an IoT VLAN).\n"+
+		"\n"+
+		"When max-concurrent upstream queries are in flight, queries from low priority\n"+
+		"clients wait until no query from other clients is waiting, keeping interactive\n"+
+		"lookups fast under load. This parameter can be repeated.")
+	fs.IntVar(&c.MaxConcurrent, "max-concurrent", 32, "Maximum number of concurrent upstream queries when low-priority is set.")
+	fs.StringVar(&c.Mirror, "mirror", "", "Send a copy of queries to a secondary destination for archival or intrusion detection.\n"+
+		"\n"+
+		"The destination is either a DNS server (i.e. 192.168.1.5:53), whose responses are\n"+
+		"ignored, or a dnstap collector receiving queries and responses\n"+
+		"(dnstap+unix:///path/to/socket or dnstap+tcp://host:port). Mirroring is\n"+
+		"asynchronous and never delays answers: queries are dropped if the destination is\n"+
+		"slow or unavailable.")
+	fs.Var(&c.MirrorDomains, "mirror-domain", "Only mirror queries for this domain and its sub-domains.\n"+
+		"This parameter can be repeated. All queries are mirrored if unset.")
+	fs.Var(&c.HealthLEDs, "health-led", "A sysfs LED reflecting the daemon health, as STATE=PATH[,blink].\n"+
+		"\n"+
+		"STATE is one of ok (queries resolved over DoH), degraded (plain DNS fallback, i.e.\n"+
+		"behind a captive portal) or down (queries failing). PATH is a LED directory like\n"+
+		"/sys/class/leds/green:status. The LED is lit while the daemon health is in STATE\n"+
+		"and turned off otherwise. This parameter can be repeated.")
+	fs.StringVar(&c.HealthCommand, "health-command", "", "A command run with the new health state (ok, degraded or down) as argument each\n"+
+		"time it changes.")
+	fs.StringVar(&c.HealthCheck, "health-check", "", "Listen address of an HTTP server answering /healthz with the daemon health.\n"+
+		"\n"+
+		"The response is a JSON object with a status of ok, degraded or down. The status\n"+
+		"code is 503 when down and 200 otherwise, for use by load balancers and monitoring.")
+	fs.BoolVar(&c.Container, "container", false, "Run as the entrypoint of a container (Docker, Podman…).\n"+
+		"\n"+
+		"Logs are written to the console, orphaned processes are reaped when running as\n"+
+		"PID 1, and the settings changing the host (auto-activate, setup-router, intercept\n"+
+		"and auto-upgrade) are ignored. The health-check server defaults to 127.0.0.1:8053\n"+
+		"so the healthcheck command can be used as the container health check. When the\n"+
+		"container uses the Docker embedded DNS server (127.0.0.11), single-label names are\n"+
+		"resolved by it so the names of the other containers keep resolving.")
+	fs.BoolVar(&c.Docker, "docker", false, "Learn the names and addresses of the running Docker containers from docker-socket.\n"+
+		"\n"+
+		"Containers sending queries are named after their container name, and queries for\n"+
+		"NAME.docker are answered locally with the addresses of the container NAME.")
+	fs.StringVar(&c.DockerSocket, "docker-socket", "/var/run/docker.sock", "Path of the Docker Engine API socket used by docker.")
 	fs.BoolVar(&c.SetupRouter, "setup-router", false, "Automatically configure NextDNS for a router setup.\n"+
-		"Common types of router are detected to integrate gracefuly. Changes applies are\n"+
+		"Common types of router are detected to integrate gracefully. Changes applied are\n"+
 		"undone on daemon exit. The listen option is ignored when this option is used.")
+	fs.StringVar(&c.RouterMode, "router-mode", "forward", "How NextDNS is integrated with the router DNS server when setup-router is enabled.\n"+
+		"\n"+
+		"With forward, the router DNS server (i.e. dnsmasq) is kept and forwards queries to\n"+
+		"NextDNS. With takeover, NextDNS listens on port 53 in place of the router DNS server\n"+
+		"and is advertised to DHCP clients. Only supported on OpenWrt and OPNsense, pfSense\n"+
+		"always uses takeover.")
+	fs.BoolVar(&c.RouterHijack, "router-hijack", false, "Redirect DNS queries sent by LAN clients to other DNS servers to the router when\n"+
+		"setup-router is enabled.\n"+
+		"\n"+
+		"Devices with hard coded DNS servers (i.e. 8.8.8.8) are forced to use NextDNS using\n"+
+		"firewall NAT rules (IPv4 only). Supported on ASUSWRT-Merlin and EdgeOS.")
+	fs.Var(&c.Intercept, "intercept", "Redirect all DNS queries received on this interface to NextDNS, whatever their\n"+
+		"destination.\n"+
+		"\n"+
+		"Firewall rules are installed using nftables or iptables on Linux and a pf anchor on\n"+
+		"BSD and macOS, and removed on exit. This forces devices ignoring the DNS server\n"+
+		"advertised by DHCP to use NextDNS. This parameter can be repeated.")
+	fs.Var(&c.InterceptExclude, "intercept-exclude", "An IP or CIDR of clients to exclude from DNS interception.\n"+
+		"This parameter can be repeated.")
 	fs.BoolVar(&c.AutoActivate, "auto-activate", false, "Run activate at startup and deactivate on exit.")
+	fs.Var(&c.Blocklists, "blocklist", "A list of domains to block locally.\n"+
+		"\n"+
+		"The list can be a local file path or an HTTP(S) URL. Both hosts file format and domain\n"+
+		"list format (one domain per line) are supported. Domains can be prefixed by *. to\n"+
+		"match sub-domains or use glob patterns.\n"+
+		"This parameter can be repeated.")
+	fs.Var(&c.Allowlists, "allowlist", "A list of domains to never block locally, in the same format as blocklist.\n"+
+		"This parameter can be repeated.")
+	fs.DurationVar(&c.BlocklistRefresh, "blocklist-refresh", 24*time.Hour, "Interval at which block and allow lists are reloaded.")
+	fs.BoolVar(&c.QuietMaintenance, "quiet-maintenance", false, "Run maintenance once a day during the quietest hour of the network.\n"+
+		"\n"+
+		"The quiet hours are learned from the query volume of each hour of the week, seeded\n"+
+		"from query-history when enabled. Maintenance runs at 4am until enough traffic has been\n"+
+		"observed. It reloads block and allow lists and rules-sync, replacing blocklist-refresh,\n"+
+		"and purges expired entries of the negative cache.")
+	fs.StringVar(&c.BlockResponse, "block-response", "nxdomain", "Response sent for blocked domains.\n"+
+		"\n"+
+		"Can be nxdomain, null (0.0.0.0 and ::) or an IPv4 and/or IPv6 address, separated by\n"+
+		"a comma.")
+	fs.StringVar(&c.RulesSync, "rules-sync", "", "File path or HTTP(S) URL of the block and allow rules of another instance to apply.\n"+
+		"\n"+
+		"Lets a laptop apply the same local rules as the home router, i.e. served with\n"+
+		"rules-sync-listen. Synced rules are merged with the local lists, refreshed every\n"+
+		"blocklist-refresh and kept when the source is unreachable.")
+	fs.StringVar(&c.RulesSyncToken, "rules-sync-token", "", "Bearer token sent to rules-sync and required by rules-sync-listen.")
+	fs.StringVar(&c.RulesSyncListen, "rules-sync-listen", "", "Address to serve the block and allow rules over HTTP for other instances\n"+
+		"to sync from with rules-sync.")
+	fs.Var(&c.Rewrites, "rewrite", "A rule rewriting queries locally, as pattern=target.\n"+
+		"\n"+
+		"The pattern can be a domain (example.com), a wildcard (*.example.com) or a regular\n"+
+		"expression between slashes (/^(.*)\\.lan$/). The target can be a comma separated list of\n"+
+		"IP addresses answered locally, a domain name the query is rewritten to (returned as a\n"+
+		"CNAME, regexp sub-matches can be referenced using ${1}), or flatten to collapse CNAME\n"+
+		"chains returned by the upstream. The flag can be repeated, the first matching rule is used.")
+	fs.Var(&c.SearchDomains, "search-domain", "A DNS search domain completing single-label queries, as DOMAIN or\n"+
+		"CONDITION=DOMAIN where CONDITION is a subnet or a MAC address.\n"+
+		"\n"+
+		"A and AAAA queries for a name without dot (i.e. nas) are resolved as the name\n"+
+		"followed by the domain (nas.lan), answered with a CNAME. When setup-router is used,\n"+
+		"the domains are also advertised to DHCP clients, per subnet for subnet conditions.\n"+
+		"The flag can be repeated, a domain with a condition takes precedence over the global one.")
+	fs.Var(&c.ZoneFiles, "zone-file", "A zone file (RFC 1035 format) answered authoritatively, as PATH or ORIGIN=PATH.\n"+
+		"\n"+
+		"The zone is named after the owner of its SOA record. ORIGIN completes the relative\n"+
+		"names of files without an $ORIGIN directive. Files are reloaded when modified, and\n"+
+		"zone transfers are refused. This parameter can be repeated.")
+	fs.Var(&c.ResponseRewrites, "response-rewrite", "A rule modifying responses before they are sent to clients, as a\n"+
+		"name pattern followed by space separated parameters.\n"+
+		"\n"+
+		"Responses can be selected with type=TYPE and answer=IP/CIDR. Actions are replace=IP,\n"+
+		"drop, ttl-min=SECONDS, ttl-max=SECONDS and add=TYPE:VALUE (A, AAAA, CNAME or TXT).\n"+
+		"For instance: \"*.example.com answer=10.0.0.0/8 replace=192.168.1.10 ttl-max=300\".\n"+
+		"The flag can be repeated, all matching rules are applied in order.")
+	fs.DurationVar(&c.TTLMin, "ttl-min", 0, "Minimum TTL of the records sent to clients (0 to disable).\n"+
+		"\n"+
+		"Lower TTLs are raised to this value, i.e. 60s to limit the queries of applications\n"+
+		"using very low TTLs on metered links. This does not change how long answers are cached.")
+	fs.DurationVar(&c.TTLMax, "ttl-max", 0, "Maximum TTL of the records sent to clients (0 to disable).")
+	fs.Var(&c.TTLExclude, "ttl-exclude", "A domain whose responses are sent with their original TTL despite ttl-min and\n"+
+		"ttl-max, with its sub-domains. This parameter can be repeated.")
+	fs.StringVar(&c.Script, "script", "", "Path of a Lua script deciding how queries are resolved.\n"+
+		"\n"+
+		"The query(q) function of the script is called with each query forwarded upstream and\n"+
+		"can block it, answer it with addresses, rewrite it to another name or route it to an\n"+
+		"upstream defined with the upstream parameter. An optional response(q, r) function\n"+
+		"can block or replace the responses. Scripts run in a sandbox without access to files\n"+
+		"or the network, and queries are resolved normally when the script fails or takes\n"+
+		"longer than 20ms.")
+	fs.Var(&c.BlockAAAA, "block-aaaa", "Suppress the AAAA answers (IPv6 addresses) sent to clients, for networks with broken\n"+
+		"IPv6. The value is an IP, CIDR or MAC address of the clients, or \"all\" for all\n"+
+		"clients. AAAA queries are still resolved, their answers are replaced with an empty\n"+
+		"(NODATA) response. This parameter can be repeated.")
+	fs.Var(&c.SVCBStrip, "svcb-strip", "A parameter removed from the SVCB and HTTPS records sent to clients: alpn,\n"+
+		"port, ipv4hint, ech or ipv6hint. Removing ech prevents clients from using Encrypted\n"+
+		"Client Hello, i.e. for networks inspecting TLS server names. Parameters are preserved\n"+
+		"by default. This parameter can be repeated.")
+	fs.Var(&c.Provenance, "provenance", "Describe where responses come from (cache, upstream endpoint, blocking rule or local\n"+
+		"answer) in the responses sent to clients, for debugging on test machines.\n"+
+		"\n"+
+		"The description is sent as an Extended DNS Error (RFC 8914) text to clients using EDNS,\n"+
+		"or as a TXT record of the additional section named provenance.nextdns. otherwise. The\n"+
+		"value is an IP, CIDR or MAC address of the clients, or \"all\" for all clients. This\n"+
+		"parameter can be repeated.")
+	fs.Var(&c.Schedules, "schedule", "A rule restricting the resolution of some domains or switching the configuration\n"+
+		"of clients during a time window, as space separated key=value parameters.\n"+
+		"\n"+
+		"The domains parameter is a comma separated list of domains (sub-domains included) and\n"+
+		"paths or URLs of domain lists. The window is defined by days (mon-fri or sat,sun) and\n"+
+		"hours (18:00-23:00), evaluated in the tz time zone (local time by default). The rule\n"+
+		"can be scoped to clients, a comma separated list of IPs, CIDRs, MAC addresses or\n"+
+		"discovered device names. Outside of the window, queries are answered with NXDOMAIN.\n"+
+		"For instance: \"domains=netflix.com,youtube.com days=mon-fri hours=18:00-23:00\n"+
+		"tz=Europe/Paris\". With profile instead of domains, the given configuration ID is used\n"+
+		"during the window, or filtering is disabled with profile=off. The flag can be\n"+
+		"repeated.")
+	fs.BoolVar(&c.DNSSEC, "dnssec", false, "Validate DNSSEC signatures locally.\n"+
+		"\n"+
+		"Responses are validated up to the root trust anchor. Bogus responses are answered\n"+
+		"with SERVFAIL and the AD bit is set on validated responses for clients asking for it.\n"+
+		"Note that validation requires the system clock to be accurate.")
+	fs.StringVar(&c.DNSSECAnchorFile, "dnssec-anchor-file", "", "Path to the file storing the root trust anchors state.\n"+
+		"\n"+
+		"When set, root key rollovers are tracked following RFC 5011 and persisted in this file.\n"+
+		"If empty, the built-in root anchors are used.")
+	fs.StringVar(&c.User, "user", "", "User to run as after binding the listening sockets (not supported on Windows).\n"+
+		"\n"+
+		"The sockets are opened as root then privileges are dropped before serving queries.\n"+
+		"On Linux, the CAP_NET_BIND_SERVICE and CAP_NET_ADMIN capabilities are kept (except\n"+
+		"in cgo builds) so listeners can be rebound and firewall rules updated.\n"+
+		"Cannot be used with setup-router and auto-activate as they require root privileges\n"+
+		"to restore the system configuration on exit.")
+	fs.StringVar(&c.Group, "group", "", "Group to run as after binding the listening sockets. Defaults to the primary group\n"+
+		"of user.")
+	fs.IntVar(&c.Nice, "nice", 0, "Scheduling priority of the process, from -20 (highest) to 19 (lowest).\n"+
+		"\n"+
+		"A negative value keeps DNS responsive when other processes compete for the CPU.\n"+
+		"On Windows, the value is mapped to a process priority class.")
+	fs.IntVar(&c.MemoryLimit, "memory-limit", 0, "Memory ceiling of the process in MB (0 to disable).\n"+
+		"\n"+
+		"When the memory usage gets close to it, optional state and features are shed in\n"+
+		"order: garbage collection is made more aggressive, the negative cache is flushed,\n"+
+		"web-ui and top statistics, anomaly detection and query-history are disabled.")
+	fs.IntVar(&c.MaxInflight, "max-inflight", 0, "Maximum number of queries processed concurrently (0 for no limit).\n"+
+		"\n"+
+		"Bounds the memory and CPU used by the queries in flight, i.e. when the upstream is\n"+
+		"slow to answer. Queries received above the limit are dropped, clients retry them.")
+	fs.IntVar(&c.GOGC, "gogc", 0, "Garbage collection target percentage, as the GOGC environment variable (0 for the\n"+
+		"Go default of 100).\n"+
+		"\n"+
+		"Lower values keep the memory usage closer to the live data at the cost of more\n"+
+		"CPU, higher values the opposite.")
+	fs.IntVar(&c.GCBallast, "gc-ballast", 0, "Size in MB of a heap ballast (0 to disable).\n"+
+		"\n"+
+		"The ballast is allocated but never written, so it is not resident, and raises the\n"+
+		"heap size at which garbage collections run: garbage can accumulate up to about the\n"+
+		"ballast size, trading memory for less CPU spent collecting small heaps.")
+	fs.StringVar(&c.IOClass, "io-class", "", "IO scheduling class of the process (Linux only).\n"+
+		"\n"+
+		"Can be realtime, best-effort or idle, optionally followed by a priority level from 0\n"+
+		"(highest) to 7 (lowest) separated by a colon (i.e. best-effort:0).")
+	fs.Var(&c.MDNSReflector, "mdns-reflector", "An interface to reflect mDNS traffic from and to (IPv4 only).\n"+
+		"\n"+
+		"The flag must be repeated for each interface, mDNS packets received on one interface are\n"+
+		"relayed to all the others. Reflected traffic can be restricted per interface to some\n"+
+		"services or host names using the name=service,service form (i.e.\n"+
+		"br-iot=_googlecast._tcp,_airplay._tcp).")
+	fs.Var(&c.MDNSAdvertise, "mdns-advertise", "An interface to advertise the DNS listeners on with mDNS/DNS-SD (IPv4 only).\n"+
+		"\n"+
+		"The listeners are published as _dns._udp and _dns._tcp services so capable LAN\n"+
+		"clients and other instances can discover the resolver. This parameter can be\n"+
+		"repeated.")
+	fs.Var(&c.RDNSS, "rdnss", "An interface to announce the IPv6 addresses of the host on as DNS servers, with the\n"+
+		"RDNSS option of router advertisements (RFC 8106).\n"+
+		"\n"+
+		"For hosts routing the network without another router advertisement daemon on the\n"+
+		"interface: the advertisements have a zero router lifetime and would remove the\n"+
+		"default route advertised by another daemon of the host. The proxy must listen on\n"+
+		"port 53 of these addresses. This parameter can be repeated.")
+	fs.BoolVar(&c.RDNSSDHCPv6, "rdnss-dhcpv6", false, "Also announce the DNS servers to the stateless DHCPv6 clients (option 23) of the\n"+
+		"rdnss interfaces, for clients ignoring RDNSS (i.e. Windows before 10).")
+	fs.DurationVar(&c.SLOWindow, "slo-window", 5*time.Minute, "Sliding window over which latency and error rate objectives are checked.")
+	fs.DurationVar(&c.SLOP50, "slo-p50", 0, "Maximum median resolution latency before alerting (0 to disable).")
+	fs.DurationVar(&c.SLOP95, "slo-p95", 0, "Maximum 95th percentile resolution latency before alerting (0 to disable).")
+	fs.Float64Var(&c.SLOErrorRate, "slo-error-rate", 0, "Maximum percentage of failed queries before alerting (0 to disable).")
+	fs.StringVar(&c.SLOWebhook, "slo-webhook", "", "URL to POST SLO alerts to as JSON.\n"+
+		"\n"+
+		"Alerts are sent when objectives start being breached, with recent failed and slow\n"+
+		"queries as evidence, and when they are restored. Alerts are always logged.")
+	fs.DurationVar(&c.DowngradeAlert, "downgrade-alert", 5*time.Minute, "Duration queries can be answered over the plain DNS fallback before a downgrade\n"+
+		"warning is raised (0 to disable).\n"+
+		"\n"+
+		"The warning is logged, shown by the status command and sent as a downgrade.detected\n"+
+		"event.")
+	fs.StringVar(&c.DowngradeWebhook, "downgrade-webhook", "", "URL to POST downgrade alerts to as JSON, when detected and when resolved.")
+	fs.DurationVar(&c.HijackCheck, "hijack-check", time.Hour, "Interval at which plain DNS traffic is tested for interception by the network\n"+
+		"or ISP (0 to disable).\n"+
+		"\n"+
+		"A query is sent to an address no DNS server answers from, and the answer of\n"+
+		"NextDNS over plain DNS is compared with its answer over DoH. On networks\n"+
+		"intercepting DNS, the plain DNS fallback is neither private nor filtered. The\n"+
+		"result is logged, shown by the status command and sent as a hijack.detected\n"+
+		"event.")
+	fs.Float64Var(&c.AnomalyThreshold, "anomaly-threshold", 0, "Number of standard deviations above its baseline a client query volume or\n"+
+		"number of unique domains must reach to be reported as an anomaly (0 to disable).\n"+
+		"\n"+
+		"Baselines are learned per client. Anomalies can be a sign of malware beaconing or\n"+
+		"data exfiltration over DNS. A value of 4 is a good start.")
+	fs.DurationVar(&c.AnomalyInterval, "anomaly-interval", time.Minute, "Interval over which client queries are counted by the anomaly detector.")
+	fs.IntVar(&c.AnomalySample, "anomaly-sample", 1, "Only track one out of N domains in the anomaly detector to reduce its cost.")
+	fs.StringVar(&c.AnomalyWebhook, "anomaly-webhook", "", "URL to POST detected anomalies to as JSON. Anomalies are always logged.")
+	fs.StringVar(&c.TunnelAction, "tunnel-detection", "", "Detect likely DNS tunneling and log, rate-limit or block suspicious queries.\n"+
+		"\n"+
+		"Queries with very long labels, high entropy sub-domains or a high volume of TXT/NULL\n"+
+		"queries from a client to the same domain are considered suspicious. With rate-limit,\n"+
+		"10 suspicious queries per minute are allowed per client and domain. Detections are\n"+
+		"always logged.")
+	fs.IntVar(&c.TunnelLabelLength, "tunnel-label-length", 50, "Maximum label length before a query is considered as tunneling (0 to disable).")
+	fs.Float64Var(&c.TunnelEntropy, "tunnel-entropy", 4, "Maximum entropy in bits per character of the sub-domain part of a name before\n"+
+		"a query is considered as tunneling (0 to disable).")
+	fs.IntVar(&c.TunnelTXTRate, "tunnel-txt-rate", 30, "Maximum number of TXT/NULL queries per minute from a client to the same domain\n"+
+		"before queries are considered as tunneling (0 to disable).")
+	fs.Var(&c.WatchDomains, "watch-domain", "Domain to resolve periodically, reporting when its A/AAAA answer changes.\n"+
+		"\n"+
+		"Changes are logged with the old and new addresses, which helps catching hijacks of\n"+
+		"your own domains or following CDN changes. This parameter can be repeated.")
+	fs.DurationVar(&c.WatchInterval, "watch-interval", 5*time.Minute, "Interval between two resolutions of the watched domains.")
+	fs.StringVar(&c.WatchWebhook, "watch-webhook", "", "URL to POST answer changes of watched domains to as JSON. Changes are always logged.")
+	fs.IntVar(&c.ShareDomainCap, "share-domain-cap", 0, "Maximum number of times a queried domain is shared off-box over 24 hours, in the\n"+
+		"evidence of SLO alerts and the top domains of anomalies sent to webhooks.\n"+
+		"\n"+
+		"Once the budget of a domain is spent, it is withheld from the payloads. The\n"+
+		"\"shared\" control command reports every payload sent off-box during the last 24\n"+
+		"hours. If zero, domains are not limited.")
+	fs.BoolVar(&c.ShareAggregate, "share-aggregate-clients", false, "Aggregate the clients in the payloads sent to webhooks: IPs are replaced with their\n"+
+		"network (/24 for IPv4, /48 for IPv6), other identifiers with a pseudonym, and client\n"+
+		"names are removed.")
+	fs.StringVar(&c.EventsFile, "events-file", "", "Path to a file to append machine readable events to.\n"+
+		"\n"+
+		"Events like service state changes, upstream switches, errors and activation\n"+
+		"changes are written as newline delimited JSON objects.")
+	fs.StringVar(&c.EventsSocket, "events-socket", "", "Path to a unix socket streaming machine readable events.\n"+
+		"\n"+
+		"Each client connecting to the socket receives events in the same format as\n"+
+		"events-file as they happen. The socket is only accessible to the daemon user.")
+	control, portalState := ctl.DefaultAddr, "/etc/nextdns.portal"
+	if runtime.GOOS == "windows" {
+		control, portalState = "", ""
+	}
+	fs.StringVar(&c.Control, "control", control, "Path to the unix socket used by the ctl command to control the daemon.\n"+
+		"\n"+
+		"If empty, the control socket is disabled.")
+	fs.BoolVar(&c.Kiosk, "kiosk", false, "Read-only control socket.\n"+
+		"\n"+
+		"Commands reading the daemon status and stats are served to all users, commands\n"+
+		"changing the daemon behavior (i.e. portal.approve) are refused. For deployments\n"+
+		"where end users have shell access but must not alter the DNS policy.")
+	fs.StringVar(&c.API, "api", "", "Address to serve the management API on over HTTPS (i.e. :8443).\n"+
+		"\n"+
+		"The API exposes the control socket commands (status, stats, config.get, config.set,\n"+
+		"cache.flush…) to remote orchestration tools, authenticated with api-token or\n"+
+		"api-read-only-token. TLS (api-cert and api-key) is required unless listening on\n"+
+		"a loopback address. If empty, the API is disabled.")
+	fs.StringVar(&c.APIToken, "api-token", "", "Bearer token giving access to all the management API commands.")
+	fs.StringVar(&c.APIReadOnlyToken, "api-read-only-token", "", "Bearer token giving access to the management API commands reading the daemon state only.")
+	fs.StringVar(&c.APICert, "api-cert", "", "Path to the PEM encoded TLS certificate of the management API.")
+	fs.StringVar(&c.APIKey, "api-key", "", "Path to the PEM encoded TLS private key of the management API.")
+	fs.StringVar(&c.WebUI, "web-ui", "", "Address to serve a web dashboard on (i.e. localhost:8053).\n"+
+		"\n"+
+		"The dashboard shows the live queries, top clients and domains, cache and upstream\n"+
+		"statistics. When web-ui-password is set, forwarders and local records (rewrite)\n"+
+		"can also be edited from it. If empty, the dashboard is disabled.")
+	fs.StringVar(&c.WebUIPassword, "web-ui-password", "", "Password required by the web dashboard with HTTP basic authentication.\n"+
+		"\n"+
+		"Required to listen on an address other than localhost, and to edit the\n"+
+		"configuration. Use a ${secret:...} reference to keep it out of the configuration.")
+	fs.StringVar(&c.Portal, "portal", "", "Address of a local captive portal for unknown devices.\n"+
+		"\n"+
+		"When set, all queries from devices with a MAC address not yet approved are answered\n"+
+		"with this address. Devices are approved with \"nextdns ctl portal.approve MAC\".\n"+
+		"Clients with no known MAC address (i.e. behind another router) are not restricted.")
+	fs.StringVar(&c.PortalStateFile, "portal-state-file", portalState, "Path to the file storing the devices approved for the portal.")
+	fs.StringVar(&c.BlockPage, "block-page", "", "Address of this host to serve a page explaining blocks on.\n"+
+		"\n"+
+		"When set, domains blocked by the blocklists, or by NextDNS (answered with 0.0.0.0 or\n"+
+		"::), are answered with this address, and a page explaining the block is served on its\n"+
+		"ports 80 and 443 (with a self-signed certificate).")
+	fs.StringVar(&c.BlockPagePassword, "block-page-password", "", "Password to enter on the block page to allow a domain blocked by the blocklists for\n"+
+		"block-page-allow. If empty, domains cannot be allowed from the page. Use a\n"+
+		"${secret:...} reference to keep it out of the configuration.\n"+
+		"\n"+
+		"The password is only accepted over HTTPS, and a client entering 5 invalid\n"+
+		"passwords within 15 minutes is refused until the end of that period.")
+	fs.DurationVar(&c.BlockPageAllow, "block-page-allow", time.Hour, "Duration a domain allowed from the block page stays allowed.")
 	return fs
 }
 
@@ -124,19 +901,40 @@ type flagSet struct {
 func (fs flagSet) Parse(args []string, useStorage bool) {
 	// Parse a copy of args to get the config file.
 	_ = fs.flag.Parse(append([]string{}, args...))
+	if fs.config.File == "" {
+		fs.config.File = os.Getenv(EnvName("config-file"))
+	}
 	if useStorage || fs.config.File != "" {
 		cs, err := fs.storer()
 		if err != nil {
 			fmt.Fprintln(fs.flag.Output(), err)
 			os.Exit(2)
 		}
-		if err = cs.LoadConfig(fs.storage); err != nil {
+		if fs.config.VarsFile == "" {
+			// Get the vars file from the configuration first.
+			_ = cs.LoadConfig(map[string]service.ConfigEntry{"vars-file": fs.storage["vars-file"]})
+		}
+		vars, err := loadVars(fs.config.VarsFile)
+		if err != nil {
 			fmt.Fprintln(fs.flag.Output(), err)
 			os.Exit(2)
 		}
+		if err = cs.LoadConfig(fs.config.withVars(fs.config.withSource(fs.storage, SourceFile), vars)); err != nil {
+			fmt.Fprintln(fs.flag.Output(), err)
+			os.Exit(2)
+		}
+	}
+	if err := loadEnv(fs.config.withSource(fs.storage, SourceEnv), os.LookupEnv); err != nil {
+		fmt.Fprintln(fs.flag.Output(), err)
+		os.Exit(2)
 	}
 
 	_ = fs.flag.Parse(args)
+	fs.flag.Visit(func(f *flag.Flag) {
+		if _, ok := fs.storage[f.Name]; ok {
+			fs.config.setSource(f.Name, SourceFlag)
+		}
+	})
 	if len(fs.flag.Args()) > 0 {
 		fmt.Fprintf(fs.flag.Output(), "Unrecognized parameter: %v\n", fs.flag.Args()[0])
 		fs.flag.PrintDefaults()
@@ -158,6 +956,20 @@ func (fs flagSet) BoolVar(p *bool, name string, value bool, usage string) {
 	fs.storage[name] = service.ConfigFlag{Value: p}
 }
 
+func (fs flagSet) IntVar(p *int, name string, value int, usage string) {
+	if fs.flag != nil {
+		fs.flag.IntVar(p, name, value, usage)
+	}
+	fs.storage[name] = service.ConfigInt{Value: p}
+}
+
+func (fs flagSet) Float64Var(p *float64, name string, value float64, usage string) {
+	if fs.flag != nil {
+		fs.flag.Float64Var(p, name, value, usage)
+	}
+	fs.storage[name] = service.ConfigFloat{Value: p}
+}
+
 func (fs flagSet) DurationVar(p *time.Duration, name string, value time.Duration, usage string) {
 	if fs.flag != nil {
 		fs.flag.DurationVar(p, name, value, usage)
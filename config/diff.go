@@ -0,0 +1,89 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/nextdns/nextdns/host/service"
+)
+
+// Diff returns the names of the settings Save would change in the stored
+// configuration, sorted. Settings missing from the stored configuration are
+// compared to their default value, so saving c is a no-op when Diff returns
+// no names.
+func (c *Config) Diff() ([]string, error) {
+	fs := c.flagSet("")
+	cs, err := fs.storer()
+	if err != nil {
+		return nil, err
+	}
+	var def Config
+	stored := map[string]service.ConfigEntry{}
+	for name, entry := range def.flagSet("defaults").storage {
+		_, list := entry.(service.ConfigListEntry)
+		stored[name] = &storedEntry{values: entryValues(entry), list: list}
+	}
+	if err := cs.LoadConfig(stored); err != nil {
+		return nil, err
+	}
+	// Normalize the stored values the way loading them would, keeping the
+	// raw value of templates.
+	var norm Config
+	nfs := norm.flagSet("normalize")
+	for name, entry := range stored {
+		se := entry.(*storedEntry)
+		if !se.loaded {
+			continue
+		}
+		e := nfs.storage[name]
+		valid := true
+		for _, v := range se.values {
+			if e.Set(v) != nil {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			se.values = entryValues(e)
+		}
+	}
+	var names []string
+	for name, entry := range c.withTemplates(fs.storage) {
+		if !reflect.DeepEqual(entryValues(entry), stored[name].(*storedEntry).values) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// storedEntry records the raw values of a stored setting, replacing its
+// default value on the first Set.
+type storedEntry struct {
+	values []string
+	list   bool
+	loaded bool
+}
+
+func (e *storedEntry) Set(v string) error {
+	if !e.loaded || !e.list {
+		e.values, e.loaded = nil, true
+	}
+	e.values = append(e.values, v)
+	return nil
+}
+
+func (e *storedEntry) String() string {
+	return ""
+}
+
+// entryValues returns the values of entry as written by Save.
+func entryValues(entry service.ConfigEntry) []string {
+	if entry, ok := entry.(service.ConfigListEntry); ok {
+		if v := entry.Strings(); len(v) > 0 {
+			return v
+		}
+		return nil
+	}
+	return []string{entry.String()}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfig_Diff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "nextdns.conf")
+	if err := ioutil.WriteFile(file, []byte("listen :5353\nforwarder lan=192.168.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"Unchanged", nil, nil},
+		{"SameValue", []string{"-listen", ":5353"}, nil},
+		{"DefaultValue", []string{"-max-attempts", "3"}, nil},
+		{"Changed", []string{"-listen", ":53", "-max-attempts", "5"}, []string{"listen", "max-attempts"}},
+		{"ListChanged", []string{"-forwarder", "corp=10.0.0.1"}, []string{"forwarder"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			c.Parse("nextdns", append([]string{"-config-file", file}, tt.args...), true)
+			got, err := c.Diff()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
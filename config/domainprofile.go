@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// domainProfile routes the queries for a domain to a configuration.
+type domainProfile struct {
+	// Domain is the domain, matching itself and its sub-domains, or
+	// *.domain matching its sub-domains only.
+	Domain string
+	Config string
+}
+
+// Match returns true if the rule matches the fully qualified name.
+func (d domainProfile) Match(name string) bool {
+	if strings.HasPrefix(d.Domain, "*.") {
+		return isSubDomain(name, d.Domain[2:])
+	}
+	return name == d.Domain || isSubDomain(name, d.Domain)
+}
+
+func (d domainProfile) String() string {
+	return strings.TrimSuffix(d.Domain, ".") + "=" + d.Config
+}
+
+// DomainProfiles is a list of configurations used for the queries of some
+// domains, whatever the client.
+type DomainProfiles []domainProfile
+
+// Get returns the configuration of the first rule matching name, or an empty
+// string if none does.
+func (dp *DomainProfiles) Get(name string) string {
+	if len(*dp) == 0 {
+		return ""
+	}
+	name = fqdn(strings.ToLower(name))
+	for _, d := range *dp {
+		if d.Match(name) {
+			return d.Config
+		}
+	}
+	return ""
+}
+
+// String is the method to format the flag's value
+func (dp *DomainProfiles) String() string {
+	return fmt.Sprint(*dp)
+}
+
+func (dp *DomainProfiles) Strings() []string {
+	if dp == nil {
+		return nil
+	}
+	var s []string
+	for _, d := range *dp {
+		s = append(s, d.String())
+	}
+	return s
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (dp *DomainProfiles) Set(value string) error {
+	idx := strings.IndexByte(value, '=')
+	if idx == -1 {
+		return fmt.Errorf("%s: missing domain", value)
+	}
+	d := domainProfile{
+		Domain: fqdn(strings.ToLower(strings.TrimSpace(value[:idx]))),
+		Config: strings.TrimSpace(value[idx+1:]),
+	}
+	if base := strings.TrimPrefix(d.Domain, "*."); base == "" || base == "." || strings.Contains(base, "..") || strings.Contains(base, "*") {
+		return fmt.Errorf("%s: invalid domain", value)
+	}
+	if d.Config == "" || strings.ContainsAny(d.Config, " ,/=") {
+		return fmt.Errorf("%s: invalid configuration id", value)
+	}
+	for i, _d := range *dp {
+		if _d.Domain == d.Domain {
+			(*dp)[i] = d
+			return nil
+		}
+	}
+	*dp = append(*dp, d)
+	return nil
+}
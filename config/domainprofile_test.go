@@ -0,0 +1,34 @@
+package config
+
+import "testing"
+
+func TestDomainProfiles_Get(t *testing.T) {
+	var dp DomainProfiles
+	for _, v := range []string{"*.work.example=work1", "corp.example.=work2", "Intranet.Example=work3"} {
+		if err := dp.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"mail.work.example.", "work1"},
+		{"work.example.", ""},
+		{"corp.example.", "work2"},
+		{"www.corp.example", "work2"},
+		{"wiki.intranet.example.", "work3"},
+		{"www.example.com.", ""},
+		{"notcorp.example.", ""},
+	}
+	for _, tt := range tests {
+		if got := dp.Get(tt.name); got != tt.want {
+			t.Errorf("Get(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	for _, v := range []string{"work1", "*.=work1", "example.com=", "*.a*.example=work1"} {
+		if err := dp.Set(v); err == nil {
+			t.Errorf("Set(%q) succeeded, want error", v)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nextdns/nextdns/host/service"
+)
+
+// Get returns the values of the setting name. Lists return one value per
+// element.
+func (c *Config) Get(name string) ([]string, error) {
+	entry, found := c.flagSet("").storage[name]
+	if !found {
+		return nil, fmt.Errorf("%s: unknown setting", name)
+	}
+	if entry, ok := entry.(service.ConfigListEntry); ok {
+		return entry.Strings(), nil
+	}
+	return []string{entry.String()}, nil
+}
+
+// credentialSettings are the settings holding credentials, redacted by
+// GetRedacted.
+var credentialSettings = map[string]bool{
+	"api-token":           true,
+	"api-read-only-token": true,
+	"web-ui-password":     true,
+	"block-page-password": true,
+	"rules-sync-token":    true,
+}
+
+// redactedValue replaces the redacted values.
+const redactedValue = "REDACTED"
+
+// GetRedacted returns the values of the setting name like Get, for display to
+// remote users: values resolved from secrets are returned as their reference
+// and credentials are replaced with redactedValue.
+func (c *Config) GetRedacted(name string) ([]string, error) {
+	entry, found := c.withSecretsHidden(c.flagSet("").storage)[name]
+	if !found {
+		return nil, fmt.Errorf("%s: unknown setting", name)
+	}
+	values := []string{entry.String()}
+	if entry, ok := entry.(service.ConfigListEntry); ok {
+		values = entry.Strings()
+	}
+	if credentialSettings[name] {
+		for i, v := range values {
+			if v != "" && !strings.HasPrefix(v, "${"+secretPrefix) {
+				values[i] = redactedValue
+			}
+		}
+	}
+	return values, nil
+}
+
+// Read resets c to the default settings and sets the ones read from r,
+// composed of one "name value" pair per line as written by Write. Unlike a
+// configuration file loaded by Parse, unknown settings are reported. Errors
+// include the line number.
+func (c *Config) Read(r io.Reader) error {
+	*c = Config{}
+	fs := c.flagSet("read")
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value := line, ""
+		if idx := strings.IndexByte(line, ' '); idx != -1 {
+			name = line[:idx]
+			value = strings.TrimSpace(line[idx+1:])
+		}
+		entry := fs.storage[name]
+		if entry == nil {
+			return fmt.Errorf("line %d: %s: unknown setting", n, name)
+		}
+		if err := entry.Set(value); err != nil {
+			return fmt.Errorf("line %d: %s: %v", n, name, err)
+		}
+	}
+	return sc.Err()
+}
+
+// Set replaces the values of the setting name with values, one per element
+// for lists, as if the configuration was edited with Read. No values resets
+// the setting to its default.
+func (c *Config) Set(name string, values ...string) error {
+	if _, err := c.Get(name); err != nil {
+		return err
+	}
+	var cur, b bytes.Buffer
+	_ = c.Write(&cur)
+	sc := bufio.NewScanner(&cur)
+	for sc.Scan() {
+		if line := sc.Text(); line != name && !strings.HasPrefix(line, name+" ") {
+			fmt.Fprintln(&b, line)
+		}
+	}
+	for _, v := range values {
+		fmt.Fprintf(&b, "%s %s\n", name, v)
+	}
+	var nc Config
+	if err := nc.Read(&b); err != nil {
+		return err
+	}
+	nc.File = c.File
+	*c = nc
+	return nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfig_Read(t *testing.T) {
+	var c Config
+	err := c.Read(strings.NewReader("# comment\n\nlisten :5353\nforwarder lan=192.168.1.1\nforwarder corp=10.0.0.1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.Get("listen"); !reflect.DeepEqual(got, []string{":5353"}) {
+		t.Errorf("Get(listen) = %v, want [:5353]", got)
+	}
+	if got, _ := c.Get("forwarder"); len(got) != 2 {
+		t.Errorf("Get(forwarder) = %v, want 2 forwarders", got)
+	}
+	if got, _ := c.Get("max-attempts"); !reflect.DeepEqual(got, []string{"3"}) {
+		t.Errorf("Get(max-attempts) = %v, want default [3]", got)
+	}
+	if _, err := c.Get("foo"); err == nil {
+		t.Error("Get(foo) succeeded")
+	}
+
+	if err := c.Read(strings.NewReader("foo bar\n")); err == nil {
+		t.Error("Read() with unknown setting succeeded")
+	}
+	if err := c.Read(strings.NewReader("listen :53\ntimeout 5\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("Read() error = %v, want line 2", err)
+	}
+}
+
+func TestConfig_GetRedacted(t *testing.T) {
+	var c Config
+	if err := c.Read(strings.NewReader("listen :5353\napi-token abc\nweb-ui-password ${secret:env:PASS}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.GetRedacted("listen"); !reflect.DeepEqual(got, []string{":5353"}) {
+		t.Errorf("GetRedacted(listen) = %v, want [:5353]", got)
+	}
+	if got, _ := c.GetRedacted("api-token"); !reflect.DeepEqual(got, []string{"REDACTED"}) {
+		t.Errorf("GetRedacted(api-token) = %v, want [REDACTED]", got)
+	}
+	if got, _ := c.GetRedacted("block-page-password"); !reflect.DeepEqual(got, []string{""}) {
+		t.Errorf("GetRedacted(block-page-password) = %v, want empty", got)
+	}
+	if _, err := c.GetRedacted("foo"); err == nil {
+		t.Error("GetRedacted(foo) succeeded")
+	}
+}
+
+func TestConfig_Set(t *testing.T) {
+	var c Config
+	if err := c.Read(strings.NewReader("listen :5353\nforwarder lan=192.168.1.1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("forwarder", "corp=10.0.0.1", "home=10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.Get("forwarder"); len(got) != 2 || !strings.HasPrefix(got[0], "corp") {
+		t.Errorf("Get(forwarder) = %v, want corp and home forwarders", got)
+	}
+	if got, _ := c.Get("listen"); !reflect.DeepEqual(got, []string{":5353"}) {
+		t.Errorf("Get(listen) = %v, want [:5353]", got)
+	}
+	if err := c.Set("listen"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.Get("listen"); !reflect.DeepEqual(got, []string{"localhost:53"}) {
+		t.Errorf("Get(listen) = %v, want default", got)
+	}
+	if err := c.Set("max-attempts", "foo"); err == nil {
+		t.Error("Set(max-attempts, foo) succeeded")
+	}
+	if err := c.Set("foo", "bar"); err == nil {
+		t.Error("Set(foo) succeeded")
+	}
+}
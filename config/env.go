@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/nextdns/nextdns/host/service"
+)
+
+// Settings are read from, by order of precedence: command line flags,
+// environment variables and the configuration file. Each setting can be set
+// with an environment variable named after it, prefixed with NEXTDNS_ (i.e.
+// NEXTDNS_MAX_ATTEMPTS for max-attempts). Values of list settings are separated
+// by new lines and are added to the values of the configuration file, like
+// command line flags.
+
+// EnvPrefix is the prefix of the environment variables settings are read
+// from.
+const EnvPrefix = "NEXTDNS_"
+
+// Setting sources.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// EnvName returns the name of the environment variable of the setting name.
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// loadEnv sets the entries of storage from the environment variables returned
+// by lookup.
+func loadEnv(storage map[string]service.ConfigEntry, lookup func(string) (string, bool)) error {
+	for name, entry := range storage {
+		v, ok := lookup(EnvName(name))
+		if !ok {
+			continue
+		}
+		values := []string{v}
+		if _, ok := entry.(service.ConfigListEntry); ok {
+			values = strings.Split(v, "\n")
+		}
+		for _, v := range values {
+			if err := entry.Set(strings.TrimSpace(v)); err != nil {
+				return fmt.Errorf("%s: %v", EnvName(name), err)
+			}
+		}
+	}
+	return nil
+}
+
+// sourceEntry records the source of the values set on a ConfigEntry.
+type sourceEntry struct {
+	service.ConfigEntry
+	name   string
+	source string
+	c      *Config
+}
+
+func (e sourceEntry) Set(v string) error {
+	if err := e.ConfigEntry.Set(v); err != nil {
+		return err
+	}
+	e.c.setSource(e.name, e.source)
+	return nil
+}
+
+// sourceListEntry is a sourceEntry for list entries.
+type sourceListEntry struct {
+	sourceEntry
+}
+
+func (e sourceListEntry) Strings() []string {
+	return e.ConfigEntry.(service.ConfigListEntry).Strings()
+}
+
+// withSource wraps the entries of storage to record source as the source of
+// the values set.
+func (c *Config) withSource(storage map[string]service.ConfigEntry, source string) map[string]service.ConfigEntry {
+	s := make(map[string]service.ConfigEntry, len(storage))
+	for name, entry := range storage {
+		e := sourceEntry{ConfigEntry: entry, name: name, source: source, c: c}
+		if _, ok := entry.(service.ConfigListEntry); ok {
+			s[name] = sourceListEntry{e}
+			continue
+		}
+		s[name] = e
+	}
+	return s
+}
+
+func (c *Config) setSource(name, source string) {
+	if c.sources == nil {
+		c.sources = map[string]string{}
+	}
+	c.sources[name] = source
+}
+
+// Source returns the source of the value of the setting name: default, file,
+// env or flag.
+func (c *Config) Source(name string) string {
+	if s := c.sources[name]; s != "" {
+		return s
+	}
+	return SourceDefault
+}
+
+// Show writes the effective configuration to w, sorted by setting name, with
+// the source of each value.
+func (c *Config) Show(w io.Writer) error {
+	storage := c.withSecretsHidden(c.flagSet("").storage)
+	names := make([]string, 0, len(storage))
+	for name := range storage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry := storage[name]
+		values := []string{entry.String()}
+		if entry, ok := entry.(service.ConfigListEntry); ok {
+			if values = entry.Strings(); len(values) == 0 {
+				continue
+			}
+		}
+		for _, v := range values {
+			if _, err := fmt.Fprintf(w, "%-26s %-30s # %s\n", name, v, c.Source(name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
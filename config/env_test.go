@@ -0,0 +1,58 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConfig_loadEnv(t *testing.T) {
+	var c Config
+	fs := c.flagSet("")
+	env := map[string]string{
+		"NEXTDNS_LISTEN":       "127.0.0.1:5353",
+		"NEXTDNS_MAX_ATTEMPTS": "5",
+		"NEXTDNS_LOG_QUERIES":  "true",
+		"NEXTDNS_FORWARDER":    "lan=192.168.1.1\ncorp=10.0.0.1,10.0.0.2",
+	}
+	err := loadEnv(c.withSource(fs.storage, SourceEnv), func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != "127.0.0.1:5353" {
+		t.Errorf("Listen = %q, want 127.0.0.1:5353", c.Listen)
+	}
+	if c.MaxAttempts != 5 {
+		t.Errorf("MaxAttempts = %d, want 5", c.MaxAttempts)
+	}
+	if !c.LogQueries {
+		t.Error("LogQueries not set")
+	}
+	if got := c.Forwarders.Strings(); len(got) != 2 {
+		t.Errorf("Forwarders = %v, want 2 forwarders", got)
+	}
+	if got := c.Source("listen"); got != SourceEnv {
+		t.Errorf("Source(listen) = %s, want %s", got, SourceEnv)
+	}
+	if got := c.Source("timeout"); got != SourceDefault {
+		t.Errorf("Source(timeout) = %s, want %s", got, SourceDefault)
+	}
+
+	var b bytes.Buffer
+	if err := c.Show(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "127.0.0.1:5353") || !strings.Contains(b.String(), "# env") {
+		t.Errorf("Show() = %q, missing listen from env", b.String())
+	}
+
+	err = loadEnv(fs.storage, func(name string) (string, bool) {
+		return "invalid", name == "NEXTDNS_TIMEOUT"
+	})
+	if err == nil || !strings.Contains(err.Error(), "NEXTDNS_TIMEOUT") {
+		t.Errorf("loadEnv() err = %v, want NEXTDNS_TIMEOUT error", err)
+	}
+}
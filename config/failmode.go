@@ -0,0 +1,99 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+// Fail modes, choosing how queries are handled when NextDNS is unreachable.
+const (
+	// FailAuto falls back to plain DNS for a few minutes after startup or a
+	// network change (i.e. to get through a captive portal), and fails
+	// queries otherwise.
+	FailAuto = "auto"
+	// FailOpen always falls back to plain DNS, resolving without filtering.
+	FailOpen = "open"
+	// FailClosed never falls back to plain DNS, queries are answered with
+	// SERVFAIL.
+	FailClosed = "closed"
+)
+
+// FailModes is a list of fail modes with optional client subnet or MAC
+// conditions.
+type FailModes []config
+
+// Get returns the fail mode matching the ip and mac conditions. Modes with a
+// condition take precedence over the global mode, FailAuto is returned if
+// none matches.
+func (fm *FailModes) Get(ip net.IP, mac net.HardwareAddr) string {
+	mode := FailAuto
+	for _, m := range *fm {
+		if m.Prefix == nil && m.MAC == nil {
+			mode = m.Config
+			continue
+		}
+		if m.Match(ip, mac) {
+			return m.Config
+		}
+	}
+	return mode
+}
+
+// Has returns true if mode is used globally or by any condition.
+func (fm *FailModes) Has(mode string) bool {
+	global := FailAuto
+	for _, m := range *fm {
+		if m.Prefix == nil && m.MAC == nil {
+			global = m.Config
+		} else if m.Config == mode {
+			return true
+		}
+	}
+	return global == mode
+}
+
+// String is the method to format the flag's value
+func (fm *FailModes) String() string {
+	return fmt.Sprint(*fm)
+}
+
+func (fm *FailModes) Strings() []string {
+	if fm == nil {
+		return nil
+	}
+	var s []string
+	for _, m := range *fm {
+		s = append(s, m.String())
+	}
+	return s
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (fm *FailModes) Set(value string) error {
+	m, err := newConfig(value)
+	if err != nil {
+		return err
+	}
+	switch m.Config {
+	case FailAuto, FailOpen, FailClosed:
+	default:
+		return fmt.Errorf("%s: invalid fail mode", m.Config)
+	}
+	*fm = setConfig(*fm, m)
+	return nil
+}
+
+// setConfig replaces the entry of cs with the same criteria as c, or appends
+// c if none.
+func setConfig(cs []config, c config) []config {
+	for i, _c := range cs {
+		if (c.MAC != nil && _c.MAC != nil && bytes.Equal(c.MAC, _c.MAC)) ||
+			(c.Prefix != nil && _c.Prefix != nil && c.Prefix.String() == _c.Prefix.String()) ||
+			(c.MAC == nil && c.Prefix == nil && _c.MAC == nil && _c.Prefix == nil) {
+			cs[i] = c
+			return cs
+		}
+	}
+	return append(cs, c)
+}
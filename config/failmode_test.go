@@ -0,0 +1,40 @@
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFailModes_Get(t *testing.T) {
+	mac, _ := net.ParseMAC("28:a0:2b:56:e9:66")
+	tests := []struct {
+		name  string
+		modes []string
+		ip    string
+		want  string
+	}{
+		{"Default", nil, "10.0.0.1", FailAuto},
+		{"Global", []string{"closed"}, "10.0.0.1", FailClosed},
+		{"Override", []string{"closed", "10.0.4.0/24=open"}, "10.0.4.2", FailOpen},
+		{"OverrideFirst", []string{"10.0.4.0/24=open", "closed"}, "10.0.4.2", FailOpen},
+		{"NoMatch", []string{"10.0.4.0/24=open", "closed"}, "10.0.5.2", FailClosed},
+		{"MAC", []string{"open", mac.String() + "=closed"}, "10.0.5.2", FailClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fm FailModes
+			for _, m := range tt.modes {
+				if err := fm.Set(m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := fm.Get(net.ParseIP(tt.ip), mac); got != tt.want {
+				t.Errorf("Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	var fm FailModes
+	if err := fm.Set("ajar"); err == nil {
+		t.Error("Set(ajar) succeeded, want error")
+	}
+}
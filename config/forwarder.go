@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/endpoint"
+	"github.com/nextdns/nextdns/wireguard"
 )
 
 // Resolver defines a forwarder server with some optional conditions.
@@ -13,6 +15,11 @@ type Resolver struct {
 	resolver.Resolver
 	addr   string
 	Domain string
+
+	// Upstream is the name of the upstream the queries are forwarded to when
+	// the server is defined by name. Resolver is then set once the upstreams
+	// are known.
+	Upstream string
 }
 
 // newResolver parses a server definition with an optional condition.
@@ -24,6 +31,10 @@ func newResolver(v string) (Resolver, error) {
 		r.addr = strings.TrimSpace(v[idx+1:])
 		r.Domain = fqdn(strings.TrimSpace(v[:idx]))
 	}
+	if isUpstreamName(r.addr) {
+		r.Upstream = r.addr
+		return r, nil
+	}
 	var err error
 	r.Resolver, err = resolver.New(r.addr)
 	return r, err
@@ -70,6 +81,61 @@ func (f *Forwarders) Get(domain string) resolver.Resolver {
 	return nil
 }
 
+// Tunnel replaces the resolver of the forwarders with DNS53 servers by one
+// sending the queries through t. DoH forwarders and the forwarders using a
+// named upstream are kept as is.
+func (f Forwarders) Tunnel(t *wireguard.Tunnel) error {
+	for i, r := range f {
+		if r.Upstream != "" {
+			continue
+		}
+		var servers []string
+		for _, addr := range strings.Split(r.addr, ",") {
+			e, err := endpoint.New(strings.TrimSpace(addr))
+			if err != nil {
+				return fmt.Errorf("%s: unsupported resolver address: %v", addr, err)
+			}
+			if e, ok := e.(*endpoint.DNSEndpoint); ok {
+				servers = append(servers, e.Addr)
+			}
+		}
+		if len(servers) == 0 {
+			continue
+		}
+		if len(servers) != strings.Count(r.addr, ",")+1 {
+			return fmt.Errorf("%s: DNS53 and DoH servers cannot be mixed with wireguard", r)
+		}
+		f[i].Resolver = &wireguard.Resolver{Tunnel: t, Servers: servers}
+	}
+	return nil
+}
+
+// SetUpstreams sets the resolver of the forwarders defined by the name of an
+// upstream. Forwarders referencing the same upstream share its resolver.
+func (f Forwarders) SetUpstreams(us Upstreams) error {
+	resolvers := map[string]resolver.Resolver{}
+	for i, r := range f {
+		if r.Upstream == "" {
+			continue
+		}
+		if res, found := resolvers[r.Upstream]; found {
+			f[i].Resolver = res
+			continue
+		}
+		u, found := us.Get(r.Upstream)
+		if !found {
+			return fmt.Errorf("%s: unknown upstream", r.Upstream)
+		}
+		res, err := u.Resolver()
+		if err != nil {
+			return fmt.Errorf("%s: %v", r.Upstream, err)
+		}
+		resolvers[r.Upstream] = res
+		f[i].Resolver = res
+	}
+	return nil
+}
+
 // String is the method to format the flag's value
 func (f *Forwarders) String() string {
 	return fmt.Sprint(*f)
@@ -104,9 +170,13 @@ func (f *Forwarders) Set(value string) error {
 
 // Resolve implements proxy.Resolver interface.
 func (f *Forwarders) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
-	r := f.Get(q.Name)
-	if r == nil {
-		return -1, resolver.ResolveInfo{}, fmt.Errorf("%s: no forwarder defined", q.Name)
+	for _, s := range *f {
+		if s.Match(q.Name) {
+			if s.Domain != "" {
+				resolver.Tracef(ctx, "forwarder", "matched rule %s", s)
+			}
+			return s.Resolve(ctx, q, buf)
+		}
 	}
-	return r.Resolve(ctx, q, buf)
+	return -1, resolver.ResolveInfo{}, fmt.Errorf("%s: no forwarder defined", q.Name)
 }
@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestForwarders_Set(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"https://dns.nextdns.io/abcdef", false},
+		{"lan=192.168.1.1", false},
+		{"corp.example=tls://dns.corp.example:853#10.0.0.1,1.1.1.1:5353", false},
+		{"lan=192.168.1", true},
+		{"lan=192.168.1.1:65536", true},
+		{"corp.example=https:///dns-query", true},
+		{"corp.example=https://doh.corp.example#10.0.0", true},
+		{"corp.example=tls://dns.corp.example:dot", true},
+		{"corp.example=1.1.1.1,", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var f Forwarders
+			err := f.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Set(%q) err = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if err != nil && len(f) != 0 {
+				t.Errorf("Set(%q) added an invalid forwarder", tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/nextdns/nextdns/health"
+)
+
+// HealthLEDs is a list of status LEDs reflecting the daemon health.
+type HealthLEDs []health.LED
+
+// String is the method to format the flag's value
+func (l *HealthLEDs) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *HealthLEDs) Strings() []string {
+	if l == nil {
+		return nil
+	}
+	var ss []string
+	for _, led := range *l {
+		ss = append(ss, led.String())
+	}
+	return ss
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (l *HealthLEDs) Set(value string) error {
+	led, err := health.ParseLED(value)
+	if err != nil {
+		return err
+	}
+	for _, _l := range *l {
+		if led == _l {
+			return nil
+		}
+	}
+	*l = append(*l, led)
+	return nil
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Instance is a named set of listen addresses whose queries are resolved with
+// their own configuration.
+type Instance struct {
+	// Name is the name of the instance.
+	Name string
+
+	// Listen is the comma separated list of listen addresses, in the same
+	// format as the listen setting.
+	Listen string
+
+	// Profile is the NextDNS configuration ID used for the queries of the
+	// instance. The config conditions apply if empty.
+	Profile string
+
+	// NoFilter specifies that the local blocklists are not applied to the
+	// queries of the instance.
+	NoFilter bool
+}
+
+// ParseInstance parses an instance definition composed of a name followed by
+// space separated key=value parameters:
+//
+//   listen=192.168.1.2:53  addresses to listen to (required)
+//   profile=abcdef         NextDNS configuration ID of the queries
+//   filter=off             do not apply the local blocklists
+func ParseInstance(s string) (Instance, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return Instance{}, fmt.Errorf("%s: invalid instance: missing listen", s)
+	}
+	inst := Instance{Name: fields[0]}
+	if strings.ContainsAny(inst.Name, "=,:/") {
+		return Instance{}, fmt.Errorf("%s: invalid instance name", inst.Name)
+	}
+	for _, f := range fields[1:] {
+		k, v := f, ""
+		if idx := strings.IndexByte(f, '='); idx != -1 {
+			k, v = f[:idx], f[idx+1:]
+		}
+		switch k {
+		case "listen":
+			if v == "" {
+				return Instance{}, fmt.Errorf("%s: missing listen address", inst.Name)
+			}
+			inst.Listen = v
+		case "profile":
+			if v == "" || strings.ContainsAny(v, "/?#") {
+				return Instance{}, fmt.Errorf("%s: invalid profile", v)
+			}
+			inst.Profile = v
+		case "filter":
+			switch v {
+			case "on":
+				inst.NoFilter = false
+			case "off":
+				inst.NoFilter = true
+			default:
+				return Instance{}, fmt.Errorf("%s: invalid filter value, expected on or off", v)
+			}
+		default:
+			return Instance{}, fmt.Errorf("%s: unknown instance parameter", k)
+		}
+	}
+	if inst.Listen == "" {
+		return Instance{}, fmt.Errorf("%s: missing listen address", inst.Name)
+	}
+	return inst, nil
+}
+
+func (inst Instance) String() string {
+	s := []string{inst.Name, "listen=" + inst.Listen}
+	if inst.Profile != "" {
+		s = append(s, "profile="+inst.Profile)
+	}
+	if inst.NoFilter {
+		s = append(s, "filter=off")
+	}
+	return strings.Join(s, " ")
+}
+
+// Instances is a list of instances.
+type Instances []Instance
+
+// Get returns the instance named name.
+func (is Instances) Get(name string) (Instance, bool) {
+	for _, inst := range is {
+		if inst.Name == name {
+			return inst, true
+		}
+	}
+	return Instance{}, false
+}
+
+// String is the method to format the flag's value
+func (is *Instances) String() string {
+	return fmt.Sprint(*is)
+}
+
+func (is *Instances) Strings() []string {
+	if is == nil {
+		return nil
+	}
+	var ss []string
+	for _, inst := range *is {
+		ss = append(ss, inst.String())
+	}
+	return ss
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (is *Instances) Set(value string) error {
+	inst, err := ParseInstance(value)
+	if err != nil {
+		return err
+	}
+	for i, _inst := range *is {
+		if _inst.Name == inst.Name {
+			// Redefining an instance replaces it.
+			(*is)[i] = inst
+			return nil
+		}
+	}
+	*is = append(*is, inst)
+	return nil
+}
@@ -0,0 +1,37 @@
+package config
+
+import "testing"
+
+func TestInstances_Set(t *testing.T) {
+	var is Instances
+	for _, v := range []string{
+		"filtered listen=192.168.1.1:53",
+		"unfiltered listen=192.168.1.2:53,udp://[fd00::2]:53 profile=abcdef filter=off",
+		"filtered listen=192.168.1.1:53 profile=123456",
+	} {
+		if err := is.Set(v); err != nil {
+			t.Fatalf("Set(%q) err = %v", v, err)
+		}
+	}
+	want := []string{
+		"filtered listen=192.168.1.1:53 profile=123456",
+		"unfiltered listen=192.168.1.2:53,udp://[fd00::2]:53 profile=abcdef filter=off",
+	}
+	got := is.Strings()
+	if len(got) != len(want) {
+		t.Fatalf("Strings() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Strings()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if inst, found := is.Get("unfiltered"); !found || !inst.NoFilter || inst.Profile != "abcdef" {
+		t.Errorf("Get(unfiltered) = %+v, %v", inst, found)
+	}
+	for _, v := range []string{"kids", "kids profile=abcdef", "kids listen=", "kids listen=:53 filter=no", "a=b listen=:53", "kids listen=:53 foo=bar"} {
+		if err := is.Set(v); err == nil {
+			t.Errorf("Set(%q) succeeded, want error", v)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package config
+
+import "strings"
+
+// StringList is a flag.Value accepting a list of values by repeating the
+// flag.
+type StringList []string
+
+// String is the method to format the flag's value
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Strings() []string {
+	if l == nil {
+		return nil
+	}
+	return *l
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (l *StringList) Set(value string) error {
+	for _, v := range *l {
+		if v == value {
+			return nil
+		}
+	}
+	*l = append(*l, value)
+	return nil
+}
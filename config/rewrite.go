@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/nextdns/nextdns/rewrite"
+)
+
+// Rewrites is a list of rewrite rules.
+type Rewrites []rewrite.Rule
+
+// String is the method to format the flag's value
+func (r *Rewrites) String() string {
+	return fmt.Sprint(*r)
+}
+
+func (r *Rewrites) Strings() []string {
+	if r == nil {
+		return nil
+	}
+	var s []string
+	for _, rule := range *r {
+		s = append(s, rule.String())
+	}
+	return s
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (r *Rewrites) Set(value string) error {
+	rule, err := rewrite.ParseRule(value)
+	if err != nil {
+		return err
+	}
+	for i, _r := range *r {
+		if rule.Pattern == _r.Pattern {
+			(*r)[i] = rule
+			return nil
+		}
+	}
+	*r = append(*r, rule)
+	return nil
+}
+
+// ResponseRewrites is a list of response rewrite rules.
+type ResponseRewrites []rewrite.ResponseRule
+
+// String is the method to format the flag's value
+func (r *ResponseRewrites) String() string {
+	return fmt.Sprint(*r)
+}
+
+func (r *ResponseRewrites) Strings() []string {
+	if r == nil {
+		return nil
+	}
+	var s []string
+	for _, rule := range *r {
+		s = append(s, rule.String())
+	}
+	return s
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (r *ResponseRewrites) Set(value string) error {
+	rule, err := rewrite.ParseResponseRule(value)
+	if err != nil {
+		return err
+	}
+	for _, _r := range *r {
+		if rule.String() == _r.String() {
+			return nil
+		}
+	}
+	*r = append(*r, rule)
+	return nil
+}
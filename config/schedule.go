@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/nextdns/nextdns/schedule"
+)
+
+// Schedules is a list of schedule rules.
+type Schedules []schedule.Rule
+
+// String is the method to format the flag's value
+func (s *Schedules) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *Schedules) Strings() []string {
+	if s == nil {
+		return nil
+	}
+	var ss []string
+	for _, rule := range *s {
+		ss = append(ss, rule.String())
+	}
+	return ss
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (s *Schedules) Set(value string) error {
+	rule, err := schedule.ParseRule(value)
+	if err != nil {
+		return err
+	}
+	for _, _r := range *s {
+		if rule.String() == _r.String() {
+			return nil
+		}
+	}
+	*s = append(*s, rule)
+	return nil
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// SearchDomains is a list of DNS search domains with optional client subnet
+// or MAC conditions.
+type SearchDomains []config
+
+// Get returns the search domain matching the ip and mac conditions. Domains
+// with a condition take precedence over the global domain, an empty string is
+// returned if none matches.
+func (sd *SearchDomains) Get(ip net.IP, mac net.HardwareAddr) string {
+	domain := ""
+	for _, d := range *sd {
+		if d.Prefix == nil && d.MAC == nil {
+			domain = d.Config
+			continue
+		}
+		if d.Match(ip, mac) {
+			return d.Config
+		}
+	}
+	return domain
+}
+
+// String is the method to format the flag's value
+func (sd *SearchDomains) String() string {
+	return fmt.Sprint(*sd)
+}
+
+func (sd *SearchDomains) Strings() []string {
+	if sd == nil {
+		return nil
+	}
+	var s []string
+	for _, d := range *sd {
+		s = append(s, d.String())
+	}
+	return s
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (sd *SearchDomains) Set(value string) error {
+	d, err := newConfig(value)
+	if err != nil {
+		return err
+	}
+	d.Config = strings.ToLower(strings.Trim(d.Config, "."))
+	if d.Config == "" || strings.ContainsAny(d.Config, " ,/=") || strings.Contains(d.Config, "..") {
+		return fmt.Errorf("%s: invalid search domain", value)
+	}
+	*sd = setConfig(*sd, d)
+	return nil
+}
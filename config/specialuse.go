@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/nextdns/nextdns/specialuse"
+)
+
+// SpecialDomains is a list of special-use domain rules.
+type SpecialDomains []specialuse.Rule
+
+// String is the method to format the flag's value
+func (s *SpecialDomains) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *SpecialDomains) Strings() []string {
+	if s == nil {
+		return nil
+	}
+	var v []string
+	for _, rule := range *s {
+		v = append(v, rule.String())
+	}
+	return v
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (s *SpecialDomains) Set(value string) error {
+	rule, err := specialuse.ParseRule(value)
+	if err != nil {
+		return err
+	}
+	for i, _r := range *s {
+		if rule.Zone == _r.Zone {
+			(*s)[i] = rule
+			return nil
+		}
+	}
+	*s = append(*s, rule)
+	return nil
+}
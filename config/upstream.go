@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/endpoint"
+)
+
+// Upstream is a named DNS server usable in the forwarder rules in place of its
+// address.
+type Upstream struct {
+	// Name is the name of the upstream.
+	Name string
+
+	// Servers is the comma separated list of the addresses of the servers,
+	// used for failover: DoH URLs, DoT URLs (tls://) or DNS53 IP[:PORT].
+	Servers string
+
+	// Bootstrap are the IPs used to contact the DoH and DoT servers without
+	// bootstrap IPs in their address.
+	Bootstrap []string
+
+	// Pins are the base64 encoded SHA-256 digests of the public keys the
+	// certificate chain of the DoH and DoT servers must contain one of.
+	Pins []string
+
+	// Headers are added to the DoH requests.
+	Headers http.Header
+}
+
+// ParseUpstream parses an upstream definition composed of a name and the
+// comma separated addresses of its servers followed by space separated
+// key=value parameters:
+//
+//	bootstrap=1.2.3.4,1.2.3.5   IPs of the DoH and DoT servers
+//	pin=BASE64                  SHA-256 digest of a public key, repeatable
+//	header=NAME:VALUE           DoH request header (URL encoded), repeatable
+func ParseUpstream(s string) (Upstream, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return Upstream{}, fmt.Errorf("%s: invalid upstream: missing servers", s)
+	}
+	u := Upstream{Name: fields[0], Servers: fields[1]}
+	if !isUpstreamName(u.Name) {
+		return Upstream{}, fmt.Errorf("%s: invalid upstream name", u.Name)
+	}
+	for _, addr := range strings.Split(u.Servers, ",") {
+		if _, err := endpoint.New(strings.TrimSpace(addr)); err != nil {
+			return Upstream{}, fmt.Errorf("%s: unsupported server address: %v", addr, err)
+		}
+	}
+	for _, f := range fields[2:] {
+		k, v := f, ""
+		if idx := strings.IndexByte(f, '='); idx != -1 {
+			k, v = f[:idx], f[idx+1:]
+		}
+		switch k {
+		case "bootstrap":
+			for _, ip := range strings.Split(v, ",") {
+				if net.ParseIP(ip) == nil {
+					return Upstream{}, fmt.Errorf("%s: invalid bootstrap IP", ip)
+				}
+				u.Bootstrap = append(u.Bootstrap, ip)
+			}
+		case "pin":
+			if err := endpoint.ParsePin(v); err != nil {
+				return Upstream{}, err
+			}
+			u.Pins = append(u.Pins, v)
+		case "header":
+			idx := strings.IndexByte(v, ':')
+			if idx <= 0 {
+				return Upstream{}, fmt.Errorf("%s: invalid header, expected NAME:VALUE", v)
+			}
+			value, err := url.PathUnescape(v[idx+1:])
+			if err != nil {
+				return Upstream{}, fmt.Errorf("%s: invalid header value: %v", v, err)
+			}
+			if u.Headers == nil {
+				u.Headers = http.Header{}
+			}
+			u.Headers.Add(v[:idx], value)
+		default:
+			return Upstream{}, fmt.Errorf("%s: unknown upstream parameter", k)
+		}
+	}
+	return u, nil
+}
+
+// isUpstreamName returns true if s can be the name of an upstream, a name
+// which cannot be mistaken for a server address.
+func isUpstreamName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '_'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (u Upstream) String() string {
+	s := []string{u.Name, u.Servers}
+	if len(u.Bootstrap) > 0 {
+		s = append(s, "bootstrap="+strings.Join(u.Bootstrap, ","))
+	}
+	for _, pin := range u.Pins {
+		s = append(s, "pin="+pin)
+	}
+	for name, values := range u.Headers {
+		for _, v := range values {
+			s = append(s, "header="+name+":"+url.PathEscape(v))
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+// Resolver returns a resolver sending the queries to the servers of u, the
+// first one reachable being used.
+func (u Upstream) Resolver() (resolver.Resolver, error) {
+	var endpoints []endpoint.Endpoint
+	for _, addr := range strings.Split(u.Servers, ",") {
+		e, err := endpoint.New(strings.TrimSpace(addr))
+		if err != nil {
+			return nil, fmt.Errorf("%s: unsupported server address: %v", addr, err)
+		}
+		switch e := e.(type) {
+		case *endpoint.DOHEndpoint:
+			if len(e.Bootstrap) == 0 {
+				e.Bootstrap = u.Bootstrap
+			}
+			e.Pins = u.Pins
+		case *endpoint.DOTEndpoint:
+			if len(e.Bootstrap) == 0 {
+				e.Bootstrap = u.Bootstrap
+			}
+			e.Pins = u.Pins
+		}
+		endpoints = append(endpoints, e)
+	}
+	return &resolver.DNS{
+		DOH: resolver.DOH{
+			ExtraHeaders: u.Headers,
+		},
+		Manager: &endpoint.Manager{
+			Providers: []endpoint.Provider{endpoint.StaticProvider(endpoints)},
+		},
+	}, nil
+}
+
+// Upstreams is a list of named upstreams.
+type Upstreams []Upstream
+
+// Get returns the upstream named name.
+func (us Upstreams) Get(name string) (Upstream, bool) {
+	for _, u := range us {
+		if u.Name == name {
+			return u, true
+		}
+	}
+	return Upstream{}, false
+}
+
+// String is the method to format the flag's value
+func (us *Upstreams) String() string {
+	return fmt.Sprint(*us)
+}
+
+func (us *Upstreams) Strings() []string {
+	if us == nil {
+		return nil
+	}
+	var ss []string
+	for _, u := range *us {
+		ss = append(ss, u.String())
+	}
+	return ss
+}
+
+// Set is the method to set the flag value, part of the flag.Value interface.
+func (us *Upstreams) Set(value string) error {
+	u, err := ParseUpstream(value)
+	if err != nil {
+		return err
+	}
+	for i, _u := range *us {
+		if _u.Name == u.Name {
+			// Redefining an upstream replaces it.
+			(*us)[i] = u
+			return nil
+		}
+	}
+	*us = append(*us, u)
+	return nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/endpoint"
+)
+
+const testPin = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+func TestUpstreams_Set(t *testing.T) {
+	var us Upstreams
+	for _, v := range []string{
+		"work https://doh.work.example/dns-query",
+		"quad9 tls://dns.quad9.net,https://dns.quad9.net/dns-query bootstrap=9.9.9.9 pin=" + testPin,
+		"work https://doh.work.example/dns-query header=Authorization:Bearer%20abc",
+	} {
+		if err := us.Set(v); err != nil {
+			t.Fatalf("Set(%q) err = %v", v, err)
+		}
+	}
+	want := []string{
+		"work https://doh.work.example/dns-query header=Authorization:Bearer%20abc",
+		"quad9 tls://dns.quad9.net,https://dns.quad9.net/dns-query bootstrap=9.9.9.9 pin=" + testPin,
+	}
+	got := us.Strings()
+	if len(got) != len(want) {
+		t.Fatalf("Strings() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Strings()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if u, found := us.Get("work"); !found || u.Headers.Get("Authorization") != "Bearer abc" {
+		t.Errorf("Get(work) = %+v, %v", u, found)
+	}
+	for _, v := range []string{
+		"work",
+		"1work https://doh.work.example",
+		"work doh.work.example",
+		"work https://doh.work.example bootstrap=doh",
+		"work https://doh.work.example pin=abc",
+		"work https://doh.work.example header=Authorization",
+		"work https://doh.work.example foo=bar",
+	} {
+		if err := us.Set(v); err == nil {
+			t.Errorf("Set(%q) succeeded, want error", v)
+		}
+	}
+}
+
+func TestForwarders_SetUpstreams(t *testing.T) {
+	var us Upstreams
+	if err := us.Set("quad9 tls://dns.quad9.net bootstrap=9.9.9.9,149.112.112.112 pin=" + testPin); err != nil {
+		t.Fatal(err)
+	}
+	var f Forwarders
+	for _, v := range []string{"a.example=quad9", "b.example=quad9", "c.example=1.1.1.1"} {
+		if err := f.Set(v); err != nil {
+			t.Fatalf("Set(%q) err = %v", v, err)
+		}
+	}
+	if err := f.SetUpstreams(us); err != nil {
+		t.Fatalf("SetUpstreams() err = %v", err)
+	}
+	if f[0].Resolver == nil || f[0].Resolver != f[1].Resolver {
+		t.Errorf("forwarders to the same upstream do not share their resolver")
+	}
+	e, err := f[0].Resolver.(*resolver.DNS).Manager.Providers[0].GetEndpoints(context.Background())
+	if err != nil || len(e) != 1 {
+		t.Fatalf("GetEndpoints() = %v, %v", e, err)
+	}
+	if dot, ok := e[0].(*endpoint.DOTEndpoint); !ok || len(dot.Bootstrap) != 2 || len(dot.Pins) != 1 {
+		t.Errorf("endpoint = %#v, want DoT endpoint with bootstrap and pin", e[0])
+	}
+	if got, want := f.Strings()[0], "a.example.=quad9"; got != want {
+		t.Errorf("Strings()[0] = %q, want %q", got, want)
+	}
+	if err := f.Set("d.example=other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetUpstreams(us); err == nil {
+		t.Errorf("SetUpstreams() with unknown upstream succeeded, want error")
+	}
+}
@@ -0,0 +1,257 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nextdns/nextdns/host/service"
+	"github.com/nextdns/nextdns/secret"
+)
+
+// Variables are referenced in configuration values as ${name} and resolved
+// from the environment or the vars file, the environment taking precedence.
+// References starting with a digit (i.e. ${1} in rewrite rules) are left
+// untouched. Secrets are referenced as ${secret:scheme:name} and resolved
+// with the secret package.
+
+// secretPrefix prefixes the secret references.
+const secretPrefix = "secret:"
+
+// loadVars reads a vars file composed of one "name value" pair per line.
+// Lines starting with # are comments.
+func loadVars(file string) (map[string]string, error) {
+	vars := map[string]string{}
+	if file == "" {
+		return vars, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value := line, ""
+		if idx := strings.IndexAny(line, " \t"); idx != -1 {
+			name, value = line[:idx], strings.TrimSpace(line[idx+1:])
+		}
+		vars[name] = value
+	}
+	return vars, s.Err()
+}
+
+// expandVars replaces ${name} references in s using vars and the
+// environment.
+func expandVars(s string, vars map[string]string) (string, error) {
+	var b strings.Builder
+	for {
+		idx := strings.Index(s, "${")
+		if idx == -1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(s[idx:], '}')
+		if end == -1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		name := s[idx+2 : idx+end]
+		if strings.HasPrefix(name, secretPrefix) {
+			value, err := secret.Lookup(name[len(secretPrefix):])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(s[:idx])
+			b.WriteString(value)
+			s = s[idx+end+1:]
+			continue
+		}
+		if !isVarName(name) {
+			b.WriteString(s[:idx+end+1])
+			s = s[idx+end+1:]
+			continue
+		}
+		value, found := os.LookupEnv(name)
+		if !found {
+			if value, found = vars[name]; !found {
+				return "", fmt.Errorf("${%s}: undefined variable", name)
+			}
+		}
+		b.WriteString(s[:idx])
+		b.WriteString(value)
+		s = s[idx+end+1:]
+	}
+}
+
+func isVarName(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// template records a configuration value using variables so it can be saved
+// back unresolved.
+type template struct {
+	raw   string
+	value string
+}
+
+// varEntry resolves variables of the values loaded from the configuration.
+type varEntry struct {
+	service.ConfigEntry
+	name string
+	vars map[string]string
+	c    *Config
+}
+
+func (e varEntry) Set(v string) error {
+	ev, err := expandVars(v, e.vars)
+	if err != nil {
+		return fmt.Errorf("%s: %v", e.name, err)
+	}
+	var before []string
+	list, isList := e.ConfigEntry.(service.ConfigListEntry)
+	if isList && ev != v {
+		before = list.Strings()
+	}
+	if err := e.ConfigEntry.Set(ev); err != nil {
+		return err
+	}
+	if ev == v {
+		return nil
+	}
+	// Record the value as formatted by the entry so it can be found on
+	// save.
+	value := e.ConfigEntry.String()
+	if isList {
+		value = newValue(before, list.Strings())
+	}
+	if e.c.templates == nil {
+		e.c.templates = map[string][]template{}
+	}
+	e.c.templates[e.name] = append(e.c.templates[e.name], template{raw: v, value: value})
+	return nil
+}
+
+// varListEntry is a varEntry for list entries.
+type varListEntry struct {
+	varEntry
+}
+
+func (e varListEntry) Strings() []string {
+	return e.ConfigEntry.(service.ConfigListEntry).Strings()
+}
+
+// newValue returns the value of after not present in before.
+func newValue(before, after []string) string {
+	seen := map[string]int{}
+	for _, v := range before {
+		seen[v]++
+	}
+	for _, v := range after {
+		if seen[v] == 0 {
+			return v
+		}
+		seen[v]--
+	}
+	return ""
+}
+
+// rawEntry formats values resolved from a template with their unresolved
+// form.
+type rawEntry struct {
+	service.ConfigEntry
+	templates []template
+}
+
+func (e rawEntry) raw(v string) string {
+	for _, t := range e.templates {
+		if t.value == v {
+			return t.raw
+		}
+	}
+	return v
+}
+
+func (e rawEntry) String() string {
+	return e.raw(e.ConfigEntry.String())
+}
+
+// rawListEntry is a rawEntry for list entries.
+type rawListEntry struct {
+	rawEntry
+}
+
+func (e rawListEntry) Strings() []string {
+	var s []string
+	for _, v := range e.ConfigEntry.(service.ConfigListEntry).Strings() {
+		s = append(s, e.raw(v))
+	}
+	return s
+}
+
+// withVars wraps the entries of storage to resolve variables on load.
+func (c *Config) withVars(storage map[string]service.ConfigEntry, vars map[string]string) map[string]service.ConfigEntry {
+	s := make(map[string]service.ConfigEntry, len(storage))
+	for name, entry := range storage {
+		e := varEntry{ConfigEntry: entry, name: name, vars: vars, c: c}
+		if _, ok := entry.(service.ConfigListEntry); ok {
+			s[name] = varListEntry{e}
+			continue
+		}
+		s[name] = e
+	}
+	return s
+}
+
+// withTemplates wraps the entries of storage so values resolved from
+// variables are saved unresolved.
+func (c *Config) withTemplates(storage map[string]service.ConfigEntry) map[string]service.ConfigEntry {
+	return c.withTemplatesFunc(storage, func(template) bool { return true })
+}
+
+// withSecretsHidden wraps the entries of storage so values resolved from
+// secrets are displayed unresolved.
+func (c *Config) withSecretsHidden(storage map[string]service.ConfigEntry) map[string]service.ConfigEntry {
+	return c.withTemplatesFunc(storage, func(t template) bool {
+		return strings.Contains(t.raw, "${"+secretPrefix)
+	})
+}
+
+func (c *Config) withTemplatesFunc(storage map[string]service.ConfigEntry, keep func(template) bool) map[string]service.ConfigEntry {
+	if len(c.templates) == 0 {
+		return storage
+	}
+	s := make(map[string]service.ConfigEntry, len(storage))
+	for name, entry := range storage {
+		var t []template
+		for _, tt := range c.templates[name] {
+			if keep(tt) {
+				t = append(t, tt)
+			}
+		}
+		if len(t) == 0 {
+			s[name] = entry
+			continue
+		}
+		e := rawEntry{ConfigEntry: entry, templates: t}
+		if _, ok := entry.(service.ConfigListEntry); ok {
+			s[name] = rawListEntry{e}
+			continue
+		}
+		s[name] = e
+	}
+	return s
+}
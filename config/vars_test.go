@@ -0,0 +1,93 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func Test_expandVars(t *testing.T) {
+	vars := map[string]string{"site": "paris", "profile": "abcdef"}
+	os.Setenv("NEXTDNS_TEST_VAR", "env")
+	defer os.Unsetenv("NEXTDNS_TEST_VAR")
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"abcdef", "abcdef", false},
+		{"${profile}", "abcdef", false},
+		{"10.0.0.0/24=${profile}", "10.0.0.0/24=abcdef", false},
+		{"${site}.example.com ${NEXTDNS_TEST_VAR}", "paris.example.com env", false},
+		{"/^(.*)\\.lan$/=${1}.${site}.example.com", "/^(.*)\\.lan$/=${1}.paris.example.com", false},
+		{"${foo", "${foo", false},
+		{"${undefined}", "", true},
+		{"${secret:env:NEXTDNS_TEST_VAR}", "env", false},
+		{"${secret:env:NEXTDNS_UNDEFINED}", "", true},
+		{"${secret:unknown:foo}", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := expandVars(tt.s, vars)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandVars() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expandVars() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_vars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "nextdns.conf")
+	varsFile := filepath.Join(dir, "site.vars")
+	conf := "vars-file " + varsFile + "\n" +
+		"listen ${lan_ip}:53\n" +
+		"config ${profile}\n" +
+		"config 10.0.4.0/24=${guest_profile}\n"
+	if err := ioutil.WriteFile(file, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vars := "# Paris office\nlan_ip 192.168.1.1\nprofile abcdef\nguest_profile 12345\n"
+	if err := ioutil.WriteFile(varsFile, []byte(vars), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var c Config
+	c.Parse("nextdns", []string{"-config-file", file, "-timeout", "3s"}, true)
+	if c.Listen != "192.168.1.1:53" {
+		t.Errorf("Listen = %q, want 192.168.1.1:53", c.Listen)
+	}
+	if got := strings.Join(c.Conf.Strings(), " "); got != "abcdef 10.0.4.0/24=12345" {
+		t.Errorf("Conf = %q", got)
+	}
+
+	// Saving keeps the variables.
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "listen ") || strings.HasPrefix(line, "config ") || strings.HasPrefix(line, "timeout ") {
+			got = append(got, line)
+		}
+	}
+	sort.Strings(got)
+	want := []string{"config ${profile}", "config 10.0.4.0/24=${guest_profile}", "listen ${lan_ip}:53", "timeout 3s"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("saved config:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
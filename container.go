@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/docker"
+	"github.com/nextdns/nextdns/host"
+)
+
+// containerHealthCheck is the default address of the health check server in
+// container mode, probed by the healthcheck command.
+const containerHealthCheck = "127.0.0.1:8053"
+
+// setupContainer adapts c to run as the entrypoint of a container: the
+// settings changing the host are disabled and orphaned processes are reaped
+// when running as PID 1.
+func setupContainer(c *config.Config, log host.Logger) {
+	if c.HealthCheck == "" {
+		c.HealthCheck = containerHealthCheck
+	}
+	for _, s := range []struct {
+		name string
+		set  bool
+	}{
+		{"auto-activate", c.AutoActivate},
+		{"setup-router", c.SetupRouter},
+		{"intercept", len(c.Intercept) > 0},
+		{"auto-upgrade", c.AutoUpgrade},
+	} {
+		if s.set {
+			log.Warningf("Container: ignoring %s", s.name)
+		}
+	}
+	c.AutoActivate, c.SetupRouter, c.Intercept, c.AutoUpgrade = false, false, nil, false
+	if dir := filepath.Dir(c.Control); c.Control != "" {
+		if _, err := os.Stat(dir); err != nil {
+			// Minimal images have no /var/run, unless a tmpfs is mounted.
+			log.Infof("Container: control socket disabled, %s does not exist", dir)
+			c.Control = ""
+		}
+	}
+	if os.Getpid() == 1 {
+		go host.ReapChildren()
+	}
+}
+
+// healthcheck exits with an error if the health check server of the daemon
+// does not report it as healthy, for use as a container health check.
+func healthcheck(args []string) error {
+	var c config.Config
+	c.Parse("nextdns healthcheck", args[1:], false)
+	addr := c.HealthCheck
+	if addr == "" {
+		if !c.Container {
+			return withCode(exitUsage, errors.New("health-check is not set"))
+		}
+		addr = containerHealthCheck
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+addr+"/healthz", nil)
+	if err != nil {
+		return withCode(exitUsage, err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// A dial error would be reported as a network failure by withCode.
+		return &cliError{code: exitNotRunning, err: err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unhealthy: %s", res.Status)
+	}
+	return nil
+}
+
+// usesEmbeddedDNS returns true if the container resolves names with the Docker
+// embedded DNS server, as set in its /etc/resolv.conf for user-defined
+// networks.
+func usesEmbeddedDNS() bool {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" && fields[1] == docker.EmbeddedDNS {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nextdns/nextdns/config"
+)
+
+// recordLogger records the formatted messages it is sent.
+type recordLogger struct {
+	msgs []string
+}
+
+func (l *recordLogger) Info(v ...interface{})    { l.msgs = append(l.msgs, fmt.Sprint(v...)) }
+func (l *recordLogger) Warning(v ...interface{}) { l.msgs = append(l.msgs, fmt.Sprint(v...)) }
+func (l *recordLogger) Error(v ...interface{})   { l.msgs = append(l.msgs, fmt.Sprint(v...)) }
+func (l *recordLogger) Infof(format string, a ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprintf(format, a...))
+}
+func (l *recordLogger) Warningf(format string, a ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprintf(format, a...))
+}
+func (l *recordLogger) Errorf(format string, a ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprintf(format, a...))
+}
+
+func Test_setupContainer(t *testing.T) {
+	dir := t.TempDir()
+	c := config.Config{
+		AutoActivate: true,
+		SetupRouter:  true,
+		Intercept:    []string{"eth0"},
+		Control:      filepath.Join(dir, "missing", "nextdns.sock"),
+	}
+	log := &recordLogger{}
+	setupContainer(&c, log)
+	if c.AutoActivate || c.SetupRouter || c.Intercept != nil || c.AutoUpgrade {
+		t.Errorf("host settings not disabled: %+v", c)
+	}
+	if c.HealthCheck != containerHealthCheck {
+		t.Errorf("HealthCheck = %q, want %q", c.HealthCheck, containerHealthCheck)
+	}
+	if c.Control != "" {
+		t.Errorf("Control = %q, want disabled", c.Control)
+	}
+	var warnings int
+	for _, m := range log.msgs {
+		if strings.HasPrefix(m, "Container: ignoring ") {
+			warnings++
+		}
+	}
+	if warnings != 3 {
+		t.Errorf("%d ignored settings logged, want 3: %q", warnings, log.msgs)
+	}
+
+	c = config.Config{
+		HealthCheck: "127.0.0.1:9000",
+		Control:     filepath.Join(dir, "nextdns.sock"),
+	}
+	log = &recordLogger{}
+	setupContainer(&c, log)
+	if c.HealthCheck != "127.0.0.1:9000" {
+		t.Errorf("HealthCheck = %q, want kept", c.HealthCheck)
+	}
+	if c.Control == "" {
+		t.Error("Control disabled while its directory exists")
+	}
+	if len(log.msgs) != 0 {
+		t.Errorf("unexpected logs: %q", log.msgs)
+	}
+}
+
+func Test_healthcheck(t *testing.T) {
+	status := http.StatusOK
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer s.Close()
+	addr := strings.TrimPrefix(s.URL, "http://")
+	args := []string{"healthcheck", "-health-check", addr}
+
+	if err := healthcheck(args); err != nil {
+		t.Errorf("healthcheck() = %v, want nil", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := healthcheck(args); err == nil {
+		t.Error("healthcheck() = nil for an unhealthy daemon")
+	}
+	s.Close()
+	if err := healthcheck(args); exitCodeOf(err) != exitNotRunning {
+		t.Errorf("healthcheck() = %v (%v), want %v", err, exitCodeOf(err), exitNotRunning)
+	}
+	if err := healthcheck([]string{"healthcheck"}); exitCodeOf(err) != exitUsage {
+		t.Errorf("healthcheck() = %v (%v), want %v", err, exitCodeOf(err), exitUsage)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+
+	"github.com/nextdns/nextdns/ctl"
+)
+
+// control sends a command to the running daemon through its control socket
+// and prints the result.
+func control(args []string) error {
+	fs := flag.NewFlagSet("nextdns ctl", flag.ExitOnError)
+	addr := ctl.DefaultAddr
+	if runtime.GOOS == "windows" {
+		addr = ""
+	}
+	fs.StringVar(&addr, "control", addr, "Path to the control socket of the daemon.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: nextdns ctl [-control path] <command> [arguments]\n\n")
+		fmt.Fprintf(fs.Output(), "Run \"nextdns ctl help\" to list the commands supported by the daemon.\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return withCode(exitUsage, errors.New("missing command"))
+	}
+	if addr == "" {
+		return withCode(exitUsage, errors.New("missing control socket path"))
+	}
+	data, err := sendControl(addr, fs.Arg(0), fs.Args()[1:]...)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+// sendControl sends the command cmd to the daemon listening on the control
+// socket addr, returning an error with the exitNotRunning code if none is.
+func sendControl(addr, cmd string, args ...string) ([]byte, error) {
+	data, err := ctl.Send(addr, cmd, args...)
+	if err != nil {
+		var oe *net.OpError
+		if errors.As(err, &oe) && oe.Op == "dial" && !errors.Is(err, os.ErrPermission) {
+			// No daemon is listening on the control socket.
+			return nil, &cliError{code: exitNotRunning, err: err}
+		}
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,210 @@
+// Package ctl implements a control socket used to send commands to the
+// running daemon.
+//
+// Requests and responses are newline delimited JSON objects:
+//
+//	{"cmd":"portal.approve","args":["00:1c:42:2e:60:4a"]}
+//	{"data":null}
+package ctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+)
+
+// DefaultAddr is the default path of the control socket on unix systems.
+const DefaultAddr = "/var/run/nextdns.sock"
+
+// Handler handles a command and returns a JSON serializable result.
+type Handler func(args []string) (interface{}, error)
+
+type request struct {
+	Cmd  string   `json:"cmd"`
+	Args []string `json:"args,omitempty"`
+}
+
+type response struct {
+	Data  interface{} `json:"data"`
+	Error string      `json:"error,omitempty"`
+}
+
+// Server serves commands on a unix socket. A nil *Server is valid and does
+// nothing.
+type Server struct {
+	// Addr is the path of the unix socket.
+	Addr string
+
+	// ReadOnly specifies that commands registered with Action are refused,
+	// only commands reading the daemon state are served. As commands cannot
+	// change the daemon behavior, the socket is accessible to all users.
+	ReadOnly bool
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	actions  map[string]bool
+	l        net.Listener
+}
+
+// Command registers the handler h for the command name.
+func (s *Server) Command(name string, h Handler) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = map[string]Handler{}
+	}
+	s.handlers[name] = h
+}
+
+// Action registers the handler h for the command name changing the daemon
+// state. Actions are refused in read-only mode.
+func (s *Server) Action(name string, h Handler) {
+	if s == nil {
+		return
+	}
+	s.Command(name, h)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.actions == nil {
+		s.actions = map[string]bool{}
+	}
+	s.actions[name] = true
+}
+
+// Start opens the socket and starts serving commands. If Addr is empty, only
+// the commands are registered (i.e. to be served by an HTTPServer).
+func (s *Server) Start() error {
+	if s == nil {
+		return nil
+	}
+	s.Command("help", func(args []string) (interface{}, error) {
+		return s.commands(s.ReadOnly), nil
+	})
+	if s.Addr == "" {
+		return nil
+	}
+	_ = os.Remove(s.Addr)
+	l, err := net.Listen("unix", s.Addr)
+	if err != nil {
+		return fmt.Errorf("ctl: %v", err)
+	}
+	// Commands can change the daemon behavior, restrict them to root.
+	mode := os.FileMode(0600)
+	if s.ReadOnly {
+		mode = 0666
+	}
+	if err := os.Chmod(s.Addr, mode); err != nil {
+		l.Close()
+		return fmt.Errorf("ctl: %v", err)
+	}
+	s.l = l
+	go s.accept()
+	return nil
+}
+
+// commands returns the sorted names of the registered commands, without the
+// actions if readOnly is true.
+func (s *Server) commands(readOnly bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmds := make([]string, 0, len(s.handlers))
+	for name := range s.handlers {
+		if readOnly && s.actions[name] {
+			continue
+		}
+		cmds = append(cmds, name)
+	}
+	sort.Strings(cmds)
+	return cmds
+}
+
+// Close closes the socket.
+func (s *Server) Close() error {
+	if s == nil || s.l == nil {
+		return nil
+	}
+	return s.l.Close()
+}
+
+func (s *Server) accept() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c net.Conn) {
+	defer c.Close()
+	sc := bufio.NewScanner(c)
+	enc := json.NewEncoder(c)
+	for sc.Scan() {
+		var req request
+		var resp response
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %v", err)
+		} else {
+			resp = s.handle(req, s.ReadOnly)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle runs the command of req. Actions are refused if readOnly is true.
+func (s *Server) handle(req request, readOnly bool) (resp response) {
+	s.mu.Lock()
+	h := s.handlers[req.Cmd]
+	action := s.actions[req.Cmd]
+	s.mu.Unlock()
+	if h == nil {
+		resp.Error = fmt.Sprintf("%s: unknown command", req.Cmd)
+		return resp
+	}
+	if action && readOnly {
+		resp.Error = fmt.Sprintf("%s: not allowed in read-only mode", req.Cmd)
+		return resp
+	}
+	data, err := h(req.Args)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.Data = data
+	return resp
+}
+
+// Send sends the command cmd with args to the daemon listening on addr and
+// returns the JSON encoded result.
+func Send(addr, cmd string, args ...string) (json.RawMessage, error) {
+	c, err := net.Dial("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if err := json.NewEncoder(c).Encode(request{Cmd: cmd, Args: args}); err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Data  json.RawMessage `json:"data"`
+		Error string          `json:"error"`
+	}
+	if err := json.NewDecoder(c).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Data, nil
+}
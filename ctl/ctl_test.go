@@ -0,0 +1,89 @@
+package ctl
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Server{Addr: filepath.Join(dir, "ctl.sock")}
+	s.Command("echo", func(args []string) (interface{}, error) {
+		return args, nil
+	})
+	s.Command("fail", func(args []string) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		cmd     string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{"echo", []string{"a", "b"}, `["a","b"]`, ""},
+		{"help", nil, `["echo","fail","help"]`, ""},
+		{"fail", nil, "", "failed"},
+		{"foo", nil, "", "foo: unknown command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			got, err := Send(s.Addr, tt.cmd, tt.args...)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Send() err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Send() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_ReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Server{Addr: filepath.Join(dir, "ctl.sock"), ReadOnly: true}
+	s.Command("status", func(args []string) (interface{}, error) {
+		return "ok", nil
+	})
+	s.Action("set", func(args []string) (interface{}, error) {
+		t.Error("action called in read-only mode")
+		return nil, nil
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if got, err := Send(s.Addr, "status"); err != nil || string(got) != `"ok"` {
+		t.Errorf("Send(status) = %s, %v, want \"ok\"", got, err)
+	}
+	if got, err := Send(s.Addr, "help"); err != nil || string(got) != `["help","status"]` {
+		t.Errorf("Send(help) = %s, %v, want [\"help\",\"status\"]", got, err)
+	}
+	if _, err := Send(s.Addr, "set"); err == nil || err.Error() != "set: not allowed in read-only mode" {
+		t.Errorf("Send(set) err = %v, want read-only error", err)
+	}
+}
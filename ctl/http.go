@@ -0,0 +1,163 @@
+package ctl
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPServer serves the commands of a Server over HTTP(S) so instances can be
+// managed remotely:
+//
+//	GET  /v1/commands               lists the commands
+//	GET  /v1/commands/stats         runs a command reading the daemon state
+//	POST /v1/commands/cache.flush   runs any command
+//
+// Arguments are passed as repeated arg query parameters or, for POST, as a
+// {"args":[...]} JSON body. Responses have the format of the control socket.
+type HTTPServer struct {
+	// Server is the server whose commands are served.
+	Server *Server
+
+	// Addr specifies the TCP address to listen to.
+	Addr string
+
+	// Token specifies the bearer token giving access to all the commands.
+	Token string
+
+	// ReadOnlyToken specifies the bearer token giving access to the commands
+	// reading the daemon state only.
+	ReadOnlyToken string
+
+	// CertFile and KeyFile specify the TLS certificate and key files. TLS is
+	// required unless Addr is a loopback address.
+	CertFile string
+	KeyFile  string
+}
+
+// Validate checks the API is protected by a token, and by TLS when reachable
+// from the network.
+func (h *HTTPServer) Validate() error {
+	if h.Token == "" && h.ReadOnlyToken == "" {
+		return errors.New("a token is required")
+	}
+	if (h.CertFile == "") != (h.KeyFile == "") {
+		return errors.New("both a certificate and a key are required for TLS")
+	}
+	if h.CertFile != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(h.Addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("%s: TLS is required to listen on a non loopback address", h.Addr)
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	readOnly, ok := h.auth(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeResponse(w, http.StatusUnauthorized, response{Error: "unauthorized"})
+		return
+	}
+	if r.URL.Path == "/v1/commands" {
+		writeResponse(w, http.StatusOK, response{Data: h.Server.commands(readOnly)})
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v1/commands/") {
+		writeResponse(w, http.StatusNotFound, response{Error: "not found"})
+		return
+	}
+	req := request{Cmd: strings.TrimPrefix(r.URL.Path, "/v1/commands/"), Args: r.URL.Query()["arg"]}
+	switch r.Method {
+	case http.MethodGet:
+		// GET must not change the daemon state.
+		h.Server.mu.Lock()
+		action := h.Server.actions[req.Cmd]
+		h.Server.mu.Unlock()
+		if action {
+			w.Header().Set("Allow", "POST")
+			writeResponse(w, http.StatusMethodNotAllowed, response{Error: fmt.Sprintf("%s: POST required", req.Cmd)})
+			return
+		}
+	case http.MethodPost:
+		var body struct {
+			Args []string `json:"args"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil && err != io.EOF {
+			writeResponse(w, http.StatusBadRequest, response{Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+		req.Args = append(req.Args, body.Args...)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeResponse(w, http.StatusMethodNotAllowed, response{Error: "method not allowed"})
+		return
+	}
+	resp := h.Server.handle(req, readOnly)
+	status := http.StatusOK
+	if resp.Error != "" {
+		status = http.StatusBadRequest
+	}
+	writeResponse(w, status, resp)
+}
+
+// auth returns whether the request has a valid token, and if this token only
+// gives read-only access.
+func (h *HTTPServer) auth(r *http.Request) (readOnly, ok bool) {
+	auth := []byte(r.Header.Get("Authorization"))
+	if h.Token != "" && subtle.ConstantTimeCompare(auth, []byte("Bearer "+h.Token)) == 1 {
+		return false, true
+	}
+	if h.ReadOnlyToken != "" && subtle.ConstantTimeCompare(auth, []byte("Bearer "+h.ReadOnlyToken)) == 1 {
+		return true, true
+	}
+	return false, false
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// ListenAndServe serves the commands on Addr until ctx is cancelled.
+func (h *HTTPServer) ListenAndServe(ctx context.Context) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", h.Addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:      h,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	if h.CertFile != "" {
+		err = srv.ServeTLS(l, h.CertFile, h.KeyFile)
+	} else {
+		err = srv.Serve(l)
+	}
+	if err == http.ErrServerClosed {
+		err = ctx.Err()
+	}
+	return err
+}
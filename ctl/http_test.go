@@ -0,0 +1,58 @@
+package ctl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPServer(t *testing.T) {
+	s := &Server{}
+	s.Command("echo", func(args []string) (interface{}, error) {
+		return args, nil
+	})
+	s.Action("set", func(args []string) (interface{}, error) {
+		return "done", nil
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	h := &HTTPServer{Server: s, Token: "admin", ReadOnlyToken: "monitor"}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"NoToken", "GET", "/v1/commands", "", "", http.StatusUnauthorized, `{"data":null,"error":"unauthorized"}`},
+		{"List", "GET", "/v1/commands", "", "admin", http.StatusOK, `{"data":["echo","help","set"]}`},
+		{"ListReadOnly", "GET", "/v1/commands", "", "monitor", http.StatusOK, `{"data":["echo","help"]}`},
+		{"GetArgs", "GET", "/v1/commands/echo?arg=a&arg=b", "", "monitor", http.StatusOK, `{"data":["a","b"]}`},
+		{"PostArgs", "POST", "/v1/commands/echo", `{"args":["c"]}`, "monitor", http.StatusOK, `{"data":["c"]}`},
+		{"GetAction", "GET", "/v1/commands/set", "", "admin", http.StatusMethodNotAllowed, `{"data":null,"error":"set: POST required"}`},
+		{"PostAction", "POST", "/v1/commands/set", "", "admin", http.StatusOK, `{"data":"done"}`},
+		{"PostActionReadOnly", "POST", "/v1/commands/set", "", "monitor", http.StatusBadRequest, `{"data":null,"error":"set: not allowed in read-only mode"}`},
+		{"Unknown", "GET", "/v1/commands/foo", "", "admin", http.StatusBadRequest, `{"data":null,"error":"foo: unknown command"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+}
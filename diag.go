@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/ctl"
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/proxy"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/endpoint"
+)
+
+// diagTimeout is the maximum duration of a single diag check.
+const diagTimeout = 5 * time.Second
+
+// diagBlackhole is an address no DNS server should answer from (TEST-NET-1,
+// RFC 5737): an answer means port 53 traffic is intercepted.
+const diagBlackhole = "192.0.2.1:53"
+
+// diagBogusDomain is a domain with invalid DNSSEC signatures, answered with
+// SERVFAIL by validating resolvers.
+const diagBogusDomain = "dnssec-failed.org."
+
+// Diag check statuses.
+const (
+	diagOK      = "ok"
+	diagWarning = "warning"
+	diagFailed  = "failed"
+)
+
+// diagResult is the result of a diag check.
+type diagResult struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// diagCheck returns the status and a detail of a check.
+type diagCheck struct {
+	name string
+	run  func(ctx context.Context) (status, detail string)
+}
+
+// diag runs an end-to-end self-test of the configured setup and reports the
+// result of each check. It fails if a check failed.
+func diag(args []string) error {
+	var c config.Config
+	c.Parse("nextdns diag", args[1:], true)
+	profile := c.Conf.Get(nil, nil)
+	upstream := func(ips string) string {
+		return "https://dns.nextdns.io/" + profile + "#" + ips
+	}
+	checks := []diagCheck{
+		{"bind", func(ctx context.Context) (string, string) {
+			return diagBind(ctx, c.Listen, c.Control)
+		}},
+		{"bootstrap", func(ctx context.Context) (string, string) {
+			ips, err := net.DefaultResolver.LookupHost(ctx, "dns.nextdns.io")
+			if err != nil {
+				return diagWarning, fmt.Sprintf("system resolver cannot resolve dns.nextdns.io, built-in bootstrap IPs are used: %v", err)
+			}
+			return diagOK, "dns.nextdns.io = " + strings.Join(ips, ", ")
+		}},
+		{"doh-ipv4", func(ctx context.Context) (string, string) {
+			return diagEndpoint(ctx, upstream("45.90.28.0"))
+		}},
+		{"doh-ipv6", func(ctx context.Context) (string, string) {
+			status, detail := diagEndpoint(ctx, upstream("2a07:a8c0::"))
+			if status == diagFailed {
+				// IPv6 is often not available, IPv4 is enough.
+				status = diagWarning
+			}
+			return status, detail
+		}},
+		{"dns53", func(ctx context.Context) (string, string) {
+			status, detail := diagEndpoint(ctx, "45.90.28.0")
+			if status == diagFailed {
+				// Only used as a fallback.
+				status = diagWarning
+			}
+			return status, detail
+		}},
+		{"dnssec", func(ctx context.Context) (string, string) {
+			return diagDNSSEC(ctx, upstream("45.90.28.0,2a07:a8c0::"), c.DNSSEC)
+		}},
+		{"hijack", diagHijack},
+	}
+
+	var results []diagResult
+	failed := false
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), diagTimeout)
+		status, detail := check.run(ctx)
+		cancel()
+		results = append(results, diagResult{check.name, status, detail})
+		if status == diagFailed {
+			failed = true
+		}
+	}
+	if jsonOutput {
+		if err := json.NewEncoder(os.Stdout).Encode(results); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range results {
+			fmt.Printf("%-10s %-8s %s\n", r.Check, r.Status, r.Detail)
+		}
+	}
+	if failed {
+		return withCode(exitNetwork, errors.New("some checks failed"))
+	}
+	return nil
+}
+
+// diagBind checks the listen addresses can be bound, or are used by a DNS
+// server (i.e. the running daemon).
+func diagBind(ctx context.Context, listen, control string) (string, string) {
+	files, err := proxy.Proxy{Addr: listen}.Bind()
+	for _, f := range files {
+		f.Close()
+	}
+	if err == nil {
+		return diagOK, listen + " can be bound"
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		return diagFailed, err.Error()
+	}
+	if control != "" {
+		if _, err := ctl.Send(control, "status"); err == nil {
+			return diagOK, listen + " is used by the running nextdns daemon"
+		}
+	}
+	for _, addr := range proxy.SplitAddr(listen) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		if host == "" || host == "localhost" || net.ParseIP(host).IsUnspecified() {
+			host = "127.0.0.1"
+		}
+		e := &endpoint.DNSEndpoint{Addr: net.JoinHostPort(host, port)}
+		if e.Test(ctx, endpoint.TestDomain) == nil {
+			return diagWarning, addr + " is used by another DNS server"
+		}
+	}
+	return diagFailed, err.Error()
+}
+
+// diagEndpoint checks server answers queries.
+func diagEndpoint(ctx context.Context, server string) (string, string) {
+	e, err := endpoint.New(server)
+	if err != nil {
+		return diagFailed, err.Error()
+	}
+	start := time.Now()
+	if err := e.Test(ctx, endpoint.TestDomain); err != nil {
+		return diagFailed, fmt.Sprintf("%s: %v", e, err)
+	}
+	return diagOK, fmt.Sprintf("%s answered in %dms", e, time.Since(start)/time.Millisecond)
+}
+
+// diagDNSSEC checks DNSSEC is validated by resolving a domain with invalid
+// signatures through server.
+func diagDNSSEC(ctx context.Context, server string, local bool) (string, string) {
+	if local {
+		return diagOK, "validated locally (dnssec)"
+	}
+	r, err := resolver.New(server)
+	if err != nil {
+		return diagFailed, err.Error()
+	}
+	q, err := diagQuery(diagBogusDomain)
+	if err != nil {
+		return diagFailed, err.Error()
+	}
+	buf := make([]byte, 1232)
+	n, _, err := r.Resolve(ctx, q, buf)
+	if err != nil {
+		return diagWarning, fmt.Sprintf("probe failed: %v", err)
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(buf[:n])
+	if err != nil {
+		return diagWarning, fmt.Sprintf("probe failed: %v", err)
+	}
+	if h.RCode != dnsmessage.RCodeServerFailure {
+		return diagWarning, fmt.Sprintf("upstream does not validate DNSSEC (%s answered with %v)", diagBogusDomain, h.RCode)
+	}
+	return diagOK, "upstream validates DNSSEC"
+}
+
+// diagHijack checks port 53 traffic is not intercepted on the path to the
+// Internet by querying an address no DNS server answers from.
+func diagHijack(ctx context.Context) (string, string) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	e := &endpoint.DNSEndpoint{Addr: diagBlackhole}
+	if err := e.Test(ctx, endpoint.TestDomain); err == nil {
+		return diagFailed, "plain DNS queries are intercepted (" + diagBlackhole + " answered), the network or ISP may redirect DNS"
+	}
+	return diagOK, "plain DNS queries are not intercepted"
+}
+
+func diagQuery(name string) (resolver.Query, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	})
+	payload, err := b.Finish()
+	if err != nil {
+		return resolver.Query{}, err
+	}
+	return resolver.NewQuery(payload, net.IPv6loopback)
+}
@@ -2,20 +2,51 @@ package discovery
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
-type leaseFile struct {
-	file, format string
+// leaseCheckInterval is the interval at which DHCP re-reads the lease files
+// when they are not watched for changes, or changes are missed.
+const leaseCheckInterval = 30 * time.Second
+
+// LeaseFile is a lease file of a DHCP server.
+type LeaseFile struct {
+	Path string
+
+	// Format is isc-dhcpd, dnsmasq or kea. When empty, the format is detected
+	// from the content of the file.
+	Format string
+}
+
+// ParseLeaseFileSpec returns the LeaseFile defined by spec, a path optionally
+// prefixed with the format and an equal sign (i.e. kea=/var/lib/kea/kea-leases4.csv).
+func ParseLeaseFileSpec(spec string) (LeaseFile, error) {
+	if idx := strings.IndexByte(spec, '='); idx != -1 && !strings.ContainsAny(spec[:idx], `/\`) {
+		switch format := spec[:idx]; format {
+		case "isc-dhcpd", "dnsmasq", "kea":
+			return LeaseFile{Path: spec[idx+1:], Format: format}, nil
+		default:
+			return LeaseFile{}, fmt.Errorf("%s: unknown lease file format", format)
+		}
+	}
+	return LeaseFile{Path: spec}, nil
 }
 
-var leaseFiles = []leaseFile{
+var leaseFiles = []LeaseFile{
 	{"/var/run/dhcpd.leases", "isc-dhcpd"},
 	{"/var/lib/dhcp/dhcpd.leases", "isc-dhcpd"},
 	{"/var/lib/misc/dnsmasq.leases", "dnsmasq"},
@@ -23,34 +54,51 @@ var leaseFiles = []leaseFile{
 	{"/tmp/dhcp.leases", "dnsmasq"},
 	{"/etc/dhcpd/dhcpd.conf.leases", "dnsmasq"},
 	{"/var/run/dnsmasq-dhcp.leases", "dnsmasq"},
+	{"/var/lib/kea/kea-leases4.csv", "kea"},
+	{"/var/lib/kea/kea-leases6.csv", "kea"},
 }
 
 type DHCP struct {
-	mu sync.RWMutex
-	m  map[string]string
-	in chan string
+	// Files are the lease files to read. When empty, the lease files of a
+	// local DHCP server are searched in the well known locations.
+	Files []LeaseFile
+
+	mu   sync.RWMutex
+	m    map[string]string
+	macs map[string]string
+	in   chan string
 }
 
 func (r *DHCP) Start(ctx context.Context) error {
-	file, format := findLeaseFile()
-	if file == "" {
+	files := r.Files
+	if len(files) == 0 {
+		files = findLeaseFiles()
+	}
+	if len(files) == 0 {
 		return nil
 	}
 
 	t := TraceFromCtx(ctx)
-	if err := r.readLease(ctx, file, format); err != nil && t.OnWarning != nil {
-		t.OnWarning(fmt.Sprintf("readLease(%s, %s): %v", file, format, err))
+	r.readLeases(ctx, files)
+	changed := make(chan struct{}, 1)
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	if err := watchFiles(ctx, paths, changed); err != nil && t.OnWarning != nil {
+		t.OnWarning(fmt.Sprintf("watchFiles: %v", err))
 	}
 	go func() {
+		tick := time.NewTicker(leaseCheckInterval)
+		defer tick.Stop()
 		for {
 			select {
-			case <-time.After(30 * time.Second):
-				if err := r.readLease(ctx, file, format); err != nil && t.OnWarning != nil {
-					t.OnWarning(fmt.Sprintf("readLease(%s, %s): %v", file, format, err))
-				}
+			case <-tick.C:
+			case <-changed:
 			case <-ctx.Done():
-				break
+				return
 			}
+			r.readLeases(ctx, files)
 		}
 	}()
 	return nil
@@ -69,60 +117,123 @@ func (r *DHCP) Lookup(addr string) (string, bool) {
 	return name, found
 }
 
-func findLeaseFile() (string, string) {
+// LookupMAC returns the MAC address of the client leased ip. For DHCPv6
+// leases, the MAC is extracted from the client DUID when it embeds one.
+func (r *DHCP) LookupMAC(ip string) net.HardwareAddr {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	mac, _ := net.ParseMAC(r.macs[ip])
+	return mac
+}
+
+// findLeaseFiles returns the lease files of the local DHCP servers. As Kea
+// splits its IPv4 and IPv6 leases, more than one file may be returned.
+func findLeaseFiles() []LeaseFile {
+	var files []LeaseFile
 	for _, lease := range leaseFiles {
-		if _, err := os.Stat(lease.file); err == nil {
-			return lease.file, lease.format
+		if len(files) > 0 && (lease.Format != "kea" || files[0].Format != "kea") {
+			continue
+		}
+		if _, err := os.Stat(lease.Path); err == nil {
+			files = append(files, lease)
+		}
+	}
+	return files
+}
+
+// readLeases reads files and updates the names and MAC addresses of the
+// leased clients. Errors are reported to the OnWarning function of the trace
+// of ctx.
+func (r *DHCP) readLeases(ctx context.Context, files []LeaseFile) {
+	t := TraceFromCtx(ctx)
+	entries := map[string]string{}
+	macs := map[string]string{}
+	for _, f := range files {
+		e, m, err := readLeaseFile(f)
+		if err != nil {
+			if t.OnWarning != nil {
+				t.OnWarning(fmt.Sprintf("readLease(%s, %s): %v", f.Path, f.Format, err))
+			}
+			continue
+		}
+		for k, v := range e {
+			entries[k] = v
+		}
+		for k, v := range m {
+			macs[k] = v
+		}
+	}
+	r.mu.Lock()
+	r.macs = macs
+	r.mu.Unlock()
+	for addr, name := range entries {
+		r.mu.Lock()
+		if r.m[addr] != name {
+			if r.m == nil {
+				r.m = map[string]string{}
+			}
+			r.m[addr] = name
+			r.mu.Unlock()
+			if t.OnDiscover != nil {
+				t.OnDiscover(addr, name, "DHCP")
+			}
+		} else {
+			r.mu.Unlock()
 		}
 	}
-	return "", ""
 }
 
-func (r *DHCP) readLease(ctx context.Context, file, format string) error {
-	f, err := os.Open(file)
+func readLeaseFile(f LeaseFile) (entries, macs map[string]string, err error) {
+	b, err := ioutil.ReadFile(f.Path)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	format := f.Format
+	if format == "" {
+		format = detectLeaseFormat(b)
 	}
-	var entries map[string]string
 	switch format {
 	case "isc-dhcpd":
-		entries, err = readDHCPDLease(f)
+		return readDHCPDLease(bytes.NewReader(b))
 	case "dnsmasq":
-		entries, err = readDNSMasqLease(f)
+		return readDNSMasqLease(bytes.NewReader(b))
+	case "kea":
+		return readKeaLease(bytes.NewReader(b))
 	default:
-		return fmt.Errorf("unknown format: %s", format)
+		return nil, nil, fmt.Errorf("unknown format: %s", format)
 	}
-	if err != nil {
-		return err
-	}
-	t := TraceFromCtx(ctx)
-	if len(entries) > 0 {
-		for addr, name := range entries {
-			r.mu.Lock()
-			if r.m[addr] != name {
-				if r.m == nil {
-					r.m = map[string]string{}
-				}
-				r.m[addr] = name
-				r.mu.Unlock()
-				if t.OnDiscover != nil {
-					t.OnDiscover(addr, name, "DHCP")
-				}
-			} else {
-				r.mu.Unlock()
-			}
+}
+
+// detectLeaseFormat returns the format of the lease file content b, based on
+// its first significant line.
+func detectLeaseFormat(b []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || line[0] == '#':
+			continue
+		case line[0] == '{' || line[0] == '[' || strings.HasPrefix(line, "address,"):
+			return "kea"
+		case strings.HasSuffix(line, ";") || strings.HasSuffix(line, "{"):
+			return "isc-dhcpd"
 		}
+		break
 	}
-	return nil
+	return "dnsmasq"
 }
 
-func readDHCPDLease(r io.Reader) (map[string]string, error) {
+func readDHCPDLease(r io.Reader) (entries, macs map[string]string, err error) {
 	s := bufio.NewScanner(r)
 	var name, ip, mac string
-	entries := map[string]string{}
+	entries = map[string]string{}
+	macs = map[string]string{}
 	for s.Scan() {
 		line := s.Text()
 		if strings.HasPrefix(line, "}") {
+			if ip != "" && mac != "" {
+				macs[ip] = mac
+			}
 			if name != "" {
 				if ip != "" {
 					entries[ip] = name
@@ -149,19 +260,207 @@ func readDHCPDLease(r io.Reader) (map[string]string, error) {
 			name = normalizeName(strings.Trim(fields[1], `";`))
 		}
 	}
-	return entries, s.Err()
+	return entries, macs, s.Err()
 }
 
-func readDNSMasqLease(r io.Reader) (map[string]string, error) {
+func readDNSMasqLease(r io.Reader) (entries, macs map[string]string, err error) {
 	s := bufio.NewScanner(r)
-	entries := map[string]string{}
+	entries = map[string]string{}
+	macs = map[string]string{}
+	v6 := false
 	for s.Scan() {
 		fields := strings.Fields(s.Text())
-		if len(fields) >= 5 {
-			name := normalizeName(fields[3])
-			entries[strings.ToLower(fields[1])] = name // MAC
-			entries[strings.ToLower(fields[2])] = name // IP
+		if len(fields) >= 1 && fields[0] == "duid" {
+			// The server DUID line starts the DHCPv6 leases:
+			// expiry iaid ip hostname client-duid
+			v6 = true
+			continue
+		}
+		if len(fields) < 5 {
+			continue
+		}
+		ip := strings.ToLower(fields[2])
+		mac := strings.ToLower(fields[1])
+		if v6 {
+			m := DUIDMAC(fields[4])
+			if m == nil {
+				mac = ""
+			} else {
+				mac = m.String()
+			}
+		}
+		if mac != "" {
+			macs[ip] = mac
+		}
+		if v6 && fields[3] == "*" {
+			continue
+		}
+		name := normalizeName(fields[3])
+		if mac != "" {
+			entries[mac] = name
+		}
+		entries[ip] = name
+	}
+	return entries, macs, s.Err()
+}
+
+// keaLease is a lease of the Kea DHCP server, as returned by the lease4-get-all
+// and lease6-get-all commands.
+type keaLease struct {
+	IPAddress string `json:"ip-address"`
+	HWAddress string `json:"hw-address"`
+	DUID      string `json:"duid"`
+	Hostname  string `json:"hostname"`
+	Type      string `json:"type"`
+	State     int    `json:"state"`
+	ValidLft  int    `json:"valid-lft"`
+}
+
+// readKeaLease reads the leases of Kea, either from its memfile lease file
+// (CSV) or from the JSON output of the lease4-get-all or lease6-get-all
+// commands.
+func readKeaLease(r io.Reader) (entries, macs map[string]string, err error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var leases []keaLease
+	if b = bytes.TrimSpace(b); len(b) > 0 && (b[0] == '{' || b[0] == '[') {
+		leases, err = readKeaJSONLease(b)
+	} else {
+		leases, err = readKeaCSVLease(bytes.NewReader(b))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	entries = map[string]string{}
+	macs = map[string]string{}
+	for _, l := range leases {
+		// Only the states default (0) is an active lease, declined (1)
+		// and expired-reclaimed (2) ones are not. Delegated prefixes are not
+		// client addresses.
+		if l.State != 0 || l.ValidLft == 0 || l.Type == "IA_PD" {
+			continue
+		}
+		ip := strings.ToLower(l.IPAddress)
+		mac := strings.ToLower(l.HWAddress)
+		if mac == "" && l.DUID != "" {
+			if m := DUIDMAC(l.DUID); m != nil {
+				mac = m.String()
+			}
+		}
+		if mac != "" {
+			macs[ip] = mac
+		}
+		if l.Hostname == "" {
+			continue
+		}
+		name := normalizeName(l.Hostname)
+		if mac != "" {
+			entries[mac] = name
+		}
+		entries[ip] = name
+	}
+	return entries, macs, nil
+}
+
+// readKeaJSONLease reads the leases of a command response, or of the list of
+// responses returned by the Kea Control Agent.
+func readKeaJSONLease(b []byte) ([]keaLease, error) {
+	type response struct {
+		Arguments struct {
+			Leases []keaLease `json:"leases"`
+		} `json:"arguments"`
+	}
+	var resps []response
+	var err error
+	if b[0] == '[' {
+		err = json.Unmarshal(b, &resps)
+	} else {
+		resps = make([]response, 1)
+		err = json.Unmarshal(b, &resps[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	var leases []keaLease
+	for _, resp := range resps {
+		leases = append(leases, resp.Arguments.Leases...)
+	}
+	return leases, nil
+}
+
+// readKeaCSVLease reads a memfile lease file. The file is a journal where the
+// last line of an address supersedes the previous ones.
+func readKeaCSVLease(r io.Reader) ([]keaLease, error) {
+	s := bufio.NewScanner(r)
+	var cols map[string]int
+	var leases []keaLease
+	index := map[string]int{}
+	for s.Scan() {
+		fields := strings.Split(strings.TrimSpace(s.Text()), ",")
+		if cols == nil {
+			if fields[0] != "address" {
+				return nil, errors.New("missing CSV header")
+			}
+			cols = map[string]int{}
+			for i, name := range fields {
+				cols[name] = i
+			}
+			continue
+		}
+		field := func(name string) string {
+			if i, found := cols[name]; found && i < len(fields) {
+				// Kea escapes the commas of the values.
+				return strings.Replace(fields[i], "&#x2c", ",", -1)
+			}
+			return ""
+		}
+		l := keaLease{
+			IPAddress: field("address"),
+			HWAddress: field("hwaddr"),
+			DUID:      field("duid"),
+			Hostname:  field("hostname"),
+		}
+		if l.IPAddress == "" {
+			continue
 		}
+		if field("lease_type") == "2" {
+			l.Type = "IA_PD"
+		}
+		l.State, _ = strconv.Atoi(field("state"))
+		l.ValidLft, _ = strconv.Atoi(field("valid_lifetime"))
+		if i, found := index[l.IPAddress]; found {
+			leases[i] = l
+			continue
+		}
+		index[l.IPAddress] = len(leases)
+		leases = append(leases, l)
+	}
+	return leases, s.Err()
+}
+
+// DUIDMAC returns the link-layer address embedded in the hex encoded DHCPv6
+// DUID, or nil if the DUID type does not embed one (DUID-EN and DUID-UUID).
+func DUIDMAC(duid string) net.HardwareAddr {
+	b, err := hex.DecodeString(strings.Replace(duid, ":", "", -1))
+	if err != nil || len(b) < 4 {
+		return nil
+	}
+	var addr []byte
+	switch binary.BigEndian.Uint16(b) {
+	case 1: // DUID-LLT: type, hardware type, time, link-layer address
+		if len(b) < 8 {
+			return nil
+		}
+		addr = b[8:]
+	case 3: // DUID-LL: type, hardware type, link-layer address
+		addr = b[4:]
+	default:
+		return nil
+	}
+	if len(addr) != 6 {
+		return nil
 	}
-	return entries, s.Err()
+	return net.HardwareAddr(addr)
 }
@@ -64,7 +64,7 @@ lease 10.0.1.3 {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			entries, err := readDHCPDLease(strings.NewReader(tt.file))
+			entries, _, err := readDHCPDLease(strings.NewReader(tt.file))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("readDHCPDLease() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -80,6 +80,7 @@ func Test_readDNSMasqLease(t *testing.T) {
 		name        string
 		file        string
 		wantEntries map[string]string
+		wantMACs    map[string]string
 		wantErr     bool
 	}{
 		{
@@ -88,6 +89,10 @@ func Test_readDNSMasqLease(t *testing.T) {
 56789 00:0f:66:4c:fc:c8 192.168.50.12 wrt54g 01:00:0f:66:4c:fc:c8
 86400 94:83:c4:01:0b:b0 192.168.50.11 GL-MT300N-V2-bb0 *
 77060 18:e8:29:af:bd:8a 192.168.50.111 ubnt *
+duid 00:01:00:01:26:5a:1f:3b:94:83:c4:01:0b:b0
+43200 20562 2001:db8::1d3f iphone 00:01:00:01:2a:4b:3c:2d:a4:83:e7:11:22:33
+43200 11 2001:db8::2a * 00:03:00:01:00:0f:66:4c:fc:c8
+43200 12 2001:db8::3b laptop 00:04:4c:4c:45:44:00:57:48:10:80:35:c2:c0:4f:52:30:32
 			`,
 			wantEntries: map[string]string{
 				"00:0f:66:4c:fc:c8": "wrt54g",
@@ -96,19 +101,123 @@ func Test_readDNSMasqLease(t *testing.T) {
 				"192.168.50.11":     "GL-MT300N-V2-bb0",
 				"18:e8:29:af:bd:8a": "ubnt",
 				"192.168.50.111":    "ubnt",
+				"a4:83:e7:11:22:33": "iphone",
+				"2001:db8::1d3f":    "iphone",
+				"2001:db8::3b":      "laptop",
+			},
+			wantMACs: map[string]string{
+				"192.168.50.12":  "00:0f:66:4c:fc:c8",
+				"192.168.50.11":  "94:83:c4:01:0b:b0",
+				"192.168.50.111": "18:e8:29:af:bd:8a",
+				"2001:db8::1d3f": "a4:83:e7:11:22:33",
+				"2001:db8::2a":   "00:0f:66:4c:fc:c8",
 			},
 			wantErr: false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			entries, err := readDNSMasqLease(strings.NewReader(tt.file))
+			entries, macs, err := readDNSMasqLease(strings.NewReader(tt.file))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("readDNSMasqLease() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if !reflect.DeepEqual(entries, tt.wantEntries) {
 				t.Errorf("readDNSMasqLease() entries = %v, want %v", entries, tt.wantEntries)
 			}
+			if !reflect.DeepEqual(macs, tt.wantMACs) {
+				t.Errorf("readDNSMasqLease() macs = %v, want %v", macs, tt.wantMACs)
+			}
 		})
 	}
 }
+
+func Test_readKeaLease(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		wantEntries map[string]string
+		wantMACs    map[string]string
+	}{
+		{
+			name: "CSV v4",
+			file: `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context,pool_id
+192.168.1.10,00:0f:66:4c:fc:c8,01:00:0f:66:4c:fc:c8,3600,1700003600,1,0,0,laptop.home.example.,0,,0
+192.168.1.11,94:83:c4:01:0b:b0,,3600,1700003600,1,0,0,,0,,0
+192.168.1.12,18:e8:29:af:bd:8a,,3600,1700003600,1,0,0,tv,0,,0
+192.168.1.12,18:e8:29:af:bd:8a,,0,1700003600,1,0,0,tv,0,,0
+192.168.1.13,a4:83:e7:11:22:33,,3600,1700003600,1,0,0,phone,2,,0
+`,
+			wantEntries: map[string]string{
+				"192.168.1.10":      "laptop",
+				"00:0f:66:4c:fc:c8": "laptop",
+			},
+			wantMACs: map[string]string{
+				"192.168.1.10": "00:0f:66:4c:fc:c8",
+				"192.168.1.11": "94:83:c4:01:0b:b0",
+			},
+		},
+		{
+			name: "CSV v6",
+			file: `address,duid,valid_lifetime,expire,subnet_id,pref_lifetime,lease_type,iaid,prefix_len,fqdn_fwd,fqdn_rev,hostname,hwaddr,state,user_context,hwtype,hwaddr_source,pool_id
+2001:db8::1d3f,00:01:00:01:2a:4b:3c:2d:a4:83:e7:11:22:33,3600,1700003600,1,1800,0,1,128,0,0,iphone,,0,,,,0
+2001:db8:1::,00:03:00:01:00:0f:66:4c:fc:c8,3600,1700003600,1,1800,2,2,56,0,0,router,,0,,,,0
+`,
+			wantEntries: map[string]string{
+				"2001:db8::1d3f":    "iphone",
+				"a4:83:e7:11:22:33": "iphone",
+			},
+			wantMACs: map[string]string{
+				"2001:db8::1d3f": "a4:83:e7:11:22:33",
+			},
+		},
+		{
+			name: "JSON",
+			file: `[{"arguments": {"leases": [
+	{"ip-address": "192.168.1.10", "hw-address": "00:0F:66:4C:FC:C8", "hostname": "laptop", "state": 0, "valid-lft": 3600},
+	{"ip-address": "192.168.1.11", "hw-address": "94:83:c4:01:0b:b0", "hostname": "old", "state": 1, "valid-lft": 3600}
+]}, "result": 0, "text": "2 IPv4 lease(s) found."}]`,
+			wantEntries: map[string]string{
+				"192.168.1.10":      "laptop",
+				"00:0f:66:4c:fc:c8": "laptop",
+			},
+			wantMACs: map[string]string{
+				"192.168.1.10": "00:0f:66:4c:fc:c8",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectLeaseFormat([]byte(tt.file)); got != "kea" {
+				t.Errorf("detectLeaseFormat() = %v, want kea", got)
+			}
+			entries, macs, err := readKeaLease(strings.NewReader(tt.file))
+			if err != nil {
+				t.Errorf("readKeaLease() error = %v", err)
+			}
+			if !reflect.DeepEqual(entries, tt.wantEntries) {
+				t.Errorf("readKeaLease() entries = %v, want %v", entries, tt.wantEntries)
+			}
+			if !reflect.DeepEqual(macs, tt.wantMACs) {
+				t.Errorf("readKeaLease() macs = %v, want %v", macs, tt.wantMACs)
+			}
+		})
+	}
+}
+
+func Test_detectLeaseFormat(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"# The format of this file is documented in the dhcpd.leases(5) manual page.\n\nauthoring-byte-order little-endian;\n", "isc-dhcpd"},
+		{"lease 10.0.1.4 {\n}\n", "isc-dhcpd"},
+		{"56789 00:0f:66:4c:fc:c8 192.168.50.12 wrt54g *\n", "dnsmasq"},
+		{"duid 00:01:00:01:26:5a:1f:3b:94:83:c4:01:0b:b0\n", "dnsmasq"},
+		{"", "dnsmasq"},
+	}
+	for _, tt := range tests {
+		if got := detectLeaseFormat([]byte(tt.file)); got != tt.want {
+			t.Errorf("detectLeaseFormat(%q) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
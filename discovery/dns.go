@@ -51,7 +51,7 @@ func (r *DNS) Start(ctx context.Context) error {
 					negCache[addr] = struct{}{}
 					continue
 				}
-				if name, err := queryPTR(servers[0], ip); err == nil {
+				if name, err := queryPTR(net.JoinHostPort(servers[0], "53"), ip); err == nil {
 					if isValidName(name) {
 						name = normalizeName(name)
 						r.mu.Lock()
@@ -107,11 +107,13 @@ func isPrivateIP(ip string) bool {
 	return false
 }
 
-func queryPTR(dns string, ip net.IP) (string, error) {
-	c, err := net.Dial("udp", net.JoinHostPort(dns, "53"))
+// queryPTR sends a PTR query for ip to the DNS (or LLMNR) server at addr.
+func queryPTR(addr string, ip net.IP) (string, error) {
+	c, err := net.Dial("udp", addr)
 	if err != nil {
 		return "", err
 	}
+	defer c.Close()
 	if err = c.SetDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
 		return "", err
 	}
@@ -0,0 +1,133 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileCheckInterval is the interval at which File checks whether the file
+// was modified.
+const fileCheckInterval = 30 * time.Second
+
+// File is a source of names and profiles maintained by the user in a file, for
+// networks where clients cannot be discovered (i.e. behind another router).
+// Each line holds an IP or MAC address, a name and an optional profile,
+// separated by spaces, like in /etc/ethers:
+//
+//   00:11:22:33:44:55  tv
+//   192.168.1.10       laptop  abcdef
+//
+// Empty lines and lines starting with # are ignored.
+type File struct {
+	Path string
+
+	mu       sync.RWMutex
+	names    map[string]string
+	profiles map[string]string
+	modTime  time.Time
+}
+
+// Load reads the file if it was modified since the last load.
+func (f *File) Load() error {
+	fi, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+	f.mu.RLock()
+	unchanged := fi.ModTime().Equal(f.modTime)
+	f.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	names, profiles, err := readClientsFile(r)
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.Path, err)
+	}
+	f.mu.Lock()
+	f.names, f.profiles, f.modTime = names, profiles, fi.ModTime()
+	f.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the file when it is modified until ctx is cancelled. Errors
+// are reported to the OnWarning function of the trace of ctx, the previous
+// content is kept.
+func (f *File) Watch(ctx context.Context) {
+	t := TraceFromCtx(ctx)
+	tick := time.NewTicker(fileCheckInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := f.Load(); err != nil && t.OnWarning != nil {
+				t.OnWarning(err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Lookup implements Source.
+func (f *File) Lookup(addr string) (string, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	name, found := f.names[addr]
+	return name, found
+}
+
+// Profile returns the profile set for the client with mac or ip, the MAC
+// address taking precedence, or an empty string.
+func (f *File) Profile(ip net.IP, mac net.HardwareAddr) string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if mac != nil {
+		if prof := f.profiles[mac.String()]; prof != "" {
+			return prof
+		}
+	}
+	if ip != nil {
+		return f.profiles[ip.String()]
+	}
+	return ""
+}
+
+func readClientsFile(r io.Reader) (names, profiles map[string]string, err error) {
+	names = map[string]string{}
+	profiles = map[string]string{}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, nil, fmt.Errorf("line %d: expected address, name and optional profile", line)
+		}
+		addr := strings.ToLower(fields[0])
+		if ip := net.ParseIP(addr); ip != nil {
+			addr = ip.String()
+		} else if mac, err := net.ParseMAC(addr); err == nil {
+			addr = mac.String()
+		} else {
+			return nil, nil, fmt.Errorf("line %d: %s: invalid IP or MAC address", line, fields[0])
+		}
+		names[addr] = fields[1]
+		if len(fields) == 3 {
+			profiles[addr] = fields[2]
+		}
+	}
+	return names, profiles, s.Err()
+}
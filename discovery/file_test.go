@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_readClientsFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		file         string
+		wantNames    map[string]string
+		wantProfiles map[string]string
+		wantErr      bool
+	}{
+		{
+			name: "Valid file",
+			file: `
+# Living room
+00:11:22:33:44:AA  tv
+192.168.1.10       laptop  abcdef
+2001:DB8::1        phone   123456
+			`,
+			wantNames: map[string]string{
+				"00:11:22:33:44:aa": "tv",
+				"192.168.1.10":      "laptop",
+				"2001:db8::1":       "phone",
+			},
+			wantProfiles: map[string]string{
+				"192.168.1.10": "abcdef",
+				"2001:db8::1":  "123456",
+			},
+		},
+		{
+			name:    "Missing name",
+			file:    "192.168.1.10\n",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid address",
+			file:    "tv 192.168.1.10\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, profiles, err := readClientsFile(strings.NewReader(tt.file))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readClientsFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("readClientsFile() names = %v, want %v", names, tt.wantNames)
+			}
+			if !reflect.DeepEqual(profiles, tt.wantProfiles) {
+				t.Errorf("readClientsFile() profiles = %v, want %v", profiles, tt.wantProfiles)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package discovery
+
+import (
+	"context"
+	"net"
+)
+
+// LLMNR discovers client names by sending them reverse LLMNR (RFC 4795)
+// queries. LLMNR is answered by most Windows and systemd-resolved hosts.
+type LLMNR struct {
+	p prober
+}
+
+func (r *LLMNR) Start(ctx context.Context) error {
+	r.p.start(ctx, "LLMNR", func(ip net.IP) (string, error) {
+		return queryPTR(net.JoinHostPort(ip.String(), "5355"), ip)
+	})
+	return nil
+}
+
+func (r *LLMNR) Lookup(addr string) (string, bool) {
+	return r.p.lookup(addr)
+}
@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// NetBIOS discovers client names by sending them NetBIOS node status
+// (NBSTAT) queries. NetBIOS is answered by Windows and Samba hosts.
+type NetBIOS struct {
+	p prober
+}
+
+func (r *NetBIOS) Start(ctx context.Context) error {
+	r.p.start(ctx, "NetBIOS", queryNetBIOS)
+	return nil
+}
+
+func (r *NetBIOS) Lookup(addr string) (string, bool) {
+	return r.p.lookup(addr)
+}
+
+// nbstatQuery is a NetBIOS node status request for the wildcard name.
+var nbstatQuery = func() []byte {
+	b := []byte{
+		0x00, 0x00, // ID
+		0x00, 0x00, // Flags
+		0x00, 0x01, // QDCOUNT
+		0x00, 0x00, // ANCOUNT
+		0x00, 0x00, // NSCOUNT
+		0x00, 0x00, // ARCOUNT
+		0x20, // Name length
+	}
+	// First level encoding of "*" padded with nulls.
+	b = append(b, 'C', 'K')
+	for i := 0; i < 15; i++ {
+		b = append(b, 'A', 'A')
+	}
+	return append(b,
+		0x00,       // Name end
+		0x00, 0x21, // Type NBSTAT
+		0x00, 0x01, // Class IN
+	)
+}()
+
+func queryNetBIOS(ip net.IP) (string, error) {
+	if ip.To4() == nil {
+		return "", errors.New("not an IPv4")
+	}
+	c, err := net.Dial("udp", net.JoinHostPort(ip.String(), "137"))
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	if err = c.SetDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		return "", err
+	}
+	if _, err = c.Write(nbstatQuery); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 1024)
+	n, err := c.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return parseNBSTAT(buf[:n])
+}
+
+// parseNBSTAT returns the workstation name found in a node status response.
+func parseNBSTAT(b []byte) (string, error) {
+	if len(b) < 12 || binary.BigEndian.Uint16(b[6:8]) == 0 {
+		return "", errors.New("no answer")
+	}
+	off := 12
+	// Skip the answer name.
+	for off < len(b) {
+		l := int(b[off])
+		if l == 0 {
+			off++
+			break
+		}
+		if l&0xc0 == 0xc0 {
+			off += 2
+			break
+		}
+		off += l + 1
+	}
+	// Type, class, TTL, rdlength.
+	off += 10
+	if off >= len(b) {
+		return "", errors.New("short response")
+	}
+	count := int(b[off])
+	off++
+	for i := 0; i < count && off+18 <= len(b); i, off = i+1, off+18 {
+		suffix := b[off+15]
+		flags := binary.BigEndian.Uint16(b[off+16 : off+18])
+		// Unique (non group) workstation name.
+		if suffix == 0x00 && flags&0x8000 == 0 {
+			return strings.ToLower(strings.TrimRight(string(b[off:off+15]), " \x00")), nil
+		}
+	}
+	return "", errors.New("not found")
+}
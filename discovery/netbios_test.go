@@ -0,0 +1,24 @@
+package discovery
+
+import "testing"
+
+func Test_parseNBSTAT(t *testing.T) {
+	// Header and answer name from the query.
+	resp := append([]byte{}, nbstatQuery[:len(nbstatQuery)-4]...)
+	resp[2], resp[5], resp[7] = 0x84, 0x00, 0x01
+	resp = append(resp,
+		0x00, 0x21, 0x00, 0x01, // Type, class
+		0x00, 0x00, 0x00, 0x00, // TTL
+		0x00, 0x41, // Length
+		0x02, // Number of names
+	)
+	resp = append(resp, "WORKGROUP      \x00\x84\x00"...)
+	resp = append(resp, "DESKTOP-42     \x00\x04\x00"...)
+	got, err := parseNBSTAT(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "desktop-42"; got != want {
+		t.Errorf("parseNBSTAT() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// prober implements the common logic of sources actively querying LAN
+// clients for their name.
+type prober struct {
+	mu sync.RWMutex
+	m  map[string]string
+	in chan string
+}
+
+func (r *prober) start(ctx context.Context, source string, query func(ip net.IP) (string, error)) {
+	negCacheCreated := time.Now()
+	negCache := map[string]struct{}{}
+	r.mu.Lock()
+	r.in = make(chan string, 10)
+	r.mu.Unlock()
+
+	go func() {
+		t := TraceFromCtx(ctx)
+		for {
+			select {
+			case addr := <-r.in:
+				if time.Since(negCacheCreated) > 5*time.Minute {
+					negCacheCreated = time.Now()
+					negCache = map[string]struct{}{}
+				}
+				if _, found := negCache[addr]; found {
+					continue
+				}
+				ip := net.ParseIP(addr)
+				if ip == nil || !isPrivateIP(addr) && !ip.IsLinkLocalUnicast() {
+					// Most likely a MAC or a non LAN address.
+					negCache[addr] = struct{}{}
+					continue
+				}
+				name, err := query(ip)
+				if err != nil || !isValidName(name) {
+					negCache[addr] = struct{}{}
+					if err != nil && t.OnWarning != nil {
+						t.OnWarning(fmt.Sprintf("%s: %s: %v", source, addr, err))
+					}
+					continue
+				}
+				name = normalizeName(name)
+				r.mu.Lock()
+				if r.m[addr] != name {
+					if r.m == nil {
+						r.m = map[string]string{}
+					}
+					r.m[addr] = name
+					r.mu.Unlock()
+					if t.OnDiscover != nil {
+						t.OnDiscover(addr, name, source)
+					}
+				} else {
+					r.mu.Unlock()
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (r *prober) lookup(addr string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name, found := r.m[addr]
+	if !found {
+		select {
+		case r.in <- addr:
+		default:
+		}
+	}
+	return name, found
+}
@@ -3,29 +3,108 @@ package discovery
 import (
 	"context"
 	"fmt"
+	"net"
+	"sort"
 	"strings"
+	"sync"
+
+	"github.com/nextdns/nextdns/arp"
+)
+
+// Confidence levels of the sources, from the most to the least trusted. The
+// sources of a Resolver are queried in this order and the name of the most
+// trusted one wins.
+const (
+	// ConfidenceStatic is for names set by the user (i.e. static map, hosts).
+	ConfidenceStatic = 100
+
+	// ConfidenceDHCP is for names sent by the clients in their DHCP requests.
+	ConfidenceDHCP = 80
+
+	// ConfidenceMDNS is for names announced by the clients over mDNS.
+	ConfidenceMDNS = 60
+
+	// ConfidenceDNS is for names returned by the PTR records of the local DNS.
+	ConfidenceDNS = 40
+
+	// ConfidenceHeuristic is for names guessed by probing the clients (i.e.
+	// LLMNR, NetBIOS).
+	ConfidenceHeuristic = 20
 )
 
+// arpPenalty is removed from the confidence of the names found for the MAC
+// address the neighbor table (ARP/NDP) associates to an IP, so they rank
+// after the names the same source knows for the IP itself.
+const arpPenalty = 5
+
+// maxSeen is the maximum number of client addresses kept for Clients.
+const maxSeen = 1000
+
 type Resolver struct {
-	s []Source
+	p []provider
+
+	mu   sync.Mutex
+	seen map[string]struct{}
 }
 
 type Source interface {
 	Lookup(addr string) (string, bool)
 }
 
+// MACSource is implemented by sources knowing the MAC address of clients
+// outside of the neighbor tables.
+type MACSource interface {
+	LookupMAC(ip string) net.HardwareAddr
+}
+
 type Starter interface {
 	Start(ctx context.Context) error
 }
 
-func (r *Resolver) Register(s Source) {
-	r.s = append(r.s, s)
+type provider struct {
+	name       string
+	confidence int
+	s          Source
+}
+
+// Candidate is a name returned by a source for a client.
+type Candidate struct {
+	Source     string `json:"source"`
+	Name       string `json:"name"`
+	Confidence int    `json:"confidence"`
+}
+
+// Client is the result of the resolution of a client name.
+type Client struct {
+	Addr string `json:"addr"`
+	MAC  string `json:"mac,omitempty"`
+
+	// Name, Source and Confidence are those of the winning candidate.
+	Name       string `json:"name"`
+	Source     string `json:"source,omitempty"`
+	Confidence int    `json:"confidence,omitempty"`
+
+	// Candidates are the names returned by all the sources, best first.
+	Candidates []Candidate `json:"candidates,omitempty"`
+
+	// Conflict is true when the sources disagree on the name.
+	Conflict bool `json:"conflict,omitempty"`
+}
+
+// Register adds s to the chain of sources with the name shown in the client
+// table and its confidence level. Sources with the same confidence are
+// queried in registration order.
+func (r *Resolver) Register(name string, confidence int, s Source) {
+	r.p = append(r.p, provider{name: name, confidence: confidence, s: s})
+	sort.SliceStable(r.p, func(i, j int) bool {
+		return r.p[i].confidence > r.p[j].confidence
+	})
 }
 
 func (r *Resolver) Start(ctx context.Context) {
 	t := TraceFromCtx(ctx)
-	for _, s := range r.s {
-		if s, ok := s.(Starter); ok {
+	for _, p := range r.p {
+		if s, ok := p.s.(Starter); ok {
 			if err := s.Start(ctx); err != nil {
 				if t.OnWarning != nil {
 					t.OnWarning(fmt.Sprintf("%T: %v", s, err))
@@ -35,12 +114,107 @@ func (r *Resolver) Start(ctx context.Context) {
 	}
 }
 
+// Lookup returns the name of the client with addr as IP or MAC address, from
+// the most trusted source knowing it. For an IP, the MAC found by LookupMAC is
+// looked up as well.
 func (r *Resolver) Lookup(addr string) string {
 	addr = strings.ToLower(addr)
-	for _, s := range r.s {
-		if name, found := s.Lookup(addr); found {
-			return name
+	if net.ParseIP(addr) != nil {
+		r.mu.Lock()
+		if r.seen == nil || len(r.seen) >= maxSeen {
+			r.seen = map[string]struct{}{}
 		}
+		r.seen[addr] = struct{}{}
+		r.mu.Unlock()
 	}
-	return ""
+	return r.resolve(addr, false).Name
+}
+
+// Resolve returns the name of the client with addr like Lookup, with the
+// candidates of all the sources.
+func (r *Resolver) Resolve(addr string) Client {
+	return r.resolve(strings.ToLower(addr), true)
+}
+
+// Clients returns the resolution of the clients recently looked up by IP,
+// sorted by address.
+func (r *Resolver) Clients() []Client {
+	r.mu.Lock()
+	addrs := make([]string, 0, len(r.seen))
+	for addr := range r.seen {
+		addrs = append(addrs, addr)
+	}
+	r.mu.Unlock()
+	sort.Strings(addrs)
+	clients := make([]Client, 0, len(addrs))
+	for _, addr := range addrs {
+		clients = append(clients, r.resolve(addr, true))
+	}
+	return clients
+}
+
+// resolve queries the sources for addr, and for its MAC if addr is an IP. If
+// all is false, it stops as soon as the remaining sources cannot win.
+func (r *Resolver) resolve(addr string, all bool) Client {
+	c := Client{Addr: addr}
+	var mac string
+	if ip := net.ParseIP(addr); ip != nil {
+		if hw := r.LookupMAC(ip); hw != nil {
+			mac = hw.String()
+			c.MAC = mac
+		}
+	}
+	for _, p := range r.p {
+		if !all && len(c.Candidates) > 0 && p.confidence <= c.Candidates[0].Confidence {
+			break
+		}
+		if name, found := p.s.Lookup(addr); found {
+			c.Candidates = append(c.Candidates, Candidate{p.name, name, p.confidence})
+		} else if mac != "" {
+			if name, found := p.s.Lookup(mac); found {
+				c.Candidates = append(c.Candidates, Candidate{p.name, name, p.confidence - arpPenalty})
+			}
+		}
+	}
+	if len(c.Candidates) == 0 {
+		return c
+	}
+	sort.SliceStable(c.Candidates, func(i, j int) bool {
+		return c.Candidates[i].Confidence > c.Candidates[j].Confidence
+	})
+	best := c.Candidates[0]
+	c.Name, c.Source, c.Confidence = best.Name, best.Source, best.Confidence
+	for _, cand := range c.Candidates[1:] {
+		if !strings.EqualFold(cand.Name, best.Name) {
+			c.Conflict = true
+		}
+	}
+	return c
+}
+
+// LookupMAC returns the MAC address of the client with ip, searched in the
+// neighbor tables first and then in the sources, like the DHCPv6 leases which
+// identify the clients using temporary addresses by their DUID.
+func (r *Resolver) LookupMAC(ip net.IP) net.HardwareAddr {
+	if mac := arp.SearchMAC(ip); mac != nil {
+		return mac
+	}
+	addr := ip.String()
+	for _, p := range r.p {
+		if s, ok := p.s.(MACSource); ok {
+			if mac := s.LookupMAC(addr); mac != nil {
+				return mac
+			}
+		}
+	}
+	return nil
+}
+
+// Static is a source of names set by the user, indexed by lowercase IP or MAC
+// address.
+type Static map[string]string
+
+func (s Static) Lookup(addr string) (string, bool) {
+	name, found := s[addr]
+	return name, found
 }
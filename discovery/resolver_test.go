@@ -21,3 +21,36 @@ func Test_isValidName(t *testing.T) {
 		})
 	}
 }
+
+func TestResolver_Resolve(t *testing.T) {
+	r := &Resolver{}
+	r.Register("heuristic", ConfidenceHeuristic, Static{"192.0.2.1": "DESKTOP-1", "192.0.2.3": "laptop"})
+	r.Register("mdns", ConfidenceMDNS, Static{"192.0.2.1": "macbook", "192.0.2.2": "printer"})
+	r.Register("static", ConfidenceStatic, Static{"192.0.2.3": "Laptop"})
+	tests := []struct {
+		addr         string
+		wantName     string
+		wantSource   string
+		wantConflict bool
+	}{
+		{"192.0.2.1", "macbook", "mdns", true},
+		{"192.0.2.2", "printer", "mdns", false},
+		{"192.0.2.3", "Laptop", "static", false},
+		{"192.0.2.4", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			c := r.Resolve(tt.addr)
+			if c.Name != tt.wantName || c.Source != tt.wantSource || c.Conflict != tt.wantConflict {
+				t.Errorf("Resolve() = %s from %s (conflict %v), want %s from %s (conflict %v)",
+					c.Name, c.Source, c.Conflict, tt.wantName, tt.wantSource, tt.wantConflict)
+			}
+			if got := r.Lookup(tt.addr); got != tt.wantName {
+				t.Errorf("Lookup() = %s, want %s", got, tt.wantName)
+			}
+		})
+	}
+	if got := len(r.Clients()); got != len(tests) {
+		t.Errorf("Clients() returned %d clients, want %d", got, len(tests))
+	}
+}
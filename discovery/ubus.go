@@ -0,0 +1,103 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UBUS discovers client names using the host hints maintained by OpenWRT
+// (through ubus luci-rpc), which combine DHCP leases, ARP and neighbor tables.
+type UBUS struct {
+	mu sync.RWMutex
+	m  map[string]string
+}
+
+func (r *UBUS) Start(ctx context.Context) error {
+	if _, err := exec.LookPath("ubus"); err != nil {
+		return nil
+	}
+	t := TraceFromCtx(ctx)
+	if err := r.refresh(ctx); err != nil {
+		// Older OpenWRT versions do not have luci-rpc.
+		return err
+	}
+	go func() {
+		for {
+			select {
+			case <-time.After(30 * time.Second):
+				if err := r.refresh(ctx); err != nil && t.OnWarning != nil {
+					t.OnWarning(fmt.Sprintf("ubus: %v", err))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *UBUS) Lookup(addr string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name, found := r.m[addr]
+	return name, found
+}
+
+func (r *UBUS) refresh(ctx context.Context) error {
+	b, err := exec.CommandContext(ctx, "ubus", "call", "luci-rpc", "getHostHints").Output()
+	if err != nil {
+		return err
+	}
+	entries, err := parseHostHints(b)
+	if err != nil {
+		return err
+	}
+	t := TraceFromCtx(ctx)
+	for addr, name := range entries {
+		r.mu.Lock()
+		if r.m[addr] != name {
+			if r.m == nil {
+				r.m = map[string]string{}
+			}
+			r.m[addr] = name
+			r.mu.Unlock()
+			if t.OnDiscover != nil {
+				t.OnDiscover(addr, name, "UBUS")
+			}
+		} else {
+			r.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func parseHostHints(b []byte) (map[string]string, error) {
+	var hints map[string]struct {
+		Name     string   `json:"name"`
+		IPAddrs  []string `json:"ipaddrs"`
+		IP6Addrs []string `json:"ip6addrs"`
+	}
+	if err := json.Unmarshal(b, &hints); err != nil {
+		return nil, err
+	}
+	entries := map[string]string{}
+	for mac, h := range hints {
+		if !isValidName(h.Name) {
+			continue
+		}
+		name := normalizeName(h.Name)
+		entries[strings.ToLower(mac)] = name
+		for _, ip := range h.IPAddrs {
+			entries[strings.ToLower(ip)] = name
+		}
+		for _, ip := range h.IP6Addrs {
+			entries[strings.ToLower(ip)] = name
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,25 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseHostHints(t *testing.T) {
+	b := []byte(`{
+	"AA:BB:CC:DD:EE:FF": {"name": "laptop", "ipaddrs": ["192.168.1.10"], "ip6addrs": ["FD00::10"]},
+	"11:22:33:44:55:66": {"ipaddrs": ["192.168.1.11"]}
+}`)
+	got, err := parseHostHints(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"aa:bb:cc:dd:ee:ff": "laptop",
+		"192.168.1.10":      "laptop",
+		"fd00::10":          "laptop",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseHostHints() = %v, want %v", got, want)
+	}
+}
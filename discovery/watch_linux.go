@@ -0,0 +1,75 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// watchFiles sends to events each time inotify reports one of paths as
+// modified or replaced, until ctx is done. The parent directories are watched
+// so files replaced by a rename, or created later, are still tracked.
+func watchFiles(ctx context.Context, paths []string, events chan<- struct{}) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	names := map[int32]map[string]bool{}
+	for _, path := range paths {
+		dir, name := filepath.Split(path)
+		if dir == "" {
+			dir = "."
+		}
+		wd, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_MODIFY|syscall.IN_CLOSE_WRITE|syscall.IN_CREATE|syscall.IN_MOVED_TO)
+		if err != nil {
+			syscall.Close(fd)
+			return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+		}
+		if names[int32(wd)] == nil {
+			names[int32(wd)] = map[string]bool{}
+		}
+		names[int32(wd)][name] = true
+	}
+	// The fd is non blocking so reads are handled by the runtime poller and
+	// interrupted by Close.
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	go func() {
+		buf := make([]byte, 8192)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			changed := false
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				end := off + syscall.SizeofInotifyEvent + int(ev.Len)
+				if end > n {
+					break
+				}
+				name := buf[off+syscall.SizeofInotifyEvent : end]
+				if i := bytes.IndexByte(name, 0); i != -1 {
+					name = name[:i]
+				}
+				if names[ev.Wd][string(name)] {
+					changed = true
+				}
+				off = end
+			}
+			if changed {
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return nil
+}
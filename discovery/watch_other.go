@@ -0,0 +1,11 @@
+// +build !linux
+
+package discovery
+
+import "context"
+
+// watchFiles is not supported on this platform, changes are only detected by
+// polling.
+func watchFiles(ctx context.Context, paths []string, events chan<- struct{}) error {
+	return nil
+}
@@ -0,0 +1,260 @@
+// Package docker learns the names and addresses of the running Docker
+// containers from the Docker Engine API, to name the containers sending
+// queries and answer the queries for their names under the docker. domain.
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// Domain is the domain the container names are answered under.
+const Domain = "docker."
+
+// EmbeddedDNS is the address of the DNS server Docker embeds in the containers
+// of user-defined networks, answering the names of the other containers.
+const EmbeddedDNS = "127.0.0.11"
+
+// refreshInterval is the interval at which the list of containers is
+// refreshed.
+const refreshInterval = 10 * time.Second
+
+// ttl is the TTL of the answers for container names, kept low as the
+// addresses change when containers are recreated.
+const ttl = 10
+
+// Container is a running container.
+type Container struct {
+	Name string
+	IPs  []net.IP
+	MACs []net.HardwareAddr
+}
+
+// Resolver answers the queries for <name>.docker with the addresses of the
+// running containers and sends other queries to Upstream. It implements the
+// discovery.Source interface, naming the clients with the container names.
+type Resolver struct {
+	// Socket is the path of the Docker Engine API socket. If empty, the
+	// containers are not listed.
+	Socket string
+
+	// Embedded specifies that the daemon runs in a container using the Docker
+	// embedded DNS server. Single-label names, the names of the containers
+	// of the same networks, are resolved by the embedded server.
+	Embedded bool
+
+	// Upstream resolves the other queries.
+	Upstream resolver.Resolver
+
+	// ErrorLog specifies an optional log function for the errors listing the
+	// containers. An error is only logged once until it changes.
+	ErrorLog func(error)
+
+	client *http.Client
+	dns53  resolver.DNS53
+
+	mu     sync.RWMutex
+	byName map[string][]net.IP
+	byAddr map[string]string
+}
+
+// Start refreshes the list of containers until ctx is cancelled.
+func (r *Resolver) Start(ctx context.Context) {
+	if r.Socket == "" {
+		return
+	}
+	r.client = &http.Client{
+		Timeout: refreshInterval,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", r.Socket)
+			},
+		},
+	}
+	t := time.NewTicker(refreshInterval)
+	defer t.Stop()
+	var lastErr string
+	for {
+		err := r.refresh(ctx)
+		if err != nil && err.Error() != lastErr && ctx.Err() == nil && r.ErrorLog != nil {
+			r.ErrorLog(err)
+		}
+		if err != nil {
+			lastErr = err.Error()
+		} else {
+			lastErr = ""
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Resolver) refresh(ctx context.Context) error {
+	req, err := http.NewRequest("GET", "http://docker/containers/json", nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("list containers: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("list containers: %s", res.Status)
+	}
+	containers, err := parseContainers(json.NewDecoder(res.Body))
+	if err != nil {
+		return fmt.Errorf("list containers: %v", err)
+	}
+	r.set(containers)
+	return nil
+}
+
+// parseContainers parses the response of the /containers/json endpoint.
+func parseContainers(dec *json.Decoder) ([]Container, error) {
+	var list []struct {
+		Names           []string
+		NetworkSettings struct {
+			Networks map[string]struct {
+				IPAddress         string
+				GlobalIPv6Address string
+				MacAddress        string
+			}
+		}
+	}
+	if err := dec.Decode(&list); err != nil {
+		return nil, err
+	}
+	var containers []Container
+	for _, c := range list {
+		if len(c.Names) == 0 {
+			continue
+		}
+		// Names are prefixed by a slash, the first one is the container
+		// name, the others are legacy links.
+		ct := Container{Name: strings.ToLower(strings.TrimPrefix(c.Names[0], "/"))}
+		for _, n := range c.NetworkSettings.Networks {
+			for _, addr := range []string{n.IPAddress, n.GlobalIPv6Address} {
+				if ip := net.ParseIP(addr); ip != nil {
+					ct.IPs = append(ct.IPs, ip)
+				}
+			}
+			if mac, err := net.ParseMAC(n.MacAddress); err == nil {
+				ct.MACs = append(ct.MACs, mac)
+			}
+		}
+		containers = append(containers, ct)
+	}
+	return containers, nil
+}
+
+// set replaces the known containers with containers.
+func (r *Resolver) set(containers []Container) {
+	byName := map[string][]net.IP{}
+	byAddr := map[string]string{}
+	for _, c := range containers {
+		byName[c.Name] = c.IPs
+		for _, ip := range c.IPs {
+			byAddr[ip.String()] = c.Name
+		}
+		for _, mac := range c.MACs {
+			byAddr[mac.String()] = c.Name
+		}
+	}
+	r.mu.Lock()
+	r.byName, r.byAddr = byName, byAddr
+	r.mu.Unlock()
+}
+
+// Lookup implements discovery.Source interface.
+func (r *Resolver) Lookup(addr string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name, found := r.byAddr[strings.ToLower(addr)]
+	return name, found
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	name := strings.ToLower(q.Name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	if r.Embedded && strings.IndexByte(name, '.') == len(name)-1 && name != "." {
+		eq := q
+		// Keep the payload intact for Upstream.
+		eq.Payload = append([]byte(nil), q.Payload...)
+		if n, i, err := r.dns53.Exchange(ctx, eq, buf, net.JoinHostPort(EmbeddedDNS, "53")); err == nil {
+			i.Source = "docker embedded DNS"
+			return n, i, nil
+		}
+		// The embedded server is down, the name may still be known upstream.
+	}
+	if !strings.HasSuffix(name, "."+Domain) {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	i.Source = "docker"
+	r.mu.RLock()
+	ips, found := r.byName[strings.TrimSuffix(name, "."+Domain)]
+	r.mu.RUnlock()
+
+	var m dnsmessage.Message
+	if err = m.Unpack(q.Payload); err != nil {
+		return 0, i, err
+	}
+	if len(m.Questions) == 0 {
+		return 0, i, errors.New("docker: no question")
+	}
+	q1 := m.Questions[0]
+	m.Header.Response = true
+	m.Header.Authoritative = true
+	m.Header.RecursionAvailable = true
+	m.Header.RCode = dnsmessage.RCodeSuccess
+	m.Answers, m.Authorities = nil, nil
+	additionals := m.Additionals[:0]
+	for _, rr := range m.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			additionals = append(additionals, rr)
+		}
+	}
+	m.Additionals = additionals
+	if !found {
+		m.Header.RCode = dnsmessage.RCodeNameError
+	}
+	for _, ip := range ips {
+		rh := dnsmessage.ResourceHeader{Name: q1.Name, Class: dnsmessage.ClassINET, TTL: ttl}
+		if ip4 := ip.To4(); ip4 != nil && (q1.Type == dnsmessage.TypeA || q1.Type == dnsmessage.TypeALL) {
+			var a [4]byte
+			copy(a[:], ip4)
+			rh.Type = dnsmessage.TypeA
+			m.Answers = append(m.Answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AResource{A: a}})
+		} else if ip4 == nil && (q1.Type == dnsmessage.TypeAAAA || q1.Type == dnsmessage.TypeALL) {
+			var aaaa [16]byte
+			copy(aaaa[:], ip)
+			rh.Type = dnsmessage.TypeAAAA
+			m.Answers = append(m.Answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AAAAResource{AAAA: aaaa}})
+		}
+	}
+	b, err := m.AppendPack(buf[:0])
+	if err != nil {
+		return 0, i, err
+	}
+	if len(b) > len(buf) {
+		return 0, i, errors.New("docker: response too large")
+	}
+	return len(b), i, nil
+}
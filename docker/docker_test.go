@@ -0,0 +1,108 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+const containersJSON = `[
+  {
+    "Id": "8dfafdbc3a40",
+    "Names": ["/Web"],
+    "NetworkSettings": {
+      "Networks": {
+        "bridge": {
+          "IPAddress": "172.17.0.2",
+          "GlobalIPv6Address": "2001:db8::2",
+          "MacAddress": "02:42:ac:11:00:02"
+        }
+      }
+    }
+  },
+  {
+    "Id": "9cd87474be90",
+    "Names": ["/db"],
+    "NetworkSettings": {"Networks": {"host": {"IPAddress": "", "MacAddress": ""}}}
+  }
+]`
+
+func TestParseContainers(t *testing.T) {
+	got, err := parseContainers(json.NewDecoder(strings.NewReader(containersJSON)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, _ := net.ParseMAC("02:42:ac:11:00:02")
+	want := []Container{
+		{Name: "web", IPs: []net.IP{net.ParseIP("172.17.0.2"), net.ParseIP("2001:db8::2")}, MACs: []net.HardwareAddr{mac}},
+		{Name: "db"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseContainers() = %+v, want %+v", got, want)
+	}
+}
+
+type nxResolver struct{}
+
+func (nxResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	return 0, resolver.ResolveInfo{Source: "upstream"}, nil
+}
+
+func TestResolver(t *testing.T) {
+	r := &Resolver{Upstream: nxResolver{}}
+	containers, _ := parseContainers(json.NewDecoder(strings.NewReader(containersJSON)))
+	r.set(containers)
+
+	if name, _ := r.Lookup("02:42:AC:11:00:02"); name != "web" {
+		t.Errorf("Lookup(mac) = %q, want web", name)
+	}
+	if name, _ := r.Lookup("172.17.0.2"); name != "web" {
+		t.Errorf("Lookup(ip) = %q, want web", name)
+	}
+
+	tests := []struct {
+		name    string
+		typ     dnsmessage.Type
+		source  string
+		rcode   dnsmessage.RCode
+		answers int
+	}{
+		{"web.docker.", dnsmessage.TypeA, "docker", dnsmessage.RCodeSuccess, 1},
+		{"WEB.docker.", dnsmessage.TypeAAAA, "docker", dnsmessage.RCodeSuccess, 1},
+		{"db.docker.", dnsmessage.TypeA, "docker", dnsmessage.RCodeSuccess, 0},
+		{"other.docker.", dnsmessage.TypeA, "docker", dnsmessage.RCodeNameError, 0},
+		{"example.com.", dnsmessage.TypeA, "upstream", dnsmessage.RCodeSuccess, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+			_ = b.StartQuestions()
+			_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(tt.name), Type: tt.typ, Class: dnsmessage.ClassINET})
+			q, _ := b.Finish()
+			buf := make([]byte, 512)
+			n, i, err := r.Resolve(context.Background(), resolver.Query{Name: tt.name, Payload: q}, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i.Source != tt.source {
+				t.Fatalf("source = %q, want %q", i.Source, tt.source)
+			}
+			if tt.source != "docker" {
+				return
+			}
+			var m dnsmessage.Message
+			if err := m.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if m.Header.RCode != tt.rcode || len(m.Answers) != tt.answers {
+				t.Errorf("got %v with %d answers, want %v with %d answers", m.Header.RCode, len(m.Answers), tt.rcode, tt.answers)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Package downgrade detects queries being answered over the plain DNS fallback
+// for too long, so a silent loss of encryption and filtering gets noticed.
+package downgrade
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultThreshold is the default value for Monitor Threshold.
+const DefaultThreshold = 5 * time.Minute
+
+// Alert is reported when a downgrade lasts longer than the threshold, and when
+// it ends.
+type Alert struct {
+	Time     time.Time     `json:"time"`
+	Resolved bool          `json:"resolved"`
+	Since    time.Time     `json:"since"`
+	Duration time.Duration `json:"duration"`
+	// Endpoint is the plain DNS endpoint when downgraded, or the encrypted
+	// endpoint switched back to when resolved.
+	Endpoint string `json:"endpoint"`
+}
+
+func (a Alert) String() string {
+	if a.Resolved {
+		return fmt.Sprintf("Downgrade resolved: queries answered over %s after %v over plain DNS",
+			a.Endpoint, a.Duration.Round(time.Second))
+	}
+	return fmt.Sprintf("Downgrade detected: queries answered over plain DNS (%s) for %v, they are neither encrypted nor filtered",
+		a.Endpoint, a.Duration.Round(time.Second))
+}
+
+// Monitor tracks whether the active endpoint uses plain DNS and reports an
+// alert once it has for longer than Threshold.
+type Monitor struct {
+	// Threshold is the duration of a downgrade before it is reported. If zero,
+	// DefaultThreshold is used.
+	Threshold time.Duration
+
+	// OnAlert is called when a downgrade is detected and when it is resolved.
+	OnAlert func(Alert)
+
+	mu       sync.Mutex
+	since    time.Time
+	endpoint string
+	timer    *time.Timer
+	alerted  bool
+}
+
+// SetEndpoint reports the active endpoint and whether it uses plain DNS.
+func (m *Monitor) SetEndpoint(endpoint string, plain bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if plain {
+		m.endpoint = endpoint
+		if m.since.IsZero() {
+			m.since = time.Now()
+			m.timer = time.AfterFunc(m.threshold(), m.alert)
+		}
+		return
+	}
+	if m.since.IsZero() {
+		return
+	}
+	m.timer.Stop()
+	if m.alerted && m.OnAlert != nil {
+		now := time.Now()
+		go m.OnAlert(Alert{Time: now, Resolved: true, Since: m.since, Duration: now.Sub(m.since), Endpoint: endpoint})
+	}
+	m.since, m.endpoint, m.timer, m.alerted = time.Time{}, "", nil, false
+}
+
+func (m *Monitor) alert() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.since.IsZero() || m.alerted {
+		return
+	}
+	m.alerted = true
+	if m.OnAlert != nil {
+		now := time.Now()
+		go m.OnAlert(Alert{Time: now, Since: m.since, Duration: now.Sub(m.since), Endpoint: m.endpoint})
+	}
+}
+
+// Downgraded returns the time since which queries are answered over plain DNS
+// if it lasted longer than the threshold, or a zero time otherwise.
+func (m *Monitor) Downgraded() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.alerted {
+		return time.Time{}
+	}
+	return m.since
+}
+
+func (m *Monitor) threshold() time.Duration {
+	if m.Threshold > 0 {
+		return m.Threshold
+	}
+	return DefaultThreshold
+}
@@ -0,0 +1,43 @@
+package downgrade
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	alerts := make(chan Alert, 10)
+	m := &Monitor{
+		Threshold: 20 * time.Millisecond,
+		OnAlert: func(a Alert) {
+			alerts <- a
+		},
+	}
+
+	// Short fallback, not reported.
+	m.SetEndpoint("1.1.1.1:53", true)
+	m.SetEndpoint("https://dns.nextdns.io", false)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case a := <-alerts:
+		t.Fatalf("unexpected alert: %v", a)
+	default:
+	}
+
+	m.SetEndpoint("1.1.1.1:53", true)
+	m.SetEndpoint("45.90.28.0:53", true)
+	a := <-alerts
+	if a.Resolved || a.Endpoint != "45.90.28.0:53" || a.Duration < m.Threshold {
+		t.Errorf("unexpected alert: %+v", a)
+	}
+	if m.Downgraded().IsZero() {
+		t.Error("Downgraded() is zero while downgraded")
+	}
+	m.SetEndpoint("https://dns.nextdns.io", false)
+	if a = <-alerts; !a.Resolved || a.Endpoint != "https://dns.nextdns.io" {
+		t.Errorf("unexpected alert: %+v", a)
+	}
+	if !m.Downgraded().IsZero() {
+		t.Error("Downgraded() is not zero after resolution")
+	}
+}
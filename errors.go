@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/nextdns/nextdns/host/service"
+	"github.com/nextdns/nextdns/i18n"
+	"github.com/nextdns/nextdns/upgrade"
+)
+
+// exitCode is the status the process exits with when a command fails. The
+// values are part of the CLI interface and must never be renumbered.
+type exitCode int
+
+const (
+	exitOK           exitCode = 0
+	exitError        exitCode = 1  // unclassified failure
+	exitUsage        exitCode = 2  // invalid command or arguments
+	exitPermission   exitCode = 3  // insufficient privileges
+	exitNotInstalled exitCode = 4  // service not installed
+	exitNotRunning   exitCode = 5  // daemon not running
+	exitNetwork      exitCode = 6  // network or remote API failure
+	exitConfig       exitCode = 7  // configuration cannot be read or written
+	exitUnsupported  exitCode = 8  // platform or init system not supported
+	exitVerification exitCode = 9  // release signature verification failed
+	exitSystem       exitCode = 10 // system change (resolver, firewall…) failed
+)
+
+var exitCodeKinds = map[exitCode]string{
+	exitOK:           "ok",
+	exitError:        "error",
+	exitUsage:        "usage",
+	exitPermission:   "permission",
+	exitNotInstalled: "not_installed",
+	exitNotRunning:   "not_running",
+	exitNetwork:      "network",
+	exitConfig:       "config",
+	exitUnsupported:  "unsupported",
+	exitVerification: "verification",
+	exitSystem:       "system",
+}
+
+func (c exitCode) String() string {
+	if k, found := exitCodeKinds[c]; found {
+		return k
+	}
+	return "error"
+}
+
+// cliError attaches an explicit exit code to an error.
+type cliError struct {
+	code exitCode
+	err  error
+}
+
+func (e *cliError) Error() string {
+	return e.err.Error()
+}
+
+func (e *cliError) Unwrap() error {
+	return e.err
+}
+
+// withCode returns err with the exit code c unless err is nil or already
+// carries a more specific code.
+func withCode(c exitCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	if code := exitCodeOf(err); code != exitError {
+		return err
+	}
+	return &cliError{code: c, err: err}
+}
+
+// exitCodeOf returns the exit code matching the cause of err.
+func exitCodeOf(err error) exitCode {
+	var ce *cliError
+	var ne net.Error
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &ce):
+		return ce.code
+	case errors.Is(err, os.ErrPermission):
+		return exitPermission
+	case errors.Is(err, service.ErrNoInstalled):
+		return exitNotInstalled
+	case errors.Is(err, service.ErrNotSuported):
+		return exitUnsupported
+	case errors.Is(err, upgrade.ErrInvalidSignature):
+		return exitVerification
+	case errors.As(err, &ne):
+		return exitNetwork
+	}
+	return exitError
+}
+
+// jsonOutput is set when the -json flag is passed to a command supporting it.
+var jsonOutput bool
+
+// jsonCommands lists the commands accepting the global -json flag.
+var jsonCommands = map[string]bool{
+	"install":    true,
+	"uninstall":  true,
+	"start":      true,
+	"stop":       true,
+	"restart":    true,
+	"status":     true,
+	"activate":   true,
+	"deactivate": true,
+	"upgrade":    true,
+	"config":     true,
+	"diag":       true,
+	"compare":    true,
+	"bench":      true,
+	"top":        true,
+	"tune":       true,
+	"pause":      true,
+	"resume":     true,
+	"cache-only": true,
+	"trace":      true,
+	"log":        true,
+}
+
+// stripFlag removes the boolean flag name from args and reports if it was
+// present.
+func stripFlag(args []string, name string) ([]string, bool) {
+	found := false
+	out := args[:0:0]
+	for _, arg := range args {
+		switch arg {
+		case "-" + name, "--" + name, "-" + name + "=true", "--" + name + "=true":
+			found = true
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out, found
+}
+
+// jsonError is the JSON representation of a command failure.
+type jsonError struct {
+	Code    int    `json:"code"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// printError writes err to w, as a JSON object if jsonOutput is set.
+func printError(w io.Writer, code exitCode, err error) {
+	if !jsonOutput {
+		fmt.Fprint(w, i18n.Sprintf("Error: %v\n", err))
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]jsonError{
+		"error": {Code: int(code), Kind: code.String(), Message: err.Error()},
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/nextdns/nextdns/host/service"
+	"github.com/nextdns/nextdns/upgrade"
+)
+
+func Test_exitCodeOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want exitCode
+	}{
+		{"nil", nil, exitOK},
+		{"plain", errors.New("failed"), exitError},
+		{"permission", &os.PathError{Op: "open", Path: "/etc/nextdns.conf", Err: os.ErrPermission}, exitPermission},
+		{"not installed", fmt.Errorf("stop: %w", service.ErrNoInstalled), exitNotInstalled},
+		{"unsupported", service.ErrNotSuported, exitUnsupported},
+		{"signature", fmt.Errorf("upgrade: %w", upgrade.ErrInvalidSignature), exitVerification},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, exitNetwork},
+		{"explicit", withCode(exitConfig, errors.New("cannot write config")), exitConfig},
+		{"wrapped explicit", fmt.Errorf("install: %w", withCode(exitSystem, errors.New("firewall"))), exitSystem},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeOf(tt.err); got != tt.want {
+				t.Errorf("exitCodeOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withCode(t *testing.T) {
+	if err := withCode(exitSystem, nil); err != nil {
+		t.Errorf("withCode(nil) = %v, want nil", err)
+	}
+	// A more specific code is kept.
+	err := withCode(exitSystem, fmt.Errorf("start: %w", service.ErrNoInstalled))
+	if code := exitCodeOf(err); code != exitNotInstalled {
+		t.Errorf("exitCodeOf() = %v, want %v", code, exitNotInstalled)
+	}
+	if !errors.Is(err, service.ErrNoInstalled) {
+		t.Error("withCode does not wrap the error")
+	}
+	err = withCode(exitConfig, errors.New("bad config"))
+	if code := exitCodeOf(err); code != exitConfig || err.Error() != "bad config" {
+		t.Errorf("withCode() = %v (%v), want bad config (%v)", err, code, exitConfig)
+	}
+}
+
+func Test_exitCode_String(t *testing.T) {
+	// Codes are part of the CLI interface.
+	for code, want := range map[exitCode]string{
+		0: "ok", 1: "error", 2: "usage", 3: "permission", 4: "not_installed",
+		5: "not_running", 6: "network", 7: "config", 8: "unsupported",
+		9: "verification", 10: "system", 42: "error",
+	} {
+		if got := code.String(); got != want {
+			t.Errorf("exitCode(%d).String() = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func Test_stripFlag(t *testing.T) {
+	args, found := stripFlag([]string{"status", "-json", "-config", "abcdef", "--json=true"}, "json")
+	if !found || !reflect.DeepEqual(args, []string{"status", "-config", "abcdef"}) {
+		t.Errorf("stripFlag() = %q, %v", args, found)
+	}
+	args, found = stripFlag([]string{"status", "-json=false"}, "json")
+	if found || !reflect.DeepEqual(args, []string{"status", "-json=false"}) {
+		t.Errorf("stripFlag() = %q, %v", args, found)
+	}
+}
+
+func Test_printError(t *testing.T) {
+	defer func(v bool) { jsonOutput = v }(jsonOutput)
+	var buf bytes.Buffer
+	jsonOutput = true
+	printError(&buf, exitNotInstalled, errors.New("service not installed"))
+	if want := `{"error":{"code":4,"kind":"not_installed","message":"service not installed"}}` + "\n"; buf.String() != want {
+		t.Errorf("printError() = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,296 @@
+// Package events implements a machine readable stream of daemon events.
+//
+// Events are encoded as newline delimited JSON (NDJSON) objects with a stable
+// schema so they can be consumed by router web UIs and scripts without parsing
+// human readable logs:
+//
+//   {"v":1,"time":"2020-04-01T12:00:00Z","type":"upstream.switched","data":{"endpoint":"https://dns1.nextdns.io#45.90.28.0"}}
+//
+// The v field is incremented on backward incompatible changes only.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Version is the version of the event schema.
+const Version = 1
+
+// Event types.
+const (
+	ServiceStarting   = "service.starting"
+	ServiceStarted    = "service.started"
+	ServiceRestarting = "service.restarting"
+	ServiceStopping   = "service.stopping"
+	ServiceStopped    = "service.stopped"
+	ServiceUpgraded   = "service.upgraded"
+
+	UpstreamConnected = "upstream.connected"
+	UpstreamSwitched  = "upstream.switched"
+	UpstreamFailed    = "upstream.failed"
+	UpstreamOffline   = "upstream.offline"
+	UpstreamOnline    = "upstream.online"
+	UpstreamPath      = "upstream.path_changed"
+
+	CacheOnlyEnabled  = "cache_only.enabled"
+	CacheOnlyDisabled = "cache_only.disabled"
+
+	DowngradeDetected = "downgrade.detected"
+	DowngradeResolved = "downgrade.resolved"
+
+	HijackDetected = "hijack.detected"
+	HijackResolved = "hijack.resolved"
+
+	ActivationActivated   = "activation.activated"
+	ActivationDeactivated = "activation.deactivated"
+
+	RouterSetup    = "router.setup"
+	RouterRestored = "router.restored"
+
+	NetworkChanged = "network.changed"
+
+	SLOBreached = "slo.breached"
+	SLORestored = "slo.restored"
+
+	AnomalyDetected = "anomaly.detected"
+
+	TunnelDetected = "tunnel.detected"
+
+	AnswerChanged = "answer.changed"
+
+	PrivateRelayDetected = "private_relay.detected"
+
+	PortalPending = "portal.pending"
+
+	BlockPageAllowed = "block_page.allowed"
+
+	CaptivePortalDetected = "captive_portal.detected"
+	CaptivePortalCleared  = "captive_portal.cleared"
+
+	ConfigUpdated = "config.updated"
+
+	ClientBanned = "client.banned"
+
+	Error = "error"
+)
+
+// Data holds the event type specific fields.
+type Data map[string]interface{}
+
+// Event is a single event of the stream.
+type Event struct {
+	Version int       `json:"v"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Data    Data      `json:"data,omitempty"`
+}
+
+// errorInterval is the minimum interval between two error events. Errors
+// happening in between are aggregated into a single event, emitted at the end
+// of the interval with the last error and the number of errors it stands for
+// as count, so a failure affecting every query does not flood subscribers.
+var errorInterval = 10 * time.Second
+
+// subscriberBuffer is the number of events buffered for each socket
+// subscriber. Events are dropped for subscribers not reading fast enough.
+const subscriberBuffer = 100
+
+// Stream emits events to a file and/or a unix socket. A nil *Stream is valid
+// and discards all events.
+type Stream struct {
+	// File specifies an optional path to a file events are appended to.
+	File string
+
+	// Socket specifies an optional unix socket path. Each connected client
+	// receives the events emitted after its connection.
+	Socket string
+
+	mu   sync.Mutex
+	f    *os.File
+	l    net.Listener
+	subs map[chan []byte]struct{}
+
+	errMu    sync.Mutex
+	errTime  time.Time
+	errData  Data
+	errCount int
+	errTimer *time.Timer
+}
+
+// Start opens the file and the socket.
+func (s *Stream) Start() error {
+	if s == nil {
+		return nil
+	}
+	if s.File != "" {
+		f, err := os.OpenFile(s.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("events: %v", err)
+		}
+		s.f = f
+	}
+	if s.Socket != "" {
+		_ = os.Remove(s.Socket)
+		l, err := net.Listen("unix", s.Socket)
+		if err != nil {
+			return fmt.Errorf("events: %v", err)
+		}
+		// Events can expose the queried domains through errors, restrict
+		// them to the daemon user like the control socket.
+		if err := os.Chmod(s.Socket, 0600); err != nil {
+			l.Close()
+			return fmt.Errorf("events: %v", err)
+		}
+		s.l = l
+		go s.accept()
+	}
+	return nil
+}
+
+func (s *Stream) accept() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(c)
+	}
+}
+
+func (s *Stream) serve(c net.Conn) {
+	defer c.Close()
+	ch := make(chan []byte, subscriberBuffer)
+	s.mu.Lock()
+	if s.subs == nil {
+		s.subs = map[chan []byte]struct{}{}
+	}
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subs, ch)
+		s.mu.Unlock()
+	}()
+	// Detect client disconnection.
+	closed := make(chan struct{})
+	go func() {
+		_, _ = c.Read(make([]byte, 1))
+		close(closed)
+	}()
+	for {
+		select {
+		case b := <-ch:
+			if _, err := c.Write(b); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// Close closes the file and the socket.
+func (s *Stream) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.errMu.Lock()
+	if s.errTimer != nil {
+		s.errTimer.Stop()
+		s.errTimer = nil
+	}
+	s.errMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.l != nil {
+		err = s.l.Close()
+		s.l = nil
+	}
+	if s.f != nil {
+		if e := s.f.Close(); e != nil {
+			err = e
+		}
+		s.f = nil
+	}
+	return err
+}
+
+// Emit sends an event of type typ with data to all the outputs. Error events
+// are rate limited to one per errorInterval.
+func (s *Stream) Emit(typ string, data Data) {
+	if s == nil {
+		return
+	}
+	if typ == Error && !s.allowError(data) {
+		return
+	}
+	s.emit(typ, data)
+}
+
+// allowError returns true if an error event with data can be emitted now.
+// Otherwise the error is aggregated and emitted by flushErrors at the end of
+// the current interval.
+func (s *Stream) allowError(data Data) bool {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	now := time.Now()
+	if s.errTimer == nil && now.Sub(s.errTime) >= errorInterval {
+		s.errTime = now
+		return true
+	}
+	s.errData = data
+	s.errCount++
+	if s.errTimer == nil {
+		s.errTimer = time.AfterFunc(errorInterval-now.Sub(s.errTime), s.flushErrors)
+	}
+	return false
+}
+
+func (s *Stream) flushErrors() {
+	s.errMu.Lock()
+	if s.errTimer == nil {
+		// Stream closed.
+		s.errMu.Unlock()
+		return
+	}
+	data := Data{}
+	for k, v := range s.errData {
+		data[k] = v
+	}
+	data["count"] = s.errCount
+	s.errTime = time.Now()
+	s.errData, s.errCount, s.errTimer = nil, 0, nil
+	s.errMu.Unlock()
+	s.emit(Error, data)
+}
+
+func (s *Stream) emit(typ string, data Data) {
+	b, err := json.Marshal(Event{
+		Version: Version,
+		Time:    time.Now().UTC(),
+		Type:    typ,
+		Data:    data,
+	})
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f != nil {
+		_, _ = s.f.Write(b)
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- b:
+		default:
+			// Slow subscriber, drop the event.
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &Stream{
+		File:   filepath.Join(dir, "events.ndjson"),
+		Socket: filepath.Join(dir, "events.sock"),
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if fi, err := os.Stat(s.Socket); err != nil {
+		t.Fatal(err)
+	} else if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("socket mode = %v, want 0600", mode)
+	}
+
+	c, err := net.Dial("unix", s.Socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// Wait for the subscriber to be registered.
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		n := len(s.subs)
+		s.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Emit(UpstreamSwitched, Data{"endpoint": "https://dns.nextdns.io"})
+
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(c).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e Event
+	if err := json.Unmarshal(line, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Version != Version || e.Type != UpstreamSwitched || e.Data["endpoint"] != "https://dns.nextdns.io" {
+		t.Errorf("unexpected event: %s", line)
+	}
+
+	b, err := ioutil.ReadFile(s.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(line) {
+		t.Errorf("file content = %q, want %q", b, line)
+	}
+}
+
+func TestStream_ErrorRateLimit(t *testing.T) {
+	defer func(d time.Duration) { errorInterval = d }(errorInterval)
+	errorInterval = 100 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &Stream{File: filepath.Join(dir, "events.ndjson")}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 50; i++ {
+		s.Emit(Error, Data{"error": fmt.Sprintf("error %d", i)})
+	}
+	s.Emit(UpstreamSwitched, nil)
+	time.Sleep(3 * errorInterval)
+
+	b, err := ioutil.ReadFile(s.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Event
+	for _, line := range bytes.Split(bytes.TrimSpace(b), []byte("\n")) {
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d events, want 3: %s", len(got), b)
+	}
+	if got[0].Type != Error || got[0].Data["error"] != "error 0" || got[0].Data["count"] != nil {
+		t.Errorf("first event = %+v, want error 0", got[0])
+	}
+	if got[1].Type != UpstreamSwitched {
+		t.Errorf("second event = %+v, want %s", got[1], UpstreamSwitched)
+	}
+	if got[2].Type != Error || got[2].Data["error"] != "error 49" || got[2].Data["count"] != float64(49) {
+		t.Errorf("aggregated event = %+v, want error 49 with count 49", got[2])
+	}
+}
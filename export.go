@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/history"
+)
+
+// export writes the locally retained query history between -from and -to in
+// the requested format.
+func export(args []string) error {
+	fs := flag.NewFlagSet("nextdns export", flag.ExitOnError)
+	from := fs.String("from", "", "Start of the exported range, as a date (2006-01-02), a RFC3339 time or a duration\n"+
+		"before now (i.e. 24h). The history is exported from its start if empty.")
+	to := fs.String("to", "", "End of the exported range (excluded), in the same formats as from. The history is\n"+
+		"exported up to now if empty.")
+	format := fs.String("format", history.FormatCSV, "Export format: csv, jsonl or parquet.")
+	output := fs.String("o", "", "File to write the export to. Written to stdout if empty.")
+	dir := fs.String("dir", "", "Directory of the query history. Defaults to the query-history setting.")
+	_ = fs.Parse(args[1:])
+
+	if err := historyDir("export", dir); err != nil {
+		return err
+	}
+	now := time.Now()
+	start, err := parseExportTime(*from, now)
+	if err != nil {
+		return fmt.Errorf("from: %v", err)
+	}
+	end, err := parseExportTime(*to, now)
+	if err != nil {
+		return fmt.Errorf("to: %v", err)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	w, err := history.NewWriter(out, *format)
+	if err != nil {
+		return err
+	}
+	if err := history.Read(*dir, start, end, w.Write); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if *output != "" {
+		return out.Close()
+	}
+	return nil
+}
+
+// historyDir sets dir to the query-history setting if empty.
+func historyDir(cmd string, dir *string) error {
+	if *dir == "" {
+		var c config.Config
+		c.Parse("nextdns "+cmd, nil, true)
+		*dir = c.QueryHistory
+	}
+	if *dir == "" {
+		return errors.New("no query history: set query-history to retain queries locally")
+	}
+	return nil
+}
+
+// parseExportTime parses s as a date, a RFC3339 time or a duration before now.
+func parseExportTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: invalid time", s)
+	}
+	return t, nil
+}
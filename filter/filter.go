@@ -0,0 +1,316 @@
+// Package filter implements local domain filtering based on block and allow
+// lists.
+package filter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// Filter matches queries against block and allow lists. Lists can be local
+// files or HTTP(S) URLs in hosts or domain list format.
+type Filter struct {
+	// Blocklists specifies the sources of domains to block.
+	Blocklists []string
+
+	// Allowlists specifies the sources of domains to never block, even if
+	// listed in a blocklist.
+	Allowlists []string
+
+	// BlockRules specifies additional rules to block, in the same format as
+	// list entries.
+	BlockRules []string
+
+	// Sync specifies an optional file path or HTTP(S) URL of the rules of
+	// another instance, as written by WriteRules (i.e. served by a
+	// SyncServer). They are merged with the local lists so a roaming device
+	// applies the same rules as its home router.
+	Sync string
+
+	// SyncToken specifies the bearer token sent when fetching Sync.
+	SyncToken string
+
+	// RefreshInterval specifies how often lists are reloaded. If zero, lists
+	// are only loaded once.
+	RefreshInterval time.Duration
+
+	// Response specifies how blocked queries are answered.
+	Response Response
+
+	// Client is the HTTP client used to fetch remote lists. If nil, a client
+	// with a 30s timeout is used.
+	Client *http.Client
+
+	// InfoLog specifies an option log function called when lists are loaded.
+	InfoLog func(string)
+
+	// ErrorLog specifies an optional log function for errors.
+	ErrorLog func(error)
+
+	mu        sync.RWMutex
+	block     *rules
+	allow     *rules
+	sources   map[string]*rules
+	syncBlock *rules
+	syncAllow *rules
+	temporary map[string]time.Time
+}
+
+// Response defines how blocked queries are answered.
+type Response struct {
+	// IPv4 and IPv6 specify the addresses returned for blocked A and AAAA
+	// queries. If both are nil, blocked queries are answered with NXDOMAIN.
+	IPv4 net.IP
+	IPv6 net.IP
+}
+
+// ParseResponse parses a block response definition: "nxdomain", "null" (for
+// 0.0.0.0 and ::) or a comma separated list of one IPv4 and/or one IPv6.
+func ParseResponse(s string) (Response, error) {
+	switch s {
+	case "", "nxdomain":
+		return Response{}, nil
+	case "null":
+		return Response{IPv4: net.IPv4zero, IPv6: net.IPv6zero}, nil
+	}
+	var r Response
+	for _, v := range strings.Split(s, ",") {
+		ip := net.ParseIP(strings.TrimSpace(v))
+		switch {
+		case ip == nil:
+			return Response{}, fmt.Errorf("%s: invalid block response", v)
+		case ip.To4() != nil:
+			r.IPv4 = ip.To4()
+		default:
+			r.IPv6 = ip
+		}
+	}
+	return r, nil
+}
+
+// Start loads the lists and refreshes them every RefreshInterval until ctx is
+// cancelled.
+func (f *Filter) Start(ctx context.Context) {
+	f.Reload(ctx)
+	if f.RefreshInterval <= 0 {
+		return
+	}
+	t := time.NewTicker(f.RefreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			f.Reload(ctx)
+		}
+	}
+}
+
+// Reload reloads all the lists. If a list can't be loaded, its last
+// successfully loaded content is used.
+func (f *Filter) Reload(ctx context.Context) {
+	block := f.load(ctx, f.Blocklists)
+	for _, rule := range f.BlockRules {
+		block.add(rule)
+	}
+	allow := f.load(ctx, f.Allowlists)
+	if f.Sync != "" {
+		f.loadSync(ctx, block, allow)
+	}
+	f.mu.Lock()
+	f.block, f.allow = block, allow
+	f.mu.Unlock()
+	f.logInfof("Filter loaded: %d block rules, %d allow rules", block.len(), allow.len())
+}
+
+func (f *Filter) load(ctx context.Context, sources []string) *rules {
+	r := newRules()
+	for _, src := range sources {
+		sr := newRules()
+		if err := f.loadSource(ctx, sr, src); err != nil {
+			f.logErr(fmt.Errorf("filter: %s: %v", src, err))
+			f.mu.RLock()
+			prev := f.sources[src]
+			f.mu.RUnlock()
+			if prev == nil {
+				continue
+			}
+			sr = prev
+		}
+		f.mu.Lock()
+		if f.sources == nil {
+			f.sources = map[string]*rules{}
+		}
+		f.sources[src] = sr
+		f.mu.Unlock()
+		r.merge(sr)
+	}
+	return r
+}
+
+func (f *Filter) loadSource(ctx context.Context, r *rules, src string) error {
+	rc, err := f.open(ctx, src, "")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return r.parse(io.LimitReader(rc, 100<<20))
+}
+
+// open opens the file or HTTP(S) URL src. If token is not empty, it is sent
+// as bearer token.
+func (f *Filter) open(ctx context.Context, src, token string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.Open(src)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	c := f.Client
+	if c == nil {
+		c = &http.Client{Timeout: 30 * time.Second}
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("status code: %d", res.StatusCode)
+	}
+	return res.Body, nil
+}
+
+// Match returns true if domain is blocked.
+func (f *Filter) Match(domain string) bool {
+	_, blocked := f.MatchRule(domain)
+	return blocked
+}
+
+// MatchRule returns the rule blocking domain, if any.
+func (f *Filter) MatchRule(domain string) (rule string, blocked bool) {
+	domain = fqdn(strings.ToLower(domain))
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.block == nil {
+		return "", false
+	}
+	if rule, blocked = f.block.matchRule(domain); !blocked {
+		return "", false
+	}
+	if f.allow != nil && f.allow.match(domain) {
+		return "", false
+	}
+	if len(f.temporary) > 0 && f.allowedTemporarily(domain, time.Now()) {
+		return "", false
+	}
+	return rule, true
+}
+
+// AllowFor allows domain and its sub-domains for d, even if listed in a
+// blocklist. It returns the time the domain will be blocked again.
+func (f *Filter) AllowFor(domain string, d time.Duration) time.Time {
+	domain = fqdn(strings.ToLower(domain))
+	now := time.Now()
+	until := now.Add(d)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.temporary == nil {
+		f.temporary = map[string]time.Time{}
+	}
+	for k, t := range f.temporary {
+		if !now.Before(t) {
+			delete(f.temporary, k)
+		}
+	}
+	f.temporary[domain] = until
+	return until
+}
+
+// allowedTemporarily returns true if domain or one of its parents is allowed
+// by AllowFor at now. Must be called with f.mu held.
+func (f *Filter) allowedTemporarily(domain string, now time.Time) bool {
+	for {
+		if t, found := f.temporary[domain]; found && now.Before(t) {
+			return true
+		}
+		idx := strings.IndexByte(domain, '.')
+		if idx == -1 || idx == len(domain)-1 {
+			return false
+		}
+		domain = domain[idx+1:]
+	}
+}
+
+// Reply writes the response for the blocked query q into buf.
+func (f *Filter) Reply(q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return 0, i, err
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return 0, i, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.Authoritative = false
+	if f.Response.IPv4 == nil && f.Response.IPv6 == nil {
+		h.RCode = dnsmessage.RCodeNameError
+	} else {
+		h.RCode = dnsmessage.RCodeSuccess
+	}
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	_ = b.StartAnswers()
+	hdr := dnsmessage.ResourceHeader{
+		Name:  q1.Name,
+		Type:  q1.Type,
+		Class: q1.Class,
+		TTL:   60,
+	}
+	switch {
+	case q1.Type == dnsmessage.TypeA && f.Response.IPv4 != nil:
+		var a [4]byte
+		copy(a[:], f.Response.IPv4.To4())
+		err = b.AResource(hdr, dnsmessage.AResource{A: a})
+	case q1.Type == dnsmessage.TypeAAAA && f.Response.IPv6 != nil:
+		var aaaa [16]byte
+		copy(aaaa[:], f.Response.IPv6.To16())
+		err = b.AAAAResource(hdr, dnsmessage.AAAAResource{AAAA: aaaa})
+	}
+	if err != nil {
+		return 0, i, err
+	}
+	buf, err = b.Finish()
+	return len(buf), i, err
+}
+
+func (f *Filter) logInfof(format string, a ...interface{}) {
+	if f.InfoLog != nil {
+		f.InfoLog(fmt.Sprintf(format, a...))
+	}
+}
+
+func (f *Filter) logErr(err error) {
+	if err != nil && f.ErrorLog != nil {
+		f.ErrorLog(err)
+	}
+}
@@ -0,0 +1,139 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path"
+	"strings"
+)
+
+// rules is a set of domain matching rules.
+type rules struct {
+	// exact holds the domains to match exactly.
+	exact map[string]struct{}
+	// suffixes holds the domains for which any sub-domain matches.
+	suffixes map[string]struct{}
+	// patterns holds glob patterns for other kinds of wildcards.
+	patterns []string
+}
+
+func newRules() *rules {
+	return &rules{
+		exact:    map[string]struct{}{},
+		suffixes: map[string]struct{}{},
+	}
+}
+
+func (r *rules) len() int {
+	return len(r.exact) + len(r.suffixes) + len(r.patterns)
+}
+
+// merge adds all the rules of o to r.
+func (r *rules) merge(o *rules) {
+	for d := range o.exact {
+		r.exact[d] = struct{}{}
+	}
+	for d := range o.suffixes {
+		r.suffixes[d] = struct{}{}
+	}
+	r.patterns = append(r.patterns, o.patterns...)
+}
+
+// add adds a rule. Supported forms are:
+//
+//   example.com       matches example.com only
+//   *.example.com     matches any sub-domain of example.com
+//   ||example.com^    matches example.com and its sub-domains (adblock style)
+//   ads*.example.com  matches using glob patterns
+func (r *rules) add(rule string) {
+	rule = strings.ToLower(strings.TrimSpace(rule))
+	if strings.HasPrefix(rule, "||") && strings.HasSuffix(rule, "^") {
+		domain := fqdn(rule[2 : len(rule)-1])
+		r.exact[domain] = struct{}{}
+		r.suffixes[domain] = struct{}{}
+		return
+	}
+	rule = fqdn(rule)
+	if rule == "." || strings.ContainsAny(rule, "/ ") {
+		return
+	}
+	if strings.HasPrefix(rule, "*.") && strings.IndexByte(rule[2:], '*') == -1 {
+		r.suffixes[rule[2:]] = struct{}{}
+		return
+	}
+	if strings.ContainsAny(rule, "*?[") {
+		r.patterns = append(r.patterns, rule)
+		return
+	}
+	r.exact[rule] = struct{}{}
+}
+
+// parse reads rules from rd in either hosts or domain list format. Comments
+// starting with # or ! are ignored.
+func (r *rules) parse(rd io.Reader) error {
+	s := bufio.NewScanner(rd)
+	for s.Scan() {
+		line := s.Text()
+		if idx := strings.IndexByte(line, '#'); idx != -1 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '!' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if net.ParseIP(fields[0]) != nil {
+			// Hosts format: IP followed by one or more host names.
+			for _, host := range fields[1:] {
+				switch host {
+				case "localhost", "localhost.localdomain", "local", "broadcasthost",
+					"ip6-localhost", "ip6-loopback", "0.0.0.0":
+					continue
+				}
+				r.add(host)
+			}
+			continue
+		}
+		r.add(fields[0])
+	}
+	return s.Err()
+}
+
+// match returns true if domain matches one of the rules. The domain must be
+// lower case and fully qualified.
+func (r *rules) match(domain string) bool {
+	_, found := r.matchRule(domain)
+	return found
+}
+
+// matchRule returns the rule matching domain, as exact domain, *.suffix or
+// glob pattern.
+func (r *rules) matchRule(domain string) (string, bool) {
+	if _, found := r.exact[domain]; found {
+		return domain, true
+	}
+	for d := domain; ; {
+		idx := strings.IndexByte(d, '.')
+		if idx == -1 || idx == len(d)-1 {
+			break
+		}
+		d = d[idx+1:]
+		if _, found := r.suffixes[d]; found {
+			return "*." + d, true
+		}
+	}
+	for _, p := range r.patterns {
+		if ok, _ := path.Match(p, domain); ok {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func fqdn(s string) string {
+	if !strings.HasSuffix(s, ".") {
+		s += "."
+	}
+	return s
+}
@@ -0,0 +1,74 @@
+package filter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_rules_match(t *testing.T) {
+	r := newRules()
+	err := r.parse(strings.NewReader(`# Comment
+0.0.0.0 ads.example.com tracker.example.com # inline comment
+127.0.0.1 localhost
+! adblock comment
+||adblock.example.net^
+*.wildcard.example.org
+exact.example.org
+ads*.example.io
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"ads.example.com.", true},
+		{"tracker.example.com.", true},
+		{"sub.ads.example.com.", false},
+		{"example.com.", false},
+		{"localhost.", false},
+		{"adblock.example.net.", true},
+		{"sub.adblock.example.net.", true},
+		{"wildcard.example.org.", false},
+		{"a.wildcard.example.org.", true},
+		{"a.b.wildcard.example.org.", true},
+		{"exact.example.org.", true},
+		{"sub.exact.example.org.", false},
+		{"ads1.example.io.", true},
+		{"example.io.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			if got := r.match(tt.domain); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilter_AllowFor(t *testing.T) {
+	f := &Filter{BlockRules: []string{"||ads.example.com^"}}
+	f.Reload(context.Background())
+	f.AllowFor("ads.example.com", time.Hour)
+	f.AllowFor("www.ads.example.com", -time.Second)
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"ads.example.com.", false},
+		{"sub.ads.example.com.", false},
+		{"tracker.example.com.", false},
+	}
+	for _, tt := range tests {
+		if got := f.Match(tt.domain); got != tt.want {
+			t.Errorf("Match(%s) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+	f.AllowFor("ads.example.com", -time.Second)
+	if !f.Match("ads.example.com.") {
+		t.Error("ads.example.com. still allowed after expiration")
+	}
+}
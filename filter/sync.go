@@ -0,0 +1,147 @@
+package filter
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// loadSync loads the rules from Sync and merges them into block and allow. If
+// Sync can't be loaded, the rules of the last successful sync are used.
+func (f *Filter) loadSync(ctx context.Context, block, allow *rules) {
+	sb, sa, err := f.fetchSync(ctx)
+	f.mu.Lock()
+	if err != nil {
+		sb, sa = f.syncBlock, f.syncAllow
+	} else {
+		f.syncBlock, f.syncAllow = sb, sa
+	}
+	f.mu.Unlock()
+	if err != nil {
+		f.logErr(fmt.Errorf("filter: sync: %s: %v", f.Sync, err))
+	}
+	if sb != nil {
+		block.merge(sb)
+		allow.merge(sa)
+	}
+}
+
+func (f *Filter) fetchSync(ctx context.Context) (block, allow *rules, err error) {
+	rc, err := f.open(ctx, f.Sync, f.SyncToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+	return readRules(io.LimitReader(rc, 100<<20))
+}
+
+// readRules reads the rules written by WriteRules.
+func readRules(r io.Reader) (block, allow *rules, err error) {
+	block, allow = newRules(), newRules()
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "block":
+			block.add(fields[1])
+		case "allow":
+			allow.add(fields[1])
+		}
+	}
+	return block, allow, s.Err()
+}
+
+// WriteRules writes the current block and allow rules to w, one "block RULE"
+// or "allow RULE" line per rule.
+func (f *Filter) WriteRules(w io.Writer) error {
+	f.mu.RLock()
+	block, allow := f.block, f.allow
+	f.mu.RUnlock()
+	bw := bufio.NewWriter(w)
+	for _, r := range []struct {
+		action string
+		rules  *rules
+	}{{"block", block}, {"allow", allow}} {
+		if r.rules == nil {
+			continue
+		}
+		for _, rule := range r.rules.list() {
+			fmt.Fprintf(bw, "%s %s\n", r.action, rule)
+		}
+	}
+	return bw.Flush()
+}
+
+// list returns the rules of r in the format accepted by add, sorted.
+func (r *rules) list() []string {
+	l := make([]string, 0, r.len())
+	for d := range r.exact {
+		l = append(l, strings.TrimSuffix(d, "."))
+	}
+	for d := range r.suffixes {
+		l = append(l, "*."+strings.TrimSuffix(d, "."))
+	}
+	for _, p := range r.patterns {
+		l = append(l, strings.TrimSuffix(p, "."))
+	}
+	sort.Strings(l)
+	return l
+}
+
+// SyncServer serves the rules of a Filter over HTTP for other instances to
+// sync from.
+type SyncServer struct {
+	// Filter is the filter whose rules are served.
+	Filter *Filter
+
+	// Addr specifies the TCP address to listen to.
+	Addr string
+
+	// Token specifies the bearer token clients must send. If empty, no
+	// authentication is required.
+	Token string
+}
+
+// ServeHTTP implements http.Handler.
+func (s *SyncServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Token != "" {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+s.Token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_ = s.Filter.WriteRules(w)
+}
+
+// ListenAndServe serves the rules on Addr until ctx is cancelled.
+func (s *SyncServer) ListenAndServe(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:      s,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	if err = srv.Serve(l); err == http.ErrServerClosed {
+		err = ctx.Err()
+	}
+	return err
+}
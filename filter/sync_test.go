@@ -0,0 +1,62 @@
+package filter
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFilter_Sync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	allowlist := dir + "/allow"
+	if err := ioutil.WriteFile(allowlist, []byte("good.ads.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	home := &Filter{
+		BlockRules: []string{"tracker.example.com", "*.ads.example.com", "||adblock.example.net^", "ads*.example.io"},
+		Allowlists: []string{allowlist},
+	}
+	home.Reload(context.Background())
+	srv := httptest.NewServer(&SyncServer{Filter: home, Token: "secret"})
+	defer srv.Close()
+
+	laptop := &Filter{
+		BlockRules: []string{"local.example.com"},
+		Sync:       srv.URL,
+		SyncToken:  "wrong",
+	}
+	laptop.Reload(context.Background())
+	if laptop.Match("tracker.example.com") {
+		t.Fatal("rules synced with an invalid token")
+	}
+
+	laptop.SyncToken = "secret"
+	laptop.Reload(context.Background())
+	for domain, want := range map[string]bool{
+		"local.example.com":     true,
+		"tracker.example.com":   true,
+		"x.ads.example.com":     true,
+		"good.ads.example.com":  false,
+		"adblock.example.net":   true,
+		"a.adblock.example.net": true,
+		"ads1.example.io":       true,
+		"example.com":           false,
+	} {
+		if got := laptop.Match(domain); got != want {
+			t.Errorf("Match(%s) = %v, want %v", domain, got, want)
+		}
+	}
+
+	// Rules of the last successful sync are kept when the source is down.
+	srv.Close()
+	laptop.Reload(context.Background())
+	if !laptop.Match("tracker.example.com") {
+		t.Error("synced rules lost after sync failure")
+	}
+}
@@ -1,12 +1,15 @@
 module github.com/nextdns/nextdns
 
-go 1.13
+go 1.16
 
 replace github.com/kardianos/service => github.com/rs/service v1.0.1-0.20191214021204-b1a37fd90075
 
 require (
 	github.com/cespare/xxhash v1.1.0
 	github.com/denisbrodbeck/machineid v1.0.1
-	golang.org/x/net v0.0.0-20191209160850-c0dbc17a3553
-	golang.org/x/sys v0.0.0-20191115151921-52ab43148777
+	github.com/yuin/gopher-lua v0.0.0-20210529063254-f4c35e4016d9
+	golang.org/x/crypto v0.13.0
+	golang.org/x/net v0.15.0
+	golang.org/x/sys v0.12.0
+	golang.zx2c4.com/wireguard v0.0.0-20231211153847-12269c276173
 )
@@ -0,0 +1,153 @@
+// Package health tracks the health of the upstream resolution and reflects it
+// on status LEDs or through a command, so the DNS state of a router can be seen
+// at a glance.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDownThreshold is the default value for Monitor DownThreshold.
+const DefaultDownThreshold = 5
+
+// State is the health of the upstream resolution.
+type State int
+
+const (
+	// OK is reported when queries are resolved over an encrypted transport.
+	OK State = iota
+	// Degraded is reported when queries are resolved using the plain DNS
+	// fallback (i.e. behind a captive portal).
+	Degraded
+	// Down is reported when queries consistently fail.
+	Down
+)
+
+func (s State) String() string {
+	switch s {
+	case OK:
+		return "ok"
+	case Degraded:
+		return "degraded"
+	case Down:
+		return "down"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// ParseState parses the string representation of a State.
+func ParseState(s string) (State, error) {
+	switch strings.ToLower(s) {
+	case "ok":
+		return OK, nil
+	case "degraded":
+		return Degraded, nil
+	case "down":
+		return Down, nil
+	}
+	return OK, fmt.Errorf("%s: invalid health state", s)
+}
+
+// Monitor computes the health state from the result of upstream queries.
+type Monitor struct {
+	// DownThreshold is the number of consecutive failed queries after which
+	// the state is Down. If zero, DefaultDownThreshold is used.
+	DownThreshold int
+
+	// OnChange is called each time the state changes.
+	OnChange func(State)
+
+	mu       sync.Mutex
+	state    State
+	errors   int
+	fallback bool
+}
+
+// State returns the current state.
+func (m *Monitor) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// Record records the result of an upstream query.
+func (m *Monitor) Record(err error) {
+	m.mu.Lock()
+	if err != nil {
+		m.errors++
+	} else {
+		m.errors = 0
+	}
+	m.updateLocked()
+}
+
+// SetFallback records whether the plain DNS fallback is in use.
+func (m *Monitor) SetFallback(fallback bool) {
+	m.mu.Lock()
+	m.fallback = fallback
+	m.updateLocked()
+}
+
+// updateLocked computes the new state, unlocks m and calls OnChange if the
+// state changed.
+func (m *Monitor) updateLocked() {
+	threshold := m.DownThreshold
+	if threshold == 0 {
+		threshold = DefaultDownThreshold
+	}
+	state := OK
+	switch {
+	case m.errors >= threshold:
+		state = Down
+	case m.fallback:
+		state = Degraded
+	}
+	changed := state != m.state
+	m.state = state
+	m.mu.Unlock()
+	if changed && m.OnChange != nil {
+		m.OnChange(state)
+	}
+}
+
+// ServeHTTP reports the current state as JSON for load balancers and
+// monitoring. The status code is 503 when the state is Down, 200 otherwise.
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := m.State()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if s == Down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": s.String()})
+}
+
+// ListenAndServe serves the state on addr at /healthz until ctx is cancelled.
+func (m *Monitor) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", m)
+	srv := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	if err = srv.Serve(l); err == http.ErrServerClosed {
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package health
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMonitor(t *testing.T) {
+	var changes []State
+	m := &Monitor{
+		DownThreshold: 2,
+		OnChange: func(s State) {
+			changes = append(changes, s)
+		},
+	}
+	errFail := errors.New("fail")
+	m.Record(nil)
+	m.SetFallback(true)
+	m.Record(errFail)
+	m.Record(errFail)
+	m.Record(errFail)
+	m.Record(nil)
+	m.SetFallback(false)
+	want := []State{Degraded, Down, Degraded, OK}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+}
+
+func TestParseLED(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    LED
+		wantErr bool
+	}{
+		{"ok=/sys/class/leds/green:status", LED{State: OK, Path: "/sys/class/leds/green:status"}, false},
+		{"Degraded=/sys/class/leds/amber:status,blink", LED{State: Degraded, Path: "/sys/class/leds/amber:status", Blink: true}, false},
+		{"down=", LED{}, true},
+		{"broken=/sys/class/leds/red:status", LED{}, true},
+		{"/sys/class/leds/red:status", LED{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseLED(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLED() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseLED() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndicator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	green := filepath.Join(dir, "green:status")
+	red := filepath.Join(dir, "red:status")
+	for _, p := range []string{green, red} {
+		if err := os.Mkdir(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(green, "max_brightness"), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	i := &Indicator{
+		LEDs: []LED{
+			{State: OK, Path: green},
+			{State: Down, Path: red, Blink: true},
+		},
+		ErrorLog: func(err error) {
+			t.Error(err)
+		},
+	}
+	check := func(path, trigger, brightness string) {
+		t.Helper()
+		for file, want := range map[string]string{"trigger": trigger, "brightness": brightness} {
+			b, err := ioutil.ReadFile(filepath.Join(path, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != want {
+				t.Errorf("%s/%s = %q, want %q", filepath.Base(path), file, b, want)
+			}
+		}
+	}
+	i.Set(OK)
+	check(green, "none", "1")
+	check(red, "none", "0")
+	i.Set(Down)
+	check(green, "none", "0")
+	check(red, "timer", "255")
+	i.Off()
+	check(red, "none", "0")
+}
+
+func TestMonitor_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		fallback bool
+		errors   int
+		want     string
+		code     int
+	}{
+		{false, 0, "ok", http.StatusOK},
+		{true, 0, "degraded", http.StatusOK},
+		{false, DefaultDownThreshold, "down", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			m := &Monitor{}
+			m.SetFallback(tt.fallback)
+			for i := 0; i < tt.errors; i++ {
+				m.Record(errors.New("fail"))
+			}
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+			if rec.Code != tt.code {
+				t.Errorf("code = %d, want %d", rec.Code, tt.code)
+			}
+			if want := `{"status":"` + tt.want + `"}` + "\n"; rec.Body.String() != want {
+				t.Errorf("body = %q, want %q", rec.Body.String(), want)
+			}
+		})
+	}
+}
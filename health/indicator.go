@@ -0,0 +1,127 @@
+package health
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LED is a LED exposed through the Linux LED class in sysfs
+// (i.e. /sys/class/leds/green:status) lit when the health is in State.
+type LED struct {
+	State State
+	Path  string
+	// Blink makes the LED blink instead of being steadily lit.
+	Blink bool
+}
+
+// ParseLED parses a LED definition in the form STATE=PATH[,blink].
+func ParseLED(s string) (LED, error) {
+	var l LED
+	idx := strings.IndexByte(s, '=')
+	if idx == -1 {
+		return l, fmt.Errorf("%s: missing state", s)
+	}
+	state, err := ParseState(s[:idx])
+	if err != nil {
+		return l, err
+	}
+	l.State = state
+	l.Path = s[idx+1:]
+	if strings.HasSuffix(l.Path, ",blink") {
+		l.Path = strings.TrimSuffix(l.Path, ",blink")
+		l.Blink = true
+	}
+	if l.Path == "" {
+		return l, fmt.Errorf("%s: missing LED path", s)
+	}
+	return l, nil
+}
+
+func (l LED) String() string {
+	s := l.State.String() + "=" + l.Path
+	if l.Blink {
+		s += ",blink"
+	}
+	return s
+}
+
+// set turns the LED on or off.
+func (l LED) set(on bool) error {
+	trigger, brightness := "none", "0"
+	if on {
+		brightness = "255"
+		if b, err := ioutil.ReadFile(filepath.Join(l.Path, "max_brightness")); err == nil {
+			brightness = strings.TrimSpace(string(b))
+		}
+		if l.Blink {
+			trigger = "timer"
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(l.Path, "trigger"), []byte(trigger), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(l.Path, "brightness"), []byte(brightness), 0644)
+}
+
+// Indicator reflects the health state on LEDs and/or by running a command.
+type Indicator struct {
+	// LEDs are lit when the health is in their state and turned off
+	// otherwise.
+	LEDs []LED
+
+	// Command is an optional command run with the name of the new state as
+	// argument each time the state changes.
+	Command string
+
+	// ErrorLog is an optional log function for errors.
+	ErrorLog func(error)
+
+	mu sync.Mutex
+}
+
+// Set reflects the state s.
+func (i *Indicator) Set(s State) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.setLEDsLocked(func(l LED) bool { return l.State == s })
+	if i.Command != "" {
+		go i.run(s.String())
+	}
+}
+
+// Off turns all the LEDs off.
+func (i *Indicator) Off() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.setLEDsLocked(func(l LED) bool { return false })
+}
+
+func (i *Indicator) setLEDsLocked(on func(l LED) bool) {
+	// Turn off LEDs first so a LED shared by several states ends up lit.
+	for _, l := range i.LEDs {
+		if !on(l) {
+			i.logErr(l.set(false))
+		}
+	}
+	for _, l := range i.LEDs {
+		if on(l) {
+			i.logErr(l.set(true))
+		}
+	}
+}
+
+func (i *Indicator) run(state string) {
+	if out, err := exec.Command(i.Command, state).CombinedOutput(); err != nil {
+		i.logErr(fmt.Errorf("health command: %v: %s", err, strings.TrimSpace(string(out))))
+	}
+}
+
+func (i *Indicator) logErr(err error) {
+	if err != nil && i.ErrorLog != nil {
+		i.ErrorLog(err)
+	}
+}
@@ -0,0 +1,245 @@
+// Package hijack periodically tests whether plain DNS traffic is transparently
+// intercepted on the path to the Internet, i.e. by an ISP redirecting port 53
+// to its own resolvers. On such networks, the plain DNS fallback is neither
+// private nor filtered even when it targets a trusted server.
+package hijack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// DefaultInterval is the default value for Detector Interval.
+const DefaultInterval = time.Hour
+
+// DefaultBlackhole is the default value for Detector Blackhole: an address no
+// DNS server answers from (TEST-NET-1, RFC 5737).
+const DefaultBlackhole = "192.0.2.1:53"
+
+// checkTimeout is the maximum duration of each test of a check.
+const checkTimeout = 5 * time.Second
+
+// Result is the outcome of a check.
+type Result struct {
+	Time        time.Time `json:"time"`
+	Intercepted bool      `json:"intercepted"`
+	Detail      string    `json:"detail"`
+}
+
+func (r Result) String() string {
+	if r.Intercepted {
+		return "DNS hijacking detected: " + r.Detail + ", the plain DNS fallback is unsafe on this network"
+	}
+	return "DNS hijacking not detected: " + r.Detail
+}
+
+// Detector tests whether plain DNS is intercepted in two ways: a query sent to
+// an address no DNS server answers from must time out, and the answer to a
+// query sent over plain DNS to Server must match the answer to the same query
+// sent over Secure.
+type Detector struct {
+	// Exchange sends q over plain DNS to addr, i.e. resolver.DNS53 Exchange.
+	Exchange func(ctx context.Context, q resolver.Query, buf []byte, addr string) (int, resolver.ResolveInfo, error)
+
+	// Secure resolves queries over an encrypted transport to the same
+	// provider as Server.
+	Secure resolver.Resolver
+
+	// Server is the plain DNS address answers are compared from. If empty,
+	// only the Blackhole test is performed.
+	Server string
+
+	// Domain is the name queried to compare answers. It must have the same
+	// answer from Server and Secure.
+	Domain string
+
+	// Blackhole is the address queried to detect interception. If empty,
+	// DefaultBlackhole is used.
+	Blackhole string
+
+	// Interval is the time between two checks. If zero, DefaultInterval is
+	// used.
+	Interval time.Duration
+
+	// OnChange is called when interception starts or stops being detected.
+	OnChange func(Result)
+
+	mu      sync.Mutex
+	last    Result
+	checked bool
+}
+
+// Last returns the result of the last check, and false if no check was made
+// yet.
+func (d *Detector) Last() (Result, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.last, d.checked
+}
+
+// Start checks for interception every Interval until ctx is cancelled.
+func (d *Detector) Start(ctx context.Context) {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		d.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Check tests for interception now and returns the result.
+func (d *Detector) Check(ctx context.Context) Result {
+	r := Result{Time: time.Now()}
+	r.Intercepted, r.Detail = d.check(ctx)
+	if ctx.Err() != nil {
+		return r
+	}
+	d.mu.Lock()
+	changed := d.checked && d.last.Intercepted != r.Intercepted || !d.checked && r.Intercepted
+	d.last, d.checked = r, true
+	d.mu.Unlock()
+	if changed && d.OnChange != nil {
+		d.OnChange(r)
+	}
+	return r
+}
+
+func (d *Detector) check(ctx context.Context) (bool, string) {
+	blackhole := d.Blackhole
+	if blackhole == "" {
+		blackhole = DefaultBlackhole
+	}
+	if _, err := d.lookup(ctx, blackhole); err == nil {
+		return true, fmt.Sprintf("%s answered a query on port 53", blackhole)
+	}
+	if d.Server == "" || d.Secure == nil {
+		return false, fmt.Sprintf("%s did not answer", blackhole)
+	}
+	secure, err := d.lookup(ctx, "")
+	if err != nil {
+		return false, fmt.Sprintf("%s did not answer, answers not compared: %v", blackhole, err)
+	}
+	plain, err := d.lookup(ctx, d.Server)
+	if err != nil {
+		return false, fmt.Sprintf("%s did not answer, answers not compared: %v", blackhole, err)
+	}
+	if !intersect(plain, secure) {
+		return true, fmt.Sprintf("%s answered %s for %s instead of %s", d.Server,
+			strings.Join(plain, ","), d.Domain, strings.Join(secure, ","))
+	}
+	return false, fmt.Sprintf("%s did not answer and %s answers match", blackhole, d.Server)
+}
+
+// lookup returns the sorted addresses of Domain, resolved over plain DNS by
+// addr, or by Secure if addr is empty.
+func (d *Detector) lookup(ctx context.Context, addr string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	domain := d.Domain
+	if domain == "" {
+		domain = "."
+	}
+	name, err := dnsmessage.NewName(domain)
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	payload, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	q, err := resolver.NewQuery(payload, net.IPv6loopback)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1232)
+	var n int
+	if addr != "" {
+		n, _, err = d.Exchange(ctx, q, buf, addr)
+	} else {
+		var i resolver.ResolveInfo
+		n, i, err = d.Secure.Resolve(ctx, q, buf)
+		if err == nil && i.Transport == "UDP" {
+			err = errors.New("encrypted resolver fell back to plain DNS")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return addresses(buf[:n])
+}
+
+// addresses returns the sorted A records of the response msg, or its RCode
+// or NODATA if it has none.
+func addresses(msg []byte) ([]string, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return []string{h.RCode.String()}, nil
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rh.Type != dnsmessage.TypeA {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		a, err := p.AResource()
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, net.IP(a.A[:]).String())
+	}
+	if len(addrs) == 0 {
+		return []string{"NODATA"}, nil
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// intersect returns true if a and b have an answer in common. Anycast or load
+// balanced answers can legitimately differ, an interceptor answers with none
+// of them.
+func intersect(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package hijack
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// answer writes a response to q with the A records ips into buf.
+func answer(q resolver.Query, buf []byte, ips ...string) (int, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return 0, err
+	}
+	question, err := p.Question()
+	if err != nil {
+		return 0, err
+	}
+	h.Response = true
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(question)
+	_ = b.StartAnswers()
+	for _, ip := range ips {
+		var a dnsmessage.AResource
+		copy(a.A[:], net.ParseIP(ip).To4())
+		_ = b.AResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}, a)
+	}
+	res, err := b.Finish()
+	return len(res), err
+}
+
+func TestDetector_Check(t *testing.T) {
+	secure := resolver.ResolverFunc(func(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+		n, err := answer(q, buf, "1.2.3.4", "1.2.3.5")
+		return n, resolver.ResolveInfo{Transport: "HTTP/2.0"}, err
+	})
+	tests := []struct {
+		name      string
+		blackhole bool
+		plain     []string
+		want      bool
+	}{
+		{"clean", false, []string{"1.2.3.5"}, false},
+		{"blackhole answered", true, []string{"1.2.3.4"}, true},
+		{"answers differ", false, []string{"10.0.0.1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var changes []Result
+			d := &Detector{
+				Exchange: func(ctx context.Context, q resolver.Query, buf []byte, addr string) (int, resolver.ResolveInfo, error) {
+					if addr == DefaultBlackhole && !tt.blackhole {
+						return 0, resolver.ResolveInfo{}, errors.New("timeout")
+					}
+					n, err := answer(q, buf, tt.plain...)
+					return n, resolver.ResolveInfo{Transport: "UDP"}, err
+				},
+				Secure: secure,
+				Server: "45.90.28.0:53",
+				Domain: "probe-test.dns.nextdns.io.",
+				OnChange: func(r Result) {
+					changes = append(changes, r)
+				},
+			}
+			if r := d.Check(context.Background()); r.Intercepted != tt.want {
+				t.Errorf("Check() = %v, want intercepted %v", r, tt.want)
+			}
+			if tt.want != (len(changes) == 1) {
+				t.Errorf("OnChange called %d times", len(changes))
+			}
+			if last, ok := d.Last(); !ok || last.Intercepted != tt.want {
+				t.Errorf("Last() = %v, %v", last, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package history
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Formats supported by NewWriter.
+const (
+	FormatCSV     = "csv"
+	FormatJSON    = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// Writer writes records in an export format.
+type Writer interface {
+	Write(r Record) error
+
+	// Close flushes the buffered records and terminates the export. It
+	// does not close the underlying writer.
+	Close() error
+}
+
+// column is a column of the CSV and Parquet exports.
+type column struct {
+	name  string
+	kind  byte // parquet physical type
+	value func(r Record) interface{}
+}
+
+// columns are the exported columns, in order.
+var columns = []column{
+	{"time", typeInt64, func(r Record) interface{} { return r.Time }},
+	{"client", typeByteArray, func(r Record) interface{} { return r.Client }},
+	{"mac", typeByteArray, func(r Record) interface{} { return r.MAC }},
+	{"device", typeByteArray, func(r Record) interface{} { return r.Device }},
+	{"protocol", typeByteArray, func(r Record) interface{} { return r.Protocol }},
+	{"name", typeByteArray, func(r Record) interface{} { return r.Name }},
+	{"type", typeByteArray, func(r Record) interface{} { return r.Type }},
+	{"response_size", typeInt32, func(r Record) interface{} { return r.ResponseSize }},
+	{"duration_ms", typeDouble, func(r Record) interface{} { return r.Duration }},
+	{"transport", typeByteArray, func(r Record) interface{} { return r.Transport }},
+	{"error", typeByteArray, func(r Record) interface{} { return r.Error }},
+}
+
+// NewWriter returns a Writer writing records to w in format.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		cw := &csvWriter{w: csv.NewWriter(w)}
+		return cw, cw.header()
+	case FormatJSON:
+		return jsonWriter{json.NewEncoder(w)}, nil
+	case FormatParquet:
+		return newParquetWriter(w)
+	default:
+		return nil, fmt.Errorf("%s: unsupported format", format)
+	}
+}
+
+type jsonWriter struct {
+	enc *json.Encoder
+}
+
+func (w jsonWriter) Write(r Record) error {
+	return w.enc.Encode(r)
+}
+
+func (w jsonWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	w   *csv.Writer
+	row []string
+}
+
+func (w *csvWriter) header() error {
+	w.row = make([]string, len(columns))
+	for i, c := range columns {
+		w.row[i] = c.name
+	}
+	return w.w.Write(w.row)
+}
+
+func (w *csvWriter) Write(r Record) error {
+	for i, c := range columns {
+		switch v := c.value(r).(type) {
+		case time.Time:
+			w.row[i] = v.UTC().Format(time.RFC3339Nano)
+		case string:
+			w.row[i] = v
+		case int:
+			w.row[i] = strconv.Itoa(v)
+		case float64:
+			w.row[i] = strconv.FormatFloat(v, 'f', 3, 64)
+		}
+	}
+	return w.w.Write(w.row)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,33 @@
+package history
+
+import "strings"
+
+// Filter selects records. Empty fields match all the records.
+type Filter struct {
+	// Client is the IP address, MAC address or device name of the client.
+	Client string
+
+	// Domain is the domain the queried name must be or be a subdomain of.
+	Domain string
+
+	// RCode is the response code, i.e. NXDOMAIN.
+	RCode string
+}
+
+// Match returns true if r is selected by f.
+func (f Filter) Match(r Record) bool {
+	if f.Client != "" && !strings.EqualFold(f.Client, r.Client) && !strings.EqualFold(f.Client, r.MAC) && !strings.EqualFold(f.Client, r.Device) {
+		return false
+	}
+	if f.Domain != "" {
+		domain := strings.ToLower(strings.TrimSuffix(f.Domain, "."))
+		name := strings.ToLower(r.Name)
+		if name != domain && !strings.HasSuffix(name, "."+domain) {
+			return false
+		}
+	}
+	if f.RCode != "" && !strings.EqualFold(f.RCode, r.RCode) {
+		return false
+	}
+	return true
+}
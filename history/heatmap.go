@@ -0,0 +1,172 @@
+package history
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Heatmap metrics.
+const (
+	MetricQueries = "queries"
+	MetricP95     = "p95"
+)
+
+// latencyBuckets is the number of buckets of the latency histogram of a
+// heatmap cell. Bucket i holds latencies up to latencyBucketRatio^(i+1)-1
+// milliseconds, for a resolution of 10% up to about 12 seconds.
+const latencyBuckets = 100
+
+const latencyBucketRatio = 1.1
+
+// shades are the characters used to render a cell, from the lowest to the
+// highest value.
+var shades = []string{"  ", "░░", "▒▒", "▓▓", "██"}
+
+// Heatmap aggregates the query volume and latency of records by day of the
+// week and hour of the day.
+type Heatmap struct {
+	// Location is the time zone the records are aggregated in. If nil,
+	// time.Local is used.
+	Location *time.Location
+
+	cells [7][24]heatCell
+}
+
+type heatCell struct {
+	count     int
+	latencies [latencyBuckets]int
+	measured  int
+}
+
+// Add adds r to the heatmap. The latency of failed queries is ignored.
+func (h *Heatmap) Add(r Record) error {
+	loc := h.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t := r.Time.In(loc)
+	c := &h.cells[t.Weekday()][t.Hour()]
+	c.count++
+	if r.Error == "" {
+		i := int(math.Log(r.Duration+1) / math.Log(latencyBucketRatio))
+		if i >= latencyBuckets {
+			i = latencyBuckets - 1
+		} else if i < 0 {
+			i = 0
+		}
+		c.latencies[i]++
+		c.measured++
+	}
+	return nil
+}
+
+// Count returns the number of queries received on day at hour.
+func (h *Heatmap) Count(day time.Weekday, hour int) int {
+	return h.cells[day][hour].count
+}
+
+// P95 returns the 95th percentile latency of the queries answered on day at
+// hour, rounded up to the bucket resolution, or 0 if there are none.
+func (h *Heatmap) P95(day time.Weekday, hour int) time.Duration {
+	return h.cells[day][hour].p95()
+}
+
+// Total returns the number of queries and failed queries, and the 95th
+// percentile latency over the whole heatmap.
+func (h *Heatmap) Total() (queries, errors int, p95 time.Duration) {
+	var t heatCell
+	for d := range h.cells {
+		for _, c := range h.cells[d] {
+			t.count += c.count
+			t.measured += c.measured
+			for i, cnt := range c.latencies {
+				t.latencies[i] += cnt
+			}
+		}
+	}
+	return t.count, t.count - t.measured, t.p95()
+}
+
+func (c *heatCell) p95() time.Duration {
+	if c.measured == 0 {
+		return 0
+	}
+	rank := (c.measured*95 + 99) / 100
+	n := 0
+	for i, cnt := range c.latencies {
+		if n += cnt; n >= rank {
+			ms := math.Pow(latencyBucketRatio, float64(i+1)) - 1
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	return 0
+}
+
+func (h *Heatmap) value(metric string, day time.Weekday, hour int) float64 {
+	if metric == MetricP95 {
+		return float64(h.P95(day, hour))
+	}
+	return float64(h.Count(day, hour))
+}
+
+// Render writes a 24x7 grid of metric to w, with one row per day of the week
+// starting on Monday and one column per hour, each cell shaded relative to
+// the highest value.
+func (h *Heatmap) Render(w io.Writer, metric string) error {
+	var title string
+	var format func(v float64) string
+	switch metric {
+	case MetricQueries:
+		title = "Queries per hour"
+		format = func(v float64) string { return fmt.Sprintf("%.0f", v) }
+	case MetricP95:
+		title = "p95 latency per hour"
+		format = func(v float64) string { return time.Duration(v).Round(time.Millisecond).String() }
+	default:
+		return fmt.Errorf("%s: unsupported metric", metric)
+	}
+	var max float64
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		for hour := 0; hour < 24; hour++ {
+			max = math.Max(max, h.value(metric, d, hour))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(title + "\n\n    ")
+	for hour := 0; hour < 24; hour++ {
+		fmt.Fprintf(&b, "%02d", hour)
+	}
+	b.WriteString("\n")
+	for i := 1; i <= 7; i++ {
+		d := time.Weekday(i % 7)
+		b.WriteString(d.String()[:3] + " ")
+		for hour := 0; hour < 24; hour++ {
+			b.WriteString(shade(h.value(metric, d, hour), max))
+		}
+		b.WriteString("\n")
+	}
+	legend := []string{shades[0] + " none"}
+	for i, s := range shades[1:] {
+		legend = append(legend, s+" >"+format(max*float64(i)/float64(len(shades)-1)))
+	}
+	b.WriteString("\n    " + strings.Join(legend, "  ") + "\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// shade returns the shade of v in a scale from 0 to max. Only zero values are
+// rendered blank.
+func shade(v, max float64) string {
+	if v <= 0 || max <= 0 {
+		return shades[0]
+	}
+	i := int(math.Ceil(v / max * float64(len(shades)-1)))
+	if i >= len(shades) {
+		i = len(shades) - 1
+	}
+	return shades[i]
+}
@@ -0,0 +1,233 @@
+// Package history retains the queries locally in daily files so they can be
+// exported for offline analysis.
+//
+// Queries are stored as newline delimited JSON in one file per UTC day named
+// queries-YYYY-MM-DD.jsonl. Like mirroring, recording never affects the answer
+// path: records are queued and dropped if the disk is slow.
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultQueueSize is the number of records queued before new records are
+// dropped.
+const defaultQueueSize = 1000
+
+// flushInterval is the maximum time records stay buffered before being
+// written to disk.
+const flushInterval = 5 * time.Second
+
+const (
+	filePrefix = "queries-"
+	fileSuffix = ".jsonl"
+	dayLayout  = "2006-01-02"
+)
+
+// Record is a query retained in the history.
+type Record struct {
+	Time         time.Time `json:"time"`
+	Client       string    `json:"client"`
+	MAC          string    `json:"mac,omitempty"`
+	Device       string    `json:"device,omitempty"`
+	Protocol     string    `json:"protocol"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	ResponseSize int       `json:"response_size"`
+	Duration     float64   `json:"duration_ms"`
+	Transport    string    `json:"transport,omitempty"`
+	RCode        string    `json:"rcode,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Store writes records to Dir and removes the files older than Retention.
+type Store struct {
+	// Dir is the directory of the history files.
+	Dir string
+
+	// Retention is the time records are kept. Records are kept forever if
+	// zero.
+	Retention time.Duration
+
+	// ErrorLog is an optional log function for errors.
+	ErrorLog func(error)
+
+	queue chan Record
+}
+
+// Init creates Dir and initializes the queue. It must be called before Record
+// or Start.
+func (s *Store) Init() error {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	s.queue = make(chan Record, defaultQueueSize)
+	return nil
+}
+
+// Record queues r to be written. It never blocks: r is dropped if the queue
+// is full.
+func (s *Store) Record(r Record) {
+	if s.queue == nil {
+		return
+	}
+	select {
+	case s.queue <- r:
+	default:
+	}
+}
+
+// Start writes the queued records until ctx is cancelled.
+func (s *Store) Start(ctx context.Context) {
+	var f *os.File
+	var w *bufio.Writer
+	var day string
+	closeFile := func() {
+		if f != nil {
+			if err := w.Flush(); err != nil {
+				s.logErr(err)
+			}
+			_ = f.Close()
+			f, w = nil, nil
+		}
+	}
+	defer closeFile()
+	s.purge(time.Now())
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-flush.C:
+			if w != nil {
+				if err := w.Flush(); err != nil {
+					s.logErr(err)
+				}
+			}
+		case r := <-s.queue:
+			if d := r.Time.UTC().Format(dayLayout); d != day || f == nil {
+				closeFile()
+				var err error
+				if f, err = os.OpenFile(s.file(d), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+					s.logErr(err)
+					continue
+				}
+				w = bufio.NewWriter(f)
+				if day != "" {
+					// New day, remove expired files.
+					s.purge(r.Time)
+				}
+				day = d
+			}
+			b, _ := json.Marshal(r)
+			b = append(b, '\n')
+			if _, err := w.Write(b); err != nil {
+				s.logErr(err)
+			}
+		}
+	}
+}
+
+func (s *Store) file(day string) string {
+	return filepath.Join(s.Dir, filePrefix+day+fileSuffix)
+}
+
+// purge removes the files with only records older than Retention.
+func (s *Store) purge(now time.Time) {
+	if s.Retention <= 0 {
+		return
+	}
+	days, err := listDays(s.Dir)
+	if err != nil {
+		s.logErr(err)
+		return
+	}
+	limit := now.Add(-s.Retention).UTC()
+	for _, d := range days {
+		if !d.Add(24 * time.Hour).After(limit) {
+			if err := os.Remove(s.file(d.Format(dayLayout))); err != nil {
+				s.logErr(err)
+			}
+		}
+	}
+}
+
+func (s *Store) logErr(err error) {
+	if s.ErrorLog != nil {
+		s.ErrorLog(fmt.Errorf("history: %v", err))
+	}
+}
+
+// listDays returns the days of the history files in dir, oldest first.
+func listDays(dir string) ([]time.Time, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []time.Time
+	for _, fi := range fis {
+		name := fi.Name()
+		if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		d, err := time.Parse(dayLayout, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+		if err != nil {
+			continue
+		}
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
+
+// Read calls f for each record of the history in dir received between from
+// (inclusive) and to (exclusive), oldest first. A zero from or to leaves the
+// range open. Lines that cannot be decoded (i.e. being written) are skipped.
+func Read(dir string, from, to time.Time, f func(Record) error) error {
+	days, err := listDays(dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range days {
+		if !from.IsZero() && !d.Add(24*time.Hour).After(from) || !to.IsZero() && !d.Before(to) {
+			continue
+		}
+		if err := readFile(filepath.Join(dir, filePrefix+d.Format(dayLayout)+fileSuffix), from, to, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(file string, from, to time.Time, f func(Record) error) error {
+	fd, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	sc := bufio.NewScanner(fd)
+	sc.Buffer(make([]byte, 0, 4096), 1<<20)
+	for sc.Scan() {
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			continue
+		}
+		if !from.IsZero() && r.Time.Before(from) || !to.IsZero() && !r.Time.Before(to) {
+			continue
+		}
+		if err := f(r); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
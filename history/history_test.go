@@ -0,0 +1,300 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now().UTC()
+	old := filepath.Join(dir, "queries-"+now.AddDate(0, 0, -10).Format(dayLayout)+".jsonl")
+	if err := ioutil.WriteFile(old, []byte(`{"name":"old.com"}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &Store{Dir: dir, Retention: 7 * 24 * time.Hour}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Start(ctx)
+		close(done)
+	}()
+	for i, name := range []string{"a.com", "b.com", "c.com"} {
+		s.Record(Record{Time: now.Add(time.Duration(i) * time.Second), Name: name})
+	}
+	for len(s.queue) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expired file not removed: %v", err)
+	}
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     string
+	}{
+		{"All", time.Time{}, time.Time{}, "a.com,b.com,c.com"},
+		{"From", now.Add(time.Second), time.Time{}, "b.com,c.com"},
+		{"To", time.Time{}, now.Add(time.Second), "a.com"},
+		{"Future", now.Add(time.Hour), time.Time{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			err := Read(dir, tt.from, tt.to, func(r Record) error {
+				names = append(names, r.Name)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("Read() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewWriter_CSV(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Write(Record{Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Client: "10.0.0.1", Name: "a,b.com", Type: "A", Duration: 1.5})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "time,client,mac,device,protocol,name,type,response_size,duration_ms,transport,error\n" +
+		"2026-01-02T03:04:05Z,10.0.0.1,,,,\"a,b.com\",A,0,1.500,,\n"
+	if buf.String() != want {
+		t.Errorf("CSV = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestNewWriter_Parquet(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatParquet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	const n = rowGroupSize + 10
+	for i := 0; i < n; i++ {
+		_ = w.Write(Record{Time: start.Add(time.Duration(i) * time.Millisecond), Name: "example.com"})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if !bytes.HasPrefix(b, parquetMagic) || !bytes.HasSuffix(b, parquetMagic) {
+		t.Fatal("missing magic")
+	}
+	size := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	footer := b[len(b)-8-size : len(b)-8]
+	d := &thriftDecoder{b: footer}
+	meta, err := d.structure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.b) != 0 {
+		t.Errorf("%d bytes left after metadata", len(d.b))
+	}
+	if got := meta[3]; got != int64(n) {
+		t.Errorf("num_rows = %v, want %d", got, n)
+	}
+	if got := len(meta[2].([]interface{})); got != len(columns)+1 {
+		t.Errorf("schema has %d elements, want %d", got, len(columns)+1)
+	}
+	groups := meta[4].([]interface{})
+	if len(groups) != 2 {
+		t.Fatalf("%d row groups, want 2", len(groups))
+	}
+	// Check the first value of the time column of the second group.
+	chunk := groups[1].(map[int16]interface{})[1].([]interface{})[0].(map[int16]interface{})
+	offset := chunk[3].(map[int16]interface{})[9].(int64)
+	d = &thriftDecoder{b: b[offset:]}
+	page, err := d.structure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := page[5].(map[int16]interface{})[1]; got != int64(10) {
+		t.Errorf("num_values = %v, want 10", got)
+	}
+	ms := int64(binary.LittleEndian.Uint64(d.b))
+	if want := start.Add(rowGroupSize*time.Millisecond).UnixNano() / 1e6; ms != want {
+		t.Errorf("time = %d, want %d", ms, want)
+	}
+}
+
+// thriftDecoder decodes the Thrift compact protocol structures written by
+// thrift, as a generic tree.
+type thriftDecoder struct {
+	b []byte
+}
+
+func (d *thriftDecoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		return 0, errors.New("invalid varint")
+	}
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *thriftDecoder) varint() (int64, error) {
+	v, err := d.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (d *thriftDecoder) value(typ byte) (interface{}, error) {
+	switch typ {
+	case thriftI32, thriftI64:
+		return d.varint()
+	case thriftBinary:
+		n, err := d.uvarint()
+		if err != nil || uint64(len(d.b)) < n {
+			return nil, errors.New("invalid binary")
+		}
+		s := string(d.b[:n])
+		d.b = d.b[n:]
+		return s, nil
+	case thriftList:
+		if len(d.b) == 0 {
+			return nil, errors.New("invalid list")
+		}
+		h := d.b[0]
+		d.b = d.b[1:]
+		n := uint64(h >> 4)
+		if n == 15 {
+			var err error
+			if n, err = d.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		l := []interface{}{}
+		for i := uint64(0); i < n; i++ {
+			v, err := d.value(h & 0xf)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		return l, nil
+	case thriftStruct:
+		return d.structure()
+	}
+	return nil, errors.New("unsupported type")
+}
+
+func (d *thriftDecoder) structure() (map[int16]interface{}, error) {
+	s := map[int16]interface{}{}
+	var id int16
+	for {
+		if len(d.b) == 0 {
+			return nil, errors.New("unterminated struct")
+		}
+		h := d.b[0]
+		d.b = d.b[1:]
+		if h == 0 {
+			return s, nil
+		}
+		if delta := int16(h >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := d.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		v, err := d.value(h & 0xf)
+		if err != nil {
+			return nil, err
+		}
+		s[id] = v
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	h := Heatmap{Location: time.UTC}
+	mon := time.Date(2026, 1, 5, 10, 30, 0, 0, time.UTC)
+	for i := 1; i <= 100; i++ {
+		_ = h.Add(Record{Time: mon, Duration: float64(i)})
+	}
+	_ = h.Add(Record{Time: mon, Error: "timeout"})
+	_ = h.Add(Record{Time: mon.Add(24 * time.Hour), Duration: 5})
+	tests := []struct {
+		day      time.Weekday
+		hour     int
+		count    int
+		p95, max time.Duration
+	}{
+		{time.Monday, 10, 101, 95 * time.Millisecond, 105 * time.Millisecond},
+		{time.Tuesday, 10, 1, 5 * time.Millisecond, 6 * time.Millisecond},
+		{time.Monday, 11, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := h.Count(tt.day, tt.hour); got != tt.count {
+			t.Errorf("Count(%v, %d) = %d, want %d", tt.day, tt.hour, got, tt.count)
+		}
+		if got := h.P95(tt.day, tt.hour); got < tt.p95 || got > tt.max {
+			t.Errorf("P95(%v, %d) = %v, want %v", tt.day, tt.hour, got, tt.p95)
+		}
+	}
+	if queries, errs, _ := h.Total(); queries != 102 || errs != 1 {
+		t.Errorf("Total() = %d, %d, want 102, 1", queries, errs)
+	}
+	var buf bytes.Buffer
+	if err := h.Render(&buf, MetricQueries); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(buf.String(), "\n"); len(lines) != 13 || lines[3] != "Mon "+strings.Repeat(" ", 20)+"██"+strings.Repeat(" ", 26) {
+		t.Errorf("unexpected rendering:\n%s", buf.String())
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	r := Record{Client: "10.0.0.1", MAC: "00:11:22:33:44:55", Device: "tv", Name: "www.example.com", RCode: "NXDOMAIN"}
+	tests := []struct {
+		f    Filter
+		want bool
+	}{
+		{Filter{}, true},
+		{Filter{Client: "10.0.0.1"}, true},
+		{Filter{Client: "00:11:22:33:44:55"}, true},
+		{Filter{Client: "TV"}, true},
+		{Filter{Client: "10.0.0.2"}, false},
+		{Filter{Domain: "example.com"}, true},
+		{Filter{Domain: "www.example.com."}, true},
+		{Filter{Domain: "ample.com"}, false},
+		{Filter{RCode: "nxdomain"}, true},
+		{Filter{RCode: "NOERROR"}, false},
+		{Filter{Client: "tv", Domain: "example.com", RCode: "NOERROR"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.f.Match(r); got != tt.want {
+			t.Errorf("%+v.Match() = %v, want %v", tt.f, got, tt.want)
+		}
+	}
+}
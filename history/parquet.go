@@ -0,0 +1,285 @@
+package history
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// rowGroupSize is the number of records buffered before being written as a
+// row group, bounding the memory used by large exports.
+const rowGroupSize = 50000
+
+// Parquet physical types.
+const (
+	typeInt32     = 1
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+)
+
+// Parquet converted types.
+const (
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+)
+
+var parquetMagic = []byte("PAR1")
+
+// parquetWriter writes a Parquet file with one required column per entry of
+// columns, PLAIN encoded and uncompressed, with one data page per column
+// chunk. The encoding is kept minimal so the file is read by any Parquet
+// reader (i.e. pandas, DuckDB) without adding dependencies.
+type parquetWriter struct {
+	w      io.Writer
+	offset int64
+	rows   []Record
+	groups []rowGroup
+}
+
+type rowGroup struct {
+	columns []columnChunk
+	size    int64
+	rows    int64
+}
+
+type columnChunk struct {
+	offset int64
+	size   int64
+}
+
+func newParquetWriter(w io.Writer) (*parquetWriter, error) {
+	pw := &parquetWriter{w: w}
+	return pw, pw.write(parquetMagic)
+}
+
+func (pw *parquetWriter) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+func (pw *parquetWriter) Write(r Record) error {
+	pw.rows = append(pw.rows, r)
+	if len(pw.rows) >= rowGroupSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered records as a row group.
+func (pw *parquetWriter) flush() error {
+	if len(pw.rows) == 0 {
+		return nil
+	}
+	g := rowGroup{rows: int64(len(pw.rows))}
+	var data []byte
+	for _, c := range columns {
+		data = data[:0]
+		for _, r := range pw.rows {
+			data = appendPlain(data, c.value(r))
+		}
+		var h thrift
+		h.i32(1, 0) // type: DATA_PAGE
+		h.i32(2, int32(len(data)))
+		h.i32(3, int32(len(data)))
+		h.structBegin(5) // data_page_header
+		h.i32(1, int32(len(pw.rows)))
+		h.i32(2, 0) // encoding: PLAIN
+		h.i32(3, 3) // definition_level_encoding: RLE
+		h.i32(4, 3) // repetition_level_encoding: RLE
+		h.structEnd()
+		h.stop()
+		chunk := columnChunk{offset: pw.offset, size: int64(len(h.b) + len(data))}
+		if err := pw.write(h.b); err != nil {
+			return err
+		}
+		if err := pw.write(data); err != nil {
+			return err
+		}
+		g.columns = append(g.columns, chunk)
+		g.size += chunk.size
+	}
+	pw.groups = append(pw.groups, g)
+	pw.rows = pw.rows[:0]
+	return nil
+}
+
+func appendPlain(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case time.Time:
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], uint64(v.UnixNano()/int64(time.Millisecond)))
+		return append(b, buf[:]...)
+	case string:
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], uint32(len(v)))
+		return append(append(b, buf[:]...), v...)
+	case int:
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], uint32(int32(v)))
+		return append(b, buf[:]...)
+	case float64:
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+		return append(b, buf[:]...)
+	}
+	return b
+}
+
+// Close writes the remaining records and the file metadata.
+func (pw *parquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+	var numRows int64
+	for _, g := range pw.groups {
+		numRows += g.rows
+	}
+	var m thrift
+	m.i32(1, 1) // version
+	m.listBegin(2, thriftStruct, len(columns)+1)
+	m.elemBegin()
+	m.binary(4, "schema")
+	m.i32(5, int32(len(columns))) // num_children
+	m.structEnd()
+	for _, c := range columns {
+		m.elemBegin()
+		m.i32(1, int32(c.kind))
+		m.i32(3, 0) // repetition_type: REQUIRED
+		m.binary(4, c.name)
+		switch c.kind {
+		case typeByteArray:
+			m.i32(6, convertedUTF8)
+		case typeInt64:
+			m.i32(6, convertedTimestampMillis)
+		}
+		m.structEnd()
+	}
+	m.i64(3, numRows)
+	m.listBegin(4, thriftStruct, len(pw.groups))
+	for _, g := range pw.groups {
+		m.elemBegin()
+		m.listBegin(1, thriftStruct, len(g.columns))
+		for i, cc := range g.columns {
+			m.elemBegin()
+			m.i64(2, cc.offset) // file_offset
+			m.structBegin(3)    // meta_data
+			m.i32(1, int32(columns[i].kind))
+			m.listBegin(2, thriftI32, 1)
+			m.varint(0) // encodings: PLAIN
+			m.listBegin(3, thriftBinary, 1)
+			m.str(columns[i].name) // path_in_schema
+			m.i32(4, 0)            // codec: UNCOMPRESSED
+			m.i64(5, g.rows)
+			m.i64(6, cc.size)
+			m.i64(7, cc.size)
+			m.i64(9, cc.offset) // data_page_offset
+			m.structEnd()
+			m.structEnd()
+		}
+		m.i64(2, g.size)
+		m.i64(3, g.rows)
+		m.structEnd()
+	}
+	m.binary(6, "nextdns")
+	m.stop()
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(m.b)))
+	for _, b := range [][]byte{m.b, size[:], parquetMagic} {
+		if err := pw.write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thrift encodes the Parquet metadata with the Thrift compact protocol.
+type thrift struct {
+	b     []byte
+	last  int16   // last field id of the current struct
+	stack []int16 // last field ids of the enclosing structs
+}
+
+func (t *thrift) varint(v int64) {
+	t.uvarint(uint64(v<<1) ^ uint64(v>>63)) // zigzag
+}
+
+func (t *thrift) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.b = append(t.b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func (t *thrift) str(s string) {
+	t.uvarint(uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+func (t *thrift) field(id int16, typ byte) {
+	if d := id - t.last; d > 0 && d <= 15 {
+		t.b = append(t.b, byte(d)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.varint(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thrift) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.str(s)
+}
+
+func (t *thrift) listBegin(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|elem)
+		return
+	}
+	t.b = append(t.b, 0xf0|elem)
+	t.uvarint(uint64(n))
+}
+
+// structBegin starts a struct field. It must be terminated by structEnd.
+func (t *thrift) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+// elemBegin starts a struct element of a list. It must be terminated by
+// structEnd.
+func (t *thrift) elemBegin() {
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thrift) structEnd() {
+	t.stop()
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+// stop terminates the top level struct.
+func (t *thrift) stop() {
+	t.b = append(t.b, 0)
+}
@@ -135,6 +135,17 @@ func ResetDNS() error {
 	return updateResolvconf()
 }
 
+// DNSResidue returns the changes made by SetDNS still present on the system.
+func DNSResidue() []string {
+	residue := resolvConfResidue()
+	for _, file := range []string{resolvconfBackupFile, resolvconfTmpFile} {
+		if _, err := os.Stat(file); err == nil {
+			residue = append(residue, file)
+		}
+	}
+	return residue
+}
+
 func updateResolvconf() error {
 	return exec.Command("/sbin/resolvconf", "-u").Run()
 }
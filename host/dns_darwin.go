@@ -1,11 +1,30 @@
 package host
 
 import (
+	"bufio"
 	"bytes"
-	"errors"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
 	"os/exec"
+	"regexp"
+	"strings"
 )
 
+// dnsBackupFile stores the DNS configuration of the network services before
+// SetDNS changed it. It is stored in /var/run as the changes made to the
+// dynamic store do not survive a reboot either.
+const dnsBackupFile = "/var/run/nextdns.dns-backup"
+
+// dnsBackup is the DNS configuration of a network service.
+type dnsBackup struct {
+	// Exists is false if the service had no DNS configuration.
+	Exists  bool     `json:"exists"`
+	Servers []string `json:"servers"`
+}
+
 func DNS() []string {
 	b, err := exec.Command("ipconfig", "getoption", "", "domain_name_server").Output()
 	if err != nil {
@@ -18,27 +37,210 @@ func DNS() []string {
 	return []string{string(b)}
 }
 
+// SetDNS sets dns as the resolver of all the network services by overriding
+// their DNS configuration in the SystemConfiguration dynamic store. The
+// original configuration is saved so it can be restored by ResetDNS. As the
+// override is lost when the network configuration is reloaded, SetDNS must be
+// called again on network changes.
 func SetDNS(dns string) error {
-	netServices, err := listNetworkServices()
+	services, err := listServices()
 	if err != nil {
 		return err
 	}
-	for _, net := range netServices {
-		if err := setDNS(net, dns); err != nil {
-			return err
+	backups := map[string]dnsBackup{}
+	if b, err := ioutil.ReadFile(dnsBackupFile); err == nil {
+		if err := json.Unmarshal(b, &backups); err != nil {
+			return fmt.Errorf("%s: %v", dnsBackupFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for _, id := range services {
+		if _, found := backups[id]; found {
+			continue
+		}
+		servers, exists, err := serviceDNS(id)
+		if err != nil {
+			return fmt.Errorf("service %s: %v", id, err)
+		}
+		backups[id] = dnsBackup{Exists: exists, Servers: servers}
+	}
+	b, err := json.Marshal(backups)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(dnsBackupFile, b, 0644); err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+	for _, id := range services {
+		if err := setServiceDNS(id, []string{dns}); err != nil {
+			return fmt.Errorf("service %s: %v", id, err)
 		}
 	}
 	return nil
 }
 
+// ResetDNS restores the DNS configuration saved by SetDNS. Services still
+// configured with a loopback DNS in the network preferences by previous
+// versions are reset as well.
 func ResetDNS() error {
-	return SetDNS("empty")
+	b, err := ioutil.ReadFile(dnsBackupFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		backups := map[string]dnsBackup{}
+		if err := json.Unmarshal(b, &backups); err != nil {
+			return fmt.Errorf("%s: %v", dnsBackupFile, err)
+		}
+		for id, bk := range backups {
+			var err error
+			if bk.Exists {
+				err = setServiceDNS(id, bk.Servers)
+			} else {
+				_, err = scutil("remove " + serviceDNSKey(id))
+			}
+			if err != nil {
+				return fmt.Errorf("service %s: %v", id, err)
+			}
+		}
+		if err := os.Remove(dnsBackupFile); err != nil {
+			return err
+		}
+	}
+	for _, svc := range legacyResidue() {
+		if err := setDNS(svc, "empty"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DNSResidue returns the changes made by SetDNS still present on the system.
+func DNSResidue() []string {
+	var residue []string
+	if _, err := os.Stat(dnsBackupFile); err == nil {
+		residue = append(residue, dnsBackupFile)
+	}
+	for _, svc := range legacyResidue() {
+		residue = append(residue, "network service "+svc)
+	}
+	return residue
+}
+
+func serviceDNSKey(id string) string {
+	return "Setup:/Network/Service/" + id + "/DNS"
+}
+
+var serviceKeyRe = regexp.MustCompile(`Setup:/Network/Service/([^/]+)/IPv4`)
+
+// listServices returns the IDs of the network services with an IPv4
+// configuration.
+func listServices() ([]string, error) {
+	out, err := scutil(`list Setup:/Network/Service/[^/]+/IPv4`)
+	if err != nil {
+		return nil, err
+	}
+	return parseServiceIDs(out), nil
+}
+
+func parseServiceIDs(out string) []string {
+	var ids []string
+	for _, m := range serviceKeyRe.FindAllStringSubmatch(out, -1) {
+		ids = append(ids, m[1])
+	}
+	return ids
+}
+
+// serviceDNS returns the DNS servers configured for the service id. exists is
+// false if the service has no DNS configuration.
+func serviceDNS(id string) (servers []string, exists bool, err error) {
+	out, err := scutil("show " + serviceDNSKey(id))
+	if err != nil {
+		return nil, false, err
+	}
+	return parseServerAddresses(out)
+}
+
+func parseServerAddresses(out string) (servers []string, exists bool, err error) {
+	if strings.Contains(out, "No such key") {
+		return nil, false, nil
+	}
+	s := bufio.NewScanner(strings.NewReader(out))
+	inServers := false
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case strings.HasPrefix(line, "ServerAddresses :"):
+			inServers = true
+		case inServers && line == "}":
+			inServers = false
+		case inServers:
+			if idx := strings.Index(line, " : "); idx != -1 {
+				servers = append(servers, line[idx+3:])
+			}
+		}
+	}
+	return servers, true, s.Err()
+}
+
+// setServiceDNS sets the server addresses of the service id, keeping the other
+// DNS settings (i.e. search domains) untouched. If servers is empty, the
+// server addresses are removed.
+func setServiceDNS(id string, servers []string) error {
+	_, err := scutil(setServiceDNSCommands(id, servers))
+	return err
+}
+
+func setServiceDNSCommands(id string, servers []string) string {
+	key := serviceDNSKey(id)
+	cmds := []string{"d.init", "get " + key}
+	if len(servers) > 0 {
+		cmds = append(cmds, "d.add ServerAddresses * "+strings.Join(servers, " "))
+	} else {
+		cmds = append(cmds, "d.remove ServerAddresses")
+	}
+	cmds = append(cmds, "set "+key)
+	return strings.Join(cmds, "\n")
+}
+
+func scutil(cmds string) (string, error) {
+	cmd := exec.Command("scutil")
+	cmd.Stdin = strings.NewReader(cmds + "\nquit\n")
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("scutil: %v: %s", err, bytes.TrimSpace(b))
+	}
+	return string(b), nil
+}
+
+// legacyResidue returns the network services configured with a loopback DNS
+// server in the network preferences.
+func legacyResidue() []string {
+	netServices, err := listNetworkServices()
+	if err != nil {
+		return nil
+	}
+	var residue []string
+	for _, svc := range netServices {
+		b, err := exec.Command("networksetup", "-getdnsservers", svc).Output()
+		if err != nil {
+			continue
+		}
+		for _, dns := range bytes.Fields(b) {
+			if ip := net.ParseIP(string(dns)); ip != nil && ip.IsLoopback() {
+				residue = append(residue, svc)
+				break
+			}
+		}
+	}
+	return residue
 }
 
 func setDNS(networkService, dns string) error {
-	b, err := exec.Command("networksetup", "-setdnsservers", networkService, dns).Output()
+	b, err := exec.Command("networksetup", "-setdnsservers", networkService, dns).CombinedOutput()
 	if err != nil {
-		return errors.New(string(b))
+		return fmt.Errorf("networksetup: %v: %s", err, bytes.TrimSpace(b))
 	}
 	return nil
 }
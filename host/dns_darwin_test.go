@@ -0,0 +1,68 @@
+package host
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseServiceIDs(t *testing.T) {
+	out := `  subKey [0] = Setup:/Network/Service/0B9D2C25-4AF4-4F32-9D32-45D0B1D1F0C1/IPv4
+  subKey [1] = Setup:/Network/Service/8A5E3F1B-3C2D-4E5F-A6B7-C8D9E0F1A2B3/IPv4
+`
+	want := []string{"0B9D2C25-4AF4-4F32-9D32-45D0B1D1F0C1", "8A5E3F1B-3C2D-4E5F-A6B7-C8D9E0F1A2B3"}
+	if got := parseServiceIDs(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseServiceIDs() = %q, want %q", got, want)
+	}
+	if got := parseServiceIDs("  subKey [0] = Setup:/Network/Global/IPv4\n"); got != nil {
+		t.Errorf("parseServiceIDs() = %q, want none", got)
+	}
+}
+
+func Test_parseServerAddresses(t *testing.T) {
+	tests := []struct {
+		name       string
+		out        string
+		want       []string
+		wantExists bool
+	}{
+		{"no key", "  No such key\n", nil, false},
+		{"search domains only", `<dictionary> {
+  SearchDomains : <array> {
+    0 : lan
+  }
+}
+`, nil, true},
+		{"servers", `<dictionary> {
+  SearchDomains : <array> {
+    0 : lan
+  }
+  ServerAddresses : <array> {
+    0 : 192.168.1.1
+    1 : 2001:db8::1
+  }
+}
+`, []string{"192.168.1.1", "2001:db8::1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, exists, err := parseServerAddresses(tt.out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || exists != tt.wantExists {
+				t.Errorf("parseServerAddresses() = %q, %v, want %q, %v", got, exists, tt.want, tt.wantExists)
+			}
+		})
+	}
+}
+
+func Test_setServiceDNSCommands(t *testing.T) {
+	want := "d.init\nget Setup:/Network/Service/ID/DNS\nd.add ServerAddresses * 127.0.0.1 ::1\nset Setup:/Network/Service/ID/DNS"
+	if got := setServiceDNSCommands("ID", []string{"127.0.0.1", "::1"}); got != want {
+		t.Errorf("setServiceDNSCommands() = %q, want %q", got, want)
+	}
+	want = "d.init\nget Setup:/Network/Service/ID/DNS\nd.remove ServerAddresses\nset Setup:/Network/Service/ID/DNS"
+	if got := setServiceDNSCommands("ID", nil); got != want {
+		t.Errorf("setServiceDNSCommands() = %q, want %q", got, want)
+	}
+}
@@ -52,24 +52,39 @@ func DNS() []string {
 	)
 }
 
+// SetDNS makes the system use dns as its resolver using the mechanism in
+// charge of the resolver configuration (systemd-resolved, NetworkManager,
+// resolvconf or a plain resolv.conf).
 func SetDNS(dns string) error {
-	if err := setupResolvConf(dns); err != nil {
-		return fmt.Errorf("setup resolv.conf: %v", err)
-	}
-	if err := disableNetworkManagerResolver(); err != nil {
-		return fmt.Errorf("NetworkManager resolver management: %v", err)
+	for _, m := range detectDNSManagers() {
+		if err := m.set(dns); err != nil {
+			return fmt.Errorf("%s: %v", m.name, err)
+		}
 	}
 	return nil
 }
 
+// ResetDNS reverts the changes made by SetDNS.
 func ResetDNS() error {
-	if err := os.Rename(resolvBackupFile, resolvFile); err != nil {
-		return fmt.Errorf("restore resolv.conf: %v", err)
+	var err error
+	for _, m := range dnsManagers {
+		if len(m.residue()) == 0 {
+			continue
+		}
+		if e := m.reset(); e != nil && err == nil {
+			err = fmt.Errorf("%s: %v", m.name, e)
+		}
 	}
-	if err := restoreNetworkManagerResolver(); err != nil {
-		return fmt.Errorf("NetworkManager resolver management: %v", err)
+	return err
+}
+
+// DNSResidue returns the changes made by SetDNS still present on the system.
+func DNSResidue() []string {
+	var residue []string
+	for _, m := range dnsManagers {
+		residue = append(residue, m.residue()...)
 	}
-	return nil
+	return residue
 }
 
 func nmcliGet() (dns []string) {
@@ -160,36 +175,3 @@ func gatewayDNSCommon(file string, size int) (dns []string) {
 	}
 	return dns
 }
-
-var networkManagerFile = "/etc/NetworkManager/conf.d/nextdns.conf"
-
-func disableNetworkManagerResolver() error {
-	confDir := filepath.Dir(networkManagerFile)
-	if st, err := os.Stat(confDir); err != nil {
-		if os.IsNotExist(err) {
-			// NetworkManager does not seem to exist on this system, just ignore.
-			return nil
-		}
-		return err
-	} else if !st.IsDir() {
-		return fmt.Errorf("%s: is not a directory", confDir)
-	}
-
-	// Disable resolv.conf management by NetworkManager
-	if err := ioutil.WriteFile(networkManagerFile, []byte("[main]\ndns=none\n"), 0644); err != nil {
-		return err
-	}
-
-	// Restart network manager
-	return exec.Command("systemctl", "reload", "NetworkManager").Run()
-}
-
-func restoreNetworkManagerResolver() error {
-	if _, err := os.Stat(networkManagerFile); err != nil {
-		return nil
-	}
-	if err := os.Remove(networkManagerFile); err != nil {
-		return err
-	}
-	return exec.Command("systemctl", "reload", "NetworkManager").Run()
-}
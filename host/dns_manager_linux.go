@@ -0,0 +1,238 @@
+package host
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dnsManager is a mechanism used to make the system use a given resolver.
+type dnsManager struct {
+	name string
+
+	// set makes the system use dns.
+	set func(dns string) error
+
+	// reset reverts the changes made by set.
+	reset func() error
+
+	// residue returns the changes made by set still present on the system.
+	residue func() []string
+}
+
+var (
+	resolvedManager = dnsManager{
+		name:    "systemd-resolved",
+		set:     setupResolved,
+		reset:   restoreResolved,
+		residue: fileResidue(resolvedFile),
+	}
+	networkManagerManager = dnsManager{
+		name:    "NetworkManager",
+		set:     disableNetworkManagerResolver,
+		reset:   restoreNetworkManagerResolver,
+		residue: fileResidue(networkManagerFile),
+	}
+	resolvconfManager = dnsManager{
+		name:    "resolvconf",
+		set:     setupResolvconf,
+		reset:   restoreResolvconf,
+		residue: fileResidue(resolvconfInterfaceFiles...),
+	}
+	resolvConfManager = dnsManager{
+		name:    "resolv.conf",
+		set:     setupResolvConf,
+		reset:   restoreResolvConf,
+		residue: resolvConfResidue,
+	}
+)
+
+// dnsManagers lists all the managers in the order they must be reset.
+var dnsManagers = []dnsManager{
+	resolvedManager,
+	networkManagerManager,
+	resolvconfManager,
+	resolvConfManager,
+}
+
+// detectDNSManagers returns the managers to use to configure the system
+// resolver depending on which software is in charge of resolv.conf.
+func detectDNSManagers() []dnsManager {
+	link, _ := os.Readlink(resolvFile)
+	content, _ := ioutil.ReadFile(resolvFile)
+	return selectDNSManagers(dnsManagerHints{
+		resolvLink:        link,
+		resolvContent:     content,
+		resolvedResidue:   len(resolvedManager.residue()) > 0,
+		resolvedRunning:   isDir("/run/systemd/resolve"),
+		resolvconfResidue: len(resolvconfManager.residue()) > 0,
+		resolvconfCommand: hasCommand("resolvconf"),
+		networkManager:    isDir(filepath.Dir(networkManagerFile)) && isDir("/run/NetworkManager"),
+	})
+}
+
+// dnsManagerHints describes the state of the system used to select the dns
+// managers.
+type dnsManagerHints struct {
+	resolvLink        string // target of resolv.conf if a symlink
+	resolvContent     []byte // content of resolv.conf
+	resolvedResidue   bool   // changes made by resolvedManager are present
+	resolvedRunning   bool   // systemd-resolved runtime directory exists
+	resolvconfResidue bool   // changes made by resolvconfManager are present
+	resolvconfCommand bool   // the resolvconf command is installed
+	networkManager    bool   // NetworkManager is installed and running
+}
+
+func selectDNSManagers(h dnsManagerHints) []dnsManager {
+	switch {
+	case h.resolvedResidue,
+		strings.Contains(h.resolvLink, "systemd/resolv"),
+		bytes.Contains(h.resolvContent, []byte("systemd-resolved")) && h.resolvedRunning:
+		// With the stub listener disabled, resolv.conf must be replaced as it
+		// would point to the stub listener address.
+		return []dnsManager{resolvedManager, resolvConfManager}
+	case h.resolvconfResidue,
+		(strings.Contains(h.resolvLink, "resolvconf") || bytes.Contains(h.resolvContent, []byte("resolvconf"))) &&
+			h.resolvconfCommand:
+		return []dnsManager{resolvconfManager}
+	case h.networkManager:
+		return []dnsManager{networkManagerManager, resolvConfManager}
+	}
+	return []dnsManager{resolvConfManager}
+}
+
+func fileResidue(files ...string) func() []string {
+	return func() []string {
+		var residue []string
+		for _, file := range files {
+			if _, err := os.Stat(file); err == nil {
+				residue = append(residue, file)
+			}
+		}
+		return residue
+	}
+}
+
+func isDir(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.IsDir()
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+func restoreResolvConf() error {
+	_ = os.Remove(resolvTmpFile)
+	if _, err := os.Lstat(resolvBackupFile); os.IsNotExist(err) {
+		return nil
+	}
+	return os.Rename(resolvBackupFile, resolvFile)
+}
+
+var resolvedFile = "/etc/systemd/resolved.conf.d/nextdns.conf"
+
+// setupResolved configures systemd-resolved to forward all queries to dns and
+// disables its stub listener so it does not conflict with our listener.
+func setupResolved(dns string) error {
+	if err := os.MkdirAll(filepath.Dir(resolvedFile), 0755); err != nil {
+		return err
+	}
+	conf := fmt.Sprintf("# This file is managed by nextdns.\n[Resolve]\nDNS=%s\nDomains=~.\nDNSStubListener=no\n", dns)
+	if b, err := ioutil.ReadFile(resolvedFile); err == nil && string(b) == conf {
+		return nil
+	}
+	if err := ioutil.WriteFile(resolvedFile, []byte(conf), 0644); err != nil {
+		return err
+	}
+	return exec.Command("systemctl", "restart", "systemd-resolved").Run()
+}
+
+func restoreResolved() error {
+	if err := os.Remove(resolvedFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return exec.Command("systemctl", "restart", "systemd-resolved").Run()
+}
+
+var networkManagerFile = "/etc/NetworkManager/conf.d/nextdns.conf"
+
+func disableNetworkManagerResolver(string) error {
+	confDir := filepath.Dir(networkManagerFile)
+	if st, err := os.Stat(confDir); err != nil {
+		if os.IsNotExist(err) {
+			// NetworkManager does not seem to exist on this system, just ignore.
+			return nil
+		}
+		return err
+	} else if !st.IsDir() {
+		return fmt.Errorf("%s: is not a directory", confDir)
+	}
+
+	// Disable resolv.conf management by NetworkManager
+	if err := ioutil.WriteFile(networkManagerFile, []byte("[main]\ndns=none\n"), 0644); err != nil {
+		return err
+	}
+
+	// Restart network manager
+	return exec.Command("systemctl", "reload", "NetworkManager").Run()
+}
+
+func restoreNetworkManagerResolver() error {
+	if _, err := os.Stat(networkManagerFile); err != nil {
+		return nil
+	}
+	if err := os.Remove(networkManagerFile); err != nil {
+		return err
+	}
+	return exec.Command("systemctl", "reload", "NetworkManager").Run()
+}
+
+// resolvconfInterface is the interface name used to register our nameserver
+// with resolvconf. Interfaces prefixed by lo are listed first by resolvconf
+// and, with Debian resolvconf, nameservers listed after a loopback address
+// are removed.
+const resolvconfInterface = "lo.nextdns"
+
+// resolvconfInterfaceFiles are the state files created by Debian resolvconf
+// and openresolv for our interface.
+var resolvconfInterfaceFiles = []string{
+	"/run/resolvconf/interface/" + resolvconfInterface,
+	"/run/resolvconf/interfaces/" + resolvconfInterface,
+}
+
+// isOpenresolv returns true if resolvconf is openresolv.
+func isOpenresolv() bool {
+	b, _ := exec.Command("resolvconf", "--version").CombinedOutput()
+	return bytes.Contains(b, []byte("openresolv"))
+}
+
+func setupResolvconf(dns string) error {
+	args := []string{"-a", resolvconfInterface}
+	if isOpenresolv() {
+		// Make our interface exclusive so other nameservers are ignored.
+		args = append([]string{"-x"}, args...)
+	}
+	cmd := exec.Command("resolvconf", args...)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("nameserver %s\n", dns))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func restoreResolvconf() error {
+	args := []string{"-d", resolvconfInterface}
+	if isOpenresolv() {
+		args = append(args, "-f")
+	}
+	if out, err := exec.Command("resolvconf", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
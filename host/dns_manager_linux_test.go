@@ -0,0 +1,65 @@
+package host
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_selectDNSManagers(t *testing.T) {
+	tests := []struct {
+		name  string
+		hints dnsManagerHints
+		want  []string
+	}{
+		{"plain", dnsManagerHints{
+			resolvContent: []byte("nameserver 192.168.1.1\n"),
+		}, []string{"resolv.conf"}},
+		{"resolved symlink", dnsManagerHints{
+			resolvLink: "../run/systemd/resolve/stub-resolv.conf",
+		}, []string{"systemd-resolved", "resolv.conf"}},
+		{"resolved content", dnsManagerHints{
+			resolvContent:   []byte("# This file is managed by man:systemd-resolved(8). Do not edit.\nnameserver 127.0.0.53\n"),
+			resolvedRunning: true,
+		}, []string{"systemd-resolved", "resolv.conf"}},
+		{"resolved content not running", dnsManagerHints{
+			resolvContent: []byte("# This file is managed by man:systemd-resolved(8). Do not edit.\nnameserver 127.0.0.53\n"),
+		}, []string{"resolv.conf"}},
+		{"resolved residue", dnsManagerHints{
+			resolvContent:   []byte("# This file is managed by nextdns.\nnameserver 127.0.0.1\n"),
+			resolvedResidue: true,
+		}, []string{"systemd-resolved", "resolv.conf"}},
+		{"resolvconf", dnsManagerHints{
+			resolvLink:        "/run/resolvconf/resolv.conf",
+			resolvconfCommand: true,
+		}, []string{"resolvconf"}},
+		{"resolvconf content", dnsManagerHints{
+			resolvContent:     []byte("# Generated by resolvconf\nnameserver 192.168.1.1\n"),
+			resolvconfCommand: true,
+		}, []string{"resolvconf"}},
+		{"resolvconf not installed", dnsManagerHints{
+			resolvContent: []byte("# Generated by resolvconf\nnameserver 192.168.1.1\n"),
+		}, []string{"resolv.conf"}},
+		{"resolvconf residue", dnsManagerHints{
+			resolvconfResidue: true,
+		}, []string{"resolvconf"}},
+		{"NetworkManager", dnsManagerHints{
+			resolvContent:  []byte("# Generated by NetworkManager\nnameserver 192.168.1.1\n"),
+			networkManager: true,
+		}, []string{"NetworkManager", "resolv.conf"}},
+		{"resolved over NetworkManager", dnsManagerHints{
+			resolvLink:     "/run/systemd/resolve/stub-resolv.conf",
+			networkManager: true,
+		}, []string{"systemd-resolved", "resolv.conf"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, m := range selectDNSManagers(tt.hints) {
+				got = append(got, m.name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectDNSManagers() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,4 +1,4 @@
-// +build !darwin,!linux,!freebsd,!openbsd,!netbsd,!dragonfly
+// +build !darwin,!linux,!freebsd,!openbsd,!netbsd,!dragonfly,!windows
 
 package host
 
@@ -15,3 +15,7 @@ func SetDNS(dns string) error {
 func ResetDNS() error {
 	return errors.New("platform not supported")
 }
+
+func DNSResidue() []string {
+	return nil
+}
@@ -77,3 +77,29 @@ func writeTempResolvConf(tmpPath, dns string) error {
 	}
 	return nil
 }
+
+// resolvConfResidue returns the resolv.conf changes made by setupResolvConf
+// still present on the system.
+func resolvConfResidue() []string {
+	return resolvConfResidueIn(resolvFile, resolvBackupFile, resolvTmpFile)
+}
+
+func resolvConfResidueIn(resolvFile, resolvBackupFile, resolvTmpFile string) []string {
+	var residue []string
+	for _, file := range []string{resolvBackupFile, resolvTmpFile} {
+		if _, err := os.Stat(file); err == nil {
+			residue = append(residue, file)
+		}
+	}
+	if f, err := os.Open(resolvFile); err == nil {
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if strings.Contains(s.Text(), "managed by nextdns") {
+				residue = append(residue, resolvFile)
+				break
+			}
+		}
+	}
+	return residue
+}
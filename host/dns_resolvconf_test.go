@@ -0,0 +1,50 @@
+// +build linux freebsd openbsd netbsd dragonfly
+
+package host
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_resolvConfResidueIn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolvconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	resolv := filepath.Join(dir, "resolv.conf")
+	backup := resolv + ".nextdns-bak"
+	tmp := resolv + ".nextdns-tmp"
+
+	if err := ioutil.WriteFile(resolv, []byte("nameserver 192.168.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r := resolvConfResidueIn(resolv, backup, tmp); len(r) != 0 {
+		t.Errorf("residue = %v, want none", r)
+	}
+
+	// Leftovers of an interrupted setupResolvConf.
+	if err := os.Rename(resolv, backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTempResolvConf(tmp, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{backup, tmp}
+	if r := resolvConfResidueIn(resolv, backup, tmp); !reflect.DeepEqual(r, want) {
+		t.Errorf("residue = %v, want %v", r, want)
+	}
+
+	// Completed setupResolvConf.
+	if err := os.Rename(tmp, resolv); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{backup, resolv}
+	if r := resolvConfResidueIn(resolv, backup, tmp); !reflect.DeepEqual(r, want) {
+		t.Errorf("residue = %v, want %v", r, want)
+	}
+}
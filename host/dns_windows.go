@@ -0,0 +1,232 @@
+package host
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// dnsBackup stores the static DNS configuration of an adapter before SetDNS
+// changed it. An empty Servers list means the adapter was using DHCP.
+type dnsBackup struct {
+	Adapter string   `json:"adapter"`
+	GUID    string   `json:"guid"`
+	Family  string   `json:"family"`
+	Servers []string `json:"servers"`
+}
+
+func dnsBackupFile() string {
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		dir = `C:\ProgramData`
+	}
+	return filepath.Join(dir, "NextDNS", "dns.backup")
+}
+
+func DNS() (dns []string) {
+	as, err := adapters()
+	if err != nil {
+		return nil
+	}
+	for _, a := range as {
+		for s := a.FirstDnsServerAddress; s != nil; s = s.Next {
+			if ip := s.Address.IP(); ip != nil && !ip.IsLoopback() {
+				dns = appendUniq(dns, ip.String())
+			}
+		}
+	}
+	return dns
+}
+
+// SetDNS sets dns as the only DNS server of all the active network adapters.
+// The previous configuration is saved so it can be restored by ResetDNS.
+func SetDNS(dns string) error {
+	ip := net.ParseIP(dns)
+	if ip == nil {
+		return fmt.Errorf("%s: invalid IP", dns)
+	}
+	family := "ipv4"
+	if ip.To4() == nil {
+		family = "ipv6"
+	}
+	as, err := adapters()
+	if err != nil {
+		return err
+	}
+
+	// Make sure we are not already activated.
+	var backups []dnsBackup
+	if _, err := os.Stat(dnsBackupFile()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%s: %v", dnsBackupFile(), err)
+	} else if os.IsNotExist(err) {
+		for _, a := range as {
+			guid := bytePtrToString(a.AdapterName)
+			backups = append(backups, dnsBackup{
+				Adapter: utf16PtrToString(a.FriendlyName),
+				GUID:    guid,
+				Family:  family,
+				Servers: staticDNS(family, guid),
+			})
+		}
+		if err := writeDNSBackup(backups); err != nil {
+			return fmt.Errorf("backup: %v", err)
+		}
+	}
+
+	for _, a := range as {
+		name := utf16PtrToString(a.FriendlyName)
+		if err := netsh("interface", family, "set", "dnsservers",
+			"name="+name, "source=static", "address="+dns, "register=none", "validate=no"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetDNS restores the adapters configuration saved by SetDNS.
+func ResetDNS() error {
+	backups, err := readDNSBackup()
+	if err != nil {
+		return fmt.Errorf("restore: %v", err)
+	}
+	for _, bk := range backups {
+		for _, args := range restoreDNSCommands(bk) {
+			if err = netsh(args...); err != nil {
+				break
+			}
+		}
+		if err != nil && !strings.Contains(err.Error(), "not found") {
+			return fmt.Errorf("restore %s: %v", bk.Adapter, err)
+		}
+	}
+	return os.Remove(dnsBackupFile())
+}
+
+// restoreDNSCommands returns the netsh commands restoring the configuration
+// saved in bk.
+func restoreDNSCommands(bk dnsBackup) [][]string {
+	if len(bk.Servers) == 0 {
+		return [][]string{{"interface", bk.Family, "set", "dnsservers",
+			"name=" + bk.Adapter, "source=dhcp"}}
+	}
+	cmds := [][]string{{"interface", bk.Family, "set", "dnsservers",
+		"name=" + bk.Adapter, "source=static", "address=" + bk.Servers[0], "register=primary", "validate=no"}}
+	for i, s := range bk.Servers[1:] {
+		cmds = append(cmds, []string{"interface", bk.Family, "add", "dnsservers",
+			"name=" + bk.Adapter, "address=" + s, fmt.Sprintf("index=%d", i+2), "validate=no"})
+	}
+	return cmds
+}
+
+// DNSResidue returns the changes made by SetDNS still present on the system.
+func DNSResidue() []string {
+	if _, err := os.Stat(dnsBackupFile()); err == nil {
+		return []string{dnsBackupFile()}
+	}
+	return nil
+}
+
+func readDNSBackup() ([]dnsBackup, error) {
+	b, err := ioutil.ReadFile(dnsBackupFile())
+	if err != nil {
+		return nil, err
+	}
+	var backups []dnsBackup
+	err = json.Unmarshal(b, &backups)
+	return backups, err
+}
+
+func writeDNSBackup(backups []dnsBackup) error {
+	b, err := json.Marshal(backups)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dnsBackupFile()), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dnsBackupFile(), b, 0644)
+}
+
+// staticDNS returns the statically configured DNS servers of the adapter
+// identified by guid or nil if the adapter gets its DNS servers from DHCP.
+func staticDNS(family, guid string) []string {
+	path := `SYSTEM\CurrentControlSet\Services\Tcpip\Parameters\Interfaces\` + guid
+	if family == "ipv6" {
+		path = `SYSTEM\CurrentControlSet\Services\Tcpip6\Parameters\Interfaces\` + guid
+	}
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err != nil {
+		return nil
+	}
+	defer k.Close()
+	v, _, err := k.GetStringValue("NameServer")
+	if err != nil {
+		return nil
+	}
+	return parseNameServer(v)
+}
+
+// parseNameServer parses the comma or space separated list of servers of the
+// NameServer registry value.
+func parseNameServer(v string) []string {
+	return strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// adapters returns the active non-loopback network adapters.
+func adapters() ([]*windows.IpAdapterAddresses, error) {
+	size := uint32(15000)
+	var b []byte
+	for i := 0; i < 3; i++ {
+		b = make([]byte, size)
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, windows.GAA_FLAG_INCLUDE_PREFIX, 0,
+			(*windows.IpAdapterAddresses)(unsafe.Pointer(&b[0])), &size)
+		if err == nil {
+			break
+		}
+		if err != windows.ERROR_BUFFER_OVERFLOW || i == 2 {
+			return nil, fmt.Errorf("GetAdaptersAddresses: %v", err)
+		}
+	}
+	var as []*windows.IpAdapterAddresses
+	for a := (*windows.IpAdapterAddresses)(unsafe.Pointer(&b[0])); a != nil; a = a.Next {
+		if a.OperStatus != windows.IfOperStatusUp || a.IfType == windows.IF_TYPE_SOFTWARE_LOOPBACK {
+			continue
+		}
+		as = append(as, a)
+	}
+	if len(as) == 0 {
+		return nil, errors.New("no active network adapter")
+	}
+	return as, nil
+}
+
+func bytePtrToString(p *byte) string {
+	if p == nil {
+		return ""
+	}
+	var b []byte
+	for ; *p != 0; p = (*byte)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + 1)) {
+		b = append(b, *p)
+	}
+	return string(b)
+}
+
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	var s []uint16
+	for ; *p != 0; p = (*uint16)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + 2)) {
+		s = append(s, *p)
+	}
+	return windows.UTF16ToString(s)
+}
@@ -0,0 +1,78 @@
+package host
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_parseNameServer(t *testing.T) {
+	tests := []struct {
+		v    string
+		want []string
+	}{
+		{"", nil},
+		{"192.168.1.1", []string{"192.168.1.1"}},
+		{"192.168.1.1,8.8.8.8", []string{"192.168.1.1", "8.8.8.8"}},
+		{"192.168.1.1 8.8.8.8", []string{"192.168.1.1", "8.8.8.8"}},
+		{"2001:db8::1, 2001:db8::2", []string{"2001:db8::1", "2001:db8::2"}},
+	}
+	for _, tt := range tests {
+		if got := parseNameServer(tt.v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseNameServer(%q) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func Test_restoreDNSCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		bk   dnsBackup
+		want [][]string
+	}{
+		{"dhcp", dnsBackup{Adapter: "Ethernet", Family: "ipv4"}, [][]string{
+			{"interface", "ipv4", "set", "dnsservers", "name=Ethernet", "source=dhcp"},
+		}},
+		{"static", dnsBackup{Adapter: "Wi-Fi", Family: "ipv6", Servers: []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"}}, [][]string{
+			{"interface", "ipv6", "set", "dnsservers", "name=Wi-Fi", "source=static", "address=2001:db8::1", "register=primary", "validate=no"},
+			{"interface", "ipv6", "add", "dnsservers", "name=Wi-Fi", "address=2001:db8::2", "index=2", "validate=no"},
+			{"interface", "ipv6", "add", "dnsservers", "name=Wi-Fi", "address=2001:db8::3", "index=3", "validate=no"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := restoreDNSCommands(tt.bk); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restoreDNSCommands() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dnsBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("ProgramData", os.Getenv("ProgramData"))
+	os.Setenv("ProgramData", dir)
+
+	backups := []dnsBackup{
+		{Adapter: "Ethernet", GUID: "{4D36E972-E325-11CE-BFC1-08002BE10318}", Family: "ipv4"},
+		{Adapter: "Wi-Fi", GUID: "{4D36E972-E325-11CE-BFC1-08002BE10319}", Family: "ipv4", Servers: []string{"192.168.1.1"}},
+	}
+	if err := writeDNSBackup(backups); err != nil {
+		t.Fatal(err)
+	}
+	if r := DNSResidue(); !reflect.DeepEqual(r, []string{dnsBackupFile()}) {
+		t.Errorf("DNSResidue() = %q, want the backup file", r)
+	}
+	got, err := readDNSBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, backups) {
+		t.Errorf("readDNSBackup() = %+v, want %+v", got, backups)
+	}
+}
@@ -0,0 +1,13 @@
+// +build !windows
+
+package host
+
+// SetupFirewall is a no-op on platforms where the firewall is not managed.
+func SetupFirewall(udpPorts, tcpPorts []string) error {
+	return nil
+}
+
+// ResetFirewall is a no-op on platforms where the firewall is not managed.
+func ResetFirewall() error {
+	return nil
+}
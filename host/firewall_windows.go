@@ -0,0 +1,76 @@
+package host
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// firewallRules are the names of the inbound rules created for the UDP and
+// TCP ports the proxy listens on. They are scoped to the private and domain
+// profiles and to the local subnet so the proxy is never exposed when the host
+// is connected to a public network.
+var firewallRules = []struct {
+	name     string
+	protocol string
+}{
+	{"NextDNS (UDP-In)", "UDP"},
+	{"NextDNS (TCP-In)", "TCP"},
+}
+
+// SetupFirewall creates the Windows Defender Firewall rules allowing inbound
+// DNS traffic from private networks on the given UDP and TCP ports. Existing
+// rules are replaced.
+func SetupFirewall(udpPorts, tcpPorts []string) error {
+	ep, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	for _, r := range firewallRules {
+		_ = netsh("advfirewall", "firewall", "delete", "rule", "name="+r.name)
+		ports := udpPorts
+		if r.protocol == "TCP" {
+			ports = tcpPorts
+		}
+		if len(ports) == 0 {
+			continue
+		}
+		if err := netsh("advfirewall", "firewall", "add", "rule",
+			"name="+r.name,
+			"dir=in",
+			"action=allow",
+			"program="+ep,
+			"protocol="+r.protocol,
+			"localport="+strings.Join(ports, ","),
+			"profile=private,domain",
+			"remoteip=localsubnet"); err != nil {
+			return fmt.Errorf("firewall rule %s: %v", r.name, err)
+		}
+	}
+	return nil
+}
+
+// ResetFirewall removes the rules created by SetupFirewall.
+func ResetFirewall() error {
+	var err error
+	for _, r := range firewallRules {
+		if e := netsh("advfirewall", "firewall", "delete", "rule", "name="+r.name); e != nil &&
+			!strings.Contains(e.Error(), "No rules match") {
+			err = fmt.Errorf("firewall rule %s: %v", r.name, e)
+		}
+	}
+	return err
+}
+
+func netsh(args ...string) error {
+	var out bytes.Buffer
+	cmd := exec.Command("netsh", args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("netsh %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// splitExclude parses the exclusion list of InterceptDNS into IPv4 and IPv6
+// networks.
+func splitExclude(exclude []string) (v4, v6 []string, err error) {
+	for _, e := range exclude {
+		n := e
+		if strings.IndexByte(e, '/') == -1 {
+			if ip := net.ParseIP(e); ip != nil && ip.To4() != nil {
+				n += "/32"
+			} else {
+				n += "/128"
+			}
+		}
+		ip, ipnet, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: invalid exclusion", e)
+		}
+		if ip.To4() != nil {
+			v4 = append(v4, ipnet.String())
+		} else {
+			v6 = append(v6, ipnet.String())
+		}
+	}
+	return v4, v6, nil
+}
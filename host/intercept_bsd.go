@@ -0,0 +1,56 @@
+// +build darwin freebsd openbsd netbsd dragonfly
+
+package host
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// interceptAnchor is the pf anchor holding the redirect rules. It must be
+// referenced by a rdr-anchor rule of the main ruleset.
+const interceptAnchor = "nextdns"
+
+// InterceptDNS redirects all DNS queries (port 53) received on ifaces to the
+// local port, whatever their destination, except for clients in exclude (IPs
+// or CIDRs). Rules are loaded in the nextdns pf anchor.
+func InterceptDNS(port int, ifaces, exclude []string) error {
+	v4, v6, err := splitExclude(exclude)
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("pfctl", "-s", "nat").Output()
+	if err != nil {
+		return fmt.Errorf("pfctl: %v", err)
+	}
+	if !bytes.Contains(out, []byte(`rdr-anchor "`+interceptAnchor+`"`)) {
+		return errors.New(`pf: missing rdr-anchor "` + interceptAnchor + `" in the main ruleset`)
+	}
+	cmd := exec.Command("pfctl", "-a", interceptAnchor, "-f", "-")
+	cmd.Stdin = strings.NewReader(pfInterceptRules(port, ifaces, append(v4, v6...)))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("pfctl: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func pfInterceptRules(port int, ifaces, exclude []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "table <nextdns_exclude> { %s }\n", strings.Join(exclude, " "))
+	for _, r := range []struct{ family, to string }{{"inet", "127.0.0.1"}, {"inet6", "::1"}} {
+		fmt.Fprintf(&b, "rdr pass on { %s } %s proto { udp tcp } from ! <nextdns_exclude> to any port 53 -> %s port %d\n",
+			strings.Join(ifaces, " "), r.family, r.to, port)
+	}
+	return b.String()
+}
+
+// ResetInterceptDNS removes the rules added by InterceptDNS.
+func ResetInterceptDNS() error {
+	if out, err := exec.Command("pfctl", "-a", interceptAnchor, "-F", "all").CombinedOutput(); err != nil {
+		return fmt.Errorf("pfctl: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+// +build darwin freebsd openbsd netbsd dragonfly
+
+package host
+
+import "testing"
+
+func Test_pfInterceptRules(t *testing.T) {
+	want := "table <nextdns_exclude> { 192.168.1.10/32 2001:db8::1/128 }\n" +
+		"rdr pass on { em1 em2 } inet proto { udp tcp } from ! <nextdns_exclude> to any port 53 -> 127.0.0.1 port 5353\n" +
+		"rdr pass on { em1 em2 } inet6 proto { udp tcp } from ! <nextdns_exclude> to any port 53 -> ::1 port 5353\n"
+	if got := pfInterceptRules(5353, []string{"em1", "em2"}, []string{"192.168.1.10/32", "2001:db8::1/128"}); got != want {
+		t.Errorf("pfInterceptRules() =\n%s\nwant\n%s", got, want)
+	}
+}
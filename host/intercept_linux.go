@@ -0,0 +1,117 @@
+package host
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const interceptChain = "NEXTDNS_INTERCEPT"
+
+// InterceptDNS redirects all DNS queries (port 53) received on ifaces to the
+// local port, whatever their destination, except for clients in exclude (IPs
+// or CIDRs). It uses nftables when available, iptables otherwise.
+func InterceptDNS(port int, ifaces, exclude []string) error {
+	v4, v6, err := splitExclude(exclude)
+	if err != nil {
+		return err
+	}
+	if hasCommand("nft") {
+		if err := nft(nftInterceptRules("ip", port, ifaces, v4)); err != nil {
+			return err
+		}
+		// IPv6 NAT may not be supported by the kernel.
+		_ = nft(nftInterceptRules("ip6", port, ifaces, v6))
+		return nil
+	}
+	if err := iptablesIntercept("iptables", port, ifaces, v4); err != nil {
+		return err
+	}
+	_ = iptablesIntercept("ip6tables", port, ifaces, v6)
+	return nil
+}
+
+// ResetInterceptDNS removes the rules added by InterceptDNS.
+func ResetInterceptDNS() error {
+	if hasCommand("nft") {
+		for _, family := range []string{"ip", "ip6"} {
+			// Creating the table first makes the deletion idempotent.
+			_ = nft(fmt.Sprintf("table %[1]s nextdns\ndelete table %[1]s nextdns\n", family))
+		}
+	}
+	for _, cmd := range []string{"iptables", "ip6tables"} {
+		if !hasCommand(cmd) {
+			continue
+		}
+		_ = exec.Command(cmd, "-t", "nat", "-D", "PREROUTING", "-j", interceptChain).Run()
+		_ = exec.Command(cmd, "-t", "nat", "-F", interceptChain).Run()
+		_ = exec.Command(cmd, "-t", "nat", "-X", interceptChain).Run()
+	}
+	return nil
+}
+
+func nftInterceptRules(family string, port int, ifaces, exclude []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "table %[1]s nextdns\ndelete table %[1]s nextdns\n", family)
+	fmt.Fprintf(&b, "table %s nextdns {\n", family)
+	b.WriteString("\tchain prerouting {\n")
+	b.WriteString("\t\ttype nat hook prerouting priority -100; policy accept;\n")
+	if len(exclude) > 0 {
+		fmt.Fprintf(&b, "\t\t%s saddr { %s } return\n", family, strings.Join(exclude, ", "))
+	}
+	quoted := make([]string, 0, len(ifaces))
+	for _, iface := range ifaces {
+		quoted = append(quoted, strconv.Quote(iface))
+	}
+	for _, proto := range []string{"udp", "tcp"} {
+		fmt.Fprintf(&b, "\t\tiifname { %s } %s dport 53 redirect to :%d\n", strings.Join(quoted, ", "), proto, port)
+	}
+	b.WriteString("\t}\n}\n")
+	return b.String()
+}
+
+func nft(rules string) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(rules)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("nft: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func iptablesIntercept(cmd string, port int, ifaces, exclude []string) error {
+	for _, args := range iptablesInterceptCommands(port, ifaces, exclude) {
+		out, err := exec.Command(cmd, append([]string{"-t", "nat"}, args...)...).CombinedOutput()
+		if err != nil && args[0] != "-N" && args[0] != "-D" {
+			return fmt.Errorf("%s %s: %v: %s", cmd, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// iptablesInterceptCommands returns the arguments of the nat table commands
+// adding the intercept rules. Errors of commands creating the chain (-N) or
+// removing the previous jump rule (-D) must be ignored as they may already
+// exist or not.
+func iptablesInterceptCommands(port int, ifaces, exclude []string) [][]string {
+	cmds := [][]string{
+		{"-N", interceptChain},
+		{"-F", interceptChain},
+	}
+	for _, n := range exclude {
+		cmds = append(cmds, []string{"-A", interceptChain, "-s", n, "-j", "RETURN"})
+	}
+	for _, iface := range ifaces {
+		for _, proto := range []string{"udp", "tcp"} {
+			cmds = append(cmds, []string{"-A", interceptChain, "-i", iface, "-p", proto, "--dport", "53",
+				"-j", "REDIRECT", "--to-ports", strconv.Itoa(port)})
+		}
+	}
+	return append(cmds,
+		[]string{"-D", "PREROUTING", "-j", interceptChain},
+		[]string{"-I", "PREROUTING", "-j", interceptChain})
+}
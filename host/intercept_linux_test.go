@@ -0,0 +1,49 @@
+package host
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_nftInterceptRules(t *testing.T) {
+	want := "table ip nextdns\n" +
+		"delete table ip nextdns\n" +
+		"table ip nextdns {\n" +
+		"\tchain prerouting {\n" +
+		"\t\ttype nat hook prerouting priority -100; policy accept;\n" +
+		"\t\tip saddr { 192.168.1.10/32, 10.0.0.0/8 } return\n" +
+		"\t\tiifname { \"br-lan\", \"wlan0\" } udp dport 53 redirect to :5353\n" +
+		"\t\tiifname { \"br-lan\", \"wlan0\" } tcp dport 53 redirect to :5353\n" +
+		"\t}\n" +
+		"}\n"
+	if got := nftInterceptRules("ip", 5353, []string{"br-lan", "wlan0"}, []string{"192.168.1.10/32", "10.0.0.0/8"}); got != want {
+		t.Errorf("nftInterceptRules() =\n%s\nwant\n%s", got, want)
+	}
+	want = "table ip6 nextdns\n" +
+		"delete table ip6 nextdns\n" +
+		"table ip6 nextdns {\n" +
+		"\tchain prerouting {\n" +
+		"\t\ttype nat hook prerouting priority -100; policy accept;\n" +
+		"\t\tiifname { \"br0\" } udp dport 53 redirect to :53\n" +
+		"\t\tiifname { \"br0\" } tcp dport 53 redirect to :53\n" +
+		"\t}\n" +
+		"}\n"
+	if got := nftInterceptRules("ip6", 53, []string{"br0"}, nil); got != want {
+		t.Errorf("nftInterceptRules() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_iptablesInterceptCommands(t *testing.T) {
+	want := [][]string{
+		{"-N", "NEXTDNS_INTERCEPT"},
+		{"-F", "NEXTDNS_INTERCEPT"},
+		{"-A", "NEXTDNS_INTERCEPT", "-s", "192.168.1.10/32", "-j", "RETURN"},
+		{"-A", "NEXTDNS_INTERCEPT", "-i", "br0", "-p", "udp", "--dport", "53", "-j", "REDIRECT", "--to-ports", "5353"},
+		{"-A", "NEXTDNS_INTERCEPT", "-i", "br0", "-p", "tcp", "--dport", "53", "-j", "REDIRECT", "--to-ports", "5353"},
+		{"-D", "PREROUTING", "-j", "NEXTDNS_INTERCEPT"},
+		{"-I", "PREROUTING", "-j", "NEXTDNS_INTERCEPT"},
+	}
+	if got := iptablesInterceptCommands(5353, []string{"br0"}, []string{"192.168.1.10/32"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("iptablesInterceptCommands() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,15 @@
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package host
+
+import "errors"
+
+// InterceptDNS is not supported on this platform.
+func InterceptDNS(port int, ifaces, exclude []string) error {
+	return errors.New("DNS interception not supported on this platform")
+}
+
+// ResetInterceptDNS is a no-op on this platform.
+func ResetInterceptDNS() error {
+	return nil
+}
@@ -0,0 +1,31 @@
+package host
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_splitExclude(t *testing.T) {
+	tests := []struct {
+		exclude []string
+		v4, v6  []string
+		wantErr bool
+	}{
+		{nil, nil, nil, false},
+		{[]string{"192.168.1.10", "10.0.0.0/8"}, []string{"192.168.1.10/32", "10.0.0.0/8"}, nil, false},
+		{[]string{"2001:db8::1", "2001:db8:1::/48"}, nil, []string{"2001:db8::1/128", "2001:db8:1::/48"}, false},
+		{[]string{"192.168.1.10/24"}, []string{"192.168.1.0/24"}, nil, false},
+		{[]string{"laptop"}, nil, nil, true},
+		{[]string{"10.0.0.0/33"}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		v4, v6, err := splitExclude(tt.exclude)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitExclude(%q) err = %v, wantErr %v", tt.exclude, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(v4, tt.v4) || !reflect.DeepEqual(v6, tt.v6) {
+			t.Errorf("splitExclude(%q) = %q, %q, want %q, %q", tt.exclude, v4, v6, tt.v4, tt.v6)
+		}
+	}
+}
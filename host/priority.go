@@ -0,0 +1,54 @@
+package host
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IO scheduling classes.
+const (
+	ioClassNone = iota
+	ioClassRealtime
+	ioClassBestEffort
+	ioClassIdle
+)
+
+// SetPriority sets the scheduling priority of the current process to nice
+// (from -20 to 19) and its IO scheduling class to ioClass. The ioClass can be
+// realtime, best-effort or idle, optionally followed by a level from 0 to 7
+// separated by a colon. An empty ioClass leaves the IO class unchanged.
+func SetPriority(nice int, ioClass string) error {
+	if nice < -20 || nice > 19 {
+		return fmt.Errorf("%d: invalid nice value", nice)
+	}
+	class, level, err := parseIOClass(ioClass)
+	if err != nil {
+		return err
+	}
+	return setPriority(nice, class, level)
+}
+
+func parseIOClass(s string) (class, level int, err error) {
+	if s == "" {
+		return ioClassNone, 0, nil
+	}
+	level = 4
+	if idx := strings.IndexByte(s, ':'); idx != -1 {
+		if level, err = strconv.Atoi(s[idx+1:]); err != nil || level < 0 || level > 7 {
+			return 0, 0, fmt.Errorf("%s: invalid io priority level", s)
+		}
+		s = s[:idx]
+	}
+	switch s {
+	case "realtime":
+		class = ioClassRealtime
+	case "best-effort":
+		class = ioClassBestEffort
+	case "idle":
+		class, level = ioClassIdle, 0
+	default:
+		return 0, 0, fmt.Errorf("%s: invalid io class", s)
+	}
+	return class, level, nil
+}
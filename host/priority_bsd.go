@@ -0,0 +1,18 @@
+// +build darwin freebsd openbsd netbsd dragonfly
+
+package host
+
+import (
+	"errors"
+	"syscall"
+)
+
+func setPriority(nice, class, level int) error {
+	if class != ioClassNone {
+		return errors.New("io class not supported on this platform")
+	}
+	if nice == 0 {
+		return nil
+	}
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice)
+}
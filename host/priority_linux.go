@@ -0,0 +1,38 @@
+package host
+
+import (
+	"io/ioutil"
+	"strconv"
+	"syscall"
+)
+
+const ioprioWhoProcess = 1
+
+// setPriority applies the priority to every thread of the process as both
+// setpriority and ioprio_set only affect the calling thread on Linux. Threads
+// created later inherit the priority of the thread creating them.
+func setPriority(nice, class, level int) error {
+	tids := []int{0}
+	if fis, err := ioutil.ReadDir("/proc/self/task"); err == nil {
+		tids = tids[:0]
+		for _, fi := range fis {
+			if tid, err := strconv.Atoi(fi.Name()); err == nil {
+				tids = append(tids, tid)
+			}
+		}
+	}
+	for _, tid := range tids {
+		if nice != 0 {
+			if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice); err != nil {
+				return err
+			}
+		}
+		if class != ioClassNone {
+			prio := uintptr(class<<13 | level)
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), prio); errno != 0 {
+				return errno
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package host
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSetPriority(t *testing.T) {
+	if err := SetPriority(1, "best-effort:5"); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := filepath.Glob("/proc/self/task/*")
+	if err != nil || len(tasks) == 0 {
+		t.Fatalf("cannot list threads: %v", err)
+	}
+	for _, task := range tasks {
+		tid, _ := strconv.Atoi(filepath.Base(task))
+		b, err := ioutil.ReadFile(filepath.Join(task, "stat"))
+		if err != nil {
+			// Thread exited.
+			continue
+		}
+		// The nice value is the 19th field, fields after comm start at the 3rd.
+		fields := strings.Fields(string(b[strings.LastIndexByte(string(b), ')')+1:]))
+		if nice := fields[16]; nice != "1" {
+			t.Errorf("thread %d: nice = %s, want 1", tid, nice)
+		}
+		prio, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+		if errno != 0 {
+			t.Fatalf("ioprio_get: %v", errno)
+		}
+		if want := uintptr(ioClassBestEffort<<13 | 5); prio != want {
+			t.Errorf("thread %d: io priority = %#x, want %#x", tid, prio, want)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+// +build !darwin,!linux,!freebsd,!openbsd,!netbsd,!dragonfly,!windows
+
+package host
+
+import "errors"
+
+func setPriority(nice, class, level int) error {
+	if nice != 0 || class != ioClassNone {
+		return errors.New("priority not supported on this platform")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package host
+
+import "testing"
+
+func Test_parseIOClass(t *testing.T) {
+	tests := []struct {
+		s         string
+		wantClass int
+		wantLevel int
+		wantErr   bool
+	}{
+		{"", ioClassNone, 0, false},
+		{"realtime", ioClassRealtime, 4, false},
+		{"realtime:0", ioClassRealtime, 0, false},
+		{"best-effort", ioClassBestEffort, 4, false},
+		{"best-effort:7", ioClassBestEffort, 7, false},
+		{"idle", ioClassIdle, 0, false},
+		{"idle:3", ioClassIdle, 0, false},
+		{"best-effort:8", 0, 0, true},
+		{"best-effort:-1", 0, 0, true},
+		{"best-effort:", 0, 0, true},
+		{"besteffort", 0, 0, true},
+		{":4", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			class, level, err := parseIOClass(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseIOClass() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if class != tt.wantClass || level != tt.wantLevel {
+				t.Errorf("parseIOClass() = %d, %d, want %d, %d", class, level, tt.wantClass, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestSetPriority_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		nice    int
+		ioClass string
+	}{
+		{-21, ""},
+		{20, ""},
+		{0, "lowest"},
+	} {
+		if err := SetPriority(tt.nice, tt.ioClass); err == nil {
+			t.Errorf("SetPriority(%d, %q) err = nil, want an error", tt.nice, tt.ioClass)
+		}
+	}
+}
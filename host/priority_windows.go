@@ -0,0 +1,28 @@
+package host
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// setPriority maps nice to the closest Windows process priority class.
+func setPriority(nice, class, level int) error {
+	if class != ioClassNone {
+		return errors.New("io class not supported on this platform")
+	}
+	var pc uint32
+	switch {
+	case nice <= -15:
+		pc = windows.HIGH_PRIORITY_CLASS
+	case nice < 0:
+		pc = windows.ABOVE_NORMAL_PRIORITY_CLASS
+	case nice == 0:
+		return nil
+	case nice < 15:
+		pc = windows.BELOW_NORMAL_PRIORITY_CLASS
+	default:
+		pc = windows.IDLE_PRIORITY_CLASS
+	}
+	return windows.SetPriorityClass(windows.CurrentProcess(), pc)
+}
@@ -0,0 +1,79 @@
+// +build linux
+
+package host
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// keptCaps are the capabilities kept when switching to an unprivileged user,
+// so listeners can be rebound (i.e. on network changes) and firewall rules
+// updated.
+var keptCaps = []uint{unix.CAP_NET_BIND_SERVICE, unix.CAP_NET_ADMIN}
+
+// setIDs switches to uid and gid. Credentials and capabilities are per thread
+// on Linux: syscall.Setuid and Setgid apply to all the threads of the runtime
+// and so does allThreads for the capabilities.
+func setIDs(uid, gid int) error {
+	keepCaps := uid > 0
+	capsNotKept := false
+	if keepCaps {
+		err := allThreads(unix.SYS_PRCTL, unix.PR_SET_KEEPCAPS, 1, 0)
+		if err == syscall.ENOTSUP {
+			// With cgo, the runtime cannot run a syscall on all the threads.
+			keepCaps, capsNotKept = false, true
+		} else if err != nil {
+			return fmt.Errorf("keepcaps: %v", err)
+		}
+	}
+	if gid != -1 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("setgroups: %v", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid: %v", err)
+		}
+	}
+	if uid != -1 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid: %v", err)
+		}
+	}
+	if keepCaps {
+		// The permitted set is kept by keepcaps but the effective one is
+		// cleared by setuid: restrict both to keptCaps.
+		hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+		data := make([]unix.CapUserData, 2)
+		for _, c := range keptCaps {
+			data[c/32].Permitted |= 1 << (c % 32)
+		}
+		data[0].Effective, data[1].Effective = data[0].Permitted, data[1].Permitted
+		// The pointers must be converted in the AllThreadsSyscall call
+		// expression to be kept alive.
+		if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+			return fmt.Errorf("capset: %v", errno)
+		}
+		if err := allThreads(unix.SYS_PRCTL, unix.PR_SET_KEEPCAPS, 0, 0); err != nil {
+			return fmt.Errorf("keepcaps: %v", err)
+		}
+	}
+	if uid > 0 && syscall.Setuid(0) == nil {
+		return fmt.Errorf("setuid: privileges could not be dropped")
+	}
+	if capsNotKept {
+		return ErrCapsNotKept
+	}
+	return nil
+}
+
+// allThreads runs the syscall on all the threads of the runtime.
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	if _, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_lookupIDs(t *testing.T) {
+	tests := []struct {
+		username, group string
+		uid, gid        int
+		wantErr         bool
+	}{
+		{"", "", -1, -1, false},
+		{"root", "", 0, 0, false},
+		{"0", "", 0, 0, false},
+		{"", "0", -1, 0, false},
+		{"root", "0", 0, 0, false},
+		{"nextdns-no-such-user", "", -1, -1, true},
+		{"", "nextdns-no-such-group", -1, -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username+":"+tt.group, func(t *testing.T) {
+			uid, gid, err := lookupIDs(tt.username, tt.group)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookupIDs() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if uid != tt.uid || gid != tt.gid {
+				t.Errorf("lookupIDs() = %d, %d, want %d, %d", uid, gid, tt.uid, tt.gid)
+			}
+		})
+	}
+}
+
+const dropPrivilegesEnv = "NEXTDNS_TEST_DROP_PRIVILEGES"
+
+func TestDropPrivileges(t *testing.T) {
+	if os.Getenv(dropPrivilegesEnv) != "" {
+		if err := dropPrivilegesChild(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if os.Getuid() != 0 {
+		t.Skip("requires root")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("requires the nobody user")
+	}
+	// Privileges are dropped in a child process not to affect the other tests.
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDropPrivileges$")
+	cmd.Env = append(os.Environ(), dropPrivilegesEnv+"=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+}
+
+func dropPrivilegesChild() error {
+	u, _ := user.Lookup("nobody")
+	// Lock some goroutines on their thread so the runtime has several threads
+	// to update.
+	done := make(chan struct{})
+	defer close(done)
+	for i := 0; i < 4; i++ {
+		go func() {
+			runtime.LockOSThread()
+			<-done
+		}()
+	}
+	err := DropPrivileges("nobody", "")
+	keptCaps := true
+	if errors.Is(err, ErrCapsNotKept) {
+		keptCaps = false
+	} else if err != nil {
+		return err
+	}
+	wantCaps := "0000000000000000"
+	if keptCaps {
+		wantCaps = "0000000000001400" // CAP_NET_ADMIN and CAP_NET_BIND_SERVICE
+	}
+	tasks, _ := filepath.Glob("/proc/self/task/*/status")
+	if len(tasks) < 2 {
+		return fmt.Errorf("%d threads, want several", len(tasks))
+	}
+	for _, task := range tasks {
+		b, err := ioutil.ReadFile(task)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			switch fields[0] {
+			case "Uid:":
+				for _, id := range fields[1:] {
+					if id != u.Uid {
+						return fmt.Errorf("%s: %s, want %s", task, line, u.Uid)
+					}
+				}
+			case "CapEff:", "CapPrm:":
+				if fields[1] != wantCaps {
+					return fmt.Errorf("%s: %s, want %s", task, line, wantCaps)
+				}
+			}
+		}
+	}
+	if keptCaps {
+		// Binding a privileged port requires CAP_NET_BIND_SERVICE.
+		l, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(1+os.Getpid()%1000))
+		if err != nil {
+			return err
+		}
+		l.Close()
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+// +build !windows,!linux
+
+package host
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func setIDs(uid, gid int) error {
+	if gid != -1 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("setgroups: %v", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid: %v", err)
+		}
+	}
+	if uid != -1 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid: %v", err)
+		}
+		if uid != 0 && syscall.Setuid(0) == nil {
+			return fmt.Errorf("setuid: privileges could not be dropped")
+		}
+	}
+	return nil
+}
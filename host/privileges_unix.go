@@ -0,0 +1,63 @@
+// +build !windows
+
+package host
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// ErrCapsNotKept is returned by DropPrivileges when the privileges were
+// dropped but the network capabilities could not be kept.
+var ErrCapsNotKept = errors.New("network capabilities not kept: not supported in cgo builds")
+
+// DropPrivileges switches the process to the given user and group. If group
+// is empty, the primary group of the user is used.
+//
+// On Linux, the CAP_NET_BIND_SERVICE and CAP_NET_ADMIN capabilities are kept
+// so listeners can be rebound and firewall rules updated; when this is not
+// possible, ErrCapsNotKept is returned once privileges are dropped. On other
+// platforms, privileged operations like binding ports below 1024 must be
+// performed before.
+func DropPrivileges(username, group string) error {
+	uid, gid, err := lookupIDs(username, group)
+	if err != nil {
+		return err
+	}
+	return setIDs(uid, gid)
+}
+
+// lookupIDs returns the uid and gid of username and group, defined by name or
+// by id. The primary group of username is used if group is empty. Unset ids
+// are -1.
+func lookupIDs(username, group string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			if u, err = user.LookupId(username); err != nil {
+				return -1, -1, err
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return -1, -1, fmt.Errorf("%s: invalid uid: %v", username, err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return -1, -1, fmt.Errorf("%s: invalid gid: %v", username, err)
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return -1, -1, err
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return -1, -1, fmt.Errorf("%s: invalid gid: %v", group, err)
+		}
+	}
+	return uid, gid, nil
+}
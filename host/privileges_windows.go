@@ -0,0 +1,11 @@
+package host
+
+import "errors"
+
+// ErrCapsNotKept is never returned on Windows.
+var ErrCapsNotKept = errors.New("network capabilities not kept")
+
+// DropPrivileges is not supported on Windows.
+func DropPrivileges(username, group string) error {
+	return errors.New("dropping privileges is not supported on this platform")
+}
@@ -0,0 +1,27 @@
+package host
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ReapChildren reaps the orphaned processes re-parented to the current
+// process, until the process exits. It is meant for processes running as PID 1
+// (i.e. as the entrypoint of a container) where no init process does it.
+//
+// As any child is reaped, the commands run by the process can occasionally
+// fail to get their exit status.
+func ReapChildren() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGCHLD)
+	for range sig {
+		for {
+			var ws syscall.WaitStatus
+			pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+			if pid <= 0 || err != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,6 @@
+// +build !linux
+
+package host
+
+// ReapChildren does nothing on this platform.
+func ReapChildren() {}
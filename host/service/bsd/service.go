@@ -49,13 +49,32 @@ func path(name string) string {
 }
 
 func (s Service) Install() error {
-	return internal.CreateWithTemplate(s.Path, tmpl, 0755, s.Config)
+	if !isFreeBSD() {
+		return internal.CreateWithTemplate(s.Path, tmpl, 0755, s.Config)
+	}
+	if err := internal.CreateWithTemplate(s.Path, freebsdTmpl, 0755, s.Config); err != nil {
+		return err
+	}
+	return internal.Run("sysrc", s.Name+"_enable=YES")
 }
 
 func (s Service) Uninstall() error {
+	if isFreeBSD() {
+		_ = internal.Run("sysrc", "-x", s.Name+"_enable")
+	}
 	return os.Remove(s.Path)
 }
 
+// isFreeBSD returns true on FreeBSD based systems (including pfSense and
+// OPNsense) where services are enabled in rc.conf using sysrc.
+func isFreeBSD() bool {
+	if runtime.GOOS != "freebsd" && runtime.GOOS != "dragonfly" {
+		return false
+	}
+	_, err := exec.LookPath("sysrc")
+	return err == nil
+}
+
 func (s Service) Status() (service.Status, error) {
 	if _, err := os.Stat(s.Path); os.IsNotExist(err) {
 		return service.StatusNotInstalled, nil
@@ -108,3 +127,26 @@ command_args="${daemon_args} {{.Executable}}{{range .Arguments}} {{.}}{{end}}"
 
 run_rc_command "$1"
 `
+
+var freebsdTmpl = `#!/bin/sh
+
+# PROVIDE: {{.Name}}
+# REQUIRE: SERVERS
+# BEFORE: unbound dnsmasq
+# KEYWORD: shutdown
+
+. /etc/rc.subr
+
+name="{{.Name}}"
+rcvar="${name}_enable"
+load_rc_config $name
+: ${ {{- .Name}}_enable:="NO"}
+
+{{.Name}}_env="{{.RunModeEnv}}=1"
+pidfile="/var/run/${name}.pid"
+command="/usr/sbin/daemon"
+daemon_args="-P ${pidfile} -r -t \"${name}: daemon\""
+command_args="${daemon_args} {{.Executable}}{{range .Arguments}} {{.}}{{end}}"
+
+run_rc_command "$1"
+`
@@ -0,0 +1,41 @@
+package bsd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nextdns/nextdns/host/service"
+	"github.com/nextdns/nextdns/host/service/internal"
+)
+
+func Test_freebsdTmpl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "nextdns")
+	c := service.Config{Name: "nextdns", Arguments: []string{"run", "-config-file", "/usr/local/etc/nextdns.conf"}}
+	if err := internal.CreateWithTemplate(p, freebsdTmpl, 0755, c); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# PROVIDE: nextdns\n",
+		"# BEFORE: unbound dnsmasq\n",
+		"rcvar=\"${name}_enable\"\n",
+		": ${nextdns_enable:=\"NO\"}\n",
+		"nextdns_env=\"" + service.RunModeEnv + "=1\"\n",
+		" run -config-file /usr/local/etc/nextdns.conf\"\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("rc.d script missing %q:\n%s", want, b)
+		}
+	}
+}
@@ -3,8 +3,10 @@ package service
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -84,6 +86,46 @@ func (e ConfigDuration) String() string {
 	return e.Value.String()
 }
 
+type ConfigInt struct {
+	Value *int
+}
+
+func (e ConfigInt) Set(v string) error {
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*e.Value = i
+	return nil
+}
+
+func (e ConfigInt) String() string {
+	if e.Value == nil {
+		return ""
+	}
+	return strconv.Itoa(*e.Value)
+}
+
+type ConfigFloat struct {
+	Value *float64
+}
+
+func (e ConfigFloat) Set(v string) error {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return err
+	}
+	*e.Value = f
+	return nil
+}
+
+func (e ConfigFloat) String() string {
+	if e.Value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*e.Value, 'g', -1, 64)
+}
+
 type ConfigFileStorer struct {
 	File string
 }
@@ -125,8 +167,14 @@ func (s ConfigFileStorer) LoadConfig(c map[string]ConfigEntry) error {
 		}
 		return err
 	}
+	defer f.Close()
+	return ReadConfig(f, c)
+}
 
-	sc := bufio.NewScanner(f)
+// ReadConfig sets the entries of c from r, composed of one "name value" pair
+// per line as written by ConfigFileStorer.
+func ReadConfig(r io.Reader, c map[string]ConfigEntry) error {
+	sc := bufio.NewScanner(r)
 	for sc.Scan() {
 		line := strings.TrimSpace(sc.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
@@ -144,7 +192,12 @@ func (s ConfigFileStorer) LoadConfig(c map[string]ConfigEntry) error {
 			}
 		}
 	}
-	if err := sc.Err(); err != nil {
+	return sc.Err()
+}
+
+// RemoveConfig removes the configuration file.
+func (s ConfigFileStorer) RemoveConfig() error {
+	if err := os.Remove(s.File); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	return nil
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFileStorer_RemoveConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := ConfigFileStorer{File: filepath.Join(dir, "nextdns.conf")}
+	if err := s.SaveConfig(map[string]ConfigEntry{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveConfig(); err != nil {
+		t.Fatalf("RemoveConfig() err = %v", err)
+	}
+	if _, err := os.Stat(s.File); !os.IsNotExist(err) {
+		t.Errorf("%s still exists: %v", s.File, err)
+	}
+	// Removing an already removed config is not an error.
+	if err := s.RemoveConfig(); err != nil {
+		t.Errorf("RemoveConfig() on missing file err = %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package systemd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// ListenFiles returns the sockets passed by systemd socket activation, or nil
+// if the process was not socket activated. The files are named after
+// LISTEN_FDNAMES when set. The environment variables are unset so the sockets
+// are not inherited by child processes.
+func ListenFiles() []*os.File {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	names := listenFDNames(os.Getpid(), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+	if len(names) == 0 {
+		return nil
+	}
+	files := make([]*os.File, 0, len(names))
+	for i, name := range names {
+		files = append(files, os.NewFile(uintptr(listenFDsStart+i), name))
+	}
+	return files
+}
+
+// listenFDNames returns the names of the file descriptors passed to the
+// process pid according to the LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES
+// variables, starting at listenFDsStart. The descriptors without a name are
+// named LISTEN_FD_<fd>. It returns nil if the descriptors are not for pid.
+func listenFDNames(pid int, listenPID, listenFDs, fdNames string) []string {
+	if listenPID != strconv.Itoa(pid) {
+		return nil
+	}
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n <= 0 {
+		return nil
+	}
+	var given []string
+	if fdNames != "" {
+		given = strings.Split(fdNames, ":")
+	}
+	names := make([]string, n)
+	for i := range names {
+		if i < len(given) && given[i] != "" {
+			names[i] = given[i]
+			continue
+		}
+		names[i] = "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+	}
+	return names
+}
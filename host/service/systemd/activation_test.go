@@ -0,0 +1,30 @@
+package systemd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_listenFDNames(t *testing.T) {
+	tests := []struct {
+		name                          string
+		listenPID, listenFDs, fdNames string
+		want                          []string
+	}{
+		{"not activated", "", "", "", nil},
+		{"other process", "43", "2", "", nil},
+		{"invalid count", "42", "x", "", nil},
+		{"no fd", "42", "0", "", nil},
+		{"unnamed", "42", "2", "", []string{"LISTEN_FD_3", "LISTEN_FD_4"}},
+		{"named", "42", "2", "dns:dns-tcp", []string{"dns", "dns-tcp"}},
+		{"partially named", "42", "3", "dns::", []string{"dns", "LISTEN_FD_4", "LISTEN_FD_5"}},
+		{"extra names", "42", "1", "dns:dns-tcp", []string{"dns"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := listenFDNames(42, tt.listenPID, tt.listenFDs, tt.fdNames); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listenFDNames() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notify states.
+const (
+	NotifyReady     = "READY=1"
+	NotifyReloading = "RELOADING=1"
+	NotifyStopping  = "STOPPING=1"
+	NotifyWatchdog  = "WATCHDOG=1"
+)
+
+// Notify sends state to the service manager using the sd_notify protocol. It
+// returns false if the process is not supervised by systemd (NOTIFY_SOCKET not
+// set).
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	if path[0] == '@' {
+		// Abstract socket.
+		path = "\x00" + path[1:]
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ExtendTimeout asks the service manager to extend the timeout of the current
+// state (i.e. start-up) so it does not fail if no other notification is sent
+// within d.
+func ExtendTimeout(d time.Duration) (bool, error) {
+	return Notify("EXTEND_TIMEOUT_USEC=" + strconv.FormatInt(int64(d/time.Microsecond), 10))
+}
+
+// Status sends a free-form status shown by systemctl status.
+func Status(status string) (bool, error) {
+	return Notify("STATUS=" + status)
+}
+
+// WatchdogInterval returns the interval at which the service manager expects
+// watchdog notifications, or 0 if the watchdog is not enabled for this
+// process.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -88,6 +88,10 @@ Before=nss-lookup.target
 Wants=nss-lookup.target
 
 [Service]
+Type=notify
+NotifyAccess=main
+WatchdogSec=60
+Restart=on-watchdog
 StartLimitInterval=5
 StartLimitBurst=10
 Environment={{.RunModeEnv}}=1
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
 	"golang.org/x/sys/windows/svc/mgr"
 
 	"github.com/nextdns/nextdns/host/service"
@@ -23,11 +24,10 @@ type Service struct {
 }
 
 func New(c service.Config) (Service, error) {
-	ep, err := os.Executable()
+	confPath, err := configPath(c.Name)
 	if err != nil {
 		return Service{}, err
 	}
-	confPath := filepath.Join(filepath.Dir(ep), c.Name+".conf")
 	return Service{
 		Config:           c,
 		ConfigFileStorer: service.ConfigFileStorer{File: confPath},
@@ -86,6 +86,7 @@ func (s Service) Uninstall() error {
 	if err != nil {
 		return err
 	}
+	_ = eventlog.Remove(s.Name)
 	return nil
 }
 
@@ -110,7 +111,9 @@ func (s Service) Status() (service.Status, error) {
 	}
 
 	switch status.State {
-	case svc.StartPending, svc.Running, svc.PausePending, svc.Paused, svc.ContinuePending, svc.StopPending, svc.Stopped:
+	case svc.StartPending, svc.Running, svc.PausePending, svc.Paused, svc.ContinuePending:
+		return service.StatusRunning, nil
+	case svc.StopPending, svc.Stopped:
 		return service.StatusStopped, nil
 	default:
 		return service.StatusUnknown, fmt.Errorf("unknown status %v", status)
@@ -168,6 +171,28 @@ func (s Service) Restart() error {
 	return s.Start()
 }
 
+// configPath returns the path of the configuration file under ProgramData.
+// A configuration file stored next to the executable by previous versions is
+// moved there.
+func configPath(name string) (string, error) {
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		dir = `C:\ProgramData`
+	}
+	dir = filepath.Join(dir, "NextDNS")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	p := filepath.Join(dir, name+".conf")
+	if ep, err := os.Executable(); err == nil {
+		old := filepath.Join(filepath.Dir(ep), name+".conf")
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			_ = os.Rename(old, p)
+		}
+	}
+	return p, nil
+}
+
 func exePath() (string, error) {
 	prog := os.Args[0]
 	p, err := filepath.Abs(prog)
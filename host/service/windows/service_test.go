@@ -0,0 +1,46 @@
+// +build windows
+
+package windows
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_configPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "programdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("ProgramData", os.Getenv("ProgramData"))
+	os.Setenv("ProgramData", dir)
+
+	// A configuration stored next to the executable by a previous version is
+	// moved to ProgramData.
+	ep, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := filepath.Join(filepath.Dir(ep), "nextdns-test.conf")
+	if err := ioutil.WriteFile(old, []byte("profile abcdef\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(old)
+
+	p, err := configPath("nextdns-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "NextDNS", "nextdns-test.conf"); p != want {
+		t.Errorf("configPath() = %s, want %s", p, want)
+	}
+	if b, err := ioutil.ReadFile(p); err != nil || string(b) != "profile abcdef\n" {
+		t.Errorf("configuration not migrated: %q, %v", b, err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("old configuration still present: %v", err)
+	}
+}
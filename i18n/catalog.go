@@ -0,0 +1,182 @@
+package i18n
+
+// catalogs holds the translations of the messages by language. Messages
+// missing from a catalog are displayed in English.
+var catalogs = map[string]map[string]string{
+	"fr": {
+		"Usage: nextdns <command> [arguments]":                         "Utilisation : nextdns <commande> [arguments]",
+		"The commands are:":                                            "Les commandes sont :",
+		"interactively setup NextDNS":                                  "configurer NextDNS de manière interactive",
+		"install service on the system":                                "installer le service sur le système",
+		"uninstall service from the system":                            "désinstaller le service du système",
+		"start installed service":                                      "démarrer le service installé",
+		"stop installed service":                                       "arrêter le service installé",
+		"restart installed service":                                    "redémarrer le service installé",
+		"return service status":                                        "afficher l'état du service",
+		"show service logs":                                            "afficher les journaux du service",
+		"run the daemon":                                               "exécuter le démon",
+		"manage configuration":                                         "gérer la configuration",
+		"setup the system to use NextDNS as a resolver":                "configurer le système pour utiliser NextDNS comme résolveur",
+		"restore the resolver configuration":                           "restaurer la configuration du résolveur",
+		"send a command to the running daemon":                         "envoyer une commande au démon en cours d'exécution",
+		"monitor a remote DNS proxy":                                   "surveiller un proxy DNS distant",
+		"export the local query history":                               "exporter l'historique local des requêtes",
+		"summarize the local query history":                            "résumer l'historique local des requêtes",
+		"run a self-test of the setup":                                 "exécuter un autotest de la configuration",
+		"compare the speed of NextDNS with the system resolver":        "comparer la vitesse de NextDNS avec le résolveur du système",
+		"send a synthetic query load and report latency and errors":    "envoyer une charge de requêtes synthétique et mesurer la latence et les erreurs",
+		"pause filtering for all clients or one client":                "mettre en pause le filtrage pour tous les clients ou un client",
+		"resume paused filtering":                                      "reprendre le filtrage mis en pause",
+		"answer from the cache and local records only":                 "répondre uniquement depuis le cache et les enregistrements locaux",
+		"Warning: cache-only mode, upstreams not contacted\n":          "Attention : mode cache uniquement, amont non contacté\n",
+		"Filtering paused for %s until %s\n":                           "Filtrage en pause pour %s jusqu'au %s\n",
+		"all clients":                                                  "tous les clients",
+		"recommend kernel and daemon settings for high query rates":    "recommander des réglages du noyau et du démon pour les débits de requêtes élevés",
+		"show a live view of the queries served by the daemon":         "afficher en direct les requêtes servies par le démon",
+		"check the health of the running daemon":                       "vérifier la santé du démon en cours d'exécution",
+		"collect a CPU, memory or trace profile of the running daemon": "collecter un profil CPU, mémoire ou trace du démon en cours d'exécution",
+		"trace how the running daemon answers a query":                 "tracer la façon dont le démon en cours d'exécution répond à une requête",
+		"show current version":                                         "afficher la version actuelle",
+		"upgrade to the latest release":                                "mettre à jour vers la dernière version",
+		"Error: %v\n":                                                  "Erreur : %v\n",
+		"Cannot setup firewall: %v\n":                                  "Impossible de configurer le pare-feu : %v\n",
+		"NextDNS installed and started using %s init\n":                "NextDNS installé et démarré avec l'init %s\n",
+		"NextDNS already installed and running using %s init\n":        "NextDNS déjà installé et en cours d'exécution avec l'init %s\n",
+		"Verifying uninstall:":                                         "Vérification de la désinstallation :",
+		"  %-10s FAILED: %v\n":                                         "  %-10s ÉCHEC : %v\n",
+		"Warning: queries answered over plain DNS (unencrypted, unfiltered) since %s\n":              "Avertissement : requêtes résolues en DNS non chiffré (sans filtrage) depuis %s\n",
+		"Warning: plain DNS is intercepted on this network (%s), the plain DNS fallback is unsafe\n": "Avertissement : le DNS non chiffré est intercepté sur ce réseau (%s), le repli en DNS non chiffré n'est pas sûr\n",
+	},
+	"de": {
+		"Usage: nextdns <command> [arguments]":                         "Verwendung: nextdns <Befehl> [Argumente]",
+		"The commands are:":                                            "Die Befehle sind:",
+		"interactively setup NextDNS":                                  "NextDNS interaktiv einrichten",
+		"install service on the system":                                "Dienst auf dem System installieren",
+		"uninstall service from the system":                            "Dienst vom System deinstallieren",
+		"start installed service":                                      "installierten Dienst starten",
+		"stop installed service":                                       "installierten Dienst stoppen",
+		"restart installed service":                                    "installierten Dienst neu starten",
+		"return service status":                                        "Dienststatus anzeigen",
+		"show service logs":                                            "Dienstprotokolle anzeigen",
+		"run the daemon":                                               "den Daemon ausführen",
+		"manage configuration":                                         "Konfiguration verwalten",
+		"setup the system to use NextDNS as a resolver":                "das System für NextDNS als Resolver einrichten",
+		"restore the resolver configuration":                           "die Resolver-Konfiguration wiederherstellen",
+		"send a command to the running daemon":                         "einen Befehl an den laufenden Daemon senden",
+		"monitor a remote DNS proxy":                                   "einen entfernten DNS-Proxy überwachen",
+		"export the local query history":                               "den lokalen Abfrageverlauf exportieren",
+		"summarize the local query history":                            "den lokalen Abfrageverlauf zusammenfassen",
+		"run a self-test of the setup":                                 "einen Selbsttest der Einrichtung ausführen",
+		"compare the speed of NextDNS with the system resolver":        "die Geschwindigkeit von NextDNS mit dem Systemresolver vergleichen",
+		"send a synthetic query load and report latency and errors":    "eine synthetische Anfragelast senden und Latenz und Fehler messen",
+		"pause filtering for all clients or one client":                "die Filterung für alle oder einen Client pausieren",
+		"resume paused filtering":                                      "die pausierte Filterung fortsetzen",
+		"answer from the cache and local records only":                 "nur aus dem Cache und lokalen Einträgen antworten",
+		"Warning: cache-only mode, upstreams not contacted\n":          "Warnung: Nur-Cache-Modus, Upstream wird nicht kontaktiert\n",
+		"Filtering paused for %s until %s\n":                           "Filterung für %s pausiert bis %s\n",
+		"all clients":                                                  "alle Clients",
+		"recommend kernel and daemon settings for high query rates":    "Kernel- und Diensteinstellungen für hohe Abfrageraten empfehlen",
+		"show a live view of the queries served by the daemon":         "die vom Dienst beantworteten Anfragen live anzeigen",
+		"check the health of the running daemon":                       "den Zustand des laufenden Dienstes prüfen",
+		"collect a CPU, memory or trace profile of the running daemon": "ein CPU-, Speicher- oder Trace-Profil des laufenden Dienstes erfassen",
+		"trace how the running daemon answers a query":                 "verfolgen, wie der laufende Dienst eine Anfrage beantwortet",
+		"show current version":                                         "aktuelle Version anzeigen",
+		"upgrade to the latest release":                                "auf die neueste Version aktualisieren",
+		"Error: %v\n":                                                  "Fehler: %v\n",
+		"Cannot setup firewall: %v\n":                                  "Firewall kann nicht eingerichtet werden: %v\n",
+		"NextDNS installed and started using %s init\n":                "NextDNS mit %s-Init installiert und gestartet\n",
+		"NextDNS already installed and running using %s init\n":        "NextDNS bereits mit %s-Init installiert und gestartet\n",
+		"Verifying uninstall:":                                         "Deinstallation wird überprüft:",
+		"  %-10s FAILED: %v\n":                                         "  %-10s FEHLGESCHLAGEN: %v\n",
+		"Warning: queries answered over plain DNS (unencrypted, unfiltered) since %s\n":              "Warnung: Anfragen werden seit %s über unverschlüsseltes DNS (ohne Filterung) beantwortet\n",
+		"Warning: plain DNS is intercepted on this network (%s), the plain DNS fallback is unsafe\n": "Warnung: unverschlüsseltes DNS wird in diesem Netzwerk abgefangen (%s), der Rückgriff auf unverschlüsseltes DNS ist unsicher\n",
+	},
+	"es": {
+		"Usage: nextdns <command> [arguments]":                         "Uso: nextdns <comando> [argumentos]",
+		"The commands are:":                                            "Los comandos son:",
+		"interactively setup NextDNS":                                  "configurar NextDNS de forma interactiva",
+		"install service on the system":                                "instalar el servicio en el sistema",
+		"uninstall service from the system":                            "desinstalar el servicio del sistema",
+		"start installed service":                                      "iniciar el servicio instalado",
+		"stop installed service":                                       "detener el servicio instalado",
+		"restart installed service":                                    "reiniciar el servicio instalado",
+		"return service status":                                        "mostrar el estado del servicio",
+		"show service logs":                                            "mostrar los registros del servicio",
+		"run the daemon":                                               "ejecutar el demonio",
+		"manage configuration":                                         "gestionar la configuración",
+		"setup the system to use NextDNS as a resolver":                "configurar el sistema para usar NextDNS como resolutor",
+		"restore the resolver configuration":                           "restaurar la configuración del resolutor",
+		"send a command to the running daemon":                         "enviar un comando al demonio en ejecución",
+		"monitor a remote DNS proxy":                                   "supervisar un proxy DNS remoto",
+		"export the local query history":                               "exportar el historial local de consultas",
+		"summarize the local query history":                            "resumir el historial local de consultas",
+		"run a self-test of the setup":                                 "ejecutar una autoprueba de la configuración",
+		"compare the speed of NextDNS with the system resolver":        "comparar la velocidad de NextDNS con el resolutor del sistema",
+		"send a synthetic query load and report latency and errors":    "enviar una carga sintética de consultas y medir la latencia y los errores",
+		"pause filtering for all clients or one client":                "pausar el filtrado para todos los clientes o uno",
+		"resume paused filtering":                                      "reanudar el filtrado pausado",
+		"answer from the cache and local records only":                 "responder solo desde la caché y los registros locales",
+		"Warning: cache-only mode, upstreams not contacted\n":          "Advertencia: modo solo caché, upstream no contactado\n",
+		"Filtering paused for %s until %s\n":                           "Filtrado pausado para %s hasta %s\n",
+		"all clients":                                                  "todos los clientes",
+		"recommend kernel and daemon settings for high query rates":    "recomendar ajustes del núcleo y del demonio para altas tasas de consultas",
+		"show a live view of the queries served by the daemon":         "mostrar en vivo las consultas atendidas por el demonio",
+		"check the health of the running daemon":                       "comprobar el estado del demonio en ejecución",
+		"collect a CPU, memory or trace profile of the running daemon": "recopilar un perfil de CPU, memoria o traza del demonio en ejecución",
+		"trace how the running daemon answers a query":                 "rastrear cómo responde el demonio en ejecución a una consulta",
+		"show current version":                                         "mostrar la versión actual",
+		"upgrade to the latest release":                                "actualizar a la última versión",
+		"Error: %v\n":                                                  "Error: %v\n",
+		"Cannot setup firewall: %v\n":                                  "No se puede configurar el cortafuegos: %v\n",
+		"NextDNS installed and started using %s init\n":                "NextDNS instalado e iniciado usando init %s\n",
+		"NextDNS already installed and running using %s init\n":        "NextDNS ya instalado y en ejecución usando init %s\n",
+		"Verifying uninstall:":                                         "Verificando la desinstalación:",
+		"  %-10s FAILED: %v\n":                                         "  %-10s FALLÓ: %v\n",
+		"Warning: queries answered over plain DNS (unencrypted, unfiltered) since %s\n":              "Advertencia: consultas resueltas por DNS sin cifrar (sin filtrado) desde %s\n",
+		"Warning: plain DNS is intercepted on this network (%s), the plain DNS fallback is unsafe\n": "Advertencia: el DNS sin cifrar es interceptado en esta red (%s), el respaldo por DNS sin cifrar no es seguro\n",
+	},
+	"pt": {
+		"Usage: nextdns <command> [arguments]":                         "Uso: nextdns <comando> [argumentos]",
+		"The commands are:":                                            "Os comandos são:",
+		"interactively setup NextDNS":                                  "configurar o NextDNS de forma interativa",
+		"install service on the system":                                "instalar o serviço no sistema",
+		"uninstall service from the system":                            "desinstalar o serviço do sistema",
+		"start installed service":                                      "iniciar o serviço instalado",
+		"stop installed service":                                       "parar o serviço instalado",
+		"restart installed service":                                    "reiniciar o serviço instalado",
+		"return service status":                                        "mostrar o estado do serviço",
+		"show service logs":                                            "mostrar os logs do serviço",
+		"run the daemon":                                               "executar o daemon",
+		"manage configuration":                                         "gerenciar a configuração",
+		"setup the system to use NextDNS as a resolver":                "configurar o sistema para usar o NextDNS como resolvedor",
+		"restore the resolver configuration":                           "restaurar a configuração do resolvedor",
+		"send a command to the running daemon":                         "enviar um comando ao daemon em execução",
+		"monitor a remote DNS proxy":                                   "monitorar um proxy DNS remoto",
+		"export the local query history":                               "exportar o histórico local de consultas",
+		"summarize the local query history":                            "resumir o histórico local de consultas",
+		"run a self-test of the setup":                                 "executar um autoteste da configuração",
+		"compare the speed of NextDNS with the system resolver":        "comparar a velocidade do NextDNS com o resolvedor do sistema",
+		"send a synthetic query load and report latency and errors":    "enviar uma carga sintética de consultas e medir a latência e os erros",
+		"pause filtering for all clients or one client":                "pausar a filtragem para todos os clientes ou um cliente",
+		"resume paused filtering":                                      "retomar a filtragem pausada",
+		"answer from the cache and local records only":                 "responder apenas a partir do cache e dos registros locais",
+		"Warning: cache-only mode, upstreams not contacted\n":          "Aviso: modo somente cache, upstream não contatado\n",
+		"Filtering paused for %s until %s\n":                           "Filtragem pausada para %s até %s\n",
+		"all clients":                                                  "todos os clientes",
+		"recommend kernel and daemon settings for high query rates":    "recomendar configurações do kernel e do daemon para altas taxas de consultas",
+		"show a live view of the queries served by the daemon":         "mostrar ao vivo as consultas atendidas pelo daemon",
+		"check the health of the running daemon":                       "verificar a saúde do daemon em execução",
+		"collect a CPU, memory or trace profile of the running daemon": "coletar um perfil de CPU, memória ou rastreamento do daemon em execução",
+		"trace how the running daemon answers a query":                 "rastrear como o daemon em execução responde a uma consulta",
+		"show current version":                                         "mostrar a versão atual",
+		"upgrade to the latest release":                                "atualizar para a versão mais recente",
+		"Error: %v\n":                                                  "Erro: %v\n",
+		"Cannot setup firewall: %v\n":                                  "Não foi possível configurar o firewall: %v\n",
+		"NextDNS installed and started using %s init\n":                "NextDNS instalado e iniciado usando o init %s\n",
+		"NextDNS already installed and running using %s init\n":        "NextDNS já instalado e em execução usando o init %s\n",
+		"Verifying uninstall:":                                         "Verificando a desinstalação:",
+		"  %-10s FAILED: %v\n":                                         "  %-10s FALHOU: %v\n",
+		"Warning: queries answered over plain DNS (unencrypted, unfiltered) since %s\n":              "Aviso: consultas resolvidas por DNS não criptografado (sem filtragem) desde %s\n",
+		"Warning: plain DNS is intercepted on this network (%s), the plain DNS fallback is unsafe\n": "Aviso: o DNS não criptografado é interceptado nesta rede (%s), o recurso ao DNS não criptografado não é seguro\n",
+	},
+}
@@ -0,0 +1,79 @@
+// Package i18n provides translations of user facing CLI messages.
+//
+// Messages are identified by their English text, which is used as a fallback
+// when no translation is available for the current locale.
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// LangEnv is the environment variable that can be set to force the language
+// of the messages, overriding the system locale.
+const LangEnv = "NEXTDNS_LANG"
+
+var (
+	langOnce sync.Once
+	lang     string
+)
+
+// Lang returns the language code (ISO 639-1) of the current locale.
+func Lang() string {
+	langOnce.Do(func() {
+		lang = detectLang()
+	})
+	return lang
+}
+
+func detectLang() string {
+	for _, env := range []string{LangEnv, "LC_ALL", "LC_MESSAGES", "LANG"} {
+		if v := os.Getenv(env); v != "" {
+			return parseLocale(v)
+		}
+	}
+	return parseLocale(systemLocale())
+}
+
+// parseLocale returns the language part of a POSIX (fr_FR.UTF-8) or BCP 47
+// (fr-FR) locale.
+func parseLocale(locale string) string {
+	if idx := strings.IndexAny(locale, "_-.@"); idx != -1 {
+		locale = locale[:idx]
+	}
+	locale = strings.ToLower(locale)
+	switch locale {
+	case "", "c", "posix":
+		return "en"
+	}
+	return locale
+}
+
+// T returns the translation of msg in the current language.
+func T(msg string) string {
+	if c := catalogs[Lang()]; c != nil {
+		if t, found := c[msg]; found {
+			return t
+		}
+	}
+	return msg
+}
+
+// Sprintf formats according to the translation of format in the current
+// language.
+func Sprintf(format string, a ...interface{}) string {
+	return fmt.Sprintf(T(format), a...)
+}
+
+// Printf prints the translation of format in the current language.
+func Printf(format string, a ...interface{}) {
+	fmt.Print(Sprintf(format, a...))
+}
+
+// Println prints the translation of msg in the current language followed by
+// a new line.
+func Println(msg string) {
+	fmt.Println(T(msg))
+}
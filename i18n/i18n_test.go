@@ -0,0 +1,60 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_parseLocale(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"", "en"},
+		{"C", "en"},
+		{"POSIX", "en"},
+		{"C.UTF-8", "en"},
+		{"fr_FR.UTF-8", "fr"},
+		{"de_DE@euro", "de"},
+		{"pt-BR", "pt"},
+		{"es", "es"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			if got := parseLocale(tt.locale); got != tt.want {
+				t.Errorf("parseLocale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_catalogs(t *testing.T) {
+	// Translations must keep the same formatting verbs as the original.
+	for lang, c := range catalogs {
+		for msg, trans := range c {
+			if got, want := strings.Count(trans, "%"), strings.Count(msg, "%"); got != want {
+				t.Errorf("%s: %q: %d verbs, want %d", lang, trans, got, want)
+			}
+			if got, want := strings.HasSuffix(trans, "\n"), strings.HasSuffix(msg, "\n"); got != want {
+				t.Errorf("%s: %q: trailing new line mismatch", lang, trans)
+			}
+		}
+	}
+}
+
+func Test_catalogsKeys(t *testing.T) {
+	// Every language must translate the same messages.
+	keys := map[string]bool{}
+	for _, c := range catalogs {
+		for msg := range c {
+			keys[msg] = true
+		}
+	}
+	for lang, c := range catalogs {
+		for msg := range keys {
+			if _, found := c[msg]; !found {
+				t.Errorf("%s: missing translation for %q", lang, msg)
+			}
+		}
+	}
+}
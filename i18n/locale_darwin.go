@@ -0,0 +1,14 @@
+package i18n
+
+import (
+	"os/exec"
+	"strings"
+)
+
+func systemLocale() string {
+	b, err := exec.Command("defaults", "read", "-g", "AppleLocale").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,7 @@
+// +build !windows,!darwin
+
+package i18n
+
+func systemLocale() string {
+	return ""
+}
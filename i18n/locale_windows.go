@@ -0,0 +1,18 @@
+package i18n
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetUserDefaultLocaleName = syscall.NewLazyDLL("kernel32.dll").NewProc("GetUserDefaultLocaleName")
+
+func systemLocale() string {
+	const localeNameMaxLength = 85
+	buf := make([]uint16, localeNameMaxLength)
+	r, _, _ := procGetUserDefaultLocaleName.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	if r == 0 {
+		return ""
+	}
+	return syscall.UTF16ToString(buf)
+}
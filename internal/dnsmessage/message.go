@@ -38,6 +38,19 @@ const (
 	TypeSRV   Type = 33
 	TypeOPT   Type = 41
 
+	// DNSSEC record types (RFC 4034 and RFC 5155). They are parsed as
+	// UnknownResource.
+	TypeDS         Type = 43
+	TypeRRSIG      Type = 46
+	TypeNSEC       Type = 47
+	TypeDNSKEY     Type = 48
+	TypeNSEC3      Type = 50
+	TypeNSEC3PARAM Type = 51
+
+	// Service binding record types (RFC 9460).
+	TypeSVCB  Type = 64
+	TypeHTTPS Type = 65
+
 	// Question.Type
 	TypeWKS   Type = 11
 	TypeHINFO Type = 13
@@ -58,10 +71,21 @@ var typeNames = map[Type]string{
 	TypeSRV:   "TypeSRV",
 	TypeOPT:   "TypeOPT",
 	TypeWKS:   "TypeWKS",
+
 	TypeHINFO: "TypeHINFO",
 	TypeMINFO: "TypeMINFO",
 	TypeAXFR:  "TypeAXFR",
 	TypeALL:   "TypeALL",
+
+	TypeDS:         "TypeDS",
+	TypeRRSIG:      "TypeRRSIG",
+	TypeNSEC:       "TypeNSEC",
+	TypeDNSKEY:     "TypeDNSKEY",
+	TypeNSEC3:      "TypeNSEC3",
+	TypeNSEC3PARAM: "TypeNSEC3PARAM",
+
+	TypeSVCB:  "TypeSVCB",
+	TypeHTTPS: "TypeHTTPS",
 }
 
 // String implements fmt.Stringer.String.
@@ -261,6 +285,7 @@ var (
 
 	errBaseLen            = errors.New("insufficient data for base length type")
 	errCalcLen            = errors.New("insufficient data for calculated length type")
+	errSVCParamOrder      = errors.New("service parameters not in strictly increasing key order")
 	errReserved           = errors.New("segment prefix is reserved")
 	errTooManyPtr         = errors.New("too many pointers (>10)")
 	errInvalidPtr         = errors.New("invalid pointer")
@@ -1027,6 +1052,59 @@ func (p *Parser) OPTResource() (OPTResource, error) {
 	return r, nil
 }
 
+// OPTOptions calls f with the code and data of each option of a single
+// OPTResource. Unlike OPTResource, it does not allocate: data aliases the
+// message and must be copied to be retained after f returns.
+//
+// One of the XXXHeader methods must have been called before calling this
+// method.
+func (p *Parser) OPTOptions(f func(code uint16, data []byte)) error {
+	if !p.resHeaderValid || p.resHeader.Type != TypeOPT {
+		return ErrNotStarted
+	}
+	end := p.off + int(p.resHeader.Length)
+	if end > len(p.msg) {
+		return errResourceLen
+	}
+	for off := p.off; off < end; {
+		code, o, err := unpackUint16(p.msg, off)
+		if err != nil {
+			return &nestedError{"Code", err}
+		}
+		l, o, err := unpackUint16(p.msg, o)
+		if err != nil {
+			return &nestedError{"Data", err}
+		}
+		if o+int(l) > end {
+			return &nestedError{"Data", errCalcLen}
+		}
+		f(code, p.msg[o:o+int(l)])
+		off = o + int(l)
+	}
+	p.off = end
+	p.resHeaderValid = false
+	p.index++
+	return nil
+}
+
+// UnknownResource parses a single UnknownResource.
+//
+// One of the XXXHeader methods must have been called before calling this
+// method.
+func (p *Parser) UnknownResource() (UnknownResource, error) {
+	if !p.resHeaderValid {
+		return UnknownResource{}, ErrNotStarted
+	}
+	r, err := unpackUnknownResource(p.resHeader.Type, p.msg, p.off, p.resHeader.Length)
+	if err != nil {
+		return UnknownResource{}, err
+	}
+	p.off += int(p.resHeader.Length)
+	p.resHeaderValid = false
+	p.index++
+	return r, nil
+}
+
 // Unpack parses a full Message.
 func (m *Message) Unpack(msg []byte) error {
 	var p Parser
@@ -1561,6 +1639,30 @@ func (b *Builder) OPTResource(h ResourceHeader, r OPTResource) error {
 	return nil
 }
 
+// UnknownResource adds a single UnknownResource.
+func (b *Builder) UnknownResource(h ResourceHeader, r UnknownResource) error {
+	if err := b.checkResourceSection(); err != nil {
+		return err
+	}
+	h.Type = r.realType()
+	msg, lenOff, err := h.pack(b.msg, b.compression, b.start)
+	if err != nil {
+		return &nestedError{"ResourceHeader", err}
+	}
+	preLen := len(msg)
+	if msg, err = r.pack(msg, b.compression, b.start); err != nil {
+		return &nestedError{"UnknownResource body", err}
+	}
+	if err := h.fixLen(msg, lenOff, preLen); err != nil {
+		return err
+	}
+	if err := b.incrementSectionCount(); err != nil {
+		return err
+	}
+	b.msg = msg
+	return nil
+}
+
 // Finish ends message building and generates a binary message.
 func (b *Builder) Finish() ([]byte, error) {
 	if b.section < sectionHeader {
@@ -2158,12 +2260,29 @@ func unpackResourceBody(msg []byte, off int, hdr ResourceHeader) (ResourceBody,
 		rb, err = unpackOPTResource(msg, off, hdr.Length)
 		r = &rb
 		name = "OPT"
+	case TypeSVCB, TypeHTTPS:
+		// Malformed records are kept as UnknownResource so they are
+		// forwarded untouched.
+		rb, serr := unpackSVCBResource(msg, off, hdr.Length)
+		if serr != nil {
+			break
+		}
+		if hdr.Type == TypeHTTPS {
+			r = &HTTPSResource{rb}
+		} else {
+			r = &rb
+		}
 	}
 	if err != nil {
 		return nil, off, &nestedError{name + " record", err}
 	}
 	if r == nil {
-		return nil, off, errors.New("invalid resource type: " + string(hdr.Type+'0'))
+		var rb UnknownResource
+		rb, err = unpackUnknownResource(hdr.Type, msg, off, hdr.Length)
+		if err != nil {
+			return nil, off, &nestedError{"Unknown record", err}
+		}
+		r = &rb
 	}
 	return r, off + int(hdr.Length), nil
 }
@@ -2608,3 +2727,223 @@ func unpackOPTResource(msg []byte, off int, length uint16) (OPTResource, error)
 	}
 	return OPTResource{opts}, nil
 }
+
+// An SVCParamKey is the key of a service parameter of SVCB and HTTPS records
+// (RFC 9460).
+type SVCParamKey uint16
+
+const (
+	SVCParamMandatory     SVCParamKey = 0
+	SVCParamALPN          SVCParamKey = 1
+	SVCParamNoDefaultALPN SVCParamKey = 2
+	SVCParamPort          SVCParamKey = 3
+	SVCParamIPv4Hint      SVCParamKey = 4
+	SVCParamECH           SVCParamKey = 5
+	SVCParamIPv6Hint      SVCParamKey = 6
+)
+
+// An SVCParam is a service parameter of SVCB and HTTPS records, with its
+// value in wire format.
+type SVCParam struct {
+	Key   SVCParamKey
+	Value []byte
+}
+
+// GoString implements fmt.GoStringer.GoString.
+func (p *SVCParam) GoString() string {
+	return "dnsmessage.SVCParam{" +
+		"Key: " + printUint16(uint16(p.Key)) + ", " +
+		"Value: []byte{" + printByteSlice(p.Value) + "}}"
+}
+
+// An SVCBResource is a SVCB Resource record (RFC 9460).
+type SVCBResource struct {
+	Priority uint16
+	Target   Name
+	Params   []SVCParam // sorted by Key, without duplicates
+}
+
+func (r *SVCBResource) realType() Type {
+	return TypeSVCB
+}
+
+// pack appends the wire format of the SVCBResource to msg. The target name is
+// never compressed.
+func (r *SVCBResource) pack(msg []byte, compression map[string]int, compressionOff int) ([]byte, error) {
+	oldMsg := msg
+	msg = packUint16(msg, r.Priority)
+	msg, err := r.Target.pack(msg, nil, compressionOff)
+	if err != nil {
+		return oldMsg, &nestedError{"SVCBResource.Target", err}
+	}
+	for _, p := range r.Params {
+		msg = packUint16(msg, uint16(p.Key))
+		msg = packUint16(msg, uint16(len(p.Value)))
+		msg = packBytes(msg, p.Value)
+	}
+	return msg, nil
+}
+
+// GoString implements fmt.GoStringer.GoString.
+func (r *SVCBResource) GoString() string {
+	s := "dnsmessage.SVCBResource{" +
+		"Priority: " + printUint16(r.Priority) + ", " +
+		"Target: " + r.Target.GoString() + ", " +
+		"Params: []dnsmessage.SVCParam{"
+	for i, p := range r.Params {
+		if i > 0 {
+			s += ", "
+		}
+		s += p.GoString()
+	}
+	return s + "}}"
+}
+
+// Param returns the value of the parameter key and whether it is set.
+func (r *SVCBResource) Param(key SVCParamKey) ([]byte, bool) {
+	for _, p := range r.Params {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// SetParam sets the value of the parameter key, keeping Params sorted.
+func (r *SVCBResource) SetParam(key SVCParamKey, value []byte) {
+	for i, p := range r.Params {
+		if p.Key == key {
+			r.Params[i].Value = value
+			return
+		}
+		if p.Key > key {
+			r.Params = append(r.Params, SVCParam{})
+			copy(r.Params[i+1:], r.Params[i:])
+			r.Params[i] = SVCParam{Key: key, Value: value}
+			return
+		}
+	}
+	r.Params = append(r.Params, SVCParam{Key: key, Value: value})
+}
+
+// DeleteParam removes the parameter key and returns true if it was set. The
+// key is also removed from the mandatory parameter, and no-default-alpn is
+// removed with alpn, so the record stays valid.
+func (r *SVCBResource) DeleteParam(key SVCParamKey) bool {
+	found := false
+	params := r.Params[:0]
+	for _, p := range r.Params {
+		if p.Key == key {
+			found = true
+			continue
+		}
+		params = append(params, p)
+	}
+	r.Params = params
+	if !found {
+		return false
+	}
+	if key == SVCParamALPN {
+		r.DeleteParam(SVCParamNoDefaultALPN)
+	}
+	if m, ok := r.Param(SVCParamMandatory); ok {
+		var keys []byte
+		for i := 0; i+1 < len(m); i += 2 {
+			if SVCParamKey(uint16(m[i])<<8|uint16(m[i+1])) != key {
+				keys = append(keys, m[i], m[i+1])
+			}
+		}
+		if len(keys) == 0 {
+			r.DeleteParam(SVCParamMandatory)
+		} else {
+			r.SetParam(SVCParamMandatory, keys)
+		}
+	}
+	return true
+}
+
+func unpackSVCBResource(msg []byte, off int, length uint16) (SVCBResource, error) {
+	end := off + int(length)
+	if end > len(msg) {
+		return SVCBResource{}, errResourceLen
+	}
+	var r SVCBResource
+	var err error
+	if r.Priority, off, err = unpackUint16(msg, off); err != nil {
+		return SVCBResource{}, &nestedError{"Priority", err}
+	}
+	if off, err = r.Target.unpack(msg, off); err != nil {
+		return SVCBResource{}, &nestedError{"Target", err}
+	}
+	for off < end {
+		var key, l uint16
+		if key, off, err = unpackUint16(msg, off); err != nil {
+			return SVCBResource{}, &nestedError{"Key", err}
+		}
+		if len(r.Params) > 0 && SVCParamKey(key) <= r.Params[len(r.Params)-1].Key {
+			return SVCBResource{}, errSVCParamOrder
+		}
+		if l, off, err = unpackUint16(msg, off); err != nil {
+			return SVCBResource{}, &nestedError{"Value", err}
+		}
+		if off+int(l) > end {
+			return SVCBResource{}, &nestedError{"Value", errCalcLen}
+		}
+		p := SVCParam{Key: SVCParamKey(key), Value: make([]byte, l)}
+		copy(p.Value, msg[off:])
+		off += int(l)
+		r.Params = append(r.Params, p)
+	}
+	if off != end {
+		return SVCBResource{}, errResourceLen
+	}
+	return r, nil
+}
+
+// An HTTPSResource is an HTTPS Resource record (RFC 9460). It has the same
+// format as SVCBResource.
+type HTTPSResource struct {
+	SVCBResource
+}
+
+func (r *HTTPSResource) realType() Type {
+	return TypeHTTPS
+}
+
+// GoString implements fmt.GoStringer.GoString.
+func (r *HTTPSResource) GoString() string {
+	return "dnsmessage.HTTPSResource{SVCBResource: " + r.SVCBResource.GoString() + "}"
+}
+
+// An UnknownResource is a catch-all container for unknown record types.
+type UnknownResource struct {
+	Type Type
+	Data []byte
+}
+
+func (r *UnknownResource) realType() Type {
+	return r.Type
+}
+
+// pack appends the wire format of the UnknownResource to msg.
+func (r *UnknownResource) pack(msg []byte, compression map[string]int, compressionOff int) ([]byte, error) {
+	return packBytes(msg, r.Data[:]), nil
+}
+
+// GoString implements fmt.GoStringer.GoString.
+func (r *UnknownResource) GoString() string {
+	return "dnsmessage.UnknownResource{" +
+		"Type: " + r.Type.GoString() + ", " +
+		"Data: []byte{" + printByteSlice(r.Data) + "}}"
+}
+
+func unpackUnknownResource(recordType Type, msg []byte, off int, length uint16) (UnknownResource, error) {
+	parsed := UnknownResource{
+		Type: recordType,
+		Data: make([]byte, length),
+	}
+	if _, err := unpackBytes(msg, off, parsed.Data); err != nil {
+		return UnknownResource{}, err
+	}
+	return parsed, nil
+}
@@ -0,0 +1,22 @@
+package outbound
+
+import (
+	"net"
+	"syscall"
+)
+
+const bindInterfaceSupported = true
+
+// Socket options scoping a socket to an interface (netinet/in.h).
+const (
+	ipBoundIF   = 25
+	ipv6BoundIF = 125
+)
+
+// bindInterface binds the socket fd to ifi with IP_BOUND_IF or IPV6_BOUND_IF.
+func bindInterface(fd uintptr, v6 bool, ifi *net.Interface) error {
+	if v6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, ipv6BoundIF, ifi.Index)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, ipBoundIF, ifi.Index)
+}
@@ -0,0 +1,13 @@
+package outbound
+
+import (
+	"net"
+	"syscall"
+)
+
+const bindInterfaceSupported = true
+
+// bindInterface binds the socket fd to ifi with SO_BINDTODEVICE.
+func bindInterface(fd uintptr, v6 bool, ifi *net.Interface) error {
+	return syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifi.Name)
+}
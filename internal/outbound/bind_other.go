@@ -0,0 +1,14 @@
+// +build !linux,!darwin
+
+package outbound
+
+import (
+	"errors"
+	"net"
+)
+
+const bindInterfaceSupported = false
+
+func bindInterface(fd uintptr, v6 bool, ifi *net.Interface) error {
+	return errors.New("not supported")
+}
@@ -0,0 +1,10 @@
+// +build !windows
+
+package outbound
+
+import "syscall"
+
+// bind sets the source address of the socket fd.
+func bind(fd uintptr, sa syscall.Sockaddr) error {
+	return syscall.Bind(int(fd), sa)
+}
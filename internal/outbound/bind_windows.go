@@ -0,0 +1,8 @@
+package outbound
+
+import "syscall"
+
+// bind sets the source address of the socket fd.
+func bind(fd uintptr, sa syscall.Sockaddr) error {
+	return syscall.Bind(syscall.Handle(fd), sa)
+}
@@ -0,0 +1,174 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultCheckInterval is the interval between two checks of the primary
+	// path when Interval is not set.
+	defaultCheckInterval = 10 * time.Second
+
+	// failThreshold is the number of consecutive failed checks of the
+	// primary path switching to the backup path.
+	failThreshold = 3
+
+	// recoverThreshold is the number of consecutive successful checks of
+	// the primary path switching back to it, so a flapping link does not
+	// move the traffic back and forth.
+	recoverThreshold = 3
+)
+
+// Failover binds the upstream connections to the Primary interface, or to the
+// Backup interface while the path through Primary is down, i.e. to use an LTE
+// dongle when the WAN link fails.
+type Failover struct {
+	// Primary is the name of the interface used while its path is healthy.
+	Primary string
+
+	// Backup is the name of the interface used while the path through
+	// Primary is down.
+	Backup string
+
+	// Check tests the path to the upstream servers through the connections
+	// of d, bound to Primary.
+	Check func(ctx context.Context, d *net.Dialer) error
+
+	// Interval is the interval between two checks of the primary path.
+	// Default is 10s.
+	Interval time.Duration
+
+	// OnChange is called when the traffic is moved to the interface iface,
+	// with the error of the primary path when iface is Backup.
+	OnChange func(iface string, err error)
+
+	mu     sync.Mutex
+	backup bool
+	fails  int
+	oks    int
+}
+
+// Validate checks the interfaces exist and binding to an interface is
+// supported on this platform.
+func (f *Failover) Validate() error {
+	if !bindInterfaceSupported {
+		return errors.New("binding to an interface is not supported on this platform")
+	}
+	if f.Primary == "" || f.Backup == "" {
+		return errors.New("primary and backup interfaces required")
+	}
+	if f.Primary == f.Backup {
+		return fmt.Errorf("%s: same primary and backup interface", f.Primary)
+	}
+	// The backup interface may only appear when needed (i.e. a USB dongle).
+	if _, err := net.InterfaceByName(f.Primary); err != nil {
+		return fmt.Errorf("%s: %v", f.Primary, err)
+	}
+	return nil
+}
+
+// Active returns the name of the interface the new connections are bound to.
+func (f *Failover) Active() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.backup {
+		return f.Backup
+	}
+	return f.Primary
+}
+
+// Dialer returns a net.Dialer binding its sockets to the active interface.
+func (f *Failover) Dialer() *net.Dialer {
+	return &net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return bindControl(f.Active(), network, c)
+		},
+	}
+}
+
+// primaryDialer returns a net.Dialer binding its sockets to Primary.
+func (f *Failover) primaryDialer() *net.Dialer {
+	return &net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return bindControl(f.Primary, network, c)
+		},
+	}
+}
+
+// bindControl binds the socket c to the interface named iface.
+func bindControl(iface, network string, c syscall.RawConn) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return fmt.Errorf("%s: %v", iface, err)
+	}
+	v6 := network[len(network)-1] == '6'
+	cerr := c.Control(func(fd uintptr) {
+		if err = bindInterface(fd, v6, ifi); err != nil {
+			err = fmt.Errorf("bind to %s: %v", ifi.Name, err)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// Start checks the primary path every Interval and moves the traffic to the
+// backup path when it fails, until ctx is cancelled.
+func (f *Failover) Start(ctx context.Context) {
+	interval := f.Interval
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	d := f.primaryDialer()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		cctx, cancel := context.WithTimeout(ctx, interval)
+		err := f.Check(cctx, d)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		f.record(err)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// record records the result err of a check of the primary path, switching
+// paths if needed.
+func (f *Failover) record(err error) {
+	f.mu.Lock()
+	var changed bool
+	if err != nil {
+		f.fails++
+		f.oks = 0
+		if !f.backup && f.fails >= failThreshold {
+			f.backup, changed = true, true
+		}
+	} else {
+		f.oks++
+		f.fails = 0
+		if f.backup && f.oks >= recoverThreshold {
+			f.backup, changed = false, true
+		}
+	}
+	iface := f.Primary
+	if f.backup {
+		iface = f.Backup
+	}
+	f.mu.Unlock()
+	if changed && f.OnChange != nil {
+		f.OnChange(iface, err)
+	}
+}
@@ -0,0 +1,86 @@
+// Package outbound pins outgoing connections to a network interface or a
+// source address, so upstream traffic can be forced through a given path on
+// multi-WAN routers or VPN setups.
+package outbound
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Dialer returns a net.Dialer binding its sockets to the interface named
+// iface if not empty, and to the address of sources matching the family of
+// the destination. A connection to a destination of a family without source
+// fails rather than leaving through another path. If neither iface nor
+// sources are set, Dialer returns nil.
+func Dialer(iface string, sources []net.IP) (*net.Dialer, error) {
+	var ifi *net.Interface
+	if iface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(iface); err != nil {
+			return nil, fmt.Errorf("%s: %v", iface, err)
+		}
+		if !bindInterfaceSupported {
+			return nil, errors.New("binding to an interface is not supported on this platform")
+		}
+	}
+	var src4, src6 net.IP
+	for _, ip := range sources {
+		switch {
+		case ip.To4() != nil:
+			if src4 != nil {
+				return nil, fmt.Errorf("%s: more than one IPv4 source address", ip)
+			}
+			src4 = ip.To4()
+		case ip.To16() != nil:
+			if src6 != nil {
+				return nil, fmt.Errorf("%s: more than one IPv6 source address", ip)
+			}
+			src6 = ip
+		}
+	}
+	if ifi == nil && src4 == nil && src6 == nil {
+		return nil, nil
+	}
+	return &net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			v6 := network[len(network)-1] == '6'
+			var sa syscall.Sockaddr
+			if src4 != nil || src6 != nil {
+				if v6 {
+					if src6 == nil {
+						return fmt.Errorf("%s: no IPv6 source address", address)
+					}
+					sa6 := &syscall.SockaddrInet6{}
+					copy(sa6.Addr[:], src6)
+					sa = sa6
+				} else {
+					if src4 == nil {
+						return fmt.Errorf("%s: no IPv4 source address", address)
+					}
+					sa4 := &syscall.SockaddrInet4{}
+					copy(sa4.Addr[:], src4)
+					sa = sa4
+				}
+			}
+			var err error
+			cerr := c.Control(func(fd uintptr) {
+				if ifi != nil {
+					if err = bindInterface(fd, v6, ifi); err != nil {
+						err = fmt.Errorf("bind to %s: %v", ifi.Name, err)
+						return
+					}
+				}
+				if sa != nil {
+					err = bind(fd, sa)
+				}
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}, nil
+}
@@ -0,0 +1,206 @@
+package outbound
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDialer_source(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	d, err := Dialer("", []net.IP{net.ParseIP("127.0.0.2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Skipf("127.0.0.2 not usable: %v", err)
+	}
+	defer c.Close()
+	if ip := c.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.2")) {
+		t.Errorf("source address = %v, want 127.0.0.2", ip)
+	}
+	if _, err := d.Dial("tcp6", "[::1]:53"); err == nil {
+		t.Error("IPv6 dial without IPv6 source address succeeded")
+	}
+	if _, err := Dialer("", []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}); err == nil {
+		t.Error("expected an error with two IPv4 source addresses")
+	}
+}
+
+func TestProxies_Get(t *testing.T) {
+	var p Proxies
+	for _, spec := range []string{"nextdns.io=http://u:p@10.0.0.1:3128", "socks5://10.0.0.2:1080"} {
+		r, err := ParseProxyRule(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p = append(p, r)
+	}
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"dns1.nextdns.io", "http://u:p@10.0.0.1:3128"},
+		{"nextdns.io.", "http://u:p@10.0.0.1:3128"},
+		{"dns.google", "socks5://10.0.0.2:1080"},
+	}
+	for _, tt := range tests {
+		if got := p.Get(tt.host); got == nil || got.String() != tt.want {
+			t.Errorf("Get(%q) = %v, want %s", tt.host, got, tt.want)
+		}
+	}
+	if p[:1].Get("notnextdns.io") != nil {
+		t.Error("Get(notnextdns.io) matched nextdns.io")
+	}
+	for _, spec := range []string{"https://10.0.0.1:3128", "socks5://10.0.0.1", "example.com=ftp://10.0.0.1:21"} {
+		if _, err := ParseProxyRule(spec); err == nil {
+			t.Errorf("ParseProxyRule(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestProxyDialer_connect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil {
+					return
+				}
+				if req.Method != "CONNECT" || req.Host != "dns.example:443" {
+					_, _ = io.WriteString(c, "HTTP/1.1 400 Bad Request\r\n\r\n")
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != "Basic dTpw" {
+					_, _ = io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				_, _ = io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+
+	for _, tt := range []struct {
+		user    string
+		wantErr bool
+	}{{"u:p", false}, {"u:x", true}} {
+		r, err := ParseProxyRule("http://" + tt.user + "@" + l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dial, err := ProxyDialer(r.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := dial(context.Background(), "tcp", "dns.example:443")
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: dial error = %v, want error %v", tt.user, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		_, _ = io.WriteString(c, "ping")
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(c, b); err != nil || string(b) != "ping" {
+			t.Errorf("%s: tunnel read %q, %v", tt.user, b, err)
+		}
+		c.Close()
+	}
+}
+
+func TestTCP(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = io.Copy(c, c)
+			c.Close()
+		}
+	}()
+	for _, fastOpen := range []bool{false, true} {
+		d := TCP(nil, fastOpen, 30*time.Second)
+		if d.KeepAlive != 30*time.Second {
+			t.Errorf("KeepAlive = %v, want 30s", d.KeepAlive)
+		}
+		c, err := d.Dial("tcp4", l.Addr().String())
+		if err != nil {
+			t.Fatalf("fastOpen=%v: %v", fastOpen, err)
+		}
+		buf := make([]byte, 4)
+		if _, err := c.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("fastOpen=%v: read %q, %v, want ping", fastOpen, buf, err)
+		}
+		c.Close()
+	}
+	if d := TCP(nil, false, 0); d.KeepAlive >= 0 {
+		t.Errorf("KeepAlive = %v, want disabled", d.KeepAlive)
+	}
+}
+
+func TestFailover_record(t *testing.T) {
+	var changes []string
+	f := &Failover{
+		Primary: "wan",
+		Backup:  "lte",
+		OnChange: func(iface string, err error) {
+			changes = append(changes, iface)
+		},
+	}
+	fail := errors.New("timeout")
+	for i, tt := range []struct {
+		err  error
+		want string
+	}{
+		{fail, "wan"},
+		{fail, "wan"},
+		{nil, "wan"}, // failures must be consecutive
+		{fail, "wan"},
+		{fail, "wan"},
+		{fail, "lte"},
+		{nil, "lte"},
+		{fail, "lte"}, // successes must be consecutive
+		{nil, "lte"},
+		{nil, "lte"},
+		{nil, "wan"},
+	} {
+		f.record(tt.err)
+		if got := f.Active(); got != tt.want {
+			t.Errorf("check %d: active = %s, want %s", i, got, tt.want)
+		}
+	}
+	if got := strings.Join(changes, ","); got != "lte,wan" {
+		t.Errorf("changes = %s, want lte,wan", got)
+	}
+}
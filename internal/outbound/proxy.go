@@ -0,0 +1,152 @@
+package outbound
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// DialFunc connects to address on the named network.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// ProxyRule routes the connections to the hosts matching Domain through the
+// proxy at URL.
+type ProxyRule struct {
+	// Domain is the host, or the parent domain of the hosts, the rule applies
+	// to. If empty, the rule applies to all hosts.
+	Domain string
+	URL    *url.URL
+}
+
+// ParseProxyRule parses a proxy rule in the [DOMAIN=]URL form, where URL is a
+// socks5://, socks5h:// or http:// proxy URL with optional credentials.
+func ParseProxyRule(spec string) (ProxyRule, error) {
+	var r ProxyRule
+	s := spec
+	if idx := strings.IndexByte(s, '='); idx != -1 && !strings.Contains(s[:idx], "://") {
+		r.Domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(s[:idx]), "."))
+		s = strings.TrimSpace(s[idx+1:])
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return r, fmt.Errorf("%s: %v", spec, err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return r, fmt.Errorf("%s: unsupported proxy scheme %q", spec, u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return r, fmt.Errorf("%s: missing proxy host or port", spec)
+	}
+	r.URL = u
+	return r, nil
+}
+
+// Proxies is a list of proxy rules, the first matching rule applying.
+type Proxies []ProxyRule
+
+// Get returns the URL of the proxy to use to connect to host, or nil to
+// connect directly.
+func (p Proxies) Get(host string) *url.URL {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, r := range p {
+		if r.Domain == "" || host == r.Domain || strings.HasSuffix(host, "."+r.Domain) {
+			return r.URL
+		}
+	}
+	return nil
+}
+
+// ProxyDialer returns a DialFunc connecting through the proxy at u, with the
+// credentials of u if any. The proxy itself is reached using forward, or a
+// default dialer if nil. The destination hostname is sent to the proxy
+// unresolved.
+func ProxyDialer(u *url.URL, forward *net.Dialer) (DialFunc, error) {
+	if forward == nil {
+		forward = &net.Dialer{}
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			auth = &proxy.Auth{User: u.User.Username()}
+			auth.Password, _ = u.User.Password()
+		}
+		d, err := proxy.SOCKS5("tcp", u.Host, auth, forward)
+		if err != nil {
+			return nil, err
+		}
+		cd, ok := d.(proxy.ContextDialer)
+		if !ok {
+			return nil, errors.New("socks5: dialer does not support contexts")
+		}
+		return cd.DialContext, nil
+	case "http":
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialConnect(ctx, u, forward, address)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+}
+
+// dialConnect connects to address through the HTTP proxy at u using the
+// CONNECT method.
+func dialConnect(ctx context.Context, u *url.URL, forward *net.Dialer, address string) (net.Conn, error) {
+	c, err := forward.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	// Interrupt the handshake if ctx is done before it completes.
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = c.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err = req.Write(c); err == nil {
+		br := bufio.NewReader(c)
+		var res *http.Response
+		if res, err = http.ReadResponse(br, req); err == nil {
+			if res.StatusCode != http.StatusOK {
+				err = fmt.Errorf("proxy %s: CONNECT %s: %s", u.Host, address, res.Status)
+			} else if br.Buffered() > 0 {
+				err = fmt.Errorf("proxy %s: unexpected data after CONNECT response", u.Host)
+			}
+		}
+	}
+	close(done)
+	<-stopped
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
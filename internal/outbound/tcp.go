@@ -0,0 +1,45 @@
+package outbound
+
+import (
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// TCP returns a copy of d, or a new net.Dialer if d is nil, enabling TCP Fast
+// Open on the TCP connections if fastOpen is true and the platform supports
+// it, and sending keepalive probes on idle connections every keepAlive, or
+// never if zero. Fast Open saves a round trip when reconnecting to a server
+// the system got a cookie from, and keepalive probes keep the connections in
+// the state table of the NATs on the path.
+func TCP(d *net.Dialer, fastOpen bool, keepAlive time.Duration) *net.Dialer {
+	nd := &net.Dialer{}
+	if d != nil {
+		*nd = *d
+	}
+	nd.KeepAlive = keepAlive
+	if keepAlive <= 0 {
+		nd.KeepAlive = -1
+	}
+	if !fastOpen || !fastOpenSupported {
+		return nd
+	}
+	control := nd.Control
+	nd.Control = func(network, address string, c syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		if !strings.HasPrefix(network, "tcp") {
+			return nil
+		}
+		return c.Control(func(fd uintptr) {
+			// Kernels without client support fall back to a regular
+			// handshake.
+			_ = setFastOpen(fd)
+		})
+	}
+	return nd
+}
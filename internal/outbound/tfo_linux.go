@@ -0,0 +1,14 @@
+package outbound
+
+import "syscall"
+
+const fastOpenSupported = true
+
+// tcpFastOpenConnect is the TCP_FASTOPEN_CONNECT socket option (Linux 4.11+),
+// sending the data of the first write in the SYN of a connect.
+const tcpFastOpenConnect = 30
+
+// setFastOpen enables TCP Fast Open on the socket fd before it connects.
+func setFastOpen(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+}
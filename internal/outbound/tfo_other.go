@@ -0,0 +1,9 @@
+// +build !linux
+
+package outbound
+
+const fastOpenSupported = false
+
+func setFastOpen(fd uintptr) error {
+	return nil
+}
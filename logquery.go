@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nextdns/nextdns/history"
+)
+
+// logQuery prints the queries of the local query history matching the
+// filters, the most recent last.
+func logQuery(args []string) error {
+	fs := flag.NewFlagSet("nextdns log query", flag.ExitOnError)
+	var f history.Filter
+	fs.StringVar(&f.Client, "client", "", "IP address, MAC address or device name of the client.")
+	fs.StringVar(&f.Domain, "domain", "", "Domain the queried names must be or be a subdomain of.")
+	fs.StringVar(&f.RCode, "rcode", "", "Response code of the queries, i.e. NXDOMAIN or SERVFAIL.")
+	from := fs.String("from", "24h", "Start of the range, as a date (2006-01-02), a RFC3339 time or a duration before\n"+
+		"now. The whole history is searched if empty.")
+	to := fs.String("to", "", "End of the range (excluded), in the same formats as from. Up to now if empty.")
+	limit := fs.Int("limit", 100, "Maximum number of queries printed, the most recent ones. No limit if 0.")
+	dir := fs.String("dir", "", "Directory of the query history. Defaults to the query-history setting.")
+	_ = fs.Parse(args[1:])
+	if fs.NArg() > 0 {
+		return withCode(exitUsage, fmt.Errorf("%s: unexpected argument", fs.Arg(0)))
+	}
+
+	if err := historyDir("log", dir); err != nil {
+		return err
+	}
+	now := time.Now()
+	start, err := parseExportTime(*from, now)
+	if err != nil {
+		return withCode(exitUsage, fmt.Errorf("from: %v", err))
+	}
+	end, err := parseExportTime(*to, now)
+	if err != nil {
+		return withCode(exitUsage, fmt.Errorf("to: %v", err))
+	}
+
+	// The history is read oldest first, only the last limit records are
+	// kept.
+	var records []history.Record
+	var total int
+	err = history.Read(*dir, start, end, func(r history.Record) error {
+		if !f.Match(r) {
+			return nil
+		}
+		total++
+		records = append(records, r)
+		if *limit > 0 && len(records) > 2*(*limit) {
+			records = append(records[:0], records[len(records)-*limit:]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *limit > 0 && len(records) > *limit {
+		records = records[len(records)-*limit:]
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tCLIENT\tNAME\tTYPE\tRCODE\tDURATION")
+	for _, r := range records {
+		client := r.Client
+		if r.Device != "" {
+			client = r.Device + " (" + r.Client + ")"
+		}
+		rcode := r.RCode
+		if r.Error != "" {
+			rcode = "error: " + r.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.1fms\n", r.Time.Local().Format("2006-01-02 15:04:05"), client, r.Name, r.Type, rcode, r.Duration)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if total > len(records) {
+		fmt.Printf("\n%d of %d matching queries shown, raise -limit to see more\n", len(records), total)
+	} else if total == 0 {
+		fmt.Println("No matching queries")
+	}
+	return nil
+}
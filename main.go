@@ -4,11 +4,16 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+
+	"github.com/nextdns/nextdns/i18n"
 )
 
 var (
 	version  = "dev"
 	platform = runtime.GOOS
+	// releaseKey is the base64 encoded ed25519 public key releases are
+	// signed with, set at build time.
+	releaseKey = ""
 )
 
 type command struct {
@@ -18,6 +23,8 @@ type command struct {
 }
 
 var commands = []command{
+	{"setup", setup, "interactively setup NextDNS"},
+
 	{"install", svc, "install service on the system"},
 	{"uninstall", svc, "uninstall service from the system"},
 	{"start", svc, "start installed service"},
@@ -33,19 +40,40 @@ var commands = []command{
 	{"activate", activation, "setup the system to use NextDNS as a resolver"},
 	{"deactivate", activation, "restore the resolver configuration"},
 
+	{"ctl", control, "send a command to the running daemon"},
+
+	{"watch", watch, "monitor a remote DNS proxy"},
+
+	{"export", export, "export the local query history"},
+	{"stats", stats, "summarize the local query history"},
+	{"top", topCmd, "show a live view of the queries served by the daemon"},
+	{"pause", pauseCmd, "pause filtering for all clients or one client"},
+	{"resume", pauseCmd, "resume paused filtering"},
+	{"cache-only", cacheOnlyCmd, "answer from the cache and local records only"},
+
+	{"diag", diag, "run a self-test of the setup"},
+	{"trace", traceCmd, "trace how the running daemon answers a query"},
+	{"compare", compare, "compare the speed of NextDNS with the system resolver"},
+	{"bench", benchCmd, "send a synthetic query load and report latency and errors"},
+	{"tune", tune, "recommend kernel and daemon settings for high query rates"},
+	{"healthcheck", healthcheck, "check the health of the running daemon"},
+	{"profile", profileCmd, "collect a CPU, memory or trace profile of the running daemon"},
+
+	{"upgrade", upgradeCmd, "upgrade to the latest release"},
+
 	{"version", showVersion, "show current version"},
 }
 
 func showCommands() {
-	fmt.Println("Usage: nextdns <command> [arguments]")
+	i18n.Println("Usage: nextdns <command> [arguments]")
 	fmt.Println("")
-	fmt.Println("The commands are:")
+	i18n.Println("The commands are:")
 	fmt.Println("")
 	for _, cmd := range commands {
-		fmt.Printf("    %-15s %s\n", cmd.name, cmd.desc)
+		fmt.Printf("    %-15s %s\n", cmd.name, i18n.T(cmd.desc))
 	}
 	fmt.Println("")
-	os.Exit(1)
+	os.Exit(int(exitUsage))
 }
 
 func showVersion(args []string) error {
@@ -62,9 +90,17 @@ func main() {
 		if c.name != cmd {
 			continue
 		}
-		if err := c.run(os.Args[1:]); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+		args := os.Args[1:]
+		if jsonCommands[cmd] {
+			args, jsonOutput = stripFlag(args, "json")
+		}
+		if checkCommands[cmd] {
+			args, checkMode = stripFlag(args, "check")
+		}
+		if err := c.run(args); err != nil {
+			code := exitCodeOf(err)
+			printError(os.Stderr, code, err)
+			os.Exit(int(code))
 		}
 		return
 	}
@@ -0,0 +1,224 @@
+// Package maintenance runs periodic maintenance tasks once a day, at the hour
+// the network is the quietest as learned from its query volume.
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DefaultHour is the local hour maintenance runs at until enough traffic has
+// been observed to learn the quiet hours.
+const DefaultHour = 4
+
+const (
+	// minObserved is the number of hours of traffic to observe before the
+	// learned profile is trusted.
+	minObserved = 24
+
+	// smoothing is the weight of a new hour of traffic in the profile.
+	smoothing = 0.3
+
+	// maxJitter is the maximum delay after the start of the quiet hour
+	// before tasks are run, so instances of a same network do not refresh
+	// their lists all at once.
+	maxJitter = 15 * time.Minute
+)
+
+// Task is a maintenance task.
+type Task struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Scheduler learns the query volume of each hour of the week and runs Tasks
+// once a day during the quietest hour of the day.
+type Scheduler struct {
+	// Tasks is the list of tasks to run, in order.
+	Tasks []Task
+
+	// Location is the time zone hours are learned in. If nil, time.Local is
+	// used.
+	Location *time.Location
+
+	// InfoLog and ErrorLog are optional log functions used when tasks are
+	// run.
+	InfoLog  func(string)
+	ErrorLog func(error)
+
+	mu       sync.Mutex
+	profile  [7][24]float64 // smoothed queries per hour
+	seen     [7][24]bool
+	observed int
+	slot     time.Time // start of the hour being counted
+	count    int
+	lastRun  time.Time
+}
+
+// Record accounts for a query received now.
+func (s *Scheduler) Record() {
+	s.mu.Lock()
+	s.observeLocked(time.Now(), 1)
+	s.mu.Unlock()
+}
+
+// Load learns the traffic profile from the times of past queries, given in
+// chronological order by read, i.e. from the query history.
+func (s *Scheduler) Load(read func(f func(t time.Time)) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := read(func(t time.Time) {
+		s.observeLocked(t, 1)
+	})
+	// The time between the last past query and now was not observed.
+	if !s.slot.IsZero() {
+		s.learnLocked(s.slot, s.count)
+		s.slot, s.count = time.Time{}, 0
+	}
+	return err
+}
+
+// observeLocked adds n queries at t, closing the hours elapsed since the
+// last observation. Hours without queries are learned as such.
+func (s *Scheduler) observeLocked(t time.Time, n int) {
+	t = t.In(s.location())
+	slot := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	if s.slot.IsZero() {
+		s.slot = slot
+	}
+	if week := 7 * 24 * time.Hour; slot.Sub(s.slot) > week {
+		s.learnLocked(s.slot, s.count)
+		s.slot, s.count = slot.Add(-week), 0
+	}
+	for s.slot.Before(slot) {
+		s.learnLocked(s.slot, s.count)
+		s.slot, s.count = s.slot.Add(time.Hour), 0
+	}
+	if !slot.Before(s.slot) {
+		s.count += n
+	}
+}
+
+func (s *Scheduler) learnLocked(slot time.Time, count int) {
+	slot = slot.In(s.location())
+	d, h := slot.Weekday(), slot.Hour()
+	if s.seen[d][h] {
+		s.profile[d][h] += smoothing * (float64(count) - s.profile[d][h])
+	} else {
+		s.profile[d][h] = float64(count)
+		s.seen[d][h] = true
+	}
+	s.observed++
+}
+
+// QuietHour returns the hour of day with the lowest query volume, or
+// DefaultHour if not enough traffic has been observed yet. Ties are broken in
+// favor of the hours closest after DefaultHour.
+func (s *Scheduler) QuietHour(day time.Weekday) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.observed < minObserved {
+		return DefaultHour
+	}
+	quiet := DefaultHour
+	min := -1.0
+	for i := 0; i < 24; i++ {
+		h := (DefaultHour + i) % 24
+		if !s.seen[day][h] {
+			continue
+		}
+		if min < 0 || s.profile[day][h] < min {
+			quiet, min = h, s.profile[day][h]
+		}
+	}
+	return quiet
+}
+
+// QuietHours returns the quiet hour of each day of the week, starting on
+// Sunday.
+func (s *Scheduler) QuietHours() [7]int {
+	var hours [7]int
+	for d := range hours {
+		hours[d] = s.QuietHour(time.Weekday(d))
+	}
+	return hours
+}
+
+// Start runs the tasks once a day during the quiet hour until ctx is
+// cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	for {
+		now := time.Now().In(s.location())
+		next := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location()).Add(time.Hour)
+		t := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		// Timers can fire slightly early, use the expected time.
+		now = next
+		s.mu.Lock()
+		s.observeLocked(now, 0)
+		due := s.lastRun.IsZero() || !sameDay(s.lastRun, now)
+		s.mu.Unlock()
+		if !due || now.Hour() != s.QuietHour(now.Weekday()) {
+			continue
+		}
+		jitter := time.NewTimer(time.Duration(rand.Int63n(int64(maxJitter))))
+		select {
+		case <-ctx.Done():
+			jitter.Stop()
+			return
+		case <-jitter.C:
+		}
+		s.Run(ctx)
+	}
+}
+
+// Run runs all the tasks now.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.lastRun = time.Now().In(s.location())
+	s.mu.Unlock()
+	for _, t := range s.Tasks {
+		if ctx.Err() != nil {
+			return
+		}
+		start := time.Now()
+		if err := t.Run(ctx); err != nil {
+			s.logErr(fmt.Errorf("maintenance: %s: %v", t.Name, err))
+			continue
+		}
+		s.logInfof("Maintenance: %s done in %v", t.Name, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+func (s *Scheduler) location() *time.Location {
+	if s.Location != nil {
+		return s.Location
+	}
+	return time.Local
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+func (s *Scheduler) logInfof(format string, a ...interface{}) {
+	if s.InfoLog != nil {
+		s.InfoLog(fmt.Sprintf(format, a...))
+	}
+}
+
+func (s *Scheduler) logErr(err error) {
+	if s.ErrorLog != nil {
+		s.ErrorLog(err)
+	}
+}
@@ -0,0 +1,47 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduler_QuietHour(t *testing.T) {
+	// Monday 2026-01-05.
+	mon := time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		hours int
+		quiet func(h int) bool
+		want  int
+	}{
+		{"NotEnoughData", 12, func(h int) bool { return false }, DefaultHour},
+		{"Uniform", 48, func(h int) bool { return false }, DefaultHour},
+		{"Night", 7 * 24, func(h int) bool { return h == 2 }, 2},
+		{"Afternoon", 7 * 24, func(h int) bool { return h == 15 }, 15},
+		{"Idle", 7 * 24, func(h int) bool { return h >= 1 && h < 6 }, DefaultHour},
+		{"Wrap", 7 * 24, func(h int) bool { return h == 1 || h == 23 }, 23},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scheduler{Location: time.UTC}
+			err := s.Load(func(f func(time.Time)) error {
+				for i := 0; i < tt.hours; i++ {
+					slot := mon.Add(time.Duration(i) * time.Hour)
+					if tt.quiet(slot.Hour()) {
+						continue
+					}
+					for j := 0; j < 10; j++ {
+						f(slot.Add(time.Duration(j) * time.Minute))
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.QuietHour(time.Monday); got != tt.want {
+				t.Errorf("QuietHour() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
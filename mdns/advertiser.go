@@ -0,0 +1,379 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// servicesName is the name used to enumerate the service types of a network
+// (RFC 6763 section 9).
+const servicesName = "_services._dns-sd._udp.local."
+
+// TTLs recommended by RFC 6762 section 10.
+const (
+	hostTTL  = 120
+	otherTTL = 4500
+)
+
+// cacheFlush is the class bit telling the records of a unique name replace the
+// cached ones (RFC 6762 section 10.2).
+const cacheFlush = 0x8000
+
+// Service is a DNS-SD service advertised by Advertiser.
+type Service struct {
+	// Type is the service type (i.e. _dns._udp).
+	Type string
+
+	// Port is the port the service listens on.
+	Port uint16
+
+	// TXT are the optional key=value pairs of the service TXT record.
+	TXT []string
+}
+
+// Advertiser publishes Services with mDNS/DNS-SD (RFC 6762 and RFC 6763) on
+// Interfaces (IPv4 only), so capable clients can discover them. Services are
+// announced when the advertiser starts, withdrawn when it stops, and queries
+// for them are answered in between.
+type Advertiser struct {
+	// Interfaces are the names of the network interfaces services are
+	// advertised on.
+	Interfaces []string
+
+	// Instance is the name of the service instances (i.e. NextDNS on router).
+	Instance string
+
+	// Host is the host name of the SRV records, without the .local suffix.
+	Host string
+
+	// Services are the advertised services.
+	Services []Service
+
+	// InfoLog specifies an optional log function called when the advertiser
+	// starts.
+	InfoLog func(string)
+
+	// ErrorLog specifies an optional log function for errors.
+	ErrorLog func(error)
+}
+
+// Validate checks the names of the advertised records are valid.
+func (a *Advertiser) Validate() error {
+	if a.Instance == "" || strings.ContainsRune(a.Instance, '.') || len(a.Instance) > 63 {
+		return fmt.Errorf("%q: invalid instance name", a.Instance)
+	}
+	if a.Host == "" || strings.ContainsRune(a.Host, '.') || len(a.Host) > 63 {
+		return fmt.Errorf("%q: invalid host name", a.Host)
+	}
+	for _, s := range a.Services {
+		if !strings.HasPrefix(s.Type, "_") || !strings.HasSuffix(s.Type, "._udp") && !strings.HasSuffix(s.Type, "._tcp") {
+			return fmt.Errorf("%q: invalid service type", s.Type)
+		}
+	}
+	return nil
+}
+
+// Start runs the advertiser until ctx is cancelled.
+func (a *Advertiser) Start(ctx context.Context) {
+	if err := a.run(ctx); err != nil && a.ErrorLog != nil {
+		a.ErrorLog(fmt.Errorf("mdns advertiser: %w", err))
+	}
+}
+
+func (a *Advertiser) run(ctx context.Context) error {
+	if len(a.Interfaces) == 0 || len(a.Services) == 0 {
+		return errors.New("no interface or service to advertise")
+	}
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	ifis := make([]*net.Interface, 0, len(a.Interfaces))
+	for _, name := range a.Interfaces {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		ifis = append(ifis, ifi)
+	}
+
+	c, err := listen(ctx, "udp4", "0.0.0.0:5353")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	p := ipv4.NewPacketConn(c)
+	for _, ifi := range ifis {
+		if err := p.JoinGroup(ifi, groupAddr); err != nil {
+			return fmt.Errorf("%s: join group: %v", ifi.Name, err)
+		}
+	}
+	if err := p.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		return err
+	}
+	_ = p.SetMulticastLoopback(false)
+	_ = p.SetMulticastTTL(255)
+
+	announce := func(ttl bool) {
+		for _, ifi := range ifis {
+			msg, err := a.announcement(ifaceAddrs(ifi), ttl)
+			if err != nil {
+				continue
+			}
+			if err := p.SetMulticastInterface(ifi); err != nil {
+				continue
+			}
+			if _, err := p.WriteTo(msg, nil, groupAddr); err != nil && a.ErrorLog != nil {
+				a.ErrorLog(fmt.Errorf("mdns advertiser: %s: %v", ifi.Name, err))
+			}
+		}
+	}
+	go func() {
+		// Announce twice, one second apart (RFC 6762 section 8.3).
+		announce(true)
+		select {
+		case <-time.After(time.Second):
+			announce(true)
+		case <-ctx.Done():
+		}
+		<-ctx.Done()
+		// Goodbye packets withdraw the records from the caches.
+		announce(false)
+		c.Close()
+	}()
+	if a.InfoLog != nil {
+		a.InfoLog(fmt.Sprintf("mDNS advertiser started on %s", strings.Join(a.Interfaces, ", ")))
+	}
+
+	buf := make([]byte, 9000)
+	for {
+		n, cm, src, err := p.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return err
+		}
+		udpSrc, ok := src.(*net.UDPAddr)
+		if cm == nil || !ok {
+			continue
+		}
+		var ifi *net.Interface
+		for _, i := range ifis {
+			if i.Index == cm.IfIndex {
+				ifi = i
+				break
+			}
+		}
+		if ifi == nil {
+			continue
+		}
+		// Legacy unicast queries (not sent from port 5353) are answered to
+		// their source (RFC 6762 section 6.7).
+		legacy := udpSrc.Port != 5353
+		resp, err := a.response(buf[:n], ifaceAddrs(ifi), legacy)
+		if err != nil || resp == nil {
+			continue
+		}
+		if legacy {
+			_, err = p.WriteTo(resp, nil, src)
+		} else {
+			_, err = p.WriteTo(resp, &ipv4.ControlMessage{IfIndex: ifi.Index}, groupAddr)
+		}
+		if err != nil && a.ErrorLog != nil {
+			a.ErrorLog(fmt.Errorf("mdns advertiser: %s: %v", ifi.Name, err))
+		}
+	}
+}
+
+// ifaceAddrs returns the IPv4 addresses of ifi.
+func ifaceAddrs(ifi *net.Interface) []net.IP {
+	addrs, _ := ifi.Addrs()
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipn, ok := addr.(*net.IPNet); ok {
+			if ip := ipn.IP.To4(); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+func (a *Advertiser) hostName() string {
+	return a.Host + ".local."
+}
+
+func (a *Advertiser) instanceName(s Service) string {
+	return a.Instance + "." + s.Type + ".local."
+}
+
+// announcement returns an unsolicited response with all the records of the
+// services, with a zero TTL if ttl is false.
+func (a *Advertiser) announcement(addrs []net.IP, ttl bool) ([]byte, error) {
+	var answers []dnsmessage.Resource
+	for _, s := range a.Services {
+		answers = append(answers, a.serviceRecords(s, addrs)...)
+	}
+	answers = append(answers, a.hostRecords(addrs)...)
+	if !ttl {
+		for i := range answers {
+			answers[i].Header.TTL = 0
+		}
+	}
+	return a.pack(dnsmessage.Header{Response: true, Authoritative: true}, nil, answers, nil, false)
+}
+
+// response returns the response to the mDNS query msg, or nil if it does not
+// ask for any of the advertised records.
+func (a *Advertiser) response(msg []byte, addrs []net.IP, legacy bool) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || h.Response {
+		return nil, err
+	}
+	qs, err := p.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+	var answers, additionals []dnsmessage.Resource
+	for _, q := range qs {
+		name := strings.ToLower(q.Name.String())
+		typ := q.Type
+		for _, s := range a.Services {
+			switch {
+			case name == servicesName && (typ == dnsmessage.TypePTR || typ == dnsmessage.TypeALL):
+				answers = append(answers, ptr(servicesName, s.Type+".local."))
+			case name == strings.ToLower(s.Type+".local.") && (typ == dnsmessage.TypePTR || typ == dnsmessage.TypeALL):
+				rrs := a.serviceRecords(s, addrs)
+				answers = append(answers, rrs[0])
+				additionals = append(additionals, rrs[1:3]...)
+				additionals = append(additionals, a.hostRecords(addrs)...)
+			case name == strings.ToLower(a.instanceName(s)):
+				rrs := a.serviceRecords(s, addrs)
+				for _, rr := range rrs[1:3] {
+					if typ == rr.Header.Type || typ == dnsmessage.TypeALL {
+						answers = append(answers, rr)
+					}
+				}
+				additionals = append(additionals, a.hostRecords(addrs)...)
+			}
+		}
+		if name == strings.ToLower(a.hostName()) && (typ == dnsmessage.TypeA || typ == dnsmessage.TypeALL) {
+			answers = append(answers, a.hostRecords(addrs)...)
+		}
+	}
+	if len(answers) == 0 {
+		return nil, nil
+	}
+	rh := dnsmessage.Header{Response: true, Authoritative: true}
+	if legacy {
+		rh.ID = h.ID
+		return a.pack(rh, qs, answers, additionals, true)
+	}
+	return a.pack(rh, nil, answers, additionals, false)
+}
+
+// serviceRecords returns the PTR, SRV, TXT and service enumeration PTR
+// records of s, in this order.
+func (a *Advertiser) serviceRecords(s Service, addrs []net.IP) []dnsmessage.Resource {
+	instance := a.instanceName(s)
+	txt := s.TXT
+	if len(txt) == 0 {
+		// A TXT record is required, even empty (RFC 6763 section 6).
+		txt = []string{""}
+	}
+	return []dnsmessage.Resource{
+		ptr(s.Type+".local.", instance),
+		{
+			Header: dnsmessage.ResourceHeader{
+				Name:  mustName(instance),
+				Type:  dnsmessage.TypeSRV,
+				Class: dnsmessage.ClassINET | cacheFlush,
+				TTL:   hostTTL,
+			},
+			Body: &dnsmessage.SRVResource{Port: s.Port, Target: mustName(a.hostName())},
+		},
+		{
+			Header: dnsmessage.ResourceHeader{
+				Name:  mustName(instance),
+				Type:  dnsmessage.TypeTXT,
+				Class: dnsmessage.ClassINET | cacheFlush,
+				TTL:   otherTTL,
+			},
+			Body: &dnsmessage.TXTResource{TXT: txt},
+		},
+		ptr(servicesName, s.Type+".local."),
+	}
+}
+
+// hostRecords returns the A records of the host.
+func (a *Advertiser) hostRecords(addrs []net.IP) []dnsmessage.Resource {
+	rrs := make([]dnsmessage.Resource, 0, len(addrs))
+	for _, ip := range addrs {
+		var ip4 [4]byte
+		copy(ip4[:], ip.To4())
+		rrs = append(rrs, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{
+				Name:  mustName(a.hostName()),
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET | cacheFlush,
+				TTL:   hostTTL,
+			},
+			Body: &dnsmessage.AResource{A: ip4},
+		})
+	}
+	return rrs
+}
+
+// pack packs a message. In legacy unicast responses, the questions are
+// repeated, the cache flush bit is cleared and TTLs are capped to 10s (RFC
+// 6762 section 6.7).
+func (a *Advertiser) pack(h dnsmessage.Header, qs []dnsmessage.Question, answers, additionals []dnsmessage.Resource, legacy bool) ([]byte, error) {
+	if legacy {
+		for _, rrs := range [][]dnsmessage.Resource{answers, additionals} {
+			for i := range rrs {
+				rrs[i].Header.Class &^= cacheFlush
+				if rrs[i].Header.TTL > 10 {
+					rrs[i].Header.TTL = 10
+				}
+			}
+		}
+	}
+	msg := dnsmessage.Message{
+		Header:      h,
+		Questions:   qs,
+		Answers:     answers,
+		Additionals: additionals,
+	}
+	return msg.Pack()
+}
+
+func ptr(name, target string) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  mustName(name),
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+			TTL:   otherTTL,
+		},
+		Body: &dnsmessage.PTRResource{PTR: mustName(target)},
+	}
+}
+
+// mustName returns name as a dnsmessage.Name. The names are built from the
+// configuration and checked by Validate.
+func mustName(name string) dnsmessage.Name {
+	n, _ := dnsmessage.NewName(name)
+	return n
+}
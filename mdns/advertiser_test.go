@@ -0,0 +1,71 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+func TestAdvertiser_response(t *testing.T) {
+	a := &Advertiser{
+		Instance: "NextDNS on router",
+		Host:     "router",
+		Services: []Service{{Type: "_dns._udp", Port: 53}},
+	}
+	addrs := []net.IP{net.IPv4(192, 168, 1, 1)}
+	tests := []struct {
+		name        string
+		qname       string
+		qtype       dnsmessage.Type
+		legacy      bool
+		wantAnswers []dnsmessage.Type
+		wantTTL     uint32
+	}{
+		{"Browse", "_dns._udp.local.", dnsmessage.TypePTR, false, []dnsmessage.Type{dnsmessage.TypePTR}, otherTTL},
+		{"BrowseCase", "_DNS._udp.local.", dnsmessage.TypePTR, false, []dnsmessage.Type{dnsmessage.TypePTR}, otherTTL},
+		{"Enumerate", "_services._dns-sd._udp.local.", dnsmessage.TypePTR, false, []dnsmessage.Type{dnsmessage.TypePTR}, otherTTL},
+		{"Resolve", "NextDNS on router._dns._udp.local.", dnsmessage.TypeSRV, false, []dnsmessage.Type{dnsmessage.TypeSRV}, hostTTL},
+		{"Host", "router.local.", dnsmessage.TypeA, false, []dnsmessage.Type{dnsmessage.TypeA}, hostTTL},
+		{"Legacy", "_dns._udp.local.", dnsmessage.TypePTR, true, []dnsmessage.Type{dnsmessage.TypePTR}, 10},
+		{"Other", "_http._tcp.local.", dnsmessage.TypePTR, false, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42})
+			_ = b.StartQuestions()
+			_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(tt.qname), Type: tt.qtype, Class: dnsmessage.ClassINET})
+			q, err := b.Finish()
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := a.response(q, addrs, tt.legacy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantAnswers == nil {
+				if resp != nil {
+					t.Errorf("unexpected response")
+				}
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(resp); err != nil {
+				t.Fatal(err)
+			}
+			if tt.legacy != (msg.ID == 42) || tt.legacy != (len(msg.Questions) == 1) {
+				t.Errorf("legacy = %v, got ID %d and %d questions", tt.legacy, msg.ID, len(msg.Questions))
+			}
+			var got []dnsmessage.Type
+			for _, rr := range msg.Answers {
+				got = append(got, rr.Header.Type)
+				if rr.Header.TTL != tt.wantTTL {
+					t.Errorf("%v TTL = %d, want %d", rr.Header.Type, rr.Header.TTL, tt.wantTTL)
+				}
+			}
+			if len(got) != len(tt.wantAnswers) || got[0] != tt.wantAnswers[0] {
+				t.Errorf("answers = %v, want %v", got, tt.wantAnswers)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+// +build !windows
+
+package mdns
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// listen listens on addr with SO_REUSEADDR so the reflector can share the mDNS
+// port with other responders (i.e. avahi).
+func listen(ctx context.Context, network, addr string) (net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			cerr := c.Control(func(fd uintptr) {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	return lc.ListenPacket(ctx, network, addr)
+}
@@ -0,0 +1,11 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+func listen(ctx context.Context, network, addr string) (net.PacketConn, error) {
+	return nil, errors.New("not supported on this platform")
+}
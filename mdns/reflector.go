@@ -0,0 +1,222 @@
+// Package mdns implements a multicast DNS reflector relaying mDNS traffic
+// between network interfaces (i.e. VLANs), and a DNS-SD advertiser
+// publishing the services of the daemon.
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+var groupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Interface is an interface mDNS traffic is reflected from and to.
+type Interface struct {
+	// Name is the name of the network interface.
+	Name string
+
+	// Allow is an optional list of service types (i.e. _googlecast._tcp) or
+	// host names allowed to be reflected from and to this interface. If empty,
+	// all traffic is reflected.
+	Allow []string
+}
+
+// ParseInterface parses an interface definition of the form name or
+// name=service[,service...].
+func ParseInterface(s string) (Interface, error) {
+	i := Interface{Name: s}
+	if idx := strings.IndexByte(s, '='); idx != -1 {
+		i.Name = strings.TrimSpace(s[:idx])
+		for _, a := range strings.Split(s[idx+1:], ",") {
+			a = strings.ToLower(strings.Trim(strings.TrimSpace(a), "."))
+			a = strings.TrimSuffix(a, ".local")
+			if a != "" {
+				i.Allow = append(i.Allow, a)
+			}
+		}
+	}
+	if i.Name == "" {
+		return Interface{}, fmt.Errorf("%s: invalid mdns reflector interface", s)
+	}
+	return i, nil
+}
+
+// allowed returns true if one of the names matches the allow list.
+func (i Interface) allowed(names []string) bool {
+	if len(i.Allow) == 0 {
+		return true
+	}
+	for _, n := range names {
+		n = strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(n), "."), ".local")
+		for _, a := range i.Allow {
+			if n == a || strings.HasSuffix(n, "."+a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reflector relays IPv4 mDNS packets received on one of Interfaces to all the
+// other Interfaces. Legacy unicast queries (not sent from port 5353) are not
+// reflected.
+type Reflector struct {
+	Interfaces []Interface
+
+	// InfoLog specifies an optional log function called when the reflector
+	// starts.
+	InfoLog func(string)
+
+	// ErrorLog specifies an optional log function for errors.
+	ErrorLog func(error)
+}
+
+type iface struct {
+	Interface
+	ifi *net.Interface
+}
+
+// Start runs the reflector until ctx is cancelled.
+func (r *Reflector) Start(ctx context.Context) {
+	if err := r.run(ctx); err != nil && r.ErrorLog != nil {
+		r.ErrorLog(fmt.Errorf("mdns reflector: %w", err))
+	}
+}
+
+func (r *Reflector) run(ctx context.Context) error {
+	if len(r.Interfaces) < 2 {
+		return errors.New("at least two interfaces are required")
+	}
+	ifaces := make([]iface, 0, len(r.Interfaces))
+	local := map[string]bool{}
+	for _, i := range r.Interfaces {
+		ifi, err := net.InterfaceByName(i.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", i.Name, err)
+		}
+		addrs, _ := ifi.Addrs()
+		for _, addr := range addrs {
+			if ipn, ok := addr.(*net.IPNet); ok {
+				local[ipn.IP.String()] = true
+			}
+		}
+		ifaces = append(ifaces, iface{Interface: i, ifi: ifi})
+	}
+
+	c, err := listen(ctx, "udp4", "0.0.0.0:5353")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+	p := ipv4.NewPacketConn(c)
+	for _, i := range ifaces {
+		if err := p.JoinGroup(i.ifi, groupAddr); err != nil {
+			return fmt.Errorf("%s: join group: %v", i.Name, err)
+		}
+	}
+	if err := p.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		return err
+	}
+	_ = p.SetMulticastLoopback(false)
+	_ = p.SetMulticastTTL(255)
+	if r.InfoLog != nil {
+		names := make([]string, 0, len(ifaces))
+		for _, i := range ifaces {
+			names = append(names, i.Name)
+		}
+		r.InfoLog(fmt.Sprintf("mDNS reflector started on %s", strings.Join(names, ", ")))
+	}
+
+	buf := make([]byte, 9000)
+	for {
+		n, cm, src, err := p.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return err
+		}
+		udpSrc, ok := src.(*net.UDPAddr)
+		if cm == nil || !ok || udpSrc.Port != 5353 || local[udpSrc.IP.String()] {
+			continue
+		}
+		in := -1
+		for j, i := range ifaces {
+			if i.ifi.Index == cm.IfIndex {
+				in = j
+				break
+			}
+		}
+		if in == -1 {
+			continue
+		}
+		names, err := packetNames(buf[:n])
+		if err != nil || !ifaces[in].allowed(names) {
+			continue
+		}
+		for j, out := range ifaces {
+			if j == in || !out.allowed(names) {
+				continue
+			}
+			if err := p.SetMulticastInterface(out.ifi); err != nil {
+				continue
+			}
+			if _, err := p.WriteTo(buf[:n], nil, groupAddr); err != nil && r.ErrorLog != nil {
+				r.ErrorLog(fmt.Errorf("mdns reflector: %s: %v", out.Name, err))
+			}
+		}
+	}
+}
+
+// packetNames returns the names of the questions and records in the mDNS
+// packet msg, including PTR targets.
+func packetNames(msg []byte) ([]string, error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return nil, err
+	}
+	qs, err := p.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(qs))
+	for _, q := range qs {
+		names = append(names, q.Name.String())
+	}
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			if err == dnsmessage.ErrSectionDone {
+				break
+			}
+			return nil, err
+		}
+		names = append(names, h.Name.String())
+		if h.Type == dnsmessage.TypePTR {
+			ptr, err := p.PTRResource()
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, ptr.PTR.String())
+			continue
+		}
+		if err := p.SkipAnswer(); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
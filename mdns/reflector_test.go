@@ -0,0 +1,31 @@
+package mdns
+
+import (
+	"testing"
+)
+
+func TestInterface_allowed(t *testing.T) {
+	tests := []struct {
+		def   string
+		names []string
+		want  bool
+	}{
+		{"br-lan", []string{"_printer._tcp.local."}, true},
+		{"br-iot=_googlecast._tcp", []string{"_googlecast._tcp.local."}, true},
+		{"br-iot=_googlecast._tcp", []string{"Living Room._googlecast._tcp.local."}, true},
+		{"br-iot=_googlecast._tcp,_airplay._tcp.local", []string{"tv._airplay._tcp.local."}, true},
+		{"br-iot=_googlecast._tcp", []string{"_printer._tcp.local.", "laptop.local."}, false},
+		{"br-iot=nas", []string{"NAS.local."}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.def, func(t *testing.T) {
+			i, err := ParseInterface(tt.def)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := i.allowed(tt.names); got != tt.want {
+				t.Errorf("allowed(%v) = %v, want %v", tt.names, got, tt.want)
+			}
+		})
+	}
+}
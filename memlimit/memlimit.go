@@ -0,0 +1,113 @@
+// Package memlimit keeps the memory usage of the process under a ceiling by
+// shedding optional state and features in a defined order, so a router does
+// not OOM-kill the daemon in the middle of queries.
+package memlimit
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	// checkInterval is the interval at which the memory usage is checked.
+	checkInterval = 5 * time.Second
+
+	// softRatio is the ratio of Limit above which steps are applied.
+	softRatio = 0.8
+)
+
+// Step is a degradation step freeing memory.
+type Step struct {
+	Name string
+	Shed func()
+}
+
+// Guard checks the memory usage of the process and applies Steps in order,
+// one per check, while the usage is close to Limit. Applied steps are not
+// reverted.
+type Guard struct {
+	// Limit is the memory ceiling in bytes.
+	Limit uint64
+
+	// Steps are the degradation steps, from the least to the most disruptive.
+	Steps []Step
+
+	// OnShed is called after a step is applied with the memory usage that
+	// triggered it.
+	OnShed func(step string, usage uint64)
+
+	// OnExceeded is called once when Limit is exceeded with no step left to
+	// apply.
+	OnExceeded func(usage uint64)
+
+	mu       sync.Mutex
+	next     int
+	exceeded bool
+	usage    func() uint64
+}
+
+// Start checks the memory usage periodically until ctx is cancelled.
+func (g *Guard) Start(ctx context.Context) {
+	t := time.NewTicker(checkInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			g.Check()
+		}
+	}
+}
+
+// Check applies the next step if the memory usage is close to Limit.
+func (g *Guard) Check() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	usage := g.readUsage()
+	if float64(usage) < float64(g.Limit)*softRatio {
+		return
+	}
+	if g.next >= len(g.Steps) {
+		if usage > g.Limit && !g.exceeded {
+			g.exceeded = true
+			if g.OnExceeded != nil {
+				g.OnExceeded(usage)
+			}
+		}
+		return
+	}
+	s := g.Steps[g.next]
+	g.next++
+	s.Shed()
+	// Return the freed memory to the OS right away.
+	debug.FreeOSMemory()
+	if g.OnShed != nil {
+		g.OnShed(s.Name, usage)
+	}
+}
+
+// Applied returns the names of the steps applied so far.
+func (g *Guard) Applied() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var names []string
+	for _, s := range g.Steps[:g.next] {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// readUsage returns the memory obtained from the OS and not released, an
+// approximation of the resident memory of the process.
+func (g *Guard) readUsage() uint64 {
+	if g.usage != nil {
+		return g.usage()
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.Sys - m.HeapReleased
+}
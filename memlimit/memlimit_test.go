@@ -0,0 +1,48 @@
+package memlimit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGuard_Check(t *testing.T) {
+	var usage uint64
+	var shed []string
+	var exceeded uint64
+	g := &Guard{
+		Limit: 100,
+		OnShed: func(step string, usage uint64) {
+			shed = append(shed, step)
+		},
+		OnExceeded: func(usage uint64) {
+			exceeded = usage
+		},
+		usage: func() uint64 { return usage },
+	}
+	for _, name := range []string{"cache", "history", "stats"} {
+		g.Steps = append(g.Steps, Step{Name: name, Shed: func() {}})
+	}
+	tests := []struct {
+		usage    uint64
+		shed     []string
+		exceeded uint64
+	}{
+		{50, nil, 0},
+		{85, []string{"cache"}, 0},
+		{70, []string{"cache"}, 0},
+		{90, []string{"cache", "history"}, 0},
+		{120, []string{"cache", "history", "stats"}, 0},
+		{110, []string{"cache", "history", "stats"}, 110},
+		{130, []string{"cache", "history", "stats"}, 110},
+	}
+	for i, tt := range tests {
+		usage = tt.usage
+		g.Check()
+		if !reflect.DeepEqual(shed, tt.shed) || exceeded != tt.exceeded {
+			t.Errorf("#%d usage %d: shed %v exceeded %d, want %v %d", i, tt.usage, shed, exceeded, tt.shed, tt.exceeded)
+		}
+	}
+	if got := g.Applied(); !reflect.DeepEqual(got, shed) {
+		t.Errorf("Applied() = %v, want %v", got, shed)
+	}
+}
@@ -0,0 +1,186 @@
+package mirror
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Frame Streams control frame types and fields.
+const (
+	fstrmControlAccept = 0x01
+	fstrmControlStart  = 0x02
+	fstrmControlStop   = 0x03
+	fstrmControlReady  = 0x04
+
+	fstrmFieldContentType = 0x01
+)
+
+const dnstapContentType = "protobuf:dnstap.Dnstap"
+
+// dnstap message types.
+const (
+	dnstapClientQuery    = 5
+	dnstapClientResponse = 6
+)
+
+// dnstapWriter writes dnstap messages using the bi-directional Frame Streams
+// protocol.
+type dnstapWriter struct {
+	c net.Conn
+	w *bufio.Writer
+}
+
+func newDnstapWriter(c net.Conn) (*dnstapWriter, error) {
+	w := &dnstapWriter{c: c, w: bufio.NewWriter(c)}
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	defer func() {
+		_ = c.SetDeadline(time.Time{})
+	}()
+	if err := w.writeControl(fstrmControlReady, true); err != nil {
+		return nil, err
+	}
+	if err := readControl(c, fstrmControlAccept); err != nil {
+		return nil, err
+	}
+	if err := w.writeControl(fstrmControlStart, true); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *dnstapWriter) writeControl(typ uint32, contentType bool) error {
+	b := appendUint32(nil, typ)
+	if contentType {
+		b = appendUint32(b, fstrmFieldContentType)
+		b = appendUint32(b, uint32(len(dnstapContentType)))
+		b = append(b, dnstapContentType...)
+	}
+	// Control frames are escaped by a zero length data frame.
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[4:], uint32(len(b)))
+	_, _ = w.w.Write(hdr[:])
+	_, _ = w.w.Write(b)
+	return w.w.Flush()
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// readControl reads a control frame and checks its type.
+func readControl(r io.Reader, typ uint32) error {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(hdr[:4]) != 0 {
+		return errors.New("dnstap: unexpected data frame")
+	}
+	l := binary.BigEndian.Uint32(hdr[4:])
+	if l < 4 || l > 512 {
+		return errors.New("dnstap: invalid control frame")
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if t := binary.BigEndian.Uint32(b); t != typ {
+		return fmt.Errorf("dnstap: unexpected control frame type %d", t)
+	}
+	return nil
+}
+
+func (w *dnstapWriter) write(m Message) error {
+	for _, typ := range []uint64{dnstapClientQuery, dnstapClientResponse} {
+		if typ == dnstapClientResponse && len(m.Response) == 0 {
+			continue
+		}
+		b := encodeDnstap(typ, m)
+		var hdr [4]byte
+		binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+		_, _ = w.w.Write(hdr[:])
+		_, _ = w.w.Write(b)
+	}
+	return w.w.Flush()
+}
+
+func (w *dnstapWriter) close() error {
+	_ = w.writeControl(fstrmControlStop, false)
+	return w.c.Close()
+}
+
+// encodeDnstap encodes m as a dnstap protobuf message of type typ.
+func encodeDnstap(typ uint64, m Message) []byte {
+	var msg []byte
+	msg = appendVarintField(msg, 1, typ)
+	var ip net.IP
+	var port int
+	switch a := m.Peer.(type) {
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	}
+	if ip != nil {
+		family := uint64(2) // INET6
+		if ip4 := ip.To4(); ip4 != nil {
+			family, ip = 1, ip4 // INET
+		}
+		msg = appendVarintField(msg, 2, family)
+		msg = appendBytesField(msg, 4, ip)
+		msg = appendVarintField(msg, 6, uint64(port))
+	}
+	proto := uint64(1) // UDP
+	if m.Protocol == "TCP" || m.Protocol == "UNIX" {
+		proto = 2
+	}
+	msg = appendVarintField(msg, 3, proto)
+	t := m.Time
+	if typ == dnstapClientQuery {
+		msg = appendVarintField(msg, 8, uint64(t.Unix()))
+		msg = appendFixed32Field(msg, 9, uint32(t.Nanosecond()))
+		msg = appendBytesField(msg, 10, m.Query)
+	} else {
+		t = t.Add(m.Duration)
+		msg = appendVarintField(msg, 12, uint64(t.Unix()))
+		msg = appendFixed32Field(msg, 13, uint32(t.Nanosecond()))
+		msg = appendBytesField(msg, 14, m.Response)
+	}
+
+	var b []byte
+	b = appendBytesField(b, 1, []byte("nextdns"))
+	b = appendBytesField(b, 14, msg)
+	b = appendVarintField(b, 15, 1) // MESSAGE
+	return b
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendVarint(b, uint64(field)<<3)
+	return appendVarint(b, v)
+}
+
+func appendFixed32Field(b []byte, field int, v uint32) []byte {
+	b = appendVarint(b, uint64(field)<<3|5)
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendVarint(b, uint64(field)<<3|2)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,205 @@
+// Package mirror implements the asynchronous mirroring of queries to a
+// secondary destination for archival or intrusion detection purposes.
+//
+// Mirroring never affects the answer path: messages are queued and dropped if
+// the destination is slow or unavailable.
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultQueueSize is the default number of messages queued before new
+// messages are dropped.
+const defaultQueueSize = 1000
+
+// Message is a query and its response as received and sent by the proxy.
+type Message struct {
+	Time     time.Time     // time the query was received
+	Duration time.Duration // time taken to answer the query
+	Protocol string        // UDP or TCP
+	Peer     net.Addr
+	Name     string
+	Query    []byte
+	Response []byte
+}
+
+// Mirror sends a copy of queries to Dest.
+type Mirror struct {
+	// Dest is the destination of mirrored queries:
+	//
+	//	192.168.1.5:53                    queries sent to a DNS server over UDP
+	//	dnstap+unix:///var/run/dnstap.sock  dnstap over a unix socket
+	//	dnstap+tcp://192.168.1.5:6000       dnstap over TCP
+	//
+	// Responses of the DNS server are ignored. Queries and responses are
+	// both sent to dnstap collectors.
+	Dest string
+
+	// Domains restricts the mirrored queries to these domains and their
+	// sub-domains. All queries are mirrored if empty.
+	Domains []string
+
+	// QueueSize is the number of messages queued before new messages are
+	// dropped. If zero, 1000 is used.
+	QueueSize int
+
+	// ErrorLog is an optional log function for errors.
+	ErrorLog func(error)
+
+	queue chan Message
+}
+
+// writer sends messages to the destination.
+type writer interface {
+	write(m Message) error
+	close() error
+}
+
+// Init validates the configuration and initializes the queue. It must be
+// called before Send or Start.
+func (m *Mirror) Init() error {
+	if _, _, err := m.parseDest(); err != nil {
+		return err
+	}
+	size := m.QueueSize
+	if size == 0 {
+		size = defaultQueueSize
+	}
+	m.queue = make(chan Message, size)
+	return nil
+}
+
+func (m *Mirror) parseDest() (network, addr string, err error) {
+	switch {
+	case strings.HasPrefix(m.Dest, "dnstap+unix://"):
+		return "dnstap+unix", strings.TrimPrefix(m.Dest, "dnstap+unix://"), nil
+	case strings.HasPrefix(m.Dest, "dnstap+tcp://"):
+		return "dnstap+tcp", strings.TrimPrefix(m.Dest, "dnstap+tcp://"), nil
+	case strings.Contains(m.Dest, "://"):
+		return "", "", fmt.Errorf("%s: unsupported mirror destination", m.Dest)
+	}
+	if _, _, err := net.SplitHostPort(m.Dest); err != nil {
+		return "", "", fmt.Errorf("%s: invalid mirror destination: %v", m.Dest, err)
+	}
+	return "udp", m.Dest, nil
+}
+
+// Send queues msg to be mirrored. It never blocks: msg is dropped if the queue
+// is full. The query and response are copied.
+func (m *Mirror) Send(msg Message) {
+	if m.queue == nil || !m.match(msg.Name) {
+		return
+	}
+	msg.Query = append([]byte(nil), msg.Query...)
+	msg.Response = append([]byte(nil), msg.Response...)
+	select {
+	case m.queue <- msg:
+	default:
+	}
+}
+
+func (m *Mirror) match(name string) bool {
+	if len(m.Domains) == 0 {
+		return true
+	}
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, d := range m.Domains {
+		d = strings.TrimSuffix(strings.ToLower(d), ".")
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start sends queued messages to the destination until ctx is cancelled,
+// reconnecting on errors.
+func (m *Mirror) Start(ctx context.Context) {
+	var w writer
+	defer func() {
+		if w != nil {
+			_ = w.close()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-m.queue:
+			if w == nil {
+				var err error
+				if w, err = m.dial(ctx); err != nil {
+					m.logErr(err)
+					// Drop messages for a while so a down destination does
+					// not cost a connection attempt per query.
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(10 * time.Second):
+					}
+					continue
+				}
+			}
+			if err := w.write(msg); err != nil {
+				m.logErr(err)
+				_ = w.close()
+				w = nil
+			}
+		}
+	}
+}
+
+func (m *Mirror) dial(ctx context.Context) (writer, error) {
+	network, addr, err := m.parseDest()
+	if err != nil {
+		return nil, err
+	}
+	d := &net.Dialer{Timeout: 5 * time.Second}
+	switch network {
+	case "dnstap+unix", "dnstap+tcp":
+		c, err := d.DialContext(ctx, strings.TrimPrefix(network, "dnstap+"), addr)
+		if err != nil {
+			return nil, fmt.Errorf("mirror: %v", err)
+		}
+		w, err := newDnstapWriter(c)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("mirror: %v", err)
+		}
+		return w, nil
+	default:
+		c, err := d.DialContext(ctx, "udp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("mirror: %v", err)
+		}
+		return dnsWriter{c}, nil
+	}
+}
+
+func (m *Mirror) logErr(err error) {
+	if m.ErrorLog != nil {
+		m.ErrorLog(err)
+	}
+}
+
+// dnsWriter sends queries to a DNS server, ignoring its responses.
+type dnsWriter struct {
+	c net.Conn
+}
+
+func (w dnsWriter) write(m Message) error {
+	if len(m.Query) == 0 {
+		return nil
+	}
+	_, err := w.c.Write(m.Query)
+	return err
+}
+
+func (w dnsWriter) close() error {
+	return w.c.Close()
+}
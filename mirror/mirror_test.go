@@ -0,0 +1,85 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMirror_parseDest(t *testing.T) {
+	tests := []struct {
+		dest        string
+		wantNetwork string
+		wantAddr    string
+		wantErr     bool
+	}{
+		{"192.168.1.5:53", "udp", "192.168.1.5:53", false},
+		{"[::1]:5353", "udp", "[::1]:5353", false},
+		{"dnstap+unix:///var/run/dnstap.sock", "dnstap+unix", "/var/run/dnstap.sock", false},
+		{"dnstap+tcp://10.0.0.1:6000", "dnstap+tcp", "10.0.0.1:6000", false},
+		{"192.168.1.5", "", "", true},
+		{"https://example.com", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dest, func(t *testing.T) {
+			m := &Mirror{Dest: tt.dest}
+			network, addr, err := m.parseDest()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDest() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if network != tt.wantNetwork || addr != tt.wantAddr {
+				t.Errorf("parseDest() = %s, %s, want %s, %s", network, addr, tt.wantNetwork, tt.wantAddr)
+			}
+		})
+	}
+}
+
+func TestMirror_match(t *testing.T) {
+	m := &Mirror{Domains: []string{"example.com", "corp.local."}}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"example.com.", true},
+		{"www.Example.com.", true},
+		{"notexample.com.", false},
+		{"host.corp.local.", true},
+		{"nextdns.io.", false},
+	}
+	for _, tt := range tests {
+		if got := m.match(tt.name); got != tt.want {
+			t.Errorf("match(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMirror_UDP(t *testing.T) {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	m := &Mirror{Dest: c.LocalAddr().String()}
+	if err := m.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Start(ctx)
+
+	query := []byte("query")
+	m.Send(Message{Name: "example.com.", Query: query, Response: []byte("response")})
+	query[0] = 'X' // the query must have been copied
+
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 512)
+	n, _, err := c.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], []byte("query")) {
+		t.Errorf("got %q, want %q", buf[:n], "query")
+	}
+}
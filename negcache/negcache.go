@@ -0,0 +1,265 @@
+// Package negcache caches negative answers (NXDOMAIN and NODATA) as described
+// in RFC 2308, so clients hammering names that do not exist do not generate
+// constant upstream traffic.
+package negcache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// DefaultMaxEntries is the number of entries kept when MaxEntries is zero.
+const DefaultMaxEntries = 10000
+
+var errTooLarge = errors.New("cached answer too large")
+
+// Resolver answers the queries for which Upstream returned a negative answer
+// from cache until the negative TTL of the answer expires.
+type Resolver struct {
+	// Upstream is the resolver queries are sent to.
+	Upstream resolver.Resolver
+
+	// MaxTTL caps the time negative answers are cached. The negative TTL of
+	// an answer is the lowest of the TTL and the MINIMUM field of the SOA
+	// record of its authority section.
+	MaxTTL time.Duration
+
+	// MaxEntries is the maximum number of cached answers. DefaultMaxEntries
+	// is used if zero.
+	MaxEntries int
+
+	// ClientKey specifies an optional function returning a key identifying
+	// how Upstream handles the queries of the client of q (i.e. its
+	// configuration). Answers are only shared by queries with the same key.
+	ClientKey func(q resolver.Query) string
+
+	// now is used by tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// entry is a cached negative answer.
+type entry struct {
+	msg     dnsmessage.Message
+	stored  time.Time
+	expires time.Time
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	question, err := p.Question()
+	if err != nil {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	key := r.key(q, question, &p)
+	now := r.timeNow()
+
+	r.mu.Lock()
+	e := r.entries[key]
+	if e != nil && !now.Before(e.expires) {
+		delete(r.entries, key)
+		e = nil
+	}
+	r.mu.Unlock()
+	if t := resolver.TraceFrom(ctx); t != nil && t.NoCache {
+		e = nil
+	}
+	if e != nil {
+		if n, err := e.reply(h.ID, question, now, buf); err == nil {
+			resolver.Tracef(ctx, "negative cache", "hit")
+			return n, resolver.ResolveInfo{Transport: "cache"}, nil
+		}
+	}
+
+	n, i, err := r.Upstream.Resolve(ctx, q, buf)
+	if err != nil || n <= 0 {
+		return n, i, err
+	}
+	if !isNegative(buf[:n]) {
+		return n, i, err
+	}
+	var msg dnsmessage.Message
+	if msg.Unpack(buf[:n]) != nil {
+		return n, i, err
+	}
+	if ttl := r.negativeTTL(msg); ttl > 0 {
+		r.store(key, &entry{msg: msg, stored: now, expires: now.Add(ttl)})
+	}
+	return n, i, err
+}
+
+// Flush removes all the cached answers and returns their number.
+func (r *Resolver) Flush() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.entries)
+	r.entries = nil
+	return n
+}
+
+// Purge removes the expired answers and returns their number.
+func (r *Resolver) Purge() int {
+	now := r.timeNow()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for k, e := range r.entries {
+		if !now.Before(e.expires) {
+			delete(r.entries, k)
+			n++
+		}
+	}
+	return n
+}
+
+// isNegative reports whether the response resp has no answer, without
+// unpacking the whole message.
+func isNegative(resp []byte) bool {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil || (h.RCode != dnsmessage.RCodeNameError && h.RCode != dnsmessage.RCodeSuccess) {
+		return false
+	}
+	if p.SkipAllQuestions() != nil {
+		return false
+	}
+	_, err = p.AnswerHeader()
+	return err == dnsmessage.ErrSectionDone
+}
+
+// key returns the cache key of the query q. Queries with or without EDNS,
+// asking for DNSSEC records or disabling validation get answers of their own.
+func (r *Resolver) key(q resolver.Query, question dnsmessage.Question, p *dnsmessage.Parser) string {
+	flags := "-"
+	if q.Payload[3]&0x10 != 0 { // CD
+		flags = "c"
+	}
+	_ = p.SkipAllQuestions()
+	_ = p.SkipAllAnswers()
+	_ = p.SkipAllAuthorities()
+	for {
+		rh, err := p.AdditionalHeader()
+		if err != nil {
+			break
+		}
+		if rh.Type == dnsmessage.TypeOPT {
+			flags += "e"
+			if rh.DNSSECAllowed() {
+				flags += "d"
+			}
+		}
+		if p.SkipAdditional() != nil {
+			break
+		}
+	}
+	key := strings.ToLower(question.Name.String()) + "|" + question.Type.String() + "|" + question.Class.String() + "|" + flags
+	if r.ClientKey != nil {
+		key = r.ClientKey(q) + "\x00" + key
+	}
+	return key
+}
+
+// negativeTTL returns the time msg can be cached, or 0 if msg is not a
+// cacheable negative answer.
+func (r *Resolver) negativeTTL(msg dnsmessage.Message) time.Duration {
+	if r.MaxTTL <= 0 || msg.Truncated || len(msg.Answers) > 0 {
+		return 0
+	}
+	if msg.RCode != dnsmessage.RCodeNameError && msg.RCode != dnsmessage.RCodeSuccess {
+		return 0
+	}
+	// Negative answers without SOA must not be cached (RFC 2308 section 5),
+	// it also tells NODATA from referrals.
+	for _, rr := range msg.Authorities {
+		soa, ok := rr.Body.(*dnsmessage.SOAResource)
+		if !ok {
+			continue
+		}
+		ttl := rr.Header.TTL
+		if soa.MinTTL < ttl {
+			ttl = soa.MinTTL
+		}
+		d := time.Duration(ttl) * time.Second
+		if d > r.MaxTTL {
+			d = r.MaxTTL
+		}
+		return d
+	}
+	return 0
+}
+
+// store adds e to the cache, making room for it if needed.
+func (r *Resolver) store(key string, e *entry) {
+	max := r.MaxEntries
+	if max <= 0 {
+		max = DefaultMaxEntries
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entries == nil {
+		r.entries = map[string]*entry{}
+	}
+	if _, found := r.entries[key]; !found && len(r.entries) >= max {
+		for k, e2 := range r.entries {
+			if !e.stored.Before(e2.expires) {
+				delete(r.entries, k)
+			}
+		}
+		// Evict a random entry if none expired.
+		for k := range r.entries {
+			if len(r.entries) < max {
+				break
+			}
+			delete(r.entries, k)
+		}
+	}
+	r.entries[key] = e
+}
+
+// reply writes the cached answer to buf with the given ID and question, and
+// its TTLs decremented by the time spent in cache.
+func (e *entry) reply(id uint16, question dnsmessage.Question, now time.Time, buf []byte) (int, error) {
+	msg := e.msg
+	msg.ID = id
+	msg.Questions = []dnsmessage.Question{question}
+	age := uint32(now.Sub(e.stored) / time.Second)
+	msg.Authorities = append([]dnsmessage.Resource(nil), msg.Authorities...)
+	for i := range msg.Authorities {
+		if rh := &msg.Authorities[i].Header; rh.TTL > age {
+			rh.TTL -= age
+		} else {
+			rh.TTL = 0
+		}
+	}
+	// buf is not packed into directly as it can share its memory with the
+	// query, sent upstream if the answer does not fit.
+	b, err := msg.Pack()
+	if err != nil {
+		return -1, err
+	}
+	if len(b) > len(buf) {
+		// I.e. UDP client of an answer first received over TCP.
+		return -1, errTooLarge
+	}
+	return copy(buf, b), nil
+}
+
+func (r *Resolver) timeNow() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
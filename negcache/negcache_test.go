@@ -0,0 +1,146 @@
+package negcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// staticResolver answers all the queries with msg, counting them.
+type staticResolver struct {
+	msg   dnsmessage.Message
+	count int
+}
+
+func (r *staticResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	r.count++
+	var p dnsmessage.Parser
+	h, _ := p.Start(q.Payload)
+	question, _ := p.Question()
+	msg := r.msg
+	msg.ID = h.ID
+	msg.Response = true
+	msg.Questions = []dnsmessage.Question{question}
+	b, err := msg.Pack()
+	if err != nil {
+		return -1, resolver.ResolveInfo{}, err
+	}
+	return copy(buf, b), resolver.ResolveInfo{Transport: "static"}, nil
+}
+
+func newQuery(t *testing.T, id uint16, name string) resolver.Query {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolver.Query{Name: name, Payload: payload}
+}
+
+func soa(ttl, minTTL uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName("example.com."),
+			Type:  dnsmessage.TypeSOA,
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		},
+		Body: &dnsmessage.SOAResource{
+			NS:     dnsmessage.MustNewName("ns.example.com."),
+			MBox:   dnsmessage.MustNewName("admin.example.com."),
+			MinTTL: minTTL,
+		},
+	}
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	a := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName("foo.example.com."),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+			TTL:   300,
+		},
+		Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+	}
+	tests := []struct {
+		name  string
+		msg   dnsmessage.Message
+		after time.Duration // delay of the second query
+		want  int           // upstream queries
+	}{
+		{"NXDOMAIN", dnsmessage.Message{
+			Header:      dnsmessage.Header{RCode: dnsmessage.RCodeNameError},
+			Authorities: []dnsmessage.Resource{soa(300, 60)},
+		}, 30 * time.Second, 1},
+		{"NODATA", dnsmessage.Message{
+			Authorities: []dnsmessage.Resource{soa(300, 60)},
+		}, 30 * time.Second, 1},
+		{"Expired", dnsmessage.Message{
+			Header:      dnsmessage.Header{RCode: dnsmessage.RCodeNameError},
+			Authorities: []dnsmessage.Resource{soa(300, 60)},
+		}, 60 * time.Second, 2},
+		{"SOATTL", dnsmessage.Message{
+			Header:      dnsmessage.Header{RCode: dnsmessage.RCodeNameError},
+			Authorities: []dnsmessage.Resource{soa(10, 60)},
+		}, 30 * time.Second, 2},
+		{"MaxTTL", dnsmessage.Message{
+			Header:      dnsmessage.Header{RCode: dnsmessage.RCodeNameError},
+			Authorities: []dnsmessage.Resource{soa(3600, 3600)},
+		}, 10 * time.Minute, 2},
+		{"NoSOA", dnsmessage.Message{
+			Header: dnsmessage.Header{RCode: dnsmessage.RCodeNameError},
+		}, time.Second, 2},
+		{"Positive", dnsmessage.Message{
+			Answers: []dnsmessage.Resource{a},
+		}, time.Second, 2},
+		{"ServFail", dnsmessage.Message{
+			Header:      dnsmessage.Header{RCode: dnsmessage.RCodeServerFailure},
+			Authorities: []dnsmessage.Resource{soa(300, 60)},
+		}, time.Second, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := &staticResolver{msg: tt.msg}
+			now := time.Now()
+			r := &Resolver{Upstream: up, MaxTTL: 5 * time.Minute, now: func() time.Time { return now }}
+			buf := make([]byte, 512)
+			if _, _, err := r.Resolve(context.Background(), newQuery(t, 1, "foo.example.com."), buf); err != nil {
+				t.Fatal(err)
+			}
+			now = now.Add(tt.after)
+			n, _, err := r.Resolve(context.Background(), newQuery(t, 2, "FOO.example.com."), buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if up.count != tt.want {
+				t.Errorf("upstream queries = %d, want %d", up.count, tt.want)
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if msg.ID != 2 {
+				t.Errorf("response ID = %d, want 2", msg.ID)
+			}
+			if got := msg.Questions[0].Name.String(); got != "FOO.example.com." {
+				t.Errorf("response name = %s, want FOO.example.com.", got)
+			}
+			if up.count == 1 {
+				ttl := tt.msg.Authorities[0].Header.TTL - uint32(tt.after/time.Second)
+				if got := msg.Authorities[0].Header.TTL; got != ttl {
+					t.Errorf("SOA TTL = %d, want %d", got, ttl)
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -26,13 +27,16 @@ var handlers struct {
 
 var cancel context.CancelFunc
 var prevInterfaces []net.Interface
+var prevRoute string
 
 // Notify sends a Change to c any time the network interfaces status change.
 func Notify(c chan<- Change) {
 	handlers.Lock()
 	defer handlers.Unlock()
-	if handlers.c == nil {
-		go startChecker()
+	if len(handlers.c) == 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		go startChecker(ctx)
 	}
 	handlers.c = append(handlers.c, c)
 }
@@ -44,7 +48,7 @@ func Stop(c chan<- Change) {
 	var newC = make([]chan<- Change, 0, len(handlers.c)-1)
 	for _, ch := range handlers.c {
 		if ch != c {
-			newC = append(newC, c)
+			newC = append(newC, ch)
 		}
 	}
 	handlers.c = newC
@@ -58,25 +62,44 @@ func broadcast(c Change) {
 	handlers.Lock()
 	defer handlers.Unlock()
 	for _, ch := range handlers.c {
-		ch <- c
+		// Do not block the checker on slow receivers.
+		select {
+		case ch <- c:
+		default:
+		}
 	}
 }
 
-func startChecker() {
+// settleDelay is the time waited after a change event for other changes of
+// the same burst (i.e. link up, then addresses and routes added).
+const settleDelay = 500 * time.Millisecond
+
+func startChecker(ctx context.Context) {
 	tick := time.NewTicker(10 * time.Second)
 	defer tick.Stop()
-	var ctx context.Context
-	ctx, cancel = context.WithCancel(context.Background())
-	defer cancel()
 	_, _ = changed() // init
+	// Get notified of changes right away when supported by the platform,
+	// polling remains as a fallback.
+	events := make(chan struct{}, 1)
+	go func() {
+		_ = watch(ctx, events)
+	}()
+	var settle <-chan time.Time
 	for {
 		select {
-		case <-tick.C:
-			if c, err := changed(); err == nil && c.Changed() {
-				broadcast(c)
+		case <-events:
+			if settle == nil {
+				settle = time.After(settleDelay)
 			}
+			continue
+		case <-settle:
+			settle = nil
+		case <-tick.C:
 		case <-ctx.Done():
-			break
+			return
+		}
+		if c, err := changed(); err == nil && c.Changed() {
+			broadcast(c)
 		}
 	}
 }
@@ -86,11 +109,34 @@ func changed() (Change, error) {
 	if err != nil {
 		return "", err
 	}
+	newRoute := defaultRoute()
 	c := Change(diff(prevInterfaces, newInterfaces))
+	if !c.Changed() && prevRoute != "" && newRoute != prevRoute {
+		c = Change(fmt.Sprintf("default route %s -> %s", prevRoute, newRoute))
+	}
 	prevInterfaces = newInterfaces
+	prevRoute = newRoute
 	return c, nil
 }
 
+// defaultRoute returns the source addresses selected by the system to reach
+// the Internet over IPv4 and IPv6. They change with the default route, even
+// when no interface changed (i.e. new gateway on the same LAN).
+func defaultRoute() string {
+	var srcs []string
+	for _, dst := range []string{"45.90.28.0:53", "[2a07:a8c0::]:53"} {
+		// Connecting a UDP socket selects a route without sending packets.
+		c, err := net.Dial("udp", dst)
+		if err != nil {
+			srcs = append(srcs, "none")
+			continue
+		}
+		srcs = append(srcs, c.LocalAddr().(*net.UDPAddr).IP.String())
+		c.Close()
+	}
+	return strings.Join(srcs, ",")
+}
+
 func diff(old, new []net.Interface) string {
 	if old == nil || new == nil {
 		return ""
@@ -123,3 +123,39 @@ func Test_diffAddrs(t *testing.T) {
 		})
 	}
 }
+
+func TestNotify(t *testing.T) {
+	a := make(chan Change, 1)
+	b := make(chan Change, 1)
+	Notify(a)
+	Notify(b)
+	Stop(a)
+	broadcast("eth0 up")
+	select {
+	case c := <-b:
+		if c != "eth0 up" {
+			t.Errorf("got change %q, want eth0 up", c)
+		}
+	default:
+		t.Error("change not sent to remaining handler")
+	}
+	select {
+	case c := <-a:
+		t.Errorf("stopped handler got change %q", c)
+	default:
+	}
+
+	// A handler not reading its changes must not block the others.
+	broadcast("eth0 down")
+	broadcast("eth0 up")
+	if c := <-b; c != "eth0 down" {
+		t.Errorf("got change %q, want eth0 down", c)
+	}
+
+	Stop(b)
+	handlers.Lock()
+	defer handlers.Unlock()
+	if len(handlers.c) != 0 || cancel != nil {
+		t.Errorf("checker not stopped: %d handlers", len(handlers.c))
+	}
+}
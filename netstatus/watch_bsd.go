@@ -0,0 +1,19 @@
+// +build darwin freebsd openbsd netbsd dragonfly
+
+package netstatus
+
+import (
+	"context"
+	"syscall"
+)
+
+// watch sends to events each time the routing socket reports a change, until
+// ctx is done.
+func watch(ctx context.Context, events chan<- struct{}) error {
+	fd, err := syscall.Socket(syscall.AF_ROUTE, syscall.SOCK_RAW, syscall.AF_UNSPEC)
+	if err != nil {
+		return err
+	}
+	syscall.CloseOnExec(fd)
+	return readEvents(ctx, fd, "route", events)
+}
@@ -0,0 +1,33 @@
+package netstatus
+
+import (
+	"context"
+	"syscall"
+)
+
+// rtnetlink multicast groups (linux/rtnetlink.h).
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6IfAddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// watch sends to events each time rtnetlink reports a link, address or route
+// change, until ctx is done.
+func watch(ctx context.Context, events chan<- struct{}) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	sa := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv4Route | rtmgrpIPv6IfAddr | rtmgrpIPv6Route,
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	return readEvents(ctx, fd, "netlink", events)
+}
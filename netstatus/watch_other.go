@@ -0,0 +1,13 @@
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly,!windows
+
+package netstatus
+
+import (
+	"context"
+	"errors"
+)
+
+// watch is not supported on this platform, changes are detected by polling.
+func watch(ctx context.Context, events chan<- struct{}) error {
+	return errors.New("network change events not supported on this platform")
+}
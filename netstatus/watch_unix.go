@@ -0,0 +1,39 @@
+// +build linux darwin freebsd openbsd netbsd dragonfly
+
+package netstatus
+
+import (
+	"context"
+	"os"
+	"syscall"
+)
+
+// readEvents sends to events each time a message is read on fd, until ctx is
+// done. The content of messages is ignored: interfaces are compared by the
+// checker.
+func readEvents(ctx context.Context, fd int, name string, events chan<- struct{}) error {
+	// Non blocking so reads are handled by the runtime poller and
+	// interrupted by Close.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	f := os.NewFile(uintptr(fd), name)
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	buf := make([]byte, 8192)
+	for {
+		if _, err := f.Read(buf); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		select {
+		case events <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// +build linux darwin freebsd openbsd netbsd dragonfly
+
+package netstatus
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_readEvents(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan struct{}, 1)
+	done := make(chan error)
+	go func() {
+		done <- readEvents(ctx, fd, "pipe", events)
+	}()
+
+	for i := 0; i < 2; i++ {
+		if _, err := w.Write([]byte("msg")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event after message %d", i)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("readEvents() = %v, want nil on cancel", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("readEvents did not return on cancel")
+	}
+}
@@ -0,0 +1,45 @@
+package netstatus
+
+import (
+	"context"
+	"syscall"
+)
+
+var (
+	iphlpapi              = syscall.NewLazyDLL("iphlpapi.dll")
+	procNotifyAddrChange  = iphlpapi.NewProc("NotifyAddrChange")
+	procNotifyRouteChange = iphlpapi.NewProc("NotifyRouteChange")
+)
+
+// watch sends to events each time an address or route changes, until ctx is
+// done.
+//
+// The synchronous notification calls cannot be interrupted: the goroutines
+// waiting for them exit on the next change after ctx is done.
+func watch(ctx context.Context, events chan<- struct{}) error {
+	if err := procNotifyAddrChange.Find(); err != nil {
+		return err
+	}
+	errs := make(chan error, 2)
+	for _, proc := range []*syscall.LazyProc{procNotifyAddrChange, procNotifyRouteChange} {
+		go func(proc *syscall.LazyProc) {
+			for ctx.Err() == nil {
+				if r, _, _ := proc.Call(0, 0); r != 0 {
+					errs <- syscall.Errno(r)
+					return
+				}
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+			errs <- nil
+		}(proc)
+	}
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
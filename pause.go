@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/nextdns/nextdns/ctl"
+	"github.com/nextdns/nextdns/pause"
+)
+
+// defaultPause is the duration of a pause when none is given.
+const defaultPause = 15 * time.Minute
+
+// pauseCmd pauses or resumes the filtering of the running daemon, for all the
+// clients or one of them.
+func pauseCmd(args []string) error {
+	cmd := args[0]
+	fs := flag.NewFlagSet("nextdns "+cmd, flag.ExitOnError)
+	addr := ctl.DefaultAddr
+	if runtime.GOOS == "windows" {
+		addr = ""
+	}
+	fs.StringVar(&addr, "control", addr, "Path to the control socket of the daemon.")
+	client := fs.String("client", "", "IP or MAC address of the client to "+cmd+" filtering for, all clients if empty.")
+	if cmd == "pause" {
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "Usage: nextdns pause [duration] [-client ip|mac]\n\n")
+			fmt.Fprintf(fs.Output(), "Pause filtering for duration (%v by default, at most %v).\n\n", defaultPause, pause.MaxDuration)
+			fs.PrintDefaults()
+		}
+	}
+	_ = fs.Parse(args[1:])
+	d := defaultPause
+	if cmd == "pause" && fs.NArg() > 0 {
+		// The duration can be followed by flags.
+		var err error
+		if d, err = time.ParseDuration(fs.Arg(0)); err != nil {
+			return withCode(exitUsage, fmt.Errorf("%s: invalid duration", fs.Arg(0)))
+		}
+		_ = fs.Parse(fs.Args()[1:])
+	}
+	if fs.NArg() > 0 {
+		return withCode(exitUsage, fmt.Errorf("%s: unexpected argument", fs.Arg(0)))
+	}
+	if addr == "" {
+		return withCode(exitUsage, errors.New("missing control socket path"))
+	}
+	if *client != "" {
+		c, err := pause.ParseClient(*client)
+		if err != nil {
+			return withCode(exitUsage, err)
+		}
+		*client = c
+	}
+	who := "all clients"
+	if *client != "" {
+		who = *client
+	}
+
+	if cmd == "resume" {
+		data, err := sendControl(addr, "resume", *client)
+		if err != nil {
+			return err
+		}
+		var res struct {
+			Resumed bool `json:"resumed"`
+		}
+		if err := json.Unmarshal(data, &res); err != nil {
+			return err
+		}
+		if jsonOutput {
+			return json.NewEncoder(os.Stdout).Encode(res)
+		}
+		if !res.Resumed {
+			fmt.Printf("Filtering was not paused for %s\n", who)
+			return nil
+		}
+		fmt.Printf("Filtering resumed for %s\n", who)
+		return nil
+	}
+
+	data, err := sendControl(addr, "pause", d.String(), *client)
+	if err != nil {
+		return err
+	}
+	var p pause.Pause
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	if jsonOutput {
+		return json.NewEncoder(os.Stdout).Encode(p)
+	}
+	fmt.Printf("Filtering paused for %s until %s\n", who, p.Until.Local().Format(time.RFC1123))
+	return nil
+}
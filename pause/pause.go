@@ -0,0 +1,157 @@
+// Package pause implements the temporary suspension of filtering, for all the
+// clients or some of them, reverting automatically after a delay.
+package pause
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxDuration is the longest a pause can last.
+const MaxDuration = 24 * time.Hour
+
+// Pause is filtering paused until a time.
+type Pause struct {
+	// Client is the IP or MAC address of the paused client, or empty for
+	// all the clients.
+	Client string    `json:"client,omitempty"`
+	Until  time.Time `json:"until"`
+}
+
+// State holds the active pauses.
+type State struct {
+	mu     sync.Mutex
+	pauses map[string]time.Time
+	// count is the number of pauses, expired ones included, so clients are
+	// checked without locking when there is none.
+	count int32
+	now   func() time.Time
+}
+
+func (s *State) timeNow() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// ParseClient returns the normalized IP or MAC address client.
+func ParseClient(client string) (string, error) {
+	if ip := net.ParseIP(client); ip != nil {
+		return ip.String(), nil
+	}
+	if mac, err := net.ParseMAC(client); err == nil {
+		return mac.String(), nil
+	}
+	return "", fmt.Errorf("%s: invalid client IP or MAC address", client)
+}
+
+// Pause pauses filtering for d for client, an IP or MAC address, or all the
+// clients if empty.
+func (s *State) Pause(client string, d time.Duration) (Pause, error) {
+	if d <= 0 || d > MaxDuration {
+		return Pause{}, fmt.Errorf("%v: duration must be positive and at most %v", d, MaxDuration)
+	}
+	if client != "" {
+		var err error
+		if client, err = ParseClient(client); err != nil {
+			return Pause{}, err
+		}
+	}
+	now := s.timeNow()
+	p := Pause{Client: client, Until: now.Add(d)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pauses == nil {
+		s.pauses = map[string]time.Time{}
+	}
+	s.pruneLocked(now)
+	s.pauses[client] = p.Until
+	atomic.StoreInt32(&s.count, int32(len(s.pauses)))
+	return p, nil
+}
+
+// Resume resumes filtering for client, or all the clients if empty. Resuming
+// all the clients also cancels the pauses of individual clients. It returns
+// false if filtering was not paused.
+func (s *State) Resume(client string) (bool, error) {
+	if client != "" {
+		var err error
+		if client, err = ParseClient(client); err != nil {
+			return false, err
+		}
+	}
+	now := s.timeNow()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(now)
+	_, found := s.pauses[client]
+	if client == "" {
+		found = len(s.pauses) > 0
+		s.pauses = nil
+	} else {
+		delete(s.pauses, client)
+	}
+	atomic.StoreInt32(&s.count, int32(len(s.pauses)))
+	return found, nil
+}
+
+// pruneLocked removes the expired pauses. Must be called with s.mu held.
+func (s *State) pruneLocked(now time.Time) {
+	for k, until := range s.pauses {
+		if !now.Before(until) {
+			delete(s.pauses, k)
+		}
+	}
+}
+
+// Paused returns true if filtering is paused for the client with ip and mac.
+func (s *State) Paused(ip net.IP, mac net.HardwareAddr) bool {
+	if atomic.LoadInt32(&s.count) == 0 {
+		return false
+	}
+	now := s.timeNow()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range [...]string{"", ipKey(ip), macKey(mac)} {
+		if until, found := s.pauses[k]; found && now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
+
+func ipKey(ip net.IP) string {
+	if ip == nil {
+		return "-"
+	}
+	return ip.String()
+}
+
+func macKey(mac net.HardwareAddr) string {
+	if mac == nil {
+		return "-"
+	}
+	return mac.String()
+}
+
+// List returns the active pauses, the global one first.
+func (s *State) List() []Pause {
+	now := s.timeNow()
+	s.mu.Lock()
+	pauses := make([]Pause, 0, len(s.pauses))
+	for client, until := range s.pauses {
+		if now.Before(until) {
+			pauses = append(pauses, Pause{Client: client, Until: until})
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(pauses, func(i, j int) bool {
+		return pauses[i].Client < pauses[j].Client
+	})
+	return pauses
+}
@@ -0,0 +1,68 @@
+package pause
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	s := &State{now: func() time.Time { return now }}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	laptop := net.ParseIP("192.168.1.10")
+	phone := net.ParseIP("192.168.1.11")
+	if s.Paused(laptop, nil) {
+		t.Fatal("paused without pause")
+	}
+	if _, err := s.Pause("192.168.1.10", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pause("AA:BB:CC:DD:EE:FF", 20*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pause("laptop", time.Minute); err == nil {
+		t.Error("invalid client accepted")
+	}
+	if _, err := s.Pause("", 2*MaxDuration); err == nil {
+		t.Error("too long pause accepted")
+	}
+	tests := []struct {
+		name  string
+		after time.Duration
+		ip    net.IP
+		mac   net.HardwareAddr
+		want  bool
+	}{
+		{"IP", 0, laptop, nil, true},
+		{"MAC", 0, phone, mac, true},
+		{"Other", 0, phone, nil, false},
+		{"IPExpired", 10 * time.Minute, laptop, nil, false},
+		{"MACActive", 10 * time.Minute, phone, mac, true},
+		{"MACExpired", 20 * time.Minute, phone, mac, false},
+	}
+	start := now
+	for _, tt := range tests {
+		now = start.Add(tt.after)
+		if got := s.Paused(tt.ip, tt.mac); got != tt.want {
+			t.Errorf("%s: Paused() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	now = start
+	if _, err := s.Pause("", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(s.List()); got != 3 {
+		t.Errorf("List() has %d pauses, want 3", got)
+	}
+	if !s.Paused(phone, nil) {
+		t.Error("global pause not applied")
+	}
+	if found, _ := s.Resume(""); !found {
+		t.Error("Resume() = false, want true")
+	}
+	if s.Paused(laptop, nil) || len(s.List()) != 0 {
+		t.Error("pauses left after resume")
+	}
+}
@@ -0,0 +1,251 @@
+// Package portal implements a simple DNS based network access control: all
+// queries from unknown devices are answered with the address of a local
+// portal until the device MAC address is approved by an administrator.
+package portal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// ttl is the TTL of portal answers, kept low so approved devices do not keep
+// reaching the portal from their cache.
+const ttl = 5
+
+// maxPending is the maximum number of pending devices tracked.
+const maxPending = 1000
+
+// Device is a device seen or approved by the portal.
+type Device struct {
+	MAC       string    `json:"mac"`
+	IP        string    `json:"ip,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+	Approved  time.Time `json:"approved,omitempty"`
+}
+
+// Portal answers queries from devices with no approved MAC address with IP
+// and sends queries from approved devices to Upstream. Queries with no known
+// MAC address (i.e. routed or local clients) are not restricted.
+type Portal struct {
+	// IP is the address of the portal. Can be an IPv4 or IPv6.
+	IP net.IP
+
+	// StatePath is the path of the file storing approved devices.
+	StatePath string
+
+	// DeviceName returns the name of a device, if known. It is called with an
+	// internal lock held and must not block.
+	DeviceName func(ip net.IP, mac net.HardwareAddr) string
+
+	// OnNewDevice is called the first time an unknown device is seen.
+	OnNewDevice func(Device)
+
+	// Upstream is the resolver used for approved devices.
+	Upstream resolver.Resolver
+
+	mu       sync.Mutex
+	approved map[string]Device
+	pending  map[string]Device
+}
+
+// Load reads the approved devices from StatePath.
+func (p *Portal) Load() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.approved = map[string]Device{}
+	if p.StatePath == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(p.StatePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var devices []Device
+	if err := json.Unmarshal(b, &devices); err != nil {
+		return fmt.Errorf("%s: %v", p.StatePath, err)
+	}
+	for _, d := range devices {
+		p.approved[d.MAC] = d
+	}
+	return nil
+}
+
+// save writes the approved devices to StatePath. Must be called with p.mu
+// held.
+func (p *Portal) save() error {
+	if p.StatePath == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(sortDevices(p.approved), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p.StatePath, b, 0600)
+}
+
+// Approve grants access to the device with the given MAC address.
+func (p *Portal) Approve(mac string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+	mac = hw.String()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.approved == nil {
+		p.approved = map[string]Device{}
+	}
+	d, found := p.pending[mac]
+	if !found {
+		d = Device{MAC: mac}
+	}
+	delete(p.pending, mac)
+	d.Approved = time.Now()
+	p.approved[mac] = d
+	return p.save()
+}
+
+// Revoke removes the access of the device with the given MAC address.
+func (p *Portal) Revoke(mac string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+	mac = hw.String()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, found := p.approved[mac]; !found {
+		return fmt.Errorf("%s: not approved", mac)
+	}
+	delete(p.approved, mac)
+	return p.save()
+}
+
+// Approved returns the approved devices.
+func (p *Portal) Approved() []Device {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return sortDevices(p.approved)
+}
+
+// Pending returns the devices seen but not approved.
+func (p *Portal) Pending() []Device {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return sortDevices(p.pending)
+}
+
+func sortDevices(m map[string]Device) []Device {
+	devices := make([]Device, 0, len(m))
+	for _, d := range m {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].MAC < devices[j].MAC
+	})
+	return devices
+}
+
+// Resolve implements resolver.Resolver interface.
+func (p *Portal) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	if q.MAC == nil || p.allowed(q) {
+		return p.Upstream.Resolve(ctx, q, buf)
+	}
+	return p.reply(q, buf)
+}
+
+// allowed returns true if the device sending q is approved. Unknown devices
+// are recorded as pending.
+func (p *Portal) allowed(q resolver.Query) bool {
+	mac := q.MAC.String()
+	now := time.Now()
+	p.mu.Lock()
+	if _, found := p.approved[mac]; found {
+		p.mu.Unlock()
+		return true
+	}
+	if p.pending == nil {
+		p.pending = map[string]Device{}
+	}
+	d, found := p.pending[mac]
+	if !found && len(p.pending) >= maxPending {
+		p.mu.Unlock()
+		return false
+	}
+	if !found {
+		d = Device{MAC: mac, FirstSeen: now}
+		if p.DeviceName != nil {
+			d.Name = strings.TrimSuffix(p.DeviceName(q.PeerIP, q.MAC), ".")
+		}
+	}
+	d.LastSeen = now
+	if q.PeerIP != nil {
+		d.IP = q.PeerIP.String()
+	}
+	p.pending[mac] = d
+	p.mu.Unlock()
+
+	if !found && p.OnNewDevice != nil {
+		p.OnNewDevice(d)
+	}
+	return false
+}
+
+// reply answers q with the portal address for A or AAAA queries matching its
+// family and with an empty answer otherwise.
+func (p *Portal) reply(q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var par dnsmessage.Parser
+	h, err := par.Start(q.Payload)
+	if err != nil {
+		return 0, i, err
+	}
+	q1, err := par.Question()
+	if err != nil {
+		return 0, i, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = dnsmessage.RCodeSuccess
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	_ = b.StartAnswers()
+	hdr := dnsmessage.ResourceHeader{
+		Name:  q1.Name,
+		Type:  q1.Type,
+		Class: q1.Class,
+		TTL:   ttl,
+	}
+	ip4 := p.IP.To4()
+	switch {
+	case q1.Type == dnsmessage.TypeA && ip4 != nil:
+		var a [4]byte
+		copy(a[:], ip4)
+		err = b.AResource(hdr, dnsmessage.AResource{A: a})
+	case q1.Type == dnsmessage.TypeAAAA && ip4 == nil && p.IP != nil:
+		var aaaa [16]byte
+		copy(aaaa[:], p.IP.To16())
+		err = b.AAAAResource(hdr, dnsmessage.AAAAResource{AAAA: aaaa})
+	}
+	if err != nil {
+		return 0, i, err
+	}
+	buf, err = b.Finish()
+	return len(buf), i, err
+}
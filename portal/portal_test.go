@@ -0,0 +1,119 @@
+package portal
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+type nopResolver struct{}
+
+func (nopResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	return 0, resolver.ResolveInfo{Transport: "upstream"}, nil
+}
+
+func query(t *testing.T, mac string) resolver.Query {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := resolver.Query{Name: "example.com.", PeerIP: net.IPv4(192, 168, 0, 2), Payload: payload}
+	if mac != "" {
+		q.MAC, _ = net.ParseMAC(mac)
+	}
+	return q
+}
+
+// resolve returns the answer of p for q or "upstream" if the query was sent
+// upstream.
+func resolve(t *testing.T, p *Portal, q resolver.Query) string {
+	t.Helper()
+	buf := make([]byte, 512)
+	n, i, err := p.Resolve(context.Background(), q, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Transport == "upstream" {
+		return "upstream"
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Answers) != 1 {
+		t.Fatalf("got %d answers, want 1", len(m.Answers))
+	}
+	a := m.Answers[0].Body.(*dnsmessage.AResource).A
+	return net.IP(a[:]).String()
+}
+
+func TestPortal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "portal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const mac = "00:1c:42:2e:60:4a"
+	var seen []Device
+	p := &Portal{
+		IP:          net.IPv4(192, 168, 0, 1),
+		StatePath:   filepath.Join(dir, "state"),
+		OnNewDevice: func(d Device) { seen = append(seen, d) },
+		Upstream:    nopResolver{},
+	}
+	if err := p.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := resolve(t, p, query(t, "")); got != "upstream" {
+		t.Errorf("no MAC: got %s, want upstream", got)
+	}
+	for i := 0; i < 2; i++ {
+		if got := resolve(t, p, query(t, mac)); got != "192.168.0.1" {
+			t.Errorf("unknown MAC: got %s, want portal", got)
+		}
+	}
+	if len(seen) != 1 || seen[0].MAC != mac || seen[0].IP != "192.168.0.2" {
+		t.Errorf("new devices = %+v", seen)
+	}
+	if pending := p.Pending(); len(pending) != 1 {
+		t.Errorf("got %d pending devices, want 1", len(pending))
+	}
+
+	if err := p.Approve("00:1C:42:2E:60:4A"); err != nil {
+		t.Fatal(err)
+	}
+	if got := resolve(t, p, query(t, mac)); got != "upstream" {
+		t.Errorf("approved MAC: got %s, want upstream", got)
+	}
+	if pending := p.Pending(); len(pending) != 0 {
+		t.Errorf("got %d pending devices, want 0", len(pending))
+	}
+
+	// Approvals are persisted.
+	p2 := &Portal{StatePath: p.StatePath, Upstream: nopResolver{}}
+	if err := p2.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if approved := p2.Approved(); len(approved) != 1 || approved[0].MAC != mac {
+		t.Errorf("loaded approved devices = %+v", approved)
+	}
+
+	if err := p.Revoke(mac); err != nil {
+		t.Fatal(err)
+	}
+	if got := resolve(t, p, query(t, mac)); got != "192.168.0.1" {
+		t.Errorf("revoked MAC: got %s, want portal", got)
+	}
+}
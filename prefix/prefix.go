@@ -0,0 +1,163 @@
+// Package prefix tracks the global IPv6 prefixes assigned to the LAN
+// interfaces, which change whenever the ISP rotates the delegated prefix, so
+// local records and reverse lookups can follow them.
+package prefix
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/nextdns/nextdns/netstatus"
+)
+
+// prefixLen is the length of the prefixes tracked. LAN interfaces get a /64
+// out of the delegated prefix.
+const prefixLen = 64
+
+// Tracker tracks the global IPv6 /64 prefixes of a set of interfaces.
+type Tracker struct {
+	// Interfaces are the names of the interfaces to track, in order of
+	// preference.
+	Interfaces []string
+
+	// OnChange is called when the prefixes change.
+	OnChange func(old, new []*net.IPNet)
+
+	mu       sync.RWMutex
+	prefixes []*net.IPNet
+}
+
+// Start updates the prefixes and updates them again on each network change
+// until ctx is cancelled.
+func (t *Tracker) Start(ctx context.Context) {
+	t.Update()
+	ch := make(chan netstatus.Change, 1)
+	netstatus.Notify(ch)
+	defer netstatus.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			t.Update()
+		}
+	}
+}
+
+// Update reads the addresses of the interfaces and updates the prefixes.
+func (t *Tracker) Update() {
+	var prefixes []*net.IPNet
+	for _, name := range t.Interfaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		prefixes = appendPrefixes(prefixes, addrs)
+	}
+	t.set(prefixes)
+}
+
+func (t *Tracker) set(prefixes []*net.IPNet) {
+	t.mu.Lock()
+	old := t.prefixes
+	changed := !equal(old, prefixes)
+	t.prefixes = prefixes
+	t.mu.Unlock()
+	if changed && t.OnChange != nil {
+		t.OnChange(old, prefixes)
+	}
+}
+
+// appendPrefixes appends the /64 prefixes of the global unicast IPv6
+// addresses in addrs, excluding unique local addresses.
+func appendPrefixes(prefixes []*net.IPNet, addrs []net.Addr) []*net.IPNet {
+	mask := net.CIDRMask(prefixLen, 128)
+addrs:
+	for _, addr := range addrs {
+		ipn, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipn.IP
+		if ip.To4() != nil || !ip.IsGlobalUnicast() || ip[0]&0xfe == 0xfc {
+			continue
+		}
+		n := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		for _, p := range prefixes {
+			if p.IP.Equal(n.IP) {
+				continue addrs
+			}
+		}
+		prefixes = append(prefixes, n)
+	}
+	return prefixes
+}
+
+func equal(a, b []*net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].IP.Equal(b[i].IP) {
+			return false
+		}
+	}
+	return true
+}
+
+// Prefixes returns the current prefixes.
+func (t *Tracker) Prefixes() []*net.IPNet {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.prefixes
+}
+
+// Contains returns true if ip is in one of the current prefixes.
+func (t *Tracker) Contains(ip net.IP) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, p := range t.prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRelative returns true if ip is a prefix relative address, an IPv6
+// address with only the interface identifier set (i.e. ::10), other than the
+// unspecified and loopback addresses.
+func IsRelative(ip net.IP) bool {
+	if len(ip) != net.IPv6len || ip.To4() != nil || ip.IsUnspecified() || ip.IsLoopback() {
+		return false
+	}
+	for _, b := range ip[:prefixLen/8] {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Expand returns the prefix relative address ip completed with the first
+// current prefix. Other addresses are returned unchanged. It returns nil if
+// ip is relative and no prefix is known.
+func (t *Tracker) Expand(ip net.IP) net.IP {
+	if !IsRelative(ip) {
+		return ip
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.prefixes) == 0 {
+		return nil
+	}
+	e := make(net.IP, net.IPv6len)
+	copy(e, t.prefixes[0].IP[:prefixLen/8])
+	copy(e[prefixLen/8:], ip[prefixLen/8:])
+	return e
+}
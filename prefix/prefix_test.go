@@ -0,0 +1,73 @@
+package prefix
+
+import (
+	"net"
+	"testing"
+)
+
+func mustIPNet(s string) *net.IPNet {
+	ip, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	n.IP = ip
+	return n
+}
+
+func TestTracker(t *testing.T) {
+	var changes int
+	tr := &Tracker{
+		OnChange: func(old, new []*net.IPNet) {
+			changes++
+		},
+	}
+	if got := tr.Expand(net.ParseIP("::10")); got != nil {
+		t.Errorf("Expand() without prefix = %v, want nil", got)
+	}
+	addrs := []net.Addr{
+		mustIPNet("192.168.1.1/24"),
+		mustIPNet("fe80::1/64"),
+		mustIPNet("fd00::1/64"),
+		mustIPNet("2001:db8:1:2::1/64"),
+		mustIPNet("2001:db8:1:2::2/128"),
+	}
+	tr.set(appendPrefixes(nil, addrs))
+	tr.set(appendPrefixes(nil, addrs))
+	if changes != 1 {
+		t.Errorf("changes = %d, want 1", changes)
+	}
+	if p := tr.Prefixes(); len(p) != 1 || p[0].String() != "2001:db8:1:2::/64" {
+		t.Fatalf("Prefixes() = %v, want [2001:db8:1:2::/64]", p)
+	}
+
+	tests := []struct {
+		ip           string
+		wantExpand   string
+		wantContains bool
+	}{
+		{"::10", "2001:db8:1:2::10", false},
+		{"::211:32ff:fe12:3456", "2001:db8:1:2:211:32ff:fe12:3456", false},
+		{"::1", "::1", false},
+		{"2001:db8:1:2::10", "2001:db8:1:2::10", true},
+		{"2001:db8:9::10", "2001:db8:9::10", false},
+		{"192.168.1.10", "192.168.1.10", false},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if got := tr.Expand(ip).String(); got != tt.wantExpand {
+			t.Errorf("Expand(%s) = %s, want %s", tt.ip, got, tt.wantExpand)
+		}
+		if got := tr.Contains(ip); got != tt.wantContains {
+			t.Errorf("Contains(%s) = %v, want %v", tt.ip, got, tt.wantContains)
+		}
+	}
+
+	// Prefix rotation.
+	tr.set(appendPrefixes(nil, []net.Addr{mustIPNet("2001:db8:5:2::1/64")}))
+	if got := tr.Expand(net.ParseIP("::10")).String(); got != "2001:db8:5:2::10" {
+		t.Errorf("Expand(::10) after rotation = %s, want 2001:db8:5:2::10", got)
+	}
+	if changes != 2 {
+		t.Errorf("changes = %d, want 2", changes)
+	}
+}
@@ -0,0 +1,144 @@
+// Package priority implements a two lane scheduler for upstream queries so
+// queries from low priority clients (i.e. IoT devices) do not slow down
+// interactive clients when the upstream is saturated.
+package priority
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// Resolver limits the number of concurrent queries sent to Upstream. When
+// the limit is reached, queries wait for a slot and queries from low priority
+// clients are only served once no other query is waiting.
+type Resolver struct {
+	// MaxConcurrent is the maximum number of concurrent upstream queries.
+	MaxConcurrent int
+
+	// Clients and MACs are the networks and MAC addresses of low priority
+	// clients.
+	Clients []*net.IPNet
+	MACs    []net.HardwareAddr
+
+	// Upstream is the resolver queries are sent to.
+	Upstream resolver.Resolver
+
+	mu     sync.Mutex
+	active int
+	high   []chan struct{}
+	low    []chan struct{}
+}
+
+// ParseClient parses a low priority client defined as an IP, a CIDR or a MAC
+// address.
+func ParseClient(s string) (*net.IPNet, net.HardwareAddr, error) {
+	if mac, err := net.ParseMAC(s); err == nil {
+		return nil, mac, nil
+	}
+	if strings.IndexByte(s, '/') == -1 {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, nil, fmt.Errorf("%s: invalid client", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: invalid client", s)
+	}
+	return n, nil, nil
+}
+
+// lowPriority returns true if q comes from a low priority client.
+func (r *Resolver) lowPriority(q resolver.Query) bool {
+	for _, n := range r.Clients {
+		if q.PeerIP != nil && n.Contains(q.PeerIP) {
+			return true
+		}
+	}
+	for _, mac := range r.MACs {
+		if q.MAC != nil && mac.String() == q.MAC.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire waits for an upstream slot in the lane of low. It returns an error
+// if ctx is cancelled first.
+func (r *Resolver) acquire(ctx context.Context, low bool) error {
+	r.mu.Lock()
+	if r.active < r.MaxConcurrent && len(r.high) == 0 && (!low || len(r.low) == 0) {
+		r.active++
+		r.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	if low {
+		r.low = append(r.low, ch)
+	} else {
+		r.high = append(r.high, ch)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		r.mu.Lock()
+		removed := remove(&r.high, ch) || remove(&r.low, ch)
+		r.mu.Unlock()
+		if !removed {
+			// The slot was handed over while cancelling, pass it on.
+			r.release()
+		}
+		return ctx.Err()
+	}
+}
+
+// release hands the slot over to the next waiting query, interactive queries
+// first.
+func (r *Resolver) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case len(r.high) > 0:
+		close(r.high[0])
+		r.high = r.high[1:]
+	case len(r.low) > 0:
+		close(r.low[0])
+		r.low = r.low[1:]
+	default:
+		r.active--
+	}
+}
+
+func remove(queue *[]chan struct{}, ch chan struct{}) bool {
+	for i, c := range *queue {
+		if c == ch {
+			*queue = append((*queue)[:i], (*queue)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	if r.MaxConcurrent <= 0 {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	if err = r.acquire(ctx, r.lowPriority(q)); err != nil {
+		return 0, i, err
+	}
+	defer r.release()
+	return r.Upstream.Resolve(ctx, q, buf)
+}
@@ -0,0 +1,79 @@
+package priority
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/resolver"
+)
+
+type blockingResolver struct {
+	mu      sync.Mutex
+	order   []string
+	release chan struct{}
+}
+
+func (r *blockingResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	r.mu.Lock()
+	r.order = append(r.order, q.PeerIP.String())
+	r.mu.Unlock()
+	<-r.release
+	return 0, resolver.ResolveInfo{}, nil
+}
+
+func TestResolver_Priority(t *testing.T) {
+	up := &blockingResolver{release: make(chan struct{})}
+	iot, _, _ := ParseClient("10.0.1.0/24")
+	r := &Resolver{
+		MaxConcurrent: 1,
+		Clients:       []*net.IPNet{iot},
+		Upstream:      up,
+	}
+	var wg sync.WaitGroup
+	query := func(ip string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _ = r.Resolve(context.Background(), resolver.Query{PeerIP: net.ParseIP(ip)}, nil)
+		}()
+		// Let the query reach its lane.
+		time.Sleep(10 * time.Millisecond)
+	}
+	query("10.0.0.1") // takes the only slot
+	query("10.0.1.1") // low priority, waits
+	query("10.0.0.2") // interactive, waits but goes first
+	for i := 0; i < 3; i++ {
+		up.release <- struct{}{}
+	}
+	wg.Wait()
+	want := []string{"10.0.0.1", "10.0.0.2", "10.0.1.1"}
+	for i := range want {
+		if up.order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", up.order, want)
+		}
+	}
+}
+
+func TestResolver_Cancel(t *testing.T) {
+	up := &blockingResolver{release: make(chan struct{})}
+	r := &Resolver{MaxConcurrent: 1, Upstream: up}
+	go func() {
+		_, _, _ = r.Resolve(context.Background(), resolver.Query{PeerIP: net.ParseIP("10.0.0.1")}, nil)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := r.Resolve(ctx, resolver.Query{PeerIP: net.ParseIP("10.0.0.2")}, nil); err != context.DeadlineExceeded {
+		t.Errorf("Resolve() err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	up.release <- struct{}{}
+	time.Sleep(10 * time.Millisecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.active != 0 || len(r.high) != 0 {
+		t.Errorf("active = %d, waiting = %d, want 0, 0", r.active, len(r.high))
+	}
+}
@@ -0,0 +1,156 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BudgetWindow is the period over which the budget of a domain is counted
+// and shared payloads are reported.
+const BudgetWindow = 24 * time.Hour
+
+// maxShared is the maximum number of payloads kept in the report.
+const maxShared = 1000
+
+// Budget limits the query details shared off-box (webhooks, alerts), and
+// keeps a report of what was shared.
+type Budget struct {
+	// DomainCap is the maximum number of times a queried domain can be
+	// shared over BudgetWindow. Once exhausted, the domain is withheld from
+	// the payloads. If zero, domains are not limited.
+	DomainCap int
+
+	// AggregateClients replaces client IPs with their network (/24 for IPv4,
+	// /48 for IPv6), other client identifiers with a pseudonym, and removes
+	// client names.
+	AggregateClients bool
+
+	mu      sync.Mutex
+	key     []byte
+	domains map[string][]time.Time
+	shared  []Shared
+	now     func() time.Time
+}
+
+// Shared is a payload shared off-box.
+type Shared struct {
+	Time        time.Time       `json:"time"`
+	Kind        string          `json:"kind"`
+	Destination string          `json:"destination"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+func (b *Budget) timeNow() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// Domain returns true if name can be shared, consuming one unit of its
+// budget.
+func (b *Budget) Domain(name string) bool {
+	if b.DomainCap <= 0 {
+		return true
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	now := b.timeNow()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.domains == nil {
+		b.domains = map[string][]time.Time{}
+	}
+	times := b.domains[name]
+	for len(times) > 0 && now.Sub(times[0]) >= BudgetWindow {
+		times = times[1:]
+	}
+	if len(times) >= b.DomainCap {
+		b.domains[name] = times
+		return false
+	}
+	b.domains[name] = append(times, now)
+	return true
+}
+
+// Domains returns the names of names that can be shared.
+func (b *Budget) Domains(names []string) []string {
+	if b.DomainCap <= 0 {
+		return names
+	}
+	allowed := make([]string, 0, len(names))
+	for _, name := range names {
+		if b.Domain(name) {
+			allowed = append(allowed, name)
+		}
+	}
+	return allowed
+}
+
+// Client returns the client identifier id as it can be shared.
+func (b *Budget) Client(id string) string {
+	if !b.AggregateClients || id == "" {
+		return id
+	}
+	if ip := net.ParseIP(id); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return (&net.IPNet{IP: ip4.Mask(truncateMask4), Mask: truncateMask4}).String()
+		}
+		return (&net.IPNet{IP: ip.Mask(truncateMask6), Mask: truncateMask6}).String()
+	}
+	b.mu.Lock()
+	if b.key == nil {
+		b.key = make([]byte, 32)
+		_, _ = rand.Read(b.key)
+	}
+	h := hmac.New(sha256.New, b.key)
+	b.mu.Unlock()
+	_, _ = h.Write([]byte(id))
+	return "client-" + hex.EncodeToString(h.Sum(nil)[:4])
+}
+
+// Record adds v, a payload of kind sent to the dest URL, to the report. The
+// destination is reported without its path and query, which may hold
+// credentials.
+func (b *Budget) Record(kind, dest string, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if u, err := url.Parse(dest); err == nil && u.Host != "" {
+		dest = u.Scheme + "://" + u.Host
+	}
+	now := b.timeNow()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, times := range b.domains {
+		if now.Sub(times[len(times)-1]) >= BudgetWindow {
+			delete(b.domains, name)
+		}
+	}
+	if len(b.shared) >= maxShared {
+		b.shared = append(b.shared[:0], b.shared[1:]...)
+	}
+	b.shared = append(b.shared, Shared{Time: now, Kind: kind, Destination: dest, Payload: payload})
+}
+
+// Report returns the payloads shared over the last BudgetWindow, the oldest
+// first.
+func (b *Budget) Report() []Shared {
+	now := b.timeNow()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := 0
+	for i < len(b.shared) && now.Sub(b.shared[i].Time) >= BudgetWindow {
+		i++
+	}
+	b.shared = append(b.shared[:0], b.shared[i:]...)
+	return append([]Shared{}, b.shared...)
+}
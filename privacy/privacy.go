@@ -0,0 +1,123 @@
+// Package privacy implements the anonymization and exclusion of the queries
+// recorded in local logs, and the budget of the query details shared off-box.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/nextdns/nextdns/proxy"
+)
+
+// Anonymization modes.
+const (
+	// AnonymizeTruncate keeps the network part of client IPs only (/24 for
+	// IPv4, /48 for IPv6).
+	AnonymizeTruncate = "truncate"
+	// AnonymizeHash replaces client IPs with a pseudonymous IPv6 unique local
+	// address derived from a keyed hash. The key is random and changes every
+	// time the daemon starts.
+	AnonymizeHash = "hash"
+)
+
+var (
+	truncateMask4 = net.CIDRMask(24, 32)
+	truncateMask6 = net.CIDRMask(48, 128)
+)
+
+// Policy defines how queries are recorded in local logs.
+type Policy struct {
+	// Anonymize is the anonymization mode of the client identities. If
+	// empty, clients are logged as is.
+	Anonymize string
+
+	// Exclude is a list of domains which queries, sub-domains included, are
+	// not logged.
+	Exclude []string
+
+	once    sync.Once
+	key     []byte
+	exclude map[string]struct{}
+}
+
+// Validate returns an error if the policy is invalid.
+func (p *Policy) Validate() error {
+	switch p.Anonymize {
+	case "", AnonymizeTruncate, AnonymizeHash:
+		return nil
+	}
+	return fmt.Errorf("%s: invalid anonymization mode", p.Anonymize)
+}
+
+// Apply returns q as it must be logged, or false if it must not be logged.
+// Anonymized queries lose their MAC address and device information.
+func (p *Policy) Apply(q proxy.QueryInfo) (proxy.QueryInfo, bool) {
+	p.once.Do(p.init)
+	if p.excluded(q.Name) {
+		return q, false
+	}
+	if p.Anonymize == "" {
+		return q, true
+	}
+	q.PeerIP = p.IP(q.PeerIP)
+	q.MAC = nil
+	q.DeviceName = ""
+	q.DeviceModel = ""
+	return q, true
+}
+
+// IP returns the anonymized ip.
+func (p *Policy) IP(ip net.IP) net.IP {
+	p.once.Do(p.init)
+	if ip == nil {
+		return nil
+	}
+	switch p.Anonymize {
+	case AnonymizeTruncate:
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(truncateMask4)
+		}
+		return ip.Mask(truncateMask6)
+	case AnonymizeHash:
+		h := hmac.New(sha256.New, p.key)
+		_, _ = h.Write(ip.To16())
+		sum := h.Sum(nil)
+		pseudo := make(net.IP, net.IPv6len)
+		pseudo[0] = 0xfd
+		copy(pseudo[1:], sum)
+		return pseudo
+	}
+	return ip
+}
+
+func (p *Policy) init() {
+	p.key = make([]byte, 32)
+	_, _ = rand.Read(p.key)
+	p.exclude = map[string]struct{}{}
+	for _, d := range p.Exclude {
+		p.exclude[strings.ToLower(strings.Trim(d, "."))] = struct{}{}
+	}
+}
+
+// excluded returns true if name or one of its parent domains is excluded.
+func (p *Policy) excluded(name string) bool {
+	if len(p.exclude) == 0 {
+		return false
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for {
+		if _, found := p.exclude[name]; found {
+			return true
+		}
+		idx := strings.IndexByte(name, '.')
+		if idx == -1 {
+			return false
+		}
+		name = name[idx+1:]
+	}
+}
@@ -0,0 +1,52 @@
+package privacy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/proxy"
+)
+
+func TestPolicy_Apply(t *testing.T) {
+	mac, _ := net.ParseMAC("28:a0:2b:56:e9:66")
+	tests := []struct {
+		name   string
+		policy *Policy
+		qname  string
+		ip     string
+		want   string
+		logged bool
+	}{
+		{"None", &Policy{}, "example.com.", "192.168.1.23", "192.168.1.23", true},
+		{"TruncateIPv4", &Policy{Anonymize: AnonymizeTruncate}, "example.com.", "192.168.1.23", "192.168.1.0", true},
+		{"TruncateIPv6", &Policy{Anonymize: AnonymizeTruncate}, "example.com.", "2001:db8:1:2::23", "2001:db8:1::", true},
+		{"Hash", &Policy{Anonymize: AnonymizeHash}, "example.com.", "192.168.1.23", "fd", true},
+		{"Excluded", &Policy{Exclude: []string{"health.example"}}, "www.health.example.", "192.168.1.23", "", false},
+		{"NotExcluded", &Policy{Exclude: []string{"health.example"}}, "myhealth.example.", "192.168.1.23", "192.168.1.23", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := proxy.QueryInfo{Name: tt.qname, PeerIP: net.ParseIP(tt.ip), MAC: mac, DeviceName: "laptop"}
+			got, logged := tt.policy.Apply(q)
+			if logged != tt.logged {
+				t.Fatalf("Apply() logged = %v, want %v", logged, tt.logged)
+			}
+			if !logged {
+				return
+			}
+			if tt.policy.Anonymize == AnonymizeHash {
+				if got.PeerIP[0] != 0xfd || got.PeerIP.Equal(tt.policy.IP(net.ParseIP("192.168.1.24"))) {
+					t.Errorf("Apply() PeerIP = %v, want a pseudonym", got.PeerIP)
+				}
+				if again, _ := tt.policy.Apply(q); !again.PeerIP.Equal(got.PeerIP) {
+					t.Errorf("Apply() PeerIP = %v, then %v", got.PeerIP, again.PeerIP)
+				}
+			} else if got.PeerIP.String() != tt.want {
+				t.Errorf("Apply() PeerIP = %v, want %v", got.PeerIP, tt.want)
+			}
+			if anonymized := got.MAC == nil && got.DeviceName == ""; anonymized != (tt.policy.Anonymize != "") {
+				t.Errorf("Apply() MAC = %v, DeviceName = %q", got.MAC, got.DeviceName)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Package privaterelay detects devices checking if iCloud Private Relay can be
+// used on the network, and can tell them it cannot so their queries keep
+// following the network DNS policy.
+package privaterelay
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// Actions taken on Private Relay lookups.
+const (
+	// ActionLog only reports the lookups.
+	ActionLog = "log"
+
+	// ActionBlock answers the lookups with NXDOMAIN, which tells the devices
+	// Private Relay is not allowed on the network.
+	ActionBlock = "block"
+)
+
+// Names are the names looked up by Apple devices before using Private Relay.
+// See https://developer.apple.com/support/prepare-your-network-for-icloud-private-relay.
+var Names = []string{
+	"mask.icloud.com.",
+	"mask-h2.icloud.com.",
+}
+
+// ReportInterval is the minimum interval between two detections reported for
+// the same client.
+const ReportInterval = time.Hour
+
+// maxTracked is the maximum number of clients tracked before the reported
+// detections are forgotten.
+const maxTracked = 10000
+
+// Detection is reported when a client looks up one of Names, at most once
+// per ReportInterval.
+type Detection struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	Name   string    `json:"name"`
+	Action string    `json:"action"`
+}
+
+func (d Detection) String() string {
+	if d.Action == ActionBlock {
+		return fmt.Sprintf("iCloud Private Relay lookup from %s (%s): blocked, the device will not use Private Relay on this network", d.Client, d.Name)
+	}
+	return fmt.Sprintf("iCloud Private Relay lookup from %s (%s): allowed, the device may bypass this resolver", d.Client, d.Name)
+}
+
+// Resolver reports and optionally blocks the lookups of Names before sending
+// queries to Upstream.
+type Resolver struct {
+	// Action is the action taken on Private Relay lookups. Default is
+	// ActionLog.
+	Action string
+
+	// OnDetect is called when a client looks up one of Names, at most once
+	// per ReportInterval.
+	OnDetect func(Detection)
+
+	// Upstream is the resolver used to resolve queries.
+	Upstream resolver.Resolver
+
+	mu       sync.Mutex
+	reported map[string]time.Time
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	if !match(q.Name) {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	action := r.Action
+	if action != ActionBlock {
+		action = ActionLog
+	}
+	r.report(q, action)
+	if action == ActionBlock {
+		return replyNXDomain(q, buf)
+	}
+	return r.Upstream.Resolve(ctx, q, buf)
+}
+
+func match(name string) bool {
+	name = strings.ToLower(name)
+	for _, n := range Names {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// report calls OnDetect if the client of q was not reported in the last
+// ReportInterval.
+func (r *Resolver) report(q resolver.Query, action string) {
+	if r.OnDetect == nil {
+		return
+	}
+	client := q.PeerIP.String()
+	now := time.Now()
+	r.mu.Lock()
+	if last, found := r.reported[client]; found && now.Sub(last) < ReportInterval {
+		r.mu.Unlock()
+		return
+	}
+	if r.reported == nil || len(r.reported) >= maxTracked {
+		r.reported = map[string]time.Time{}
+	}
+	r.reported[client] = now
+	r.mu.Unlock()
+	r.OnDetect(Detection{
+		Time:   now,
+		Client: client,
+		Name:   strings.TrimSuffix(strings.ToLower(q.Name), "."),
+		Action: action,
+	})
+}
+
+func replyNXDomain(q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return -1, i, err
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return -1, i, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = dnsmessage.RCodeNameError
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	buf, err = b.Finish()
+	return len(buf), i, err
+}
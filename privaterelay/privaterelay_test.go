@@ -0,0 +1,80 @@
+package privaterelay
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+type countResolver struct {
+	count int
+}
+
+func (r *countResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	r.count++
+	return copy(buf, q.Payload), resolver.ResolveInfo{}, nil
+}
+
+func newQuery(t *testing.T, name string) resolver.Query {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolver.Query{Name: name, PeerIP: net.ParseIP("192.168.1.2"), Payload: payload}
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	tests := []struct {
+		name         string
+		action       string
+		qname        string
+		wantUpstream int
+		wantReports  int
+	}{
+		{"Log", ActionLog, "mask.icloud.com.", 2, 1},
+		{"Block", ActionBlock, "MASK-H2.icloud.com.", 0, 1},
+		{"Other", ActionBlock, "www.icloud.com.", 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := &countResolver{}
+			var reports int
+			r := &Resolver{
+				Action:   tt.action,
+				Upstream: up,
+				OnDetect: func(d Detection) { reports++ },
+			}
+			for i := 0; i < 2; i++ {
+				buf := make([]byte, 512)
+				n, _, err := r.Resolve(context.Background(), newQuery(t, tt.qname), buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var p dnsmessage.Parser
+				h, err := p.Start(buf[:n])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if blocked := h.RCode == dnsmessage.RCodeNameError; blocked != (tt.wantUpstream == 0) {
+					t.Errorf("rcode = %v", h.RCode)
+				}
+			}
+			if up.count != tt.wantUpstream {
+				t.Errorf("upstream queries = %d, want %d", up.count, tt.wantUpstream)
+			}
+			if reports != tt.wantReports {
+				t.Errorf("reports = %d, want %d", reports, tt.wantReports)
+			}
+		})
+	}
+}
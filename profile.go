@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+
+	"github.com/nextdns/nextdns/ctl"
+)
+
+const (
+	// defaultProfileDuration is the duration of the profiles sampled over
+	// time when none is given.
+	defaultProfileDuration = 30 * time.Second
+
+	// maxProfileDuration is the longest a profile can be sampled for.
+	maxProfileDuration = 5 * time.Minute
+)
+
+// timedProfiles are the profiles sampled over a duration, the others being
+// snapshots of the daemon state.
+var timedProfiles = map[string]bool{
+	"cpu":   true,
+	"trace": true,
+	"block": true,
+	"mutex": true,
+}
+
+// profileRunning holds a token while a timed profile is sampled, as the
+// runtime only supports one at a time.
+var profileRunning = make(chan struct{}, 1)
+
+// captureProfile returns the profile name of the running process in the pprof
+// format (the runtime trace format for trace), sampled for d for the timed
+// profiles.
+func captureProfile(name string, d time.Duration) ([]byte, error) {
+	if d <= 0 || d > maxProfileDuration {
+		return nil, fmt.Errorf("%v: duration must be positive and at most %v", d, maxProfileDuration)
+	}
+	if !timedProfiles[name] {
+		p := pprof.Lookup(name)
+		if p == nil {
+			return nil, fmt.Errorf("%s: unknown profile", name)
+		}
+		var buf bytes.Buffer
+		err := p.WriteTo(&buf, 0)
+		return buf.Bytes(), err
+	}
+	select {
+	case profileRunning <- struct{}{}:
+		defer func() { <-profileRunning }()
+	default:
+		return nil, errors.New("a profile is already running")
+	}
+	var buf bytes.Buffer
+	switch name {
+	case "cpu":
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			return nil, err
+		}
+		time.Sleep(d)
+		pprof.StopCPUProfile()
+	case "trace":
+		if err := trace.Start(&buf); err != nil {
+			return nil, err
+		}
+		time.Sleep(d)
+		trace.Stop()
+	case "block":
+		// Block and mutex events are only recorded while enabled.
+		runtime.SetBlockProfileRate(1)
+		time.Sleep(d)
+		runtime.SetBlockProfileRate(0)
+		if err := pprof.Lookup("block").WriteTo(&buf, 0); err != nil {
+			return nil, err
+		}
+	case "mutex":
+		prev := runtime.SetMutexProfileFraction(1)
+		time.Sleep(d)
+		runtime.SetMutexProfileFraction(prev)
+		if err := pprof.Lookup("mutex").WriteTo(&buf, 0); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// runtimeMetrics returns the memory, garbage collector and scheduler metrics
+// of the running process.
+func runtimeMetrics() map[string]interface{} {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var lastGC string
+	if m.LastGC > 0 {
+		lastGC = time.Unix(0, int64(m.LastGC)).UTC().Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"goroutines":     runtime.NumGoroutine(),
+		"gomaxprocs":     runtime.GOMAXPROCS(0),
+		"sys_mb":         m.Sys >> 20,
+		"rss_mb":         (m.Sys - m.HeapReleased) >> 20,
+		"heap_alloc_mb":  m.HeapAlloc >> 20,
+		"heap_objects":   m.HeapObjects,
+		"stack_mb":       m.StackSys >> 20,
+		"gc_count":       m.NumGC,
+		"gc_cpu_percent": m.GCCPUFraction * 100,
+		"gc_pause_ms":    float64(m.PauseNs[(m.NumGC+255)%256]) / 1e6,
+		"last_gc":        lastGC,
+	}
+}
+
+// profileCmd collects a profile of the running daemon through its control
+// socket and writes it to a file.
+func profileCmd(args []string) error {
+	fs := flag.NewFlagSet("nextdns profile", flag.ExitOnError)
+	addr := ctl.DefaultAddr
+	if runtime.GOOS == "windows" {
+		addr = ""
+	}
+	fs.StringVar(&addr, "control", addr, "Path to the control socket of the daemon.")
+	out := fs.String("o", "", "File to write the profile to. Defaults to nextdns-TYPE-TIME.pprof in the\ncurrent directory.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: nextdns profile <type> [duration] [-o file]\n\n")
+		fmt.Fprintf(fs.Output(), "Collect a profile of the running daemon, to analyze with go tool pprof (go tool\n")
+		fmt.Fprintf(fs.Output(), "trace for trace). Types:\n\n")
+		fmt.Fprintf(fs.Output(), "  cpu, trace, block, mutex          sampled for duration (%v by default)\n", defaultProfileDuration)
+		fmt.Fprintf(fs.Output(), "  heap, allocs, goroutine, threadcreate\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return withCode(exitUsage, errors.New("missing profile type"))
+	}
+	name := fs.Arg(0)
+	d := defaultProfileDuration
+	if fs.NArg() > 1 {
+		var err error
+		if d, err = time.ParseDuration(fs.Arg(1)); err != nil || !timedProfiles[name] {
+			return withCode(exitUsage, fmt.Errorf("%s: invalid duration", fs.Arg(1)))
+		}
+		// The duration can be followed by flags.
+		_ = fs.Parse(fs.Args()[2:])
+		if fs.NArg() > 0 {
+			return withCode(exitUsage, fmt.Errorf("%s: unexpected argument", fs.Arg(0)))
+		}
+	}
+	if addr == "" {
+		return withCode(exitUsage, errors.New("missing control socket path"))
+	}
+	if timedProfiles[name] {
+		fmt.Fprintf(os.Stderr, "Sampling %s profile for %v...\n", name, d)
+	}
+	data, err := sendControl(addr, "pprof", name, d.String())
+	if err != nil {
+		return err
+	}
+	var profile []byte
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return err
+	}
+	file := *out
+	if file == "" {
+		ext := "pprof"
+		if name == "trace" {
+			ext = "trace"
+		}
+		file = fmt.Sprintf("nextdns-%s-%s.%s", name, time.Now().Format("20060102-150405"), ext)
+	}
+	if err := ioutil.WriteFile(file, profile, 0644); err != nil {
+		return err
+	}
+	tool := "pprof"
+	if name == "trace" {
+		tool = "trace"
+	}
+	fmt.Printf("Wrote %s profile to %s (%d bytes), analyze with: go tool %s %s\n", name, file, len(profile), tool, file)
+	return nil
+}
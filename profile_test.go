@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/ctl"
+)
+
+// gzipMagic prefixes the profiles in the pprof format.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func Test_captureProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		d       time.Duration
+		wantErr bool
+	}{
+		{"heap", time.Second, false},
+		{"goroutine", time.Second, false},
+		{"cpu", 50 * time.Millisecond, false},
+		{"block", 10 * time.Millisecond, false},
+		{"mutex", 10 * time.Millisecond, false},
+		{"foo", time.Second, true},
+		{"heap", 0, true},
+		{"cpu", maxProfileDuration + time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.d.String(), func(t *testing.T) {
+			b, err := captureProfile(tt.name, tt.d)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("captureProfile() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.HasPrefix(b, gzipMagic) {
+				t.Errorf("captureProfile() returned %d bytes, not a pprof profile", len(b))
+			}
+		})
+	}
+}
+
+func Test_captureProfile_Concurrent(t *testing.T) {
+	profileRunning <- struct{}{}
+	defer func() { <-profileRunning }()
+	if _, err := captureProfile("cpu", 10*time.Millisecond); err == nil {
+		t.Error("captureProfile() succeeded while a profile is running")
+	}
+	// Snapshots do not conflict with timed profiles.
+	if _, err := captureProfile("heap", time.Second); err != nil {
+		t.Errorf("captureProfile(heap) = %v", err)
+	}
+}
+
+func Test_runtimeMetrics(t *testing.T) {
+	m := runtimeMetrics()
+	for _, k := range []string{"goroutines", "gomaxprocs", "sys_mb", "rss_mb", "heap_alloc_mb",
+		"heap_objects", "stack_mb", "gc_count", "gc_cpu_percent", "gc_pause_ms", "last_gc"} {
+		if _, found := m[k]; !found {
+			t.Errorf("runtimeMetrics() misses %s", k)
+		}
+	}
+	if n, _ := m["goroutines"].(int); n < 1 {
+		t.Errorf("goroutines = %v", m["goroutines"])
+	}
+}
+
+func Test_profileCmd(t *testing.T) {
+	dir := t.TempDir()
+	s := &ctl.Server{Addr: filepath.Join(dir, "ctl.sock")}
+	setupProfiling(&proxySvc{ctl: s, log: &recordLogger{}})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	out := filepath.Join(dir, "heap.pprof")
+	if err := profileCmd([]string{"profile", "-control", s.Addr, "-o", out, "heap"}); err != nil {
+		t.Fatalf("profileCmd(heap) = %v", err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, gzipMagic) {
+		t.Error("profileCmd(heap) wrote an invalid profile")
+	}
+
+	out = filepath.Join(dir, "cpu.pprof")
+	if err := profileCmd([]string{"profile", "-control", s.Addr, "cpu", "50ms", "-o", out}); err != nil {
+		t.Fatalf("profileCmd(cpu) = %v", err)
+	}
+	if _, err := ioutil.ReadFile(out); err != nil {
+		t.Errorf("profileCmd(cpu) did not write the profile: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"profile", "-control", s.Addr},
+		{"profile", "-control", s.Addr, "heap", "1s"},
+		{"profile", "-control", s.Addr, "cpu", "soon"},
+		{"profile", "-control", s.Addr, "cpu", "1s", "extra"},
+	} {
+		if err := profileCmd(args); exitCodeOf(err) != exitUsage {
+			t.Errorf("profileCmd(%q) = %v, want a usage error", args[3:], err)
+		}
+	}
+	err = profileCmd([]string{"profile", "-control", filepath.Join(dir, "none.sock"), "heap"})
+	if exitCodeOf(err) != exitNotRunning {
+		t.Errorf("profileCmd() without daemon = %v, want %v", err, exitNotRunning)
+	}
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// privateNets are the networks matched by the private ACL keyword.
+var privateNets = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"169.254.0.0/16",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// errACLDenied is returned by the ACL handler for dropped queries so no
+// response is sent.
+var errACLDenied = errors.New("query denied by ACL")
+
+// ACL restricts the clients allowed to send queries to some listeners.
+// Queries from the loopback interface are always allowed.
+type ACL struct {
+	// Addr is the listen address, as found in Proxy.Addr, the ACL applies to.
+	// If empty, the ACL applies to all listeners.
+	Addr string
+
+	// Allow is the list of networks allowed to send queries.
+	Allow []*net.IPNet
+
+	// allow keeps the allow list as defined for String.
+	allow []string
+}
+
+// ParseACL parses an ACL definition in the [ADDR=]NET[,NET...] format where
+// NET can be a CIDR, an IP or private for private and link-local networks.
+func ParseACL(s string) (ACL, error) {
+	var acl ACL
+	nets := s
+	if idx := strings.IndexByte(s, '='); idx != -1 {
+		acl.Addr, nets = s[:idx], s[idx+1:]
+		if _, _, err := net.SplitHostPort(acl.Addr); err != nil {
+			return ACL{}, fmt.Errorf("%s: invalid ACL address: %v", s, err)
+		}
+	}
+	for _, n := range strings.Split(nets, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		acl.allow = append(acl.allow, n)
+		cidrs := []string{n}
+		if n == "private" {
+			cidrs = privateNets
+		} else if strings.IndexByte(n, '/') == -1 {
+			if ip := net.ParseIP(n); ip != nil && ip.To4() != nil {
+				cidrs = []string{n + "/32"}
+			} else {
+				cidrs = []string{n + "/128"}
+			}
+		}
+		for _, c := range cidrs {
+			_, ipn, err := net.ParseCIDR(c)
+			if err != nil {
+				return ACL{}, fmt.Errorf("%s: invalid ACL network", n)
+			}
+			acl.Allow = append(acl.Allow, ipn)
+		}
+	}
+	if len(acl.Allow) == 0 {
+		return ACL{}, fmt.Errorf("%s: invalid ACL: missing networks", s)
+	}
+	return acl, nil
+}
+
+func (acl ACL) String() string {
+	s := strings.Join(acl.allow, ",")
+	if acl.Addr != "" {
+		s = acl.Addr + "=" + s
+	}
+	return s
+}
+
+// allowed returns true if ip is allowed to send queries.
+func (acl ACL) allowed(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() {
+		return true
+	}
+	for _, n := range acl.Allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// aclListener is a Listener only serving queries allowed by its ACLs.
+type aclListener struct {
+	Listener
+	acls []ACL
+	drop bool
+}
+
+// Serve implements Listener interface.
+func (l aclListener) Serve(h Handler) error {
+	return l.Listener.Serve(aclHandler{Handler: h, acls: l.acls, drop: l.drop})
+}
+
+// aclHandler is a Handler refusing or dropping queries from clients not
+// allowed by any of its ACLs.
+type aclHandler struct {
+	Handler
+	acls []ACL
+	drop bool
+}
+
+// ServeDNS implements Handler interface.
+func (h aclHandler) ServeDNS(protocol string, peer net.Addr, buf []byte, qsize int) (rsize int, err error) {
+	ip := addrIP(peer)
+	for _, acl := range h.acls {
+		if acl.allowed(ip) {
+			return h.Handler.ServeDNS(protocol, peer, buf, qsize)
+		}
+	}
+	if h.drop {
+		return 0, errACLDenied
+	}
+	return replyRefused(buf, qsize)
+}
+
+// withACLs wraps l with the ACLs of p applying to the listen address addr.
+// ACLs defined for addr take precedence over the ones applying to all
+// listeners.
+func (p Proxy) withACLs(l Listener, addr string) Listener {
+	var acls, global []ACL
+	for _, acl := range p.ACLs {
+		switch acl.Addr {
+		case "":
+			global = append(global, acl)
+		case addr:
+			acls = append(acls, acl)
+		}
+	}
+	if len(acls) == 0 {
+		acls = global
+	}
+	if len(acls) == 0 {
+		return l
+	}
+	return aclListener{Listener: l, acls: acls, drop: p.ACLDrop}
+}
+
+// replyRefused writes a REFUSED response to the query stored in buf[:qsize]
+// into buf.
+func replyRefused(buf []byte, qsize int) (int, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(buf[:qsize])
+	if err != nil {
+		return 0, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return 0, err
+	}
+	h.Response = true
+	h.RCode = dnsmessage.RCodeRefused
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q)
+	buf, err = b.Finish()
+	return len(buf), err
+}
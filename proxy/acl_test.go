@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseACL(t *testing.T) {
+	tests := []struct {
+		acl     string
+		ip      string
+		want    bool
+		wantErr bool
+	}{
+		{"192.168.1.0/24", "192.168.1.10", true, false},
+		{"192.168.1.0/24", "192.168.2.10", false, false},
+		{"192.168.1.0/24", "127.0.0.1", true, false},
+		{"192.168.1.0/24", "::1", true, false},
+		{"eth0:53=10.0.0.1,2001:db8::1", "2001:db8::1", true, false},
+		{"eth0:53=10.0.0.1,2001:db8::1", "10.0.0.2", false, false},
+		{"private", "172.20.1.1", true, false},
+		{"private", "fd00::1", true, false},
+		{"private", "8.8.8.8", false, false},
+		{"eth0=10.0.0.0/8", "", false, true},
+		{"foo", "", false, true},
+		{"", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acl+"/"+tt.ip, func(t *testing.T) {
+			acl, err := ParseACL(tt.acl)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseACL() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if acl.String() != tt.acl {
+				t.Errorf("ACL.String() = %v, want %v", acl.String(), tt.acl)
+			}
+			if got := acl.allowed(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("ACL.allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_withACLs(t *testing.T) {
+	global, _ := ParseACL("private")
+	lan, _ := ParseACL("eth0:53=192.168.1.0/24")
+	p := Proxy{ACLs: []ACL{global, lan}}
+	l := &UDPListener{}
+	if got := p.withACLs(l, "eth0:53").(aclListener).acls; len(got) != 1 || got[0].Addr != "eth0:53" {
+		t.Errorf("withACLs(eth0:53) = %v, want [%v]", got, lan)
+	}
+	if got := p.withACLs(l, "").(aclListener).acls; len(got) != 1 || got[0].Addr != "" {
+		t.Errorf("withACLs() = %v, want [%v]", got, global)
+	}
+	if got := (Proxy{}).withACLs(l, ""); got != l {
+		t.Errorf("withACLs() without ACL = %v, want %v", got, l)
+	}
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/nextdns/nextdns/hosts"
+)
+
+// listenAddr is an address to listen to for a given network.
+type listenAddr struct {
+	// network is udp, tcp or empty for both.
+	network string
+	addr    string
+
+	// entry is the entry of Proxy.Addr, without its protocol prefix, addr
+	// comes from.
+	entry string
+}
+
+// SplitAddr returns the addresses of a comma separated list of listen
+// addresses as accepted by Proxy.Addr, without their protocol prefix.
+func SplitAddr(addr string) []string {
+	var addrs []string
+	for _, a := range strings.Split(addr, ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		if idx := strings.Index(a, "://"); idx != -1 {
+			a = a[idx+3:]
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs
+}
+
+// ListenPorts returns the UDP and TCP ports listened to for the comma
+// separated list of listen addresses addr.
+func ListenPorts(addr string) (udp, tcp []string, err error) {
+	addrs, err := Proxy{Addr: addr}.listenAddrs()
+	if err != nil {
+		return nil, nil, err
+	}
+	add := func(ports []string, port string) []string {
+		for _, p := range ports {
+			if p == port {
+				return ports
+			}
+		}
+		return append(ports, port)
+	}
+	for _, a := range addrs {
+		_, port, _ := net.SplitHostPort(a.addr)
+		if a.network != "tcp" {
+			udp = add(udp, port)
+		}
+		if a.network != "udp" {
+			tcp = add(tcp, port)
+		}
+	}
+	return udp, tcp, nil
+}
+
+// listenAddrs returns the addresses to listen to for Addr.
+func (p Proxy) listenAddrs() ([]listenAddr, error) {
+	addr := p.Addr
+	if addr == "" {
+		addr = ":53"
+	}
+	return parseListenAddrs(addr)
+}
+
+// parseListenAddrs returns the addresses to listen to for the comma separated
+// list of listen addresses addr.
+func parseListenAddrs(addr string) ([]listenAddr, error) {
+	var addrs []listenAddr
+	for _, a := range strings.Split(addr, ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		var network string
+		if idx := strings.Index(a, "://"); idx != -1 {
+			network, a = a[:idx], a[idx+3:]
+			if network != "udp" && network != "tcp" {
+				return nil, fmt.Errorf("%s: unsupported protocol", network)
+			}
+		}
+		host, port, err := net.SplitHostPort(a)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range lookupHost(host) {
+			addrs = append(addrs, listenAddr{network, net.JoinHostPort(ip, port), a})
+		}
+	}
+	return addrs, nil
+}
+
+// lookupHost returns the IPs of host if it is the name of a network
+// interface or found in the /etc/hosts file (for localhost for instance).
+// Otherwise host is returned as is.
+func lookupHost(host string) []string {
+	if host == "" || net.ParseIP(host) != nil {
+		return []string{host}
+	}
+	if iface, err := net.InterfaceByName(host); err == nil {
+		var ips []string
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ips = append(ips, ipnet.IP.String())
+		}
+		return ips
+	}
+	if ips := hosts.LookupHost(host); len(ips) > 0 {
+		return ips
+	}
+	return []string{host}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProxy_listenAddrs(t *testing.T) {
+	tests := []struct {
+		addr    string
+		want    []listenAddr
+		wantErr bool
+	}{
+		{"", []listenAddr{{"", ":53", ":53"}}, false},
+		{"127.0.0.1:53", []listenAddr{{"", "127.0.0.1:53", "127.0.0.1:53"}}, false},
+		{"127.0.0.1:53, [::1]:53", []listenAddr{{"", "127.0.0.1:53", "127.0.0.1:53"}, {"", "[::1]:53", "[::1]:53"}}, false},
+		{"udp://:53,tcp://10.0.0.1:5353", []listenAddr{{"udp", ":53", ":53"}, {"tcp", "10.0.0.1:5353", "10.0.0.1:5353"}}, false},
+		{"tls://:853", nil, true},
+		{"127.0.0.1", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got, err := Proxy{Addr: tt.addr}.listenAddrs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listenAddrs() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listenAddrs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitAddr(t *testing.T) {
+	got := SplitAddr("udp://127.0.0.1:53, eth0:53,,tcp://[::1]:53")
+	want := []string{"127.0.0.1:53", "eth0:53", "[::1]:53"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitAddr() = %v, want %v", got, want)
+	}
+}
+
+func TestListenPorts(t *testing.T) {
+	tests := []struct {
+		addr    string
+		udp     []string
+		tcp     []string
+		wantErr bool
+	}{
+		{"", []string{"53"}, []string{"53"}, false},
+		{"127.0.0.1:53, [::1]:53", []string{"53"}, []string{"53"}, false},
+		{"udp://:53,tcp://10.0.0.1:5353", []string{"53"}, []string{"5353"}, false},
+		{"udp://:5353", []string{"5353"}, nil, false},
+		{"tls://:853", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			udp, tcp, err := ListenPorts(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ListenPorts() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(udp, tt.udp) || !reflect.DeepEqual(tcp, tt.tcp) {
+				t.Errorf("ListenPorts() = %v, %v, want %v, %v", udp, tcp, tt.udp, tt.tcp)
+			}
+		})
+	}
+}
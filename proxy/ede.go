@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// Extended DNS Error (RFC 8914) option code and the info codes reported by the
+// proxy.
+const (
+	optionEDE = 15
+
+	EDEOther                = 0
+	EDEBlocked              = 15
+	EDEProhibited           = 18
+	EDENoReachableAuthority = 22
+	EDENetworkError         = 23
+)
+
+var errNoOPT = errors.New("response without OPT record")
+
+var edeNames = map[int]string{
+	EDEOther:                "Other",
+	EDEBlocked:              "Blocked",
+	EDEProhibited:           "Prohibited",
+	EDENoReachableAuthority: "No Reachable Authority",
+	EDENetworkError:         "Network Error",
+}
+
+// ExtendedErrorName returns the name of the Extended DNS Error info code.
+func ExtendedErrorName(code int) string {
+	if name, found := edeNames[code]; found {
+		return name
+	}
+	return "Unknown"
+}
+
+// resolveError returns the Extended DNS Error info code and text describing
+// the failure err to resolve a query.
+func resolveError(err error) (int, string) {
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout() {
+		return EDENoReachableAuthority, "upstream timeout"
+	}
+	var (
+		authErr   x509.UnknownAuthorityError
+		certErr   x509.CertificateInvalidError
+		hostErr   x509.HostnameError
+		recordErr tls.RecordHeaderError
+	)
+	if errors.As(err, &authErr) || errors.As(err, &certErr) || errors.As(err, &hostErr) ||
+		errors.As(err, &recordErr) || strings.Contains(err.Error(), "tls: ") {
+		return EDENetworkError, "upstream TLS failure"
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) {
+		return EDENetworkError, "upstream unreachable"
+	}
+	return EDEOther, "upstream error"
+}
+
+// sourceError returns the Extended DNS Error info code of the response
+// generated locally with the resolve info i, and false if it is a regular
+// response.
+func sourceError(i resolver.ResolveInfo) (int, bool) {
+	switch {
+	case strings.HasPrefix(i.Source, "blocked"):
+		return EDEBlocked, true
+	case strings.HasPrefix(i.Source, "rate limited"):
+		return EDEProhibited, true
+	case i.Source == "fail-closed", i.Source == "cache-only":
+		return EDENoReachableAuthority, true
+	}
+	return 0, false
+}
+
+// addExtendedError adds an Extended DNS Error with code and text to the
+// response in buf[:n], adding an OPT record if it has none. The response is
+// left untouched if it cannot be parsed or would not fit in buf anymore. It
+// returns the new size of the response.
+func addExtendedError(buf []byte, n int, code int, text string) (int, error) {
+	var m dnsmessage.Message
+	if m.Unpack(buf[:n]) != nil {
+		return n, nil
+	}
+	if err := appendExtendedError(&m, code, text, true); err != nil {
+		return n, err
+	}
+	b, err := m.Pack()
+	if err != nil {
+		return n, err
+	}
+	if len(b) > len(buf) {
+		return n, nil
+	}
+	return copy(buf, b), nil
+}
+
+// appendExtendedError appends an Extended DNS Error with code and text to the
+// OPT record of m. If m has no OPT record, one is added if edns is true. It
+// returns errNoOPT if the error was not added.
+func appendExtendedError(m *dnsmessage.Message, code int, text string, edns bool) error {
+	data := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(data, uint16(code))
+	data = append(data, text...)
+	ede := dnsmessage.Option{Code: optionEDE, Data: data}
+	for j, rr := range m.Additionals {
+		if opt, ok := rr.Body.(*dnsmessage.OPTResource); ok {
+			opts := append(append([]dnsmessage.Option(nil), opt.Options...), ede)
+			m.Additionals[j].Body = &dnsmessage.OPTResource{Options: opts}
+			return nil
+		}
+	}
+	if !edns {
+		return errNoOPT
+	}
+	// I.e. answered locally without OPT record.
+	var rh dnsmessage.ResourceHeader
+	if err := rh.SetEDNS0(defaultUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return err
+	}
+	m.Additionals = append(m.Additionals, dnsmessage.Resource{
+		Header: rh,
+		Body:   &dnsmessage.OPTResource{Options: []dnsmessage.Option{ede}},
+	})
+	return nil
+}
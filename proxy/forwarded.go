@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net"
+
+	"github.com/nextdns/nextdns/arp"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// forwardedClient restores the identity of the client of a query forwarded
+// by one of Forwarders, so client conditions, logs and reporting apply to the
+// actual client rather than to the forwarder.
+//
+// The forwarder passes the identity of its client with EDNS0 options added to
+// each query:
+//
+//   * Client subnet (RFC 7871): with a full length prefix (/32 or /128), the
+//     address replaces the peer address when the query is parsed. With a
+//     shorter prefix, the address of the network is used as the client
+//     address, so subnet conditions still apply.
+//   * MAC address (option 65001, as added by dnsmasq --add-mac): when the
+//     client address is still the forwarder one, it is searched in the
+//     neighbor table, which only works when the forwarder shares the LAN of
+//     its clients.
+//
+// For Pi-hole, add "add-subnet=32,128" and "add-mac" to a dnsmasq
+// configuration file (i.e. /etc/dnsmasq.d/99-nextdns.conf). For AdGuard Home,
+// enable "Use EDNS Client Subnet" in the upstream DNS settings, which sends the
+// /24 or /56 network of the clients.
+//
+// Options sent by other peers are not trusted beyond the full length client
+// subnet historically honored for every peer.
+func (p Proxy) forwardedClient(q *resolver.Query, peer net.IP) {
+	if peer == nil || !p.isForwarder(peer) {
+		return
+	}
+	if q.MAC != nil && q.MAC.String() == arp.SearchMAC(peer).String() {
+		// The MAC of the forwarder itself, found in the neighbor table.
+		q.MAC = nil
+	}
+	if !q.PeerIP.Equal(peer) {
+		// Full client address already set from the client subnet.
+		return
+	}
+	if s := q.ClientSubnet; s != nil {
+		q.PeerIP = s.IP
+		return
+	}
+	if q.MAC != nil {
+		if ip := arp.SearchIP(q.MAC); ip != nil {
+			q.PeerIP = ip
+		}
+	}
+}
+
+// isForwarder returns true if ip is the address of one of Forwarders.
+func (p Proxy) isForwarder(ip net.IP) bool {
+	for _, n := range p.Forwarders {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,513 @@
+// +build linux,iouring
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// io_uring definitions missing from x/sys/unix.
+const (
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	ioringEnterGetEvents = 1 << 0
+
+	ioringFeatNoDrop   = 1 << 1
+	ioringFeatFastPoll = 1 << 5
+
+	ioringOpSendmsg     = 9
+	ioringOpRecvmsg     = 10
+	ioringOpAccept      = 13
+	ioringOpAsyncCancel = 14
+	ioringOpRead        = 22
+	ioringOpRecv        = 27
+)
+
+// ioUringEntries is the size of the submission rings, the completion rings
+// being twice as large.
+const ioUringEntries = 256
+
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        ioSQRingOffsets
+	cqOff        ioCQRingOffsets
+}
+
+type ioSQRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type ioCQRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// ioUringSQE is a submission queue entry. opFlags holds the flags of the
+// operation (i.e. msg_flags for sendmsg).
+type ioUringSQE struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	opFlags  uint32
+	userData uint64
+	_        [24]byte
+}
+
+type ioUringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+var ioUringProbe struct {
+	once sync.Once
+	ok   bool
+}
+
+// ioUringSupported returns true if the kernel supports the io_uring features
+// used by the UDP and TCP loops (Linux 5.7 and later), and io_uring is not
+// disabled (i.e. by seccomp in containers).
+func ioUringSupported() bool {
+	ioUringProbe.once.Do(func() {
+		if r, err := newIOUring(); err == nil {
+			r.close()
+			ioUringProbe.ok = true
+		}
+	})
+	return ioUringProbe.ok
+}
+
+// ioUring is an io_uring instance used by a single goroutine.
+type ioUring struct {
+	fd                   int
+	sqMem, cqMem, sqeMem []byte
+	sqHead, sqTail       *uint32
+	sqes                 *[ioUringEntries]ioUringSQE
+	cqHead, cqTail       *uint32
+	cqes                 *[2 * ioUringEntries]ioUringCQE
+
+	// tail is the tail of the submission ring, published to the kernel by
+	// enter. submitted is the tail of the entries consumed by the kernel.
+	tail, submitted uint32
+}
+
+func newIOUring() (_ *ioUring, err error) {
+	var p ioUringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, ioUringEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	r := &ioUring{fd: int(fd)}
+	defer func() {
+		if err != nil {
+			r.close()
+		}
+	}()
+	// Without fast poll, the operations on sockets not ready block a kernel
+	// worker thread each.
+	if p.features&ioringFeatFastPoll == 0 || p.features&ioringFeatNoDrop == 0 {
+		return nil, errors.New("io_uring: Linux 5.7 or later required")
+	}
+	if p.sqEntries != ioUringEntries || p.cqEntries != 2*ioUringEntries {
+		return nil, errors.New("io_uring: unexpected ring sizes")
+	}
+	mmap := func(off int64, size int) ([]byte, error) {
+		return unix.Mmap(r.fd, off, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	}
+	if r.sqMem, err = mmap(ioringOffSQRing, int(p.sqOff.array)+ioUringEntries*4); err != nil {
+		return nil, err
+	}
+	if r.cqMem, err = mmap(ioringOffCQRing, int(p.cqOff.cqes)+2*ioUringEntries*int(unsafe.Sizeof(ioUringCQE{}))); err != nil {
+		return nil, err
+	}
+	if r.sqeMem, err = mmap(ioringOffSQEs, ioUringEntries*int(unsafe.Sizeof(ioUringSQE{}))); err != nil {
+		return nil, err
+	}
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqMem[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqMem[p.sqOff.tail]))
+	r.sqes = (*[ioUringEntries]ioUringSQE)(unsafe.Pointer(&r.sqeMem[0]))
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqMem[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqMem[p.cqOff.tail]))
+	r.cqes = (*[2 * ioUringEntries]ioUringCQE)(unsafe.Pointer(&r.cqMem[p.cqOff.cqes]))
+	// The submission array maps each slot to the entry with the same index.
+	array := (*[ioUringEntries]uint32)(unsafe.Pointer(&r.sqMem[p.sqOff.array]))
+	for i := range array {
+		array[i] = uint32(i)
+	}
+	r.tail = atomic.LoadUint32(r.sqTail)
+	r.submitted = r.tail
+	return r, nil
+}
+
+func (r *ioUring) close() {
+	for _, mem := range [][]byte{r.sqMem, r.cqMem, r.sqeMem} {
+		if mem != nil {
+			_ = unix.Munmap(mem)
+		}
+	}
+	unix.Close(r.fd)
+}
+
+// full returns true if no submission entry is available before the next
+// enter.
+func (r *ioUring) full() bool {
+	return r.tail-atomic.LoadUint32(r.sqHead) == ioUringEntries
+}
+
+// sqe returns a zeroed submission entry, submitted by the next enter. The ring
+// must not be full.
+func (r *ioUring) sqe() *ioUringSQE {
+	sqe := &r.sqes[r.tail&(ioUringEntries-1)]
+	*sqe = ioUringSQE{}
+	r.tail++
+	return sqe
+}
+
+// enter submits the pending entries and waits for at least wait completions.
+func (r *ioUring) enter(wait int) error {
+	atomic.StoreUint32(r.sqTail, r.tail)
+	var flags uintptr
+	if wait > 0 {
+		flags = ioringEnterGetEvents
+	}
+	for {
+		n, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(r.tail-r.submitted), uintptr(wait), flags, 0, 0)
+		switch errno {
+		case 0:
+			r.submitted += uint32(n)
+			return nil
+		case unix.EINTR:
+			continue
+		case unix.EBUSY, unix.EAGAIN:
+			// Completions are waiting to be reaped before more entries
+			// can be submitted.
+			return nil
+		default:
+			return errno
+		}
+	}
+}
+
+// reap calls f with the user data and the result of the available
+// completions, until f returns an error.
+func (r *ioUring) reap(f func(userData uint64, res int32) error) error {
+	head := atomic.LoadUint32(r.cqHead)
+	for head != atomic.LoadUint32(r.cqTail) {
+		cqe := r.cqes[head&(2*ioUringEntries-1)]
+		head++
+		atomic.StoreUint32(r.cqHead, head)
+		if err := f(cqe.userData, cqe.res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ringOp is an operation submitted to a ringLoop. It holds the memory read or
+// written by the kernel until its completion.
+type ringOp struct {
+	done func(res int32) error
+
+	msg     unix.Msghdr
+	iov     [2]unix.Iovec
+	name    unix.RawSockaddrAny
+	namelen uint32
+	oob     []byte
+	bp      *[]byte
+	buf     [8]byte
+	n       int
+}
+
+// ringLoop runs the completions of the operations submitted to an io_uring
+// and the functions posted by the other goroutines.
+type ringLoop struct {
+	ring *ioUring
+	// ops holds the operations in progress by user data, 0 being used for
+	// the cancellations.
+	ops    map[uint64]*ringOp
+	nextID uint64
+	// stopping is true once the loop is asked to stop. The operations in
+	// progress are then canceled and must not be submitted again.
+	stopping bool
+
+	// deferred holds the completions reaped while submitting, run before the
+	// next ones.
+	deferred []ioUringCQE
+
+	// wake is an eventfd read by the loop, written to wake it up when a
+	// function is posted.
+	wake   int
+	wakeOp ringOp
+
+	mu     sync.Mutex
+	posted []func() error
+	woken  bool
+	exited bool
+}
+
+func newRingLoop() (*ringLoop, error) {
+	r, err := newIOUring()
+	if err != nil {
+		return nil, err
+	}
+	wake, err := unix.Eventfd(0, unix.EFD_CLOEXEC)
+	if err != nil {
+		r.close()
+		return nil, err
+	}
+	l := &ringLoop{ring: r, ops: map[uint64]*ringOp{}, wake: wake}
+	l.wakeOp.done = l.woke
+	return l, nil
+}
+
+// submit submits op with the given entry fields. Its done function is called
+// by run with the result of the operation.
+func (l *ringLoop) submit(op *ringOp, opcode uint8, fd int, addr unsafe.Pointer, n uint32, off uint64, opFlags uint32) error {
+	sqe, err := l.sqe()
+	if err != nil {
+		return err
+	}
+	l.nextID++
+	l.ops[l.nextID] = op
+	sqe.opcode = opcode
+	sqe.fd = int32(fd)
+	sqe.addr = uint64(uintptr(addr))
+	sqe.len = n
+	sqe.off = off
+	sqe.opFlags = opFlags
+	sqe.userData = l.nextID
+	return nil
+}
+
+// sqe returns a submission entry, submitting the pending ones first if the
+// ring is full.
+func (l *ringLoop) sqe() (*ioUringSQE, error) {
+	for l.ring.full() {
+		if err := l.ring.enter(0); err != nil {
+			return nil, err
+		}
+		if l.ring.full() {
+			// The kernel refuses the entries until the completions are
+			// reaped.
+			_ = l.ring.reap(func(userData uint64, res int32) error {
+				l.deferred = append(l.deferred, ioUringCQE{userData: userData, res: res})
+				return nil
+			})
+		}
+	}
+	return l.ring.sqe(), nil
+}
+
+// post runs f in the loop goroutine. It returns false if the loop exited,
+// f not being called.
+func (l *ringLoop) post(f func() error) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.exited {
+		return false
+	}
+	l.posted = append(l.posted, f)
+	if !l.woken {
+		l.woken = true
+		var b [8]byte
+		*(*uint64)(unsafe.Pointer(&b[0])) = 1
+		_, _ = unix.Write(l.wake, b[:])
+	}
+	return true
+}
+
+func (l *ringLoop) readWake() error {
+	return l.submit(&l.wakeOp, ioringOpRead, l.wake, unsafe.Pointer(&l.wakeOp.buf[0]), 8, 0, 0)
+}
+
+func (l *ringLoop) woke(res int32) error {
+	l.mu.Lock()
+	l.woken = false
+	l.mu.Unlock()
+	if l.stopping {
+		return nil
+	}
+	return l.readWake()
+}
+
+// run runs the loop until done is closed or an error is returned by a done
+// function. The operations in progress are canceled before it returns.
+func (l *ringLoop) run(done <-chan struct{}) error {
+	exit := make(chan struct{})
+	defer close(exit)
+	go func() {
+		select {
+		case <-done:
+			l.post(l.stop)
+		case <-exit:
+		}
+	}()
+	err := l.readWake()
+	for err == nil {
+		if err = l.reap(); err != nil {
+			break
+		}
+		l.mu.Lock()
+		posted := l.posted
+		l.posted = nil
+		l.mu.Unlock()
+		for _, f := range posted {
+			if err = f(); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+		if l.stopping && len(l.ops) == 0 {
+			err = net.ErrClosed
+			break
+		}
+		err = l.ring.enter(1)
+	}
+	if !l.stopping {
+		l.stop()
+	}
+	// The memory of the canceled operations is kept until they complete.
+	for len(l.ops) > 0 && l.ring.enter(1) == nil {
+		_ = l.reap()
+	}
+	l.mu.Lock()
+	l.exited = true
+	posted := l.posted
+	l.posted = nil
+	l.mu.Unlock()
+	// Run the functions posted since the last iteration so they release
+	// their resources.
+	for _, f := range posted {
+		_ = f()
+	}
+	return err
+}
+
+// reap runs the deferred and the available completions.
+func (l *ringLoop) reap() error {
+	for len(l.deferred) > 0 {
+		cqe := l.deferred[0]
+		l.deferred = l.deferred[1:]
+		if err := l.complete(cqe.userData, cqe.res); err != nil {
+			return err
+		}
+	}
+	return l.ring.reap(l.complete)
+}
+
+func (l *ringLoop) complete(userData uint64, res int32) error {
+	op := l.ops[userData]
+	if op == nil {
+		return nil
+	}
+	delete(l.ops, userData)
+	return op.done(res)
+}
+
+// stop cancels the operations in progress.
+func (l *ringLoop) stop() error {
+	l.stopping = true
+	for id := range l.ops {
+		sqe, err := l.sqe()
+		if err != nil {
+			return err
+		}
+		sqe.opcode = ioringOpAsyncCancel
+		sqe.fd = -1
+		sqe.addr = id
+	}
+	return nil
+}
+
+// close releases the ring, once run returned.
+func (l *ringLoop) close() {
+	unix.Close(l.wake)
+	l.ring.close()
+}
+
+// ringErr returns the error of the negative result res.
+func ringErr(res int32) error {
+	return syscall.Errno(-res)
+}
+
+// temporaryRingErr returns true if an operation failing with the negative
+// result res can be submitted again.
+func temporaryRingErr(res int32) bool {
+	switch syscall.Errno(-res) {
+	case unix.EINTR, unix.EAGAIN, unix.ENOBUFS, unix.ENOMEM:
+		return true
+	}
+	return false
+}
+
+// dupSocket returns a blocking duplicate of the socket of c, served by a
+// ringLoop instead of c. Operations on blocking sockets wait for them to be
+// ready instead of failing with EAGAIN. The flag is shared with c, which must
+// not be read or written anymore.
+func dupSocket(c syscall.Conn) (fd int, err error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	cerr := rc.Control(func(s uintptr) {
+		fd, err = unix.FcntlInt(s, unix.F_DUPFD_CLOEXEC, 0)
+	})
+	if cerr != nil {
+		return -1, cerr
+	}
+	if err != nil {
+		return -1, err
+	}
+	if err := unix.SetNonblock(fd, false); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// parseRawSockaddr returns the IP, port and zone of the IPv4 or IPv6 socket
+// address sa.
+func parseRawSockaddr(sa *unix.RawSockaddrAny) (ip net.IP, port int, zone string) {
+	switch sa.Addr.Family {
+	case unix.AF_INET:
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		p := (*[2]byte)(unsafe.Pointer(&sa4.Port))
+		return append(net.IP(nil), sa4.Addr[:]...), int(p[0])<<8 | int(p[1]), ""
+	case unix.AF_INET6:
+		sa6 := (*unix.RawSockaddrInet6)(unsafe.Pointer(sa))
+		p := (*[2]byte)(unsafe.Pointer(&sa6.Port))
+		if sa6.Scope_id != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa6.Scope_id)); err == nil {
+				zone = ifi.Name
+			} else {
+				zone = strconv.Itoa(int(sa6.Scope_id))
+			}
+		}
+		return append(net.IP(nil), sa6.Addr[:]...), int(p[0])<<8 | int(p[1]), zone
+	}
+	return nil, 0, ""
+}
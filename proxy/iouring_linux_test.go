@@ -0,0 +1,190 @@
+// +build linux,iouring
+
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func skipIfNoIOUring(t *testing.T) {
+	t.Helper()
+	if !ioUringSupported() {
+		t.Skip("io_uring not supported")
+	}
+}
+
+func TestUDPListener_IOUring(t *testing.T) {
+	skipIfNoIOUring(t)
+	for _, addr := range []string{"127.0.0.1:0", "[::1]:0"} {
+		t.Run(addr, func(t *testing.T) {
+			l := &UDPListener{Addr: addr, Sockets: 2}
+			if err := l.Listen(context.Background()); err != nil {
+				t.Skip(err)
+			}
+			errs := make(chan error)
+			go func() {
+				errs <- l.Serve(echoHandler{})
+			}()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					c, err := net.Dial("udp", l.conns[0].LocalAddr().String())
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer c.Close()
+					q := make([]byte, 20)
+					buf := make([]byte, 512)
+					for j := 0; j < 50; j++ {
+						q[0], q[1] = byte(i), byte(j)
+						if _, err := c.Write(q); err != nil {
+							t.Error(err)
+							return
+						}
+						_ = c.SetReadDeadline(time.Now().Add(time.Second))
+						n, err := c.Read(buf)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if n != len(q) || buf[0] != byte(i) || buf[1] != byte(j) {
+							t.Errorf("unexpected response %x", buf[:n])
+							return
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			_ = l.Close()
+			if err := <-errs; err == nil {
+				t.Error("Serve() returned no error after Close")
+			}
+		})
+	}
+}
+
+type errorRecorder struct {
+	mu   sync.Mutex
+	errs []string
+}
+
+func (r *errorRecorder) log(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err.Error())
+}
+
+func (r *errorRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.errs, "; ")
+}
+
+func TestTCPListener_IOUring(t *testing.T) {
+	skipIfNoIOUring(t)
+	errLog := &errorRecorder{}
+	l := &TCPListener{Addr: "127.0.0.1:0", ErrorLog: errLog.log}
+	if err := l.Listen(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	go func() {
+		errs <- l.Serve(echoHandler{})
+	}()
+	addr := l.l.Addr().String()
+
+	for _, size := range []int{300, maxTCPSize} {
+		t.Run("pipelined "+strconv.Itoa(size), func(t *testing.T) {
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			const queries = 100
+			go func() {
+				q := make([]byte, size)
+				for i := 0; i < queries; i++ {
+					binary.BigEndian.PutUint16(q, uint16(i))
+					_ = writeTCP(c, q)
+				}
+			}()
+			seen := map[uint16]bool{}
+			buf := make([]byte, maxTCPSize)
+			_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for i := 0; i < queries; i++ {
+				n, err := readTCP(c, buf)
+				if err != nil {
+					t.Fatalf("response %d: %v", i, err)
+				}
+				if n != size {
+					t.Fatalf("response %d: size %d, want %d", i, n, size)
+				}
+				seen[binary.BigEndian.Uint16(buf)] = true
+			}
+			if len(seen) != queries {
+				t.Errorf("%d distinct responses, want %d", len(seen), queries)
+			}
+		})
+	}
+
+	t.Run("split", func(t *testing.T) {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		msg := make([]byte, 22)
+		binary.BigEndian.PutUint16(msg, 20)
+		msg[2] = 42
+		for i := range msg {
+			if _, err := c.Write(msg[i : i+1]); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 512)
+		n, err := readTCP(c, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 20 || buf[0] != 42 {
+			t.Fatalf("unexpected response %x", buf[:n])
+		}
+	})
+
+	t.Run("too small", func(t *testing.T) {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if err := writeTCP(c, make([]byte, 10)); err != nil {
+			t.Fatal(err)
+		}
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := c.Read(make([]byte, 10)); err == nil {
+			t.Error("connection not closed")
+		}
+		if !strings.Contains(errLog.String(), "query too small: 10") {
+			t.Errorf("errors = %q, want query too small", errLog)
+		}
+	})
+
+	_ = l.Close()
+	if err := <-errs; err == nil {
+		t.Error("Serve() returned no error after Close")
+	}
+}
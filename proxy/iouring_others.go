@@ -0,0 +1,23 @@
+// +build !linux !iouring
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// ioUringSupported returns false as the io_uring loops are only built on
+// Linux with the iouring build tag.
+func ioUringSupported() bool {
+	return false
+}
+
+func serveUDPRing(c *net.UDPConn, h Handler, bpool *udpBufferPool, done <-chan struct{}) error {
+	return errors.New("io_uring not supported")
+}
+
+func serveTCPRing(ln *net.TCPListener, h Handler, bpool *sync.Pool, errorLog func(error), done <-chan struct{}) error {
+	return errors.New("io_uring not supported")
+}
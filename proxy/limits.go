@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// quotaWindow is the period over which the parse errors of a source are
+// counted.
+const quotaWindow = time.Minute
+
+// maxQuotaSources is the maximum number of sources tracked by Limits.
+const maxQuotaSources = 10000
+
+var errBanned = errors.New("source temporarily banned")
+
+var errOverloaded = errors.New("too many queries in flight")
+
+// overloadLogInterval is the minimum interval between two reports of queries
+// dropped for MaxInflight.
+const overloadLogInterval = time.Minute
+
+// Limits bounds the size and complexity of the queries accepted by the proxy,
+// and temporarily bans the sources sending too many queries failing to parse
+// or exceeding the limits, protecting the daemon from crafted packets. Zero
+// values disable the corresponding limit.
+type Limits struct {
+	// MaxLabels is the maximum number of labels of the queried name.
+	MaxLabels int
+
+	// MaxOptions is the maximum number of EDNS options of a query.
+	MaxOptions int
+
+	// MaxSize is the maximum size of a query per protocol (UDP or TCP).
+	MaxSize map[string]int
+
+	// ErrorQuota is the number of queries failing to parse or exceeding the
+	// limits a source can send per minute before being banned.
+	ErrorQuota int
+
+	// BanDuration is the time during which the queries of a banned source
+	// are dropped. Default is 10 minutes.
+	BanDuration time.Duration
+
+	// OnBan is called when a source gets banned.
+	OnBan func(ip net.IP, errors int)
+
+	mu      sync.Mutex
+	sources map[string]*quotaSource
+	now     func() time.Time
+}
+
+type quotaSource struct {
+	start       time.Time
+	errors      int
+	bannedUntil time.Time
+}
+
+// ParseMaxSize parses a maximum query size in the PROTOCOL=SIZE form.
+func ParseMaxSize(s string) (protocol string, size int, err error) {
+	idx := strings.IndexByte(s, '=')
+	if idx == -1 {
+		return "", 0, fmt.Errorf("%s: missing protocol", s)
+	}
+	protocol = strings.ToUpper(strings.TrimSpace(s[:idx]))
+	if protocol != "UDP" && protocol != "TCP" {
+		return "", 0, fmt.Errorf("%s: unsupported protocol", s)
+	}
+	size, err = strconv.Atoi(strings.TrimSpace(s[idx+1:]))
+	if err != nil || size < 12 || size > maxTCPSize {
+		return "", 0, fmt.Errorf("%s: invalid size", s)
+	}
+	return protocol, size, nil
+}
+
+func (l *Limits) timeNow() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// banned returns true if the queries of ip must be dropped.
+func (l *Limits) banned(ip net.IP) bool {
+	if l.ErrorQuota <= 0 || ip == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.sources[string(ip.To16())]
+	return s != nil && l.timeNow().Before(s.bannedUntil)
+}
+
+// check returns an error if the query in q, received over protocol, exceeds
+// the limits.
+func (l *Limits) check(protocol string, q []byte, name string) error {
+	if max := l.MaxSize[protocol]; max > 0 && len(q) > max {
+		return fmt.Errorf("query of %d bytes over %s", len(q), protocol)
+	}
+	if l.MaxLabels > 0 {
+		labels := strings.Count(strings.TrimSuffix(name, "."), ".") + 1
+		if name == "." || name == "" {
+			labels = 0
+		}
+		if labels > l.MaxLabels {
+			return fmt.Errorf("%s: %d labels", name, labels)
+		}
+	}
+	if l.MaxOptions > 0 {
+		if n := ednsOptionCount(q); n > l.MaxOptions {
+			return fmt.Errorf("%s: %d EDNS options", name, n)
+		}
+	}
+	return nil
+}
+
+// fail counts a parse error for ip, banning it once ErrorQuota is reached.
+func (l *Limits) fail(ip net.IP) {
+	if l.ErrorQuota <= 0 || ip == nil {
+		return
+	}
+	now := l.timeNow()
+	l.mu.Lock()
+	if l.sources == nil {
+		l.sources = map[string]*quotaSource{}
+	}
+	key := string(ip.To16())
+	s := l.sources[key]
+	if s == nil {
+		if len(l.sources) >= maxQuotaSources {
+			l.pruneLocked(now)
+			if len(l.sources) >= maxQuotaSources {
+				// Evicting sources still banned or counting errors would
+				// let them start over, leave the new one untracked.
+				l.mu.Unlock()
+				return
+			}
+		}
+		s = &quotaSource{start: now}
+		l.sources[key] = s
+	}
+	if now.Sub(s.start) >= quotaWindow {
+		s.start, s.errors = now, 0
+	}
+	s.errors++
+	var banned bool
+	if s.errors >= l.ErrorQuota && !now.Before(s.bannedUntil) {
+		ban := l.BanDuration
+		if ban <= 0 {
+			ban = 10 * time.Minute
+		}
+		s.bannedUntil = now.Add(ban)
+		banned = true
+	}
+	errs := s.errors
+	l.mu.Unlock()
+	if banned && l.OnBan != nil {
+		l.OnBan(ip, errs)
+	}
+}
+
+// pruneLocked removes the sources neither banned nor counting errors.
+func (l *Limits) pruneLocked(now time.Time) {
+	for k, s := range l.sources {
+		if now.Sub(s.start) >= quotaWindow && !now.Before(s.bannedUntil) {
+			delete(l.sources, k)
+		}
+	}
+}
+
+// banSource returns the IP the errors of the query q received from peer are
+// counted against, or nil if none. The local host is never banned, and the
+// errors of the queries of Forwarders are attributed to the client they pass,
+// never to the forwarder itself, which would deny all its clients.
+func (p Proxy) banSource(q *resolver.Query, peer net.IP) net.IP {
+	if peer == nil || peer.IsLoopback() {
+		return nil
+	}
+	if !p.isForwarder(peer) {
+		return peer
+	}
+	p.forwardedClient(q, peer)
+	if q.PeerIP == nil || q.PeerIP.Equal(peer) || q.PeerIP.IsLoopback() {
+		return nil
+	}
+	return q.PeerIP
+}
+
+// ednsOptionCount returns the number of EDNS options of the query q.
+func ednsOptionCount(q []byte) int {
+	var p dnsmessage.Parser
+	if _, err := p.Start(q); err != nil {
+		return 0
+	}
+	_ = p.SkipAllQuestions()
+	_ = p.SkipAllAnswers()
+	_ = p.SkipAllAuthorities()
+	for {
+		rh, err := p.AdditionalHeader()
+		if err != nil {
+			return 0
+		}
+		if rh.Type == dnsmessage.TypeOPT {
+			n := 0
+			_ = p.OPTOptions(func(code uint16, data []byte) { n++ })
+			return n
+		}
+		if p.SkipAdditional() != nil {
+			return 0
+		}
+	}
+}
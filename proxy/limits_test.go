@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+func TestProxy_Limits(t *testing.T) {
+	now := time.Now()
+	var bans int
+	l := &Limits{
+		MaxLabels:   4,
+		MaxOptions:  1,
+		MaxSize:     map[string]int{"TCP": 100},
+		ErrorQuota:  3,
+		BanDuration: time.Minute,
+		OnBan:       func(ip net.IP, errors int) { bans++ },
+		now:         func() time.Time { return now },
+	}
+	p := Proxy{Upstream: bigResolver{1}, Limits: l}.withQueryContext()
+	query := func(name string, options int) []byte {
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+		_ = b.StartQuestions()
+		_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+		if options > 0 {
+			_ = b.StartAdditionals()
+			var opt dnsmessage.ResourceHeader
+			_ = opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false)
+			var opts []dnsmessage.Option
+			for i := 0; i < options; i++ {
+				opts = append(opts, dnsmessage.Option{Code: 65001, Data: []byte{byte(i)}})
+			}
+			_ = b.OPTResource(opt, dnsmessage.OPTResource{Options: opts})
+		}
+		q, _ := b.Finish()
+		return q
+	}
+	peer := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1234}
+	tests := []struct {
+		name     string
+		protocol string
+		query    []byte
+		want     string // rcode, "drop" if no response
+	}{
+		{"Valid", "UDP", query("a.b.example.com.", 1), "NOERROR"},
+		{"Labels", "UDP", query("a.b.c.example.com.", 0), "REFUSED"},
+		{"Options", "TCP", query("example.com.", 2), "REFUSED"},
+		{"Garbage", "UDP", []byte("garbage packet"), "drop"},
+		{"Banned", "UDP", query("example.com.", 0), "drop"},
+		{"Unbanned", "UDP", query("example.com.", 0), "NOERROR"},
+		{"Size", "TCP", query("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa.example.com.", 1), "REFUSED"},
+	}
+	for _, tt := range tests {
+		if tt.name == "Unbanned" {
+			now = now.Add(time.Minute)
+		}
+		buf := make([]byte, maxTCPSize)
+		n, err := p.ServeDNS(tt.protocol, peer, buf, copy(buf, tt.query))
+		got := "drop"
+		if err == nil && n >= 4 {
+			got = rcodeName(buf[3] & 0xf)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+	if bans != 1 {
+		t.Errorf("got %d bans, want 1", bans)
+	}
+}
+
+func TestProxy_Limits_Sources(t *testing.T) {
+	now := time.Now()
+	var banned []string
+	l := &Limits{
+		MaxLabels:  2,
+		ErrorQuota: 2,
+		OnBan:      func(ip net.IP, errors int) { banned = append(banned, ip.String()) },
+		now:        func() time.Time { return now },
+	}
+	_, fwd, _ := net.ParseCIDR("192.168.0.53/32")
+	p := Proxy{Upstream: bigResolver{1}, Limits: l, Forwarders: []*net.IPNet{fwd}}.withQueryContext()
+	query := func(name string, client net.IP) []byte {
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+		_ = b.StartQuestions()
+		_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+		if client != nil {
+			// Client subnet option with a full length prefix.
+			_ = b.StartAdditionals()
+			var opt dnsmessage.ResourceHeader
+			_ = opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false)
+			data := append([]byte{0, 1, 32, 0}, client.To4()...)
+			_ = b.OPTResource(opt, dnsmessage.OPTResource{Options: []dnsmessage.Option{{Code: 8, Data: data}}})
+		}
+		q, _ := b.Finish()
+		return q
+	}
+	serve := func(peer net.IP, q []byte) string {
+		buf := make([]byte, maxTCPSize)
+		n, err := p.ServeDNS("UDP", &net.UDPAddr{IP: peer, Port: 1234}, buf, copy(buf, q))
+		if err != nil || n < 4 {
+			return "drop"
+		}
+		return rcodeName(buf[3] & 0xf)
+	}
+	forwarder := net.IPv4(192, 168, 0, 53)
+	client := net.IPv4(192, 168, 0, 10)
+	other := net.IPv4(192, 168, 0, 11)
+	for i := 0; i < 3; i++ {
+		serve(forwarder, query("a.b.example.com.", client))
+		serve(forwarder, []byte("garbage packet"))
+		serve(net.IPv4(127, 0, 0, 1), query("a.b.example.com.", nil))
+	}
+	if len(banned) != 1 || banned[0] != client.String() {
+		t.Errorf("banned %v, want only %v", banned, client)
+	}
+	if got := serve(forwarder, query("example.com.", client)); got != "drop" {
+		t.Errorf("banned client through forwarder: got %s, want drop", got)
+	}
+	if got := serve(forwarder, query("example.com.", other)); got != "NOERROR" {
+		t.Errorf("other client through forwarder: got %s, want NOERROR", got)
+	}
+	if got := serve(net.IPv4(127, 0, 0, 1), query("example.com.", nil)); got != "NOERROR" {
+		t.Errorf("loopback: got %s, want NOERROR", got)
+	}
+
+	// Banned sources are not evicted to make room for new ones.
+	for i := 0; len(l.sources) < maxQuotaSources; i++ {
+		l.sources[string(net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)).To16())] = &quotaSource{start: now, bannedUntil: now.Add(time.Minute)}
+	}
+	l.fail(net.IPv4(172, 16, 0, 1))
+	if !l.banned(client) || len(l.sources) != maxQuotaSources {
+		t.Errorf("banned client evicted, %d sources", len(l.sources))
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"context"
+	"net"
+)
+
+// Listener is a transport receiving DNS queries (i.e. UDP, TCP).
+type Listener interface {
+	// Listen opens the listener. It is called before Serve.
+	Listen(ctx context.Context) error
+
+	// Serve serves the queries received by the listener with h until the
+	// listener is closed.
+	Serve(h Handler) error
+
+	// Close closes the listener, making Serve return.
+	Close() error
+
+	// String returns a description of the listener (i.e. UDP/127.0.0.1:53).
+	String() string
+}
+
+// Handler handles the queries received by a Listener.
+type Handler interface {
+	// ServeDNS resolves the query stored in buf[:qsize] received from peer
+	// over protocol, and writes the response into buf. It returns the size of
+	// the response. If an error is returned, no response must be sent.
+	ServeDNS(protocol string, peer net.Addr, buf []byte, qsize int) (rsize int, err error)
+}
+
+// Instance is a named set of addresses to listen to.
+type Instance struct {
+	// Name is the name of the instance, set in the Instance field of the
+	// queries received on Addr.
+	Name string
+
+	// Addr is the comma separated list of TCP/UDP addresses to listen to, in
+	// the same format as Proxy.Addr.
+	Addr string
+}
+
+// instanceListener is a Listener serving its queries as those of an instance.
+// It must wrap the other listeners (i.e. aclListener) to receive the Proxy as
+// Handler.
+type instanceListener struct {
+	Listener
+	name string
+}
+
+// Serve implements the Listener interface.
+func (l instanceListener) Serve(h Handler) error {
+	if p, ok := h.(Proxy); ok {
+		p.instance = l.name
+		h = p
+	}
+	return l.Listener.Serve(h)
+}
+
+func (l instanceListener) String() string {
+	return l.Listener.String() + " (" + l.name + ")"
+}
@@ -0,0 +1,384 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+type fakeListener struct {
+	queries [][]byte
+	answers [][]byte
+	opened  bool
+	closed  chan struct{}
+}
+
+func (l *fakeListener) Listen(ctx context.Context) error {
+	l.opened = true
+	l.closed = make(chan struct{})
+	return nil
+}
+
+func (l *fakeListener) Serve(h Handler) error {
+	peer := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1234}
+	for _, q := range l.queries {
+		buf := make([]byte, 512)
+		n := copy(buf, q)
+		rsize, err := h.ServeDNS("fake", peer, buf, n)
+		if err != nil {
+			return err
+		}
+		l.answers = append(l.answers, buf[:rsize])
+	}
+	<-l.closed
+	return errors.New("closed")
+}
+
+func (l *fakeListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *fakeListener) String() string {
+	return "fake"
+}
+
+type echoResolver struct{}
+
+func (echoResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	n := copy(buf, q.Payload)
+	buf[2] |= 0x80 // QR
+	return n, resolver.ResolveInfo{Transport: "echo"}, nil
+}
+
+func TestProxy_Listeners(t *testing.T) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("example.com."),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	})
+	q, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := &fakeListener{queries: [][]byte{q}}
+	logs := make(chan QueryInfo, 1)
+	listening := false
+	p := Proxy{
+		Addr:        "127.0.0.1:0",
+		Listeners:   []Listener{l},
+		Upstream:    echoResolver{},
+		QueryLog:    func(qi QueryInfo) { logs <- qi },
+		OnListening: func() { listening = l.opened },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- p.ListenAndServe(ctx) }()
+
+	var qi QueryInfo
+	select {
+	case qi = <-logs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for query")
+	}
+	cancel()
+	if err := <-errc; err != nil && !errors.Is(err, context.Canceled) {
+		t.Errorf("ListenAndServe() err = %v", err)
+	}
+
+	if !l.opened {
+		t.Error("listener not opened")
+	}
+	if !listening {
+		t.Error("OnListening not called once the listeners are open")
+	}
+	if len(l.answers) != 1 {
+		t.Fatalf("got %d answers, want 1", len(l.answers))
+	}
+	var h dnsmessage.Header
+	var pr dnsmessage.Parser
+	if h, err = pr.Start(l.answers[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !h.Response || h.ID != 42 {
+		t.Errorf("answer header = %v", h)
+	}
+	if qi.Protocol != "fake" || qi.Name != "example.com." || qi.Type != "A" || qi.UpstreamTransport != "echo" {
+		t.Errorf("query info = %+v", qi)
+	}
+	if !qi.PeerIP.Equal(net.IPv4(192, 168, 0, 2)) {
+		t.Errorf("peer ip = %v", qi.PeerIP)
+	}
+}
+
+type instanceResolver chan string
+
+func (r instanceResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	r <- q.Instance
+	return echoResolver{}.Resolve(ctx, q, buf)
+}
+
+func TestInstanceListener(t *testing.T) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("example.com."),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	})
+	q, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances := make(instanceResolver, 1)
+	p := Proxy{
+		Upstream: instances,
+		ACLs:     []ACL{{Allow: []*net.IPNet{{IP: net.IPv4(192, 168, 0, 0), Mask: net.CIDRMask(16, 32)}}}},
+	}
+	fl := &fakeListener{queries: [][]byte{q}}
+	// The ACLs are applied under the instance.
+	l := instanceListener{p.withACLs(fl, ""), "unfiltered"}
+	if err := l.Listen(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- l.Serve(p.withQueryContext()) }()
+	select {
+	case got := <-instances:
+		if got != "unfiltered" {
+			t.Errorf("query instance = %q, want unfiltered", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for query")
+	}
+	_ = l.Close()
+	<-errc
+	if want := "fake (unfiltered)"; l.String() != want {
+		t.Errorf("String() = %q, want %q", l.String(), want)
+	}
+}
+
+type echoHandler struct{}
+
+func (echoHandler) ServeDNS(protocol string, peer net.Addr, buf []byte, qsize int) (int, error) {
+	return qsize, nil
+}
+
+func TestUDPListener_Sockets(t *testing.T) {
+	// IPv4 sockets use batched writes, dual stack ones write packets one by
+	// one.
+	for _, addr := range []string{"127.0.0.1:0", ":0"} {
+		t.Run(addr, func(t *testing.T) {
+			testUDPListener(t, addr)
+		})
+	}
+}
+
+func testUDPListener(t *testing.T, addr string) {
+	l := &UDPListener{Addr: addr, Sockets: 4}
+	if err := l.Listen(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := 1
+	if reusePortSupported {
+		want = 4
+	}
+	if len(l.conns) != want {
+		t.Fatalf("%d sockets, want %d", len(l.conns), want)
+	}
+	port := l.conns[0].LocalAddr().(*net.UDPAddr).Port
+	for _, c := range l.conns[1:] {
+		if got := c.LocalAddr().(*net.UDPAddr).Port; got != port {
+			t.Errorf("socket bound to port %d, want %d", got, port)
+		}
+	}
+	errs := make(chan error)
+	go func() {
+		errs <- l.Serve(echoHandler{})
+	}()
+
+	c, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	q := make([]byte, 20)
+	q[0] = 42
+	for i := 0; i < 10; i++ {
+		if _, err := c.Write(q); err != nil {
+			t.Fatal(err)
+		}
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 512)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(q) || buf[0] != 42 {
+			t.Fatalf("unexpected response %x", buf[:n])
+		}
+	}
+
+	_ = l.Close()
+	if err := <-errs; err == nil {
+		t.Error("Serve() returned no error after Close")
+	}
+}
+
+func TestUDPListener_AddrInUse(t *testing.T) {
+	// A socket of another process sharing the port with SO_REUSEPORT must not
+	// let the listener join it and silently receive part of the traffic.
+	lc := &net.ListenConfig{Control: reusePort}
+	other, err := lc.ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	l := &UDPListener{Addr: other.LocalAddr().String(), Sockets: 4}
+	if err := l.Listen(context.Background()); err == nil {
+		l.Close()
+		t.Fatal("Listen succeeded on an address in use")
+	}
+
+	// Neither can a second listener join a multi-socket one.
+	l = &UDPListener{Addr: "127.0.0.1:0", Sockets: 4}
+	if err := l.Listen(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l2 := &UDPListener{Addr: l.conns[0].LocalAddr().String(), Sockets: 4}
+	if err := l2.Listen(context.Background()); err == nil {
+		l2.Close()
+		t.Fatal("Listen succeeded on the address of another listener")
+	}
+}
+
+type peerHandler struct {
+	peers chan net.Addr
+}
+
+func (h peerHandler) ServeDNS(protocol string, peer net.Addr, buf []byte, qsize int) (int, error) {
+	h.peers <- peer
+	return qsize, nil
+}
+
+func TestUnixListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nextdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dns.sock")
+	// Stale socket left by a previous run.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l := &UnixListener{Path: path}
+	if err := l.Listen(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&UnixListener{Path: path}).Listen(context.Background()); err == nil {
+		t.Error("Listen() on a socket in use returned no error")
+	}
+	h := peerHandler{peers: make(chan net.Addr, 1)}
+	errs := make(chan error)
+	go func() {
+		errs <- l.Serve(h)
+	}()
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	q := make([]byte, 20)
+	q[0] = 42
+	if err := writeTCP(c, q); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 512)
+	n, err := readTCP(c, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(q) || buf[0] != 42 {
+		t.Fatalf("unexpected response %x", buf[:n])
+	}
+	if peer := addrIP(<-h.peers); !peer.IsLoopback() {
+		t.Errorf("peer = %v, want loopback", peer)
+	}
+
+	_ = l.Close()
+	if err := <-errs; err == nil {
+		t.Error("Serve() returned no error after Close")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket not removed on Close: %v", err)
+	}
+}
+
+func TestUDPBufferPool_responseBuffer(t *testing.T) {
+	query := func(edns int) []byte {
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+		_ = b.StartQuestions()
+		_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+		if edns > 0 {
+			_ = b.StartAdditionals()
+			var opt dnsmessage.ResourceHeader
+			_ = opt.SetEDNS0(edns, dnsmessage.RCodeSuccess, false)
+			_ = b.OPTResource(opt, dnsmessage.OPTResource{})
+		}
+		q, _ := b.Finish()
+		return q
+	}
+	tests := []struct {
+		name     string
+		max      int
+		edns     int
+		wantSize int
+		wantBuf  int
+	}{
+		{"NoEDNS", 4096, 0, 512, 512},
+		{"Default", 0, 4096, 512, 512},
+		{"Small", 100, 1232, 100, 512},
+		{"EDNSBelow512", 4096, 256, 512, 512},
+		{"EDNS1232", 4096, 1232, 1232, 1232},
+		{"EDNS1400", 4096, 1400, 1400, 4096},
+		{"Capped", 8192, 65535, 4096, 4096},
+	}
+	pool := newUDPBufferPool()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Proxy{MaxUDPSize: tt.max}
+			q := query(tt.edns)
+			if got := p.UDPResponseSize(q); got != tt.wantSize {
+				t.Errorf("UDPResponseSize() = %d, want %d", got, tt.wantSize)
+			}
+			bp := pool.Get(defaultUDPSize)
+			n := copy(*bp, q)
+			bp = pool.responseBuffer(p, bp, n)
+			defer pool.Put(bp)
+			if len(*bp) != tt.wantBuf || string((*bp)[:n]) != string(q) {
+				t.Errorf("buffer of %d bytes, want %d holding the query", len(*bp), tt.wantBuf)
+			}
+		})
+	}
+}
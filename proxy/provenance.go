@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// provenanceName is the owner name of the TXT record holding the provenance
+// for clients without EDNS.
+const provenanceName = "provenance.nextdns."
+
+// maxTXTString is the maximum length of a TXT record string.
+const maxTXTString = 255
+
+// provenance describes where a response with the resolve info i comes from.
+func provenance(i resolver.ResolveInfo) string {
+	switch {
+	case i.Source != "":
+		return i.Source
+	case i.Transport == "cache":
+		return "cache"
+	case i.Endpoint != "":
+		s := "upstream " + i.Endpoint
+		if i.Transport != "" {
+			s += " over " + i.Transport
+		}
+		return s
+	}
+	return "local"
+}
+
+// hasEDNS returns true if the query q has an OPT record.
+func hasEDNS(q []byte) bool {
+	_, ok := ednsUDPSize(q)
+	return ok
+}
+
+// ednsUDPSize returns the UDP payload size advertised in the OPT record of the
+// query q, and false if q has none.
+func ednsUDPSize(q []byte) (int, bool) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(q); err != nil {
+		return 0, false
+	}
+	_ = p.SkipAllQuestions()
+	_ = p.SkipAllAnswers()
+	_ = p.SkipAllAuthorities()
+	for {
+		rh, err := p.AdditionalHeader()
+		if err != nil {
+			return 0, false
+		}
+		if rh.Type == dnsmessage.TypeOPT {
+			return int(rh.Class), true
+		}
+		if p.SkipAdditional() != nil {
+			return 0, false
+		}
+	}
+}
+
+// addProvenance adds the provenance of the response in buf[:n] as the text of
+// an Extended DNS Error (RFC 8914) when the client uses EDNS, or as a TXT
+// record of the additional section otherwise. The response is left untouched
+// if it cannot be parsed or would not fit in buf anymore. It returns the new
+// size of the response.
+func addProvenance(buf []byte, n int, i resolver.ResolveInfo, edns bool) (int, error) {
+	var m dnsmessage.Message
+	if m.Unpack(buf[:n]) != nil {
+		return n, nil
+	}
+	text := provenance(i)
+	code, ok := sourceError(i)
+	if !ok {
+		code = EDEOther
+	}
+	switch err := appendExtendedError(&m, code, text, edns); err {
+	case nil:
+	case errNoOPT:
+		if len(text) > maxTXTString {
+			text = text[:maxTXTString]
+		}
+		m.Additionals = append(m.Additionals, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{
+				Name:  dnsmessage.MustNewName(provenanceName),
+				Type:  dnsmessage.TypeTXT,
+				Class: dnsmessage.ClassINET,
+			},
+			Body: &dnsmessage.TXTResource{TXT: []string{text}},
+		})
+	default:
+		return n, err
+	}
+	b, err := m.Pack()
+	if err != nil {
+		return n, err
+	}
+	if len(b) > len(buf) {
+		return n, nil
+	}
+	return copy(buf, b), nil
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"sync/atomic"
 	"time"
 
-	"github.com/nextdns/nextdns/hosts"
+	"github.com/nextdns/nextdns/filter"
+	"github.com/nextdns/nextdns/mirror"
 	"github.com/nextdns/nextdns/resolver"
 )
 
@@ -15,12 +19,19 @@ import (
 type QueryInfo struct {
 	Protocol          string
 	PeerIP            net.IP
+	MAC               net.HardwareAddr
+	DeviceName        string
+	DeviceModel       string
 	Type              string
 	Name              string
 	QuerySize         int
 	ResponseSize      int
 	Duration          time.Duration
 	UpstreamTransport string
+	UpstreamTiming    resolver.Timing
+	Attempts          int
+	RCode             string
+	EDE               int // Extended DNS Error (RFC 8914) info code, -1 if none
 	Error             error
 }
 
@@ -29,9 +40,42 @@ type Proxy struct {
 	// Addr specifies the TCP/UDP address to listen to, :53 if empty.
 	Addr string
 
+	// Files specifies optional pre-opened sockets (i.e. passed by systemd
+	// socket activation) to serve on instead of listening on Addr. Stream
+	// sockets are served as TCP and datagram sockets as UDP.
+	Files []*os.File
+
+	// Listeners specifies additional listeners to serve queries on.
+	Listeners []Listener
+
+	// Instances specifies optional named sets of addresses to listen to in
+	// addition to Addr. The queries they receive carry the name of their
+	// instance, so they can be resolved with a different configuration while
+	// sharing the cache and upstream connections.
+	Instances []Instance
+
+	// ACLs specifies optional access control lists restricting the clients
+	// allowed to send queries to the listeners.
+	ACLs []ACL
+
+	// ACLDrop specifies that queries denied by ACLs are dropped instead of
+	// being answered with REFUSED.
+	ACLDrop bool
+
+	// Forwarders specifies the networks of the resolvers the proxy is the
+	// upstream of (i.e. AdGuard Home or Pi-hole), trusted to pass the identity
+	// of their clients. See forwardedClient for the expected options.
+	Forwarders []*net.IPNet
+
 	// Upstream specifies the resolver used for incoming queries.
 	Upstream resolver.Resolver
 
+	// Middlewares specifies optional stages queries go through before being
+	// answered locally (hosts, local reverse lookups, Filter) or sent to
+	// Upstream, the first being the outermost. It lets programs embedding the
+	// proxy inspect, answer or alter queries and their responses.
+	Middlewares []resolver.Middleware
+
 	// BogusPriv specifies that reverse lookup on private subnets are answerd
 	// with NXDOMAIN.
 	BogusPriv bool
@@ -40,9 +84,81 @@ type Proxy struct {
 	// upstream resolver.
 	UseHosts bool
 
-	// Timeout defines the maximum allowed time allowed for a request before
-	// being cancelled.
-	Timeout time.Duration
+	// LocalPTR specifies an optional function returning the name of the LAN
+	// client with the given IP. When a name is returned, reverse lookups on
+	// private subnets are answered locally with this name.
+	LocalPTR func(ip net.IP) string
+
+	// LocalNet specifies an optional function reporting addresses of local
+	// networks other than the private ones (i.e. the delegated IPv6 prefix).
+	// Reverse lookups of these addresses are handled like private ones.
+	LocalNet func(ip net.IP) bool
+
+	// Filter specifies an optional filter. Queries for blocked domains are
+	// answered locally.
+	Filter *filter.Filter
+
+	// FilterBypass specifies an optional function reporting the queries not
+	// subject to Filter (i.e. clients with filtering scheduled off).
+	FilterBypass func(q resolver.Query) bool
+
+	// Mirror specifies an optional mirror queries and responses are sent to.
+	Mirror *mirror.Mirror
+
+	// RewriteResponse specifies an optional function called with each response
+	// to q stored in buf[:n] before it is sent to the client. It returns the
+	// new size of the response.
+	RewriteResponse func(q resolver.Query, buf []byte, n int) (int, error)
+
+	// RefuseAny specifies that ANY queries are answered with a synthesized
+	// HINFO record as described in RFC 8482 instead of being resolved.
+	RefuseAny bool
+
+	// MinimalResponses specifies that the authority and additional records
+	// not needed by stub clients are removed from responses.
+	MinimalResponses bool
+
+	// MaxUDPSize specifies the maximum size of the responses sent over UDP,
+	// further limited by the size advertised by the client with EDNS, or 512
+	// without. Larger responses are replaced by an empty truncated response
+	// so the client retries over TCP, with an OPT record if the query has one.
+	// If zero, 512 is used, and it cannot be more than 4096.
+	MaxUDPSize int
+
+	// Provenance specifies an optional function reporting the queries whose
+	// response carries a description of where it comes from (i.e. cache,
+	// upstream endpoint or blocking rule), for debugging.
+	Provenance func(q resolver.Query) bool
+
+	// ExtendedErrors specifies that queries failing to resolve are answered
+	// with SERVFAIL instead of being dropped, and that an Extended DNS Error
+	// (RFC 8914) is attached to these responses and the blocked ones when the
+	// client uses EDNS, telling a failure (upstream timeout, TLS or network
+	// error) from a block or a rate limit.
+	ExtendedErrors bool
+
+	// Retry defines the maximum time allowed for a request before being
+	// cancelled and how failed upstream queries are retried.
+	Retry resolver.RetryPolicy
+
+	// Limits specifies optional limits on the queries accepted, and bans the
+	// sources of invalid queries.
+	Limits *Limits
+
+	// MaxInflight specifies the maximum number of queries processed
+	// concurrently, bounding the memory they use. Queries received above the
+	// limit are dropped, clients retry them. No limit if zero.
+	MaxInflight int
+
+	// ClientMAC specifies an optional function returning the MAC address of
+	// the client with the given IP when it is not found in the ARP table,
+	// so clients rotating IPv6 privacy addresses keep the same identity.
+	ClientMAC func(ip net.IP) net.HardwareAddr
+
+	// DeviceInfo specifies an optional function returning the name and model
+	// of the client with the given IP and MAC addresses. It is used to populate
+	// the DeviceName and DeviceModel fields of QueryInfo.
+	DeviceInfo func(ip net.IP, mac net.HardwareAddr) (name, model string)
 
 	// QueryLog specifies an optional log function called for each received query.
 	QueryLog func(QueryInfo)
@@ -54,81 +170,80 @@ type Proxy struct {
 	// ErrorLog specifies an optional log function for errors. If not set,
 	// errors are not reported.
 	ErrorLog func(error)
+
+	// instance is the name of the instance of the listener serving the
+	// queries, set by instanceListener.
+	instance string
+
+	// OnListening specifies an optional function called by ListenAndServe
+	// once all the listeners are open, before serving.
+	OnListening func()
+
+	// queryCtx is the parent context of the queries, carrying Retry. It is
+	// set by ListenAndServe.
+	queryCtx context.Context
+
+	// handler is the chain of stages built by ListenAndServe.
+	handler resolver.Resolver
+
+	// inflight holds a token per query processed when MaxInflight is set.
+	inflight chan struct{}
+
+	// overloadLogged is the Unix time in nanoseconds queries were last
+	// reported dropped for MaxInflight.
+	overloadLogged *int64
 }
 
 // ListenAndServe listens on UDP and TCP and serve DNS queries. If ctx is
 // canceled, listeners are closed and ListenAndServe returns context.Canceled
 // error.
 func (p Proxy) ListenAndServe(ctx context.Context) error {
-	addr := p.Addr
-	if addr == "" {
-		addr = ":53"
+	p = p.withQueryContext()
+	ls, err := p.listeners()
+	if err != nil {
+		return fmt.Errorf("proxy: %w", err)
 	}
 
-	var addrs []string
-
-	// Try to lookup the given addr in the /etc/hosts file (for localhost for
-	// instance).
-	if host, port, err := net.SplitHostPort(addr); err == nil {
-		if ips := hosts.LookupHost(host); len(ips) > 0 {
-			for _, ip := range ips {
-				addrs = append(addrs, net.JoinHostPort(ip, port))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var opened []Listener
+	for _, l := range ls {
+		if err := l.Listen(ctx); err != nil {
+			for _, l := range opened {
+				_ = l.Close()
 			}
+			return fmt.Errorf("proxy: %s: %w", l, err)
 		}
+		p.logInfof("Listening on %s", l)
+		opened = append(opened, l)
 	}
-
-	if len(addrs) == 0 {
-		addrs = []string{addr}
+	if p.OnListening != nil {
+		p.OnListening()
 	}
 
-	lc := &net.ListenConfig{}
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	expReturns := (len(addrs) * 2) + 1
-	errs := make(chan error, expReturns)
-	var closeAll []func() error
-
-	for _, addr := range addrs {
-		go func(addr string) {
-			var err error
-			p.logInfof("Listening on UDP/%s", addr)
-			udp, err := lc.ListenPacket(ctx, "udp", addr)
-			if err == nil {
-				closeAll = append(closeAll, udp.Close)
-				err = p.serveUDP(udp)
+	errs := make(chan error, len(opened)+1)
+	for _, l := range opened {
+		go func(l Listener) {
+			err := l.Serve(p)
+			if err != nil && ctx.Err() == nil {
+				err = fmt.Errorf("%s: %w", l, err)
+			} else {
+				err = nil
 			}
 			cancel()
-			if err != nil {
-				err = fmt.Errorf("udp: %w", err)
-			}
 			errs <- err
-		}(addr)
-
-		go func(addr string) {
-			var err error
-			p.logInfof("Listening on TCP/%s", addr)
-			tcp, err := lc.Listen(ctx, "tcp", addr)
-			if err == nil {
-				closeAll = append(closeAll, tcp.Close)
-				err = p.serveTCP(tcp)
-			}
-			cancel()
-			if err != nil {
-				err = fmt.Errorf("tcp: %w", err)
-			}
-			errs <- err
-		}(addr)
+		}(l)
 	}
 
 	<-ctx.Done()
 	errs <- ctx.Err()
-	for _, close := range closeAll {
-		close()
+	for _, l := range opened {
+		_ = l.Close()
 	}
-	// Wait for the two sockets (+ ctx err) to be terminated and return the
+	// Wait for the listeners (+ ctx err) to be terminated and return the
 	// initial error.
-	var err error
-	for i := 0; i < expReturns; i++ {
+	err = nil
+	for i := 0; i < len(opened)+1; i++ {
 		if e := <-errs; (err == nil || errors.Is(err, context.Canceled)) && e != nil {
 			err = e
 		}
@@ -139,21 +254,403 @@ func (p Proxy) ListenAndServe(ctx context.Context) error {
 	return nil
 }
 
-func (p Proxy) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
-	if p.UseHosts {
-		n, i, err = hostsResolve(q, buf)
+// withQueryContext returns p with the parent context of the queries and the
+// chain of stages created once instead of for each query.
+func (p Proxy) withQueryContext() Proxy {
+	p.queryCtx = resolver.ContextWithRetryPolicy(context.Background(), p.Retry)
+	p.handler = p.chain()
+	if p.MaxInflight > 0 {
+		p.inflight = make(chan struct{}, p.MaxInflight)
+		p.overloadLogged = new(int64)
+	}
+	return p
+}
+
+// listeners returns the listeners for Addr, or Files if set, followed by
+// Listeners.
+func (p Proxy) listeners() ([]Listener, error) {
+	var ls []Listener
+	if len(p.Files) > 0 {
+		// Sockets are dup'ed so the originals stay open for restarts.
+		var dups []io.Closer
+		for _, f := range p.Files {
+			if l, err := net.FileListener(f); err == nil {
+				dups = append(dups, l)
+				ls = append(ls, p.withACLs(&TCPListener{Listener: l, ErrorLog: p.ErrorLog}, ""))
+				continue
+			}
+			c, err := net.FilePacketConn(f)
+			if err != nil {
+				for _, d := range dups {
+					_ = d.Close()
+				}
+				return nil, fmt.Errorf("%s: %w", f.Name(), err)
+			}
+			dups = append(dups, c)
+			ls = append(ls, p.withACLs(&UDPListener{Conn: c}, ""))
+		}
+	} else {
+		addrs, err := p.listenAddrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			if a.network != "tcp" {
+				ls = append(ls, p.withACLs(&UDPListener{Addr: a.addr}, a.entry))
+			}
+			if a.network != "udp" {
+				ls = append(ls, p.withACLs(&TCPListener{Addr: a.addr, ErrorLog: p.ErrorLog}, a.entry))
+			}
+		}
+	}
+	for _, inst := range p.Instances {
+		addrs, err := parseListenAddrs(inst.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", inst.Name, err)
+		}
+		for _, a := range addrs {
+			if a.network != "tcp" {
+				ls = append(ls, instanceListener{p.withACLs(&UDPListener{Addr: a.addr}, a.entry), inst.Name})
+			}
+			if a.network != "udp" {
+				ls = append(ls, instanceListener{p.withACLs(&TCPListener{Addr: a.addr, ErrorLog: p.ErrorLog}, a.entry), inst.Name})
+			}
+		}
+	}
+	for _, l := range p.Listeners {
+		ls = append(ls, p.withACLs(l, ""))
+	}
+	return ls, nil
+}
+
+// Bind opens the UDP and TCP sockets for Addr and returns them as files
+// suitable for Files. It allows binding privileged ports before dropping
+// privileges.
+func (p Proxy) Bind() (files []*os.File, err error) {
+	defer func() {
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			files = nil
+		}
+	}()
+	addrs, err := p.listenAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if a.network != "tcp" {
+			udp, err := net.ListenPacket("udp", a.addr)
+			if err != nil {
+				return files, err
+			}
+			f, err := udp.(*net.UDPConn).File()
+			udp.Close()
+			if err != nil {
+				return files, err
+			}
+			files = append(files, f)
+		}
+		if a.network != "udp" {
+			tcp, err := net.Listen("tcp", a.addr)
+			if err != nil {
+				return files, err
+			}
+			f, err := tcp.(*net.TCPListener).File()
+			tcp.Close()
+			if err != nil {
+				return files, err
+			}
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// ServeDNS implements Handler interface.
+func (p Proxy) ServeDNS(protocol string, peer net.Addr, buf []byte, qsize int) (rsize int, err error) {
+	start := time.Now()
+	var ri resolver.ResolveInfo
+	var failure error
+	ede := -1
+	peerIP := addrIP(peer)
+	if p.Limits != nil && p.Limits.banned(peerIP) {
+		return 0, errBanned
+	}
+	if p.inflight != nil {
+		select {
+		case p.inflight <- struct{}{}:
+			defer func() { <-p.inflight }()
+		default:
+			p.logOverload()
+			return 0, errOverloaded
+		}
+	}
+	q, err := resolver.NewQuery(buf[:qsize], peerIP)
+	if p.Limits != nil {
 		if err == nil {
-			return
+			err = p.Limits.check(protocol, buf[:qsize], q.Name)
+		}
+		if err != nil {
+			// Crafted packets are not logged one by one, only the bans of
+			// their sources are.
+			p.Limits.fail(p.banSource(&q, peerIP))
+			if q.Name != "" {
+				return replyRefused(buf, qsize)
+			}
+			return 0, err
+		}
+	} else if err != nil {
+		p.logErr(err)
+	}
+	q.Instance = p.instance
+	if len(p.Forwarders) > 0 {
+		p.forwardedClient(&q, peerIP)
+		if p.Limits != nil && !q.PeerIP.Equal(peerIP) && p.Limits.banned(q.PeerIP) {
+			return 0, errBanned
+		}
+	}
+	if q.MAC == nil && p.ClientMAC != nil && q.PeerIP != nil && !q.PeerIP.IsLoopback() {
+		q.MAC = p.ClientMAC(q.PeerIP)
+	}
+	if p.Mirror != nil {
+		// The query is overwritten by the response in buf.
+		query := append([]byte(nil), buf[:qsize]...)
+		defer func() {
+			m := mirror.Message{
+				Time:     start,
+				Duration: time.Since(start),
+				Protocol: protocol,
+				Peer:     peer,
+				Name:     q.Name,
+				Query:    query,
+			}
+			if err == nil && rsize > 0 {
+				m.Response = buf[:rsize]
+			}
+			p.Mirror.Send(m)
+		}()
+	}
+	defer func() {
+		var rcode string
+		if err == nil && rsize >= 4 {
+			rcode = rcodeName(buf[3] & 0xf)
 		}
+		qerr := err
+		if qerr == nil {
+			qerr = failure
+		}
+		p.logQuery(QueryInfo{
+			PeerIP:            q.PeerIP,
+			MAC:               q.MAC,
+			Protocol:          protocol,
+			Type:              q.Type,
+			Name:              q.Name,
+			QuerySize:         qsize,
+			ResponseSize:      rsize,
+			Duration:          time.Since(start),
+			UpstreamTransport: ri.Transport,
+			UpstreamTiming:    ri.Timing,
+			Attempts:          ri.Attempts,
+			RCode:             rcode,
+			EDE:               ede,
+			Error:             qerr,
+		})
+	}()
+	parent := p.queryCtx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := resolver.WithRetryPolicy(parent, p.Retry)
+	defer cancel()
+	// The query is overwritten by the response in buf.
+	var udpSize int
+	if protocol == "UDP" {
+		udpSize = p.UDPResponseSize(buf[:qsize])
+	}
+	provenance := p.Provenance != nil && p.Provenance(q)
+	var edns bool
+	if protocol == "UDP" || provenance || p.ExtendedErrors {
+		edns = hasEDNS(q.Payload)
+	}
+	var query []byte
+	if p.ExtendedErrors {
+		// Kept to answer with SERVFAIL, buf being possibly overwritten by a
+		// failed resolution.
+		query = append([]byte(nil), buf[:qsize]...)
+	}
+	rsize, ri, err = p.Resolve(ctx, q, buf)
+	if err != nil {
+		code, text := resolveError(err)
+		ede = code
+		if p.ExtendedErrors {
+			failure = err
+			rsize, err = replyServFail(query, buf)
+			if err == nil && edns {
+				rsize, err = addExtendedError(buf, rsize, code, text)
+			}
+			if err != nil {
+				// Dropped as if ExtendedErrors was not set.
+				err = failure
+			}
+		}
+	} else if code, ok := sourceError(ri); ok {
+		ede = code
+	}
+	if err == nil && rsize > 0 && p.RewriteResponse != nil {
+		rsize, err = p.RewriteResponse(q, buf, rsize)
+	}
+	if err == nil && rsize > 0 && p.MinimalResponses {
+		rsize, err = minimizeResponse(buf, rsize)
+	}
+	if err == nil && rsize > 0 && provenance {
+		rsize, err = addProvenance(buf, rsize, ri, edns)
+	} else if err == nil && rsize > 0 && failure == nil && ede >= 0 && p.ExtendedErrors && edns {
+		rsize, err = addExtendedError(buf, rsize, ede, "")
+	}
+	if err == nil && protocol == "UDP" && p.truncateUDP(buf, rsize, udpSize) {
+		rsize, err = replyTruncated(q, buf, edns, udpSize)
+	}
+	return rsize, err
+}
+
+// UDPResponseSize returns the maximum size of the UDP response to query: the
+// smallest of MaxUDPSize and the size advertised by the client.
+func (p Proxy) UDPResponseSize(query []byte) int {
+	max := p.MaxUDPSize
+	if max <= 0 {
+		max = defaultUDPSize
+	} else if max > maxUDPSize {
+		max = maxUDPSize
+	}
+	size, ok := ednsUDPSize(query)
+	if !ok || size < defaultUDPSize {
+		// Sizes below 512 are treated as 512 (RFC 6891).
+		size = defaultUDPSize
 	}
-	if p.BogusPriv && q.Type == "PTR" && isPrivateReverse(q.Name) {
-		return replyNXDomain(q, buf)
+	if size < max {
+		max = size
 	}
-	return p.Upstream.Resolve(ctx, q, buf)
+	return max
+}
+
+// truncateUDP returns true if the response in buf[:n] must be truncated to be
+// sent over UDP: it is larger than max or was cut to fit in buf.
+func (p Proxy) truncateUDP(buf []byte, n, max int) bool {
+	return n > max || (n >= len(buf) && n > 2 && buf[2]&0x2 != 0)
+}
+
+// Resolve resolves q through Middlewares, the local stages and Upstream.
+func (p Proxy) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	h := p.handler
+	if h == nil {
+		h = p.chain()
+	}
+	return h.Resolve(ctx, q, buf)
+}
+
+// chain returns Upstream wrapped by Middlewares followed by the enabled local
+// stages.
+func (p Proxy) chain() resolver.Resolver {
+	mws := append([]resolver.Middleware{}, p.Middlewares...)
+	mws = append(mws, p.refuseAnyStage(), p.hostsStage(), p.localReverseStage(), p.filterStage())
+	return resolver.Chain(p.Upstream, mws...)
+}
+
+// refuseAnyStage answers ANY queries with a HINFO record if RefuseAny is set.
+func (p Proxy) refuseAnyStage() resolver.Middleware {
+	if !p.RefuseAny {
+		return nil
+	}
+	return func(next resolver.Resolver) resolver.Resolver {
+		return resolver.ResolverFunc(func(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+			if q.Type != "ALL" {
+				return next.Resolve(ctx, q, buf)
+			}
+			n, i, err = replyHINFO(q, buf)
+			i.Source = "any refused"
+			return
+		})
+	}
+}
+
+// hostsStage answers the queries found in /etc/hosts if UseHosts is set.
+func (p Proxy) hostsStage() resolver.Middleware {
+	if !p.UseHosts {
+		return nil
+	}
+	return func(next resolver.Resolver) resolver.Resolver {
+		return resolver.ResolverFunc(func(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+			if n, i, err = hostsResolve(q, buf); err == nil {
+				i.Source = "hosts"
+				return
+			}
+			return next.Resolve(ctx, q, buf)
+		})
+	}
+}
+
+// localReverseStage answers reverse lookups on local networks with the name
+// of the client or NXDOMAIN, according to LocalPTR and BogusPriv.
+func (p Proxy) localReverseStage() resolver.Middleware {
+	if p.LocalPTR == nil && !p.BogusPriv {
+		return nil
+	}
+	return func(next resolver.Resolver) resolver.Resolver {
+		return resolver.ResolverFunc(func(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+			if q.Type != "PTR" || !isPrivateReverse(q.Name) && !p.isLocalReverse(q.Name) {
+				return next.Resolve(ctx, q, buf)
+			}
+			if p.LocalPTR != nil {
+				if name := p.LocalPTR(ptrIP(q.Name)); name != "" {
+					n, i, err = replyPTR(q, name, buf)
+					i.Source = "local client name"
+					return
+				}
+			}
+			if p.BogusPriv {
+				n, i, err = replyNXDomain(q, buf)
+				i.Source = "bogus-priv"
+				return
+			}
+			return next.Resolve(ctx, q, buf)
+		})
+	}
+}
+
+// filterStage answers the queries blocked by Filter.
+func (p Proxy) filterStage() resolver.Middleware {
+	if p.Filter == nil {
+		return nil
+	}
+	return func(next resolver.Resolver) resolver.Resolver {
+		return resolver.ResolverFunc(func(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+			if p.FilterBypass == nil || !p.FilterBypass(q) {
+				if rule, blocked := p.Filter.MatchRule(q.Name); blocked {
+					n, i, err = p.Filter.Reply(q, buf)
+					i.Source = "blocked by rule " + rule
+					return
+				}
+			}
+			return next.Resolve(ctx, q, buf)
+		})
+	}
+}
+
+func (p Proxy) isLocalReverse(qname string) bool {
+	if p.LocalNet == nil {
+		return false
+	}
+	ip := ptrIP(qname)
+	return ip != nil && p.LocalNet(ip)
 }
 
 func (p Proxy) logQuery(q QueryInfo) {
 	if p.QueryLog != nil {
+		if p.DeviceInfo != nil {
+			q.DeviceName, q.DeviceModel = p.DeviceInfo(q.PeerIP, q.MAC)
+		}
 		p.QueryLog(q)
 	}
 }
@@ -169,3 +666,14 @@ func (p Proxy) logErr(err error) {
 		p.ErrorLog(err)
 	}
 }
+
+// logOverload reports queries dropped for MaxInflight, at most once per
+// overloadLogInterval.
+func (p Proxy) logOverload() {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(p.overloadLogged)
+	if now-last < int64(overloadLogInterval) || !atomic.CompareAndSwapInt64(p.overloadLogged, last, now) {
+		return
+	}
+	p.logErr(fmt.Errorf("%d queries in flight: dropping new queries", p.MaxInflight))
+}
@@ -0,0 +1,443 @@
+package proxy
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/nextdns/nextdns/filter"
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+func BenchmarkProxy_ServeDNS(b *testing.B) {
+	bld := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	_ = bld.StartQuestions()
+	_ = bld.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("www.example.com."),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	})
+	q, err := bld.Finish()
+	if err != nil {
+		b.Fatal(err)
+	}
+	p := Proxy{
+		Upstream: echoResolver{},
+		QueryLog: func(QueryInfo) {},
+	}.withQueryContext()
+	peer := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1234}
+	buf := make([]byte, maxUDPSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		n := copy(buf, q)
+		if _, err := p.ServeDNS("UDP", peer, buf, n); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestProxy_LocalPTR(t *testing.T) {
+	localPTR := func(ip net.IP) string {
+		if ip.Equal(net.IPv4(192, 168, 0, 2)) {
+			return "laptop.lan."
+		}
+		if ip.Equal(net.IPv4(8, 8, 8, 8)) {
+			t.Errorf("LocalPTR called for public IP %v", ip)
+		}
+		return ""
+	}
+	tests := []struct {
+		name      string
+		bogusPriv bool
+		want      string // rcode and answer of the response
+	}{
+		{"2.0.168.192.in-addr.arpa.", false, "RCodeSuccess PTR laptop.lan."},
+		{"2.0.168.192.in-addr.arpa.", true, "RCodeSuccess PTR laptop.lan."},
+		{"3.0.168.192.in-addr.arpa.", true, "RCodeNameError"},
+		{"3.0.168.192.in-addr.arpa.", false, "RCodeSuccess A"},
+		{"8.8.8.8.in-addr.arpa.", false, "RCodeSuccess A"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%v", tt.name, tt.bogusPriv), func(t *testing.T) {
+			p := Proxy{
+				Upstream:  bigResolver{1},
+				BogusPriv: tt.bogusPriv,
+				LocalPTR:  localPTR,
+				QueryLog:  func(QueryInfo) {},
+			}.withQueryContext()
+			bld := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+			_ = bld.StartQuestions()
+			_ = bld.Question(dnsmessage.Question{
+				Name:  dnsmessage.MustNewName(tt.name),
+				Type:  dnsmessage.TypePTR,
+				Class: dnsmessage.ClassINET,
+			})
+			q, _ := bld.Finish()
+			buf := make([]byte, maxUDPSize)
+			n, err := p.ServeDNS("UDP", &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1234}, buf, copy(buf, q))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m dnsmessage.Message
+			if err := m.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			got := m.RCode.String()
+			for _, rr := range m.Answers {
+				switch body := rr.Body.(type) {
+				case *dnsmessage.PTRResource:
+					got += " PTR " + body.PTR.String()
+				case *dnsmessage.AResource:
+					got += " A"
+				}
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if m.ID != 42 || !m.Response {
+				t.Errorf("invalid response header: %+v", m.Header)
+			}
+		})
+	}
+}
+
+// bigResolver answers with count A records, an NS authority and glue records.
+type bigResolver struct {
+	count int
+}
+
+func (r bigResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	var p dnsmessage.Parser
+	h, _ := p.Start(q.Payload)
+	q1, _ := p.Question()
+	h.Response = true
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	b.EnableCompression()
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	_ = b.StartAnswers()
+	rh := dnsmessage.ResourceHeader{Name: q1.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60}
+	for i := 0; i < r.count; i++ {
+		_ = b.AResource(rh, dnsmessage.AResource{A: [4]byte{10, 0, 0, byte(i)}})
+	}
+	ns := dnsmessage.MustNewName("ns.example.com.")
+	_ = b.StartAuthorities()
+	_ = b.NSResource(dnsmessage.ResourceHeader{Name: q1.Name, Type: dnsmessage.TypeNS, Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.NSResource{NS: ns})
+	_ = b.StartAdditionals()
+	_ = b.AResource(dnsmessage.ResourceHeader{Name: ns, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.AResource{A: [4]byte{10, 1, 0, 1}})
+	var opt dnsmessage.ResourceHeader
+	_ = opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false)
+	_ = b.OPTResource(opt, dnsmessage.OPTResource{})
+	out, err := b.Finish()
+	return len(out), resolver.ResolveInfo{Transport: "test", Endpoint: "https://dns.nextdns.io"}, err
+}
+
+func TestProxy_hardening(t *testing.T) {
+	tests := []struct {
+		name     string
+		proxy    Proxy
+		protocol string
+		qtype    dnsmessage.Type
+		count    int
+		want     string // type:count of the answers, authorities and additionals
+		tc       bool
+		edns     bool
+	}{
+		{"Default", Proxy{}, "UDP", dnsmessage.TypeA, 2, "A:2 NS:1 A,OPT:2", false, false},
+		{"Minimal", Proxy{MinimalResponses: true}, "UDP", dnsmessage.TypeA, 2, "A:2 :0 OPT:1", false, false},
+		{"AnyAllowed", Proxy{}, "UDP", dnsmessage.TypeALL, 2, "A:2 NS:1 A,OPT:2", false, false},
+		{"AnyRefused", Proxy{RefuseAny: true}, "TCP", dnsmessage.TypeALL, 2, "HINFO:1 :0 :0", false, false},
+		{"MaxUDPSize", Proxy{MaxUDPSize: 100}, "UDP", dnsmessage.TypeA, 10, ":0 :0 :0", true, false},
+		{"MaxUDPSizeEDNS", Proxy{MaxUDPSize: 100}, "UDP", dnsmessage.TypeA, 10, ":0 :0 OPT:1", true, true},
+		{"MaxUDPSizeTCP", Proxy{MaxUDPSize: 100}, "TCP", dnsmessage.TypeA, 10, "A:10 NS:1 A,OPT:2", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bld := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+			_ = bld.StartQuestions()
+			_ = bld.Question(dnsmessage.Question{
+				Name:  dnsmessage.MustNewName("example.com."),
+				Type:  tt.qtype,
+				Class: dnsmessage.ClassINET,
+			})
+			if tt.edns {
+				_ = bld.StartAdditionals()
+				var opt dnsmessage.ResourceHeader
+				_ = opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false)
+				_ = bld.OPTResource(opt, dnsmessage.OPTResource{})
+			}
+			q, _ := bld.Finish()
+			p := tt.proxy
+			p.Upstream = bigResolver{tt.count}
+			p = p.withQueryContext()
+			buf := make([]byte, maxTCPSize)
+			n := copy(buf, q)
+			n, err := p.ServeDNS(tt.protocol, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1234}, buf, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m dnsmessage.Message
+			if err := m.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			section := func(rrs []dnsmessage.Resource) string {
+				var types []string
+				for _, rr := range rrs {
+					typ := strings.TrimPrefix(rr.Header.Type.String(), "Type")
+					if len(types) == 0 || types[len(types)-1] != typ {
+						types = append(types, typ)
+					}
+				}
+				return fmt.Sprintf("%s:%d", strings.Join(types, ","), len(rrs))
+			}
+			got := section(m.Answers) + " " + section(m.Authorities) + " " + section(m.Additionals)
+			if got != tt.want || m.Truncated != tt.tc {
+				t.Errorf("got %s tc=%v, want %s tc=%v", got, m.Truncated, tt.want, tt.tc)
+			}
+		})
+	}
+}
+
+func TestProxy_provenance(t *testing.T) {
+	f := &filter.Filter{BlockRules: []string{"||ads.example.com^"}}
+	f.Reload(context.Background())
+	p := Proxy{
+		Upstream:   bigResolver{1},
+		Filter:     f,
+		Provenance: func(q resolver.Query) bool { return true },
+	}.withQueryContext()
+	tests := []struct {
+		name string
+		edns bool
+		want string
+	}{
+		{"www.example.com.", false, "EDE 0: upstream https://dns.nextdns.io over test"},
+		{"ads.example.com.", false, "TXT: blocked by rule ads.example.com."},
+		{"ads.example.com.", true, "EDE 15: blocked by rule ads.example.com."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bld := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+			_ = bld.StartQuestions()
+			_ = bld.Question(dnsmessage.Question{
+				Name:  dnsmessage.MustNewName(tt.name),
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET,
+			})
+			if tt.edns {
+				var opt dnsmessage.ResourceHeader
+				_ = opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false)
+				_ = bld.StartAdditionals()
+				_ = bld.OPTResource(opt, dnsmessage.OPTResource{})
+			}
+			q, _ := bld.Finish()
+			buf := make([]byte, maxUDPSize)
+			n, err := p.ServeDNS("UDP", &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1234}, buf, copy(buf, q))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m dnsmessage.Message
+			if err := m.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			for _, rr := range m.Additionals {
+				switch body := rr.Body.(type) {
+				case *dnsmessage.OPTResource:
+					for _, o := range body.Options {
+						if o.Code == optionEDE {
+							got = fmt.Sprintf("EDE %d: %s", int(o.Data[0])<<8|int(o.Data[1]), o.Data[2:])
+						}
+					}
+				case *dnsmessage.TXTResource:
+					got = "TXT: " + strings.Join(body.TXT, "")
+				}
+			}
+			if got != tt.want {
+				t.Errorf("provenance = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// errResolver fails with err.
+type errResolver struct {
+	err error
+}
+
+func (r errResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	return 0, resolver.ResolveInfo{}, r.err
+}
+
+func TestProxy_extendedErrors(t *testing.T) {
+	f := &filter.Filter{BlockRules: []string{"||ads.example.com^"}}
+	f.Reload(context.Background())
+	tests := []struct {
+		name     string
+		upstream resolver.Resolver
+		edns     bool
+		want     string // rcode and EDE of the response
+		wantEDE  int
+	}{
+		{"www.example.com.", bigResolver{1}, true, "RCodeSuccess", -1},
+		{"ads.example.com.", bigResolver{1}, true, "RCodeNameError EDE 15", EDEBlocked},
+		{"ads.example.com.", bigResolver{1}, false, "RCodeNameError", EDEBlocked},
+		{"www.example.com.", errResolver{fmt.Errorf("doh resolve: %w", context.DeadlineExceeded)}, true, "RCodeServerFailure EDE 22: upstream timeout", EDENoReachableAuthority},
+		{"www.example.com.", errResolver{fmt.Errorf("doh resolve: %w", x509.UnknownAuthorityError{})}, true, "RCodeServerFailure EDE 23: upstream TLS failure", EDENetworkError},
+		{"www.example.com.", errResolver{&net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true, "RCodeServerFailure EDE 23: upstream unreachable", EDENetworkError},
+		{"www.example.com.", errResolver{errors.New("doh resolve: 500 Internal Server Error")}, false, "RCodeServerFailure", EDEOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotEDE := -2
+			p := Proxy{
+				Upstream:       tt.upstream,
+				Filter:         f,
+				ExtendedErrors: true,
+				QueryLog:       func(qi QueryInfo) { gotEDE = qi.EDE },
+			}.withQueryContext()
+			bld := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+			_ = bld.StartQuestions()
+			_ = bld.Question(dnsmessage.Question{
+				Name:  dnsmessage.MustNewName(tt.name),
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET,
+			})
+			if tt.edns {
+				var opt dnsmessage.ResourceHeader
+				_ = opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false)
+				_ = bld.StartAdditionals()
+				_ = bld.OPTResource(opt, dnsmessage.OPTResource{})
+			}
+			q, _ := bld.Finish()
+			buf := make([]byte, maxUDPSize)
+			n, err := p.ServeDNS("UDP", &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1234}, buf, copy(buf, q))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m dnsmessage.Message
+			if err := m.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			got := m.RCode.String()
+			for _, rr := range m.Additionals {
+				if body, ok := rr.Body.(*dnsmessage.OPTResource); ok {
+					for _, o := range body.Options {
+						if o.Code == optionEDE {
+							got += fmt.Sprintf(" EDE %d", int(o.Data[0])<<8|int(o.Data[1]))
+							if len(o.Data) > 2 {
+								got += ": " + string(o.Data[2:])
+							}
+						}
+					}
+				}
+			}
+			if got != tt.want || gotEDE != tt.wantEDE {
+				t.Errorf("got %q (EDE %d), want %q (EDE %d)", got, gotEDE, tt.want, tt.wantEDE)
+			}
+		})
+	}
+}
+
+// blockingResolver echoes the queries once release is closed.
+type blockingResolver struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r blockingResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	r.started <- struct{}{}
+	<-r.release
+	return echoResolver{}.Resolve(ctx, q, buf)
+}
+
+func TestProxy_maxInflight(t *testing.T) {
+	r := blockingResolver{started: make(chan struct{}), release: make(chan struct{})}
+	var logged []error
+	p := Proxy{
+		Upstream:    r,
+		MaxInflight: 1,
+		ErrorLog:    func(err error) { logged = append(logged, err) },
+	}.withQueryContext()
+	bld := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	_ = bld.StartQuestions()
+	_ = bld.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	q, _ := bld.Finish()
+	peer := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1234}
+	serve := func() error {
+		buf := make([]byte, maxUDPSize)
+		_, err := p.ServeDNS("UDP", peer, buf, copy(buf, q))
+		return err
+	}
+
+	errs := make(chan error)
+	go func() { errs <- serve() }()
+	<-r.started
+	for i := 0; i < 2; i++ {
+		if err := serve(); err != errOverloaded {
+			t.Errorf("ServeDNS() err = %v, want %v", err, errOverloaded)
+		}
+	}
+	if len(logged) != 1 {
+		t.Errorf("%d errors logged, want 1", len(logged))
+	}
+	close(r.release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	go func() { <-r.started }()
+	if err := serve(); err != nil {
+		t.Errorf("ServeDNS() after release err = %v", err)
+	}
+}
+
+func TestProxy_Trace(t *testing.T) {
+	f := &filter.Filter{BlockRules: []string{"||ads.example.com^"}}
+	f.Reload(context.Background())
+	p := Proxy{
+		Upstream: resolver.ResolverFunc(func(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+			resolver.Tracef(ctx, "upstream", "sent")
+			return bigResolver{1}.Resolve(ctx, q, buf)
+		}),
+		Filter: f,
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"www.example.com.", "upstream: sent, answer: from upstream https://dns.nextdns.io over test"},
+		{"ads.example.com.", "answer: from blocked by rule ads.example.com."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bld := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+			_ = bld.StartQuestions()
+			_ = bld.Question(dnsmessage.Question{
+				Name:  dnsmessage.MustNewName(tt.name),
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET,
+			})
+			payload, _ := bld.Finish()
+			q, err := resolver.NewQuery(payload, net.IPv4(127, 0, 0, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := &resolver.Trace{}
+			if _, _, err := p.Trace(context.Background(), q, make([]byte, maxUDPSize), tr); err != nil {
+				t.Fatal(err)
+			}
+			var steps []string
+			for _, s := range tr.Steps() {
+				steps = append(steps, s.Stage+": "+s.Message)
+			}
+			if got := strings.Join(steps, ", "); got != tt.want {
+				t.Errorf("trace = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
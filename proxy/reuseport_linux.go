@@ -0,0 +1,41 @@
+// +build linux
+
+package proxy
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePortSupported reports whether the kernel load balances the packets
+// between the sockets bound to the same address with SO_REUSEPORT.
+const reusePortSupported = true
+
+// reusePort sets SO_REUSEPORT on the socket so several sockets can be bound to
+// the same address.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// setReusePort sets SO_REUSEPORT on the bound socket c so other sockets can
+// join it.
+func setReusePort(c net.PacketConn) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return reusePort("", "", rc)
+}
@@ -0,0 +1,20 @@
+// +build !linux
+
+package proxy
+
+import (
+	"net"
+	"syscall"
+)
+
+// reusePortSupported is false as the load balancing of SO_REUSEPORT sockets is
+// only supported on Linux.
+const reusePortSupported = false
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+func setReusePort(c net.PacketConn) error {
+	return nil
+}
@@ -8,23 +8,73 @@ import (
 	"io"
 	"net"
 	"sync"
-	"time"
-
-	"github.com/nextdns/nextdns/resolver"
 )
 
 const maxTCPSize = 65535
 
-func (p Proxy) serveTCP(l net.Listener) error {
+// TCPListener is a Listener for DNS over TCP.
+type TCPListener struct {
+	// Addr specifies the TCP address to listen to. It is ignored if Listener is
+	// set.
+	Addr string
+
+	// Listener specifies an optional pre-opened listener. It is closed by
+	// Close.
+	Listener net.Listener
+
+	l net.Listener
+	// done is closed by Close to stop the io_uring loop.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// ErrorLog specifies an optional log function for connection errors.
+	ErrorLog func(error)
+}
+
+func (l *TCPListener) String() string {
+	if l.Listener != nil {
+		return "TCP/" + l.Listener.Addr().String()
+	}
+	return "TCP/" + l.Addr
+}
+
+// Listen implements Listener interface.
+func (l *TCPListener) Listen(ctx context.Context) (err error) {
+	l.done, l.closeOnce = make(chan struct{}), sync.Once{}
+	if l.Listener != nil {
+		l.l = l.Listener
+		return nil
+	}
+	lc := &net.ListenConfig{}
+	l.l, err = lc.Listen(ctx, "tcp", l.Addr)
+	return err
+}
+
+// Close implements Listener interface.
+func (l *TCPListener) Close() error {
+	if l.l == nil {
+		return nil
+	}
+	if l.done != nil {
+		l.closeOnce.Do(func() { close(l.done) })
+	}
+	return l.l.Close()
+}
+
+// Serve implements Listener interface.
+func (l *TCPListener) Serve(h Handler) error {
 	bpool := &sync.Pool{
 		New: func() interface{} {
 			b := make([]byte, maxTCPSize)
 			return &b
 		},
 	}
+	if tl, ok := l.l.(*net.TCPListener); ok && ioUringSupported() {
+		return serveTCPRing(tl, h, bpool, l.ErrorLog, l.done)
+	}
 
 	for {
-		c, err := l.Accept()
+		c, err := l.l.Accept()
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
 				continue
@@ -32,76 +82,55 @@ func (p Proxy) serveTCP(l net.Listener) error {
 			return err
 		}
 		go func() {
-			if err := p.serveTCPConn(c, bpool); err != nil {
-				if p.ErrorLog != nil {
-					p.ErrorLog(err)
+			if err := serveTCPConn("TCP", c, c.RemoteAddr(), h, bpool); err != nil {
+				if l.ErrorLog != nil {
+					l.ErrorLog(err)
 				}
 			}
 		}()
 	}
 }
 
-func (p Proxy) serveTCPConn(c net.Conn, bpool *sync.Pool) error {
+// serveTCPConn serves the length prefixed queries received on c from peer over
+// protocol.
+func serveTCPConn(protocol string, c net.Conn, peer net.Addr, h Handler, bpool *sync.Pool) error {
 	defer c.Close()
 
+	var wmu sync.Mutex
 	for {
-		buf := *bpool.Get().(*[]byte)
+		bp := bpool.Get().(*[]byte)
+		buf := *bp
 		qsize, err := readTCP(c, buf)
 		if err != nil {
+			bpool.Put(bp)
 			if err == io.EOF {
 				return nil
 			}
-			return fmt.Errorf("TCP read: %v", err)
+			return fmt.Errorf("%s read: %v", protocol, err)
 		}
 		if qsize <= 14 {
+			bpool.Put(bp)
 			return fmt.Errorf("query too small: %d", qsize)
 		}
-		start := time.Now()
 		go func() {
-			var err error
-			var rsize int
-			var ri resolver.ResolveInfo
-			ip := addrIP(c.RemoteAddr())
-			q, err := resolver.NewQuery(buf[:qsize], ip)
-			if err != nil {
-				p.logErr(err)
-			}
-			defer func() {
-				bpool.Put(&buf)
-				p.logQuery(QueryInfo{
-					PeerIP:            q.PeerIP,
-					Protocol:          "TCP",
-					Type:              q.Type,
-					Name:              q.Name,
-					QuerySize:         qsize,
-					ResponseSize:      rsize,
-					Duration:          time.Since(start),
-					UpstreamTransport: ri.Transport,
-					Error:             err,
-				})
-			}()
-			ctx := context.Background()
-			if p.Timeout > 0 {
-				var cancel context.CancelFunc
-				ctx, cancel = context.WithTimeout(ctx, p.Timeout)
-				defer cancel()
-			}
-			if rsize, ri, err = p.Resolve(ctx, q, buf); err != nil {
-				return
-			}
-			if rsize > maxTCPSize {
+			defer bpool.Put(bp)
+			rsize, err := h.ServeDNS(protocol, peer, buf, qsize)
+			if err != nil || rsize > maxTCPSize {
 				return
 			}
-			err = writeTCP(c, buf[:rsize])
+			wmu.Lock()
+			defer wmu.Unlock()
+			_ = writeTCP(c, buf[:rsize])
 		}()
 	}
 }
 
 func readTCP(r io.Reader, buf []byte) (int, error) {
-	var length uint16
-	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+	// The length prefix is read in buf so it does not escape to the heap.
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
 		return -1, err
 	}
+	length := binary.BigEndian.Uint16(buf)
 	if length > maxTCPSize {
 		return -1, errors.New("message too large")
 	}
@@ -0,0 +1,262 @@
+// +build linux,iouring
+
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpRing serves a TCP listener and its connections with an io_uring.
+type tcpRing struct {
+	*ringLoop
+	fd       int
+	h        Handler
+	bpool    *sync.Pool
+	errorLog func(error)
+	conns    map[*tcpRingConn]struct{}
+}
+
+// tcpRingConn is a connection served by a tcpRing.
+type tcpRingConn struct {
+	fd   int
+	peer net.Addr
+	hdr  [2]byte
+	// reading is false once the connection is closed by the client or
+	// failed, handlers is the number of queries being handled and out the
+	// responses to send, out[0] being in progress. The responses are dropped
+	// once failed is set.
+	reading  bool
+	failed   bool
+	handlers int
+	out      []*ringOp
+}
+
+// serveTCPRing serves the connections accepted on ln with an io_uring until
+// done is closed.
+func serveTCPRing(ln *net.TCPListener, h Handler, bpool *sync.Pool, errorLog func(error), done <-chan struct{}) error {
+	l, err := newRingLoop()
+	if err != nil {
+		return err
+	}
+	defer l.close()
+	fd, err := dupSocket(ln)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if errorLog == nil {
+		errorLog = func(error) {}
+	}
+	s := &tcpRing{ringLoop: l, fd: fd, h: h, bpool: bpool, errorLog: errorLog, conns: map[*tcpRingConn]struct{}{}}
+	defer func() {
+		for c := range s.conns {
+			unix.Close(c.fd)
+		}
+	}()
+	op := &ringOp{}
+	op.done = func(res int32) error {
+		return s.accepted(op, res)
+	}
+	if err := s.accept(op); err != nil {
+		return err
+	}
+	return l.run(done)
+}
+
+func (s *tcpRing) accept(op *ringOp) error {
+	op.namelen = unix.SizeofSockaddrAny
+	return s.submit(op, ioringOpAccept, s.fd, unsafe.Pointer(&op.name), 0,
+		uint64(uintptr(unsafe.Pointer(&op.namelen))), unix.SOCK_CLOEXEC)
+}
+
+func (s *tcpRing) accepted(op *ringOp, res int32) error {
+	if s.stopping {
+		if res >= 0 {
+			unix.Close(int(res))
+		}
+		return nil
+	}
+	if res < 0 {
+		// Like net.TCPListener, temporary errors (i.e. EMFILE) are retried.
+		if !temporaryRingErr(res) && !syscall.Errno(-res).Temporary() {
+			return ringErr(res)
+		}
+		return s.accept(op)
+	}
+	fd := int(res)
+	// Same options as the connections accepted by net.TCPListener.
+	_ = unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, 1)
+	_ = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1)
+	_ = unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, 15)
+	_ = unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, 15)
+	ip, port, zone := parseRawSockaddr(&op.name)
+	c := &tcpRingConn{fd: fd, peer: &net.TCPAddr{IP: ip, Port: port, Zone: zone}, reading: true}
+	s.conns[c] = struct{}{}
+	rop := &ringOp{}
+	rop.done = func(res int32) error {
+		return s.read(c, rop, res)
+	}
+	if err := s.recv(c, rop); err != nil {
+		return err
+	}
+	return s.accept(op)
+}
+
+// recv reads the rest of the length prefix, or of the query if op.bp is set.
+func (s *tcpRing) recv(c *tcpRingConn, op *ringOp) error {
+	if op.bp == nil {
+		return s.submit(op, ioringOpRecv, c.fd, unsafe.Pointer(&c.hdr[op.n]), uint32(2-op.n), 0, 0)
+	}
+	qsize := int(binary.BigEndian.Uint16(c.hdr[:]))
+	return s.submit(op, ioringOpRecv, c.fd, unsafe.Pointer(&(*op.bp)[op.n]), uint32(qsize-op.n), 0, 0)
+}
+
+func (s *tcpRing) read(c *tcpRingConn, op *ringOp, res int32) error {
+	var err error
+	switch {
+	case s.stopping:
+		c.reading = false
+	case res < 0 && temporaryRingErr(res):
+		return s.recv(c, op)
+	case res < 0:
+		err = ringErr(res)
+	case res == 0:
+		if op.n > 0 || op.bp != nil {
+			err = io.ErrUnexpectedEOF
+		}
+		c.reading = false
+	}
+	if err != nil {
+		s.errorLog(fmt.Errorf("TCP read: %v", err))
+		c.reading, c.failed = false, true
+	}
+	if !c.reading {
+		if op.bp != nil {
+			s.bpool.Put(op.bp)
+		}
+		s.closeIdle(c)
+		return nil
+	}
+
+	op.n += int(res)
+	if op.bp == nil {
+		if op.n < 2 {
+			return s.recv(c, op)
+		}
+		if qsize := binary.BigEndian.Uint16(c.hdr[:]); qsize <= 14 {
+			s.errorLog(fmt.Errorf("query too small: %d", qsize))
+			c.reading = false
+			s.closeIdle(c)
+			return nil
+		}
+		op.bp, op.n = s.bpool.Get().(*[]byte), 0
+		return s.recv(c, op)
+	}
+	if qsize := int(binary.BigEndian.Uint16(c.hdr[:])); op.n == qsize {
+		c.handlers++
+		go s.serve(c, op.bp, qsize)
+		op.bp, op.n = nil, 0
+	}
+	return s.recv(c, op)
+}
+
+// serve handles the query in bp and sends its response on c.
+func (s *tcpRing) serve(c *tcpRingConn, bp *[]byte, qsize int) {
+	rsize, err := s.h.ServeDNS("TCP", c.peer, *bp, qsize)
+	posted := s.post(func() error {
+		c.handlers--
+		if err != nil || rsize > maxTCPSize || s.stopping || c.failed {
+			s.bpool.Put(bp)
+			s.closeIdle(c)
+			return nil
+		}
+		op := &ringOp{bp: bp}
+		binary.BigEndian.PutUint16(op.buf[:2], uint16(rsize))
+		op.done = func(res int32) error {
+			return s.sent(c, op, res)
+		}
+		c.out = append(c.out, op)
+		if len(c.out) == 1 {
+			return s.send(c, op)
+		}
+		return nil
+	})
+	if !posted {
+		s.bpool.Put(bp)
+	}
+}
+
+// send sends the rest of the response of op, made of the length prefix in
+// op.buf and the response in op.bp, op.n bytes being already sent.
+func (s *tcpRing) send(c *tcpRingConn, op *ringOp) error {
+	rsize := int(binary.BigEndian.Uint16(op.buf[:2]))
+	iov := op.iov[:0]
+	if op.n < 2 {
+		op.iov[0].Base = &op.buf[op.n]
+		op.iov[0].SetLen(2 - op.n)
+		iov = op.iov[:1]
+		if rsize > 0 {
+			op.iov[1].Base = &(*op.bp)[0]
+			op.iov[1].SetLen(rsize)
+			iov = op.iov[:2]
+		}
+	} else {
+		op.iov[0].Base = &(*op.bp)[op.n-2]
+		op.iov[0].SetLen(rsize - (op.n - 2))
+		iov = op.iov[:1]
+	}
+	op.msg = unix.Msghdr{Iov: &iov[0]}
+	op.msg.SetIovlen(len(iov))
+	return s.submit(op, ioringOpSendmsg, c.fd, unsafe.Pointer(&op.msg), 1, 0, unix.MSG_NOSIGNAL)
+}
+
+func (s *tcpRing) sent(c *tcpRingConn, op *ringOp, res int32) error {
+	if !s.stopping {
+		if res < 0 && temporaryRingErr(res) {
+			return s.send(c, op)
+		}
+		if res >= 0 {
+			op.n += int(res)
+			if op.n < 2+int(binary.BigEndian.Uint16(op.buf[:2])) {
+				return s.send(c, op)
+			}
+		}
+	}
+	s.bpool.Put(op.bp)
+	c.out = c.out[1:]
+	if res < 0 || s.stopping {
+		// The connection failed, the client will retry the queries.
+		c.failed = true
+		for _, op := range c.out {
+			s.bpool.Put(op.bp)
+		}
+		c.out = nil
+		if c.reading {
+			// Wake the read up.
+			_ = unix.Shutdown(c.fd, unix.SHUT_RDWR)
+		}
+	}
+	if len(c.out) > 0 {
+		return s.send(c, c.out[0])
+	}
+	s.closeIdle(c)
+	return nil
+}
+
+// closeIdle closes c once it is not read, handled or written anymore.
+func (s *tcpRing) closeIdle(c *tcpRingConn) {
+	if c.reading || c.handlers > 0 || len(c.out) > 0 || c.fd == -1 {
+		return
+	}
+	unix.Close(c.fd)
+	c.fd = -1
+	delete(s.conns, c)
+}
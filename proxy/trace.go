@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// RCodeName returns the name of rcode as printed in the query log, i.e.
+// NXDOMAIN.
+func RCodeName(rcode dnsmessage.RCode) string {
+	return rcodeName(byte(rcode))
+}
+
+// Trace resolves q through the same stages as the queries received by the
+// listeners, recording their decisions into t. The response is written into
+// buf.
+func (p Proxy) Trace(ctx context.Context, q resolver.Query, buf []byte, t *resolver.Trace) (n int, i resolver.ResolveInfo, err error) {
+	ctx = resolver.WithTrace(ctx, t)
+	ctx, cancel := resolver.WithRetryPolicy(ctx, p.Retry)
+	defer cancel()
+	if q.MAC == nil && p.ClientMAC != nil && q.PeerIP != nil && !q.PeerIP.IsLoopback() {
+		if q.MAC = p.ClientMAC(q.PeerIP); q.MAC != nil {
+			t.Add("client", "MAC address "+q.MAC.String())
+		}
+	}
+	n, i, err = p.Resolve(ctx, q, buf)
+	if err != nil {
+		code, text := resolveError(err)
+		t.Add("answer", fmt.Sprintf("failed, %s: %v", text, err))
+		if p.ExtendedErrors {
+			t.Add("answer", fmt.Sprintf("SERVFAIL with extended error %d (%s)", code, ExtendedErrorName(code)))
+		}
+		return n, i, err
+	}
+	if p.RewriteResponse != nil && n > 0 {
+		if n, err = p.RewriteResponse(q, buf, n); err != nil {
+			return n, i, err
+		}
+	}
+	if p.MinimalResponses && n > 0 {
+		if n, err = minimizeResponse(buf, n); err != nil {
+			return n, i, err
+		}
+	}
+	t.Add("answer", "from "+provenance(i))
+	return n, i, nil
+}
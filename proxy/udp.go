@@ -5,8 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"runtime"
 	"sync"
-	"time"
 
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
@@ -14,7 +14,81 @@ import (
 	"github.com/nextdns/nextdns/resolver"
 )
 
-const maxUDPSize = 512
+// defaultUDPSize is the maximum size of DNS messages over UDP without EDNS
+// (RFC 1035), and the default maximum size of the responses.
+const defaultUDPSize = 512
+
+// maxUDPSize is the maximum size of the responses sent over UDP.
+const maxUDPSize = 4096
+
+// udpBufferSizes are the sizes of the UDP buffer tiers: messages without
+// EDNS, the EDNS size avoiding IP fragmentation (DNS Flag Day 2020) and
+// maxUDPSize.
+var udpBufferSizes = [...]int{defaultUDPSize, 1232, maxUDPSize}
+
+// udpBufferPool pools UDP buffers by tiers of udpBufferSizes. Queries are read
+// into buffers of the smallest tier, and moved to a larger one only when the
+// response may need it, so large buffers are not held for most queries.
+type udpBufferPool struct {
+	tiers [len(udpBufferSizes)]sync.Pool
+}
+
+func newUDPBufferPool() *udpBufferPool {
+	p := &udpBufferPool{}
+	for i := range p.tiers {
+		size := udpBufferSizes[i]
+		p.tiers[i].New = func() interface{} {
+			b := make([]byte, size)
+			return &b
+		}
+	}
+	return p
+}
+
+// Get returns a buffer of the smallest tier holding size bytes, or of the
+// largest tier.
+func (p *udpBufferPool) Get(size int) *[]byte {
+	i := 0
+	for i < len(udpBufferSizes)-1 && udpBufferSizes[i] < size {
+		i++
+	}
+	return p.tiers[i].Get().(*[]byte)
+}
+
+// Put puts bp back into the pool of its tier.
+func (p *udpBufferPool) Put(bp *[]byte) {
+	for i, size := range udpBufferSizes {
+		if len(*bp) == size {
+			p.tiers[i].Put(bp)
+			return
+		}
+	}
+}
+
+// udpSizer is implemented by the handlers sending UDP responses larger than
+// defaultUDPSize.
+type udpSizer interface {
+	// UDPResponseSize returns the maximum size of the UDP response to query.
+	UDPResponseSize(query []byte) int
+}
+
+// responseBuffer returns a buffer holding the query in bp[:qsize] and large
+// enough for the response of h. It is bp unless a larger tier is needed, in
+// which case bp is put back.
+func (p *udpBufferPool) responseBuffer(h Handler, bp *[]byte, qsize int) *[]byte {
+	s, ok := h.(udpSizer)
+	if !ok {
+		return bp
+	}
+	size := s.UDPResponseSize((*bp)[:qsize])
+	if size <= len(*bp) {
+		return bp
+	}
+	nbp := p.Get(size)
+	copy(*nbp, (*bp)[:qsize])
+	p.Put(bp)
+	return nbp
+}
 
 // This is the required size of the OOB buffer to pass to ReadMsgUDP.
 var udpOOBSize = func() int {
@@ -32,76 +106,165 @@ var udpOOBSize = func() int {
 	return len(oob6)
 }()
 
-func (p Proxy) serveUDP(l net.PacketConn) error {
-	bpool := sync.Pool{
-		New: func() interface{} {
-			b := make([]byte, maxUDPSize)
-			return &b
-		},
+// UDPListener is a Listener for DNS over UDP.
+type UDPListener struct {
+	// Addr specifies the UDP address to listen to. It is ignored if Conn is
+	// set.
+	Addr string
+
+	// Conn specifies an optional pre-opened connection. It is closed by Close.
+	Conn net.PacketConn
+
+	// Sockets specifies the number of sockets bound to Addr with SO_REUSEPORT,
+	// each served by its own reader, so the kernel load balances the queries
+	// between them. If zero, one socket per CPU is opened on Linux. Other
+	// platforms always use a single socket.
+	Sockets int
+
+	conns []net.PacketConn
+	// done is closed by Close to stop the io_uring loops.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *UDPListener) String() string {
+	if l.Conn != nil {
+		return "UDP/" + l.Conn.LocalAddr().String()
 	}
+	return "UDP/" + l.Addr
+}
 
-	c, ok := l.(*net.UDPConn)
-	if !ok {
-		return errors.New("not a UDP socket")
+// Listen implements Listener interface.
+func (l *UDPListener) Listen(ctx context.Context) error {
+	l.conns = nil
+	l.done, l.closeOnce = make(chan struct{}), sync.Once{}
+	if l.Conn != nil {
+		l.conns = []net.PacketConn{l.Conn}
+		return nil
 	}
-	if err := setUDPDstOptions(c); err != nil {
-		return fmt.Errorf("setUDPDstOptions: %w", err)
+	n := l.Sockets
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
 	}
+	if !reusePortSupported {
+		n = 1
+	}
+	lc := &net.ListenConfig{}
+	addr := l.Addr
+	for i := 0; i < n; i++ {
+		c, err := lc.ListenPacket(ctx, "udp", addr)
+		if err != nil {
+			_ = l.Close()
+			return err
+		}
+		l.conns = append(l.conns, c)
+		if i == 0 && n > 1 {
+			// The first socket is bound without SO_REUSEPORT so the bind fails
+			// when another process (i.e. another instance) already serves the
+			// address. The option is then set for the next sockets to join it.
+			if err := setReusePort(c); err != nil {
+				_ = l.Close()
+				return err
+			}
+			lc.Control = reusePort
+			// Bind the next sockets on the port of the first one in case
+			// Addr has port 0.
+			host, _, _ := net.SplitHostPort(l.Addr)
+			_, port, _ := net.SplitHostPort(c.LocalAddr().String())
+			addr = net.JoinHostPort(host, port)
+		}
+	}
+	return nil
+}
+
+// Close implements Listener interface.
+func (l *UDPListener) Close() error {
+	if l.done != nil {
+		l.closeOnce.Do(func() { close(l.done) })
+	}
+	var err error
+	for _, c := range l.conns {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Serve implements Listener interface.
+func (l *UDPListener) Serve(h Handler) error {
+	bpool := newUDPBufferPool()
+	conns := l.conns
+	if len(conns) == 0 {
+		return errors.New("not listening")
+	}
+	errs := make(chan error, len(conns))
+	for _, conn := range conns {
+		c, ok := conn.(*net.UDPConn)
+		if !ok {
+			return errors.New("not a UDP socket")
+		}
+		if err := setUDPDstOptions(c); err != nil {
+			return fmt.Errorf("setUDPDstOptions: %w", err)
+		}
+		go func() {
+			if ioUringSupported() {
+				errs <- serveUDPRing(c, h, bpool, l.done)
+				return
+			}
+			errs <- serveUDPConn(c, h, bpool)
+		}()
+	}
+	// The other readers return once the listener is closed.
+	return <-errs
+}
 
+// serveUDP reads the queries received on c and serves them with h.
+func serveUDP(c *net.UDPConn, h Handler, bpool *udpBufferPool) error {
+	// The OOB buffer is reused as the destination address is copied out of
+	// it.
+	oob := make([]byte, udpOOBSize)
 	for {
-		buf := *bpool.Get().(*[]byte)
-		qsize, lip, raddr, err := readUDP(c, buf)
+		bp := bpool.Get(defaultUDPSize)
+		qsize, lip, raddr, err := readUDP(c, *bp, oob)
 		if err != nil {
+			bpool.Put(bp)
 			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
-				bpool.Put(&buf)
 				continue
 			}
 			return err
 		}
 		if qsize <= 14 {
-			bpool.Put(&buf)
+			bpool.Put(bp)
 			continue
 		}
-		start := time.Now()
 		go func() {
-			var err error
-			var rsize int
-			var ri resolver.ResolveInfo
-			q, err := resolver.NewQuery(buf[:qsize], addrIP(raddr))
-			if err != nil {
-				p.logErr(err)
+			bp := bpool.responseBuffer(h, bp, qsize)
+			defer bpool.Put(bp)
+			buf := *bp
+			rsize, err := h.ServeDNS("UDP", raddr, buf, qsize)
+			if err == nil {
+				rsize, err = fitUDP(buf, rsize)
 			}
-			defer func() {
-				bpool.Put(&buf)
-				p.logQuery(QueryInfo{
-					PeerIP:            q.PeerIP,
-					Protocol:          "UDP",
-					Type:              q.Type,
-					Name:              q.Name,
-					QuerySize:         qsize,
-					ResponseSize:      rsize,
-					Duration:          time.Since(start),
-					UpstreamTransport: ri.Transport,
-					Error:             err,
-				})
-			}()
-			ctx := context.Background()
-			if p.Timeout > 0 {
-				var cancel context.CancelFunc
-				ctx, cancel = context.WithTimeout(ctx, p.Timeout)
-				defer cancel()
-			}
-			if rsize, ri, err = p.Resolve(ctx, q, buf); err != nil {
-				return
-			}
-			if rsize > maxUDPSize {
+			if err != nil {
 				return
 			}
-			_, _, err = c.WriteMsgUDP(buf[:rsize], oobWithSrc(lip), raddr)
+			_, _, _ = c.WriteMsgUDP(buf[:rsize], oobWithSrc(lip), raddr)
 		}()
 	}
 }
 
+// fitUDP returns the size of the response of rsize bytes in buf. A response
+// larger than buf, i.e. not truncated by its handler, is replaced by its header
+// and question with the TC bit set, so the client retries over TCP instead of
+// timing out.
+func fitUDP(buf []byte, rsize int) (int, error) {
+	if rsize <= len(buf) {
+		return rsize, nil
+	}
+	return replyTruncated(resolver.Query{Payload: buf}, buf, false, 0)
+}
+
 // setUDPDstOptions sets the FlagDst on c to request the destination address as
 // part of the oob data.
 func setUDPDstOptions(c *net.UDPConn) error {
@@ -115,10 +278,10 @@ func setUDPDstOptions(c *net.UDPConn) error {
 	return nil
 }
 
-// readUDP reads from c to buf and returns the local and remote addresses.
-func readUDP(c *net.UDPConn, buf []byte) (n int, lip net.IP, raddr *net.UDPAddr, err error) {
+// readUDP reads from c to buf, using oob for the control message, and returns
+// the local and remote addresses.
+func readUDP(c *net.UDPConn, buf, oob []byte) (n int, lip net.IP, raddr *net.UDPAddr, err error) {
 	var oobn int
-	oob := make([]byte, udpOOBSize)
 	n, oobn, _, raddr, err = c.ReadMsgUDP(buf, oob)
 	if err != nil {
 		return -1, nil, nil, err
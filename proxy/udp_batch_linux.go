@@ -0,0 +1,157 @@
+// +build linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/ipv4"
+)
+
+// udpBatchSize is the maximum number of packets read or written per system
+// call.
+const udpBatchSize = 16
+
+// serveUDPConn serves c reading, and when possible writing, up to
+// udpBatchSize packets per system call with recvmmsg and sendmmsg. At high
+// query rates, the system call overhead otherwise dominates the CPU usage of
+// small routers.
+func serveUDPConn(c *net.UDPConn, h Handler, bpool *udpBufferPool) error {
+	done := make(chan struct{})
+	defer close(done)
+	pc := ipv4.NewPacketConn(c)
+	write := func(bp *[]byte, rsize int, lip net.IP, raddr net.Addr) {
+		_, _, _ = c.WriteMsgUDP((*bp)[:rsize], oobWithSrc(lip), raddr.(*net.UDPAddr))
+		bpool.Put(bp)
+	}
+	if la, ok := c.LocalAddr().(*net.UDPAddr); ok && la.IP.To4() != nil {
+		// Batched writes are limited to IPv4 sockets as IPv4 destinations
+		// are always encoded as AF_INET addresses, refused by dual stack
+		// sockets.
+		w := &udpBatchWriter{pc: pc, bpool: bpool, out: make(chan udpResponse, udpBatchSize)}
+		go w.run(done)
+		write = func(bp *[]byte, rsize int, lip net.IP, raddr net.Addr) {
+			select {
+			case w.out <- udpResponse{bp: bp, size: rsize, lip: lip, raddr: raddr}:
+			case <-done:
+				bpool.Put(bp)
+			}
+		}
+	}
+
+	// bps holds the pool pointers of the message buffers so they can be put
+	// back without allocating.
+	msgs := make([]ipv4.Message, udpBatchSize)
+	bps := make([]*[]byte, udpBatchSize)
+	for i := range msgs {
+		bps[i] = bpool.Get(defaultUDPSize)
+		msgs[i].Buffers = [][]byte{*bps[i]}
+		msgs[i].OOB = make([]byte, udpOOBSize)
+	}
+	defer func() {
+		for _, bp := range bps {
+			bpool.Put(bp)
+		}
+	}()
+	for {
+		n, err := pc.ReadBatch(msgs, 0)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			if errors.Is(err, syscall.ENOSYS) {
+				// recvmmsg not supported by the kernel.
+				return serveUDP(c, h, bpool)
+			}
+			return err
+		}
+		for i := range msgs[:n] {
+			m := &msgs[i]
+			if m.N <= 14 {
+				continue
+			}
+			bp, qsize, raddr := bps[i], m.N, m.Addr
+			lip := parseDstFromOOB(m.OOB[:m.NN])
+			bps[i] = bpool.Get(defaultUDPSize)
+			m.Buffers[0] = *bps[i]
+			go func() {
+				bp := bpool.responseBuffer(h, bp, qsize)
+				rsize, err := h.ServeDNS("UDP", raddr, *bp, qsize)
+				if err == nil {
+					rsize, err = fitUDP(*bp, rsize)
+				}
+				if err != nil {
+					bpool.Put(bp)
+					return
+				}
+				write(bp, rsize, lip, raddr)
+			}()
+		}
+	}
+}
+
+type udpResponse struct {
+	bp    *[]byte
+	size  int
+	lip   net.IP
+	raddr net.Addr
+}
+
+// udpBatchWriter writes the responses sent to out, batching the ones
+// available at the time of the write.
+type udpBatchWriter struct {
+	pc    *ipv4.PacketConn
+	bpool *udpBufferPool
+	out   chan udpResponse
+}
+
+func (w *udpBatchWriter) run(done <-chan struct{}) {
+	// The messages and their Buffers slices are reused between batches.
+	all := make([]ipv4.Message, udpBatchSize)
+	for i := range all {
+		all[i].Buffers = make([][]byte, 1)
+	}
+	msgs := all[:0]
+	bps := make([]*[]byte, 0, udpBatchSize)
+	add := func(r udpResponse) {
+		m := &all[len(msgs)]
+		m.Buffers[0] = (*r.bp)[:r.size]
+		m.OOB = oobWithSrc(r.lip)
+		m.Addr = r.raddr
+		msgs = all[:len(msgs)+1]
+		bps = append(bps, r.bp)
+	}
+	for {
+		select {
+		case r := <-w.out:
+			add(r)
+		case <-done:
+			return
+		}
+	drain:
+		for len(msgs) < udpBatchSize {
+			select {
+			case r := <-w.out:
+				add(r)
+			default:
+				break drain
+			}
+		}
+		for sent := 0; sent < len(msgs); {
+			n, err := w.pc.WriteBatch(msgs[sent:], 0)
+			if err != nil {
+				// Drop the remaining responses, clients will retry.
+				break
+			}
+			sent += n
+		}
+		for i, bp := range bps {
+			w.bpool.Put(bp)
+			bps[i] = nil
+			all[i].Buffers[0], all[i].OOB, all[i].Addr = nil, nil, nil
+		}
+		msgs, bps = all[:0], bps[:0]
+	}
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package proxy
+
+import (
+	"net"
+)
+
+// serveUDPConn serves c one packet at a time as batched reads and writes are
+// only supported on Linux.
+func serveUDPConn(c *net.UDPConn, h Handler, bpool *udpBufferPool) error {
+	return serveUDP(c, h, bpool)
+}
@@ -0,0 +1,124 @@
+// +build linux,iouring
+
+package proxy
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// udpRing serves a UDP socket with an io_uring, keeping udpBatchSize reads in
+// progress and sending the responses without a system call each.
+type udpRing struct {
+	*ringLoop
+	fd    int
+	h     Handler
+	bpool *udpBufferPool
+}
+
+// serveUDPRing serves c with an io_uring until done is closed.
+func serveUDPRing(c *net.UDPConn, h Handler, bpool *udpBufferPool, done <-chan struct{}) error {
+	l, err := newRingLoop()
+	if err != nil {
+		return err
+	}
+	defer l.close()
+	fd, err := dupSocket(c)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	s := &udpRing{ringLoop: l, fd: fd, h: h, bpool: bpool}
+	for i := 0; i < udpBatchSize; i++ {
+		op := &ringOp{bp: bpool.Get(defaultUDPSize), oob: make([]byte, udpOOBSize)}
+		op.done = func(res int32) error {
+			return s.received(op, res)
+		}
+		if err := s.recv(op); err != nil {
+			return err
+		}
+	}
+	return l.run(done)
+}
+
+func (s *udpRing) recv(op *ringOp) error {
+	op.iov[0].Base = &(*op.bp)[0]
+	op.iov[0].SetLen(len(*op.bp))
+	op.msg = unix.Msghdr{
+		Name:    (*byte)(unsafe.Pointer(&op.name)),
+		Namelen: unix.SizeofSockaddrAny,
+		Iov:     &op.iov[0],
+		Control: &op.oob[0],
+	}
+	op.msg.SetIovlen(1)
+	op.msg.SetControllen(len(op.oob))
+	return s.submit(op, ioringOpRecvmsg, s.fd, unsafe.Pointer(&op.msg), 1, 0, 0)
+}
+
+func (s *udpRing) received(op *ringOp, res int32) error {
+	if s.stopping {
+		s.bpool.Put(op.bp)
+		return nil
+	}
+	if res < 0 {
+		if !temporaryRingErr(res) {
+			s.bpool.Put(op.bp)
+			return ringErr(res)
+		}
+		return s.recv(op)
+	}
+	if res > 14 {
+		bp, qsize := op.bp, int(res)
+		ip, port, zone := parseRawSockaddr(&op.name)
+		raddr := &net.UDPAddr{IP: ip, Port: port, Zone: zone}
+		lip := parseDstFromOOB(op.oob[:op.msg.Controllen])
+		name, namelen := op.name, op.msg.Namelen
+		op.bp = s.bpool.Get(defaultUDPSize)
+		go s.serve(bp, qsize, raddr, lip, name, namelen)
+	}
+	return s.recv(op)
+}
+
+// serve handles the query in bp and sends the response back to the sender
+// address name.
+func (s *udpRing) serve(bp *[]byte, qsize int, raddr *net.UDPAddr, lip net.IP, name unix.RawSockaddrAny, namelen uint32) {
+	bp = s.bpool.responseBuffer(s.h, bp, qsize)
+	rsize, err := s.h.ServeDNS("UDP", raddr, *bp, qsize)
+	if err == nil {
+		rsize, err = fitUDP(*bp, rsize)
+	}
+	if err != nil {
+		s.bpool.Put(bp)
+		return
+	}
+	op := &ringOp{bp: bp, name: name, oob: oobWithSrc(lip)}
+	op.done = func(int32) error {
+		// Failed responses are dropped, clients will retry.
+		s.bpool.Put(op.bp)
+		return nil
+	}
+	op.iov[0].Base = &(*bp)[0]
+	op.iov[0].SetLen(rsize)
+	op.msg = unix.Msghdr{
+		Name:    (*byte)(unsafe.Pointer(&op.name)),
+		Namelen: namelen,
+		Iov:     &op.iov[0],
+	}
+	op.msg.SetIovlen(1)
+	if len(op.oob) > 0 {
+		op.msg.Control = &op.oob[0]
+		op.msg.SetControllen(len(op.oob))
+	}
+	posted := s.post(func() error {
+		if s.stopping {
+			s.bpool.Put(op.bp)
+			return nil
+		}
+		return s.submit(op, ioringOpSendmsg, s.fd, unsafe.Pointer(&op.msg), 1, 0, 0)
+	})
+	if !posted {
+		s.bpool.Put(bp)
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// unixPeer is the peer address of the queries received over a Unix domain
+// socket. Only local processes can connect to the socket, so they are
+// considered as coming from the loopback interface.
+var unixPeer = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+// UnixListener is a Listener for DNS over a Unix domain socket, using the DNS
+// over TCP framing. On Windows, AF_UNIX sockets are supported since Windows 10
+// version 1803.
+type UnixListener struct {
+	// Path specifies the path of the socket. A stale socket left at this path
+	// is replaced.
+	Path string
+
+	// Mode specifies the permissions of the socket. Default is 0666 so any
+	// local process can send queries.
+	Mode os.FileMode
+
+	l net.Listener
+
+	// ErrorLog specifies an optional log function for connection errors.
+	ErrorLog func(error)
+}
+
+func (l *UnixListener) String() string {
+	return "UNIX/" + l.Path
+}
+
+// Listen implements Listener interface.
+func (l *UnixListener) Listen(ctx context.Context) (err error) {
+	if err := removeStaleSocket(l.Path); err != nil {
+		return err
+	}
+	lc := &net.ListenConfig{}
+	if l.l, err = lc.Listen(ctx, "unix", l.Path); err != nil {
+		return err
+	}
+	mode := l.Mode
+	if mode == 0 {
+		mode = 0666
+	}
+	if err := os.Chmod(l.Path, mode); err != nil {
+		l.l.Close()
+		return err
+	}
+	return nil
+}
+
+// removeStaleSocket removes the socket at path if no process is listening on
+// it anymore.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 && runtime.GOOS != "windows" {
+		return fmt.Errorf("%s: file exists and is not a socket", path)
+	}
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		c.Close()
+		return fmt.Errorf("%s: socket already in use", path)
+	}
+	return os.Remove(path)
+}
+
+// Close implements Listener interface. The socket file is removed.
+func (l *UnixListener) Close() error {
+	if l.l == nil {
+		return nil
+	}
+	return l.l.Close()
+}
+
+// Serve implements Listener interface.
+func (l *UnixListener) Serve(h Handler) error {
+	bpool := &sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, maxTCPSize)
+			return &b
+		},
+	}
+
+	for {
+		c, err := l.l.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return err
+		}
+		go func() {
+			if err := serveTCPConn("UNIX", c, unixPeer, h, bpool); err != nil {
+				if l.ErrorLog != nil {
+					l.ErrorLog(err)
+				}
+			}
+		}()
+	}
+}
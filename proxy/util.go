@@ -30,6 +30,164 @@ func replyNXDomain(q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo,
 	return len(buf), i, err
 }
 
+// replyHINFO answers the ANY query q with a synthesized HINFO record, as
+// described in RFC 8482.
+func replyHINFO(q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return 0, i, err
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return 0, i, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = dnsmessage.RCodeSuccess
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	_ = b.StartAnswers()
+	// CPU "RFC8482" and an empty OS, as character-strings.
+	data := append([]byte{7}, "RFC8482"...)
+	data = append(data, 0)
+	err = b.UnknownResource(dnsmessage.ResourceHeader{
+		Name:  q1.Name,
+		Type:  dnsmessage.TypeHINFO,
+		Class: q1.Class,
+		TTL:   3600,
+	}, dnsmessage.UnknownResource{Type: dnsmessage.TypeHINFO, Data: data})
+	if err != nil {
+		return 0, i, err
+	}
+	buf, err = b.Finish()
+	return len(buf), i, err
+}
+
+// replyServFail writes a SERVFAIL response to the query into buf.
+func replyServFail(query []byte, buf []byte) (int, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return 0, err
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return 0, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = dnsmessage.RCodeServerFailure
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	buf, err = b.Finish()
+	return len(buf), err
+}
+
+// replyTruncated writes an empty response to q with the TC bit set into buf,
+// so the client retries over TCP. If edns is true, an OPT record advertising
+// udpSize is added, so the client does not retry without EDNS instead.
+func replyTruncated(q resolver.Query, buf []byte, edns bool, udpSize int) (int, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return 0, err
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return 0, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.Truncated = true
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	if edns {
+		var rh dnsmessage.ResourceHeader
+		if err := rh.SetEDNS0(udpSize, dnsmessage.RCodeSuccess, false); err != nil {
+			return 0, err
+		}
+		_ = b.StartAdditionals()
+		if err := b.OPTResource(rh, dnsmessage.OPTResource{}); err != nil {
+			return 0, err
+		}
+	}
+	buf, err = b.Finish()
+	return len(buf), err
+}
+
+// minimizeResponse removes the records of the response in buf[:n] stub
+// clients do not need: the authority section of positive answers (the SOA of
+// negative answers is kept for caching) and the additional section but the
+// OPT record. It returns the new size of the response.
+func minimizeResponse(buf []byte, n int) (int, error) {
+	var m dnsmessage.Message
+	if m.Unpack(buf[:n]) != nil {
+		// Left untouched, i.e. cut responses are truncated afterwards.
+		return n, nil
+	}
+	changed := false
+	if len(m.Answers) > 0 && len(m.Authorities) > 0 {
+		m.Authorities = nil
+		changed = true
+	}
+	additionals := m.Additionals[:0]
+	for _, rr := range m.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			additionals = append(additionals, rr)
+		}
+	}
+	if len(additionals) != len(m.Additionals) {
+		m.Additionals = additionals
+		changed = true
+	}
+	if !changed {
+		return n, nil
+	}
+	b, err := m.AppendPack(buf[:0])
+	if err != nil {
+		return n, err
+	}
+	return len(b), nil
+}
+
+func replyPTR(q resolver.Query, name string, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	ptr, err := dnsmessage.NewName(name)
+	if err != nil {
+		return 0, i, err
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return 0, i, err
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return 0, i, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = dnsmessage.RCodeSuccess
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	_ = b.StartAnswers()
+	err = b.PTRResource(dnsmessage.ResourceHeader{
+		Name:  q1.Name,
+		Type:  q1.Type,
+		Class: q1.Class,
+		TTL:   60,
+	}, dnsmessage.PTRResource{PTR: ptr})
+	if err != nil {
+		return 0, i, err
+	}
+	buf, err = b.Finish()
+	return len(buf), i, err
+}
+
 func hostsResolve(q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
 	switch q.Type {
 	case "A", "AAAA", "PTR":
@@ -198,3 +356,22 @@ func addrIP(addr net.Addr) (ip net.IP) {
 	}
 	return
 }
+
+// rcodeName returns the mnemonic of the response code rcode (RFC 1035).
+func rcodeName(rcode byte) string {
+	switch dnsmessage.RCode(rcode) {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
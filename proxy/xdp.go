@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// XDPListener is an experimental Listener for DNS over UDP receiving the
+// queries with AF_XDP sockets, bypassing the network stack of the kernel for
+// very high query rates. It is only supported on Linux 4.18 and later.
+//
+// An XDP program is attached to Interface, redirecting the IPv4 and IPv6 UDP
+// datagrams sent to Port to one socket per receive queue of the interface,
+// whatever their destination address. The other packets are passed to the
+// network stack. Datagrams with IPv4 options or IPv6 extension headers are
+// passed too, and are not answered unless a UDPListener serves the port.
+// Responses larger than about 2KB are truncated, clients retrying over TCP.
+type XDPListener struct {
+	// Interface is the name of the network interface to receive the queries
+	// on.
+	Interface string
+
+	// Port is the UDP port to receive the queries on. Default is 53.
+	Port int
+
+	xdp
+}
+
+func (l *XDPListener) String() string {
+	return "XDP/" + l.Interface + ":" + strconv.Itoa(l.port())
+}
+
+func (l *XDPListener) port() int {
+	if l.Port == 0 {
+		return 53
+	}
+	return l.Port
+}
+
+// ParseXDPListener parses the IFACE[:PORT] value of the listen-xdp setting.
+func ParseXDPListener(s string) (*XDPListener, error) {
+	l := &XDPListener{Interface: s}
+	if idx := strings.LastIndexByte(s, ':'); idx != -1 {
+		port, err := strconv.Atoi(s[idx+1:])
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("%s: invalid port", s)
+		}
+		l.Interface, l.Port = s[:idx], port
+	}
+	if l.Interface == "" {
+		return nil, fmt.Errorf("%s: missing interface", s)
+	}
+	return l, nil
+}
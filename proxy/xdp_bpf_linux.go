@@ -0,0 +1,399 @@
+// +build linux
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// nativeEndian is the byte order of the host. The instructions of the XDP
+// program are encoded with it, and the packet fields loaded by the program
+// are read with it.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// Opcodes of the eBPF instructions used by the XDP program
+// (linux/bpf_common.h and linux/bpf.h).
+const (
+	bpfLdxW    = 0x61 // dst = *(u32 *)(src + off)
+	bpfLdxH    = 0x69 // dst = *(u16 *)(src + off)
+	bpfLdxB    = 0x71 // dst = *(u8 *)(src + off)
+	bpfLdImm64 = 0x18 // dst = imm64, over two instructions
+	bpfMovK    = 0xb7 // dst = imm
+	bpfMovX    = 0xbf // dst = src
+	bpfAddK    = 0x07 // dst += imm
+	bpfJa      = 0x05 // goto off
+	bpfJeqK    = 0x15 // if dst == imm goto off
+	bpfJneK    = 0x55 // if dst != imm goto off
+	bpfJsetK   = 0x45 // if dst & imm goto off
+	bpfJgtX    = 0x2d // if dst > src goto off
+	bpfCall    = 0x85
+	bpfExit    = 0x95
+
+	bpfPseudoMapFD = 1 // src of bpfLdImm64 loading a map from its fd
+
+	bpfFuncRedirectMap = 51
+
+	xdpPass = 2
+)
+
+// bpfInsn is an eBPF instruction. Jumps are given the label of their target,
+// resolved by bpfProg.assemble.
+type bpfInsn struct {
+	code     uint8
+	dst, src uint8
+	off      int16
+	imm      int32
+	jump     string
+}
+
+// bpfProg assembles an eBPF program.
+type bpfProg struct {
+	insns  []bpfInsn
+	labels map[string]int
+}
+
+func (p *bpfProg) label(name string) {
+	if p.labels == nil {
+		p.labels = map[string]int{}
+	}
+	p.labels[name] = len(p.insns)
+}
+
+func (p *bpfProg) add(insns ...bpfInsn) {
+	p.insns = append(p.insns, insns...)
+}
+
+// assemble returns the program encoded in the format of the kernel.
+func (p *bpfProg) assemble() ([]byte, error) {
+	b := make([]byte, 8*len(p.insns))
+	for i, insn := range p.insns {
+		if insn.jump != "" {
+			target, found := p.labels[insn.jump]
+			if !found {
+				return nil, fmt.Errorf("%s: unknown label", insn.jump)
+			}
+			insn.off = int16(target - i - 1)
+		}
+		// The register fields are a bit field whose order follows the byte
+		// order.
+		regs := insn.src<<4 | insn.dst
+		if nativeEndian == binary.BigEndian {
+			regs = insn.dst<<4 | insn.src
+		}
+		e := b[8*i:]
+		e[0], e[1] = insn.code, regs
+		nativeEndian.PutUint16(e[2:4], uint16(insn.off))
+		nativeEndian.PutUint32(e[4:8], uint32(insn.imm))
+	}
+	return b, nil
+}
+
+// xdpProgram returns the XDP program redirecting the UDP datagrams sent to
+// port to the AF_XDP socket of their receive queue in the XSKMAP mapFD.
+func xdpProgram(port uint16, mapFD int) *bpfProg {
+	// Since Linux 5.3, the action taken when the queue has no socket (i.e.
+	// closed by the listener) is set in the flags of bpf_redirect_map.
+	// Older kernels abort the packets and refuse the flags.
+	var noSocket int32
+	if kernelAtLeast(5, 3) {
+		noSocket = xdpPass
+	}
+	// Packet fields are compared to constants read from their network byte
+	// order representation.
+	be16 := func(v uint16) int32 {
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], v)
+		return int32(nativeEndian.Uint16(b[:]))
+	}
+	const (
+		r0, r1, r2, r3, r4, r5 = 0, 1, 2, 3, 4, 5
+	)
+	p := &bpfProg{}
+	p.add(
+		// r1 is the struct xdp_md context: data, data_end, data_meta,
+		// ingress_ifindex and rx_queue_index.
+		bpfInsn{code: bpfLdxW, dst: r2, src: r1, off: 0},
+		bpfInsn{code: bpfLdxW, dst: r3, src: r1, off: 4},
+		bpfInsn{code: bpfMovX, dst: r4, src: r2},
+		bpfInsn{code: bpfAddK, dst: r4, imm: ethHeaderLen + ipv4HeaderLen + udpHeaderLen},
+		bpfInsn{code: bpfJgtX, dst: r4, src: r3, jump: "pass"},
+		bpfInsn{code: bpfLdxH, dst: r5, src: r2, off: 12},
+		bpfInsn{code: bpfJeqK, dst: r5, imm: be16(ethTypeIPv6), jump: "ipv6"},
+		bpfInsn{code: bpfJneK, dst: r5, imm: be16(ethTypeIPv4), jump: "pass"},
+		// IPv4 without options, not fragmented.
+		bpfInsn{code: bpfLdxB, dst: r5, src: r2, off: ethHeaderLen},
+		bpfInsn{code: bpfJneK, dst: r5, imm: 0x45, jump: "pass"},
+		bpfInsn{code: bpfLdxH, dst: r5, src: r2, off: ethHeaderLen + 6},
+		bpfInsn{code: bpfJsetK, dst: r5, imm: be16(0x3fff), jump: "pass"},
+		bpfInsn{code: bpfLdxB, dst: r5, src: r2, off: ethHeaderLen + 9},
+		bpfInsn{code: bpfJneK, dst: r5, imm: ipProtoUDP, jump: "pass"},
+		bpfInsn{code: bpfLdxH, dst: r5, src: r2, off: ethHeaderLen + ipv4HeaderLen + 2},
+		bpfInsn{code: bpfJneK, dst: r5, imm: be16(port), jump: "pass"},
+		bpfInsn{code: bpfJa, jump: "redirect"},
+	)
+	p.label("ipv6")
+	p.add(
+		// IPv6 without extension headers.
+		bpfInsn{code: bpfMovX, dst: r4, src: r2},
+		bpfInsn{code: bpfAddK, dst: r4, imm: ethHeaderLen + ipv6HeaderLen + udpHeaderLen},
+		bpfInsn{code: bpfJgtX, dst: r4, src: r3, jump: "pass"},
+		bpfInsn{code: bpfLdxB, dst: r5, src: r2, off: ethHeaderLen + 6},
+		bpfInsn{code: bpfJneK, dst: r5, imm: ipProtoUDP, jump: "pass"},
+		bpfInsn{code: bpfLdxH, dst: r5, src: r2, off: ethHeaderLen + ipv6HeaderLen + 2},
+		bpfInsn{code: bpfJneK, dst: r5, imm: be16(port), jump: "pass"},
+	)
+	p.label("redirect")
+	p.add(
+		// bpf_redirect_map(map, rx_queue_index, noSocket)
+		bpfInsn{code: bpfLdxW, dst: r2, src: r1, off: 16},
+		bpfInsn{code: bpfLdImm64, dst: r1, src: bpfPseudoMapFD, imm: int32(mapFD)},
+		bpfInsn{},
+		bpfInsn{code: bpfMovK, dst: r3, imm: noSocket},
+		bpfInsn{code: bpfCall, imm: bpfFuncRedirectMap},
+		bpfInsn{code: bpfExit},
+	)
+	p.label("pass")
+	p.add(
+		bpfInsn{code: bpfMovK, dst: r0, imm: xdpPass},
+		bpfInsn{code: bpfExit},
+	)
+	return p
+}
+
+// bpf calls the bpf system call cmd with the attributes attr of size size.
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(r), nil
+}
+
+// createXSKMap creates a map of size entries holding the AF_XDP sockets of the
+// receive queues.
+func createXSKMap(size int) (int, error) {
+	attr := struct {
+		mapType    uint32
+		keySize    uint32
+		valueSize  uint32
+		maxEntries uint32
+		mapFlags   uint32
+	}{unix.BPF_MAP_TYPE_XSKMAP, 4, 4, uint32(size), 0}
+	fd, err := bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return -1, fmt.Errorf("create XSKMAP: %w", err)
+	}
+	return fd, nil
+}
+
+// updateXSKMap sets the socket of the receive queue queue to fd.
+func updateXSKMap(mapFD, queue, fd int) error {
+	key, value := uint32(queue), uint32(fd)
+	attr := struct {
+		mapFD uint32
+		_     uint32
+		key   uint64
+		value uint64
+		flags uint64
+	}{
+		mapFD: uint32(mapFD),
+		key:   uint64(uintptr(unsafe.Pointer(&key))),
+		value: uint64(uintptr(unsafe.Pointer(&value))),
+	}
+	_, err := bpf(unix.BPF_MAP_UPDATE_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(&key)
+	runtime.KeepAlive(&value)
+	if err != nil {
+		return fmt.Errorf("update XSKMAP: %w", err)
+	}
+	return nil
+}
+
+// loadXDPProgram loads the XDP program p into the kernel and returns its fd.
+func loadXDPProgram(p *bpfProg) (int, error) {
+	insns, err := p.assemble()
+	if err != nil {
+		return -1, err
+	}
+	fd, err := bpfProgLoad(insns, nil)
+	if err != nil {
+		// Load it again with the verifier log to explain the failure.
+		log := make([]byte, 64<<10)
+		if _, lerr := bpfProgLoad(insns, log); lerr != nil {
+			if n := bytes.IndexByte(log, 0); n > 0 {
+				return -1, fmt.Errorf("load XDP program: %w: %s", err, bytes.TrimSpace(log[:n]))
+			}
+		}
+		return -1, fmt.Errorf("load XDP program: %w", err)
+	}
+	return fd, nil
+}
+
+// bpfProgLoad loads the XDP program insns, writing the verifier log into log
+// if not nil.
+func bpfProgLoad(insns, log []byte) (int, error) {
+	license := []byte("MIT\x00")
+	attr := struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		progFlags   uint32
+	}{
+		progType: unix.BPF_PROG_TYPE_XDP,
+		insnCnt:  uint32(len(insns) / 8),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	if len(log) > 0 {
+		attr.logLevel = 1
+		attr.logSize = uint32(len(log))
+		attr.logBuf = uint64(uintptr(unsafe.Pointer(&log[0])))
+	}
+	fd, err := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	runtime.KeepAlive(log)
+	return fd, err
+}
+
+// Netlink attributes setting the XDP program of a link
+// (linux/if_link.h).
+const (
+	iflaXDP      = 43
+	iflaXDPFD    = 1
+	iflaXDPFlags = 3
+)
+
+// setXDPProgram attaches the XDP program fd to the interface ifindex with
+// flags, or detaches the program if fd is -1.
+func setXDPProgram(ifindex, fd int, flags uint32) error {
+	s, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(s)
+	if err := unix.Bind(s, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	const attrsLen = 3*unix.SizeofRtAttr + 4 + 4
+	msg := make([]byte, unix.SizeofNlMsghdr+unix.SizeofIfInfomsg+attrsLen)
+	nativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:6], unix.RTM_SETLINK)
+	nativeEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	nativeEndian.PutUint32(msg[8:12], 1) // sequence
+	ifi := msg[unix.SizeofNlMsghdr:]
+	ifi[0] = unix.AF_UNSPEC
+	nativeEndian.PutUint32(ifi[4:8], uint32(ifindex))
+	attrs := ifi[unix.SizeofIfInfomsg:]
+	nativeEndian.PutUint16(attrs[0:2], attrsLen)
+	nativeEndian.PutUint16(attrs[2:4], iflaXDP|unix.NLA_F_NESTED)
+	nativeEndian.PutUint16(attrs[4:6], unix.SizeofRtAttr+4)
+	nativeEndian.PutUint16(attrs[6:8], iflaXDPFD)
+	nativeEndian.PutUint32(attrs[8:12], uint32(int32(fd)))
+	nativeEndian.PutUint16(attrs[12:14], unix.SizeofRtAttr+4)
+	nativeEndian.PutUint16(attrs[14:16], iflaXDPFlags)
+	nativeEndian.PutUint32(attrs[16:20], flags)
+	if err := unix.Sendto(s, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := unix.Recvfrom(s, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Type != unix.NLMSG_ERROR || len(m.Data) < 4 {
+				continue
+			}
+			if errno := -int32(nativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+				return syscall.Errno(errno)
+			}
+			return nil
+		}
+	}
+}
+
+// bpf command creating a link and attach type of the links of XDP programs
+// (linux/bpf.h).
+const (
+	bpfLinkCreate = 28
+	bpfXDP        = 37
+)
+
+// createBPFLink attaches the XDP program fd to the interface ifindex with the
+// mode flags, returning the fd of a BPF link detaching it once closed.
+func createBPFLink(ifindex, fd int, flags uint32) (int, error) {
+	attr := struct {
+		progFD        uint32
+		targetIfindex uint32
+		attachType    uint32
+		flags         uint32
+	}{uint32(fd), uint32(ifindex), bpfXDP, flags}
+	return bpf(bpfLinkCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+// attachXDPProgram attaches the XDP program fd to the interface ifindex, in
+// the native driver mode if supported or in the generic mode otherwise.
+//
+// Since Linux 5.9, the program is attached with a BPF link whose fd is
+// returned: the program is detached once it is closed, including when the
+// process dies. Older kernels attach it with netlink, link being -1, and it
+// must be detached with the returned mode flags.
+func attachXDPProgram(ifindex, fd int) (link int, mode uint32, err error) {
+	useLink := kernelAtLeast(5, 9)
+	for _, mode = range []uint32{unix.XDP_FLAGS_DRV_MODE, unix.XDP_FLAGS_SKB_MODE} {
+		if useLink {
+			link, err = createBPFLink(ifindex, fd, mode)
+		} else {
+			link, err = -1, setXDPProgram(ifindex, fd, mode|unix.XDP_FLAGS_UPDATE_IF_NOEXIST)
+		}
+		if err == nil {
+			return link, mode, nil
+		}
+		if errors.Is(err, unix.EBUSY) || errors.Is(err, unix.EEXIST) {
+			// Possibly left by a crash on kernels without BPF links.
+			return -1, 0, errors.New("an XDP program is already attached to the interface, detach it with: ip link set dev IFACE xdp off")
+		}
+	}
+	return -1, 0, fmt.Errorf("attach XDP program: %w", err)
+}
+
+// kernelAtLeast returns true if the version of the running kernel is at least
+// major.minor.
+func kernelAtLeast(major, minor int) bool {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return false
+	}
+	var maj, min int
+	if _, err := fmt.Sscanf(string(uts.Release[:]), "%d.%d", &maj, &min); err != nil {
+		return false
+	}
+	return maj > major || maj == major && min >= minor
+}
@@ -0,0 +1,421 @@
+// +build linux
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Each AF_XDP socket has its own UMEM of xdpFrames frames, split between the
+// frames receiving the queries and the ones sending the responses. Rings hold
+// as many entries as frames of their direction.
+const (
+	xdpFrameSize = 2048
+	xdpRingSize  = 1024
+	xdpFrames    = 2 * xdpRingSize
+)
+
+type xdp struct {
+	mu     sync.Mutex
+	closed bool
+	// opened is true once Listen allocated the resources below.
+	opened  bool
+	ifindex int
+	// link is the BPF link of the program, or -1. Without link, mode is
+	// the flags the program was attached with, zero if not attached.
+	link    int
+	mode    uint32
+	mapFD   int
+	progFD  int
+	sockets []*xdpSocket
+	// wake is a pipe whose write end is closed by Close to wake the readers
+	// up.
+	wake    [2]int
+	readers sync.WaitGroup
+}
+
+// Listen implements Listener interface.
+func (l *XDPListener) Listen(ctx context.Context) (err error) {
+	iface, err := net.InterfaceByName(l.Interface)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.closed, l.opened, l.ifindex, l.mode, l.sockets = false, true, iface.Index, 0, nil
+	l.link, l.mapFD, l.progFD, l.wake = -1, -1, -1, [2]int{-1, -1}
+	l.mu.Unlock()
+	defer func() {
+		if err != nil {
+			l.release()
+		}
+	}()
+
+	// Kernels before 5.11 account the maps and the UMEMs in the locked
+	// memory, limited to 64KB by default.
+	_ = unix.Setrlimit(unix.RLIMIT_MEMLOCK, &unix.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY})
+
+	queues := rxQueues(l.Interface)
+	if l.mapFD, err = createXSKMap(queues); err != nil {
+		return err
+	}
+	for q := 0; q < queues; q++ {
+		s, err := openXDPSocket(iface.Index, q)
+		if err != nil {
+			return fmt.Errorf("queue %d: %w", q, err)
+		}
+		l.sockets = append(l.sockets, s)
+		if err := updateXSKMap(l.mapFD, q, s.fd); err != nil {
+			return err
+		}
+	}
+	if l.progFD, err = loadXDPProgram(xdpProgram(uint16(l.port()), l.mapFD)); err != nil {
+		return err
+	}
+	if err := unix.Pipe2(l.wake[:], unix.O_CLOEXEC); err != nil {
+		return err
+	}
+	// Attached last so the queries are redirected once all the queues have
+	// their socket.
+	if l.link, l.mode, err = attachXDPProgram(iface.Index, l.progFD); err != nil {
+		return err
+	}
+	return nil
+}
+
+// rxQueues returns the number of receive queues of the interface iface.
+func rxQueues(iface string) int {
+	queues, _ := filepath.Glob(filepath.Join("/sys/class/net", iface, "queues", "rx-*"))
+	if len(queues) == 0 {
+		return 1
+	}
+	return len(queues)
+}
+
+// Close implements Listener interface.
+func (l *XDPListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+	return l.release()
+}
+
+// release detaches the program and releases the resources of the listener
+// once the readers returned.
+func (l *XDPListener) release() error {
+	if !l.opened {
+		return nil
+	}
+	l.opened = false
+	// The program is detached first so the queries are passed to the
+	// network stack again.
+	var err error
+	if l.link != -1 {
+		err = unix.Close(l.link)
+	} else if l.mode != 0 {
+		err = setXDPProgram(l.ifindex, -1, l.mode)
+	}
+	l.link, l.mode = -1, 0
+	if l.wake[1] != -1 {
+		unix.Close(l.wake[1])
+	}
+	l.readers.Wait()
+	for _, s := range l.sockets {
+		s.close()
+	}
+	for _, fd := range []int{l.wake[0], l.progFD, l.mapFD} {
+		if fd != -1 {
+			unix.Close(fd)
+		}
+	}
+	l.sockets, l.mapFD, l.progFD, l.wake = nil, -1, -1, [2]int{-1, -1}
+	return err
+}
+
+// Serve implements Listener interface.
+func (l *XDPListener) Serve(h Handler) error {
+	l.mu.Lock()
+	if l.closed || len(l.sockets) == 0 {
+		l.mu.Unlock()
+		return errors.New("not listening")
+	}
+	sockets, wake := l.sockets, l.wake[0]
+	l.readers.Add(len(sockets))
+	l.mu.Unlock()
+
+	bpool := newUDPBufferPool()
+	port := uint16(l.port())
+	errs := make(chan error, len(sockets))
+	for _, s := range sockets {
+		go func(s *xdpSocket) {
+			defer l.readers.Done()
+			errs <- s.serve(wake, h, port, bpool)
+		}(s)
+	}
+	// The other readers return once the listener is closed.
+	return <-errs
+}
+
+// xdpRing is a ring shared with the kernel. The producer and consumer
+// indexes are free running, entries being indexed by their value masked.
+type xdpRing struct {
+	mem      []byte
+	producer *uint32
+	consumer *uint32
+	desc     unsafe.Pointer
+}
+
+const xdpRingMask = xdpRingSize - 1
+
+// mapXDPRing maps the ring of the socket fd at the mmap offset pgoff, the
+// ring having entries of entrySize bytes.
+func mapXDPRing(fd int, off unix.XDPRingOffset, pgoff int64, entrySize uintptr) (r xdpRing, err error) {
+	size := int(off.Desc) + xdpRingSize*int(entrySize)
+	r.mem, err = unix.Mmap(fd, pgoff, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return r, err
+	}
+	r.producer = (*uint32)(unsafe.Pointer(&r.mem[off.Producer]))
+	r.consumer = (*uint32)(unsafe.Pointer(&r.mem[off.Consumer]))
+	r.desc = unsafe.Pointer(&r.mem[off.Desc])
+	return r, nil
+}
+
+// addrs returns the entries of a fill or completion ring.
+func (r xdpRing) addrs() *[xdpRingSize]uint64 {
+	return (*[xdpRingSize]uint64)(r.desc)
+}
+
+// descs returns the entries of a receive or transmit ring.
+func (r xdpRing) descs() *[xdpRingSize]unix.XDPDesc {
+	return (*[xdpRingSize]unix.XDPDesc)(r.desc)
+}
+
+// xdpSocket is an AF_XDP socket bound to a receive queue.
+type xdpSocket struct {
+	fd   int
+	umem []byte
+
+	rx, fill xdpRing
+
+	// mu guards the transmit and completion rings, the frames available to
+	// send the responses and closed.
+	mu     sync.Mutex
+	tx     xdpRing
+	comp   xdpRing
+	free   []uint64
+	closed bool
+}
+
+// openXDPSocket opens an AF_XDP socket bound to the receive queue queue of the
+// interface ifindex.
+func openXDPSocket(ifindex, queue int) (_ *xdpSocket, err error) {
+	fd, err := unix.Socket(unix.AF_XDP, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("AF_XDP socket: %w", err)
+	}
+	s := &xdpSocket{fd: fd}
+	defer func() {
+		if err != nil {
+			s.close()
+		}
+	}()
+	s.umem, err = unix.Mmap(-1, 0, xdpFrames*xdpFrameSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_POPULATE)
+	if err != nil {
+		return nil, fmt.Errorf("UMEM: %w", err)
+	}
+	reg := unix.XDPUmemReg{
+		Addr: uint64(uintptr(unsafe.Pointer(&s.umem[0]))),
+		Len:  uint64(len(s.umem)),
+		Size: xdpFrameSize,
+	}
+	if err := setsockopt(fd, unix.XDP_UMEM_REG, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		return nil, fmt.Errorf("XDP_UMEM_REG: %w", err)
+	}
+	for _, ring := range []int{unix.XDP_UMEM_FILL_RING, unix.XDP_UMEM_COMPLETION_RING, unix.XDP_RX_RING, unix.XDP_TX_RING} {
+		if err := unix.SetsockoptInt(fd, unix.SOL_XDP, ring, xdpRingSize); err != nil {
+			return nil, fmt.Errorf("ring size: %w", err)
+		}
+	}
+	var off unix.XDPMmapOffsets
+	offLen := uint32(unsafe.Sizeof(off))
+	if _, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), unix.SOL_XDP, unix.XDP_MMAP_OFFSETS,
+		uintptr(unsafe.Pointer(&off)), uintptr(unsafe.Pointer(&offLen)), 0); errno != 0 {
+		return nil, fmt.Errorf("XDP_MMAP_OFFSETS: %w", errno)
+	}
+	descSize, addrSize := unsafe.Sizeof(unix.XDPDesc{}), unsafe.Sizeof(uint64(0))
+	if s.rx, err = mapXDPRing(fd, off.Rx, unix.XDP_PGOFF_RX_RING, descSize); err != nil {
+		return nil, fmt.Errorf("RX ring: %w", err)
+	}
+	if s.tx, err = mapXDPRing(fd, off.Tx, unix.XDP_PGOFF_TX_RING, descSize); err != nil {
+		return nil, fmt.Errorf("TX ring: %w", err)
+	}
+	if s.fill, err = mapXDPRing(fd, off.Fr, unix.XDP_UMEM_PGOFF_FILL_RING, addrSize); err != nil {
+		return nil, fmt.Errorf("fill ring: %w", err)
+	}
+	if s.comp, err = mapXDPRing(fd, off.Cr, unix.XDP_UMEM_PGOFF_COMPLETION_RING, addrSize); err != nil {
+		return nil, fmt.Errorf("completion ring: %w", err)
+	}
+
+	// The first half of the frames receive the queries, the other half send
+	// the responses.
+	fill := s.fill.addrs()
+	for i := 0; i < xdpRingSize; i++ {
+		fill[i] = uint64(i * xdpFrameSize)
+	}
+	atomic.StoreUint32(s.fill.producer, xdpRingSize)
+	for i := xdpRingSize; i < xdpFrames; i++ {
+		s.free = append(s.free, uint64(i*xdpFrameSize))
+	}
+
+	// The kernel releases the queue of a closed socket asynchronously, a
+	// listener opened right after another one was closed may have to wait
+	// for it.
+	sa := &unix.SockaddrXDP{Ifindex: uint32(ifindex), QueueID: uint32(queue)}
+	for i := 0; ; i++ {
+		err = unix.Bind(fd, sa)
+		if err != unix.EBUSY || i == 20 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bind: %w", err)
+	}
+	return s, nil
+}
+
+// setsockopt sets the SOL_XDP option opt to the value of size bytes at val.
+func setsockopt(fd, opt int, val unsafe.Pointer, size uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT, uintptr(fd), unix.SOL_XDP, uintptr(opt), uintptr(val), size, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (s *xdpSocket) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for _, r := range []xdpRing{s.rx, s.tx, s.fill, s.comp} {
+		if r.mem != nil {
+			_ = unix.Munmap(r.mem)
+		}
+	}
+	unix.Close(s.fd)
+	if s.umem != nil {
+		_ = unix.Munmap(s.umem)
+	}
+}
+
+// serve serves the queries received on s with h until wake is readable.
+func (s *xdpSocket) serve(wake int, h Handler, port uint16, bpool *udpBufferPool) error {
+	fds := []unix.PollFd{
+		{Fd: int32(s.fd), Events: unix.POLLIN},
+		{Fd: int32(wake), Events: unix.POLLIN},
+	}
+	for {
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return err
+		}
+		if fds[1].Revents != 0 {
+			return nil
+		}
+		s.receive(h, port, bpool)
+	}
+}
+
+// receive serves the queries in the receive ring and gives their frames back
+// to the kernel.
+func (s *xdpSocket) receive(h Handler, port uint16, bpool *udpBufferPool) {
+	rx, fill := s.rx.descs(), s.fill.addrs()
+	cons, prod := *s.rx.consumer, atomic.LoadUint32(s.rx.producer)
+	fillProd := *s.fill.producer
+	for ; cons != prod; cons++ {
+		d := rx[cons&xdpRingMask]
+		s.handle(s.umem[d.Addr:d.Addr+uint64(d.Len)], h, port, bpool)
+		// Addresses may be offset by the headroom of the driver.
+		fill[fillProd&xdpRingMask] = d.Addr &^ (xdpFrameSize - 1)
+		fillProd++
+	}
+	atomic.StoreUint32(s.rx.consumer, cons)
+	atomic.StoreUint32(s.fill.producer, fillProd)
+}
+
+// handle serves the query in frame with h in the background. The frame is
+// reused once handle returns.
+func (s *xdpSocket) handle(frame []byte, h Handler, port uint16, bpool *udpBufferPool) {
+	p, ok := parseXDPFrame(frame)
+	if !ok || p.dstPort != port || len(p.payload) <= 14 {
+		return
+	}
+	qsize := len(p.payload)
+	bp := bpool.Get(qsize)
+	copy(*bp, p.payload)
+	p.payload = nil
+	p.src = append(net.IP(nil), p.src...)
+	p.dst = append(net.IP(nil), p.dst...)
+	raddr := &net.UDPAddr{IP: p.src, Port: int(p.srcPort)}
+	maxSize := xdpFrameSize - p.headerLen()
+	go func() {
+		bp := bpool.responseBuffer(h, bp, qsize)
+		defer bpool.Put(bp)
+		buf := *bp
+		if len(buf) > maxSize {
+			buf = buf[:maxSize]
+		}
+		rsize, err := h.ServeDNS("UDP", raddr, buf, qsize)
+		if err == nil {
+			rsize, err = fitUDP(buf, rsize)
+		}
+		if err != nil {
+			return
+		}
+		s.send(p, buf[:rsize])
+	}()
+}
+
+// send sends payload back to the sender of p. The response is dropped if no
+// frame is available, the client retrying.
+func (s *xdpSocket) send(p xdpPacket, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	// Reclaim the frames of the responses sent.
+	comp := s.comp.addrs()
+	cons, prod := *s.comp.consumer, atomic.LoadUint32(s.comp.producer)
+	for ; cons != prod; cons++ {
+		s.free = append(s.free, comp[cons&xdpRingMask])
+	}
+	atomic.StoreUint32(s.comp.consumer, cons)
+	if len(s.free) == 0 {
+		return
+	}
+	addr := s.free[len(s.free)-1]
+	s.free = s.free[:len(s.free)-1]
+	n := p.reply(s.umem[addr:addr+xdpFrameSize], payload)
+	tprod := *s.tx.producer
+	s.tx.descs()[tprod&xdpRingMask] = unix.XDPDesc{Addr: addr, Len: uint32(n)}
+	atomic.StoreUint32(s.tx.producer, tprod+1)
+	// Wake the kernel up to send the frames of the ring.
+	_, _, _ = unix.Syscall6(unix.SYS_SENDTO, uintptr(s.fd), 0, 0, unix.MSG_DONTWAIT, 0, 0)
+}
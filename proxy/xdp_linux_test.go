@@ -0,0 +1,63 @@
+// +build linux
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestXDPListener(t *testing.T) {
+	// A free port, the listener does not bind it. IPv6 is used as IPv4
+	// packets from 127.0.0.0/8 sent by the listener are dropped as martians.
+	c, err := net.ListenPacket("udp", "[::1]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := c.LocalAddr().(*net.UDPAddr).Port
+	c.Close()
+
+	l := &XDPListener{Interface: "lo", Port: port}
+	if err := l.Listen(context.Background()); err != nil {
+		t.Skipf("AF_XDP not available: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Serve(echoHandler{})
+	}()
+
+	conn, err := net.Dial("udp", net.JoinHostPort("::1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	q := []byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x07example\x03com\x00\x00\x01\x00\x01")
+	if _, err := conn.Write(q); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no response: %v", err)
+	}
+	if !bytes.Equal(buf[:n], q) {
+		t.Errorf("response = %x, want %x", buf[:n], q)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return on Close")
+	}
+}
@@ -0,0 +1,25 @@
+// +build !linux
+
+package proxy
+
+import (
+	"context"
+	"errors"
+)
+
+type xdp struct{}
+
+// Listen implements Listener interface.
+func (l *XDPListener) Listen(ctx context.Context) error {
+	return errors.New("AF_XDP is only supported on Linux")
+}
+
+// Close implements Listener interface.
+func (l *XDPListener) Close() error {
+	return nil
+}
+
+// Serve implements Listener interface.
+func (l *XDPListener) Serve(h Handler) error {
+	return errors.New("not listening")
+}
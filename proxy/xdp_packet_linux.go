@@ -0,0 +1,162 @@
+// +build linux
+
+package proxy
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// Sizes of the headers of the UDP datagrams received with AF_XDP.
+const (
+	ethHeaderLen  = 14
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+)
+
+const (
+	ethTypeIPv4 = 0x0800
+	ethTypeIPv6 = 0x86dd
+	ipProtoUDP  = 17
+)
+
+// xdpPacket holds the addresses of a UDP datagram read from an Ethernet
+// frame.
+type xdpPacket struct {
+	ethSrc, ethDst   [6]byte
+	src, dst         net.IP
+	srcPort, dstPort uint16
+	payload          []byte
+}
+
+// parseXDPFrame parses the Ethernet frame of an IPv4 or IPv6 UDP datagram.
+// Fragmented datagrams and IPv6 extension headers are not supported.
+func parseXDPFrame(frame []byte) (p xdpPacket, ok bool) {
+	if len(frame) < ethHeaderLen {
+		return p, false
+	}
+	copy(p.ethDst[:], frame[0:6])
+	copy(p.ethSrc[:], frame[6:12])
+	var udp []byte
+	switch binary.BigEndian.Uint16(frame[12:14]) {
+	case ethTypeIPv4:
+		ip := frame[ethHeaderLen:]
+		if len(ip) < ipv4HeaderLen || ip[0]>>4 != 4 {
+			return p, false
+		}
+		hlen := int(ip[0]&0x0f) * 4
+		tlen := int(binary.BigEndian.Uint16(ip[2:4]))
+		if hlen < ipv4HeaderLen || tlen < hlen || tlen > len(ip) ||
+			ip[9] != ipProtoUDP || binary.BigEndian.Uint16(ip[6:8])&0x3fff != 0 {
+			return p, false
+		}
+		p.src, p.dst = net.IP(ip[12:16]), net.IP(ip[16:20])
+		udp = ip[hlen:tlen]
+	case ethTypeIPv6:
+		ip := frame[ethHeaderLen:]
+		if len(ip) < ipv6HeaderLen || ip[0]>>4 != 6 || ip[6] != ipProtoUDP {
+			return p, false
+		}
+		plen := int(binary.BigEndian.Uint16(ip[4:6]))
+		if plen > len(ip)-ipv6HeaderLen {
+			return p, false
+		}
+		p.src, p.dst = net.IP(ip[8:24]), net.IP(ip[24:40])
+		udp = ip[ipv6HeaderLen : ipv6HeaderLen+plen]
+	default:
+		return p, false
+	}
+	if len(udp) < udpHeaderLen {
+		return p, false
+	}
+	ulen := int(binary.BigEndian.Uint16(udp[4:6]))
+	if ulen < udpHeaderLen || ulen > len(udp) {
+		return p, false
+	}
+	p.srcPort = binary.BigEndian.Uint16(udp[0:2])
+	p.dstPort = binary.BigEndian.Uint16(udp[2:4])
+	p.payload = udp[udpHeaderLen:ulen]
+	return p, true
+}
+
+// headerLen returns the size of the headers of the frames sent to the sender
+// of p.
+func (p xdpPacket) headerLen() int {
+	if p.src.To4() != nil {
+		return ethHeaderLen + ipv4HeaderLen + udpHeaderLen
+	}
+	return ethHeaderLen + ipv6HeaderLen + udpHeaderLen
+}
+
+// reply writes into frame the Ethernet frame sending payload back to the
+// sender of p, and returns its size. The frame must hold p.headerLen() bytes
+// more than payload.
+func (p xdpPacket) reply(frame, payload []byte) int {
+	copy(frame[0:6], p.ethSrc[:])
+	copy(frame[6:12], p.ethDst[:])
+	ulen := udpHeaderLen + len(payload)
+	var udp, pseudo []byte
+	if p.src.To4() != nil {
+		binary.BigEndian.PutUint16(frame[12:14], ethTypeIPv4)
+		ip := frame[ethHeaderLen : ethHeaderLen+ipv4HeaderLen]
+		ip[0], ip[1] = 0x45, 0
+		binary.BigEndian.PutUint16(ip[2:4], uint16(ipv4HeaderLen+ulen))
+		binary.BigEndian.PutUint32(ip[4:8], 0x4000) // DF
+		ip[8], ip[9] = 64, ipProtoUDP
+		ip[10], ip[11] = 0, 0
+		copy(ip[12:16], p.dst.To4())
+		copy(ip[16:20], p.src.To4())
+		binary.BigEndian.PutUint16(ip[10:12], ^fold(sumWords(0, ip)))
+		pseudo = ip[12:20]
+		udp = frame[ethHeaderLen+ipv4HeaderLen:]
+	} else {
+		binary.BigEndian.PutUint16(frame[12:14], ethTypeIPv6)
+		ip := frame[ethHeaderLen : ethHeaderLen+ipv6HeaderLen]
+		binary.BigEndian.PutUint32(ip[0:4], 6<<28)
+		binary.BigEndian.PutUint16(ip[4:6], uint16(ulen))
+		ip[6], ip[7] = ipProtoUDP, 64
+		copy(ip[8:24], p.dst.To16())
+		copy(ip[24:40], p.src.To16())
+		pseudo = ip[8:40]
+		udp = frame[ethHeaderLen+ipv6HeaderLen:]
+	}
+	binary.BigEndian.PutUint16(udp[0:2], p.dstPort)
+	binary.BigEndian.PutUint16(udp[2:4], p.srcPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(ulen))
+	udp[6], udp[7] = 0, 0
+	copy(udp[udpHeaderLen:], payload)
+	udp = udp[:ulen]
+
+	// The checksum covers a pseudo header made of the addresses, the protocol
+	// and the UDP length.
+	sum := sumWords(ipProtoUDP+uint32(ulen), pseudo)
+	csum := ^fold(sumWords(sum, udp))
+	if csum == 0 {
+		// Zero means no checksum, its complement is sent instead.
+		csum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:8], csum)
+	return p.headerLen() + len(payload)
+}
+
+// sumWords adds the big endian 16-bit words of b to the ones' complement sum
+// sum, b being padded with a zero byte if its size is odd.
+func sumWords(sum uint32, b []byte) uint32 {
+	for len(b) >= 2 {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	return sum
+}
+
+// fold folds sum into 16 bits, adding the carries back.
+func fold(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return uint16(sum)
+}
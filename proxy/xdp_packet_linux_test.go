@@ -0,0 +1,103 @@
+// +build linux
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// udpChecksumValid returns true if the UDP checksum of the datagram udp sent
+// from src to dst is valid.
+func udpChecksumValid(src, dst net.IP, udp []byte) bool {
+	var pseudo []byte
+	if src.To4() != nil {
+		pseudo = append(append(pseudo, src.To4()...), dst.To4()...)
+	} else {
+		pseudo = append(append(pseudo, src.To16()...), dst.To16()...)
+	}
+	sum := sumWords(ipProtoUDP+uint32(len(udp)), pseudo)
+	return fold(sumWords(sum, udp)) == 0xffff
+}
+
+func TestXDPPacket_reply(t *testing.T) {
+	clientMAC := [6]byte{0x02, 0, 0, 0, 0, 1}
+	serverMAC := [6]byte{0x02, 0, 0, 0, 0, 2}
+	tests := []struct {
+		name           string
+		client, server net.IP
+		payload        []byte
+	}{
+		{"ipv4", net.IPv4(192, 168, 1, 10), net.IPv4(192, 168, 1, 1), []byte("query")},
+		{"ipv4 odd", net.IPv4(10, 0, 0, 2).To4(), net.IPv4(10, 0, 0, 1).To4(), []byte("odd query")},
+		{"ipv6", net.ParseIP("fd00::10"), net.ParseIP("fd00::1"), []byte("query")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The query is built as the reply of the server to the client.
+			q := xdpPacket{ethSrc: serverMAC, ethDst: clientMAC, src: tt.server, dst: tt.client, srcPort: 53, dstPort: 40000}
+			frame := make([]byte, xdpFrameSize)
+			n := q.reply(frame, tt.payload)
+			p, ok := parseXDPFrame(frame[:n])
+			if !ok {
+				t.Fatalf("parseXDPFrame(%x) failed", frame[:n])
+			}
+			if p.ethSrc != clientMAC || p.ethDst != serverMAC {
+				t.Errorf("MACs = %x > %x, want %x > %x", p.ethSrc, p.ethDst, clientMAC, serverMAC)
+			}
+			if !p.src.Equal(tt.client) || !p.dst.Equal(tt.server) || p.srcPort != 40000 || p.dstPort != 53 {
+				t.Errorf("addresses = %v:%d > %v:%d", p.src, p.srcPort, p.dst, p.dstPort)
+			}
+			if !bytes.Equal(p.payload, tt.payload) {
+				t.Errorf("payload = %q, want %q", p.payload, tt.payload)
+			}
+			if n != q.headerLen()+len(tt.payload) {
+				t.Errorf("size = %d, want %d", n, q.headerLen()+len(tt.payload))
+			}
+			ip := frame[ethHeaderLen:n]
+			var udp []byte
+			if tt.client.To4() != nil {
+				if fold(sumWords(0, ip[:ipv4HeaderLen])) != 0xffff {
+					t.Error("invalid IPv4 header checksum")
+				}
+				udp = ip[ipv4HeaderLen:]
+			} else {
+				udp = ip[ipv6HeaderLen:]
+			}
+			if !udpChecksumValid(tt.client, tt.server, udp) {
+				t.Error("invalid UDP checksum")
+			}
+		})
+	}
+}
+
+func Test_parseXDPFrame_Invalid(t *testing.T) {
+	q := xdpPacket{src: net.IPv4(192, 168, 1, 1), dst: net.IPv4(192, 168, 1, 10), srcPort: 53, dstPort: 40000}
+	valid := make([]byte, xdpFrameSize)
+	valid = valid[:q.reply(valid, []byte("query"))]
+	if _, ok := parseXDPFrame(valid); !ok {
+		t.Fatal("valid frame refused")
+	}
+	tests := []struct {
+		name   string
+		modify func(f []byte) []byte
+	}{
+		{"short", func(f []byte) []byte { return f[:ethHeaderLen+ipv4HeaderLen+4] }},
+		{"arp", func(f []byte) []byte { binary.BigEndian.PutUint16(f[12:], 0x0806); return f }},
+		{"tcp", func(f []byte) []byte { f[ethHeaderLen+9] = 6; return f }},
+		{"fragment", func(f []byte) []byte { f[ethHeaderLen+6] |= 0x20; return f }},
+		{"ip length", func(f []byte) []byte { binary.BigEndian.PutUint16(f[ethHeaderLen+2:], 1000); return f }},
+		{"udp length", func(f []byte) []byte { binary.BigEndian.PutUint16(f[ethHeaderLen+ipv4HeaderLen+4:], 1000); return f }},
+		{"ihl", func(f []byte) []byte { f[ethHeaderLen] = 0x44; return f }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.modify(append([]byte(nil), valid...))
+			if _, ok := parseXDPFrame(f); ok {
+				t.Error("invalid frame accepted")
+			}
+		})
+	}
+}
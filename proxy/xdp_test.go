@@ -0,0 +1,30 @@
+package proxy
+
+import "testing"
+
+func TestParseXDPListener(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"eth0", "XDP/eth0:53", false},
+		{"eth0:5353", "XDP/eth0:5353", false},
+		{"eth0:", "", true},
+		{"eth0:dns", "", true},
+		{"eth0:70000", "", true},
+		{":53", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			l, err := ParseXDPListener(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseXDPListener() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && l.String() != tt.want {
+				t.Errorf("ParseXDPListener() = %v, want %v", l, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package ra
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv6"
+)
+
+// DHCPv6 message types and options (RFC 8415 and RFC 3646).
+const (
+	dhcpInformationRequest = 11
+	dhcpReply              = 7
+
+	optClientID   = 1
+	optServerID   = 2
+	optDNSServers = 23
+)
+
+var allDHCPAgents = &net.UDPAddr{IP: net.ParseIP("ff02::1:2")}
+
+// serveDHCPv6 answers the DHCPv6 Information-Request messages received on the
+// interfaces with the DNS servers until ctx is cancelled.
+func (a *Announcer) serveDHCPv6(ctx context.Context) error {
+	ifis := make([]*net.Interface, 0, len(a.Interfaces))
+	for _, name := range a.Interfaces {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		ifis = append(ifis, ifi)
+	}
+	var lc net.ListenConfig
+	c, err := lc.ListenPacket(ctx, "udp6", "[::]:547")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+	p := ipv6.NewPacketConn(c)
+	for _, ifi := range ifis {
+		if err := p.JoinGroup(ifi, allDHCPAgents); err != nil {
+			return fmt.Errorf("%s: join group: %v", ifi.Name, err)
+		}
+	}
+	if err := p.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, cm, src, err := p.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return err
+		}
+		if cm == nil {
+			continue
+		}
+		var ifi *net.Interface
+		for _, i := range ifis {
+			if i.Index == cm.IfIndex {
+				ifi = i
+				break
+			}
+		}
+		if ifi == nil {
+			continue
+		}
+		servers := ifaceServers(ifi)
+		if len(servers) == 0 {
+			continue
+		}
+		resp, err := dhcpv6Reply(buf[:n], duid(ifi), servers)
+		if err != nil || resp == nil {
+			continue
+		}
+		if _, err := p.WriteTo(resp, &ipv6.ControlMessage{IfIndex: ifi.Index}, src); err != nil {
+			a.logErr(fmt.Errorf("dhcpv6: %s: %v", ifi.Name, err))
+		}
+	}
+}
+
+// duid returns the DUID-LL (RFC 8415 section 11.4) of ifi used as server
+// identifier.
+func duid(ifi *net.Interface) []byte {
+	mac := ifi.HardwareAddr
+	if len(mac) == 0 {
+		// Interfaces without a link-layer address (i.e. tunnels) get one
+		// derived from their index.
+		mac = net.HardwareAddr{0x02, 0, 0, 0, byte(ifi.Index >> 8), byte(ifi.Index)}
+	}
+	b := []byte{0, 3, 0, 1} // DUID-LL, Ethernet
+	return append(b, mac...)
+}
+
+// dhcpv6Reply returns the reply to the DHCPv6 message msg announcing servers,
+// or nil if msg is not an Information-Request. The other messages are left to
+// the stateful DHCPv6 server of the network, if any.
+func dhcpv6Reply(msg, serverID []byte, servers []net.IP) ([]byte, error) {
+	if len(msg) < 4 {
+		return nil, errors.New("message too short")
+	}
+	if msg[0] != dhcpInformationRequest {
+		return nil, nil
+	}
+	var clientID []byte
+	for opts := msg[4:]; len(opts) > 0; {
+		if len(opts) < 4 {
+			return nil, errors.New("invalid option")
+		}
+		code := binary.BigEndian.Uint16(opts)
+		size := int(binary.BigEndian.Uint16(opts[2:]))
+		if len(opts) < 4+size {
+			return nil, errors.New("invalid option length")
+		}
+		data := opts[4 : 4+size]
+		switch code {
+		case optClientID:
+			clientID = data
+		case optServerID:
+			// Only answer the requests sent to any server or to this one.
+			if string(data) != string(serverID) {
+				return nil, nil
+			}
+		}
+		opts = opts[4+size:]
+	}
+	b := []byte{dhcpReply, msg[1], msg[2], msg[3]} // Same transaction ID.
+	if clientID != nil {
+		b = appendOption(b, optClientID, clientID)
+	}
+	b = appendOption(b, optServerID, serverID)
+	var dns []byte
+	for _, ip := range servers {
+		dns = append(dns, ip.To16()...)
+	}
+	return appendOption(b, optDNSServers, dns), nil
+}
+
+func appendOption(b []byte, code uint16, data []byte) []byte {
+	var hdr [4]byte
+	binary.BigEndian.PutUint16(hdr[:], code)
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(data)))
+	return append(append(b, hdr[:]...), data...)
+}
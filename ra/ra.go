@@ -0,0 +1,274 @@
+// Package ra announces the proxy as the DNS server of IPv6 networks, with the
+// RDNSS option of router advertisements (RFC 8106) and stateless DHCPv6 (RFC
+// 3646), so clients pick it up automatically.
+package ra
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// defaultInterval is the interval between two unsolicited router
+	// advertisements when Interval is not set.
+	defaultInterval = 200 * time.Second
+
+	// minDelayBetweenRAs is the minimum delay between two advertisements sent
+	// on an interface in response to solicitations (RFC 4861 section 10).
+	minDelayBetweenRAs = 3 * time.Second
+
+	// maxServers is the maximum number of addresses announced per interface.
+	maxServers = 3
+
+	// optRDNSS is the type of the Recursive DNS Server option.
+	optRDNSS = 25
+)
+
+var allNodes = &net.IPAddr{IP: net.ParseIP("ff02::1")}
+
+var allRouters = &net.IPAddr{IP: net.ParseIP("ff02::2")}
+
+// Announcer sends router advertisements carrying only the addresses of the
+// host as recursive DNS servers on Interfaces, and optionally answers the
+// stateless DHCPv6 requests with them. The advertisements have a zero router
+// lifetime, so they do not change the default route of the clients, but must
+// not be sent on interfaces where another daemon advertises the same router.
+type Announcer struct {
+	// Interfaces are the names of the network interfaces the DNS servers are
+	// announced on.
+	Interfaces []string
+
+	// DHCPv6 specifies that the Information-Request messages of the DHCPv6
+	// clients are answered with the DNS servers (option 23), and that the
+	// advertisements tell clients to send them.
+	DHCPv6 bool
+
+	// Interval is the interval between two unsolicited advertisements.
+	// Default is 200s. The DNS servers are announced for 3 intervals.
+	Interval time.Duration
+
+	// InfoLog specifies an optional log function called when the announcer
+	// starts.
+	InfoLog func(string)
+
+	// ErrorLog specifies an optional log function for errors.
+	ErrorLog func(error)
+
+	mu   sync.Mutex
+	last map[int]time.Time
+}
+
+// Validate checks the interfaces exist and announcing is supported on this
+// platform.
+func (a *Announcer) Validate() error {
+	if !supported {
+		return errors.New("not supported on this platform")
+	}
+	if len(a.Interfaces) == 0 {
+		return errors.New("no interface")
+	}
+	for _, name := range a.Interfaces {
+		if _, err := net.InterfaceByName(name); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Start runs the announcer until ctx is cancelled.
+func (a *Announcer) Start(ctx context.Context) {
+	if err := a.Validate(); err != nil {
+		a.logErr(err)
+		return
+	}
+	if a.DHCPv6 {
+		go func() {
+			if err := a.serveDHCPv6(ctx); err != nil {
+				a.logErr(fmt.Errorf("dhcpv6: %w", err))
+			}
+		}()
+	}
+	if err := a.run(ctx); err != nil {
+		a.logErr(err)
+	}
+}
+
+func (a *Announcer) logErr(err error) {
+	if a.ErrorLog != nil {
+		a.ErrorLog(fmt.Errorf("rdnss: %w", err))
+	}
+}
+
+func (a *Announcer) interval() time.Duration {
+	if a.Interval > 0 {
+		return a.Interval
+	}
+	return defaultInterval
+}
+
+func (a *Announcer) run(ctx context.Context) error {
+	ifis := make([]*net.Interface, 0, len(a.Interfaces))
+	for _, name := range a.Interfaces {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		ifis = append(ifis, ifi)
+	}
+
+	c, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	p := c.IPv6PacketConn()
+	var f ipv6.ICMPFilter
+	f.SetAll(true)
+	f.Accept(ipv6.ICMPTypeRouterSolicitation)
+	if err := p.SetICMPFilter(&f); err != nil {
+		return err
+	}
+	for _, ifi := range ifis {
+		if err := p.JoinGroup(ifi, allRouters); err != nil {
+			return fmt.Errorf("%s: join group: %v", ifi.Name, err)
+		}
+	}
+	if err := p.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		return err
+	}
+	// Neighbor discovery messages are only accepted with a hop limit of 255
+	// (RFC 4861 section 6.1.2).
+	_ = p.SetMulticastHopLimit(255)
+	_ = p.SetHopLimit(255)
+	_ = p.SetMulticastLoopback(false)
+
+	lifetime := 3 * a.interval()
+	advertise := func(ifi *net.Interface, lifetime time.Duration) {
+		servers := ifaceServers(ifi)
+		if len(servers) == 0 {
+			return
+		}
+		msg := advertisement(servers, lifetime, a.DHCPv6)
+		if _, err := p.WriteTo(msg, &ipv6.ControlMessage{IfIndex: ifi.Index, HopLimit: 255}, allNodes); err != nil {
+			a.logErr(fmt.Errorf("%s: %v", ifi.Name, err))
+		}
+	}
+	go func() {
+		t := time.NewTicker(a.interval())
+		defer t.Stop()
+		for {
+			for _, ifi := range ifis {
+				advertise(ifi, lifetime)
+			}
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				// A zero lifetime withdraws the servers from the clients.
+				for _, ifi := range ifis {
+					advertise(ifi, 0)
+				}
+				c.Close()
+				return
+			}
+		}
+	}()
+	if a.InfoLog != nil {
+		a.InfoLog(fmt.Sprintf("DNS server announcer started on %s", strings.Join(a.Interfaces, ", ")))
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, cm, _, err := p.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return err
+		}
+		if cm == nil || n < 1 || ipv6.ICMPType(buf[0]) != ipv6.ICMPTypeRouterSolicitation {
+			continue
+		}
+		for _, ifi := range ifis {
+			if ifi.Index == cm.IfIndex && a.solicited(ifi.Index) {
+				advertise(ifi, lifetime)
+				break
+			}
+		}
+	}
+}
+
+// solicited returns true if an advertisement can be sent on the interface
+// with the given index in response to a solicitation.
+func (a *Announcer) solicited(index int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.last == nil {
+		a.last = map[int]time.Time{}
+	}
+	now := time.Now()
+	if now.Sub(a.last[index]) < minDelayBetweenRAs {
+		return false
+	}
+	a.last[index] = now
+	return true
+}
+
+// ifaceServers returns the IPv6 addresses of ifi announced as DNS servers:
+// the global and unique local ones, or the link-local ones if it has none.
+func ifaceServers(ifi *net.Interface) []net.IP {
+	addrs, _ := ifi.Addrs()
+	var global, local []net.IP
+	for _, addr := range addrs {
+		ipn, ok := addr.(*net.IPNet)
+		if !ok || ipn.IP.To4() != nil || ipn.IP.To16() == nil {
+			continue
+		}
+		switch {
+		case ipn.IP.IsLinkLocalUnicast():
+			local = append(local, ipn.IP)
+		case ipn.IP.IsGlobalUnicast():
+			global = append(global, ipn.IP)
+		}
+	}
+	servers := global
+	if len(servers) == 0 {
+		servers = local
+	}
+	if len(servers) > maxServers {
+		servers = servers[:maxServers]
+	}
+	return servers
+}
+
+// advertisement returns a router advertisement with a zero router lifetime
+// and a RDNSS option announcing servers for lifetime. The other configuration
+// flag is set if dhcpv6 is true. The checksum is left to the kernel.
+func advertisement(servers []net.IP, lifetime time.Duration, dhcpv6 bool) []byte {
+	b := make([]byte, 16, 16+8+16*len(servers))
+	b[0] = byte(ipv6.ICMPTypeRouterAdvertisement)
+	if dhcpv6 {
+		b[5] = 0x40 // O flag
+	}
+	// Router lifetime, reachable time and retrans timer are left to zero
+	// (unspecified).
+	opt := make([]byte, 8, 8+16*len(servers))
+	opt[0] = optRDNSS
+	opt[1] = byte(1 + 2*len(servers)) // In units of 8 octets.
+	binary.BigEndian.PutUint32(opt[4:], uint32(lifetime/time.Second))
+	for _, ip := range servers {
+		opt = append(opt, ip.To16()...)
+	}
+	return append(b, opt...)
+}
@@ -0,0 +1,59 @@
+package ra
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAdvertisement(t *testing.T) {
+	servers := []net.IP{net.ParseIP("fd00::1"), net.ParseIP("2001:db8::1")}
+	got := advertisement(servers, 600*time.Second, true)
+	want := "86000000" + "00400000" + "00000000" + "00000000" + // RA, O flag
+		"1905" + "0000" + "00000258" + // RDNSS, 40 bytes, 600s
+		"fd000000000000000000000000000001" +
+		"20010db8000000000000000000000001"
+	if hex.EncodeToString(got) != want {
+		t.Errorf("advertisement() = %x, want %s", got, want)
+	}
+	if got := advertisement(servers[:1], 0, false); got[5] != 0 || !bytes.Equal(got[20:24], []byte{0, 0, 0, 0}) {
+		t.Errorf("withdrawal advertisement = %x", got)
+	}
+}
+
+func TestDHCPv6Reply(t *testing.T) {
+	serverID := duid(&net.Interface{Index: 2, HardwareAddr: net.HardwareAddr{0, 0x1c, 0x42, 0x2e, 0x60, 0x4a}})
+	servers := []net.IP{net.ParseIP("fd00::1")}
+	clientID := "0001000a00030001aabbccddeeff"
+	tests := []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{"information request",
+			"0b123456" + clientID + "00060002" + "0017",
+			"07123456" + clientID + "0002000a00030001001c422e604a" + "00170010fd000000000000000000000000000001"},
+		{"to this server",
+			"0b123456" + "0002000a00030001001c422e604a",
+			"07123456" + "0002000a00030001001c422e604a" + "00170010fd000000000000000000000000000001"},
+		{"to another server", "0b123456" + "0002000a00030001aabbccddeeff", ""},
+		{"solicit", "01123456" + clientID, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, _ := hex.DecodeString(tt.msg)
+			got, err := dhcpv6Reply(msg, serverID, servers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(got) != tt.want {
+				t.Errorf("dhcpv6Reply() = %x, want %s", got, tt.want)
+			}
+		})
+	}
+	if _, err := dhcpv6Reply([]byte{11, 0, 0, 0, 0, 1, 0, 9}, serverID, servers); err == nil {
+		t.Error("dhcpv6Reply() with a truncated option succeeded, want error")
+	}
+}
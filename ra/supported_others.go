@@ -0,0 +1,5 @@
+// +build !windows
+
+package ra
+
+const supported = true
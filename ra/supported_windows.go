@@ -0,0 +1,4 @@
+package ra
+
+// Raw ICMPv6 sockets cannot join the all-routers group on Windows.
+const supported = false
@@ -0,0 +1,112 @@
+// Package rebind implements a DNS rebinding protection removing local
+// addresses from upstream answers, so a public domain cannot be used to reach
+// devices of the LAN from a browser.
+package rebind
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/rewrite"
+)
+
+// Resolver removes the A and AAAA records with a private, loopback or link
+// local address from the answers of Upstream, and such addresses from the
+// hints of SVCB and HTTPS records. The unspecified addresses used for blocked
+// domains are kept.
+type Resolver struct {
+	// Upstream is the resolver answers are checked from. Local resolvers
+	// (i.e. conditional forwarders to the LAN) must not be part of it.
+	Upstream resolver.Resolver
+
+	// LocalNet specifies an optional function reporting addresses of local
+	// networks other than the private ones (i.e. the delegated IPv6 prefix).
+	LocalNet func(ip net.IP) bool
+
+	// OnRebind is called for each removed record.
+	OnRebind func(name string, ip net.IP)
+}
+
+var privateNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"::1/128",
+		"fe80::/10",
+		"fc00::/7",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+func (r *Resolver) isLocal(ip net.IP) bool {
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return r.LocalNet != nil && r.LocalNet(ip)
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	n, i, err = r.Upstream.Resolve(ctx, q, buf)
+	switch q.Type {
+	case "A", "AAAA", "ANY", "SVCB", "HTTPS":
+	default:
+		return n, i, err
+	}
+	if err != nil || n <= 0 {
+		return n, i, err
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(buf[:n]); err != nil {
+		// Leave responses we cannot parse untouched.
+		return n, i, nil
+	}
+	changed := false
+	answers := m.Answers[:0]
+	for _, a := range m.Answers {
+		for _, ip := range rewrite.FilterSVCBHints(a, func(ip net.IP) bool { return !r.isLocal(ip) }) {
+			changed = true
+			if r.OnRebind != nil {
+				r.OnRebind(q.Name, ip)
+			}
+		}
+		var ip net.IP
+		switch b := a.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(b.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(b.AAAA[:])
+		}
+		if ip != nil && r.isLocal(ip) {
+			if r.OnRebind != nil {
+				r.OnRebind(q.Name, ip)
+			}
+			continue
+		}
+		answers = append(answers, a)
+	}
+	if !changed && len(answers) == len(m.Answers) {
+		return n, i, nil
+	}
+	m.Answers = answers
+	b, err := m.AppendPack(buf[:0])
+	if err != nil {
+		return 0, i, err
+	}
+	if len(b) > len(buf) {
+		return 0, i, errors.New("rebind: response too large")
+	}
+	return len(b), i, nil
+}
@@ -0,0 +1,81 @@
+package rebind
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+type staticResolver []net.IP
+
+func (r staticResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	name := dnsmessage.MustNewName(q.Name)
+	m := dnsmessage.Message{
+		Header:    dnsmessage.Header{Response: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}
+	hdr := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 60}
+	for _, ip := range r {
+		if ip4 := ip.To4(); ip4 != nil {
+			var a [4]byte
+			copy(a[:], ip4)
+			m.Answers = append(m.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AResource{A: a}})
+		} else {
+			var aaaa [16]byte
+			copy(aaaa[:], ip)
+			m.Answers = append(m.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: aaaa}})
+		}
+	}
+	b, err := m.AppendPack(buf[:0])
+	return len(b), resolver.ResolveInfo{}, err
+}
+
+func TestResolver(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("2001:db8:1:2::/64")
+	tests := []struct {
+		name    string
+		answers []string
+		want    int
+	}{
+		{"public", []string{"93.184.216.34", "2606:2800:220:1::1"}, 2},
+		{"private", []string{"93.184.216.34", "192.168.1.1"}, 1},
+		{"loopback", []string{"127.0.0.1"}, 0},
+		{"blocked", []string{"0.0.0.0", "::"}, 2},
+		{"ula", []string{"fd00::1"}, 0},
+		{"delegated prefix", []string{"2001:db8:1:2::10", "2001:db8:9::1"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var up staticResolver
+			for _, a := range tt.answers {
+				up = append(up, net.ParseIP(a))
+			}
+			var rebinds int
+			r := &Resolver{
+				Upstream: up,
+				LocalNet: lan.Contains,
+				OnRebind: func(name string, ip net.IP) {
+					rebinds++
+				},
+			}
+			buf := make([]byte, 512)
+			n, _, err := r.Resolve(context.Background(), resolver.Query{Name: "example.com.", Type: "A"}, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m dnsmessage.Message
+			if err := m.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Answers) != tt.want {
+				t.Errorf("got %d answers, want %d", len(m.Answers), tt.want)
+			}
+			if rebinds != len(tt.answers)-tt.want {
+				t.Errorf("got %d rebinds, want %d", rebinds, len(tt.answers)-tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// EDNS0 options identifying the client of a query, as added by dnsmasq.
+const (
+	EDNS0MAC   = 0xfde9 // dnsmasq --add-mac
+	EDNS0CPEID = 0xfe00 // dnsmasq --add-cpe-id
+)
+
+// ClientOptions sends the queries to Upstream with EDNS0 options identifying
+// their client: its MAC address when known and its device ID. It is meant for
+// upstreams not receiving the DoH headers of DOH.ClientInfo, like conditional
+// forwarders, so they can apply per-device settings even though all the
+// queries come from the proxy.
+type ClientOptions struct {
+	Upstream Resolver
+
+	// ClientInfo returns the information of the client of the query. Only its
+	// ID is sent.
+	ClientInfo func(Query) ClientInfo
+}
+
+// Resolve implements the Resolver interface.
+func (r *ClientOptions) Resolve(ctx context.Context, q Query, buf []byte) (int, ResolveInfo, error) {
+	var opts []dnsmessage.Option
+	if q.MAC != nil && !q.PeerIP.IsLoopback() {
+		opts = append(opts, dnsmessage.Option{Code: EDNS0MAC, Data: append([]byte(nil), q.MAC...)})
+	}
+	if r.ClientInfo != nil {
+		if id := r.ClientInfo(q).ID; id != "" {
+			opts = append(opts, dnsmessage.Option{Code: EDNS0CPEID, Data: []byte(id)})
+		}
+	}
+	if len(opts) > 0 {
+		if payload, err := addOptions(q.Payload, opts); err == nil {
+			Tracef(ctx, "upstream", "client options added")
+			q.Payload = payload
+		}
+	}
+	return r.Upstream.Resolve(ctx, q, buf)
+}
+
+// addOptions returns a copy of the query with opts added to its OPT record,
+// replacing the options with the same codes. An OPT record is added if the
+// query has none.
+func addOptions(query []byte, opts []dnsmessage.Option) ([]byte, error) {
+	var m dnsmessage.Message
+	if err := m.Unpack(query); err != nil {
+		return nil, err
+	}
+	replaced := func(code uint16) bool {
+		for _, o := range opts {
+			if o.Code == code {
+				return true
+			}
+		}
+		return false
+	}
+	found := false
+	for i, rr := range m.Additionals {
+		opt, ok := rr.Body.(*dnsmessage.OPTResource)
+		if !ok {
+			continue
+		}
+		var options []dnsmessage.Option
+		for _, o := range opt.Options {
+			if !replaced(o.Code) {
+				options = append(options, o)
+			}
+		}
+		m.Additionals[i].Body = &dnsmessage.OPTResource{Options: append(options, opts...)}
+		found = true
+		break
+	}
+	if !found {
+		var rh dnsmessage.ResourceHeader
+		// The size of responses without EDNS is kept.
+		if err := rh.SetEDNS0(512, dnsmessage.RCodeSuccess, false); err != nil {
+			return nil, err
+		}
+		m.Additionals = append(m.Additionals, dnsmessage.Resource{
+			Header: rh,
+			Body:   &dnsmessage.OPTResource{Options: opts},
+		})
+	}
+	return m.Pack()
+}
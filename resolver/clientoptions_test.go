@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+func TestClientOptions(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	ecs := dnsmessage.Option{Code: 0x8, Data: []byte{0, 1, 24, 0, 10, 0, 4}}
+	tests := []struct {
+		name    string
+		payload []byte
+		peerIP  net.IP
+		mac     net.HardwareAddr
+		want    map[uint16]string
+	}{
+		{"NoEDNS", newTestQuery(t, "example.com."), net.IPv4(192, 168, 0, 2), mac,
+			map[uint16]string{EDNS0MAC: string(mac), EDNS0CPEID: "abcde"}},
+		{"NoMAC", newTestQuery(t, "example.com."), net.IPv4(192, 168, 0, 2), nil,
+			map[uint16]string{EDNS0CPEID: "abcde"}},
+		{"Loopback", newTestQuery(t, "example.com."), net.IPv4(127, 0, 0, 1), mac,
+			map[uint16]string{EDNS0CPEID: "abcde"}},
+		{"Replaced", newTestQuery(t, "example.com.", ecs, dnsmessage.Option{Code: EDNS0MAC, Data: []byte{0x02, 0, 0, 0, 0, 2}}), net.IPv4(192, 168, 0, 2), mac,
+			map[uint16]string{0x8: string(ecs.Data), EDNS0MAC: string(mac), EDNS0CPEID: "abcde"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []byte
+			r := &ClientOptions{
+				Upstream: ResolverFunc(func(ctx context.Context, q Query, buf []byte) (int, ResolveInfo, error) {
+					sent = q.Payload
+					return 0, ResolveInfo{}, nil
+				}),
+				ClientInfo: func(q Query) ClientInfo {
+					return ClientInfo{ID: "abcde"}
+				},
+			}
+			q := Query{PeerIP: tt.peerIP, MAC: tt.mac, Payload: tt.payload}
+			if _, _, err := r.Resolve(context.Background(), q, nil); err != nil {
+				t.Fatal(err)
+			}
+			var p dnsmessage.Parser
+			if _, err := p.Start(sent); err != nil {
+				t.Fatal(err)
+			}
+			_ = p.SkipAllQuestions()
+			_ = p.SkipAllAnswers()
+			_ = p.SkipAllAuthorities()
+			got := map[uint16]string{}
+			for {
+				h, err := p.AdditionalHeader()
+				if err != nil {
+					break
+				}
+				if h.Type == dnsmessage.TypeOPT {
+					_ = p.OPTOptions(func(code uint16, data []byte) {
+						got[code] = string(data)
+					})
+					break
+				}
+				_ = p.SkipAdditional()
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got options %q, want %q", got, tt.want)
+			}
+			for code, data := range tt.want {
+				if got[code] != data {
+					t.Errorf("option %d = %q, want %q", code, got[code], data)
+				}
+			}
+		})
+	}
+}
@@ -1,28 +1,86 @@
 package resolver
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"time"
 )
 
 // DNS53 is a DNS53 implementation of the Resolver interface.
+//
+// As plain DNS is not authenticated, queries are hardened against spoofing:
+// each query is sent from a new socket (thus a new random source port chosen
+// by the system) with a random transaction ID and a query name with randomized
+// case (0x20 encoding). Responses are only accepted from the server address,
+// with the same ID and the same question, other packets are ignored. If the
+// server does not preserve the case of the name, the query is sent again
+// without 0x20 encoding.
 type DNS53 struct {
-	Dialer *net.Dialer
+	// Dialer dials the sockets to the servers, a net.Dialer if nil.
+	Dialer Dialer
 }
 
-var defaultDialer = &net.Dialer{}
+// Dialer dials the connections to DNS53 servers, i.e. a net.Dialer or a
+// tunnel.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+var defaultDialer Dialer = &net.Dialer{}
+
+// errCaseMismatch is returned when a response matches the query except for
+// the case of the query name, either because the server does not preserve
+// it or because the response is spoofed.
+var errCaseMismatch = errors.New("query name case mismatch")
+
+// Exchange sends q to the DNS server at addr and writes the response into
+// buf.
+func (r DNS53) Exchange(ctx context.Context, q Query, buf []byte, addr string) (int, ResolveInfo, error) {
+	return r.resolve(ctx, q, buf, addr)
+}
 
 func (r DNS53) resolve(ctx context.Context, q Query, buf []byte, addr string) (int, ResolveInfo, error) {
+	n, i, err := r.exchange(ctx, q.Payload, buf, addr, true)
+	if err == errCaseMismatch {
+		// Some servers do not preserve the case of the query name, retry
+		// without 0x20 encoding, still protected by the ID and source port.
+		n, i, err = r.exchange(ctx, q.Payload, buf, addr, false)
+	}
+	return n, i, err
+}
+
+func (r DNS53) exchange(ctx context.Context, payload, buf []byte, addr string, randomizeCase bool) (int, ResolveInfo, error) {
 	i := ResolveInfo{Transport: "UDP"}
+	qend := questionEnd(payload)
+	if qend == -1 {
+		return -1, i, errors.New("invalid query")
+	}
+	// The payload is copied as it can share its memory with buf.
+	orig := append([]byte(nil), payload[:qend]...)
+	msg := append([]byte(nil), payload...)
+	if _, err := rand.Read(msg[:2]); err != nil {
+		return -1, i, err
+	}
+	if randomizeCase {
+		if err := randomizeNameCase(msg[12 : qend-4]); err != nil {
+			return -1, i, err
+		}
+	}
+
 	d := r.Dialer
 	if d == nil {
 		d = defaultDialer
 	}
+	start := time.Now()
 	c, err := d.DialContext(ctx, "udp", addr)
+	i.Timing.Connect = time.Since(start)
 	if err != nil {
-		return -1, i, fmt.Errorf("dial: %v", err)
+		return -1, i, fmt.Errorf("dial: %w", err)
 	}
 	defer c.Close()
 	if t, ok := ctx.Deadline(); ok {
@@ -31,13 +89,70 @@ func (r DNS53) resolve(ctx context.Context, q Query, buf []byte, addr string) (i
 			_ = c.SetDeadline(time.Time{})
 		}()
 	}
-	_, err = c.Write(q.Payload)
+	_, err = c.Write(msg)
 	if err != nil {
-		return -1, i, fmt.Errorf("write: %v", err)
+		return -1, i, fmt.Errorf("write: %w", err)
 	}
-	n, err := c.Read(buf)
-	if err != nil {
-		return -1, i, fmt.Errorf("read: %v", err)
+	wrote := time.Now()
+	for {
+		n, err := c.Read(buf)
+		i.Timing.Wait = time.Since(wrote)
+		if err != nil {
+			return -1, i, fmt.Errorf("read: %w", err)
+		}
+		if n < qend || !bytes.Equal(buf[:2], msg[:2]) || buf[2]&0x80 == 0 {
+			// Not a response to our query.
+			continue
+		}
+		if !bytes.EqualFold(buf[12:qend], msg[12:qend]) {
+			continue
+		}
+		if randomizeCase && !bytes.Equal(buf[12:qend], msg[12:qend]) {
+			return -1, i, errCaseMismatch
+		}
+		// Restore the ID and query name of the client.
+		copy(buf[:2], orig[:2])
+		copy(buf[12:qend], orig[12:qend])
+		return n, i, nil
+	}
+}
+
+// questionEnd returns the offset of the end of the first question of the
+// message m, or -1 if m is invalid.
+func questionEnd(m []byte) int {
+	if len(m) < 12 || binary.BigEndian.Uint16(m[4:6]) == 0 {
+		return -1
+	}
+	off := 12
+	for off < len(m) {
+		l := int(m[off])
+		if l == 0 {
+			if off+5 > len(m) {
+				return -1
+			}
+			return off + 5 // root label + type + class
+		}
+		if l&0xC0 != 0 {
+			// Compression is not expected in the question of a query.
+			return -1
+		}
+		off += l + 1
+	}
+	return -1
+}
+
+// randomizeNameCase randomly changes the case of the letters of the wire
+// format name.
+func randomizeNameCase(name []byte) error {
+	rnd := make([]byte, len(name))
+	if _, err := rand.Read(rnd); err != nil {
+		return err
+	}
+	for j, c := range name {
+		// Length bytes are below 64, never letters.
+		if ((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) && rnd[j]&1 == 1 {
+			name[j] = c ^ 0x20
+		}
 	}
-	return n, i, nil
+	return nil
 }
@@ -0,0 +1,93 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// query is a query for "Example.com. IN A" with ID 0x1234.
+var query = []byte{
+	0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	7, 'E', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+	0x00, 0x01, 0x00, 0x01,
+}
+
+// serveDNS53 starts a UDP server answering each query with the responses
+// built by reply. It returns the server address and connection.
+func serveDNS53(t *testing.T, reply func(q []byte) [][]byte) (string, net.PacketConn) {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			for _, r := range reply(append([]byte(nil), buf[:n]...)) {
+				_, _ = c.WriteTo(r, addr)
+			}
+		}
+	}()
+	return c.LocalAddr().String(), c
+}
+
+func response(q []byte) []byte {
+	r := append([]byte(nil), q...)
+	r[2] |= 0x80
+	return r
+}
+
+func TestDNS53_resolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   func(q []byte) [][]byte
+		wantErr bool
+	}{
+		{"preserve case", func(q []byte) [][]byte {
+			return [][]byte{response(q)}
+		}, false},
+		{"lower case", func(q []byte) [][]byte {
+			r := response(q)
+			copy(r[12:], bytes.ToLower(r[12:25]))
+			return [][]byte{r}
+		}, false},
+		{"spoofed first", func(q []byte) [][]byte {
+			spoof := response(q)
+			spoof[0]++
+			other := response(q)
+			other[13] = 'z'
+			return [][]byte{spoof, other, response(q)}
+		}, false},
+		{"spoofed only", func(q []byte) [][]byte {
+			spoof := response(q)
+			spoof[1]++
+			return [][]byte{spoof}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, c := serveDNS53(t, tt.reply)
+			defer c.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			buf := make([]byte, 512)
+			n, _, err := DNS53{}.resolve(ctx, Query{Payload: query}, buf, addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if want := response(query); !bytes.Equal(buf[:n], want) {
+				t.Errorf("resolve() = %x, want %x", buf[:n], want)
+			}
+		})
+	}
+}
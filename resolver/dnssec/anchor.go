@@ -0,0 +1,204 @@
+package dnssec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// holdDown is the RFC 5011 add hold-down time after which a new key seen in
+// the root DNSKEY set is trusted.
+const holdDown = 30 * 24 * time.Hour
+
+// Anchor states as defined by RFC 5011 section 4.
+const (
+	stateAddPend = "addpend"
+	stateValid   = "valid"
+	stateRevoked = "revoked"
+)
+
+// defaultAnchors are the IANA root zone KSKs.
+var defaultAnchors = []anchor{
+	{
+		State:      stateValid,
+		KeyTag:     20326,
+		Algorithm:  algRSASHA256,
+		DigestType: digestSHA256,
+		Digest:     "e06d44b80b8f1d39a95c0b0d7c65d08458e880409bbc683457104237c7f8ec8d",
+	},
+	{
+		State:      stateValid,
+		KeyTag:     38696,
+		Algorithm:  algRSASHA256,
+		DigestType: digestSHA256,
+		Digest:     "683d2d0acb8c9b712a1948b27f741219298d0a450d612c483af444a4c0fb2b16",
+	},
+}
+
+type anchor struct {
+	State      string    `json:"state"`
+	KeyTag     uint16    `json:"key_tag"`
+	Algorithm  uint8     `json:"algorithm"`
+	DigestType uint8     `json:"digest_type,omitempty"`
+	Digest     string    `json:"digest,omitempty"`
+	Key        []byte    `json:"key,omitempty"` // DNSKEY rdata
+	FirstSeen  time.Time `json:"first_seen,omitempty"`
+}
+
+// matches returns true if a designates k, ignoring the revoke flag.
+func (a anchor) matches(k dnskey) bool {
+	if k.Flags&flagRevoke != 0 {
+		rdata := append([]byte(nil), k.rdata...)
+		rdata[1] &^= flagRevoke
+		k, _ = parseDNSKEY(rr{Data: rdata})
+	}
+	if a.Key != nil {
+		return bytes.Equal(a.Key, k.rdata)
+	}
+	digest, err := hex.DecodeString(a.Digest)
+	if err != nil {
+		return false
+	}
+	return ds{
+		KeyTag:     a.KeyTag,
+		Algorithm:  a.Algorithm,
+		DigestType: a.DigestType,
+		Digest:     digest,
+	}.matches(root, k)
+}
+
+// Anchors is the set of root trust anchors. When File is set, the anchors are
+// kept up to date following the RFC 5011 automated update procedure and their
+// state is persisted in File.
+type Anchors struct {
+	// File specifies the path to the file storing the state of the anchors.
+	// If empty, the built-in anchors are used and updates are kept in memory.
+	File string
+
+	mu      sync.Mutex
+	loaded  bool
+	anchors []anchor
+}
+
+func (as *Anchors) load() {
+	if as.loaded {
+		return
+	}
+	as.loaded = true
+	as.anchors = append([]anchor(nil), defaultAnchors...)
+	if as.File == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(as.File)
+	if err != nil {
+		return
+	}
+	var anchors []anchor
+	if err := json.Unmarshal(b, &anchors); err == nil && len(anchors) > 0 {
+		as.anchors = anchors
+	}
+}
+
+func (as *Anchors) save() error {
+	if as.File == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(as.anchors, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(as.File), 0755); err != nil {
+		return err
+	}
+	tmp := as.File + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, as.File)
+}
+
+// trusted returns the keys of the root DNSKEY set designated by a valid
+// anchor.
+func (as *Anchors) trusted(keys []dnskey) []dnskey {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.load()
+	var trusted []dnskey
+	for _, k := range keys {
+		if k.Flags&flagRevoke != 0 {
+			continue
+		}
+		for _, a := range as.anchors {
+			if a.State == stateValid && a.matches(k) {
+				trusted = append(trusted, k)
+				break
+			}
+		}
+	}
+	return trusted
+}
+
+// update applies the RFC 5011 state transitions using a validated root DNSKEY
+// set. selfSigned reports whether a key signed the set.
+func (as *Anchors) update(keys []dnskey, selfSigned func(dnskey) bool, now time.Time) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.load()
+	changed := false
+	seen := make([]bool, len(as.anchors))
+	for _, k := range keys {
+		if k.Flags&flagSEP == 0 {
+			continue
+		}
+		found := false
+		for i := range as.anchors {
+			a := &as.anchors[i]
+			if !a.matches(k) {
+				continue
+			}
+			found = true
+			seen[i] = true
+			switch {
+			case k.Flags&flagRevoke != 0:
+				if a.State != stateRevoked && selfSigned(k) {
+					a.State = stateRevoked
+					changed = true
+				}
+			case a.State == stateAddPend && now.Sub(a.FirstSeen) >= holdDown:
+				a.State = stateValid
+				changed = true
+			}
+		}
+		if !found && k.Flags&flagRevoke == 0 {
+			as.anchors = append(as.anchors, anchor{
+				State:     stateAddPend,
+				KeyTag:    k.keyTag(),
+				Algorithm: k.Algorithm,
+				Key:       append([]byte(nil), k.rdata...),
+				FirstSeen: now,
+			})
+			seen = append(seen, true)
+			changed = true
+		}
+	}
+	// Pending keys removed from the set before the end of the hold-down are
+	// forgotten.
+	anchors := as.anchors[:0]
+	for i, a := range as.anchors {
+		if a.State == stateAddPend && !seen[i] {
+			changed = true
+			continue
+		}
+		anchors = append(anchors, a)
+	}
+	as.anchors = anchors
+	if !changed {
+		return nil
+	}
+	return as.save()
+}
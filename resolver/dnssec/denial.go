@@ -0,0 +1,319 @@
+package dnssec
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"strings"
+)
+
+// maxNSEC3Iterations is the limit above which NSEC3 records are treated as
+// insecure as recommended by RFC 9276.
+const maxNSEC3Iterations = 150
+
+var b32 = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// canonicalCompare compares a and b using the canonical DNS name order defined
+// in RFC 4034 section 6.1.
+func canonicalCompare(a, b name) int {
+	la, lb := a.lower().labels(), b.lower().labels()
+	for i, j := len(la)-1, len(lb)-1; i >= 0 || j >= 0; i, j = i-1, j-1 {
+		if i < 0 {
+			return -1
+		}
+		if j < 0 {
+			return 1
+		}
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+type nsec struct {
+	Owner  name
+	Next   name
+	Bitmap []byte
+}
+
+func parseNSEC(r rr) (nsec, error) {
+	next, off, err := parseName(r.Data, 0)
+	if err != nil {
+		return nsec{}, err
+	}
+	return nsec{Owner: r.Name.lower(), Next: next.lower(), Bitmap: r.Data[off:]}, nil
+}
+
+// covers returns true if n falls strictly between the owner and next names
+// of the record.
+func (r nsec) covers(n name) bool {
+	if canonicalCompare(r.Owner, r.Next) < 0 {
+		return canonicalCompare(r.Owner, n) < 0 && canonicalCompare(n, r.Next) < 0
+	}
+	// Last NSEC of the zone, next is the apex.
+	return canonicalCompare(r.Owner, n) < 0 || canonicalCompare(n, r.Next) < 0
+}
+
+type nsec3 struct {
+	Owner      name
+	Zone       name
+	Hash       []byte
+	Next       []byte
+	Salt       []byte
+	Iterations uint16
+	OptOut     bool
+	Bitmap     []byte
+}
+
+func parseNSEC3(r rr) (nsec3, error) {
+	d := r.Data
+	if len(d) < 5 {
+		return nsec3{}, errMalformed
+	}
+	rec := nsec3{
+		Owner:      r.Name.lower(),
+		Zone:       r.Name.lower().parent(),
+		OptOut:     d[1]&1 == 1,
+		Iterations: binary.BigEndian.Uint16(d[2:]),
+	}
+	if d[0] != 1 { // SHA-1
+		return rec, errUnsupportedAlgorithm
+	}
+	sl := int(d[4])
+	if len(d) < 6+sl {
+		return rec, errMalformed
+	}
+	rec.Salt = d[5 : 5+sl]
+	hl := int(d[5+sl])
+	if len(d) < 6+sl+hl {
+		return rec, errMalformed
+	}
+	rec.Next = d[6+sl : 6+sl+hl]
+	rec.Bitmap = d[6+sl+hl:]
+	labels := rec.Owner.labels()
+	if len(labels) == 0 {
+		return rec, errMalformed
+	}
+	h, err := b32.DecodeString(strings.ToUpper(labels[0]))
+	if err != nil {
+		return rec, err
+	}
+	rec.Hash = h
+	return rec, nil
+}
+
+// nsec3Hash computes the RFC 5155 hash of n.
+func nsec3Hash(n name, salt []byte, iterations uint16) []byte {
+	h := sha1.New()
+	h.Write([]byte(n.lower()))
+	h.Write(salt)
+	sum := h.Sum(nil)
+	for i := 0; i < int(iterations); i++ {
+		h.Reset()
+		h.Write(sum)
+		h.Write(salt)
+		sum = h.Sum(sum[:0])
+	}
+	return sum
+}
+
+func (r nsec3) hash(n name) []byte {
+	return nsec3Hash(n, r.Salt, r.Iterations)
+}
+
+func (r nsec3) matches(n name) bool {
+	return n.isSubdomain(r.Zone) && bytes.Equal(r.Hash, r.hash(n))
+}
+
+func (r nsec3) covers(n name) bool {
+	if !n.isSubdomain(r.Zone) {
+		return false
+	}
+	h := r.hash(n)
+	if bytes.Compare(r.Hash, r.Next) < 0 {
+		return bytes.Compare(r.Hash, h) < 0 && bytes.Compare(h, r.Next) < 0
+	}
+	return bytes.Compare(r.Hash, h) < 0 || bytes.Compare(h, r.Next) < 0
+}
+
+// denial holds the validated NSEC and NSEC3 records of a response.
+type denial struct {
+	nsec  []nsec
+	nsec3 []nsec3
+	// insecure is set when NSEC3 records with too many iterations were
+	// ignored.
+	insecure bool
+}
+
+// add adds the records of set if it is an NSEC or NSEC3 RRset.
+func (d *denial) add(set *rrset) {
+	for _, r := range set.rrs {
+		switch r.Type {
+		case typeNSEC:
+			if rec, err := parseNSEC(r); err == nil {
+				d.nsec = append(d.nsec, rec)
+			}
+		case typeNSEC3:
+			if rec, err := parseNSEC3(r); err == nil {
+				if rec.Iterations > maxNSEC3Iterations {
+					d.insecure = true
+					continue
+				}
+				d.nsec3 = append(d.nsec3, rec)
+			}
+		}
+	}
+}
+
+// closestEncloser returns the closest encloser of qname proven by NSEC3
+// records and whether the next closer name is covered by an opt-out record.
+func (d *denial) closestEncloser(qname name) (ce, nextCloser name, optOut, ok bool) {
+	for n := qname; ; n = n.parent() {
+		for _, r := range d.nsec3 {
+			if !r.matches(n) {
+				continue
+			}
+			if n == qname {
+				// Name exists, no closest encloser.
+				return "", "", false, false
+			}
+			for _, c := range d.nsec3 {
+				if c.covers(nextCloser) {
+					return n, nextCloser, c.OptOut, true
+				}
+			}
+			return "", "", false, false
+		}
+		if n == root {
+			return "", "", false, false
+		}
+		nextCloser = n
+	}
+}
+
+// nsecClosestEncloser returns the closest encloser deduced from an NSEC record
+// covering qname.
+func nsecClosestEncloser(r nsec, qname name) name {
+	ce := qname.parent()
+	for ce != root && !r.Owner.isSubdomain(ce) && !r.Next.isSubdomain(ce) {
+		ce = ce.parent()
+	}
+	return ce
+}
+
+// nxDomain returns true if the records prove that qname does not exist. The
+// insecure return is set when the proof relies on an opt-out span.
+func (d *denial) nxDomain(qname name) (proven, insecure bool) {
+	qname = qname.lower()
+	for _, r := range d.nsec {
+		if !r.covers(qname) {
+			continue
+		}
+		wc := "\x01*" + nsecClosestEncloser(r, qname)
+		for _, w := range d.nsec {
+			if w.covers(wc) {
+				return true, false
+			}
+		}
+	}
+	if ce, _, optOut, ok := d.closestEncloser(qname); ok {
+		wc := "\x01*" + ce
+		for _, r := range d.nsec3 {
+			if r.covers(wc) {
+				return true, optOut
+			}
+		}
+	}
+	return false, d.insecure
+}
+
+// noData returns true if the records prove that qname exists but has no
+// record of type qtype.
+func (d *denial) noData(qname name, qtype uint16) (proven, insecure bool) {
+	qname = qname.lower()
+	for _, r := range d.nsec {
+		if r.Owner == qname {
+			if typeBitmapHas(r.Bitmap, qtype) || typeBitmapHas(r.Bitmap, typeCNAME) {
+				return false, false
+			}
+			if qtype == typeDS && typeBitmapHas(r.Bitmap, typeSOA) && qname != root {
+				// Child side apex NSEC can't prove DS absence.
+				return false, false
+			}
+			return true, false
+		}
+		if r.covers(qname) {
+			// Wildcard no data.
+			wc := "\x01*" + nsecClosestEncloser(r, qname)
+			for _, w := range d.nsec {
+				if w.Owner == wc && !typeBitmapHas(w.Bitmap, qtype) && !typeBitmapHas(w.Bitmap, typeCNAME) {
+					return true, false
+				}
+			}
+		}
+	}
+	for _, r := range d.nsec3 {
+		if r.matches(qname) {
+			if typeBitmapHas(r.Bitmap, qtype) || typeBitmapHas(r.Bitmap, typeCNAME) {
+				return false, false
+			}
+			if qtype == typeDS && typeBitmapHas(r.Bitmap, typeSOA) && qname != root {
+				return false, false
+			}
+			return true, false
+		}
+	}
+	if ce, _, optOut, ok := d.closestEncloser(qname); ok {
+		if qtype == typeDS && optOut {
+			// Insecure delegation in an opt-out span.
+			return true, true
+		}
+		wc := "\x01*" + ce
+		for _, r := range d.nsec3 {
+			if r.matches(wc) && !typeBitmapHas(r.Bitmap, qtype) && !typeBitmapHas(r.Bitmap, typeCNAME) {
+				return true, false
+			}
+		}
+	}
+	return false, d.insecure
+}
+
+// isDelegation returns true if the records prove that qname is a delegation
+// point (has NS but is not a zone apex).
+func (d *denial) isDelegation(qname name) (delegation, proven bool) {
+	qname = qname.lower()
+	for _, r := range d.nsec {
+		if r.Owner == qname {
+			return typeBitmapHas(r.Bitmap, typeNS) && !typeBitmapHas(r.Bitmap, typeSOA), true
+		}
+	}
+	for _, r := range d.nsec3 {
+		if r.matches(qname) {
+			return typeBitmapHas(r.Bitmap, typeNS) && !typeBitmapHas(r.Bitmap, typeSOA), true
+		}
+	}
+	if _, _, optOut, ok := d.closestEncloser(qname); ok && optOut {
+		return true, true
+	}
+	return false, false
+}
+
+// wildcardExpanded returns true if the records prove that qname does not exist
+// so a wildcard expansion at the given number of labels was legitimate.
+func (d *denial) wildcardExpanded(qname name, labels int) bool {
+	qname = qname.lower()
+	for _, r := range d.nsec {
+		if r.covers(qname) {
+			return true
+		}
+	}
+	nextCloser := qname.suffix(labels + 1)
+	for _, r := range d.nsec3 {
+		if r.covers(nextCloser) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,658 @@
+// Package dnssec implements a DNSSEC validating resolver.Resolver wrapping an
+// upstream resolver.
+package dnssec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/resolver"
+)
+
+type status int
+
+const (
+	statusInsecure status = iota
+	statusSecure
+	statusBogus
+)
+
+func (s status) String() string {
+	switch s {
+	case statusSecure:
+		return "secure"
+	case statusBogus:
+		return "bogus"
+	}
+	return "insecure"
+}
+
+const maxCacheEntries = 10000
+
+// Validator is a resolver.Resolver validating the DNSSEC signatures of the
+// responses returned by Upstream up to the root trust anchors.
+//
+// Queries are sent upstream with the DO and CD bits set. Bogus responses are
+// answered with SERVFAIL, and secure responses have the AD bit set when the
+// client requested it (by setting DO or AD). DNSSEC records are removed from
+// responses if the client did not set the DO bit. Queries with the CD bit set
+// are forwarded untouched.
+type Validator struct {
+	// Upstream is the resolver used to send queries.
+	Upstream resolver.Resolver
+
+	// Anchors specifies the root trust anchors. If nil, the built-in IANA root
+	// anchors are used.
+	Anchors *Anchors
+
+	// ErrorLog specifies an optional log function called when a bogus
+	// response is detected or when the trust anchors can't be updated.
+	ErrorLog func(error)
+
+	mu      sync.Mutex
+	anchors *Anchors
+	keys    map[name]keysEntry
+	names   map[name]statusEntry
+}
+
+type keysEntry struct {
+	keys    []dnskey
+	status  status
+	err     error
+	expires time.Time
+}
+
+type statusEntry struct {
+	status  status
+	err     error
+	expires time.Time
+}
+
+// rrset is a set of records of the same owner, type and class with their
+// signatures.
+type rrset struct {
+	owner name
+	typ   uint16
+	rrs   []rr
+	sigs  []rrsig
+}
+
+// Resolve implements the resolver.Resolver interface.
+func (v *Validator) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	qm, err := parseMessage(q.Payload)
+	if err != nil || qm.Flags&flagCD != 0 {
+		return v.Upstream.Resolve(ctx, q, buf)
+	}
+	clientOPT := qm.opt()
+	clientDO := qm.do()
+	clientAD := qm.Flags&flagAD != 0
+
+	// Forward the query with DO and CD set.
+	uqm := *qm
+	uqm.Flags |= flagCD
+	uqm.Additional = []rr{newOPT(4096, true)}
+	if clientOPT != nil {
+		o := *clientOPT
+		o.Class = 4096
+		o.TTL |= ednsDO
+		uqm.Additional = []rr{o}
+	}
+	uq := q
+	uq.Payload = uqm.pack(65535)
+	rbuf := make([]byte, 65535)
+	if n, i, err = v.Upstream.Resolve(ctx, uq, rbuf); err != nil {
+		return n, i, err
+	}
+	rm, err := parseMessage(rbuf[:n])
+	if err != nil {
+		return -1, i, fmt.Errorf("dnssec: parse response: %v", err)
+	}
+
+	st, err := v.validate(ctx, q, rm)
+	if st == statusBogus {
+		v.logErr(fmt.Errorf("dnssec: %s %s: bogus: %v", q.Name, q.Type, err))
+		sm := &message{
+			ID:    qm.ID,
+			Flags: flagQR | qm.Flags&flagRD | rm.Flags&0x0080 /* RA */ | rcodeServFail,
+		}
+		sm.Question = qm.Question
+		if clientOPT != nil {
+			sm.Additional = []rr{newOPT(4096, clientDO)}
+		}
+		return copy(buf, sm.pack(len(buf))), i, nil
+	}
+
+	rm.ID = qm.ID
+	rm.Flags &^= flagAD | flagCD
+	if st == statusSecure && (clientDO || clientAD) {
+		rm.Flags |= flagAD
+	}
+	if !clientDO {
+		rm.Answer = stripDNSSEC(rm.Answer, qm.Question.Type)
+		rm.Authority = stripDNSSEC(rm.Authority, 0)
+		rm.Additional = stripDNSSEC(rm.Additional, 0)
+	}
+	additional := rm.Additional[:0]
+	for _, r := range rm.Additional {
+		if r.Type == typeOPT {
+			if clientOPT == nil {
+				continue
+			}
+			r.TTL &^= ednsDO
+			if clientDO {
+				r.TTL |= ednsDO
+			}
+		}
+		additional = append(additional, r)
+	}
+	rm.Additional = additional
+	return copy(buf, rm.pack(len(buf))), i, nil
+}
+
+// stripDNSSEC removes the DNSSEC records from rrs, except for records of type
+// keep.
+func stripDNSSEC(rrs []rr, keep uint16) []rr {
+	res := rrs[:0]
+	for _, r := range rrs {
+		switch r.Type {
+		case typeRRSIG, typeNSEC, typeNSEC3:
+			if r.Type != keep {
+				continue
+			}
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// validate returns the DNSSEC status of the response m to q.
+func (v *Validator) validate(ctx context.Context, q resolver.Query, m *message) (status, error) {
+	if rc := m.rcode(); rc != rcodeSuccess && rc != rcodeNXDomain {
+		return statusInsecure, nil
+	}
+	answer := groupRRsets(m.Answer)
+	authority := groupRRsets(m.Authority)
+	hasDNAME := false
+	for _, set := range answer {
+		if set.typ == typeDNAME {
+			hasDNAME = true
+		}
+	}
+
+	result := statusSecure
+	validated := 0
+	var den denial
+	type wildcard struct {
+		owner  name
+		labels int
+	}
+	var wildcards []wildcard
+	for s, sets := range [][]*rrset{answer, authority} {
+		for _, set := range sets {
+			if len(set.sigs) == 0 {
+				if set.typ == typeCNAME && hasDNAME {
+					// Synthesized from a DNAME.
+					continue
+				}
+				if s == 1 && set.typ == typeNS {
+					// Referral NS records are not signed.
+					continue
+				}
+				st, err := v.nameStatus(ctx, q, set.owner)
+				switch st {
+				case statusInsecure:
+					result = statusInsecure
+					continue
+				case statusBogus:
+					return st, err
+				}
+				return statusBogus, fmt.Errorf("missing signature for %s %d", set.owner, set.typ)
+			}
+			st, sig, err := v.verifyRRset(ctx, q, set, "")
+			switch st {
+			case statusBogus:
+				return st, err
+			case statusInsecure:
+				result = statusInsecure
+				continue
+			}
+			validated++
+			den.add(set)
+			if labels := int(sig.Labels); labels < set.owner.labelCount() && set.typ != typeNSEC3 {
+				wildcards = append(wildcards, wildcard{set.owner, labels})
+			}
+		}
+	}
+	if result != statusSecure {
+		return result, nil
+	}
+	if validated == 0 {
+		// Nothing signed, only acceptable in an insecure zone.
+		st, err := v.nameStatus(ctx, q, m.Question.Name.lower())
+		if st == statusSecure {
+			return statusBogus, errors.New("missing signatures")
+		}
+		return st, err
+	}
+	for _, w := range wildcards {
+		if !den.wildcardExpanded(w.owner, w.labels) {
+			return statusBogus, fmt.Errorf("%s: missing wildcard expansion proof", w.owner)
+		}
+	}
+
+	// Follow the CNAME chain to find the name the final answer is for.
+	target := m.Question.Name.lower()
+	qtype := m.Question.Type
+	for hops := 0; hops < 16; hops++ {
+		var next name
+		for _, set := range answer {
+			if set.owner == target && set.typ == typeCNAME && qtype != typeCNAME {
+				if n, _, err := parseName(set.rrs[0].Data, 0); err == nil {
+					next = n.lower()
+				}
+			}
+		}
+		if next == "" {
+			break
+		}
+		target = next
+	}
+	for _, set := range answer {
+		if set.owner == target && (set.typ == qtype || qtype == 255 /* ANY */) {
+			return statusSecure, nil
+		}
+	}
+	var proven, insecure bool
+	if m.rcode() == rcodeNXDomain {
+		proven, insecure = den.nxDomain(target)
+	} else {
+		proven, insecure = den.noData(target, qtype)
+	}
+	switch {
+	case insecure:
+		return statusInsecure, nil
+	case !proven:
+		return statusBogus, fmt.Errorf("%s: missing denial of existence proof", target)
+	}
+	return statusSecure, nil
+}
+
+// groupRRsets groups rrs into RRsets, attaching RRSIG records to the RRset
+// they cover.
+func groupRRsets(rrs []rr) []*rrset {
+	var sets []*rrset
+	find := func(owner name, typ uint16) *rrset {
+		for _, set := range sets {
+			if set.owner == owner && set.typ == typ {
+				return set
+			}
+		}
+		set := &rrset{owner: owner, typ: typ}
+		sets = append(sets, set)
+		return set
+	}
+	var sigs []rr
+	for _, r := range rrs {
+		switch r.Type {
+		case typeRRSIG:
+			sigs = append(sigs, r)
+		case typeOPT:
+		default:
+			set := find(r.Name.lower(), r.Type)
+			set.rrs = append(set.rrs, r)
+		}
+	}
+	for _, r := range sigs {
+		sig, err := parseRRSIG(r)
+		if err != nil {
+			continue
+		}
+		owner := r.Name.lower()
+		for _, set := range sets {
+			if set.owner == owner && set.typ == sig.TypeCovered {
+				set.sigs = append(set.sigs, sig)
+			}
+		}
+	}
+	return sets
+}
+
+// verifyRRset verifies the signatures of set. If above is not empty, only
+// signatures made by a zone strictly above it are considered.
+func (v *Validator) verifyRRset(ctx context.Context, q resolver.Query, set *rrset, above name) (status, rrsig, error) {
+	err := errors.New("no valid signature")
+	supported := false
+	now := time.Now()
+	for _, sig := range set.sigs {
+		if !set.owner.isSubdomain(sig.SignerName) {
+			continue
+		}
+		if above != "" && (sig.SignerName == above || !above.isSubdomain(sig.SignerName)) {
+			continue
+		}
+		if !algorithmSupported(sig.Algorithm) {
+			continue
+		}
+		supported = true
+		if !sig.validAt(now) {
+			err = fmt.Errorf("%s %d: signature expired or not yet valid", set.owner, set.typ)
+			continue
+		}
+		keys, st, kerr := v.zoneKeys(ctx, q, sig.SignerName)
+		switch st {
+		case statusInsecure:
+			return st, sig, nil
+		case statusBogus:
+			err = kerr
+			continue
+		}
+		for _, k := range keys {
+			if verr := verify(sig, k, set.rrs); verr == nil {
+				return statusSecure, sig, nil
+			} else if k.keyTag() == sig.KeyTag {
+				err = fmt.Errorf("%s %d: %v", set.owner, set.typ, verr)
+			}
+		}
+	}
+	if !supported && len(set.sigs) > 0 {
+		// RFC 4035 section 5.2: unsupported algorithms are treated as
+		// insecure.
+		return statusInsecure, rrsig{}, nil
+	}
+	return statusBogus, rrsig{}, err
+}
+
+// zoneKeys returns the validated DNSKEY set of zone.
+func (v *Validator) zoneKeys(ctx context.Context, q resolver.Query, zone name) ([]dnskey, status, error) {
+	v.mu.Lock()
+	e, found := v.keys[zone]
+	v.mu.Unlock()
+	if found && time.Now().Before(e.expires) {
+		return e.keys, e.status, e.err
+	}
+
+	keys, ttl, st, err := v.fetchZoneKeys(ctx, q, zone)
+	if ctx.Err() != nil {
+		// Do not cache errors caused by the query timeout.
+		return keys, st, err
+	}
+	if st == statusBogus || ttl > time.Hour {
+		ttl = time.Hour
+		if st == statusBogus {
+			ttl = 30 * time.Second
+		}
+	} else if ttl < time.Minute {
+		ttl = time.Minute
+	}
+	v.mu.Lock()
+	if v.keys == nil || len(v.keys) > maxCacheEntries {
+		v.keys = map[name]keysEntry{}
+	}
+	v.keys[zone] = keysEntry{keys: keys, status: st, err: err, expires: time.Now().Add(ttl)}
+	v.mu.Unlock()
+	return keys, st, err
+}
+
+func (v *Validator) fetchZoneKeys(ctx context.Context, q resolver.Query, zone name) ([]dnskey, time.Duration, status, error) {
+	var dss []ds
+	if zone != root {
+		var st status
+		var err error
+		if dss, st, err = v.dsRecords(ctx, q, zone); st != statusSecure {
+			return nil, 0, st, err
+		}
+	}
+
+	m, err := v.query(ctx, q, zone, typeDNSKEY)
+	if err != nil {
+		return nil, 0, statusBogus, fmt.Errorf("%s DNSKEY: %v", zone, err)
+	}
+	var set *rrset
+	for _, s := range groupRRsets(m.Answer) {
+		if s.owner == zone && s.typ == typeDNSKEY {
+			set = s
+		}
+	}
+	if set == nil {
+		return nil, 0, statusBogus, fmt.Errorf("%s: no DNSKEY", zone)
+	}
+	var keys []dnskey
+	ttl := time.Duration(set.rrs[0].TTL) * time.Second
+	for _, r := range set.rrs {
+		if k, err := parseDNSKEY(r); err == nil {
+			keys = append(keys, k)
+		}
+	}
+
+	// Find the secure entry points.
+	var seps []dnskey
+	if zone == root {
+		seps = v.rootAnchors().trusted(keys)
+	} else {
+		for _, k := range keys {
+			for _, d := range dss {
+				if d.matches(zone, k) {
+					seps = append(seps, k)
+					break
+				}
+			}
+		}
+	}
+	signs := func(k dnskey) bool {
+		for _, sig := range set.sigs {
+			if sig.SignerName == zone && sig.validAt(time.Now()) && verify(sig, k, set.rrs) == nil {
+				return true
+			}
+		}
+		return false
+	}
+	for _, k := range seps {
+		if signs(k) {
+			if zone == root {
+				if err := v.rootAnchors().update(keys, signs, time.Now()); err != nil {
+					v.logErr(fmt.Errorf("dnssec: update trust anchors: %v", err))
+				}
+			}
+			return keys, ttl, statusSecure, nil
+		}
+	}
+	return nil, 0, statusBogus, fmt.Errorf("%s: DNSKEY set not signed by a trusted key", zone)
+}
+
+// dsRecords returns the validated DS records of zone. If zone is proven to be
+// an insecure delegation, statusInsecure is returned.
+func (v *Validator) dsRecords(ctx context.Context, q resolver.Query, zone name) ([]ds, status, error) {
+	m, err := v.query(ctx, q, zone, typeDS)
+	if err != nil {
+		return nil, statusBogus, fmt.Errorf("%s DS: %v", zone, err)
+	}
+	for _, set := range groupRRsets(m.Answer) {
+		if set.owner != zone || set.typ != typeDS {
+			continue
+		}
+		st, _, err := v.verifyRRset(ctx, q, set, zone)
+		if st != statusSecure {
+			return nil, st, err
+		}
+		var dss []ds
+		for _, r := range set.rrs {
+			if d, err := parseDS(r); err == nil && d.supported() {
+				dss = append(dss, d)
+			}
+		}
+		if len(dss) == 0 {
+			// RFC 4035 section 5.2: no supported DS means insecure.
+			return nil, statusInsecure, nil
+		}
+		return dss, statusSecure, nil
+	}
+
+	den, st, err := v.validateDenial(ctx, q, m, zone)
+	if st != statusSecure {
+		return nil, st, err
+	}
+	if proven, _ := den.noData(zone, typeDS); proven {
+		return nil, statusInsecure, nil
+	}
+	if den.insecure {
+		return nil, statusInsecure, nil
+	}
+	return nil, statusBogus, fmt.Errorf("%s: missing DS denial of existence proof", zone)
+}
+
+// validateDenial validates the NSEC and NSEC3 records found in the authority
+// section of m, a response to a query for n. If no record is signed, the
+// status of the parent of n is returned.
+func (v *Validator) validateDenial(ctx context.Context, q resolver.Query, m *message, n name) (denial, status, error) {
+	var den denial
+	signed := false
+	for _, set := range groupRRsets(m.Authority) {
+		if set.typ != typeNSEC && set.typ != typeNSEC3 {
+			continue
+		}
+		if len(set.sigs) == 0 {
+			continue
+		}
+		signed = true
+		st, _, err := v.verifyRRset(ctx, q, set, n)
+		if st != statusSecure {
+			return den, st, err
+		}
+		den.add(set)
+	}
+	if !signed {
+		st, err := v.nameStatus(ctx, q, n.parent())
+		if st == statusSecure {
+			return den, statusBogus, fmt.Errorf("%s: missing denial of existence", n)
+		}
+		return den, st, err
+	}
+	return den, statusSecure, nil
+}
+
+// nameStatus returns the status of the zone n belongs to by walking the
+// delegations from the root.
+func (v *Validator) nameStatus(ctx context.Context, q resolver.Query, n name) (status, error) {
+	if n == root {
+		return statusSecure, nil
+	}
+	v.mu.Lock()
+	e, found := v.names[n]
+	v.mu.Unlock()
+	if found && time.Now().Before(e.expires) {
+		return e.status, e.err
+	}
+
+	st, err := v.nameStatus(ctx, q, n.parent())
+	if st == statusSecure {
+		st, err = v.delegationStatus(ctx, q, n)
+	}
+	if ctx.Err() != nil {
+		return st, err
+	}
+	ttl := 10 * time.Minute
+	if st == statusBogus {
+		ttl = 30 * time.Second
+	}
+	v.mu.Lock()
+	if v.names == nil || len(v.names) > maxCacheEntries {
+		v.names = map[name]statusEntry{}
+	}
+	v.names[n] = statusEntry{status: st, err: err, expires: time.Now().Add(ttl)}
+	v.mu.Unlock()
+	return st, err
+}
+
+// delegationStatus returns the status of n given its parent is secure.
+func (v *Validator) delegationStatus(ctx context.Context, q resolver.Query, n name) (status, error) {
+	m, err := v.query(ctx, q, n, typeDS)
+	if err != nil {
+		return statusBogus, fmt.Errorf("%s DS: %v", n, err)
+	}
+	for _, set := range groupRRsets(m.Answer) {
+		if set.owner == n && set.typ == typeDS {
+			st, _, err := v.verifyRRset(ctx, q, set, n)
+			return st, err
+		}
+	}
+	den, st, err := v.validateDenial(ctx, q, m, n)
+	if st != statusSecure {
+		return st, err
+	}
+	if m.rcode() == rcodeNXDomain {
+		// The name does not exist in a secure zone.
+		return statusSecure, nil
+	}
+	delegation, proven := den.isDelegation(n)
+	switch {
+	case proven && delegation:
+		if noDS, _ := den.noData(n, typeDS); noDS {
+			return statusInsecure, nil
+		}
+		return statusBogus, fmt.Errorf("%s: delegation without DS proof", n)
+	case proven:
+		return statusSecure, nil
+	case den.insecure:
+		return statusInsecure, nil
+	}
+	// Empty non terminal or a name covered by a denial.
+	if noData, _ := den.noData(n, typeDS); noData {
+		return statusSecure, nil
+	}
+	return statusBogus, fmt.Errorf("%s: missing DS denial of existence proof", n)
+}
+
+// query sends a DNSSEC query for qname/qtype to the upstream resolver, using
+// the client information of q.
+func (v *Validator) query(ctx context.Context, q resolver.Query, qname name, qtype uint16) (*message, error) {
+	id := uint16(rand.Uint32())
+	typ := "DS"
+	if qtype == typeDNSKEY {
+		typ = "DNSKEY"
+	}
+	sq := resolver.Query{
+		Type:    typ,
+		Name:    qname.String(),
+		PeerIP:  q.PeerIP,
+		MAC:     q.MAC,
+		Payload: newQuery(id, qname, qtype),
+	}
+	buf := make([]byte, 65535)
+	n, _, err := v.Upstream.Resolve(ctx, sq, buf)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseMessage(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	if m.ID != id {
+		return nil, errors.New("id mismatch")
+	}
+	if rc := m.rcode(); rc != rcodeSuccess && rc != rcodeNXDomain {
+		return nil, fmt.Errorf("rcode %d", rc)
+	}
+	return m, nil
+}
+
+func (v *Validator) rootAnchors() *Anchors {
+	if v.Anchors != nil {
+		return v.Anchors
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.anchors == nil {
+		v.anchors = &Anchors{}
+	}
+	return v.anchors
+}
+
+func (v *Validator) logErr(err error) {
+	if err != nil && v.ErrorLog != nil {
+		v.ErrorLog(err)
+	}
+}
@@ -0,0 +1,224 @@
+package dnssec
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/resolver"
+)
+
+func mkName(s string) name {
+	s = strings.TrimSuffix(s, ".")
+	if s == "" {
+		return root
+	}
+	var b []byte
+	for _, l := range strings.Split(s, ".") {
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	return name(append(b, 0))
+}
+
+func Test_dnskey_keyTag(t *testing.T) {
+	// KSK-2017
+	key, _ := base64.StdEncoding.DecodeString("AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU=")
+	k, err := parseDNSKEY(rr{Data: append([]byte{1, 1, 3, 8}, key...)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := k.keyTag(), uint16(20326); got != want {
+		t.Errorf("keyTag() = %v, want %v", got, want)
+	}
+	if !defaultAnchors[0].matches(k) {
+		t.Errorf("KSK-2017 does not match its anchor")
+	}
+	if defaultAnchors[1].matches(k) {
+		t.Errorf("KSK-2017 matches KSK-2024 anchor")
+	}
+}
+
+func Test_nsec3Hash(t *testing.T) {
+	// RFC 5155 appendix A.
+	salt, _ := hex.DecodeString("aabbccdd")
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"example.", "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom"},
+		{"a.example.", "35mthgpgcu1qg68fab165klnsnk3dpvl"},
+		{"ns1.example.", "2t7b4g4vsa5smi47k61mv5bv1a22bojr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.ToLower(b32.EncodeToString(nsec3Hash(mkName(tt.name), salt, 12)))
+			if got != tt.want {
+				t.Errorf("nsec3Hash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_canonicalCompare(t *testing.T) {
+	// RFC 4034 section 6.1.
+	names := []name{
+		mkName("example."),
+		mkName("a.example."),
+		mkName("yljkjljk.a.example."),
+		mkName("Z.a.example."),
+		mkName("zABC.a.EXAMPLE."),
+		mkName("z.example."),
+		mkName("\x01.z.example."),
+		mkName("*.z.example."),
+		mkName("\xc8.z.example."),
+	}
+	for i := 1; i < len(names); i++ {
+		if canonicalCompare(names[i-1], names[i]) >= 0 {
+			t.Errorf("canonicalCompare(%q, %q) >= 0", names[i-1], names[i])
+		}
+		if canonicalCompare(names[i], names[i-1]) <= 0 {
+			t.Errorf("canonicalCompare(%q, %q) <= 0", names[i], names[i-1])
+		}
+	}
+}
+
+func pad32(i *big.Int) []byte {
+	b := i.Bytes()
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+type testZone struct {
+	name name
+	key  *ecdsa.PrivateKey
+	rr   rr
+}
+
+func newTestZone(t *testing.T, n string) *testZone {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte{1, 1, 3, algECDSAP256SHA256}
+	data = append(data, pad32(key.X)...)
+	data = append(data, pad32(key.Y)...)
+	z := &testZone{name: mkName(n), key: key}
+	z.rr = rr{Name: z.name, Type: typeDNSKEY, Class: classINET, TTL: 3600, Data: data}
+	return z
+}
+
+func (z *testZone) ds() rr {
+	k, _ := parseDNSKEY(z.rr)
+	h := sha256.Sum256(append([]byte(z.name), k.rdata...))
+	data := appendUint16(nil, k.keyTag())
+	data = append(data, algECDSAP256SHA256, digestSHA256)
+	return rr{Name: z.name, Type: typeDS, Class: classINET, TTL: 3600, Data: append(data, h[:]...)}
+}
+
+func (z *testZone) sign(t *testing.T, rrs ...rr) []rr {
+	k, _ := parseDNSKEY(z.rr)
+	now := uint32(time.Now().Unix())
+	data := appendUint16(nil, rrs[0].Type)
+	data = append(data, algECDSAP256SHA256, byte(rrs[0].Name.labelCount()))
+	data = appendUint32(data, rrs[0].TTL)
+	data = appendUint32(data, now+3600)
+	data = appendUint32(data, now-3600)
+	data = appendUint16(data, k.keyTag())
+	data = append(data, z.name...)
+	sig, _ := parseRRSIG(rr{Type: typeRRSIG, Data: append(data, 0)})
+	signed, err := signedData(sig, rrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(signed)
+	r, s, err := ecdsa.Sign(rand.Reader, z.key, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, pad32(r)...)
+	data = append(data, pad32(s)...)
+	return append(rrs, rr{Name: rrs[0].Name, Type: typeRRSIG, Class: classINET, TTL: rrs[0].TTL, Data: data})
+}
+
+type testUpstream map[string][]rr
+
+func (u testUpstream) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	m, err := parseMessage(q.Payload)
+	if err != nil {
+		return -1, resolver.ResolveInfo{}, err
+	}
+	m.Flags |= flagQR
+	m.Answer = u[fmt.Sprintf("%s %d", m.Question.Name, m.Question.Type)]
+	return copy(buf, m.pack(len(buf))), resolver.ResolveInfo{}, nil
+}
+
+func Test_Validator_Resolve(t *testing.T) {
+	rootZone := newTestZone(t, ".")
+	exampleZone := newTestZone(t, "example.")
+	rk, _ := parseDNSKEY(rootZone.rr)
+	www := mkName("www.example.")
+	a := rr{Name: www, Type: 1, Class: classINET, TTL: 300, Data: []byte{192, 0, 2, 1}}
+	goodA := exampleZone.sign(t, a)
+	badA := append([]rr{{Name: www, Type: 1, Class: classINET, TTL: 300, Data: []byte{192, 0, 2, 2}}}, goodA[1])
+	upstream := testUpstream{
+		". 48":        rootZone.sign(t, rootZone.rr),
+		"example. 43": rootZone.sign(t, exampleZone.ds()),
+		"example. 48": exampleZone.sign(t, exampleZone.rr),
+	}
+
+	tests := []struct {
+		name      string
+		answer    []rr
+		wantRcode int
+		wantAD    bool
+	}{
+		{"secure", goodA, rcodeSuccess, true},
+		{"bogus", badA, rcodeServFail, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream["www.example. 1"] = tt.answer
+			v := &Validator{
+				Upstream: upstream,
+				Anchors:  &Anchors{anchors: []anchor{{State: stateValid, Key: rk.rdata}}, loaded: true},
+			}
+			qm := &message{ID: 1234, Flags: flagRD | flagAD}
+			qm.Question.Name = www
+			qm.Question.Type = 1
+			qm.Question.Class = classINET
+			buf := make([]byte, 512)
+			n, _, err := v.Resolve(context.Background(), resolver.Query{Payload: qm.pack(512)}, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := parseMessage(buf[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.rcode(); got != tt.wantRcode {
+				t.Errorf("rcode = %v, want %v", got, tt.wantRcode)
+			}
+			if got := m.Flags&flagAD != 0; got != tt.wantAD {
+				t.Errorf("AD = %v, want %v", got, tt.wantAD)
+			}
+			if got := binary.BigEndian.Uint16(buf); got != 1234 {
+				t.Errorf("ID = %v, want 1234", got)
+			}
+			for _, r := range m.Answer {
+				if r.Type == typeRRSIG {
+					t.Errorf("RRSIG returned to a non DO client")
+				}
+			}
+		})
+	}
+}
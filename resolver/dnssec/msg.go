@@ -0,0 +1,449 @@
+package dnssec
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+const (
+	typeCNAME      = 5
+	typeNS         = 2
+	typeSOA        = 6
+	typePTR        = 12
+	typeMX         = 15
+	typeSRV        = 33
+	typeDNAME      = 39
+	typeOPT        = 41
+	typeDS         = 43
+	typeRRSIG      = 46
+	typeNSEC       = 47
+	typeDNSKEY     = 48
+	typeNSEC3      = 50
+	typeNSEC3PARAM = 51
+
+	classINET = 1
+
+	rcodeSuccess  = 0
+	rcodeServFail = 2
+	rcodeNXDomain = 3
+
+	flagQR = 0x8000
+	flagTC = 0x0200
+	flagRD = 0x0100
+	flagAD = 0x0020
+	flagCD = 0x0010
+
+	ednsDO = 0x8000
+)
+
+var errMalformed = errors.New("malformed message")
+
+// name is a domain name in uncompressed wire format. Names used for
+// comparison are always lower cased.
+type name string
+
+const root name = "\x00"
+
+// String returns the presentation format of n.
+func (n name) String() string {
+	if n == root {
+		return "."
+	}
+	var sb strings.Builder
+	for _, l := range n.labels() {
+		sb.WriteString(l)
+		sb.WriteByte('.')
+	}
+	return sb.String()
+}
+
+// labels returns the labels of n, from left to right.
+func (n name) labels() []string {
+	var labels []string
+	for i := 0; i < len(n) && n[i] != 0; i += int(n[i]) + 1 {
+		labels = append(labels, string(n[i+1:i+1+int(n[i])]))
+	}
+	return labels
+}
+
+// labelCount returns the number of labels in n, not counting the root label
+// nor a leading wildcard label as defined for the RRSIG labels field.
+func (n name) labelCount() int {
+	c := len(n.labels())
+	if n.isWildcard() {
+		c--
+	}
+	return c
+}
+
+func (n name) isWildcard() bool {
+	return len(n) >= 2 && n[0] == 1 && n[1] == '*'
+}
+
+// parent returns n minus its left most label.
+func (n name) parent() name {
+	if n == root || len(n) == 0 {
+		return root
+	}
+	return n[int(n[0])+1:]
+}
+
+// suffix returns the last count labels of n.
+func (n name) suffix(count int) name {
+	for len(n.labels()) > count {
+		n = n.parent()
+	}
+	return n
+}
+
+// isSubdomain returns true if n is equal to or a sub domain of zone.
+func (n name) isSubdomain(zone name) bool {
+	return strings.HasSuffix(string(n), string(zone)) &&
+		(len(n) == len(zone) || isLabelBoundary(n, len(n)-len(zone)))
+}
+
+func isLabelBoundary(n name, off int) bool {
+	for i := 0; i < len(n); i += int(n[i]) + 1 {
+		if i == off {
+			return true
+		}
+		if n[i] == 0 {
+			break
+		}
+	}
+	return false
+}
+
+func (n name) lower() name {
+	b := []byte(n)
+	for i := 0; i < len(b) && b[i] != 0; i += int(b[i]) + 1 {
+		for j := i + 1; j <= i+int(b[i]); j++ {
+			if b[j] >= 'A' && b[j] <= 'Z' {
+				b[j] += 'a' - 'A'
+			}
+		}
+	}
+	return name(b)
+}
+
+// parseName parses a wire format name from msg at off, following compression
+// pointers, and returns the offset following the name.
+func parseName(msg []byte, off int) (name, int, error) {
+	var b []byte
+	next := -1
+	ptrs := 0
+	for {
+		if off >= len(msg) {
+			return "", 0, errMalformed
+		}
+		c := int(msg[off])
+		switch c & 0xc0 {
+		case 0x00:
+			if c == 0 {
+				b = append(b, 0)
+				if next < 0 {
+					next = off + 1
+				}
+				if len(b) > 255 {
+					return "", 0, errMalformed
+				}
+				return name(b), next, nil
+			}
+			if off+1+c > len(msg) {
+				return "", 0, errMalformed
+			}
+			b = append(b, msg[off:off+1+c]...)
+			off += 1 + c
+		case 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			if ptrs++; ptrs > 64 {
+				return "", 0, errMalformed
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		default:
+			return "", 0, errMalformed
+		}
+	}
+}
+
+// rr is a resource record with its rdata expanded so it does not reference the
+// original message anymore.
+type rr struct {
+	Name  name // as received
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte // uncompressed, case preserved
+}
+
+// message is a parsed DNS message.
+type message struct {
+	ID       uint16
+	Flags    uint16
+	Question struct {
+		Name  name
+		Type  uint16
+		Class uint16
+	}
+	Answer     []rr
+	Authority  []rr
+	Additional []rr
+}
+
+func (m *message) rcode() int {
+	return int(m.Flags & 0xf)
+}
+
+// opt returns the EDNS0 OPT record if any.
+func (m *message) opt() *rr {
+	for i := range m.Additional {
+		if m.Additional[i].Type == typeOPT {
+			return &m.Additional[i]
+		}
+	}
+	return nil
+}
+
+// do returns true if the DO bit is set in the OPT record.
+func (m *message) do() bool {
+	if o := m.opt(); o != nil {
+		return o.TTL&ednsDO != 0
+	}
+	return false
+}
+
+func parseMessage(msg []byte) (*message, error) {
+	if len(msg) < 12 {
+		return nil, errMalformed
+	}
+	m := &message{
+		ID:    binary.BigEndian.Uint16(msg[0:]),
+		Flags: binary.BigEndian.Uint16(msg[2:]),
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	counts := [3]int{
+		int(binary.BigEndian.Uint16(msg[6:])),
+		int(binary.BigEndian.Uint16(msg[8:])),
+		int(binary.BigEndian.Uint16(msg[10:])),
+	}
+	off := 12
+	if qd != 1 {
+		return nil, errors.New("unsupported question count")
+	}
+	var err error
+	if m.Question.Name, off, err = parseName(msg, off); err != nil {
+		return nil, err
+	}
+	if off+4 > len(msg) {
+		return nil, errMalformed
+	}
+	m.Question.Type = binary.BigEndian.Uint16(msg[off:])
+	m.Question.Class = binary.BigEndian.Uint16(msg[off+2:])
+	off += 4
+	sections := [3]*[]rr{&m.Answer, &m.Authority, &m.Additional}
+	for s, count := range counts {
+		for i := 0; i < count; i++ {
+			var r rr
+			if r, off, err = parseRR(msg, off); err != nil {
+				return nil, err
+			}
+			*sections[s] = append(*sections[s], r)
+		}
+	}
+	return m, nil
+}
+
+func parseRR(msg []byte, off int) (r rr, next int, err error) {
+	if r.Name, off, err = parseName(msg, off); err != nil {
+		return
+	}
+	if off+10 > len(msg) {
+		return r, 0, errMalformed
+	}
+	r.Type = binary.BigEndian.Uint16(msg[off:])
+	r.Class = binary.BigEndian.Uint16(msg[off+2:])
+	r.TTL = binary.BigEndian.Uint32(msg[off+4:])
+	l := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if off+l > len(msg) {
+		return r, 0, errMalformed
+	}
+	if r.Data, err = expandRData(msg, off, l, r.Type); err != nil {
+		return
+	}
+	return r, off + l, nil
+}
+
+// expandRData returns a copy of the rdata at off with the compressed names
+// (only allowed in well known types) expanded.
+func expandRData(msg []byte, off, l int, typ uint16) ([]byte, error) {
+	end := off + l
+	var prefix, names int
+	switch typ {
+	case typeNS, typeCNAME, typePTR, typeDNAME:
+		names = 1
+	case typeMX:
+		prefix, names = 2, 1
+	case typeSRV:
+		prefix, names = 6, 1
+	case typeSOA:
+		names = 2
+	default:
+		return append([]byte(nil), msg[off:end]...), nil
+	}
+	if off+prefix > end {
+		return nil, errMalformed
+	}
+	data := append([]byte(nil), msg[off:off+prefix]...)
+	off += prefix
+	for i := 0; i < names; i++ {
+		n, next, err := parseName(msg[:end], off)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, n...)
+		off = next
+	}
+	return append(data, msg[off:end]...), nil
+}
+
+// canonicalRData returns the RFC 4034 section 6.2 canonical form of r's rdata.
+func canonicalRData(r rr) []byte {
+	var prefix, names int
+	switch r.Type {
+	case typeNS, typeCNAME, typePTR, typeDNAME:
+		names = 1
+	case typeMX:
+		prefix, names = 2, 1
+	case typeSRV:
+		prefix, names = 6, 1
+	case typeSOA:
+		names = 2
+	case typeRRSIG:
+		prefix, names = 18, 1
+	default:
+		return r.Data
+	}
+	if len(r.Data) < prefix {
+		return r.Data
+	}
+	data := append([]byte(nil), r.Data[:prefix]...)
+	off := prefix
+	for i := 0; i < names; i++ {
+		n, next, err := parseName(r.Data, off)
+		if err != nil {
+			return r.Data
+		}
+		data = append(data, n.lower()...)
+		off = next
+	}
+	return append(data, r.Data[off:]...)
+}
+
+// packRR appends the wire format of r to b, uncompressed.
+func packRR(b []byte, r rr) []byte {
+	b = append(b, r.Name...)
+	b = appendUint16(b, r.Type)
+	b = appendUint16(b, r.Class)
+	b = appendUint32(b, r.TTL)
+	b = appendUint16(b, uint16(len(r.Data)))
+	return append(b, r.Data...)
+}
+
+// pack returns the uncompressed wire format of m. If the message does not fit
+// in max, only the header and question are returned with the TC bit set.
+func (m *message) pack(max int) []byte {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	binary.BigEndian.PutUint16(b[2:], m.Flags)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	b = append(b, m.Question.Name...)
+	b = appendUint16(b, m.Question.Type)
+	b = appendUint16(b, m.Question.Class)
+	hdrLen := len(b)
+	var opt *rr
+	for s, rrs := range [][]rr{m.Answer, m.Authority, m.Additional} {
+		count := 0
+		for _, r := range rrs {
+			if r.Type == typeOPT {
+				o := r
+				opt = &o
+				continue
+			}
+			b = packRR(b, r)
+			count++
+		}
+		binary.BigEndian.PutUint16(b[6+s*2:], uint16(count))
+	}
+	if opt != nil {
+		b = packRR(b, *opt)
+		binary.BigEndian.PutUint16(b[10:], binary.BigEndian.Uint16(b[10:])+1)
+	}
+	if len(b) > max {
+		b = b[:hdrLen]
+		binary.BigEndian.PutUint16(b[2:], m.Flags|flagTC)
+		for i := 6; i < 12; i++ {
+			b[i] = 0
+		}
+		if opt != nil && len(b)+11+len(opt.Data) <= max {
+			// Keep the OPT record so the client knows it can retry with a
+			// larger buffer.
+			b = packRR(b, *opt)
+			binary.BigEndian.PutUint16(b[10:], 1)
+		}
+	}
+	return b
+}
+
+// newQuery returns a query for qname/qtype with the DO and CD bits set.
+func newQuery(id uint16, qname name, qtype uint16) []byte {
+	m := &message{
+		ID:    id,
+		Flags: flagRD | flagCD,
+	}
+	m.Question.Name = qname
+	m.Question.Type = qtype
+	m.Question.Class = classINET
+	m.Additional = []rr{newOPT(4096, true)}
+	return m.pack(65535)
+}
+
+func newOPT(udpSize uint16, do bool) rr {
+	r := rr{Name: root, Type: typeOPT, Class: udpSize}
+	if do {
+		r.TTL = ednsDO
+	}
+	return r
+}
+
+// typeBitmapHas returns true if typ is present in the NSEC/NSEC3 type bitmap.
+func typeBitmapHas(bitmap []byte, typ uint16) bool {
+	window := byte(typ >> 8)
+	bit := byte(typ & 0xff)
+	for len(bitmap) >= 2 {
+		w, l := bitmap[0], int(bitmap[1])
+		if l > 32 || len(bitmap) < 2+l {
+			return false
+		}
+		if w == window {
+			idx := int(bit / 8)
+			return idx < l && bitmap[2+idx]&(0x80>>(bit%8)) != 0
+		}
+		bitmap = bitmap[2+l:]
+	}
+	return false
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,328 @@
+package dnssec
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// DNSSEC algorithm numbers.
+const (
+	algRSASHA1         = 5
+	algRSASHA1NSEC3    = 7
+	algRSASHA256       = 8
+	algRSASHA512       = 10
+	algECDSAP256SHA256 = 13
+	algECDSAP384SHA384 = 14
+	algED25519         = 15
+)
+
+// DS digest types.
+const (
+	digestSHA1   = 1
+	digestSHA256 = 2
+	digestSHA384 = 4
+)
+
+// DNSKEY flags.
+const (
+	flagZone   = 0x0100
+	flagRevoke = 0x0080
+	flagSEP    = 0x0001
+)
+
+var errUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+// rrsig is a parsed RRSIG record.
+type rrsig struct {
+	TypeCovered uint16
+	Algorithm   uint8
+	Labels      uint8
+	OrigTTL     uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  name // lower cased
+	Signature   []byte
+	rdata       []byte // rdata minus signature, canonical form
+}
+
+func parseRRSIG(r rr) (rrsig, error) {
+	var s rrsig
+	d := r.Data
+	if len(d) < 19 {
+		return s, errMalformed
+	}
+	s.TypeCovered = binary.BigEndian.Uint16(d[0:])
+	s.Algorithm = d[2]
+	s.Labels = d[3]
+	s.OrigTTL = binary.BigEndian.Uint32(d[4:])
+	s.Expiration = binary.BigEndian.Uint32(d[8:])
+	s.Inception = binary.BigEndian.Uint32(d[12:])
+	s.KeyTag = binary.BigEndian.Uint16(d[16:])
+	n, off, err := parseName(d, 18)
+	if err != nil {
+		return s, err
+	}
+	s.SignerName = n.lower()
+	s.Signature = d[off:]
+	s.rdata = append(append([]byte(nil), d[:18]...), s.SignerName...)
+	return s, nil
+}
+
+// validAt returns true if t is within the signature validity period, using
+// RFC 1982 serial number arithmetic.
+func (s rrsig) validAt(t time.Time) bool {
+	const year68 = 1 << 31
+	utc := t.UTC().Unix()
+	modi := (int64(s.Inception) - utc) / year68
+	mode := (int64(s.Expiration) - utc) / year68
+	ti := int64(s.Inception) + modi*year68
+	te := int64(s.Expiration) + mode*year68
+	return ti <= utc && utc <= te
+}
+
+// dnskey is a parsed DNSKEY record.
+type dnskey struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+	rdata     []byte
+}
+
+func parseDNSKEY(r rr) (dnskey, error) {
+	d := r.Data
+	if len(d) < 4 {
+		return dnskey{}, errMalformed
+	}
+	return dnskey{
+		Flags:     binary.BigEndian.Uint16(d[0:]),
+		Protocol:  d[2],
+		Algorithm: d[3],
+		PublicKey: d[4:],
+		rdata:     d,
+	}, nil
+}
+
+// keyTag computes the RFC 4034 appendix B key tag.
+func (k dnskey) keyTag() uint16 {
+	var ac uint32
+	for i, b := range k.rdata {
+		if i&1 == 1 {
+			ac += uint32(b)
+		} else {
+			ac += uint32(b) << 8
+		}
+	}
+	ac += ac >> 16 & 0xffff
+	return uint16(ac & 0xffff)
+}
+
+// ds is a parsed DS record.
+type ds struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+func parseDS(r rr) (ds, error) {
+	d := r.Data
+	if len(d) < 5 {
+		return ds{}, errMalformed
+	}
+	return ds{
+		KeyTag:     binary.BigEndian.Uint16(d[0:]),
+		Algorithm:  d[2],
+		DigestType: d[3],
+		Digest:     d[4:],
+	}, nil
+}
+
+// supported returns true if both the algorithm and digest type of d are
+// supported.
+func (d ds) supported() bool {
+	switch d.DigestType {
+	case digestSHA1, digestSHA256, digestSHA384:
+	default:
+		return false
+	}
+	return algorithmSupported(d.Algorithm)
+}
+
+// matches returns true if d is a digest of k owned by owner.
+func (d ds) matches(owner name, k dnskey) bool {
+	if d.KeyTag != k.keyTag() || d.Algorithm != k.Algorithm {
+		return false
+	}
+	var h []byte
+	data := append([]byte(owner.lower()), k.rdata...)
+	switch d.DigestType {
+	case digestSHA1:
+		s := sha1.Sum(data)
+		h = s[:]
+	case digestSHA256:
+		s := sha256.Sum256(data)
+		h = s[:]
+	case digestSHA384:
+		s := sha512.Sum384(data)
+		h = s[:]
+	default:
+		return false
+	}
+	return bytes.Equal(h, d.Digest)
+}
+
+func algorithmSupported(alg uint8) bool {
+	switch alg {
+	case algRSASHA1, algRSASHA1NSEC3, algRSASHA256, algRSASHA512,
+		algECDSAP256SHA256, algECDSAP384SHA384, algED25519:
+		return true
+	}
+	return false
+}
+
+// signedData returns the data covered by sig for rrset as defined in RFC 4034
+// section 3.1.8.1.
+func signedData(sig rrsig, rrset []rr) ([]byte, error) {
+	if len(rrset) == 0 {
+		return nil, errors.New("empty rrset")
+	}
+	owner := rrset[0].Name.lower()
+	if labels := owner.labelCount(); int(sig.Labels) < labels {
+		// Wildcard expansion.
+		owner = "\x01*" + owner.suffix(int(sig.Labels))
+	} else if int(sig.Labels) > labels {
+		return nil, errors.New("invalid rrsig labels")
+	}
+	rdatas := make([][]byte, 0, len(rrset))
+	for _, r := range rrset {
+		rdatas = append(rdatas, canonicalRData(r))
+	}
+	sort.Slice(rdatas, func(i, j int) bool {
+		return bytes.Compare(rdatas[i], rdatas[j]) < 0
+	})
+	data := append([]byte(nil), sig.rdata...)
+	for i, rd := range rdatas {
+		if i > 0 && bytes.Equal(rd, rdatas[i-1]) {
+			continue // duplicate
+		}
+		data = append(data, owner...)
+		data = appendUint16(data, rrset[0].Type)
+		data = appendUint16(data, rrset[0].Class)
+		data = appendUint32(data, sig.OrigTTL)
+		data = appendUint16(data, uint16(len(rd)))
+		data = append(data, rd...)
+	}
+	return data, nil
+}
+
+// verify checks that sig is a valid signature of rrset by key k.
+func verify(sig rrsig, k dnskey, rrset []rr) error {
+	if sig.Algorithm != k.Algorithm || sig.KeyTag != k.keyTag() {
+		return errors.New("key mismatch")
+	}
+	if k.Flags&flagZone == 0 || k.Protocol != 3 {
+		return errors.New("not a zone key")
+	}
+	if k.Flags&flagRevoke != 0 && sig.TypeCovered != typeDNSKEY {
+		return errors.New("revoked key")
+	}
+	data, err := signedData(sig, rrset)
+	if err != nil {
+		return err
+	}
+	return verifySignature(k.Algorithm, k.PublicKey, data, sig.Signature)
+}
+
+func verifySignature(alg uint8, key, data, sig []byte) error {
+	switch alg {
+	case algRSASHA1, algRSASHA1NSEC3, algRSASHA256, algRSASHA512:
+		pub, err := parseRSAKey(key)
+		if err != nil {
+			return err
+		}
+		var h crypto.Hash
+		switch alg {
+		case algRSASHA256:
+			h = crypto.SHA256
+		case algRSASHA512:
+			h = crypto.SHA512
+		default:
+			h = crypto.SHA1
+		}
+		hh := h.New()
+		hh.Write(data)
+		return rsa.VerifyPKCS1v15(pub, h, hh.Sum(nil), sig)
+	case algECDSAP256SHA256, algECDSAP384SHA384:
+		curve, h := elliptic.P256(), crypto.SHA256
+		if alg == algECDSAP384SHA384 {
+			curve, h = elliptic.P384(), crypto.SHA384
+		}
+		size := curve.Params().BitSize / 8
+		if len(key) != 2*size || len(sig) != 2*size {
+			return errMalformed
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(key[:size]),
+			Y:     new(big.Int).SetBytes(key[size:]),
+		}
+		hh := h.New()
+		hh.Write(data)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, hh.Sum(nil), r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case algED25519:
+		if len(key) != ed25519.PublicKeySize {
+			return errMalformed
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %d", errUnsupportedAlgorithm, alg)
+}
+
+func parseRSAKey(key []byte) (*rsa.PublicKey, error) {
+	if len(key) < 1 {
+		return nil, errMalformed
+	}
+	explen := int(key[0])
+	key = key[1:]
+	if explen == 0 {
+		if len(key) < 2 {
+			return nil, errMalformed
+		}
+		explen = int(binary.BigEndian.Uint16(key))
+		key = key[2:]
+	}
+	if explen > 4 || explen == 0 || len(key) <= explen {
+		return nil, errors.New("unsupported rsa exponent")
+	}
+	var e int
+	for _, b := range key[:explen] {
+		e = e<<8 | int(b)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(key[explen:]),
+		E: e,
+	}, nil
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
+
+	"github.com/nextdns/nextdns/resolver/endpoint"
 )
 
 type ClientInfo struct {
@@ -45,7 +48,9 @@ func (r DOH) resolve(ctx context.Context, q Query, buf []byte, rt http.RoundTrip
 	if url == "" {
 		url = "https://0.0.0.0"
 	}
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(q.Payload))
+	Tracef(ctx, "upstream", "DoH URL %s", url)
+	var st stageTrace
+	req, err := http.NewRequestWithContext(st.withTrace(ctx), "POST", url, bytes.NewReader(q.Payload))
 	if err != nil {
 		return -1, i, err
 	}
@@ -69,15 +74,21 @@ func (r DOH) resolve(ctx context.Context, q Query, buf []byte, rt http.RoundTrip
 		rt = http.DefaultTransport
 	}
 	res, err := rt.RoundTrip(req)
+	i.Timing = st.Timing()
 	if err != nil {
-		return -1, i, err
+		return -1, i, st.err(err)
 	}
 	defer res.Body.Close()
 	i.Transport = res.Proto
 	if res.StatusCode != http.StatusOK {
-		return -1, i, fmt.Errorf("error code: %d", res.StatusCode)
+		return -1, i, endpoint.StatusError{StatusCode: res.StatusCode}
 	}
+	start := time.Now()
 	n, err := readDNSResponse(res.Body, buf)
+	i.Timing.Read = time.Since(start)
+	if err != nil {
+		err = fmt.Errorf("read: %w", err)
+	}
 	return n, i, err
 }
 
@@ -0,0 +1,50 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDOH_timing(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(query)
+	}))
+	defer s.Close()
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		wantErr  string
+		wantWait time.Duration
+	}{
+		{"answered", time.Second, "", 50 * time.Millisecond},
+		{"timeout waiting", 20 * time.Millisecond, "wait: ", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			r := DOH{URL: s.URL}
+			buf := make([]byte, 512)
+			_, i, err := r.resolve(ctx, Query{Payload: query}, buf, http.DefaultTransport)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) || !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("err = %v, want %s...deadline exceeded", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i.Timing.Wait < tt.wantWait {
+				t.Errorf("Timing.Wait = %v, want >= %v", i.Timing.Wait, tt.wantWait)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package endpoint
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// StatusError is returned when a DoH server responds with a non 200 status.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("status: %d", e.StatusCode)
+}
+
+// IsCaptivePortalError returns true if err is typical of a captive portal
+// intercepting connections: invalid certificate, non TLS response or HTTP
+// redirect.
+func IsCaptivePortalError(err error) bool {
+	var (
+		unknownAuthority   x509.UnknownAuthorityError
+		hostname           x509.HostnameError
+		certificateInvalid x509.CertificateInvalidError
+		recordHeader       tls.RecordHeaderError
+		status             StatusError
+	)
+	switch {
+	case errors.As(err, &unknownAuthority),
+		errors.As(err, &hostname),
+		errors.As(err, &certificateInvalid),
+		errors.As(err, &recordHeader):
+		return true
+	case errors.As(err, &status):
+		return status.StatusCode >= 300 && status.StatusCode < 400
+	}
+	return false
+}
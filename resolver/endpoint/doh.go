@@ -1,13 +1,18 @@
 package endpoint
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
+	"time"
 )
 
 type ClientInfo struct {
@@ -32,9 +37,39 @@ type DOHEndpoint struct {
 	// used.
 	Bootstrap []string `json:"ips"`
 
+	// Dialer is used to connect to the server, i.e. to pin the traffic to an
+	// interface or a source address. If nil, a default dialer is used.
+	Dialer *net.Dialer `json:"-"`
+
+	// Proxy is the URL of a SOCKS5 or HTTP CONNECT proxy to connect to the
+	// server through, reached using Dialer. If nil, the server is contacted
+	// directly.
+	Proxy *url.URL `json:"-"`
+
+	// Pins are the base64 encoded SHA-256 digests of the public keys the
+	// certificate chain of the server must contain one of. If empty, any
+	// trusted certificate is accepted.
+	Pins []string `json:"-"`
+
+	// ECH defines whether Encrypted Client Hello hides Hostname from on-path
+	// observers. The configuration is looked up in the HTTPS record of
+	// Hostname, asked to the server itself over DoH.
+	ECH ECHMode `json:"-"`
+
 	once      sync.Once
 	transport http.RoundTripper
 	onConnect func(*ConnectInfo)
+
+	ech   echState
+	echMu sync.Mutex
+	// echTransport connects with ECH using the configuration list echList.
+	echTransport http.RoundTripper
+	echList      []byte
+
+	// testRootCAs is used in unit tests to trust their server certificate.
+	testRootCAs *x509.CertPool
+	// testLookupECH replaces the ECH configuration lookup in unit tests.
+	testLookupECH echLookup
 }
 
 func (e *DOHEndpoint) Protocol() Protocol {
@@ -60,31 +95,135 @@ func (e *DOHEndpoint) Test(ctx context.Context, testDomain string) (err error) {
 	req = req.WithContext(ctx)
 	res, err := e.RoundTrip(req)
 	if err != nil {
-		return fmt.Errorf("roundtrip: %v", err)
+		return fmt.Errorf("roundtrip: %w", err)
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("status: %d", res.StatusCode)
+		return StatusError{StatusCode: res.StatusCode}
 	}
 	// Consume body to convice the HTTP lib the connection can be reused.
 	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1<<16))
 	return nil
 }
 
-func (e *DOHEndpoint) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+func (e *DOHEndpoint) init() {
 	e.once.Do(func() {
 		if e.transport == nil {
-			e.transport = newTransport(e)
+			e.transport = newTransport(e, nil)
 		}
 	})
+}
+
+func (e *DOHEndpoint) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	rt, err := e.roundTripper(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err = e.roundTrip(rt, req)
+	if retry, ok := echRejected(err); ok && (req.Body == nil || req.GetBody != nil) {
+		// Retry once with the configuration sent by the server, or without
+		// ECH if it disabled it.
+		e.ech.rejected(retry)
+		if rt, err = e.roundTripper(req.Context()); err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		resp, err = e.roundTrip(rt, req)
+	}
+	return resp, err
+}
+
+func (e *DOHEndpoint) roundTrip(rt http.RoundTripper, req *http.Request) (resp *http.Response, err error) {
 	if e.onConnect != nil {
 		ctx, ci := withConnectInfo(req.Context())
 		req = req.WithContext(ctx)
-		resp, err = e.transport.RoundTrip(req)
+		resp, err = rt.RoundTrip(req)
 		if ci.Connect {
 			e.onConnect(ci)
 		}
 		return
 	}
-	return e.transport.RoundTrip(req)
+	return rt.RoundTrip(req)
+}
+
+// roundTripper returns the transport to send the requests through, connecting
+// with ECH when it is enabled and a configuration is available.
+func (e *DOHEndpoint) roundTripper(ctx context.Context) (http.RoundTripper, error) {
+	e.init()
+	list, err := e.ech.config(ctx, e.ECH, e.lookupECH)
+	if err != nil {
+		return nil, err
+	}
+	return e.transportFor(list), nil
+}
+
+// transportFor returns the transport connecting with ECH using the
+// configuration list, or without ECH if nil.
+func (e *DOHEndpoint) transportFor(list []byte) http.RoundTripper {
+	e.init()
+	if list == nil {
+		return e.transport
+	}
+	e.echMu.Lock()
+	defer e.echMu.Unlock()
+	if e.echTransport == nil || !bytes.Equal(list, e.echList) {
+		if e.echTransport != nil {
+			closeIdleConnections(e.echTransport)
+		}
+		e.echTransport, e.echList = newTransport(e, list), list
+	}
+	return e.echTransport
+}
+
+// lookupECH looks up the ECH configuration of the server with a DoH query sent
+// to the server itself, connecting with ECH using list if not nil.
+func (e *DOHEndpoint) lookupECH(ctx context.Context, list []byte) ([]byte, time.Duration, error) {
+	if e.testLookupECH != nil {
+		return e.testLookupECH(ctx, list)
+	}
+	q, err := echQuery(e.Hostname)
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://nowhere/", bytes.NewReader(q))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	res, err := e.transportFor(list).RoundTrip(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, StatusError{StatusCode: res.StatusCode}
+	}
+	resp, err := ioutil.ReadAll(io.LimitReader(res.Body, 65535))
+	if err != nil {
+		return nil, 0, err
+	}
+	return parseECH(resp)
+}
+
+// CloseIdleConnections closes the idle connections to the server so new ones
+// are established, i.e. after a network change made them stale.
+func (e *DOHEndpoint) CloseIdleConnections() {
+	e.init()
+	closeIdleConnections(e.transport)
+	e.echMu.Lock()
+	defer e.echMu.Unlock()
+	if e.echTransport != nil {
+		closeIdleConnections(e.echTransport)
+	}
+}
+
+func closeIdleConnections(rt http.RoundTripper) {
+	if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
 }
@@ -0,0 +1,302 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// maxIdleDOTConns is the maximum number of idle connections kept open to a DoT
+// server.
+const maxIdleDOTConns = 4
+
+// DOTEndpoint represents a DNS over TLS (RFC 7858) server endpoint.
+type DOTEndpoint struct {
+	// Hostname used to contact the DoT server. If Bootstrap is provided,
+	// Hostname is only used for TLS verification.
+	Hostname string
+
+	// Port of the server. If empty, 853 is used.
+	Port string
+
+	// Bootstrap is the IPs to use to contact the DoT server. When provided, no
+	// DNS request is necessary to contact the DoT server. They are tried in
+	// order.
+	Bootstrap []string
+
+	// Dialer is used to connect to the server. If nil, a default dialer is
+	// used.
+	Dialer *net.Dialer
+
+	// Pins are the base64 encoded SHA-256 digests of the public keys the
+	// certificate chain of the server must contain one of. If empty, any
+	// trusted certificate is accepted.
+	Pins []string
+
+	// ECH defines whether Encrypted Client Hello hides Hostname from on-path
+	// observers. The configuration is looked up in the HTTPS record of
+	// Hostname, asked to the server itself over DoT.
+	ECH ECHMode
+
+	mu   sync.Mutex
+	idle []net.Conn
+	ech  echState
+
+	// testRootCAs is used in unit tests to trust their server certificate.
+	testRootCAs *x509.CertPool
+	// testLookupECH replaces the ECH configuration lookup in unit tests.
+	testLookupECH echLookup
+}
+
+func (e *DOTEndpoint) Protocol() Protocol {
+	return ProtocolDOT
+}
+
+func (e *DOTEndpoint) Equal(e2 Endpoint) bool {
+	if e2, ok := e2.(*DOTEndpoint); ok {
+		return e.Hostname == e2.Hostname && e.port() == e2.port()
+	}
+	return false
+}
+
+func (e *DOTEndpoint) String() string {
+	s := "tls://" + e.Hostname
+	if e.Port != "" {
+		s += ":" + e.Port
+	}
+	if len(e.Bootstrap) != 0 {
+		s += "#" + strings.Join(e.Bootstrap, ",")
+	}
+	return s
+}
+
+func (e *DOTEndpoint) port() string {
+	if e.Port != "" {
+		return e.Port
+	}
+	return "853"
+}
+
+func (e *DOTEndpoint) Test(ctx context.Context, testDomain string) error {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 514), dnsmessage.Header{
+		RecursionDesired: true,
+	})
+	_ = b.StartQuestions()
+	err := b.Question(dnsmessage.Question{
+		Class: dnsmessage.ClassINET,
+		Type:  dnsmessage.TypeA,
+		Name:  dnsmessage.MustNewName(testDomain),
+	})
+	if err != nil {
+		return fmt.Errorf("question: %v", err)
+	}
+	q, err := b.Finish()
+	if err != nil {
+		return fmt.Errorf("finish: %v", err)
+	}
+	_, err = e.Exchange(ctx, q, make([]byte, 514))
+	return err
+}
+
+// Exchange sends the query q to the server and writes the response into buf.
+// A response larger than buf is cut and marked as truncated. Connections are
+// reused for the following queries.
+func (e *DOTEndpoint) Exchange(ctx context.Context, q, buf []byte) (int, error) {
+	// The query is copied as it can share its memory with buf.
+	msg := make([]byte, 2, 2+len(q))
+	binary.BigEndian.PutUint16(msg, uint16(len(q)))
+	msg = append(msg, q...)
+	for {
+		c, reused := e.getConn()
+		if c == nil {
+			var err error
+			if c, err = e.dial(ctx); err != nil {
+				return -1, err
+			}
+		}
+		n, err := exchange(ctx, c, msg, buf)
+		if err != nil {
+			c.Close()
+			if reused && ctx.Err() == nil {
+				// The server may have closed the idle connection.
+				continue
+			}
+			return -1, err
+		}
+		e.putConn(c)
+		return n, nil
+	}
+}
+
+func exchange(ctx context.Context, c net.Conn, msg, buf []byte) (int, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	_ = c.SetDeadline(deadline)
+	if _, err := c.Write(msg); err != nil {
+		return -1, fmt.Errorf("write: %w", err)
+	}
+	var l [2]byte
+	if _, err := io.ReadFull(c, l[:]); err != nil {
+		return -1, fmt.Errorf("read: %w", err)
+	}
+	size := int(binary.BigEndian.Uint16(l[:]))
+	if size < 12 {
+		return -1, errors.New("read: invalid response")
+	}
+	resp := buf
+	if size > len(buf) {
+		resp = make([]byte, size)
+	}
+	if _, err := io.ReadFull(c, resp[:size]); err != nil {
+		return -1, fmt.Errorf("read: %w", err)
+	}
+	if resp[0] != msg[2] || resp[1] != msg[3] {
+		return -1, errors.New("read: mismatched response ID")
+	}
+	if size > len(buf) {
+		size = copy(buf, resp)
+		buf[2] |= 0x2 // mark response as truncated
+	}
+	_ = c.SetDeadline(time.Time{})
+	return size, nil
+}
+
+func (e *DOTEndpoint) getConn() (net.Conn, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.idle) == 0 {
+		return nil, false
+	}
+	c := e.idle[len(e.idle)-1]
+	e.idle = e.idle[:len(e.idle)-1]
+	return c, true
+}
+
+func (e *DOTEndpoint) putConn(c net.Conn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.idle) >= maxIdleDOTConns {
+		c.Close()
+		return
+	}
+	e.idle = append(e.idle, c)
+}
+
+// CloseIdleConnections closes the idle connections to the server so new ones
+// are established, i.e. after a network change made them stale.
+func (e *DOTEndpoint) CloseIdleConnections() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, c := range e.idle {
+		c.Close()
+	}
+	e.idle = nil
+}
+
+func (e *DOTEndpoint) dial(ctx context.Context) (net.Conn, error) {
+	for retried := false; ; retried = true {
+		list, err := e.ech.config(ctx, e.ECH, e.lookupECH)
+		if err != nil {
+			return nil, err
+		}
+		c, err := e.dialTLS(ctx, list)
+		if retry, ok := echRejected(err); ok && !retried {
+			// Retry once with the configuration sent by the server, or
+			// without ECH if it disabled it.
+			e.ech.rejected(retry)
+			continue
+		}
+		return c, err
+	}
+}
+
+func (e *DOTEndpoint) addrs(port string) []string {
+	if len(e.Bootstrap) == 0 {
+		return []string{net.JoinHostPort(e.Hostname, port)}
+	}
+	addrs := make([]string, 0, len(e.Bootstrap))
+	for _, ip := range e.Bootstrap {
+		addrs = append(addrs, net.JoinHostPort(ip, port))
+	}
+	return addrs
+}
+
+func (e *DOTEndpoint) dialTCP(ctx context.Context, addrs []string) (net.Conn, error) {
+	d := e.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	var c net.Conn
+	var err error
+	for _, addr := range addrs {
+		if c, err = d.DialContext(ctx, "tcp", addr); err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	return c, err
+}
+
+// dialTLS connects to the server, using ECH with the configuration list
+// echList if not nil.
+func (e *DOTEndpoint) dialTLS(ctx context.Context, echList []byte) (net.Conn, error) {
+	c, err := e.dialTCP(ctx, e.addrs(e.port()))
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	cfg := &tls.Config{
+		ServerName: e.Hostname,
+		RootCAs:    e.testRootCAs,
+	}
+	if len(e.Pins) > 0 {
+		cfg.VerifyPeerCertificate = verifyPins(e.Pins)
+	}
+	setECH(cfg, echList)
+	tc := tls.Client(c, cfg)
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = tc.SetDeadline(deadline)
+	}
+	if err := tc.Handshake(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	_ = tc.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// lookupECH looks up the ECH configuration of the server with a DoT query sent
+// to the server itself, connecting with ECH using list if not nil.
+func (e *DOTEndpoint) lookupECH(ctx context.Context, list []byte) ([]byte, time.Duration, error) {
+	if e.testLookupECH != nil {
+		return e.testLookupECH(ctx, list)
+	}
+	q, err := echQuery(e.Hostname)
+	if err != nil {
+		return nil, 0, err
+	}
+	c, err := e.dialTLS(ctx, list)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer c.Close()
+	msg := make([]byte, 2, 2+len(q))
+	binary.BigEndian.PutUint16(msg, uint16(len(q)))
+	msg = append(msg, q...)
+	buf := make([]byte, 65535)
+	n, err := exchange(ctx, c, msg, buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return parseECH(buf[:n])
+}
@@ -0,0 +1,95 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dotServer starts a DoT server echoing the queries as responses with the
+// certificate of testCertificate, and returns its address, the pool trusting
+// its certificate and the pin of its public key. If not nil, configure can
+// modify the TLS configuration of the server.
+func dotServer(t *testing.T, conns *int32, configure func(*tls.Config)) (net.Listener, *x509.CertPool, string) {
+	t.Helper()
+	cert, pool, pin := testCertificate(t)
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(conns, 1)
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 514)
+				for {
+					if _, err := io.ReadFull(c, buf[:2]); err != nil {
+						return
+					}
+					n := int(binary.BigEndian.Uint16(buf))
+					if _, err := io.ReadFull(c, buf[2:2+n]); err != nil {
+						return
+					}
+					buf[4] |= 0x80 // QR
+					if _, err := c.Write(buf[:2+n]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l, pool, pin
+}
+
+func TestDOTEndpoint_Exchange(t *testing.T) {
+	var conns int32
+	l, pool, pin := dotServer(t, &conns, nil)
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+
+	e, err := New("tls://dot.example.com:" + port + "#" + host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot, ok := e.(*DOTEndpoint)
+	if !ok {
+		t.Fatalf("New() = %T, want *DOTEndpoint", e)
+	}
+	dot.testRootCAs = pool
+	dot.Pins = []string{pin}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if err := dot.Test(ctx, "example.com."); err != nil {
+			t.Fatalf("Test() = %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("%d connections, want 1 reused", n)
+	}
+
+	dot.CloseIdleConnections()
+	dot.Pins = []string{base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))}
+	if err := dot.Test(ctx, "example.com."); err == nil {
+		t.Error("Test() succeeded with a mismatched pin")
+	}
+}
@@ -0,0 +1,197 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// ECHMode defines whether the TLS connections to a server use Encrypted Client
+// Hello, hiding the server hostname from on-path observers.
+type ECHMode int
+
+const (
+	// ECHOff never uses ECH.
+	ECHOff ECHMode = iota
+
+	// ECHPrefer uses ECH when the server publishes a configuration, and
+	// connects without it otherwise.
+	ECHPrefer
+
+	// ECHRequire fails the connections when ECH cannot be used.
+	ECHRequire
+)
+
+// ParseECHMode parses the off, prefer and require ECH modes.
+func ParseECHMode(s string) (ECHMode, error) {
+	switch s {
+	case "", "off":
+		return ECHOff, nil
+	case "prefer":
+		return ECHPrefer, nil
+	case "require":
+		return ECHRequire, nil
+	}
+	return ECHOff, fmt.Errorf("%s: invalid ECH mode: expected off, prefer or require", s)
+}
+
+func (m ECHMode) String() string {
+	switch m {
+	case ECHPrefer:
+		return "prefer"
+	case ECHRequire:
+		return "require"
+	}
+	return "off"
+}
+
+const (
+	// echLookupTimeout bounds the lookup of an ECH configuration, so a server
+	// not answering it does not delay the connections in prefer mode.
+	echLookupTimeout = 2 * time.Second
+
+	// echMinTTL is the minimum time an ECH configuration, or its absence, is
+	// cached.
+	echMinTTL = 5 * time.Minute
+
+	// echFailureTTL is the time before a failed lookup is retried.
+	echFailureTTL = time.Minute
+)
+
+var errECHUnsupported = errors.New("ech: not supported by this build")
+
+// echLookup returns the ECH configuration list of a server and the time it
+// can be cached, asking the server itself over a connection using ECH with the
+// configuration list known so far, or without ECH if nil. The list is nil if
+// the server publishes none.
+type echLookup func(ctx context.Context, list []byte) ([]byte, time.Duration, error)
+
+// echState caches the ECH configuration list of a server.
+type echState struct {
+	mu     sync.Mutex
+	list   []byte
+	err    error
+	expire time.Time
+}
+
+// config returns the ECH configuration list to connect with in mode, or nil to
+// connect without ECH. The list is looked up with lookup once the previous one
+// expired. In require mode, a missing configuration or a failed lookup is an
+// error.
+func (s *echState) config(ctx context.Context, mode ECHMode, lookup echLookup) ([]byte, error) {
+	if mode == ECHOff {
+		return nil, nil
+	}
+	if !echSupported {
+		if mode == ECHRequire {
+			return nil, errECHUnsupported
+		}
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now(); !now.Before(s.expire) {
+		ctx, cancel := context.WithTimeout(ctx, echLookupTimeout)
+		list, ttl, err := lookup(ctx, s.list)
+		if retry, ok := echRejected(err); ok {
+			// The server rotated its keys since the previous lookup.
+			list, ttl, err = lookup(ctx, retry)
+		}
+		cancel()
+		if err != nil {
+			s.list, s.err, s.expire = nil, fmt.Errorf("ech: %w", err), now.Add(echFailureTTL)
+		} else {
+			if ttl < echMinTTL {
+				ttl = echMinTTL
+			}
+			s.list, s.err, s.expire = list, nil, now.Add(ttl)
+			if list == nil {
+				s.err = errors.New("ech: no configuration published")
+			}
+		}
+	}
+	if s.list == nil && mode == ECHRequire {
+		return nil, s.err
+	}
+	return s.list, nil
+}
+
+// rejected replaces the ECH configuration list rejected by the server with the
+// retry list it sent. An empty retry list means the server disabled ECH.
+func (s *echState) rejected(retry []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.list, s.err = nil, errors.New("ech: rejected by the server")
+	if len(retry) > 0 {
+		s.list, s.err = retry, nil
+	}
+	if floor := time.Now().Add(echMinTTL); s.expire.Before(floor) {
+		s.expire = floor
+	}
+}
+
+// echQuery returns the query of the HTTPS record of hostname, sent to the
+// server itself so the answer is authenticated by its certificate.
+func echQuery(hostname string) ([]byte, error) {
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = h
+	}
+	if !strings.HasSuffix(hostname, ".") {
+		hostname += "."
+	}
+	name, err := dnsmessage.NewName(hostname)
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(make([]byte, 0, 514), dnsmessage.Header{
+		RecursionDesired: true,
+	})
+	_ = b.StartQuestions()
+	if err = b.Question(dnsmessage.Question{
+		Class: dnsmessage.ClassINET,
+		Type:  dnsmessage.TypeHTTPS,
+		Name:  name,
+	}); err != nil {
+		return nil, fmt.Errorf("question: %v", err)
+	}
+	q, err := b.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("finish: %v", err)
+	}
+	return q, nil
+}
+
+// parseECH returns the ECH configuration list of the HTTPS record with the
+// highest priority in the response resp, and the TTL of the record.
+func parseECH(resp []byte) ([]byte, time.Duration, error) {
+	var m dnsmessage.Message
+	if err := m.Unpack(resp); err != nil {
+		return nil, 0, err
+	}
+	switch m.Header.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	default:
+		return nil, 0, fmt.Errorf("lookup: %v", m.Header.RCode)
+	}
+	var list []byte
+	var prio uint16
+	ttl := echMinTTL
+	for _, rr := range m.Answers {
+		r, ok := rr.Body.(*dnsmessage.HTTPSResource)
+		// Alias mode records (priority 0) are not followed.
+		if !ok || r.Priority == 0 || (list != nil && r.Priority >= prio) {
+			continue
+		}
+		if v, found := r.Param(dnsmessage.SVCParamECH); found && len(v) > 0 {
+			list, prio = v, r.Priority
+			ttl = time.Duration(rr.Header.TTL) * time.Second
+		}
+	}
+	return list, ttl, nil
+}
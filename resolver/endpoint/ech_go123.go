@@ -0,0 +1,33 @@
+// +build go1.23
+
+package endpoint
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+const echSupported = true
+
+// setECH sets the ECH configuration list cfg connects with, if any.
+func setECH(cfg *tls.Config, list []byte) {
+	if list != nil {
+		cfg.EncryptedClientHelloConfigList = list
+		cfg.MinVersion = tls.VersionTLS13
+	}
+}
+
+// echRejected returns the retry configuration list sent by the server if err
+// is caused by the server rejecting ECH.
+func echRejected(err error) (retry []byte, ok bool) {
+	var rerr *tls.ECHRejectionError
+	if errors.As(err, &rerr) {
+		return rerr.RetryConfigList, true
+	}
+	return nil, false
+}
+
+// echAccepted returns whether ECH was used by the connection of cs.
+func echAccepted(cs tls.ConnectionState) bool {
+	return cs.ECHAccepted
+}
@@ -0,0 +1,167 @@
+// +build go1.24
+
+package endpoint
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testECHKey returns an ECH key with the public name public.example.com and
+// the configuration list publishing it.
+func testECHKey(t *testing.T, id byte) (tls.EncryptedClientHelloKey, []byte) {
+	t.Helper()
+	k, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u16 := func(b []byte, v int) []byte {
+		return binary.BigEndian.AppendUint16(b, uint16(v))
+	}
+	pub := k.PublicKey().Bytes()
+	publicName := "public.example.com"
+	body := []byte{id}
+	body = u16(body, 0x0020) // DHKEM(X25519, HKDF-SHA256)
+	body = append(u16(body, len(pub)), pub...)
+	body = u16(u16(u16(body, 4), 0x0001), 0x0001) // HKDF-SHA256, AES-128-GCM
+	body = append(body, 0, byte(len(publicName)))
+	body = append(body, publicName...)
+	body = u16(body, 0) // extensions
+	config := append(u16(u16(nil, 0xfe0d), len(body)), body...)
+	list := append(u16(nil, len(config)), config...)
+	return tls.EncryptedClientHelloKey{Config: config, PrivateKey: k.Bytes(), SendAsRetry: true}, list
+}
+
+type echTest struct {
+	name string
+	mode ECHMode
+	// server is the key of the server, none if nil.
+	server *tls.EncryptedClientHelloKey
+	// list is the looked up configuration list, none if nil.
+	list         []byte
+	lookupErr    error
+	wantAccepted bool
+	wantErr      bool
+}
+
+func echTests(t *testing.T) []echTest {
+	key, list := testECHKey(t, 1)
+	_, stale := testECHKey(t, 2)
+	lookupErr := errors.New("timeout")
+	return []echTest{
+		{"require", ECHRequire, &key, list, nil, true, false},
+		{"require retry", ECHRequire, &key, stale, nil, true, false},
+		{"require disabled", ECHRequire, nil, list, nil, false, true},
+		{"require none", ECHRequire, &key, nil, nil, false, true},
+		{"require lookup error", ECHRequire, &key, nil, lookupErr, false, true},
+		{"prefer", ECHPrefer, &key, list, nil, true, false},
+		{"prefer disabled", ECHPrefer, nil, list, nil, false, false},
+		{"prefer none", ECHPrefer, &key, nil, nil, false, false},
+		{"prefer lookup error", ECHPrefer, &key, list, lookupErr, false, false},
+		{"off", ECHOff, &key, list, nil, false, false},
+	}
+}
+
+func (tt echTest) configure(cfg *tls.Config) {
+	if tt.server != nil {
+		cfg.EncryptedClientHelloKeys = []tls.EncryptedClientHelloKey{*tt.server}
+	}
+}
+
+func (tt echTest) lookup(ctx context.Context, list []byte) ([]byte, time.Duration, error) {
+	return tt.list, time.Hour, tt.lookupErr
+}
+
+func TestDOTEndpoint_ECH(t *testing.T) {
+	for _, tt := range echTests(t) {
+		t.Run(tt.name, func(t *testing.T) {
+			var conns int32
+			l, pool, _ := dotServer(t, &conns, tt.configure)
+			defer l.Close()
+			e := &DOTEndpoint{
+				Hostname:      "dot.example.com",
+				Bootstrap:     []string{"127.0.0.1"},
+				ECH:           tt.mode,
+				testRootCAs:   pool,
+				testLookupECH: tt.lookup,
+			}
+			_, e.Port, _ = net.SplitHostPort(l.Addr().String())
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			c, err := e.dial(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			if got := c.(*tls.Conn).ConnectionState().ECHAccepted; got != tt.wantAccepted {
+				t.Errorf("ECHAccepted = %v, want %v", got, tt.wantAccepted)
+			}
+		})
+	}
+}
+
+func TestDOHEndpoint_ECH(t *testing.T) {
+	for _, tt := range echTests(t) {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, pool, _ := testCertificate(t)
+			s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The body of the retried requests must be sent again.
+				if b, _ := ioutil.ReadAll(r.Body); string(b) != "query" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if r.TLS.ECHAccepted {
+					w.Header().Set("X-ECH", "1")
+				}
+			}))
+			s.EnableHTTP2 = true
+			s.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+			s.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+			tt.configure(s.TLS)
+			s.StartTLS()
+			defer s.Close()
+			e := &DOHEndpoint{
+				Hostname:      "dot.example.com",
+				Bootstrap:     []string{"127.0.0.1"},
+				Proxy:         connectProxy(t, s.Listener.Addr().String()),
+				ECH:           tt.mode,
+				testRootCAs:   pool,
+				testLookupECH: tt.lookup,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, "POST", "https://nowhere/", strings.NewReader("query"))
+			res, err := e.RoundTrip(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RoundTrip() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer res.Body.Close()
+			_, _ = io.Copy(ioutil.Discard, res.Body)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("RoundTrip() status = %d", res.StatusCode)
+			}
+			if got := res.Header.Get("X-ECH") == "1"; got != tt.wantAccepted {
+				t.Errorf("ECHAccepted = %v, want %v", got, tt.wantAccepted)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+// +build !go1.23
+
+package endpoint
+
+import "crypto/tls"
+
+// echSupported is false as ECH requires Go 1.23.
+const echSupported = false
+
+func setECH(cfg *tls.Config, list []byte) {}
+
+func echRejected(err error) (retry []byte, ok bool) {
+	return nil, false
+}
+
+func echAccepted(cs tls.ConnectionState) bool {
+	return false
+}
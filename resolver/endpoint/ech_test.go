@@ -0,0 +1,263 @@
+package endpoint
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+func TestParseECHMode(t *testing.T) {
+	for _, s := range []string{"off", "prefer", "require"} {
+		m, err := ParseECHMode(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.String() != s {
+			t.Errorf("ParseECHMode(%q) = %v", s, m)
+		}
+	}
+	if _, err := ParseECHMode("on"); err == nil {
+		t.Error("ParseECHMode(on) succeeded")
+	}
+}
+
+// testCertificate returns a self-signed certificate for dot.example.com and
+// public.example.com, the pool trusting it and the pin of its public key.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dot.example.com"},
+		DNSNames:     []string{"dot.example.com", "public.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool, base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// httpsServer starts a DoT server answering the HTTPS queries with the records
+// rrs, and returns its address and the pool trusting its certificate.
+func httpsServer(t *testing.T, rcode dnsmessage.RCode, rrs ...dnsmessage.Resource) (string, *x509.CertPool) {
+	t.Helper()
+	cert, pool, _ := testCertificate(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 514)
+				if _, err := io.ReadFull(c, buf[:2]); err != nil {
+					return
+				}
+				n := int(binary.BigEndian.Uint16(buf))
+				if _, err := io.ReadFull(c, buf[:n]); err != nil {
+					return
+				}
+				var q dnsmessage.Message
+				if err := q.Unpack(buf[:n]); err != nil || len(q.Questions) != 1 || q.Questions[0].Type != dnsmessage.TypeHTTPS {
+					return
+				}
+				m := dnsmessage.Message{
+					Header:    dnsmessage.Header{ID: q.Header.ID, Response: true, RCode: rcode},
+					Questions: q.Questions,
+					Answers:   rrs,
+				}
+				resp, err := m.AppendPack(make([]byte, 2))
+				if err != nil {
+					return
+				}
+				binary.BigEndian.PutUint16(resp, uint16(len(resp)-2))
+				_, _ = c.Write(resp)
+			}()
+		}
+	}()
+	return l.Addr().String(), pool
+}
+
+func httpsRecord(prio uint16, ttl uint32, ech []byte) dnsmessage.Resource {
+	r := &dnsmessage.HTTPSResource{}
+	r.Priority = prio
+	r.Target = dnsmessage.MustNewName(".")
+	if ech != nil {
+		r.SetParam(dnsmessage.SVCParamECH, ech)
+	}
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName("dot.example.com."),
+			Type:  dnsmessage.TypeHTTPS,
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		},
+		Body: r,
+	}
+}
+
+func TestDOTEndpoint_lookupECH(t *testing.T) {
+	tests := []struct {
+		name      string
+		rcode     dnsmessage.RCode
+		rrs       []dnsmessage.Resource
+		untrusted bool
+		want      []byte
+		wantTTL   time.Duration
+		wantErr   bool
+	}{
+		{"ech", dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+			httpsRecord(1, 3600, []byte("list")),
+		}, false, []byte("list"), time.Hour, false},
+		{"highest priority", dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+			httpsRecord(2, 3600, []byte("second")),
+			httpsRecord(0, 3600, []byte("alias")),
+			httpsRecord(1, 600, []byte("first")),
+		}, false, []byte("first"), 10 * time.Minute, false},
+		{"no ech", dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+			httpsRecord(1, 3600, nil),
+		}, false, nil, echMinTTL, false},
+		{"nxdomain", dnsmessage.RCodeNameError, nil, false, nil, echMinTTL, false},
+		{"servfail", dnsmessage.RCodeServerFailure, nil, false, nil, 0, true},
+		{"untrusted", dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+			httpsRecord(1, 3600, []byte("list")),
+		}, true, nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, pool := httpsServer(t, tt.rcode, tt.rrs...)
+			e := &DOTEndpoint{
+				Hostname:  "dot.example.com",
+				Bootstrap: []string{"127.0.0.1"},
+			}
+			_, e.Port, _ = net.SplitHostPort(addr)
+			if !tt.untrusted {
+				e.testRootCAs = pool
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			list, ttl, err := e.lookupECH(ctx, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookupECH() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(list, tt.want) || ttl != tt.wantTTL {
+				t.Errorf("lookupECH() = %q, %v, want %q, %v", list, ttl, tt.want, tt.wantTTL)
+			}
+		})
+	}
+}
+
+// connectProxy starts an HTTP CONNECT proxy connecting all the tunnels to addr,
+// and returns its URL.
+func connectProxy(t *testing.T, addr string) *url.URL {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil || req.Method != "CONNECT" {
+					return
+				}
+				s, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer s.Close()
+				if _, err := io.WriteString(c, "HTTP/1.1 200 OK\r\n\r\n"); err != nil {
+					return
+				}
+				go func() { _, _ = io.Copy(s, c) }()
+				_, _ = io.Copy(c, s)
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func TestDOHEndpoint_lookupECH(t *testing.T) {
+	cert, pool, _ := testCertificate(t)
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		var q dnsmessage.Message
+		if r.Header.Get("Content-Type") != "application/dns-message" || q.Unpack(b) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: q.Header.ID, Response: true},
+			Questions: q.Questions,
+			Answers:   []dnsmessage.Resource{httpsRecord(1, 3600, []byte("list"))},
+		}
+		resp, _ := m.Pack()
+		_, _ = w.Write(resp)
+	}))
+	s.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	s.StartTLS()
+	defer s.Close()
+	e := &DOHEndpoint{
+		Hostname:    "dot.example.com",
+		Bootstrap:   []string{"127.0.0.1"},
+		Proxy:       connectProxy(t, s.Listener.Addr().String()),
+		testRootCAs: pool,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	list, ttl, err := e.lookupECH(ctx, nil)
+	if err != nil || string(list) != "list" || ttl != time.Hour {
+		t.Errorf("lookupECH() = %q, %v, %v, want %q, %v", list, ttl, err, "list", time.Hour)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -20,6 +21,8 @@ func (p Protocol) String() string {
 		return "doh"
 	case ProtocolDNS:
 		return "dns"
+	case ProtocolDOT:
+		return "dot"
 	default:
 		return "unknown"
 	}
@@ -28,6 +31,7 @@ func (p Protocol) String() string {
 const (
 	ProtocolDOH Protocol = iota
 	ProtocolDNS
+	ProtocolDOT
 )
 
 // Endpoint represents a DNS server endpoint.
@@ -50,6 +54,8 @@ type Endpoint interface {
 //
 //   * DoH:   https://doh.server.com/path
 //   * DoH:   https://doh.server.com/path#1.2.3.4 // with bootstrap
+//   * DoT:   tls://dot.server.com
+//   * DoT:   tls://dot.server.com:853#1.2.3.4 // with bootstrap
 //   * DNS53: 1.2.3.4
 //   * DNS53: 1.2.3.4:5353
 func New(server string) (Endpoint, error) {
@@ -58,12 +64,42 @@ func New(server string) (Endpoint, error) {
 		if err != nil {
 			return nil, err
 		}
+		if u.Hostname() == "" {
+			return nil, errors.New("missing hostname")
+		}
+		if err := checkPort(u.Port()); err != nil {
+			return nil, err
+		}
 		e := &DOHEndpoint{
 			Hostname: u.Host,
 			Path:     u.Path,
 		}
 		if u.Fragment != "" {
-			e.Bootstrap = strings.Split(u.Fragment, ",")
+			if e.Bootstrap, err = parseBootstrap(u.Fragment); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	}
+	if strings.HasPrefix(server, "tls://") {
+		u, err := url.Parse(server)
+		if err != nil {
+			return nil, err
+		}
+		if u.Hostname() == "" {
+			return nil, errors.New("missing hostname")
+		}
+		if err := checkPort(u.Port()); err != nil {
+			return nil, err
+		}
+		e := &DOTEndpoint{
+			Hostname: u.Hostname(),
+			Port:     u.Port(),
+		}
+		if u.Fragment != "" {
+			if e.Bootstrap, err = parseBootstrap(u.Fragment); err != nil {
+				return nil, err
+			}
 		}
 		return e, nil
 	}
@@ -76,11 +112,36 @@ func New(server string) (Endpoint, error) {
 	if ip := net.ParseIP(host); ip == nil {
 		return nil, errors.New("not a valid IP address")
 	}
+	if err := checkPort(port); err != nil {
+		return nil, err
+	}
 	return &DNSEndpoint{
 		Addr: net.JoinHostPort(host, port),
 	}, nil
 }
 
+// parseBootstrap parses a comma separated list of bootstrap IPs.
+func parseBootstrap(s string) ([]string, error) {
+	ips := strings.Split(s, ",")
+	for _, ip := range ips {
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("%s: invalid bootstrap IP", ip)
+		}
+	}
+	return ips, nil
+}
+
+// checkPort returns an error if port is set and is not a valid port number.
+func checkPort(port string) error {
+	if port == "" {
+		return nil
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("%s: invalid port", port)
+	}
+	return nil
+}
+
 // MustNew is like New but panics on error.
 func MustNew(server string) Endpoint {
 	e, err := New(server)
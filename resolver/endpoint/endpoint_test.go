@@ -0,0 +1,39 @@
+package endpoint
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		server  string
+		want    string
+		wantErr bool
+	}{
+		{"https://dns.nextdns.io/abcdef", "https://dns.nextdns.io/abcdef", false},
+		{"https://dns.nextdns.io/abcdef#45.90.28.0,2a07:a8c0::", "https://dns.nextdns.io/abcdef#45.90.28.0,2a07:a8c0::", false},
+		{"https://doh.example.com:8443/dns-query", "https://doh.example.com:8443/dns-query", false},
+		{"tls://dns.quad9.net", "tls://dns.quad9.net", false},
+		{"tls://dns.quad9.net:853#9.9.9.9", "tls://dns.quad9.net:853#9.9.9.9", false},
+		{"1.1.1.1", "1.1.1.1:53", false},
+		{"[2606:4700::1111]:5353", "[2606:4700::1111]:5353", false},
+		{"https://", "", true},
+		{"https:///dns-query", "", true},
+		{"https://dns.nextdns.io#45.90.28", "", true},
+		{"https://dns.nextdns.io:http/", "", true},
+		{"tls://#9.9.9.9", "", true},
+		{"tls://dns.quad9.net:0", "", true},
+		{"tls://dns.quad9.net#quad9", "", true},
+		{"dns.quad9.net", "", true},
+		{"1.1.1.1:99999", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			e, err := New(tt.server)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && e.String() != tt.want {
+				t.Errorf("New() = %v, want %v", e, tt.want)
+			}
+		})
+	}
+}
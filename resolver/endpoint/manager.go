@@ -3,7 +3,9 @@ package endpoint
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -62,6 +64,19 @@ type Manager struct {
 	// endpoints).
 	OnConnect func(*ConnectInfo)
 
+	// Dialer is set as the Dialer of the DoH endpoints returned by Providers
+	// without one.
+	Dialer *net.Dialer
+
+	// Proxy returns the URL of the proxy set as the Proxy of the DoH endpoints
+	// for hostname returned by Providers without one, or nil for a direct
+	// connection.
+	Proxy func(hostname string) *url.URL
+
+	// ECH is set as the ECH mode of the DoH and DoT endpoints returned by
+	// Providers without one.
+	ECH ECHMode
+
 	// OnError is called each time a test on e failed, forcing Manager to
 	// fallback to the next endpoint. If e is nil, the error happended on the
 	// Provider.
@@ -70,8 +85,23 @@ type Manager struct {
 	// OnProviderError is called when a provider returns an error.
 	OnProviderError func(p Provider, err error)
 
+	// SteeringMargin is the minimum relative RTT improvement (0 to 1) over
+	// the active endpoint for Steer to switch to another endpoint. If zero,
+	// DefaultSteeringMargin is used.
+	SteeringMargin float64
+
+	// OnSteer is called before OnChange when Steer switches to a faster
+	// endpoint, with the smoothed RTTs of both endpoints. The RTT of from is
+	// negative if it failed to answer.
+	OnSteer func(from, to Endpoint, fromRTT, toRTT time.Duration)
+
 	mu             sync.RWMutex
 	activeEndpoint *activeEnpoint
+	// endpoints lists the endpoints returned by the providers on last test.
+	endpoints []Endpoint
+
+	steerMu  sync.Mutex
+	steering steeringState
 
 	testNewTransport func(e *DOHEndpoint) http.RoundTripper
 	testNow          func() time.Time
@@ -112,6 +142,7 @@ func (m *Manager) testLocked(ctx context.Context) error {
 // of its health.
 func (m *Manager) findBestEndpointLocked(ctx context.Context) (*activeEnpoint, error) {
 	var firstEndpoint Endpoint
+	m.endpoints = nil
 	for _, p := range m.Providers {
 		endpoints, err := p.GetEndpoints(ctx)
 		if err != nil {
@@ -124,6 +155,10 @@ func (m *Manager) findBestEndpointLocked(ctx context.Context) (*activeEnpoint, e
 			}
 			continue
 		}
+		m.endpoints = append(m.endpoints, endpoints...)
+		for _, e := range endpoints {
+			m.setDialerLocked(e)
+		}
 		for _, e := range endpoints {
 			if firstEndpoint == nil {
 				firstEndpoint = e
@@ -165,6 +200,34 @@ func isErrNetUnreachable(err error) bool {
 	return false
 }
 
+// setDialerLocked sets the Dialer, Proxy and ECH mode of m to e if it is a DoH
+// endpoint without them, or the Dialer and ECH mode if it is a DoT endpoint.
+// It is called before e is used, so its transport picks them up.
+func (m *Manager) setDialerLocked(e Endpoint) {
+	if dot, ok := e.(*DOTEndpoint); ok {
+		if m.Dialer != nil && dot.Dialer == nil {
+			dot.Dialer = m.Dialer
+		}
+		if dot.ECH == ECHOff {
+			dot.ECH = m.ECH
+		}
+		return
+	}
+	doh, ok := e.(*DOHEndpoint)
+	if !ok {
+		return
+	}
+	if m.Dialer != nil && doh.Dialer == nil {
+		doh.Dialer = m.Dialer
+	}
+	if m.Proxy != nil && doh.Proxy == nil {
+		doh.Proxy = m.Proxy(doh.Hostname)
+	}
+	if doh.ECH == ECHOff {
+		doh.ECH = m.ECH
+	}
+}
+
 func (m *Manager) newActiveEndpointLocked(e Endpoint) (ae *activeEnpoint) {
 	if m.activeEndpoint != nil && m.activeEndpoint.Endpoint.Equal(e) {
 		return m.activeEndpoint
@@ -197,6 +260,22 @@ func (m *Manager) newActiveEndpointLocked(e Endpoint) (ae *activeEnpoint) {
 	return ae
 }
 
+// CloseIdleConnections closes the idle connections of the endpoints so new ones
+// are established through the current network, i.e. after a network change.
+func (m *Manager) CloseIdleConnections() {
+	m.mu.RLock()
+	endpoints := m.endpoints
+	if m.activeEndpoint != nil {
+		endpoints = append(endpoints[:len(endpoints):len(endpoints)], m.activeEndpoint.Endpoint)
+	}
+	m.mu.RUnlock()
+	for _, e := range endpoints {
+		if c, ok := e.(interface{ CloseIdleConnections() }); ok {
+			c.CloseIdleConnections()
+		}
+	}
+}
+
 func (m *Manager) getActiveEndpoint() (*activeEnpoint, error) {
 	m.mu.RLock()
 	ae := m.activeEndpoint
@@ -209,6 +288,7 @@ func (m *Manager) getActiveEndpoint() (*activeEnpoint, error) {
 			if m.InitEndpoint != nil {
 				// InitEndpoint provided, use it but zero the lastTest so an
 				// async test is triggered on first query.
+				m.setDialerLocked(m.InitEndpoint)
 				ae = m.newActiveEndpointLocked(m.InitEndpoint)
 				ae.lastTest = time.Time{}
 			} else {
@@ -235,6 +315,34 @@ func (m *Manager) Do(ctx context.Context, action func(e Endpoint) error) error {
 	return ae.do(action)
 }
 
+// DoFailover is like Do but performs action with the endpoint skip positions
+// after the active one in the list of endpoints returned by the providers on
+// last test. It is used to retry a request failed with a connection error on
+// another endpoint right away. A test is triggered so the active endpoint is
+// switched if failed.
+func (m *Manager) DoFailover(ctx context.Context, skip int, action func(e Endpoint) error) error {
+	if skip == 0 {
+		return m.Do(ctx, action)
+	}
+	ae, err := m.getActiveEndpoint()
+	if err != nil {
+		return err
+	}
+	if ae == nil {
+		return errors.New("no active endpoint")
+	}
+	ae.test()
+	m.mu.RLock()
+	endpoints := m.endpoints
+	m.mu.RUnlock()
+	for i, e := range endpoints {
+		if e.Equal(ae.Endpoint) {
+			return action(endpoints[(i+skip)%len(endpoints)])
+		}
+	}
+	return action(ae.Endpoint)
+}
+
 // activeEnpoint handles request successes and errors and perform opportunistic
 // and recovery tests.
 type activeEnpoint struct {
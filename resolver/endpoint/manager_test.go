@@ -207,3 +207,77 @@ func TestManager_OpportunisticTest(t *testing.T) {
 		})
 	}
 }
+
+func TestManager_Steer(t *testing.T) {
+	m := newTestManager(t)
+	var mu sync.Mutex
+	delays := map[string]time.Duration{"https://a": 40 * time.Millisecond, "https://b": 0}
+	m.EndpointTester = func(e Endpoint) Tester {
+		return func(ctx context.Context, testDomain string) error {
+			mu.Lock()
+			d := delays[e.String()]
+			mu.Unlock()
+			time.Sleep(d)
+			return nil
+		}
+	}
+	m.Test(context.Background())
+	m.wantElected(t, "https://a")
+
+	for i, tt := range []struct {
+		a, b        time.Duration
+		wantElected string
+	}{
+		{40 * time.Millisecond, 0, "https://a"}, // faster once, not switched yet
+		{40 * time.Millisecond, 0, "https://b"},
+		// RTTs are smoothed: a needs a few rounds to become faster than b.
+		{0, 40 * time.Millisecond, "https://b"},
+		{0, 40 * time.Millisecond, "https://b"},
+		{0, 40 * time.Millisecond, "https://b"},
+		{0, 40 * time.Millisecond, "https://a"},
+	} {
+		mu.Lock()
+		delays["https://a"], delays["https://b"] = tt.a, tt.b
+		mu.Unlock()
+		if err := m.Steer(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			m.wantElected(t, tt.wantElected)
+		})
+	}
+}
+
+type idleTransport struct {
+	errTransport
+	closed int
+}
+
+func (t *idleTransport) CloseIdleConnections() {
+	t.closed++
+}
+
+func TestManager_CloseIdleConnections(t *testing.T) {
+	active := &idleTransport{}
+	other := &idleTransport{}
+	m := &Manager{
+		endpoints: []Endpoint{
+			&DOHEndpoint{Hostname: "a", transport: other},
+			&DNSEndpoint{Addr: "127.0.0.1:53"},
+		},
+	}
+	m.activeEndpoint = &activeEnpoint{
+		Endpoint: &DOHEndpoint{Hostname: "b", transport: active},
+		manager:  m,
+	}
+	m.CloseIdleConnections()
+	if active.closed != 1 {
+		t.Errorf("active endpoint closed %d times, want 1", active.closed)
+	}
+	if other.closed != 1 {
+		t.Errorf("other endpoint closed %d times, want 1", other.closed)
+	}
+	if len(m.endpoints) != 2 {
+		t.Errorf("endpoints modified: %v", m.endpoints)
+	}
+}
@@ -0,0 +1,40 @@
+package endpoint
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ParsePin checks pin is a base64 encoded SHA-256 digest of a certificate
+// SubjectPublicKeyInfo, as used by HPKP.
+func ParsePin(pin string) error {
+	b, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("%s: invalid pin: expected the base64 encoded SHA-256 digest of a public key", pin)
+	}
+	return nil
+}
+
+// verifyPins returns a function for tls.Config.VerifyPeerCertificate accepting
+// the verified chains with a certificate whose public key matches one of the
+// pins, so the server is trusted even if a public CA is compromised or a TLS
+// inspecting proxy is installed.
+func verifyPins(pins []string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				pin := base64.StdEncoding.EncodeToString(sum[:])
+				for _, p := range pins {
+					if p == pin {
+						return nil
+					}
+				}
+			}
+		}
+		return errors.New("no certificate matching the pinned public keys")
+	}
+}
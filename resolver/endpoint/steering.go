@@ -0,0 +1,162 @@
+package endpoint
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// DefaultSteeringMargin defines the default value for Manager
+	// SteeringMargin.
+	DefaultSteeringMargin = 0.2
+
+	// minSteeringGain is the minimum latency gain for a steering switch, so
+	// endpoints with close sub-millisecond RTTs do not alternate.
+	minSteeringGain = 5 * time.Millisecond
+
+	// steeringRounds is the number of consecutive steering rounds an endpoint
+	// must be the fastest before being switched to.
+	steeringRounds = 2
+
+	// rttSmoothing is the weight of a new RTT sample in the smoothed RTT.
+	rttSmoothing = 0.3
+)
+
+// steeringState holds the RTT measurements of the endpoints between steering
+// rounds.
+type steeringState struct {
+	rtts      map[string]time.Duration // smoothed RTT by endpoint
+	candidate string
+	wins      int
+}
+
+// RTTs returns the smoothed RTT measured by Steer for each endpoint, indexed
+// by their string representation.
+func (m *Manager) RTTs() map[string]time.Duration {
+	m.steerMu.Lock()
+	defer m.steerMu.Unlock()
+	rtts := make(map[string]time.Duration, len(m.steering.rtts))
+	for e, rtt := range m.steering.rtts {
+		rtts[e] = rtt
+	}
+	return rtts
+}
+
+// ResetSteering forgets the RTTs measured so far, i.e. after a network change
+// made them irrelevant.
+func (m *Manager) ResetSteering() {
+	m.steerMu.Lock()
+	m.steering = steeringState{}
+	m.steerMu.Unlock()
+}
+
+// Steer measures the RTT of all the endpoints using the same protocol as the
+// active endpoint and switches to the fastest one. To avoid flapping, an
+// endpoint must be faster than the active one by SteeringMargin and at least
+// a few milliseconds for several consecutive calls before being switched to.
+//
+// Steer does nothing while the plain DNS fallback is active, Test is in charge
+// of recovering from failures.
+func (m *Manager) Steer(ctx context.Context) error {
+	ae, err := m.getActiveEndpoint()
+	if err != nil {
+		return err
+	}
+	if ae == nil || ae.Protocol() == ProtocolDNS {
+		return nil
+	}
+	m.mu.RLock()
+	endpoints := m.endpoints
+	m.mu.RUnlock()
+
+	m.steerMu.Lock()
+	defer m.steerMu.Unlock()
+	if m.steering.rtts == nil {
+		m.steering.rtts = map[string]time.Duration{}
+	}
+	var best Endpoint
+	var bestRTT time.Duration
+	activeRTT := time.Duration(-1)
+	for _, e := range endpoints {
+		if e.Protocol() != ae.Protocol() {
+			continue
+		}
+		key := e.String()
+		rtt, err := m.probe(ctx, e)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			delete(m.steering.rtts, key)
+			continue
+		}
+		if srtt, found := m.steering.rtts[key]; found {
+			rtt = srtt + time.Duration(rttSmoothing*float64(rtt-srtt))
+		}
+		m.steering.rtts[key] = rtt
+		if e.Equal(ae.Endpoint) {
+			activeRTT = rtt
+		}
+		if best == nil || rtt < bestRTT {
+			best, bestRTT = e, rtt
+		}
+	}
+	margin := m.SteeringMargin
+	if margin == 0 {
+		margin = DefaultSteeringMargin
+	}
+	if best == nil || best.Equal(ae.Endpoint) ||
+		activeRTT >= 0 && (bestRTT > time.Duration(float64(activeRTT)*(1-margin)) || activeRTT-bestRTT < minSteeringGain) {
+		m.steering.candidate, m.steering.wins = "", 0
+		return nil
+	}
+	if key := best.String(); key != m.steering.candidate {
+		m.steering.candidate, m.steering.wins = key, 0
+	}
+	if m.steering.wins++; m.steering.wins < steeringRounds {
+		return nil
+	}
+	m.steering.candidate, m.steering.wins = "", 0
+
+	m.mu.Lock()
+	if m.activeEndpoint != ae {
+		// Changed by a test in the meantime.
+		m.mu.Unlock()
+		return nil
+	}
+	m.activeEndpoint = m.newActiveEndpointLocked(best)
+	m.mu.Unlock()
+	if m.OnSteer != nil {
+		m.OnSteer(ae.Endpoint, best, activeRTT, bestRTT)
+	}
+	if m.OnChange != nil {
+		m.OnChange(best)
+	}
+	return nil
+}
+
+// probe returns the best RTT of two tests of e, so the connection setup of an
+// idle endpoint is not accounted for.
+func (m *Manager) probe(ctx context.Context, e Endpoint) (time.Duration, error) {
+	tester := e.Test
+	if m.EndpointTester != nil {
+		if t := m.EndpointTester(e); t != nil {
+			tester = t
+		}
+	}
+	best := time.Duration(-1)
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		start := time.Now()
+		err := tester(ctx, TestDomain)
+		rtt := time.Since(start)
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+		if best < 0 || rtt < best {
+			best = rtt
+		}
+	}
+	return best, nil
+}
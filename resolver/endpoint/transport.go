@@ -5,7 +5,10 @@ import (
 	"crypto/tls"
 	"net"
 	"net/http"
+	"net/url"
 	"runtime"
+
+	"github.com/nextdns/nextdns/internal/outbound"
 )
 
 type transport struct {
@@ -15,7 +18,9 @@ type transport struct {
 	addr     string
 }
 
-func newTransport(e *DOHEndpoint) transport {
+// newTransport returns the transport to e, using ECH with the configuration list
+// echList if not nil.
+func newTransport(e *DOHEndpoint, echList []byte) transport {
 	var addr string
 	var addrs []string
 	if len(e.Bootstrap) != 0 {
@@ -27,17 +32,30 @@ func newTransport(e *DOHEndpoint) transport {
 		addr = e.Hostname
 	}
 	d := &parallelDialer{}
+	if e.Dialer != nil {
+		d.Dialer = *e.Dialer
+	}
 	d.FallbackDelay = 0 // disable happy eyeball, we do our own
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addrs != nil {
+			return d.DialParallel(ctx, network, addrs)
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+	if e.Proxy != nil {
+		dial = proxyDial(e.Proxy, e.Dialer, addrs)
+	}
+	tlsConfig := &tls.Config{
+		ServerName: e.Hostname,
+		RootCAs:    e.testRootCAs,
+	}
+	if len(e.Pins) > 0 {
+		tlsConfig.VerifyPeerCertificate = verifyPins(e.Pins)
+	}
+	setECH(tlsConfig, echList)
 	t := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			ServerName: e.Hostname,
-		},
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			if addrs != nil {
-				return d.DialParallel(ctx, network, addrs)
-			}
-			return d.DialContext(ctx, network, addr)
-		},
+		TLSClientConfig:   tlsConfig,
+		DialContext:       dial,
 		ForceAttemptHTTP2: true,
 	}
 	runtime.SetFinalizer(t, func(t *http.Transport) {
@@ -51,6 +69,28 @@ func newTransport(e *DOHEndpoint) transport {
 	}
 }
 
+// proxyDial returns a dial function connecting through the proxy at u. The
+// addrs are tried in order as racing them would open as many tunnels.
+func proxyDial(u *url.URL, d *net.Dialer, addrs []string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	pd, perr := outbound.ProxyDialer(u, d)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if perr != nil {
+			return nil, perr
+		}
+		if addrs == nil {
+			return pd(ctx, network, addr)
+		}
+		var c net.Conn
+		var err error
+		for _, addr := range addrs {
+			if c, err = pd(ctx, network, addr); err == nil || ctx.Err() != nil {
+				break
+			}
+		}
+		return c, err
+	}
+}
+
 func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	req.URL.Host = t.addr
 	req.Host = t.hostname
@@ -59,3 +99,9 @@ func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 	return t.RoundTripper.RoundTrip(req)
 }
+
+func (t transport) CloseIdleConnections() {
+	if c, ok := t.RoundTripper.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,28 @@
+package resolver
+
+import "context"
+
+// ResolverFunc is an adapter to use an ordinary function as a Resolver.
+type ResolverFunc func(ctx context.Context, q Query, buf []byte) (n int, i ResolveInfo, err error)
+
+// Resolve implements Resolver interface.
+func (f ResolverFunc) Resolve(ctx context.Context, q Query, buf []byte) (n int, i ResolveInfo, err error) {
+	return f(ctx, q, buf)
+}
+
+// Middleware is a stage of the query processing. It returns a Resolver
+// handling queries before and/or after passing them to next, or answering
+// them without calling next at all.
+type Middleware func(next Resolver) Resolver
+
+// Chain returns r wrapped by mws. The first middleware is the outermost: it
+// is the first to see queries and the last to see responses. Nil middlewares
+// are skipped.
+func Chain(r Resolver, mws ...Middleware) Resolver {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			r = mws[i](r)
+		}
+	}
+	return r
+}
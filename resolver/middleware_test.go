@@ -0,0 +1,55 @@
+package resolver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	stage := func(name string, answer bool) Middleware {
+		return func(next Resolver) Resolver {
+			return ResolverFunc(func(ctx context.Context, q Query, buf []byte) (int, ResolveInfo, error) {
+				calls = append(calls, name)
+				if answer {
+					return 0, ResolveInfo{Source: name}, nil
+				}
+				n, i, err := next.Resolve(ctx, q, buf)
+				calls = append(calls, name+" done")
+				return n, i, err
+			})
+		}
+	}
+	upstream := ResolverFunc(func(ctx context.Context, q Query, buf []byte) (int, ResolveInfo, error) {
+		calls = append(calls, "upstream")
+		return 0, ResolveInfo{Source: "upstream"}, nil
+	})
+	tests := []struct {
+		name       string
+		mws        []Middleware
+		wantSource string
+		wantCalls  []string
+	}{
+		{"empty", nil, "upstream", []string{"upstream"}},
+		{"order", []Middleware{stage("a", false), nil, stage("b", false)}, "upstream",
+			[]string{"a", "b", "upstream", "b done", "a done"}},
+		{"answered", []Middleware{stage("a", false), stage("b", true), stage("c", false)}, "b",
+			[]string{"a", "b", "a done"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			_, i, err := Chain(upstream, tt.mws...).Resolve(context.Background(), Query{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i.Source != tt.wantSource {
+				t.Errorf("Source = %q, want %q", i.Source, tt.wantSource)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
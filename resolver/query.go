@@ -3,6 +3,7 @@ package resolver
 import (
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/nextdns/nextdns/arp"
 	"github.com/nextdns/nextdns/internal/dnsmessage"
@@ -14,6 +15,14 @@ type Query struct {
 	PeerIP  net.IP
 	MAC     net.HardwareAddr
 	Payload []byte
+
+	// ClientSubnet is the client subnet sent in the query as EDNS0
+	// extension, if any. Only full addresses replace PeerIP.
+	ClientSubnet *net.IPNet
+
+	// Instance is the name of the proxy instance the query was received by,
+	// empty for the main listen addresses.
+	Instance string
 }
 
 var typeNames = map[dnsmessage.Type]string{
@@ -32,6 +41,47 @@ var typeNames = map[dnsmessage.Type]string{
 	dnsmessage.TypeMINFO: "MINFO",
 	dnsmessage.TypeAXFR:  "AXFR",
 	dnsmessage.TypeALL:   "ALL",
+
+	dnsmessage.TypeDS:         "DS",
+	dnsmessage.TypeRRSIG:      "RRSIG",
+	dnsmessage.TypeNSEC:       "NSEC",
+	dnsmessage.TypeDNSKEY:     "DNSKEY",
+	dnsmessage.TypeNSEC3:      "NSEC3",
+	dnsmessage.TypeNSEC3PARAM: "NSEC3PARAM",
+
+	dnsmessage.TypeSVCB:  "SVCB",
+	dnsmessage.TypeHTTPS: "HTTPS",
+}
+
+// maxInternedNames is the maximum number of names kept by names before it is
+// reset.
+const maxInternedNames = 4096
+
+// names interns the recently queried names. Most queries are for a small set
+// of names, so parsing them does not allocate the name string.
+var names = &nameCache{m: make(map[string]string)}
+
+type nameCache struct {
+	mu sync.RWMutex
+	m  map[string]string
+}
+
+// get returns b as a string, allocating it only if not already interned.
+func (c *nameCache) get(b []byte) string {
+	c.mu.RLock()
+	s, found := c.m[string(b)]
+	c.mu.RUnlock()
+	if found {
+		return s
+	}
+	s = string(b)
+	c.mu.Lock()
+	if len(c.m) >= maxInternedNames {
+		c.m = make(map[string]string, maxInternedNames)
+	}
+	c.m[s] = s
+	c.mu.Unlock()
+	return s
 }
 
 // NewQuery lasily parses payload and extract the queried name, ip/MAC if
@@ -64,12 +114,9 @@ func NewQuery(payload []byte, peerIP net.IP) (Query, error) {
 }
 
 func (qry *Query) parse() error {
-	const (
-		EDNS0_SUBNET = 0x8
-		EDNS0_MAC    = 0xfde9 // as defined by dnsmasq --add-mac feature
-	)
+	const EDNS0_SUBNET = 0x8
 
-	p := &dnsmessage.Parser{}
+	var p dnsmessage.Parser
 	if _, err := p.Start(qry.Payload); err != nil {
 		return fmt.Errorf("parse query: %v", err)
 	}
@@ -79,7 +126,7 @@ func (qry *Query) parse() error {
 		return fmt.Errorf("parse question: %v", err)
 	}
 	qry.Type = typeNames[q.Type]
-	qry.Name = q.Name.String()
+	qry.Name = names.get(q.Name.Data[:q.Name.Length])
 	_ = p.SkipAllQuestions()
 	_ = p.SkipAllAnswers()
 	_ = p.SkipAllAuthorities()
@@ -92,40 +139,51 @@ func (qry *Query) parse() error {
 			return fmt.Errorf("parse additional: %v", err)
 		}
 		if h.Type == dnsmessage.TypeOPT {
-			opt, err := p.OPTResource()
-			if err != nil {
-				return fmt.Errorf("parse OPT: %v", err)
-			}
-			for _, o := range opt.Options {
-				switch o.Code {
-				case EDNS0_MAC:
-					qry.MAC = net.HardwareAddr(o.Data)
+			// Options alias the payload, overwritten by the response, so
+			// the values kept are copied.
+			err := p.OPTOptions(func(code uint16, data []byte) {
+				switch code {
+				case EDNS0MAC:
+					qry.MAC = append(net.HardwareAddr(nil), data...)
 				case EDNS0_SUBNET:
-					if len(o.Data) < 8 {
-						continue
+					if len(data) < 4 {
+						return
 					}
-					switch o.Data[1] {
-					case 0x1: // IPv4
-						if o.Data[2] != 32 {
-							// Only consider full IPs
-							continue
-						}
-						qry.PeerIP = net.IP(o.Data[4:8])
-					case 0x2: // IPv6
-						if len(o.Data) < 20 {
-							continue
-						}
-						if o.Data[2] != 128 {
-							// Only consider full IPs
-							continue
-						}
-						qry.PeerIP = net.IP(o.Data[4:20])
+					size := net.IPv4len
+					if data[1] == 0x2 { // IPv6
+						size = net.IPv6len
+					} else if data[1] != 0x1 { // IPv4
+						return
+					}
+					qry.ClientSubnet = clientSubnet(data, size)
+					if int(data[2]) == size*8 && len(data) >= 4+size {
+						// Only consider full IPs
+						qry.PeerIP = append(net.IP(nil), data[4:4+size]...)
 					}
 				}
+			})
+			if err != nil {
+				return fmt.Errorf("parse OPT: %v", err)
 			}
 			break
 		}
+		if err := p.SkipAdditional(); err != nil {
+			return fmt.Errorf("parse additional: %v", err)
+		}
 	}
 
 	return nil
 }
+
+// clientSubnet returns the network of the EDNS0 client subnet option data,
+// for an address of size bytes, or nil if the option is invalid.
+func clientSubnet(data []byte, size int) *net.IPNet {
+	prefix := int(data[2])
+	if prefix > size*8 || len(data)-4 > size || len(data)-4 < (prefix+7)/8 {
+		return nil
+	}
+	ip := make(net.IP, size)
+	copy(ip, data[4:])
+	mask := net.CIDRMask(prefix, size*8)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
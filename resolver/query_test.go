@@ -0,0 +1,78 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+func newTestQuery(t testing.TB, name string, opts ...dnsmessage.Option) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  dnsmessage.TypeAAAA,
+		Class: dnsmessage.ClassINET,
+	})
+	if len(opts) > 0 {
+		_ = b.StartAdditionals()
+		var h dnsmessage.ResourceHeader
+		_ = h.SetEDNS0(4096, dnsmessage.RCodeSuccess, false)
+		_ = b.OPTResource(h, dnsmessage.OPTResource{Options: opts})
+	}
+	q, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestNewQuery(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	ecs := []byte{0, 1, 32, 0, 10, 0, 0, 5}
+	tests := []struct {
+		name    string
+		payload []byte
+		peerIP  net.IP
+		mac     net.HardwareAddr
+		subnet  string
+	}{
+		{"Plain", newTestQuery(t, "example.com."), net.IPv4(192, 168, 0, 2), nil, "<nil>"},
+		{"MAC", newTestQuery(t, "example.com.", dnsmessage.Option{Code: 0xfde9, Data: mac}), net.IPv4(192, 168, 0, 2), mac, "<nil>"},
+		{"ECS", newTestQuery(t, "example.com.", dnsmessage.Option{Code: 0x8, Data: ecs}), net.IPv4(10, 0, 0, 5), nil, "10.0.0.5/32"},
+		{"ECSSubnet", newTestQuery(t, "example.com.", dnsmessage.Option{Code: 0x8, Data: []byte{0, 1, 24, 0, 10, 0, 4}}), net.IPv4(192, 168, 0, 2), nil, "10.0.4.0/24"},
+		{"ECSSubnet6", newTestQuery(t, "example.com.", dnsmessage.Option{Code: 0x8, Data: []byte{0, 2, 56, 0, 0x20, 0x01, 0x0d, 0xb8, 0, 1, 0xff}}), net.IPv4(192, 168, 0, 2), nil, "2001:db8:1:ff00::/56"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := NewQuery(tt.payload, net.IPv4(192, 168, 0, 2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Name != "example.com." || q.Type != "AAAA" {
+				t.Errorf("NewQuery() = %s %s, want example.com. AAAA", q.Name, q.Type)
+			}
+			if !q.PeerIP.Equal(tt.peerIP) {
+				t.Errorf("NewQuery() PeerIP = %v, want %v", q.PeerIP, tt.peerIP)
+			}
+			if q.MAC.String() != tt.mac.String() {
+				t.Errorf("NewQuery() MAC = %v, want %v", q.MAC, tt.mac)
+			}
+			if q.ClientSubnet.String() != tt.subnet {
+				t.Errorf("NewQuery() ClientSubnet = %v, want %v", q.ClientSubnet, tt.subnet)
+			}
+		})
+	}
+}
+
+func BenchmarkNewQuery(b *testing.B) {
+	payload := newTestQuery(b, "www.example.com.", dnsmessage.Option{Code: 0xfde9, Data: []byte{0x02, 0, 0, 0, 0, 1}})
+	peer := net.IPv4(192, 168, 0, 2)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewQuery(payload, peer); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
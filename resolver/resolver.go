@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/nextdns/nextdns/internal/dnsmessage"
 	"github.com/nextdns/nextdns/resolver/endpoint"
 )
 
@@ -22,10 +23,28 @@ type DNS struct {
 	DOH     DOH
 	DNS53   DNS53
 	Manager *endpoint.Manager
+
+	// FailClosed returns true if q must be answered with SERVFAIL rather than
+	// being sent to a plain DNS endpoint, i.e. when the plain DNS fallback
+	// would bypass filtering. If nil, queries are always sent.
+	FailClosed func(q Query) bool
 }
 
 type ResolveInfo struct {
 	Transport string
+
+	// Endpoint is the upstream endpoint that answered the query, if any.
+	Endpoint string
+
+	// Source describes where the response comes from when it was generated
+	// locally (i.e. blocked by a rule).
+	Source string
+
+	// Attempts is the number of times the query was sent upstream.
+	Attempts int
+
+	// Timing is the time spent in each stage of the last attempt.
+	Timing Timing
 }
 
 // New instances a DNS53 or DoH resolver for endpoint.
@@ -57,23 +76,76 @@ func New(servers string) (Resolver, error) {
 	}, nil
 }
 
-// Resolve implements Resolver interface.
+// Resolve implements Resolver interface. Failed queries are retried according
+// to the RetryPolicy found in ctx, if any.
 func (r *DNS) Resolve(ctx context.Context, q Query, buf []byte) (n int, i ResolveInfo, err error) {
-	err = r.Manager.Do(ctx, func(e endpoint.Endpoint) error {
-		var err2 error
-		switch e := e.(type) {
-		case *endpoint.DOHEndpoint:
-			if n, i, err2 = r.DOH.resolve(ctx, q, buf, e); err2 != nil {
-				return fmt.Errorf("doh resolve: %v", err2)
+	policy := retryPolicyFrom(ctx)
+	if policy.MaxAttempts > 1 {
+		// A failed attempt can partially write the response into buf, which
+		// can share its memory with the payload.
+		q.Payload = append([]byte(nil), q.Payload...)
+	}
+	attempts, err := policy.do(ctx, func(ctx context.Context, failover int) error {
+		return r.Manager.DoFailover(ctx, failover, func(e endpoint.Endpoint) error {
+			var err2 error
+			Tracef(ctx, "upstream", "sending to %s", e)
+			switch e := e.(type) {
+			case *endpoint.DOHEndpoint:
+				if n, i, err2 = r.DOH.resolve(ctx, q, buf, e); err2 != nil {
+					Tracef(ctx, "upstream", "%s failed: %v", e, err2)
+					return fmt.Errorf("doh resolve: %w", err2)
+				}
+				i.Endpoint = e.String()
+			case *endpoint.DOTEndpoint:
+				if n, err2 = e.Exchange(ctx, q.Payload, buf); err2 != nil {
+					Tracef(ctx, "upstream", "%s failed: %v", e, err2)
+					return fmt.Errorf("dot resolve: %w", err2)
+				}
+				i = ResolveInfo{Transport: "DoT", Endpoint: e.String()}
+			case *endpoint.DNSEndpoint:
+				if r.FailClosed != nil && r.FailClosed(q) {
+					Tracef(ctx, "upstream", "fail-closed: not sent to plain DNS endpoint %s", e)
+					n, err2 = replyServFail(q, buf)
+					i.Source = "fail-closed"
+					return err2
+				}
+				if n, i, err2 = r.DNS53.resolve(ctx, q, buf, e.Addr); err2 != nil {
+					Tracef(ctx, "upstream", "%s failed: %v", e, err2)
+					return fmt.Errorf("dns resolve: %w", err2)
+				}
+				i.Endpoint = e.String()
+			default:
+				return fmt.Errorf("dns resolve: unsupported type: %T", e)
 			}
-		case *endpoint.DNSEndpoint:
-			if n, i, err2 = r.DNS53.resolve(ctx, q, buf, e.Addr); err2 != nil {
-				return fmt.Errorf("dns resolve: %v", err2)
+			if t := i.Timing.String(); t != "" {
+				Tracef(ctx, "upstream", "answered by %s over %s (%s)", e, i.Transport, t)
+			} else {
+				Tracef(ctx, "upstream", "answered by %s over %s", e, i.Transport)
 			}
-		default:
-			return fmt.Errorf("dns resolve: unsupported type: %T", e)
-		}
-		return nil
+			return nil
+		})
 	})
+	i.Attempts = attempts
 	return n, i, err
 }
+
+// replyServFail writes a SERVFAIL response to q into buf.
+func replyServFail(q Query, buf []byte) (int, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return 0, err
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return 0, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = dnsmessage.RCodeServerFailure
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	res, err := b.Finish()
+	return len(res), err
+}
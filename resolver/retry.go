@@ -0,0 +1,116 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy defines how queries failing upstream are retried.
+//
+// Queries failed with a connection level error (i.e. connection refused or
+// reset) are retried right away on the next endpoint. Other errors, like
+// timeouts or HTTP errors, are retried on the same endpoint after an
+// exponential backoff with jitter.
+type RetryPolicy struct {
+	// Timeout is the maximum duration of a query, including its retries.
+	Timeout time.Duration
+
+	// AttemptTimeout is the maximum duration of each attempt. If zero, an
+	// attempt can last up to Timeout.
+	AttemptTimeout time.Duration
+
+	// MaxAttempts is the maximum number of attempts. If zero, queries are
+	// sent only once.
+	MaxAttempts int
+
+	// Backoff is the delay before the second attempt, doubled after each
+	// attempt up to MaxBackoff. A random jitter of up to half the delay is
+	// added.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy returns a context carrying p and bounded by p.Timeout, and
+// its cancel function. Resolvers supporting retries use the policy found in
+// the query context.
+func WithRetryPolicy(ctx context.Context, p RetryPolicy) (context.Context, context.CancelFunc) {
+	if cur, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); !ok || cur != p {
+		ctx = ContextWithRetryPolicy(ctx, p)
+	}
+	if p.Timeout > 0 {
+		return context.WithTimeout(ctx, p.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// ContextWithRetryPolicy returns a copy of ctx carrying p. Unlike
+// WithRetryPolicy, it does not bound ctx so it can be created once as the
+// parent of all the query contexts, sparing WithRetryPolicy an allocation per
+// query.
+func ContextWithRetryPolicy(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, p)
+}
+
+func retryPolicyFrom(ctx context.Context) RetryPolicy {
+	p, _ := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+	return p
+}
+
+// backoff returns the delay to wait before the attempt number attempt
+// (starting at 1 for the first retry).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// do calls attempt until it succeeds, MaxAttempts is reached or ctx is done.
+// The failover argument of attempt is the number of endpoints to skip after
+// the active one. It returns the number of attempts made.
+func (p RetryPolicy) do(ctx context.Context, attempt func(ctx context.Context, failover int) error) (attempts int, err error) {
+	failover := 0
+	for {
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if p.AttemptTimeout > 0 {
+			actx, cancel = context.WithTimeout(ctx, p.AttemptTimeout)
+		}
+		err = attempt(actx, failover)
+		cancel()
+		attempts++
+		if err == nil || attempts >= p.MaxAttempts || ctx.Err() != nil {
+			return attempts, err
+		}
+		if isConnError(err) {
+			failover++
+			continue
+		}
+		select {
+		case <-time.After(p.backoff(attempts)):
+		case <-ctx.Done():
+			return attempts, err
+		}
+	}
+}
+
+// isConnError returns true if err is a connection level error, other than a
+// timeout.
+func isConnError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return !opErr.Timeout()
+	}
+	return false
+}
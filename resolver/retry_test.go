@@ -0,0 +1,55 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_do(t *testing.T) {
+	connErr := &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+	appErr := errors.New("error code: 500")
+	tests := []struct {
+		name         string
+		errs         []error
+		maxAttempts  int
+		wantFailover []int
+		wantErr      error
+	}{
+		{"success", []error{nil}, 3, []int{0}, nil},
+		{"no retry", []error{appErr}, 0, []int{0}, appErr},
+		{"conn error failover", []error{connErr, connErr, nil}, 3, []int{0, 1, 2}, nil},
+		{"app error same endpoint", []error{appErr, nil}, 3, []int{0, 0}, nil},
+		{"mixed", []error{connErr, appErr, appErr}, 3, []int{0, 1, 1}, appErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := RetryPolicy{MaxAttempts: tt.maxAttempts, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+			var failovers []int
+			attempts, err := p.do(context.Background(), func(ctx context.Context, failover int) error {
+				failovers = append(failovers, failover)
+				return tt.errs[len(failovers)-1]
+			})
+			if err != tt.wantErr {
+				t.Errorf("do() err = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != len(tt.wantFailover) || !reflect.DeepEqual(failovers, tt.wantFailover) {
+				t.Errorf("do() attempts = %d failovers = %v, want %v", attempts, failovers, tt.wantFailover)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		if got := p.backoff(attempt + 1); got < want || got > want+want/2 {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", attempt+1, got, want, want+want/2)
+		}
+	}
+}
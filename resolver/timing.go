@@ -0,0 +1,102 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timing is the time spent in each stage of the last attempt to send a query
+// upstream. Stages not reached are zero.
+type Timing struct {
+	// Connect is the time spent acquiring a connection, including the dial
+	// and the TLS handshake when no idle connection could be reused.
+	Connect time.Duration
+
+	// Wait is the time between the query being sent and the first byte of
+	// the response.
+	Wait time.Duration
+
+	// Read is the time spent reading the response body.
+	Read time.Duration
+}
+
+// String returns the non-zero stages, i.e. "connect=12ms wait=20ms".
+func (t Timing) String() string {
+	var s string
+	for _, st := range []struct {
+		name string
+		d    time.Duration
+	}{{"connect", t.Connect}, {"wait", t.Wait}, {"read", t.Read}} {
+		if st.d <= 0 {
+			continue
+		}
+		if s != "" {
+			s += " "
+		}
+		s += fmt.Sprintf("%s=%dms", st.name, st.d/time.Millisecond)
+	}
+	return s
+}
+
+// stageTrace measures the stages of an HTTP exchange.
+type stageTrace struct {
+	mu        sync.Mutex
+	start     time.Time
+	connected time.Time
+	wrote     time.Time
+	timing    Timing
+}
+
+// withTrace returns ctx with a trace recording the stages reached by the
+// request it is used for.
+func (s *stageTrace) withTrace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			s.mu.Lock()
+			s.start = time.Now()
+			s.mu.Unlock()
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			s.mu.Lock()
+			s.connected = time.Now()
+			s.timing.Connect = s.connected.Sub(s.start)
+			s.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			s.mu.Lock()
+			s.wrote = time.Now()
+			s.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			s.mu.Lock()
+			if !s.wrote.IsZero() {
+				s.timing.Wait = time.Since(s.wrote)
+			}
+			s.mu.Unlock()
+		},
+	})
+}
+
+// Timing returns the stages measured so far.
+func (s *stageTrace) Timing() Timing {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.timing
+}
+
+// err returns err prefixed with the stage the exchange was in when it failed,
+// so timeouts can be told apart.
+func (s *stageTrace) err(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stage := "connect"
+	if !s.wrote.IsZero() {
+		stage = "wait"
+	} else if !s.connected.IsZero() {
+		stage = "write"
+	}
+	return fmt.Errorf("%s: %w", stage, err)
+}
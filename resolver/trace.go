@@ -0,0 +1,79 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Trace records the decisions taken by the resolvers for a query, to debug
+// how a query is answered.
+type Trace struct {
+	// NoCache specifies that the answer must not come from a cache, so the
+	// decisions of the resolvers behind it are recorded.
+	NoCache bool
+
+	mu    sync.Mutex
+	start time.Time
+	steps []TraceStep
+}
+
+// TraceStep is a decision taken by a resolver.
+type TraceStep struct {
+	// Elapsed is the time since the start of the trace.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Stage is the resolver taking the decision, i.e. "cache".
+	Stage string `json:"stage"`
+
+	// Message describes the decision.
+	Message string `json:"message"`
+}
+
+type traceKey struct{}
+
+// WithTrace returns a copy of ctx recording the decisions of the resolvers
+// into t.
+func WithTrace(ctx context.Context, t *Trace) context.Context {
+	t.mu.Lock()
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	t.mu.Unlock()
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// TraceFrom returns the trace of ctx, or nil if the query is not traced.
+func TraceFrom(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// Tracef records a decision of stage into the trace of ctx, if any.
+func Tracef(ctx context.Context, stage, format string, a ...interface{}) {
+	if t := TraceFrom(ctx); t != nil {
+		t.Add(stage, fmt.Sprintf(format, a...))
+	}
+}
+
+// Add records a decision of stage.
+func (t *Trace) Add(stage, msg string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	t.steps = append(t.steps, TraceStep{
+		Elapsed: time.Since(t.start),
+		Stage:   stage,
+		Message: msg,
+	})
+}
+
+// Steps returns the recorded decisions in order.
+func (t *Trace) Steps() []TraceStep {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TraceStep(nil), t.steps...)
+}
@@ -0,0 +1,478 @@
+package rewrite
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// ResponseRule defines a modification applied to responses before they are
+// sent to the client.
+type ResponseRule struct {
+	// Pattern is the query name pattern the rule applies to.
+	Pattern string
+
+	// Type restricts the rule to a query type (i.e. A). Any type if empty.
+	Type string
+
+	// Answer restricts the rule to responses containing an address in this
+	// network. Replace and Drop only affect records in this network.
+	Answer *net.IPNet
+
+	// Replace is the address A and AAAA records are replaced with.
+	Replace net.IP
+
+	// Drop specifies that answer records are removed.
+	Drop bool
+
+	// MinTTL and MaxTTL clamp the TTL of all records if not zero.
+	MinTTL uint32
+	MaxTTL uint32
+
+	// Add holds the records added to the answer section as TYPE:value (i.e.
+	// A:10.0.0.1, CNAME:example.com or TXT:text).
+	Add []string
+
+	pattern
+	add []dnsmessage.ResourceBody
+}
+
+// ParseResponseRule parses a rule definition composed of a name pattern
+// followed by space separated key=value parameters:
+//
+//   type=A               only apply to A queries
+//   answer=10.0.0.0/8    only apply to responses with an address in this network
+//   replace=192.168.0.1  replace the A or AAAA records with this address
+//   drop                 remove the answer records
+//   ttl-min=60           raise TTLs lower than 60 seconds
+//   ttl-max=3600         lower TTLs higher than 3600 seconds
+//   add=A:10.0.0.1       add a record (A, AAAA, CNAME or TXT)
+//
+// The name pattern uses the same syntax as rewrite rules.
+func ParseResponseRule(s string) (ResponseRule, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return ResponseRule{}, fmt.Errorf("%s: invalid response rule: missing action", s)
+	}
+	r := ResponseRule{Pattern: fields[0]}
+	var err error
+	if r.pattern, err = parsePattern(r.Pattern); err != nil {
+		return ResponseRule{}, err
+	}
+	for _, f := range fields[1:] {
+		k, v := f, ""
+		if idx := strings.IndexByte(f, '='); idx != -1 {
+			k, v = f[:idx], f[idx+1:]
+		}
+		switch k {
+		case "type":
+			if _, ok := types[strings.ToUpper(v)]; !ok {
+				return ResponseRule{}, fmt.Errorf("%s: invalid query type", v)
+			}
+			r.Type = strings.ToUpper(v)
+		case "answer":
+			if r.Answer, err = parseNet(v); err != nil {
+				return ResponseRule{}, err
+			}
+		case "replace":
+			if r.Replace = net.ParseIP(v); r.Replace == nil {
+				return ResponseRule{}, fmt.Errorf("%s: invalid address", v)
+			}
+			if ip4 := r.Replace.To4(); ip4 != nil {
+				r.Replace = ip4
+			}
+		case "drop":
+			r.Drop = true
+		case "ttl-min", "ttl-max":
+			ttl, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return ResponseRule{}, fmt.Errorf("%s: invalid TTL", v)
+			}
+			if k == "ttl-min" {
+				r.MinTTL = uint32(ttl)
+			} else {
+				r.MaxTTL = uint32(ttl)
+			}
+		case "add":
+			body, err := parseRecord(v)
+			if err != nil {
+				return ResponseRule{}, err
+			}
+			r.Add = append(r.Add, v)
+			r.add = append(r.add, body)
+		default:
+			return ResponseRule{}, fmt.Errorf("%s: unknown response rule parameter", k)
+		}
+	}
+	return r, nil
+}
+
+func (r ResponseRule) String() string {
+	s := []string{r.Pattern}
+	if r.Type != "" {
+		s = append(s, "type="+r.Type)
+	}
+	if r.Answer != nil {
+		if ones, bits := r.Answer.Mask.Size(); ones == bits {
+			s = append(s, "answer="+r.Answer.IP.String())
+		} else {
+			s = append(s, "answer="+r.Answer.String())
+		}
+	}
+	if r.Replace != nil {
+		s = append(s, "replace="+r.Replace.String())
+	}
+	if r.Drop {
+		s = append(s, "drop")
+	}
+	if r.MinTTL > 0 {
+		s = append(s, fmt.Sprintf("ttl-min=%d", r.MinTTL))
+	}
+	if r.MaxTTL > 0 {
+		s = append(s, fmt.Sprintf("ttl-max=%d", r.MaxTTL))
+	}
+	for _, a := range r.Add {
+		s = append(s, "add="+a)
+	}
+	return strings.Join(s, " ")
+}
+
+var types = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"TXT":   dnsmessage.TypeTXT,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SRV":   dnsmessage.TypeSRV,
+	"SOA":   dnsmessage.TypeSOA,
+	"SVCB":  dnsmessage.TypeSVCB,
+	"HTTPS": dnsmessage.TypeHTTPS,
+}
+
+func parseNet(s string) (*net.IPNet, error) {
+	if strings.IndexByte(s, '/') != -1 {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid network", s)
+		}
+		return n, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%s: invalid address", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func parseRecord(s string) (dnsmessage.ResourceBody, error) {
+	idx := strings.IndexByte(s, ':')
+	if idx == -1 {
+		return nil, fmt.Errorf("%s: invalid record: missing type", s)
+	}
+	typ, v := strings.ToUpper(s[:idx]), s[idx+1:]
+	switch typ {
+	case "A", "AAAA":
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("%s: invalid address", v)
+		}
+		if ip4 := ip.To4(); typ == "A" && ip4 != nil {
+			var a [4]byte
+			copy(a[:], ip4)
+			return &dnsmessage.AResource{A: a}, nil
+		} else if typ == "AAAA" && ip4 == nil {
+			var aaaa [16]byte
+			copy(aaaa[:], ip)
+			return &dnsmessage.AAAAResource{AAAA: aaaa}, nil
+		}
+		return nil, fmt.Errorf("%s: address does not match type %s", v, typ)
+	case "CNAME":
+		n, err := dnsmessage.NewName(fqdn(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", v, err)
+		}
+		return &dnsmessage.CNAMEResource{CNAME: n}, nil
+	case "TXT":
+		return &dnsmessage.TXTResource{TXT: []string{v}}, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported record type", typ)
+}
+
+// matchQuestion returns true if the rule applies to q.
+func (r ResponseRule) matchQuestion(q dnsmessage.Question) bool {
+	if r.Type != "" && types[r.Type] != q.Type {
+		return false
+	}
+	_, ok := r.match(q.Name.String())
+	return ok
+}
+
+// inAnswer returns true if rr is an address record in the Answer network, or
+// an SVCB or HTTPS record with an address hint in it, or if the rule has no
+// Answer restriction.
+func (r ResponseRule) inAnswer(rr dnsmessage.Resource) bool {
+	if r.Answer == nil {
+		return true
+	}
+	switch b := rr.Body.(type) {
+	case *dnsmessage.AResource:
+		return r.Answer.Contains(net.IP(b.A[:]))
+	case *dnsmessage.AAAAResource:
+		return r.Answer.Contains(net.IP(b.AAAA[:]))
+	}
+	if sb := svcb(rr); sb != nil {
+		for _, ip := range svcbHints(sb) {
+			if r.Answer.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// apply modifies m according to the rule and returns true if m was changed.
+func (r ResponseRule) apply(m *dnsmessage.Message) (changed bool) {
+	q := m.Questions[0]
+	if r.Answer != nil {
+		found := false
+		for _, rr := range m.Answers {
+			if r.inAnswer(rr) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	answers := m.Answers[:0]
+	for _, rr := range m.Answers {
+		if !r.inAnswer(rr) {
+			answers = append(answers, rr)
+			continue
+		}
+		if r.Drop {
+			changed = true
+			continue
+		}
+		if r.Replace != nil {
+			switch b := rr.Body.(type) {
+			case *dnsmessage.AResource:
+				if len(r.Replace) == net.IPv4len {
+					copy(b.A[:], r.Replace)
+					changed = true
+				}
+			case *dnsmessage.AAAAResource:
+				if len(r.Replace) == net.IPv6len {
+					copy(b.AAAA[:], r.Replace)
+					changed = true
+				}
+			}
+			// Replace the address hints of the same family so clients
+			// connecting using them get the same address.
+			if sb := svcb(rr); sb != nil {
+				key := dnsmessage.SVCParamIPv4Hint
+				if len(r.Replace) == net.IPv6len {
+					key = dnsmessage.SVCParamIPv6Hint
+				}
+				if _, found := sb.Param(key); found {
+					sb.SetParam(key, append([]byte(nil), r.Replace...))
+					changed = true
+				}
+			}
+		}
+		answers = append(answers, rr)
+	}
+	m.Answers = answers
+	for _, body := range r.add {
+		m.Answers = append(m.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Class: q.Class, TTL: localTTL},
+			Body:   body,
+		})
+		changed = true
+	}
+	if clampTTL(m, r.MinTTL, r.MaxTTL) {
+		changed = true
+	}
+	return changed
+}
+
+// clampTTL raises the TTL of the records of m lower than min and lowers those
+// higher than max, if not zero. It returns true if m was changed.
+func clampTTL(m *dnsmessage.Message, min, max uint32) (changed bool) {
+	if min == 0 && max == 0 {
+		return false
+	}
+	for _, rrs := range [][]dnsmessage.Resource{m.Answers, m.Authorities, m.Additionals} {
+		for i := range rrs {
+			h := &rrs[i].Header
+			if h.Type == dnsmessage.TypeOPT {
+				continue
+			}
+			if min > 0 && h.TTL < min {
+				h.TTL = min
+				changed = true
+			}
+			if max > 0 && h.TTL > max {
+				h.TTL = max
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// TTLClamp clamps the TTL of the records of all the responses sent to
+// clients, independently of the cache.
+type TTLClamp struct {
+	// Min and Max are the bounds of the TTLs in seconds, if not zero.
+	Min uint32
+	Max uint32
+
+	// Exclude are the zones (i.e. example.com) whose responses are left
+	// untouched, with their sub-domains.
+	Exclude []string
+}
+
+// excluded returns true if name is in one of the Exclude zones.
+func (c TTLClamp) excluded(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, zone := range c.Exclude {
+		zone = strings.TrimSuffix(strings.ToLower(zone), ".")
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply clamps the TTLs of the response stored in buf[:n] and writes it back
+// into buf. It returns the new size of the response.
+func (c TTLClamp) Apply(buf []byte, n int) (int, error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(buf[:n]); err != nil {
+		return n, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return n, nil
+	}
+	if c.excluded(q.Name.String()) {
+		return n, nil
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(buf[:n]); err != nil {
+		return n, err
+	}
+	if !clampTTL(&m, c.Min, c.Max) {
+		return n, nil
+	}
+	b, err := m.AppendPack(buf[:0])
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// DropAAAA removes the AAAA records of the response to a AAAA query stored in
+// buf[:n], turning it into a NODATA response, and writes it back into buf. The
+// ipv6hint parameters and additional AAAA records of responses to SVCB and
+// HTTPS queries are removed too. Other responses are left untouched. It
+// returns the new size of the response.
+func DropAAAA(buf []byte, n int) (int, error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(buf[:n]); err != nil {
+		return n, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return n, nil
+	}
+	switch q.Type {
+	case dnsmessage.TypeAAAA, dnsmessage.TypeSVCB, dnsmessage.TypeHTTPS:
+	default:
+		return n, nil
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(buf[:n]); err != nil {
+		return n, err
+	}
+	changed := false
+	for _, rrs := range []*[]dnsmessage.Resource{&m.Answers, &m.Additionals} {
+		kept := (*rrs)[:0]
+		for _, rr := range *rrs {
+			// Other records, i.e. the CNAME chain, are kept.
+			if rr.Header.Type == dnsmessage.TypeAAAA {
+				changed = true
+				continue
+			}
+			if sb := svcb(rr); sb != nil && sb.DeleteParam(dnsmessage.SVCParamIPv6Hint) {
+				changed = true
+			}
+			kept = append(kept, rr)
+		}
+		*rrs = kept
+	}
+	if !changed {
+		return n, nil
+	}
+	b, err := m.AppendPack(buf[:0])
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// RewriteResponse applies the rules matching the response stored in buf[:n]
+// and writes the modified response back into buf. All matching rules are
+// applied in order. It returns the new size of the response.
+func RewriteResponse(rules []ResponseRule, buf []byte, n int) (int, error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(buf[:n]); err != nil {
+		return n, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return n, nil
+	}
+	var matched []ResponseRule
+	for _, r := range rules {
+		if r.matchQuestion(q) {
+			matched = append(matched, r)
+		}
+	}
+	if len(matched) == 0 {
+		return n, nil
+	}
+
+	var m dnsmessage.Message
+	if err := m.Unpack(buf[:n]); err != nil {
+		return n, err
+	}
+	changed := false
+	for _, r := range matched {
+		if r.apply(&m) {
+			changed = true
+		}
+	}
+	if !changed {
+		return n, nil
+	}
+	b, err := m.AppendPack(buf[:0])
+	if err != nil {
+		return 0, err
+	}
+	if len(b) > len(buf) {
+		return 0, errors.New("rewrite: response too large")
+	}
+	return len(b), nil
+}
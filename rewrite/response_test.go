@@ -0,0 +1,162 @@
+package rewrite
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+func testResponse(t *testing.T) []byte {
+	t.Helper()
+	name := dnsmessage.MustNewName("www.example.com.")
+	hdr := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 1000}
+	m := dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+		},
+		Answers: []dnsmessage.Resource{
+			{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}},
+			{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{8, 8, 8, 8}}},
+		},
+	}
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func answers(t *testing.T, b []byte) string {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	var s []string
+	for _, rr := range m.Answers {
+		var v string
+		switch b := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			v = fmt.Sprintf("%d.%d.%d.%d", b.A[0], b.A[1], b.A[2], b.A[3])
+		case *dnsmessage.TXTResource:
+			v = strings.Join(b.TXT, " ")
+		}
+		s = append(s, fmt.Sprintf("%s/%d", v, rr.Header.TTL))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestRewriteResponse(t *testing.T) {
+	tests := []struct {
+		rules []string
+		want  string
+	}{
+		{[]string{"example.com drop"}, "10.0.0.1/1000 8.8.8.8/1000"},
+		{[]string{"*.example.com type=AAAA drop"}, "10.0.0.1/1000 8.8.8.8/1000"},
+		{[]string{"*.example.com answer=10.0.0.0/8 drop"}, "8.8.8.8/1000"},
+		{[]string{"*.example.com drop"}, ""},
+		{[]string{"*.example.com answer=10.0.0.1 replace=192.168.0.1"}, "192.168.0.1/1000 8.8.8.8/1000"},
+		{[]string{"*.example.com answer=172.16.0.0/12 replace=192.168.0.1"}, "10.0.0.1/1000 8.8.8.8/1000"},
+		{[]string{"*.example.com ttl-max=300"}, "10.0.0.1/300 8.8.8.8/300"},
+		{[]string{"*.example.com ttl-min=3600"}, "10.0.0.1/3600 8.8.8.8/3600"},
+		{[]string{"www.example.com add=TXT:hello"}, "10.0.0.1/1000 8.8.8.8/1000 hello/60"},
+		{[]string{"/^www\\./ type=A answer=8.8.8.8 drop", "*.example.com ttl-max=10"}, "10.0.0.1/10"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.rules, ","), func(t *testing.T) {
+			var rules []ResponseRule
+			for _, s := range tt.rules {
+				r, err := ParseResponseRule(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.String() != s {
+					t.Errorf("String() = %q, want %q", r.String(), s)
+				}
+				rules = append(rules, r)
+			}
+			resp := testResponse(t)
+			buf := make([]byte, 512)
+			copy(buf, resp)
+			n, err := RewriteResponse(rules, buf, len(resp))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := answers(t, buf[:n]); got != tt.want {
+				t.Errorf("RewriteResponse() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTTLClamp_Apply(t *testing.T) {
+	tests := []struct {
+		name  string
+		clamp TTLClamp
+		want  string
+	}{
+		{"min", TTLClamp{Min: 3600}, "10.0.0.1/3600 8.8.8.8/3600"},
+		{"max", TTLClamp{Max: 300}, "10.0.0.1/300 8.8.8.8/300"},
+		{"in range", TTLClamp{Min: 60, Max: 3600}, "10.0.0.1/1000 8.8.8.8/1000"},
+		{"excluded", TTLClamp{Max: 300, Exclude: []string{"Example.com."}}, "10.0.0.1/1000 8.8.8.8/1000"},
+		{"other zone", TTLClamp{Max: 300, Exclude: []string{"ample.com"}}, "10.0.0.1/300 8.8.8.8/300"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := testResponse(t)
+			buf := make([]byte, 512)
+			copy(buf, resp)
+			n, err := tt.clamp.Apply(buf, len(resp))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := answers(t, buf[:n]); got != tt.want {
+				t.Errorf("Apply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropAAAA(t *testing.T) {
+	name := dnsmessage.MustNewName("www.example.com.")
+	target := dnsmessage.MustNewName("cdn.example.net.")
+	tests := []struct {
+		typ  dnsmessage.Type
+		want int // remaining answers
+	}{
+		{dnsmessage.TypeAAAA, 1},
+		{dnsmessage.TypeA, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ.String(), func(t *testing.T) {
+			hdr := dnsmessage.ResourceHeader{Name: target, Class: dnsmessage.ClassINET, TTL: 60}
+			m := dnsmessage.Message{
+				Header:    dnsmessage.Header{Response: true},
+				Questions: []dnsmessage.Question{{Name: name, Type: tt.typ, Class: dnsmessage.ClassINET}},
+				Answers: []dnsmessage.Resource{
+					{Header: dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 60}, Body: &dnsmessage.CNAMEResource{CNAME: target}},
+					{Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0xd, 0xb8, 15: 1}}},
+					{Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0xd, 0xb8, 15: 2}}},
+				},
+			}
+			buf, err := m.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := DropAAAA(buf, len(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got dnsmessage.Message
+			if err := got.Unpack(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Answers) != tt.want {
+				t.Errorf("%d answers, want %d", len(got.Answers), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+// Package rewrite implements query name rewriting based on wildcard and
+// regular expression rules.
+package rewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// localTTL is the TTL used for locally generated records.
+const localTTL = 60
+
+// Resolver rewrites queries matching Rules before sending them to Upstream.
+// Queries not matching any rule are sent to Upstream untouched.
+type Resolver struct {
+	// Rules is the list of rewrite rules. The first matching rule is used.
+	Rules []Rule
+
+	// Upstream is the resolver used to resolve rewritten and non matching
+	// queries.
+	Upstream resolver.Resolver
+
+	// ExpandAddr specifies an optional function applied to the addresses of
+	// address rules before they are returned (i.e. to complete IPv6
+	// addresses relative to the current delegated prefix). Addresses for
+	// which it returns nil are omitted.
+	ExpandAddr func(ip net.IP) net.IP
+
+	// SearchDomain specifies an optional function returning the search domain
+	// of the client sending q. Address queries for single-label names not
+	// matching any rule are resolved as the name followed by this domain.
+	SearchDomain func(q resolver.Query) string
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	for _, rule := range r.Rules {
+		target, ok := rule.Match(q.Name)
+		if !ok {
+			continue
+		}
+		resolver.Tracef(ctx, "rewrite", "matched rule %s", rule)
+		switch {
+		case len(rule.Addrs) > 0:
+			return replyAddrs(q, r.expand(rule.Addrs), buf)
+		case rule.Flatten:
+			return r.resolveFlatten(ctx, q, buf)
+		default:
+			return r.resolveTarget(ctx, q, target, buf)
+		}
+	}
+	if r.SearchDomain != nil && (q.Type == "A" || q.Type == "AAAA") && isSingleLabel(q.Name) {
+		if domain := r.SearchDomain(q); domain != "" {
+			resolver.Tracef(ctx, "rewrite", "search domain %s", domain)
+			return r.resolveTarget(ctx, q, fqdn(q.Name)+fqdn(domain), buf)
+		}
+	}
+	return r.Upstream.Resolve(ctx, q, buf)
+}
+
+// isSingleLabel returns true if name has a single label (i.e. nas.).
+func isSingleLabel(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	return name != "" && !strings.Contains(name, ".")
+}
+
+func (r *Resolver) expand(addrs []net.IP) []net.IP {
+	if r.ExpandAddr == nil {
+		return addrs
+	}
+	expanded := make([]net.IP, 0, len(addrs))
+	for _, ip := range addrs {
+		if ip = r.ExpandAddr(ip); ip != nil {
+			expanded = append(expanded, ip)
+		}
+	}
+	return expanded
+}
+
+// replyAddrs answers q with addrs matching the query type.
+func replyAddrs(q resolver.Query, addrs []net.IP, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var m dnsmessage.Message
+	if err = m.Unpack(q.Payload); err != nil {
+		return 0, i, err
+	}
+	if len(m.Questions) == 0 {
+		return 0, i, errors.New("rewrite: no question")
+	}
+	q1 := m.Questions[0]
+	m.Header.Response = true
+	m.Header.RecursionAvailable = true
+	m.Header.RCode = dnsmessage.RCodeSuccess
+	m.Answers, m.Authorities = nil, nil
+	hdr := dnsmessage.ResourceHeader{Name: q1.Name, Class: q1.Class, TTL: localTTL}
+	for _, ip := range addrs {
+		switch {
+		case q1.Type == dnsmessage.TypeA && len(ip) == net.IPv4len:
+			var a [4]byte
+			copy(a[:], ip)
+			m.Answers = append(m.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AResource{A: a}})
+		case q1.Type == dnsmessage.TypeAAAA && len(ip) == net.IPv6len:
+			var aaaa [16]byte
+			copy(aaaa[:], ip)
+			m.Answers = append(m.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: aaaa}})
+		}
+	}
+	return pack(&m, buf)
+}
+
+// resolveTarget resolves target instead of the query name and returns the
+// result prefixed by a CNAME from the query name to target.
+func (r *Resolver) resolveTarget(ctx context.Context, q resolver.Query, target string, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var qm dnsmessage.Message
+	if err = qm.Unpack(q.Payload); err != nil {
+		return 0, i, err
+	}
+	if len(qm.Questions) == 0 {
+		return 0, i, errors.New("rewrite: no question")
+	}
+	orig := qm.Questions[0]
+	tn, err := dnsmessage.NewName(target)
+	if err != nil {
+		return 0, i, fmt.Errorf("rewrite: %s: %v", target, err)
+	}
+	qm.Questions[0].Name = tn
+	payload, err := qm.Pack()
+	if err != nil {
+		return 0, i, err
+	}
+	tq := q
+	tq.Name = target
+	tq.Payload = payload
+	n, i, err = r.Upstream.Resolve(ctx, tq, buf)
+	if err != nil || n <= 0 {
+		return n, i, err
+	}
+
+	var m dnsmessage.Message
+	if err = m.Unpack(buf[:n]); err != nil {
+		return 0, i, err
+	}
+	if len(m.Questions) == 0 {
+		return n, i, nil
+	}
+	m.Questions[0] = orig
+	cname := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: orig.Name, Class: orig.Class, TTL: localTTL},
+		Body:   &dnsmessage.CNAMEResource{CNAME: tn},
+	}
+	m.Answers = append([]dnsmessage.Resource{cname}, m.Answers...)
+	n, _, err = pack(&m, buf)
+	return n, i, err
+}
+
+// resolveFlatten resolves q and collapses any CNAME chain in the response so
+// final records are returned directly for the query name.
+func (r *Resolver) resolveFlatten(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	n, i, err = r.Upstream.Resolve(ctx, q, buf)
+	if err != nil || n <= 0 {
+		return n, i, err
+	}
+	var m dnsmessage.Message
+	if err = m.Unpack(buf[:n]); err != nil {
+		return 0, i, err
+	}
+	if len(m.Questions) == 0 {
+		return n, i, nil
+	}
+	q1 := m.Questions[0]
+	if q1.Type == dnsmessage.TypeCNAME {
+		return n, i, nil
+	}
+	answers := make([]dnsmessage.Resource, 0, len(m.Answers))
+	minTTL := ^uint32(0)
+	flattened := false
+	for _, rr := range m.Answers {
+		if rr.Header.TTL < minTTL {
+			minTTL = rr.Header.TTL
+		}
+		if rr.Header.Type == dnsmessage.TypeCNAME {
+			flattened = true
+			continue
+		}
+		if rr.Header.Type == q1.Type {
+			answers = append(answers, rr)
+		}
+	}
+	if !flattened {
+		return n, i, nil
+	}
+	for j := range answers {
+		answers[j].Header.Name = q1.Name
+		answers[j].Header.TTL = minTTL
+	}
+	m.Answers = answers
+	n, _, err = pack(&m, buf)
+	return n, i, err
+}
+
+func pack(m *dnsmessage.Message, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	b, err := m.AppendPack(buf[:0])
+	if err != nil {
+		return 0, i, err
+	}
+	if len(b) > len(buf) {
+		return 0, i, errors.New("rewrite: response too large")
+	}
+	return len(b), i, nil
+}
@@ -0,0 +1,104 @@
+package rewrite
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+func TestResolver_SearchDomain(t *testing.T) {
+	rule, err := ParseRule("printer=printer.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upstreamName string
+	r := &Resolver{
+		Rules: []Rule{rule},
+		Upstream: resolver.ResolverFunc(func(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+			upstreamName = q.Name
+			return copy(buf, q.Payload), resolver.ResolveInfo{}, nil
+		}),
+		SearchDomain: func(q resolver.Query) string {
+			if q.PeerIP.Equal(net.ParseIP("10.0.3.1")) {
+				return "iot.lan"
+			}
+			return "lan."
+		},
+	}
+	tests := []struct {
+		name   string
+		qtype  dnsmessage.Type
+		peerIP string
+		want   string
+	}{
+		{"nas.", dnsmessage.TypeA, "10.0.0.1", "nas.lan."},
+		{"nas.", dnsmessage.TypeAAAA, "10.0.3.1", "nas.iot.lan."},
+		{"nas.example.com.", dnsmessage.TypeA, "10.0.0.1", "nas.example.com."},
+		{"com.", dnsmessage.TypeNS, "10.0.0.1", "com."},
+		{"printer.", dnsmessage.TypeA, "10.0.0.1", "printer.example.com."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+tt.qtype.String(), func(t *testing.T) {
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+			_ = b.StartQuestions()
+			_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(tt.name), Type: tt.qtype, Class: dnsmessage.ClassINET})
+			payload, err := b.Finish()
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := resolver.NewQuery(payload, net.ParseIP(tt.peerIP))
+			if err != nil {
+				t.Fatal(err)
+			}
+			upstreamName = ""
+			if _, _, err := r.Resolve(context.Background(), q, make([]byte, 512)); err != nil {
+				t.Fatal(err)
+			}
+			if upstreamName != tt.want {
+				t.Errorf("upstream name = %q, want %q", upstreamName, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver_TargetNoQuestion(t *testing.T) {
+	rule, err := ParseRule("printer.lan=printer.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Resolver{
+		Rules: []Rule{rule},
+		Upstream: resolver.ResolverFunc(func(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+			// Some servers answer FORMERR or SERVFAIL with no question.
+			b := dnsmessage.NewBuilder(buf[:0], dnsmessage.Header{Response: true, RCode: dnsmessage.RCodeServerFailure})
+			msg, err := b.Finish()
+			return len(msg), resolver.ResolveInfo{}, err
+		}),
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("printer.lan."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := resolver.NewQuery(payload, net.ParseIP("10.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 512)
+	n, _, err := r.Resolve(context.Background(), q, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if m.Header.RCode != dnsmessage.RCodeServerFailure {
+		t.Errorf("rcode = %v, want %v", m.Header.RCode, dnsmessage.RCodeServerFailure)
+	}
+}
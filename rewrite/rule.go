@@ -0,0 +1,167 @@
+package rewrite
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rule defines a query name rewrite.
+type Rule struct {
+	// Pattern is the query name pattern the rule applies to.
+	Pattern string
+
+	// Target is the name queries are rewritten to. Target is empty for
+	// address and flatten rules.
+	Target string
+
+	// Addrs holds the addresses returned for address rules.
+	Addrs []net.IP
+
+	// Flatten specifies that CNAME chains in upstream responses are
+	// collapsed so final records are returned for the query name.
+	Flatten bool
+
+	pattern
+}
+
+// pattern matches query names against a domain, a wildcard or a regular
+// expression.
+type pattern struct {
+	exact  string
+	suffix string
+	glob   string
+	re     *regexp.Regexp
+}
+
+func parsePattern(s string) (pattern, error) {
+	var pt pattern
+	p := strings.ToLower(s)
+	switch {
+	case len(p) > 2 && p[0] == '/' && p[len(p)-1] == '/':
+		re, err := regexp.Compile("(?i)" + s[1:len(s)-1])
+		if err != nil {
+			return pattern{}, fmt.Errorf("%s: invalid rewrite pattern: %v", s, err)
+		}
+		pt.re = re
+	case strings.HasPrefix(p, "*.") && !strings.ContainsAny(p[2:], "*?["):
+		pt.suffix = fqdn(p[1:])
+	case strings.ContainsAny(p, "*?["):
+		pt.glob = fqdn(p)
+	default:
+		pt.exact = fqdn(p)
+	}
+	return pt, nil
+}
+
+// match returns true if name matches the pattern. With a regular expression,
+// the sub-match indexes are returned in m.
+func (pt pattern) match(name string) (m []int, ok bool) {
+	name = fqdn(strings.ToLower(name))
+	switch {
+	case pt.re != nil:
+		m = pt.re.FindStringSubmatchIndex(strings.TrimSuffix(name, "."))
+		return m, m != nil
+	case pt.suffix != "":
+		return nil, strings.HasSuffix(name, pt.suffix) && len(name) > len(pt.suffix)
+	case pt.glob != "":
+		ok, _ = path.Match(pt.glob, name)
+		return nil, ok
+	}
+	return nil, name == pt.exact
+}
+
+// ParseRule parses a rule definition of the form pattern=target where:
+//
+// pattern is either a domain (example.com), a wildcard (*.example.com or any
+// glob pattern), or a regular expression delimited by slashes
+// (/^(.*)\.lan$/).
+//
+// target is either a comma separated list of IP addresses answered locally,
+// a domain name queries are rewritten to (returned as a CNAME), or the
+// flatten keyword to collapse CNAME chains returned by the upstream. With a
+// regular expression pattern, a domain target can reference sub-matches
+// using $1, $2 etc.
+func ParseRule(s string) (Rule, error) {
+	// A regular expression can contain =, so the rule is split on the first =
+	// following its closing delimiter. Targets never contain / nor =.
+	start := 0
+	if strings.HasPrefix(strings.TrimSpace(s), "/") {
+		start = strings.LastIndexByte(s, '/')
+	}
+	idx := strings.IndexByte(s[start:], '=')
+	if idx == -1 {
+		return Rule{}, fmt.Errorf("%s: invalid rewrite rule: missing =", s)
+	}
+	idx += start
+	r := Rule{Pattern: strings.TrimSpace(s[:idx])}
+	target := strings.TrimSpace(s[idx+1:])
+	if r.Pattern == "" || target == "" {
+		return Rule{}, fmt.Errorf("%s: invalid rewrite rule", s)
+	}
+	if strings.IndexByte(target, '=') != -1 {
+		return Rule{}, fmt.Errorf("%s: invalid rewrite target: unexpected =", target)
+	}
+
+	var err error
+	if r.pattern, err = parsePattern(r.Pattern); err != nil {
+		return Rule{}, err
+	}
+
+	switch {
+	case target == "flatten":
+		r.Flatten = true
+	case net.ParseIP(strings.Split(target, ",")[0]) != nil:
+		for _, v := range strings.Split(target, ",") {
+			ip := net.ParseIP(strings.TrimSpace(v))
+			if ip == nil {
+				return Rule{}, fmt.Errorf("%s: invalid rewrite address", v)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			r.Addrs = append(r.Addrs, ip)
+		}
+	default:
+		r.Target = fqdn(target)
+	}
+	return r, nil
+}
+
+// Match returns true if name matches the rule pattern. For name rules, target
+// is the name to rewrite the query to.
+func (r Rule) Match(name string) (target string, ok bool) {
+	m, ok := r.match(name)
+	if !ok {
+		return "", false
+	}
+	if r.re != nil && r.Target != "" {
+		name = strings.TrimSuffix(fqdn(strings.ToLower(name)), ".")
+		target = fqdn(string(r.re.ExpandString(nil, r.Target, name, m)))
+		return target, true
+	}
+	return r.Target, true
+}
+
+func (r Rule) String() string {
+	switch {
+	case r.Flatten:
+		return r.Pattern + "=flatten"
+	case len(r.Addrs) > 0:
+		addrs := make([]string, 0, len(r.Addrs))
+		for _, ip := range r.Addrs {
+			addrs = append(addrs, ip.String())
+		}
+		return r.Pattern + "=" + strings.Join(addrs, ",")
+	}
+	return r.Pattern + "=" + strings.TrimSuffix(r.Target, ".")
+}
+
+func fqdn(s string) string {
+	if !strings.HasSuffix(s, ".") {
+		s += "."
+	}
+	return s
+}
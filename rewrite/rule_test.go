@@ -0,0 +1,71 @@
+package rewrite
+
+import (
+	"testing"
+)
+
+func TestRule_Match(t *testing.T) {
+	tests := []struct {
+		rule       string
+		name       string
+		wantTarget string
+		wantOK     bool
+	}{
+		{"example.com=10.0.0.1", "example.com.", "", true},
+		{"example.com=10.0.0.1", "www.example.com.", "", false},
+		{"*.internal.example.com=10.1.2.3", "a.internal.example.com.", "", true},
+		{"*.internal.example.com=10.1.2.3", "a.b.internal.example.com", "", true},
+		{"*.internal.example.com=10.1.2.3", "internal.example.com.", "", false},
+		{"ads*.example.com=0.0.0.0", "ads1.example.com.", "", true},
+		{"www.example.com=example.net", "WWW.example.com.", "example.net.", true},
+		{`/^(.*)\.lan$/=$1.home.arpa`, "nas.lan.", "nas.home.arpa.", true},
+		{`/^(.*)\.lan$/=$1.home.arpa`, "nas.lan.example.", "", false},
+		{"example.com=flatten", "example.com.", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule+" "+tt.name, func(t *testing.T) {
+			r, err := ParseRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target, ok := r.Match(tt.name)
+			if ok != tt.wantOK {
+				t.Errorf("Match() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && target != tt.wantTarget {
+				t.Errorf("Match() target = %v, want %v", target, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    string
+		wantErr bool
+	}{
+		{"example.com=10.0.0.1,::1", "example.com=10.0.0.1,::1", false},
+		{"example.com=example.net.", "example.com=example.net", false},
+		{"example.com=flatten", "example.com=flatten", false},
+		{"example.com", "", true},
+		{"=10.0.0.1", "", true},
+		{"example.com=10.0.0.1,foo", "", true},
+		{"/[/=foo", "", true},
+		{`/^(\w+)=(\w+)\.lan$/=$2.example.com`, `/^(\w+)=(\w+)\.lan$/=$2.example.com`, false},
+		{"/a=b/ = 10.0.0.1", "/a=b/=10.0.0.1", false},
+		{"/a=b/", "", true},
+		{"example.com=a=b", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			r, err := ParseRule(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRule() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && r.String() != tt.want {
+				t.Errorf("ParseRule() = %v, want %v", r.String(), tt.want)
+			}
+		})
+	}
+}
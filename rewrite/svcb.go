@@ -0,0 +1,142 @@
+package rewrite
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// svcParamKeys maps the names of the SVCB and HTTPS parameters that can be
+// stripped to their key.
+var svcParamKeys = map[string]dnsmessage.SVCParamKey{
+	"alpn":     dnsmessage.SVCParamALPN,
+	"port":     dnsmessage.SVCParamPort,
+	"ipv4hint": dnsmessage.SVCParamIPv4Hint,
+	"ech":      dnsmessage.SVCParamECH,
+	"ipv6hint": dnsmessage.SVCParamIPv6Hint,
+}
+
+// ParseSVCParamKey returns the key of the SVCB and HTTPS parameter named s
+// (alpn, port, ipv4hint, ech or ipv6hint).
+func ParseSVCParamKey(s string) (dnsmessage.SVCParamKey, error) {
+	if k, found := svcParamKeys[s]; found {
+		return k, nil
+	}
+	return 0, fmt.Errorf("%s: unsupported SVCB parameter", s)
+}
+
+// svcb returns the body of rr if it is an SVCB or HTTPS record, or nil.
+func svcb(rr dnsmessage.Resource) *dnsmessage.SVCBResource {
+	switch b := rr.Body.(type) {
+	case *dnsmessage.SVCBResource:
+		return b
+	case *dnsmessage.HTTPSResource:
+		return &b.SVCBResource
+	}
+	return nil
+}
+
+// svcbHints returns the addresses of the ipv4hint and ipv6hint parameters of
+// r.
+func svcbHints(r *dnsmessage.SVCBResource) []net.IP {
+	var ips []net.IP
+	for _, p := range r.Params {
+		size := hintSize(p.Key)
+		if size == 0 {
+			continue
+		}
+		for i := 0; i+size <= len(p.Value); i += size {
+			ips = append(ips, net.IP(p.Value[i:i+size]))
+		}
+	}
+	return ips
+}
+
+func hintSize(key dnsmessage.SVCParamKey) int {
+	switch key {
+	case dnsmessage.SVCParamIPv4Hint:
+		return net.IPv4len
+	case dnsmessage.SVCParamIPv6Hint:
+		return net.IPv6len
+	}
+	return 0
+}
+
+// FilterSVCBHints removes the addresses for which keep returns false from the
+// ipv4hint and ipv6hint parameters of rr, if it is an SVCB or HTTPS record.
+// Parameters left empty are removed. It returns the removed addresses.
+func FilterSVCBHints(rr dnsmessage.Resource, keep func(ip net.IP) bool) (removed []net.IP) {
+	r := svcb(rr)
+	if r == nil {
+		return nil
+	}
+	for _, key := range []dnsmessage.SVCParamKey{dnsmessage.SVCParamIPv4Hint, dnsmessage.SVCParamIPv6Hint} {
+		v, found := r.Param(key)
+		if !found {
+			continue
+		}
+		size := hintSize(key)
+		var kept []byte
+		for i := 0; i+size <= len(v); i += size {
+			if ip := net.IP(v[i : i+size]); keep(ip) {
+				kept = append(kept, ip...)
+			} else {
+				removed = append(removed, ip)
+			}
+		}
+		if len(kept) == len(v) {
+			continue
+		}
+		if len(kept) == 0 {
+			r.DeleteParam(key)
+		} else {
+			r.SetParam(key, kept)
+		}
+	}
+	return removed
+}
+
+// StripSVCParams removes the parameters with keys from the SVCB and HTTPS
+// records of the response stored in buf[:n] and writes it back into buf. Other
+// responses are left untouched. It returns the new size of the response.
+func StripSVCParams(keys []dnsmessage.SVCParamKey, buf []byte, n int) (int, error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(buf[:n]); err != nil {
+		return n, err
+	}
+	q, err := p.Question()
+	if err != nil || (q.Type != dnsmessage.TypeHTTPS && q.Type != dnsmessage.TypeSVCB) {
+		return n, nil
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(buf[:n]); err != nil {
+		return n, err
+	}
+	changed := false
+	for _, rrs := range [][]dnsmessage.Resource{m.Answers, m.Additionals} {
+		for _, rr := range rrs {
+			r := svcb(rr)
+			if r == nil {
+				continue
+			}
+			for _, key := range keys {
+				if r.DeleteParam(key) {
+					changed = true
+				}
+			}
+		}
+	}
+	if !changed {
+		return n, nil
+	}
+	b, err := m.AppendPack(buf[:0])
+	if err != nil {
+		return 0, err
+	}
+	if len(b) > len(buf) {
+		return 0, errors.New("rewrite: response too large")
+	}
+	return len(b), nil
+}
@@ -0,0 +1,148 @@
+package rewrite
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+func testHTTPSResponse(t *testing.T) []byte {
+	t.Helper()
+	name := dnsmessage.MustNewName("example.com.")
+	m := dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: dnsmessage.TypeHTTPS, Class: dnsmessage.ClassINET},
+		},
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 300},
+			Body: &dnsmessage.HTTPSResource{SVCBResource: dnsmessage.SVCBResource{
+				Priority: 1,
+				Target:   dnsmessage.MustNewName("."),
+				Params: []dnsmessage.SVCParam{
+					{Key: dnsmessage.SVCParamMandatory, Value: []byte{0, 1, 0, 5}},
+					{Key: dnsmessage.SVCParamALPN, Value: []byte("\x02h2")},
+					{Key: dnsmessage.SVCParamNoDefaultALPN},
+					{Key: dnsmessage.SVCParamIPv4Hint, Value: []byte{10, 0, 0, 1, 8, 8, 8, 8}},
+					{Key: dnsmessage.SVCParamECH, Value: []byte{1, 2, 3}},
+					{Key: dnsmessage.SVCParamIPv6Hint, Value: net.ParseIP("2001:db8::1")},
+				},
+			}},
+		}},
+	}
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func svcbParams(t *testing.T, b []byte) map[dnsmessage.SVCParamKey][]byte {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Answers) != 1 {
+		t.Fatalf("got %d answers, want 1", len(m.Answers))
+	}
+	r, ok := m.Answers[0].Body.(*dnsmessage.HTTPSResource)
+	if !ok {
+		t.Fatalf("got %T, want *dnsmessage.HTTPSResource", m.Answers[0].Body)
+	}
+	params := map[dnsmessage.SVCParamKey][]byte{}
+	for _, p := range r.Params {
+		params[p.Key] = p.Value
+	}
+	return params
+}
+
+func TestSVCB(t *testing.T) {
+	keys := func(names ...string) []dnsmessage.SVCParamKey {
+		var ks []dnsmessage.SVCParamKey
+		for _, n := range names {
+			k, err := ParseSVCParamKey(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ks = append(ks, k)
+		}
+		return ks
+	}
+	tests := []struct {
+		name string
+		edit func(buf []byte, n int) (int, error)
+		want map[dnsmessage.SVCParamKey][]byte
+	}{
+		{"untouched", func(buf []byte, n int) (int, error) { return n, nil }, map[dnsmessage.SVCParamKey][]byte{
+			dnsmessage.SVCParamMandatory:     {0, 1, 0, 5},
+			dnsmessage.SVCParamALPN:          []byte("\x02h2"),
+			dnsmessage.SVCParamNoDefaultALPN: {},
+			dnsmessage.SVCParamIPv4Hint:      {10, 0, 0, 1, 8, 8, 8, 8},
+			dnsmessage.SVCParamECH:           {1, 2, 3},
+			dnsmessage.SVCParamIPv6Hint:      net.ParseIP("2001:db8::1"),
+		}},
+		{"strip ech", func(buf []byte, n int) (int, error) { return StripSVCParams(keys("ech"), buf, n) }, map[dnsmessage.SVCParamKey][]byte{
+			dnsmessage.SVCParamMandatory:     {0, 1},
+			dnsmessage.SVCParamALPN:          []byte("\x02h2"),
+			dnsmessage.SVCParamNoDefaultALPN: {},
+			dnsmessage.SVCParamIPv4Hint:      {10, 0, 0, 1, 8, 8, 8, 8},
+			dnsmessage.SVCParamIPv6Hint:      net.ParseIP("2001:db8::1"),
+		}},
+		{"strip alpn and ech", func(buf []byte, n int) (int, error) { return StripSVCParams(keys("alpn", "ech"), buf, n) }, map[dnsmessage.SVCParamKey][]byte{
+			dnsmessage.SVCParamIPv4Hint: {10, 0, 0, 1, 8, 8, 8, 8},
+			dnsmessage.SVCParamIPv6Hint: net.ParseIP("2001:db8::1"),
+		}},
+		{"drop aaaa", DropAAAA, map[dnsmessage.SVCParamKey][]byte{
+			dnsmessage.SVCParamMandatory:     {0, 1, 0, 5},
+			dnsmessage.SVCParamALPN:          []byte("\x02h2"),
+			dnsmessage.SVCParamNoDefaultALPN: {},
+			dnsmessage.SVCParamIPv4Hint:      {10, 0, 0, 1, 8, 8, 8, 8},
+			dnsmessage.SVCParamECH:           {1, 2, 3},
+		}},
+		{"replace", func(buf []byte, n int) (int, error) {
+			r, err := ParseResponseRule("example.com answer=8.8.8.8 replace=192.168.1.10")
+			if err != nil {
+				return 0, err
+			}
+			return RewriteResponse([]ResponseRule{r}, buf, n)
+		}, map[dnsmessage.SVCParamKey][]byte{
+			dnsmessage.SVCParamMandatory:     {0, 1, 0, 5},
+			dnsmessage.SVCParamALPN:          []byte("\x02h2"),
+			dnsmessage.SVCParamNoDefaultALPN: {},
+			dnsmessage.SVCParamIPv4Hint:      {192, 168, 1, 10},
+			dnsmessage.SVCParamECH:           {1, 2, 3},
+			dnsmessage.SVCParamIPv6Hint:      net.ParseIP("2001:db8::1"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testHTTPSResponse(t)
+			buf := make([]byte, 512)
+			n, err := tt.edit(buf, copy(buf, b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := svcbParams(t, buf[:n]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("params = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterSVCBHints(t *testing.T) {
+	var m dnsmessage.Message
+	if err := m.Unpack(testHTTPSResponse(t)); err != nil {
+		t.Fatal(err)
+	}
+	removed := FilterSVCBHints(m.Answers[0], func(ip net.IP) bool { return !ip.Equal(net.ParseIP("10.0.0.1")) })
+	if len(removed) != 1 || !removed[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("removed = %v, want [10.0.0.1]", removed)
+	}
+	r := m.Answers[0].Body.(*dnsmessage.HTTPSResource)
+	if v, _ := r.Param(dnsmessage.SVCParamIPv4Hint); !reflect.DeepEqual(v, []byte{8, 8, 8, 8}) {
+		t.Errorf("ipv4hint = %v, want [8 8 8 8]", v)
+	}
+}
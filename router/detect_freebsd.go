@@ -0,0 +1,19 @@
+// +build freebsd
+
+package router
+
+import (
+	"github.com/nextdns/nextdns/router/generic"
+	"github.com/nextdns/nextdns/router/opnsense"
+	"github.com/nextdns/nextdns/router/pfsense"
+)
+
+func detectRouter() Router {
+	if r, ok := pfsense.New(); ok {
+		return r
+	}
+	if r, ok := opnsense.New(); ok {
+		return r
+	}
+	return generic.New()
+}
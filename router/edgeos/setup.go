@@ -1,63 +1,159 @@
 package edgeos
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/nextdns/nextdns/config"
 	"github.com/nextdns/nextdns/router/internal"
 )
 
+// Router integrates with the DNS forwarding service of EdgeOS (dnsmasq) and
+// VyOS (PowerDNS recursor) through the configuration CLI. Changes are
+// committed but not saved to the boot configuration: they are applied again
+// by Setup when the service is started by the post-config.d script, which
+// survives reboots and firmware upgrades.
 type Router struct {
-	DNSMasqPath     string
 	ListenPort      string
 	ClientReporting bool
+	Hijack          bool
+	vyos            bool
 }
 
+const (
+	cfgCmd            = "/opt/vyatta/sbin/vyatta-cfg-cmd-wrapper"
+	legacyDNSMasqPath = "/etc/dnsmasq.d/nextdns.conf"
+)
+
 func New() (*Router, bool) {
 	if st, err := os.Stat("/config/scripts/post-config.d"); err != nil || !st.IsDir() {
 		return nil, false
 	}
-	return &Router{
-		DNSMasqPath: "/etc/dnsmasq.d/nextdns.conf",
-		ListenPort:  "5342",
-	}, true
+	r := &Router{
+		ListenPort: "5342",
+	}
+	if o, err := internal.ReadOsRelease(); err == nil && o["ID"] == "vyos" {
+		r.vyos = true
+	}
+	return r, true
 }
 
 func (r *Router) Configure(c *config.Config) error {
 	c.Listen = "127.0.0.1:" + r.ListenPort
 	r.ClientReporting = c.ReportClientInfo
+	r.Hijack = c.RouterHijack
 	return nil
 }
 
+// settings returns the DNS forwarding settings pointing to NextDNS.
+func (r *Router) settings() []string {
+	const prefix = "service dns forwarding "
+	if r.vyos {
+		return []string{prefix + "name-server 127.0.0.1 port " + r.ListenPort}
+	}
+	s := []string{
+		prefix + "options no-resolv",
+		prefix + "options server=127.0.0.1#" + r.ListenPort,
+	}
+	if r.ClientReporting {
+		s = append(s, prefix+"options add-mac", prefix+"options add-subnet=32,128")
+	}
+	return s
+}
+
 func (r *Router) Setup() error {
-	if err := internal.WriteTemplate(r.DNSMasqPath, tmpl, r, 0644); err != nil {
+	if r.Hijack && r.vyos {
+		return errors.New("router-hijack is not supported on VyOS")
+	}
+	// Remove the dnsmasq config file written by previous versions.
+	_ = os.Remove(legacyDNSMasqPath)
+	var cmds []string
+	for _, s := range r.settings() {
+		cmds = append(cmds, "set "+s)
+	}
+	if err := configure(cmds...); err != nil {
 		return err
 	}
-
-	return restartDNSMasq()
+	if r.Hijack {
+		ifaces, err := listenInterfaces()
+		if err != nil {
+			return err
+		}
+		for _, iface := range ifaces {
+			if err := internal.HijackDNS(iface); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func (r *Router) Restore() error {
-	if err := os.Remove(r.DNSMasqPath); err != nil {
+	// Settings of both the client reporting and vyos variants are deleted as
+	// Restore can be called from another process (i.e. deactivate).
+	var cmds []string
+	rs := []*Router{
+		{ListenPort: r.ListenPort, ClientReporting: true},
+		{ListenPort: r.ListenPort, vyos: true},
+	}
+	for _, rr := range rs {
+		for _, s := range rr.settings() {
+			if exists(s) {
+				cmds = append(cmds, "delete "+s)
+			}
+		}
+	}
+	if err := configure(cmds...); err != nil {
 		return err
 	}
-	return restartDNSMasq()
+	if ifaces, err := listenInterfaces(); err == nil {
+		for _, iface := range ifaces {
+			// Rules may not exist if hijack was not enabled.
+			_ = internal.UnhijackDNS(iface)
+		}
+	}
+	return nil
 }
 
-func restartDNSMasq() error {
-	if err := exec.Command("sudo", "/etc/init.d/dnsmasq", "restart").Run(); err != nil {
-		return fmt.Errorf("dnsmasq restart: %v", err)
+// configure runs cmds in a configuration session and commits them.
+func configure(cmds ...string) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+	if err := exec.Command(cfgCmd, "begin").Run(); err != nil {
+		return fmt.Errorf("configure: %v", err)
+	}
+	defer func() { _ = exec.Command(cfgCmd, "end").Run() }()
+	for _, cmd := range append(cmds, "commit") {
+		if out, err := exec.Command(cfgCmd, strings.Fields(cmd)...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %v: %s", cmd, err, strings.TrimSpace(string(out)))
+		}
 	}
 	return nil
 }
 
-var tmpl = `# Configuration generated by NextDNS
-no-resolv
-server=127.0.0.1#{{.ListenPort}}
-{{- if .ClientReporting}}
-add-mac
-add-subnet=32,128
-{{- end}}
-`
+// exists returns true if the setting s is present in the active
+// configuration.
+func exists(s string) bool {
+	return exec.Command("/bin/cli-shell-api", append([]string{"existsActive"}, strings.Fields(s)...)...).Run() == nil
+}
+
+// listenInterfaces returns the LAN interfaces the DNS forwarding service
+// listens on.
+func listenInterfaces() ([]string, error) {
+	out, err := exec.Command("/bin/cli-shell-api", "returnActiveValues", "service", "dns", "forwarding", "listen-on").Output()
+	if err != nil {
+		return nil, fmt.Errorf("dns forwarding listen-on: %v", err)
+	}
+	var ifaces []string
+	for _, f := range strings.Fields(string(out)) {
+		ifaces = append(ifaces, strings.Trim(f, "'"))
+	}
+	if len(ifaces) == 0 {
+		return nil, errors.New("dns forwarding listen-on: no interface")
+	}
+	return ifaces, nil
+}
@@ -0,0 +1,52 @@
+package edgeos
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nextdns/nextdns/config"
+)
+
+func TestRouter_settings(t *testing.T) {
+	tests := []struct {
+		name   string
+		router Router
+		want   []string
+	}{
+		{"edgeos", Router{ListenPort: "5342"}, []string{
+			"service dns forwarding options no-resolv",
+			"service dns forwarding options server=127.0.0.1#5342",
+		}},
+		{"edgeos client reporting", Router{ListenPort: "5342", ClientReporting: true}, []string{
+			"service dns forwarding options no-resolv",
+			"service dns forwarding options server=127.0.0.1#5342",
+			"service dns forwarding options add-mac",
+			"service dns forwarding options add-subnet=32,128",
+		}},
+		{"vyos", Router{ListenPort: "5342", ClientReporting: true, vyos: true}, []string{
+			"service dns forwarding name-server 127.0.0.1 port 5342",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.router.settings(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("settings() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouter_Configure(t *testing.T) {
+	r := &Router{ListenPort: "5342"}
+	c := &config.Config{ReportClientInfo: true, RouterHijack: true}
+	if err := r.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != "127.0.0.1:5342" || !r.ClientReporting || !r.Hijack {
+		t.Errorf("Configure() = listen %s, router %+v", c.Listen, r)
+	}
+	r.vyos = true
+	if err := r.Setup(); err == nil {
+		t.Error("Setup() with hijack on VyOS err = nil, want an error")
+	}
+}
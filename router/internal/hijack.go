@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// hijackChain is the iptables nat chain holding the DNS hijack rules.
+const hijackChain = "NEXTDNS"
+
+// HijackCommands returns the iptables commands redirecting DNS queries
+// received on iface and addressed to another host (i.e. clients with a hard
+// coded 8.8.8.8) to the DNS server of the router. Commands can be run again
+// to refresh the rules, errors of commands creating the chain or removing the
+// previous jump rule must be ignored.
+func HijackCommands(iface string) [][]string {
+	cmds := [][]string{
+		{"iptables", "-t", "nat", "-N", hijackChain},
+		{"iptables", "-t", "nat", "-F", hijackChain},
+	}
+	for _, proto := range []string{"udp", "tcp"} {
+		cmds = append(cmds, []string{"iptables", "-t", "nat", "-A", hijackChain,
+			"-p", proto, "--dport", "53", "-m", "addrtype", "!", "--dst-type", "LOCAL",
+			"-j", "REDIRECT", "--to-ports", "53"})
+	}
+	return append(cmds,
+		[]string{"iptables", "-t", "nat", "-D", "PREROUTING", "-i", iface, "-j", hijackChain},
+		[]string{"iptables", "-t", "nat", "-I", "PREROUTING", "-i", iface, "-j", hijackChain})
+}
+
+// UnhijackCommands returns the iptables commands removing the rules added by
+// HijackCommands.
+func UnhijackCommands(iface string) [][]string {
+	return [][]string{
+		{"iptables", "-t", "nat", "-D", "PREROUTING", "-i", iface, "-j", hijackChain},
+		{"iptables", "-t", "nat", "-F", hijackChain},
+		{"iptables", "-t", "nat", "-X", hijackChain},
+	}
+}
+
+// HijackScript returns the commands as a shell script.
+func HijackScript(cmds [][]string) string {
+	var s strings.Builder
+	for _, cmd := range cmds {
+		s.WriteString(strings.Join(cmd, " "))
+		s.WriteString(" 2>/dev/null\n")
+	}
+	return s.String()
+}
+
+// HijackDNS adds the hijack rules for iface.
+func HijackDNS(iface string) error {
+	for _, cmd := range HijackCommands(iface) {
+		err := exec.Command(cmd[0], cmd[1:]...).Run()
+		if err != nil && cmd[3] != "-N" && cmd[3] != "-D" {
+			return fmt.Errorf("%s: %v", strings.Join(cmd, " "), err)
+		}
+	}
+	return nil
+}
+
+// UnhijackDNS removes the hijack rules for iface.
+func UnhijackDNS(iface string) error {
+	var firstErr error
+	for _, cmd := range UnhijackCommands(iface) {
+		if err := exec.Command(cmd[0], cmd[1:]...).Run(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %v", strings.Join(cmd, " "), err)
+		}
+	}
+	return firstErr
+}
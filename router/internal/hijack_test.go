@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHijackScript(t *testing.T) {
+	want := "iptables -t nat -N NEXTDNS 2>/dev/null\n" +
+		"iptables -t nat -F NEXTDNS 2>/dev/null\n" +
+		"iptables -t nat -A NEXTDNS -p udp --dport 53 -m addrtype ! --dst-type LOCAL -j REDIRECT --to-ports 53 2>/dev/null\n" +
+		"iptables -t nat -A NEXTDNS -p tcp --dport 53 -m addrtype ! --dst-type LOCAL -j REDIRECT --to-ports 53 2>/dev/null\n" +
+		"iptables -t nat -D PREROUTING -i br0 -j NEXTDNS 2>/dev/null\n" +
+		"iptables -t nat -I PREROUTING -i br0 -j NEXTDNS 2>/dev/null\n"
+	if got := HijackScript(HijackCommands("br0")); got != want {
+		t.Errorf("HijackScript() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnhijackCommands(t *testing.T) {
+	want := [][]string{
+		{"iptables", "-t", "nat", "-D", "PREROUTING", "-i", "eth1", "-j", "NEXTDNS"},
+		{"iptables", "-t", "nat", "-F", "NEXTDNS"},
+		{"iptables", "-t", "nat", "-X", "NEXTDNS"},
+	}
+	if got := UnhijackCommands("eth1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("UnhijackCommands() = %q, want %q", got, want)
+	}
+	// Every rule added by HijackCommands must be removed.
+	for _, cmd := range HijackCommands("eth1") {
+		if cmd[3] == "-I" {
+			del := append([]string{}, cmd...)
+			del[3] = "-D"
+			if !reflect.DeepEqual(del, want[0]) {
+				t.Errorf("jump rule %q not removed", cmd)
+			}
+		}
+	}
+}
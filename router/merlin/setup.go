@@ -19,6 +19,15 @@ type Router struct {
 	ClientReporting bool
 	CurrentPostConf string
 	johnFork        bool
+
+	// Hijack specifies that DNS queries sent by LAN clients to other servers
+	// are redirected to dnsmasq. The rules are re-applied by the nat-start
+	// script every time the firewall is restarted.
+	Hijack          bool
+	LANIface        string
+	NATStartPath    string
+	CurrentNATStart string
+	HijackScript    string
 }
 
 func New() (*Router, bool) {
@@ -27,20 +36,41 @@ func New() (*Router, bool) {
 		return nil, false
 	}
 	postConfPath := "/jffs/scripts/dnsmasq.postconf"
+	natStartPath := "/jffs/scripts/nat-start"
 	return &Router{
 		DNSMasqPath:     postConfPath,
 		CurrentPostConf: readPostConf(postConfPath),
 		ListenPort:      "5342",
 		johnFork:        strings.HasPrefix(string(b), "ASUSWRT-Merlin-LTS"),
+		LANIface:        lanIface(),
+		NATStartPath:    natStartPath,
+		CurrentNATStart: readPostConf(natStartPath),
 	}, true
 }
 
 func (r *Router) Configure(c *config.Config) error {
 	c.Listen = "127.0.0.1:" + r.ListenPort
 	r.ClientReporting = c.ReportClientInfo
+	if c.RouterHijack {
+		r.Hijack = true
+		r.HijackScript = indent(internal.HijackScript(internal.HijackCommands(r.LANIface)))
+	}
 	return nil
 }
 
+func lanIface() string {
+	if vars, err := internal.NVRAM("lan_ifname"); err == nil && len(vars) == 1 {
+		if iface := strings.TrimPrefix(vars[0], "lan_ifname="); iface != "" {
+			return iface
+		}
+	}
+	return "br0"
+}
+
+func indent(s string) string {
+	return "\t" + strings.Replace(strings.TrimSuffix(s, "\n"), "\n", "\n\t", -1)
+}
+
 func readPostConf(path string) string {
 	f, err := os.Open(path)
 	if err != nil {
@@ -67,6 +97,14 @@ func (r *Router) Setup() error {
 	if err := internal.WriteTemplate(r.DNSMasqPath, tmpl, r, 0755); err != nil {
 		return err
 	}
+	if r.Hijack {
+		if err := internal.WriteTemplate(r.NATStartPath, natStartTmpl, r, 0755); err != nil {
+			return err
+		}
+		if err := internal.HijackDNS(r.LANIface); err != nil {
+			return err
+		}
+	}
 	// Restart dnsmasq service to apply changes.
 	if err := exec.Command("service", "restart_dnsmasq").Run(); err != nil {
 		return fmt.Errorf("service restart_dnsmasq: %v", err)
@@ -88,6 +126,24 @@ func (r *Router) Restore() error {
 	if err != nil {
 		return fmt.Errorf("restore %s: %v", r.DNSMasqPath, err)
 	}
+	// Hijack is not set when restoring from another process (i.e.
+	// deactivate), check for the nat-start script instead.
+	if b, _ := ioutil.ReadFile(r.NATStartPath); r.Hijack || bytes.Contains(b, []byte("## NextDNS END")) {
+		if r.CurrentNATStart != "" {
+			err = ioutil.WriteFile(r.NATStartPath, []byte(r.CurrentNATStart), 0755)
+		} else {
+			err = os.Remove(r.NATStartPath)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("restore %s: %v", r.NATStartPath, err)
+		}
+		if err := internal.UnhijackDNS(r.LANIface); err != nil {
+			return err
+		}
+	}
 
 	// Restart dnsmasq service to apply changes.
 	if err := exec.Command("service", "restart_dnsmasq").Run(); err != nil {
@@ -119,3 +175,14 @@ fi
 ## NextDNS END
 {{.CurrentPostConf -}}
 `
+
+var natStartTmpl = `#!/bin/sh
+# Configuration generated by NextDNS
+
+if [ -f /tmp/nextdns.pid ] && [ -d "/proc/$(sed -n '1p' /tmp/nextdns.pid)" ]; then
+{{.HijackScript}}
+fi
+
+## NextDNS END
+{{.CurrentNATStart -}}
+`
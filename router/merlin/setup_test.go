@@ -0,0 +1,48 @@
+package merlin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/router/internal"
+)
+
+func TestNATStartTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const userScript = "#!/bin/sh\niptables -t nat -A PREROUTING -p tcp --dport 2222 -j DNAT --to 192.168.1.2:22\n"
+	r := &Router{
+		ListenPort:      "5342",
+		LANIface:        "br0",
+		NATStartPath:    filepath.Join(dir, "nat-start"),
+		CurrentNATStart: userScript,
+	}
+	if err := r.Configure(&config.Config{RouterHijack: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := internal.WriteTemplate(r.NATStartPath, natStartTmpl, r, 0755); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(r.NATStartPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := string(b)
+	if !strings.Contains(script, "\tiptables -t nat -I PREROUTING -i br0 -j NEXTDNS 2>/dev/null\n") {
+		t.Errorf("hijack rules not in nat-start:\n%s", script)
+	}
+	if !strings.HasSuffix(script, "## NextDNS END\n"+userScript) {
+		t.Errorf("user script not preserved:\n%s", script)
+	}
+	// The user script is extracted back when restoring.
+	if got := readPostConf(r.NATStartPath); got != userScript {
+		t.Errorf("readPostConf() = %q, want %q", got, userScript)
+	}
+}
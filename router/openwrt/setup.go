@@ -1,8 +1,10 @@
 package openwrt
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"strings"
@@ -13,10 +15,30 @@ import (
 
 type Router struct {
 	DNSMasqPath     string
+	StatePath       string
 	ListenPort      string
 	ClientReporting bool
 
-	savedForwarders string
+	// SearchDomains are the dnsmasq domain settings advertising the search
+	// domains to DHCP clients, as DOMAIN[,SUBNET].
+	SearchDomains []string
+
+	// Takeover specifies that nextdns listens on port 53 in place of dnsmasq
+	// which is only kept as a DHCP server.
+	Takeover bool
+}
+
+// state holds the uci settings changed by Setup so they can be restored by
+// Restore, even from another process (i.e. deactivate or uninstall).
+type state struct {
+	// Forward is true when Forwarders were saved.
+	Forward    bool     `json:"forward,omitempty"`
+	Forwarders []string `json:"forwarders,omitempty"`
+
+	// Takeover is true when Port and DHCPOptions were saved.
+	Takeover    bool     `json:"takeover,omitempty"`
+	Port        string   `json:"port,omitempty"`
+	DHCPOptions []string `json:"dhcp_options,omitempty"`
 }
 
 func New() (*Router, bool) {
@@ -25,71 +47,207 @@ func New() (*Router, bool) {
 	}
 	return &Router{
 		DNSMasqPath: "/tmp/dnsmasq.d/nextdns.conf",
+		StatePath:   "/etc/nextdns.openwrt",
 		ListenPort:  "5342",
 	}, true
 }
 
 func (r *Router) Configure(c *config.Config) error {
-	c.Listen = "127.0.0.1:" + r.ListenPort
 	r.ClientReporting = c.ReportClientInfo
-	return nil
+	r.SearchDomains = nil
+	for _, d := range c.SearchDomains {
+		switch {
+		case d.MAC != nil:
+			// dnsmasq sets domains per address range only.
+		case d.Prefix != nil:
+			r.SearchDomains = append(r.SearchDomains, d.Config+","+d.Prefix.String())
+		default:
+			r.SearchDomains = append(r.SearchDomains, d.Config)
+		}
+	}
+	if c.RouterMode != "takeover" {
+		c.Listen = "127.0.0.1:" + r.ListenPort
+		return nil
+	}
+	r.Takeover = true
+	c.Listen = ":53"
+	// Port 53 must be released by dnsmasq before we start listening.
+	st, err := r.loadState()
+	if err != nil {
+		return err
+	}
+	if !st.Takeover {
+		st.Takeover = true
+		if st.Port, err = uciGet("dhcp.@dnsmasq[0].port"); err != nil {
+			return err
+		}
+		opts, err := uciGet("dhcp.lan.dhcp_option")
+		if err != nil {
+			return err
+		}
+		st.DHCPOptions = strings.Fields(opts)
+		if err := r.saveState(st); err != nil {
+			return err
+		}
+	}
+	if _, err := uci("set", "dhcp.@dnsmasq[0].port=0"); err != nil {
+		return err
+	}
+	if _, err := uci("commit", "dhcp"); err != nil {
+		return err
+	}
+	return restartDNSMasq()
 }
 
 func (r *Router) Setup() (err error) {
-	r.savedForwarders, err = uci("get", "dhcp.@dnsmasq[0].server")
+	st, err := r.loadState()
 	if err != nil {
-		if !errors.Is(err, uciErrEntryNotFound) {
+		return err
+	}
+
+	if r.Takeover {
+		// With its DNS server disabled, dnsmasq no longer advertises itself as
+		// the DNS server of the LAN, advertise the router address instead.
+		lanIP, err := uciGet("network.lan.ipaddr")
+		if err != nil {
+			return err
+		}
+		lanIP = strings.SplitN(strings.Fields(lanIP + " ")[0], "/", 2)[0]
+		if lanIP == "" {
+			return errors.New("cannot find LAN address")
+		}
+		_, _ = uci("delete", "dhcp.lan.dhcp_option")
+		for _, opt := range st.DHCPOptions {
+			if strings.HasPrefix(opt, "6,") || strings.HasPrefix(opt, "option:dns-server,") {
+				continue
+			}
+			if _, err := uci("add_list", "dhcp.lan.dhcp_option="+opt); err != nil {
+				return err
+			}
+		}
+		if _, err := uci("add_list", "dhcp.lan.dhcp_option=6,"+lanIP); err != nil {
 			return err
 		}
-	} else {
-		if _, err = uci("delete", "dhcp.@dnsmasq[0].server"); err != nil {
+		if _, err := uci("commit", "dhcp"); err != nil {
 			return err
 		}
-		if _, err = uci("commit"); err != nil {
+		if err := internal.WriteTemplate(r.DNSMasqPath, tmpl, r, 0644); err != nil {
 			return err
 		}
+		return restartDNSMasq()
 	}
 
-	if err := internal.WriteTemplate(r.DNSMasqPath, tmpl, r, 0644); err != nil {
+	if !st.Forward {
+		fwd, err := uciGet("dhcp.@dnsmasq[0].server")
+		if err != nil {
+			return err
+		}
+		st.Forward = true
+		st.Forwarders = strings.Fields(fwd)
+		if err := r.saveState(st); err != nil {
+			return err
+		}
+	}
+	if _, err := uci("delete", "dhcp.@dnsmasq[0].server"); err != nil && !errors.Is(err, uciErrEntryNotFound) {
+		return err
+	}
+	if _, err = uci("commit", "dhcp"); err != nil {
 		return err
 	}
 
-	// Restart dnsmasq service to apply changes.
-	if err := exec.Command("/etc/init.d/dnsmasq", "restart").Run(); err != nil {
-		return fmt.Errorf("dnsmasq restart: %v", err)
+	if err := internal.WriteTemplate(r.DNSMasqPath, tmpl, r, 0644); err != nil {
+		return err
 	}
 
-	return nil
+	return restartDNSMasq()
 }
 
 func (r *Router) Restore() error {
+	st, err := r.loadState()
+	if err != nil {
+		return err
+	}
+
 	// Restore forwarders
-	if r.savedForwarders != "" {
-		for _, f := range strings.Split(r.savedForwarders, " ") {
+	if st.Forward {
+		_, _ = uci("delete", "dhcp.@dnsmasq[0].server")
+		for _, f := range st.Forwarders {
 			if _, err := uci("add_list", "dhcp.@dnsmasq[0].server="+f); err != nil {
 				return err
 			}
 		}
-		if _, err := uci("commit"); err != nil {
+	}
+
+	// Restore dnsmasq DNS server and DHCP options
+	if st.Takeover {
+		if st.Port == "" {
+			_, _ = uci("delete", "dhcp.@dnsmasq[0].port")
+		} else if _, err := uci("set", "dhcp.@dnsmasq[0].port="+st.Port); err != nil {
 			return err
 		}
+		_, _ = uci("delete", "dhcp.lan.dhcp_option")
+		for _, opt := range st.DHCPOptions {
+			if _, err := uci("add_list", "dhcp.lan.dhcp_option="+opt); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := uci("commit", "dhcp"); err != nil {
+		return err
 	}
 
 	// Remove the custom dnsmasq config
 	_ = os.Remove(r.DNSMasqPath)
+	if err := os.Remove(r.StatePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return restartDNSMasq()
+}
+
+func (r *Router) loadState() (state, error) {
+	var st state
+	b, err := ioutil.ReadFile(r.StatePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return st, nil
+		}
+		return st, err
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return st, fmt.Errorf("%s: %v", r.StatePath, err)
+	}
+	return st, nil
+}
 
-	// Restart dnsmasq service to apply changes.
-	if err := exec.Command("/etc/init.d/dnsmasq", "restart").Run(); err != nil {
+func (r *Router) saveState(st state) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.StatePath, b, 0600)
+}
+
+// dnsmasqInit is the init script of dnsmasq.
+var dnsmasqInit = "/etc/init.d/dnsmasq"
+
+func restartDNSMasq() error {
+	if err := exec.Command(dnsmasqInit, "restart").Run(); err != nil {
 		return fmt.Errorf("dnsmasq restart: %v", err)
 	}
 	return nil
 }
 
 var tmpl = `# Configuration generated by NextDNS
+{{- if not .Takeover}}
 no-resolv
 server=127.0.0.1#{{.ListenPort}}
 {{- if .ClientReporting}}
 add-mac
 add-subnet=32,128
 {{- end}}
+{{- end}}
+{{- range .SearchDomains}}
+domain={{.}}
+{{- end}}
 `
@@ -0,0 +1,154 @@
+package openwrt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nextdns/nextdns/config"
+)
+
+// fakeUCI is a uci command storing the options as key=value lines in the file
+// set by UCI_DB, lists being stored space separated.
+const fakeUCI = `#!/bin/sh
+db=$UCI_DB
+touch "$db"
+cmd=$1; arg=$2; key=${arg%%=*}; val=${arg#*=}
+has() { awk -v k="$1=" 'index($0, k) == 1 { f = 1 } END { exit !f }' "$db"; }
+get() { awk -v k="$1=" 'index($0, k) == 1 { print substr($0, length(k) + 1) }' "$db"; }
+del() { awk -v k="$1=" 'index($0, k) != 1' "$db" > "$db.tmp"; mv "$db.tmp" "$db"; }
+case $cmd in
+get)
+	has "$key" || { echo "uci: Entry not found" >&2; exit 1; }
+	get "$key";;
+set)
+	del "$key"; echo "$key=$val" >> "$db";;
+add_list)
+	cur=$(get "$key"); del "$key"
+	echo "$key=${cur:+$cur }$val" >> "$db";;
+delete)
+	has "$key" || { echo "uci: Entry not found" >&2; exit 1; }
+	del "$key";;
+commit)
+	;;
+esac
+`
+
+func setupFakeRouter(t *testing.T, db string) *Router {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "openwrt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := ioutil.WriteFile(filepath.Join(dir, "uci"), []byte(fakeUCI), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "dnsmasq"), []byte("#!/bin/sh\necho restart >> \"$UCI_DB.restarts\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "uci.db"), []byte(db), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path, init := os.Getenv("PATH"), dnsmasqInit
+	t.Cleanup(func() {
+		os.Setenv("PATH", path)
+		os.Unsetenv("UCI_DB")
+		dnsmasqInit = init
+	})
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	os.Setenv("UCI_DB", filepath.Join(dir, "uci.db"))
+	dnsmasqInit = filepath.Join(dir, "dnsmasq")
+	return &Router{
+		DNSMasqPath: filepath.Join(dir, "nextdns.conf"),
+		StatePath:   filepath.Join(dir, "nextdns.openwrt"),
+		ListenPort:  "5342",
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+const testUCIDB = "dhcp.@dnsmasq[0].server=8.8.8.8 1.1.1.1\n" +
+	"dhcp.lan.dhcp_option=3,192.168.1.1 6,9.9.9.9\n" +
+	"network.lan.ipaddr=192.168.1.1/24\n"
+
+func TestRouter_Forward(t *testing.T) {
+	r := setupFakeRouter(t, testUCIDB)
+	c := &config.Config{ReportClientInfo: true}
+	if err := r.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != "127.0.0.1:5342" {
+		t.Errorf("listen = %s, want 127.0.0.1:5342", c.Listen)
+	}
+	if err := r.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if db := readFile(t, os.Getenv("UCI_DB")); strings.Contains(db, "dnsmasq[0].server") {
+		t.Errorf("forwarders not removed:\n%s", db)
+	}
+	want := "# Configuration generated by NextDNS\nno-resolv\nserver=127.0.0.1#5342\nadd-mac\nadd-subnet=32,128\n"
+	if got := readFile(t, r.DNSMasqPath); got != want {
+		t.Errorf("dnsmasq config = %q, want %q", got, want)
+	}
+
+	// Restore from another process.
+	r2 := setupFakeRouter(t, readFile(t, os.Getenv("UCI_DB")))
+	r2.StatePath, r2.DNSMasqPath = r.StatePath, r.DNSMasqPath
+	if err := r2.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if db := readFile(t, os.Getenv("UCI_DB")); !strings.Contains(db, "dhcp.@dnsmasq[0].server=8.8.8.8 1.1.1.1\n") {
+		t.Errorf("forwarders not restored:\n%s", db)
+	}
+	for _, f := range []string{r.StatePath, r.DNSMasqPath} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", f)
+		}
+	}
+}
+
+func TestRouter_Takeover(t *testing.T) {
+	r := setupFakeRouter(t, testUCIDB)
+	c := &config.Config{RouterMode: "takeover"}
+	if err := r.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != ":53" {
+		t.Errorf("listen = %s, want :53", c.Listen)
+	}
+	db := readFile(t, os.Getenv("UCI_DB"))
+	if !strings.Contains(db, "dhcp.@dnsmasq[0].port=0\n") {
+		t.Errorf("dnsmasq DNS server not disabled:\n%s", db)
+	}
+	if err := r.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	db = readFile(t, os.Getenv("UCI_DB"))
+	if !strings.Contains(db, "dhcp.lan.dhcp_option=3,192.168.1.1 6,192.168.1.1\n") {
+		t.Errorf("router not advertised as DNS server:\n%s", db)
+	}
+	// Configure again (i.e. restart) must not overwrite the saved state.
+	if err := r.Configure(&config.Config{RouterMode: "takeover"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	db = readFile(t, os.Getenv("UCI_DB"))
+	if strings.Contains(db, "dnsmasq[0].port") {
+		t.Errorf("dnsmasq port not restored:\n%s", db)
+	}
+	if !strings.Contains(db, "dhcp.lan.dhcp_option=3,192.168.1.1 6,9.9.9.9\n") {
+		t.Errorf("DHCP options not restored:\n%s", db)
+	}
+}
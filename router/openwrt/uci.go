@@ -24,3 +24,12 @@ func uci(args ...string) (string, error) {
 	}
 	return strings.TrimSpace(stdout.String()), nil
 }
+
+// uciGet returns the value of an option or an empty string if not set.
+func uciGet(option string) (string, error) {
+	v, err := uci("get", option)
+	if errors.Is(err, uciErrEntryNotFound) {
+		return "", nil
+	}
+	return v, err
+}
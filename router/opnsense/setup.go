@@ -0,0 +1,74 @@
+package opnsense
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/router/internal"
+)
+
+type Router struct {
+	UnboundPath string
+	ListenPort  string
+
+	// Takeover specifies that nextdns listens on port 53 in place of unbound.
+	Takeover bool
+}
+
+func New() (*Router, bool) {
+	if _, err := os.Stat("/usr/local/sbin/opnsense-version"); err != nil {
+		return nil, false
+	}
+	return &Router{
+		UnboundPath: "/usr/local/etc/unbound.opnsense.d/nextdns.conf",
+		ListenPort:  "5342",
+	}, true
+}
+
+func (r *Router) Configure(c *config.Config) error {
+	if c.RouterMode != "takeover" {
+		c.Listen = "127.0.0.1:" + r.ListenPort
+		return nil
+	}
+	r.Takeover = true
+	c.Listen = ":53"
+	// Port 53 must be released by unbound before we start listening.
+	return configctl("unbound", "stop")
+}
+
+func (r *Router) Setup() error {
+	if r.Takeover {
+		return nil
+	}
+	if err := internal.WriteTemplate(r.UnboundPath, tmpl, r, 0644); err != nil {
+		return err
+	}
+	return configctl("unbound", "restart")
+}
+
+func (r *Router) Restore() error {
+	if r.Takeover {
+		return configctl("unbound", "start")
+	}
+	if err := os.Remove(r.UnboundPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return configctl("unbound", "restart")
+}
+
+func configctl(service, action string) error {
+	if err := exec.Command("configctl", service, action).Run(); err != nil {
+		return fmt.Errorf("%s %s: %v", service, action, err)
+	}
+	return nil
+}
+
+var tmpl = `# Configuration generated by NextDNS
+server:
+  do-not-query-localhost: no
+forward-zone:
+  name: "."
+  forward-addr: 127.0.0.1@{{.ListenPort}}
+`
@@ -0,0 +1,76 @@
+package opnsense
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nextdns/nextdns/config"
+)
+
+// fakeConfigctl installs a configctl command logging its arguments and
+// returns the path of the log.
+func fakeConfigctl(t *testing.T, dir string) string {
+	t.Helper()
+	log := filepath.Join(dir, "log")
+	if err := ioutil.WriteFile(filepath.Join(dir, "configctl"), []byte("#!/bin/sh\necho \"$@\" >> "+log+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return log
+}
+
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		wantListen string
+		wantConf   string
+		wantCmds   string
+	}{
+		{"forward", "", "127.0.0.1:5342",
+			"# Configuration generated by NextDNS\nserver:\n  do-not-query-localhost: no\nforward-zone:\n  name: \".\"\n  forward-addr: 127.0.0.1@5342\n",
+			"unbound restart\nunbound restart\n"},
+		{"takeover", "takeover", ":53", "", "unbound stop\nunbound start\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "opnsense")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			log := fakeConfigctl(t, dir)
+			r := &Router{
+				UnboundPath: filepath.Join(dir, "nextdns.conf"),
+				ListenPort:  "5342",
+			}
+			c := &config.Config{RouterMode: tt.mode}
+			if err := r.Configure(c); err != nil {
+				t.Fatal(err)
+			}
+			if c.Listen != tt.wantListen {
+				t.Errorf("listen = %s, want %s", c.Listen, tt.wantListen)
+			}
+			if err := r.Setup(); err != nil {
+				t.Fatal(err)
+			}
+			b, _ := ioutil.ReadFile(r.UnboundPath)
+			if string(b) != tt.wantConf {
+				t.Errorf("unbound config = %q, want %q", b, tt.wantConf)
+			}
+			if err := r.Restore(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(r.UnboundPath); !os.IsNotExist(err) {
+				t.Errorf("unbound config not removed: %v", err)
+			}
+			if b, _ := ioutil.ReadFile(log); string(b) != tt.wantCmds {
+				t.Errorf("commands = %q, want %q", b, tt.wantCmds)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package pfsense
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+
+	"github.com/nextdns/nextdns/config"
+)
+
+// Router takes over port 53 from unbound (the DNS Resolver service).
+//
+// pfSense regenerates the whole unbound configuration from its own settings,
+// so unbound cannot be made to forward to NextDNS without changing the
+// pfSense configuration: NextDNS always replaces unbound.
+type Router struct {
+}
+
+func New() (*Router, bool) {
+	if b, err := ioutil.ReadFile("/etc/platform"); err != nil || !bytes.HasPrefix(b, []byte("pfSense")) {
+		return nil, false
+	}
+	return &Router{}, true
+}
+
+func (r *Router) Configure(c *config.Config) error {
+	c.Listen = ":53"
+	// Port 53 must be released by unbound before we start listening.
+	return svc("stop", "unbound")
+}
+
+func (r *Router) Setup() error {
+	return nil
+}
+
+func (r *Router) Restore() error {
+	return svc("start", "unbound")
+}
+
+// pfSsh is the pfSense developer shell used to control the services.
+var pfSsh = "/usr/local/sbin/pfSsh.php"
+
+func svc(action, name string) error {
+	if err := exec.Command(pfSsh, "playback", "svc", action, name).Run(); err != nil {
+		return fmt.Errorf("%s %s: %v", name, action, err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package pfsense
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nextdns/nextdns/config"
+)
+
+func TestRouter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pfsense")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "log")
+	defer func(p string) { pfSsh = p }(pfSsh)
+	pfSsh = filepath.Join(dir, "pfSsh.php")
+	if err := ioutil.WriteFile(pfSsh, []byte("#!/bin/sh\necho \"$@\" >> "+log+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Router{}
+	c := &config.Config{Listen: "localhost:53"}
+	if err := r.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != ":53" {
+		t.Errorf("listen = %s, want :53", c.Listen)
+	}
+	if err := r.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "playback svc stop unbound\nplayback svc start unbound\n"; string(b) != want {
+		t.Errorf("commands = %q, want %q", b, want)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -8,34 +9,84 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/nextdns/nextdns/hosts"
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/internal/outbound"
+	"github.com/nextdns/nextdns/internal/webhook"
 
 	"github.com/cespare/xxhash"
 	"github.com/denisbrodbeck/machineid"
 
+	"github.com/nextdns/nextdns/anomaly"
+	"github.com/nextdns/nextdns/answerwatch"
+	"github.com/nextdns/nextdns/blockpage"
+	"github.com/nextdns/nextdns/cache"
+	"github.com/nextdns/nextdns/cacheonly"
+	"github.com/nextdns/nextdns/captive"
+	"github.com/nextdns/nextdns/coalesce"
 	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/ctl"
 	"github.com/nextdns/nextdns/discovery"
+	"github.com/nextdns/nextdns/docker"
+	"github.com/nextdns/nextdns/downgrade"
+	"github.com/nextdns/nextdns/events"
+	"github.com/nextdns/nextdns/filter"
+	"github.com/nextdns/nextdns/health"
+	"github.com/nextdns/nextdns/hijack"
+	"github.com/nextdns/nextdns/history"
 	"github.com/nextdns/nextdns/host"
 	"github.com/nextdns/nextdns/host/service"
+	"github.com/nextdns/nextdns/host/service/systemd"
+	"github.com/nextdns/nextdns/maintenance"
+	"github.com/nextdns/nextdns/mdns"
+	"github.com/nextdns/nextdns/memlimit"
+	"github.com/nextdns/nextdns/mirror"
+	"github.com/nextdns/nextdns/negcache"
 	"github.com/nextdns/nextdns/netstatus"
+	"github.com/nextdns/nextdns/pause"
+	"github.com/nextdns/nextdns/portal"
+	"github.com/nextdns/nextdns/prefix"
+	"github.com/nextdns/nextdns/priority"
+	"github.com/nextdns/nextdns/privacy"
+	"github.com/nextdns/nextdns/privaterelay"
 	"github.com/nextdns/nextdns/proxy"
+	"github.com/nextdns/nextdns/ra"
+	"github.com/nextdns/nextdns/rebind"
 	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/resolver/dnssec"
 	"github.com/nextdns/nextdns/resolver/endpoint"
+	"github.com/nextdns/nextdns/rewrite"
 	"github.com/nextdns/nextdns/router"
+	"github.com/nextdns/nextdns/schedule"
+	"github.com/nextdns/nextdns/script"
+	"github.com/nextdns/nextdns/slo"
+	"github.com/nextdns/nextdns/specialuse"
+	"github.com/nextdns/nextdns/top"
+	"github.com/nextdns/nextdns/tunnel"
+	"github.com/nextdns/nextdns/webui"
+	"github.com/nextdns/nextdns/wireguard"
+	"github.com/nextdns/nextdns/zone"
 )
 
 type proxySvc struct {
 	proxy.Proxy
 	log      host.Logger
+	events   *events.Stream
+	ctl      *ctl.Server
 	resolver *resolver.DNS
 	stopFunc func()
 	stopped  chan struct{}
 
+	// listening is 1 while the listeners of the proxy are open and serving.
+	listening int32
+
 	// OnInit is called every time the proxy is started or restarted. The ctx is
 	// cancelled on stop or restart.
 	OnInit []func(ctx context.Context)
@@ -47,17 +98,33 @@ type proxySvc struct {
 	OnStopped []func()
 }
 
+// gcBallast is the heap ballast allocated with gc-ballast, kept referenced so
+// the garbage collector accounts for it.
+var gcBallast []byte
+
 func (p *proxySvc) Start() (err error) {
 	p.log.Infof("Starting NextDNS %s/%s on %s", version, platform, p.Addr)
-	backoff := 100 * time.Millisecond
+	if err := p.events.Start(); err != nil {
+		p.log.Errorf("Events: %v", err)
+	}
+	if err := p.ctl.Start(); err != nil {
+		p.log.Errorf("Control: %v", err)
+	}
+	p.events.Emit(events.ServiceStarting, events.Data{"version": version, "platform": platform, "listen": p.Addr})
+	backoff := minStartBackoff
 	for {
 		if err = p.start(); err != nil {
-			if isErrNetUnreachable(err) {
-				p.log.Infof("Network not yet ready, waiting")
+			if isErrNetNotReady(err) {
+				p.log.Infof("Network not yet ready, retrying in %v: %v", backoff, err)
+				// Keep systemd waiting for the listeners instead of failing the
+				// start-up on timeout.
+				_, _ = systemd.Status("Waiting for the network: " + err.Error())
+				_, _ = systemd.ExtendTimeout(backoff + 30*time.Second)
 				time.Sleep(backoff)
-				backoff <<= 1
+				backoff = nextBackoff(backoff, maxStartBackoff)
 				continue
 			}
+			p.events.Emit(events.Error, events.Data{"error": err.Error()})
 			return err
 		}
 		break
@@ -65,61 +132,125 @@ func (p *proxySvc) Start() (err error) {
 	for _, f := range p.OnStarted {
 		f()
 	}
+	_, _ = systemd.Status("Serving on " + p.Addr)
+	_, _ = systemd.Notify(systemd.NotifyReady)
+	p.events.Emit(events.ServiceStarted, events.Data{"version": version, "platform": platform, "listen": p.Addr})
 	return nil
 }
 
-func isErrNetUnreachable(err error) bool {
+// Bounds of the backoff between the attempts to start the proxy while the
+// network is not ready, i.e. on a router booting before its interfaces are
+// configured.
+const (
+	minStartBackoff = 100 * time.Millisecond
+	maxStartBackoff = 30 * time.Second
+)
+
+// nextBackoff returns the backoff following d, doubled and capped to max.
+func nextBackoff(d, max time.Duration) time.Duration {
+	if d <<= 1; d > max || d <= 0 {
+		return max
+	}
+	return d
+}
+
+// isErrNetNotReady returns true if err is caused by a network not configured
+// yet: unreachable or down network, or listen address not assigned yet.
+func isErrNetNotReady(err error) bool {
 	if strings.Contains(err.Error(), "network is unreachable") {
 		return true
 	}
 	for ; err != nil; err = errors.Unwrap(err) {
 		if sysErr, ok := err.(*os.SyscallError); ok {
-			return sysErr.Err == syscall.ENETUNREACH
+			switch sysErr.Err {
+			case syscall.ENETUNREACH, syscall.ENETDOWN, syscall.EADDRNOTAVAIL:
+				return true
+			}
+			return false
+		}
+		if _, ok := err.(*net.DNSError); ok {
+			// Listen address host not resolvable yet.
+			return true
 		}
 	}
 	return false
 }
 
-func (p *proxySvc) start() (err error) {
+// start starts the proxy and returns once its listeners are open, or with the
+// error preventing them to open.
+func (p *proxySvc) start() error {
 	errC := make(chan error)
+	listening := make(chan struct{})
+	p.OnListening = func() {
+		atomic.StoreInt32(&p.listening, 1)
+		close(listening)
+	}
 	var ctx context.Context
+	ctx, p.stopFunc = context.WithCancel(context.Background())
+	p.stopped = make(chan struct{})
 	go func() {
-		ctx, p.stopFunc = context.WithCancel(context.Background())
 		defer p.stopFunc()
-		p.stopped = make(chan struct{})
 		defer close(p.stopped)
 		for _, f := range p.OnInit {
 			go f(ctx)
 		}
-		if err = p.ListenAndServe(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		err := p.ListenAndServe(ctx)
+		atomic.StoreInt32(&p.listening, 0)
+		if err != nil && !errors.Is(err, context.Canceled) {
 			select {
 			case errC <- err:
 			default:
+				// The proxy stopped serving after start-up, the watchdog
+				// notifications stop so systemd restarts the service.
+				p.log.Errorf("Proxy stopped: %v", err)
 			}
 		}
 	}()
 	select {
 	case err := <-errC:
 		return err
-	case <-time.After(5 * time.Second):
+	case <-listening:
 	}
 	return nil
 }
 
 func (p *proxySvc) Restart() error {
 	p.log.Infof("Restarting NextDNS %s/%s on %s", version, platform, p.Addr)
+	p.events.Emit(events.ServiceRestarting, events.Data{"version": version, "platform": platform, "listen": p.Addr})
+	_, _ = systemd.Notify(systemd.NotifyReloading)
 	_ = p.stop()
-	return p.start()
+	if err := p.start(); err != nil {
+		return err
+	}
+	_, _ = systemd.Notify(systemd.NotifyReady)
+	return nil
+}
+
+// watchdog sends the systemd watchdog notifications while the proxy is
+// serving, so systemd restarts the service when it is not.
+func (p *proxySvc) watchdog(interval time.Duration) {
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+	for range t.C {
+		if atomic.LoadInt32(&p.listening) == 1 {
+			_, _ = systemd.Notify(systemd.NotifyWatchdog)
+		}
+	}
 }
 
 func (p *proxySvc) Stop() error {
 	p.log.Infof("Stopping NextDNS %s/%s", version, platform)
+	p.events.Emit(events.ServiceStopping, nil)
+	_, _ = systemd.Notify(systemd.NotifyStopping)
 	if p.stop() {
 		for _, f := range p.OnStopped {
 			f()
 		}
 	}
 	p.log.Infof("NextDNS %s/%s stopped", version, platform)
+	p.events.Emit(events.ServiceStopped, nil)
+	_ = p.ctl.Close()
+	_ = p.events.Close()
 	return nil
 }
 
@@ -145,8 +276,12 @@ func run(args []string) error {
 	useStorage := service.CurrentRunMode() == service.RunModeService
 	c.Parse("nextdns "+cmd, args, useStorage)
 
-	log, err := host.NewLogger("nextdns")
-	if err != nil {
+	var log host.Logger
+	var err error
+	if c.Container {
+		log = host.NewConsoleLogger("nextdns")
+		setupContainer(&c, log)
+	} else if log, err = host.NewLogger("nextdns"); err != nil {
 		log = host.NewConsoleLogger("nextdns")
 		log.Warningf("Service logger error (switching to console): %v", err)
 	}
@@ -154,6 +289,59 @@ func run(args []string) error {
 		log: log,
 	}
 
+	listenFiles := systemd.ListenFiles()
+	if len(listenFiles) > 0 {
+		log.Infof("Using %d socket(s) from systemd socket activation", len(listenFiles))
+	}
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go p.watchdog(interval)
+	}
+
+	if c.Nice != 0 || c.IOClass != "" {
+		if err := host.SetPriority(c.Nice, c.IOClass); err != nil {
+			log.Errorf("Setting process priority: %v", err)
+		}
+	}
+	if c.EventsFile != "" || c.EventsSocket != "" {
+		p.events = &events.Stream{
+			File:   c.EventsFile,
+			Socket: c.EventsSocket,
+		}
+	}
+
+	if c.Control != "" || c.API != "" {
+		// The commands are served by the API even without control socket.
+		p.ctl = &ctl.Server{Addr: c.Control, ReadOnly: c.Kiosk}
+	}
+
+	if c.BundleURL != "" {
+		// Setup before c is modified by the router setup.
+		setupBundle(p, c)
+	}
+
+	if c.AutoUpgrade {
+		if err := checkUpgradeKey(c); err != nil {
+			return err
+		}
+		setupAutoUpgrade(p, c)
+	}
+
+	acls := c.ACLs
+	var forwarders []*net.IPNet
+	if len(c.ForwardedBy) > 0 {
+		acl, err := proxy.ParseACL(strings.Join(c.ForwardedBy, ","))
+		if err != nil {
+			return fmt.Errorf("forwarded-by: %v", err)
+		}
+		acls, forwarders = []proxy.ACL{acl}, acl.Allow
+		// The system resolver, the router and the clients are managed by
+		// the forwarder.
+		if c.SetupRouter || c.AutoActivate {
+			log.Warning("setup-router and auto-activate are disabled with forwarded-by")
+		}
+		c.SetupRouter, c.AutoActivate = false, false
+	}
+
 	if c.SetupRouter {
 		r := router.New()
 		if err := r.Configure(&c); err != nil {
@@ -163,12 +351,53 @@ func run(args []string) error {
 			log.Info("Setting up router")
 			if err := r.Setup(); err != nil {
 				log.Errorf("Setting up router: %v", err)
+				p.events.Emit(events.Error, events.Data{"error": err.Error(), "op": "router.setup"})
+				return
 			}
+			p.events.Emit(events.RouterSetup, nil)
 		})
 		p.OnStopped = append(p.OnStopped, func() {
 			log.Info("Restore router settings")
 			if err := r.Restore(); err != nil {
 				log.Errorf("Restore router settings: %v", err)
+				p.events.Emit(events.Error, events.Data{"error": err.Error(), "op": "router.restore"})
+				return
+			}
+			p.events.Emit(events.RouterRestored, nil)
+		})
+	}
+
+	if len(c.Intercept) > 0 {
+		port := 53
+		if !c.SetupRouter {
+			// With setup-router, queries are redirected to the router DNS
+			// server which forwards them to us if we are not listening on 53.
+			var listen string
+			if addrs := proxy.SplitAddr(c.Listen); len(addrs) > 0 {
+				listen = addrs[0]
+			}
+			_, portStr, err := net.SplitHostPort(listen)
+			if err != nil {
+				return fmt.Errorf("intercept: %v", err)
+			}
+			if port, err = strconv.Atoi(portStr); err != nil {
+				return fmt.Errorf("intercept: %s: invalid port", portStr)
+			}
+			if isLocalhostMode(&c) {
+				return errors.New("intercept: listen must not be limited to the loopback interface")
+			}
+		}
+		p.OnStarted = append(p.OnStarted, func() {
+			log.Infof("Intercepting DNS queries on %s", strings.Join(c.Intercept, ", "))
+			if err := host.InterceptDNS(port, c.Intercept, c.InterceptExclude); err != nil {
+				log.Errorf("Intercept DNS: %v", err)
+				p.events.Emit(events.Error, events.Data{"error": err.Error(), "op": "intercept"})
+			}
+		})
+		p.OnStopped = append(p.OnStopped, func() {
+			log.Info("Removing DNS interception rules")
+			if err := host.ResetInterceptDNS(); err != nil {
+				log.Errorf("Reset DNS interception: %v", err)
 			}
 		})
 	}
@@ -178,200 +407,1844 @@ func run(args []string) error {
 			log.Info("Activating")
 			if err := activate(c); err != nil {
 				log.Errorf("Activate: %v", err)
+				p.events.Emit(events.Error, events.Data{"error": err.Error(), "op": "activate"})
+				return
+			}
+			p.events.Emit(events.ActivationActivated, nil)
+		})
+		p.OnInit = append(p.OnInit, func(ctx context.Context) {
+			// Some platforms (i.e. macOS) reset the system DNS configuration
+			// when the network changes, re-apply it.
+			netChange := make(chan netstatus.Change, 1)
+			netstatus.Notify(netChange)
+			defer netstatus.Stop(netChange)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-netChange:
+					if err := activate(c); err != nil {
+						log.Errorf("Activate after network change: %v", err)
+					}
+				}
 			}
 		})
 		p.OnStopped = append(p.OnStopped, func() {
 			log.Info("Deactivating")
 			if err := deactivate(); err != nil {
 				log.Errorf("Deactivate: %v", err)
+				p.events.Emit(events.Error, events.Data{"error": err.Error(), "op": "deactivate"})
+				return
 			}
+			p.events.Emit(events.ActivationDeactivated, nil)
 		})
 	}
 
+	var sources []net.IP
+	for _, src := range c.UpstreamSource {
+		ip := net.ParseIP(src)
+		if ip == nil {
+			return fmt.Errorf("upstream-source: %s: invalid IP address", src)
+		}
+		sources = append(sources, ip)
+	}
+	var dialer *net.Dialer
+	var failover *outbound.Failover
+	if c.UpstreamBackup != "" {
+		if c.UpstreamInterface == "" {
+			return errors.New("upstream-backup-interface: upstream-interface required")
+		}
+		if len(sources) > 0 {
+			return errors.New("upstream-backup-interface: cannot be combined with upstream-source")
+		}
+		failover = &outbound.Failover{
+			Primary: c.UpstreamInterface,
+			Backup:  c.UpstreamBackup,
+		}
+		if err := failover.Validate(); err != nil {
+			return fmt.Errorf("upstream-backup-interface: %v", err)
+		}
+		dialer = failover.Dialer()
+	} else if dialer, err = outbound.Dialer(c.UpstreamInterface, sources); err != nil {
+		return fmt.Errorf("upstream-interface: %v", err)
+	}
+	dialer = outbound.TCP(dialer, c.UpstreamFastOpen, c.UpstreamKeepAlive)
+	if err := c.Forwarders.SetUpstreams(c.Upstreams); err != nil {
+		return fmt.Errorf("forwarder: %v", err)
+	}
+	// Forwarders are not pinned to the upstream interface, but their DoH
+	// connections are tuned the same way.
+	fwdDialer := outbound.TCP(nil, c.UpstreamFastOpen, c.UpstreamKeepAlive)
+	for _, f := range c.Forwarders {
+		if r, ok := f.Resolver.(*resolver.DNS); ok {
+			r.Manager.Dialer = fwdDialer
+		}
+	}
+	var proxies outbound.Proxies
+	for _, spec := range c.UpstreamProxy {
+		r, err := outbound.ParseProxyRule(spec)
+		if err != nil {
+			return fmt.Errorf("upstream-proxy: %v", err)
+		}
+		proxies = append(proxies, r)
+	}
+	if len(proxies) > 0 {
+		for _, f := range c.Forwarders {
+			if r, ok := f.Resolver.(*resolver.DNS); ok {
+				r.Manager.Proxy = proxies.Get
+			}
+		}
+	}
+	ech, err := endpoint.ParseECHMode(c.UpstreamECH)
+	if err != nil {
+		return fmt.Errorf("upstream-ech: %v", err)
+	}
+	for _, f := range c.Forwarders {
+		if r, ok := f.Resolver.(*resolver.DNS); ok {
+			r.Manager.ECH = ech
+		}
+	}
+
 	startup := time.Now()
+	autoFallback := func() bool {
+		// Backward compat: the captive portal is now somewhat always enabled,
+		// but for those who enabled it in the past, disable the delay after which
+		// the fallback is disabled.
+		if c.DetectCaptivePortals {
+			return true
+		}
+		// Allow fallback to plain DNS for 10 minute after startup or after
+		// a change of network configuration.
+		return time.Since(startup) < 10*time.Minute
+	}
 	p.resolver = &resolver.DNS{
 		DOH: resolver.DOH{
 			ExtraHeaders: http.Header{
 				"User-Agent": []string{fmt.Sprintf("nextdns-cli/%s (%s; %s; %s)", version, platform, runtime.GOARCH, host.InitType())},
 			},
 		},
-		Manager: nextdnsEndpointManager(log, c.HPM, func() bool {
-			// Backward compat: the captive portal is now somewhat always enabled,
-			// but for those who enabled it in the past, disable the delay after which
-			// the fallback is disabled.
-			if c.DetectCaptivePortals {
+		Manager: nextdnsEndpointManager(log, p.events, c.HPM, dialer, proxies, func() bool {
+			// The fallback is also used in closed mode, so FailClosed
+			// answers with SERVFAIL right away rather than letting queries
+			// time out on the failed DoH endpoints.
+			return c.FailMode.Has(config.FailOpen) || c.FailMode.Has(config.FailClosed) || autoFallback()
+		}),
+		FailClosed: func(q resolver.Query) bool {
+			switch c.FailMode.Get(q.PeerIP, q.MAC) {
+			case config.FailOpen:
+				return false
+			case config.FailClosed:
 				return true
 			}
-			// Allow fallback to plain DNS for 10 minute after startup or after
-			// a change of network configuration.
-			return time.Since(startup) < 10*time.Minute
-		}),
+			return !autoFallback()
+		},
+	}
+	p.resolver.Manager.ECH = ech
+
+	// With quiet-maintenance, lists are reloaded by the maintenance scheduler.
+	listRefresh := c.BlocklistRefresh
+	if c.QuietMaintenance {
+		listRefresh = 0
+	}
+	var maintenanceTasks []maintenance.Task
+	// memoryShed holds the memory-limit degradation steps by name.
+	memoryShed := map[string]func(){}
+
+	// paused holds the filtering pauses set with the pause command.
+	var paused *pause.State
+	// cacheOnly stops contacting the upstreams when set with the cache-only
+	// command.
+	var cacheOnly *cacheonly.Switch
+	if p.ctl != nil {
+		paused = &pause.State{}
+		setupPause(p, paused)
+		cacheOnly = &cacheonly.Switch{}
+		setupCacheOnly(p, cacheOnly)
+		setupProfiling(p)
+		setupTrace(p)
+	}
+	var sched *schedule.Resolver
+	var schedProfiles, schedNames bool
+	if len(c.Schedules) > 0 {
+		sched = &schedule.Resolver{
+			Rules:           c.Schedules,
+			RefreshInterval: listRefresh,
+			InfoLog: func(msg string) {
+				log.Info(msg)
+			},
+			ErrorLog: func(err error) {
+				log.Error(err)
+			},
+		}
+		maintenanceTasks = append(maintenanceTasks, maintenance.Task{Name: "schedule lists reload", Run: func(ctx context.Context) error {
+			sched.Reload(ctx)
+			return nil
+		}})
+		for _, rule := range c.Schedules {
+			schedProfiles = schedProfiles || rule.Profile != ""
+			schedNames = schedNames || len(rule.Names) > 0
+		}
+	}
+	var clientsFile *discovery.File
+	if c.ClientsFile != "" {
+		clientsFile = &discovery.File{Path: c.ClientsFile}
+		if err := clientsFile.Load(); err != nil {
+			return fmt.Errorf("clients-file: %v", err)
+		}
+		p.OnInit = append(p.OnInit, func(ctx context.Context) {
+			clientsFile.Watch(discovery.WithTrace(ctx, discovery.Trace{
+				OnWarning: func(msg string) {
+					log.Warningf("Clients file: %s", msg)
+				},
+			}))
+		})
+	}
+	var leaseFiles []discovery.LeaseFile
+	for _, spec := range c.DHCPLeaseFiles {
+		f, err := discovery.ParseLeaseFileSpec(spec)
+		if err != nil {
+			return fmt.Errorf("dhcp-lease-file: %v", err)
+		}
+		leaseFiles = append(leaseFiles, f)
+	}
+	// profile returns the configuration ID used for q, as scheduled, set in
+	// the clients file or conditionally configured.
+	profile := func(q resolver.Query) string {
+		if paused != nil && paused.Paused(q.PeerIP, q.MAC) {
+			return ""
+		}
+		if q.Instance != "" {
+			if inst, found := c.Instances.Get(q.Instance); found && inst.Profile != "" {
+				return inst.Profile
+			}
+		}
+		if prof := c.DomainProfiles.Get(q.Name); prof != "" {
+			return prof
+		}
+		if schedProfiles {
+			if prof, ok := sched.Profile(q.PeerIP, q.MAC, time.Now()); ok {
+				if prof == schedule.ProfileOff {
+					return ""
+				}
+				return prof
+			}
+		}
+		if clientsFile != nil {
+			if prof := clientsFile.Profile(q.PeerIP, q.MAC); prof != "" {
+				return prof
+			}
+		}
+		return c.Conf.Get(q.PeerIP, q.MAC)
 	}
 
-	if len(c.Conf) == 0 || (len(c.Conf) == 1 && c.Conf.Get(nil, nil) != "") {
+	if paused == nil && len(c.Instances) == 0 && len(c.DomainProfiles) == 0 && !schedProfiles && clientsFile == nil && (len(c.Conf) == 0 || (len(c.Conf) == 1 && c.Conf.Get(nil, nil) != "")) {
 		// Optimize for no dynamic configuration.
 		p.resolver.DOH.URL = "https://dns.nextdns.io/" + c.Conf.Get(nil, nil)
 	} else {
 		p.resolver.DOH.GetURL = func(q resolver.Query) string {
-			return "https://dns.nextdns.io/" + c.Conf.Get(q.PeerIP, q.MAC)
+			return "https://dns.nextdns.io/" + profile(q)
 		}
 	}
 
-	p.Proxy = proxy.Proxy{
-		Addr:      c.Listen,
-		Upstream:  p.resolver,
-		BogusPriv: c.BogusPriv,
-		UseHosts:  c.UseHosts,
-		Timeout:   c.Timeout,
+	var upstream resolver.Resolver = p.resolver
+	if c.DNSSEC {
+		upstream = &dnssec.Validator{
+			Upstream: p.resolver,
+			Anchors:  &dnssec.Anchors{File: c.DNSSECAnchorFile},
+			ErrorLog: func(err error) {
+				log.Warning(err)
+			},
+		}
 	}
 
-	if len(c.Forwarders) > 0 {
-		// Append default doh server at the end of the forwarder list as a catch all.
-		fwd := make(config.Forwarders, 0, len(c.Forwarders)+1)
-		fwd = append(fwd, c.Forwarders...)
-		fwd = append(fwd, config.Resolver{Resolver: p.resolver})
-		p.Upstream = &fwd
+	var prefixes *prefix.Tracker
+	if len(c.TrackPrefix) > 0 {
+		prefixes = &prefix.Tracker{
+			Interfaces: c.TrackPrefix,
+			OnChange: func(old, new []*net.IPNet) {
+				log.Infof("IPv6 prefix changed: %v -> %v", old, new)
+			},
+		}
+		prefixes.Update()
+	}
+	localNet := func(ip net.IP) bool {
+		return prefixes != nil && prefixes.Contains(ip)
 	}
 
-	if c.LogQueries {
-		p.QueryLog = func(q proxy.QueryInfo) {
-			var errStr string
-			if q.Error != nil {
-				errStr = ": " + q.Error.Error()
-			}
-			log.Infof("Query %s %s %s %s (qry=%d/res=%d) %dms %s%s",
-				q.PeerIP.String(),
-				q.Protocol,
-				q.Type,
-				q.Name,
-				q.QuerySize,
-				q.ResponseSize,
-				q.Duration/time.Millisecond,
-				q.UpstreamTransport,
-				errStr)
+	if c.RebindProtection {
+		upstream = &rebind.Resolver{
+			Upstream: upstream,
+			LocalNet: localNet,
+			OnRebind: func(name string, ip net.IP) {
+				log.Warningf("DNS rebinding blocked: %s %s", name, ip)
+			},
 		}
 	}
-	p.InfoLog = func(msg string) {
-		log.Info(msg)
+
+	if c.CaptivePortalProbes {
+		upstream = &captive.Resolver{
+			Names:    captive.ProbeNames,
+			Servers:  host.DNS,
+			Upstream: upstream,
+			OnChange: func(detected bool) {
+				if detected {
+					log.Warning("Captive portal detected, resolving portal probes with network DNS")
+					p.events.Emit(events.CaptivePortalDetected, nil)
+				} else {
+					log.Info("Captive portal cleared")
+					p.events.Emit(events.CaptivePortalCleared, nil)
+				}
+			},
+		}
 	}
-	p.ErrorLog = func(err error) {
-		log.Error(err)
+
+	if cacheOnly != nil {
+		// Below the caches and the local answers of special-use domains, so
+		// they are still served.
+		upstream = &cacheonly.Resolver{
+			Switch:   cacheOnly,
+			Upstream: upstream,
+		}
 	}
-	localhostMode := isLocalhostMode(&c)
-	if c.ReportClientInfo {
-		// Only enable discovery if configured to listen to requests outside
-		// the local host.
-		enableDiscovery := !localhostMode
-		setupClientReporting(p, &c.Conf, enableDiscovery)
-	}
-	if localhostMode {
-		// If only listening on localhost, we may be running on a laptop or
-		// other sort of device that might change network from time to time.
-		// When such change is detected, it better to trigger a re-negotiation
-		// of the best endpoint sooner than later. We also reset the startup
-		// time so plain DNS fallback happen again (useful for captive portals).
-		p.OnInit = append(p.OnInit, func(ctx context.Context) {
-			netChange := make(chan netstatus.Change)
-			netstatus.Notify(netChange)
-			for c := range netChange {
-				log.Infof("Network change detected: %s", c)
-				startup = time.Now() // reset the startup marker so DNS fallback can happen again.
-				if err := p.resolver.Manager.Test(ctx); err != nil {
-					log.Error("Test after network change failed: %v", err)
-				}
-			}
-		})
+
+	upstream = &specialuse.Resolver{
+		Rules:       c.SpecialDomains,
+		Canary:      c.DoHCanary,
+		BlockDDR:    c.BlockDDR,
+		BlockBypass: c.BlockDoHBypass,
+		Servers:     host.DNS,
+		Upstream:    upstream,
 	}
 
-	return service.Run("nextdns", p)
-}
+	clientKey := func(q resolver.Query) string {
+		key := profile(q)
+		if c.ReportClientInfo && !q.PeerIP.IsLoopback() {
+			// Each client is reported with its own queries.
+			key += "|" + q.PeerIP.String() + "|" + q.MAC.String()
+		}
+		return key
+	}
 
-// isLocalhostMode returns true if listen is only listening for the local host.
-func isLocalhostMode(c *config.Config) bool {
-	if c.SetupRouter {
-		// The listen arg is irrelevant when in router mode.
-		return false
+	if c.CoalesceQueries {
+		upstream = &coalesce.Resolver{
+			Upstream:  upstream,
+			ClientKey: clientKey,
+		}
 	}
-	if host, _, err := net.SplitHostPort(c.Listen); err == nil {
-		switch host {
-		case "localhost", "127.0.0.1", "::1":
-			return true
+
+	if c.NegativeCacheMaxTTL > 0 {
+		nc := &negcache.Resolver{
+			Upstream:  upstream,
+			MaxTTL:    c.NegativeCacheMaxTTL,
+			ClientKey: clientKey,
 		}
-		if ips := hosts.LookupHost(host); len(ips) > 0 {
-			for _, ip := range ips {
-				if !net.ParseIP(ip).IsLoopback() {
-					return false
-				}
+		upstream = nc
+		p.ctl.Action("cache.flush", func(args []string) (interface{}, error) {
+			return map[string]int{"flushed": nc.Flush()}, nil
+		})
+		maintenanceTasks = append(maintenanceTasks, maintenance.Task{Name: "negative cache purge", Run: func(ctx context.Context) error {
+			nc.Purge()
+			return nil
+		}})
+		memoryShed["negative cache"] = func() {
+			nc.Flush()
+		}
+	}
+
+	if c.Cache != "" {
+		backend, err := cache.New(c.Cache)
+		if err != nil {
+			return fmt.Errorf("cache: %v", err)
+		}
+		upstream = &cache.Resolver{
+			Cache:     backend,
+			Upstream:  upstream,
+			MaxTTL:    c.CacheMaxTTL,
+			ClientKey: clientKey,
+			ErrorLog: func(err error) {
+				log.Errorf("Cache: %v", err)
+			},
+		}
+		if m, ok := backend.(*cache.Memory); ok {
+			m.MaxBytes = c.CacheMaxMemory << 20
+			maintenanceTasks = append(maintenanceTasks, maintenance.Task{Name: "cache purge", Run: func(ctx context.Context) error {
+				m.Purge()
+				return nil
+			}})
+			memoryShed["cache"] = func() {
+				m.Flush()
 			}
-			return true
 		}
-		return net.ParseIP(host).IsLoopback()
 	}
-	return false
-}
 
-// nextdnsEndpointManager returns a endpoint.Manager configured to connect to
-// NextDNS using different steering techniques.
-func nextdnsEndpointManager(log host.Logger, hpm bool, canFallback func() bool) *endpoint.Manager {
-	qs := "?stack=dual"
-	if hpm {
-		qs += "&hardened_privacy=1"
+	if c.MaxUDPSize < 64 || c.MaxUDPSize > 512 {
+		return fmt.Errorf("%d: invalid max-udp-size: must be between 64 and 512", c.MaxUDPSize)
 	}
-	m := &endpoint.Manager{
-		Providers: []endpoint.Provider{
-			// Prefer unicast routing.
-			&endpoint.SourceURLProvider{
-				SourceURL: "https://router.nextdns.io" + qs,
-				Client: &http.Client{
-					Timeout: 5 * time.Second,
-					// Trick to avoid depending on DNS to contact the router API.
-					Transport: &endpoint.DOHEndpoint{Hostname: "router.nextdns.io", Bootstrap: []string{
-						"216.239.32.21",
-						"216.239.34.21",
-						"216.239.36.21",
-						"216.239.38.21",
-					}},
-				},
-			},
-			// Fallback on anycast.
-			endpoint.StaticProvider([]endpoint.Endpoint{
-				endpoint.MustNew("https://dns1.nextdns.io#45.90.28.0,2a07:a8c0::"),
-				endpoint.MustNew("https://dns2.nextdns.io#45.90.30.0,2a07:a8c1::"),
-			}),
-		},
-		InitEndpoint: endpoint.MustNew("https://dns1.nextdns.io#45.90.28.0,2a07:a8c0::"),
-		OnError: func(e endpoint.Endpoint, err error) {
-			log.Warningf("Endpoint failed: %v: %v", e, err)
-		},
-		OnProviderError: func(p endpoint.Provider, err error) {
-			log.Warningf("Endpoint provider failed: %v: %v", p, err)
-		},
-		OnConnect: func(ci *endpoint.ConnectInfo) {
-			log.Infof("Connected %s (con=%dms tls=%dms, %s)",
-				ci.ServerAddr,
-				ci.ConnectTimes[ci.ServerAddr]/time.Millisecond,
-				ci.TLSTime/time.Millisecond,
-				ci.TLSVersion)
-		},
-		OnChange: func(e endpoint.Endpoint) {
-			log.Infof("Switching endpoint: %s", e)
+	p.Proxy = proxy.Proxy{
+		Addr:             c.Listen,
+		Files:            listenFiles,
+		ACLs:             acls,
+		Forwarders:       forwarders,
+		Upstream:         upstream,
+		BogusPriv:        c.BogusPriv,
+		UseHosts:         c.UseHosts,
+		RefuseAny:        c.RefuseAny,
+		MinimalResponses: c.MinimalResponses,
+		ExtendedErrors:   c.ExtendedErrors,
+		MaxUDPSize:       c.MaxUDPSize,
+		MaxInflight:      c.MaxInflight,
+		Retry: resolver.RetryPolicy{
+			Timeout:        c.Timeout,
+			AttemptTimeout: c.AttemptTimeout,
+			MaxAttempts:    c.MaxAttempts,
+			Backoff:        c.RetryBackoff,
+			MaxBackoff:     c.RetryMaxBackoff,
 		},
 	}
-	// Fallback on system DNS and set a short min test interval for when plain
-	// DNS protocol is used so we go back on safe DoH as soon as possible. This
-	// allows automatic handling of captive portals as well as NTP / DNS
-	// inter-dependency on some routers, when NTP needs DNS to sync the time,
-	// and DoH needs time properly set to establish a TLS session.
-	m.Providers = append(m.Providers, endpoint.ProviderFunc(func(ctx context.Context) ([]endpoint.Endpoint, error) {
-		if !canFallback() {
-			// Fallback disabled.
-			return nil, nil
+	for _, inst := range c.Instances {
+		p.Instances = append(p.Instances, proxy.Instance{Name: inst.Name, Addr: inst.Listen})
+	}
+	if c.ListenUnix != "" {
+		p.Listeners = append(p.Listeners, &proxy.UnixListener{
+			Path: c.ListenUnix,
+			// Set later with the other proxy logs.
+			ErrorLog: func(err error) {
+				p.ErrorLog(err)
+			},
+		})
+	}
+	if c.ParseErrorQuota > 0 && c.ParseErrorBan <= 0 {
+		return fmt.Errorf("%v: invalid parse-error-ban: must be positive", c.ParseErrorBan)
+	}
+	if c.MaxLabels > 0 || c.MaxOptions > 0 || len(c.MaxQuerySize) > 0 || c.ParseErrorQuota > 0 {
+		l := &proxy.Limits{
+			MaxLabels:   c.MaxLabels,
+			MaxOptions:  c.MaxOptions,
+			MaxSize:     map[string]int{},
+			ErrorQuota:  c.ParseErrorQuota,
+			BanDuration: c.ParseErrorBan,
+			OnBan: func(ip net.IP, errors int) {
+				log.Warningf("Client %s banned for %v: %d invalid queries", ip, c.ParseErrorBan, errors)
+				p.events.Emit(events.ClientBanned, events.Data{"client": ip.String(), "errors": errors, "duration": c.ParseErrorBan.String()})
+			},
 		}
-		ips := host.DNS()
-		endpoints := make([]endpoint.Endpoint, 0, len(ips)+1)
-		for _, ip := range ips {
-			endpoints = append(endpoints, &endpoint.DNSEndpoint{
-				Addr: net.JoinHostPort(ip, "53"),
+		for _, v := range c.MaxQuerySize {
+			protocol, size, err := proxy.ParseMaxSize(v)
+			if err != nil {
+				return fmt.Errorf("max-query-size: %v", err)
+			}
+			l.MaxSize[protocol] = size
+		}
+		p.Limits = l
+	}
+	switch c.ACLAction {
+	case "refuse":
+	case "drop":
+		p.ACLDrop = true
+	default:
+		return fmt.Errorf("%s: invalid acl-action", c.ACLAction)
+	}
+
+	if c.ListenXDP != "" {
+		l, err := proxy.ParseXDPListener(c.ListenXDP)
+		if err != nil {
+			return fmt.Errorf("listen-xdp: %v", err)
+		}
+		p.Listeners = append(p.Listeners, l)
+	}
+
+	if len(c.Blocklists) > 0 || c.RulesSync != "" {
+		resp, err := filter.ParseResponse(c.BlockResponse)
+		if err != nil {
+			return err
+		}
+		f := &filter.Filter{
+			Blocklists:      c.Blocklists,
+			Allowlists:      c.Allowlists,
+			Sync:            c.RulesSync,
+			SyncToken:       c.RulesSyncToken,
+			RefreshInterval: listRefresh,
+			Response:        resp,
+			InfoLog: func(msg string) {
+				log.Info(msg)
+			},
+			ErrorLog: func(err error) {
+				log.Error(err)
+			},
+		}
+		p.Filter = f
+		p.OnInit = append(p.OnInit, f.Start)
+		maintenanceTasks = append(maintenanceTasks, maintenance.Task{Name: "lists reload", Run: func(ctx context.Context) error {
+			f.Reload(ctx)
+			return nil
+		}})
+		if c.RulesSyncListen != "" {
+			srv := &filter.SyncServer{Filter: f, Addr: c.RulesSyncListen, Token: c.RulesSyncToken}
+			p.OnInit = append(p.OnInit, func(ctx context.Context) {
+				log.Infof("Serving rules for sync on %s", srv.Addr)
+				if err := srv.ListenAndServe(ctx); err != nil && !errors.Is(err, context.Canceled) {
+					log.Errorf("Rules sync server: %v", err)
+				}
+			})
+		}
+	}
+
+	if c.BlockPage != "" {
+		if err := setupBlockPage(p, &c); err != nil {
+			return err
+		}
+	}
+
+	if c.Mirror != "" {
+		m := &mirror.Mirror{
+			Dest:    c.Mirror,
+			Domains: c.MirrorDomains,
+			ErrorLog: func(err error) {
+				log.Error(err)
+			},
+		}
+		if err := m.Init(); err != nil {
+			return err
+		}
+		p.Mirror = m
+		p.OnInit = append(p.OnInit, m.Start)
+	}
+
+	if len(c.MDNSReflector) > 0 {
+		r := &mdns.Reflector{
+			InfoLog: func(msg string) {
+				log.Info(msg)
+			},
+			ErrorLog: func(err error) {
+				log.Error(err)
+			},
+		}
+		for _, def := range c.MDNSReflector {
+			i, err := mdns.ParseInterface(def)
+			if err != nil {
+				return err
+			}
+			r.Interfaces = append(r.Interfaces, i)
+		}
+		p.OnInit = append(p.OnInit, r.Start)
+	}
+
+	if len(c.MDNSAdvertise) > 0 {
+		name, _ := host.Name()
+		if idx := strings.IndexByte(name, '.'); idx != -1 {
+			name = name[:idx]
+		}
+		if name == "" {
+			name = "nextdns"
+		}
+		a := &mdns.Advertiser{
+			Interfaces: c.MDNSAdvertise,
+			Instance:   "NextDNS on " + name,
+			Host:       name,
+			Services:   mdnsServices(c.Listen),
+			InfoLog: func(msg string) {
+				log.Info(msg)
+			},
+			ErrorLog: func(err error) {
+				log.Error(err)
+			},
+		}
+		if err := a.Validate(); err != nil {
+			return fmt.Errorf("mdns-advertise: %v", err)
+		}
+		if len(a.Services) > 0 {
+			p.OnInit = append(p.OnInit, a.Start)
+		} else {
+			log.Warning("mDNS advertiser disabled: no listener reachable from the network")
+		}
+	}
+
+	if c.WireGuard != "" {
+		wc, err := wireguard.LoadConfig(c.WireGuard)
+		if err != nil {
+			return fmt.Errorf("wireguard: %v", err)
+		}
+		t, err := wireguard.NewTunnel(wc, func(err error) {
+			log.Errorf("WireGuard: %v", err)
+		})
+		if err != nil {
+			return fmt.Errorf("wireguard: %v", err)
+		}
+		if err := c.Forwarders.Tunnel(t); err != nil {
+			t.Close()
+			return fmt.Errorf("wireguard: %v", err)
+		}
+	}
+
+	if len(c.RDNSS) > 0 {
+		a := &ra.Announcer{
+			Interfaces: c.RDNSS,
+			DHCPv6:     c.RDNSSDHCPv6,
+			InfoLog: func(msg string) {
+				log.Info(msg)
+			},
+			ErrorLog: func(err error) {
+				log.Error(err)
+			},
+		}
+		if err := a.Validate(); err != nil {
+			return fmt.Errorf("rdnss: %v", err)
+		}
+		listen := c.Listen
+		for _, inst := range c.Instances {
+			listen += "," + inst.Listen
+		}
+		if !listensOnPort53(listen) {
+			log.Warning("DNS server announcer disabled: no listener on port 53 reachable from the network")
+		} else {
+			p.OnInit = append(p.OnInit, a.Start)
+		}
+	}
+
+	if len(c.Forwarders) > 0 {
+		// Append default doh server at the end of the forwarder list as a catch all.
+		fwd := make(config.Forwarders, 0, len(c.Forwarders)+1)
+		fwd = append(fwd, c.Forwarders...)
+		if c.ReportClientInfo && c.ForwarderClientInfo {
+			for i := range fwd {
+				fwd[i].Resolver = &resolver.ClientOptions{
+					Upstream: fwd[i].Resolver,
+					// Set by setupClientReporting.
+					ClientInfo: func(q resolver.Query) resolver.ClientInfo {
+						return p.resolver.DOH.ClientInfo(q)
+					},
+				}
+			}
+		}
+		if cacheOnly != nil {
+			for i := range fwd {
+				fwd[i].Resolver = &cacheonly.Resolver{
+					Switch:   cacheOnly,
+					Upstream: fwd[i].Resolver,
+				}
+			}
+		}
+		fwd = append(fwd, config.Resolver{Resolver: upstream})
+		p.Upstream = &fwd
+	}
+
+	if len(c.LowPriority) > 0 {
+		r := &priority.Resolver{
+			MaxConcurrent: c.MaxConcurrent,
+			Upstream:      p.Upstream,
+		}
+		for _, client := range c.LowPriority {
+			n, mac, err := priority.ParseClient(client)
+			if err != nil {
+				return fmt.Errorf("low-priority: %v", err)
+			}
+			if mac != nil {
+				r.MACs = append(r.MACs, mac)
+			} else {
+				r.Clients = append(r.Clients, n)
+			}
+		}
+		p.Upstream = r
+	}
+
+	if len(c.ZoneFiles) > 0 {
+		r := &zone.Resolver{
+			Upstream: p.Upstream,
+			ErrorLog: func(err error) {
+				log.Errorf("Zone file: %v", err)
+			},
+		}
+		for _, spec := range c.ZoneFiles {
+			f := zone.ParseFileSpec(spec)
+			if err := f.Load(); err != nil {
+				return fmt.Errorf("zone-file: %v", err)
+			}
+			log.Infof("Serving zone %s from %s", f.Zone().Origin(), f.Path)
+			r.Files = append(r.Files, f)
+		}
+		p.Upstream = r
+		p.OnInit = append(p.OnInit, r.Start)
+	}
+
+	var dockerResolver *docker.Resolver
+	embeddedDNS := c.Container && usesEmbeddedDNS()
+	if c.Docker || embeddedDNS {
+		dockerResolver = &docker.Resolver{
+			Embedded: embeddedDNS,
+			Upstream: p.Upstream,
+			ErrorLog: func(err error) {
+				log.Errorf("Docker: %v", err)
+			},
+		}
+		if c.Docker {
+			dockerResolver.Socket = c.DockerSocket
+		}
+		if embeddedDNS {
+			log.Info("Container: resolving single-label names with the Docker embedded DNS")
+		}
+		p.Upstream = dockerResolver
+		p.OnInit = append(p.OnInit, dockerResolver.Start)
+	}
+
+	if len(c.Rewrites) > 0 || len(c.SearchDomains) > 0 {
+		r := &rewrite.Resolver{
+			Rules:    c.Rewrites,
+			Upstream: p.Upstream,
+		}
+		if prefixes != nil {
+			r.ExpandAddr = prefixes.Expand
+		}
+		if len(c.SearchDomains) > 0 {
+			r.SearchDomain = func(q resolver.Query) string {
+				return c.SearchDomains.Get(q.PeerIP, q.MAC)
+			}
+		}
+		p.Upstream = r
+	}
+
+	if prefixes != nil {
+		p.LocalNet = localNet
+		p.OnInit = append(p.OnInit, prefixes.Start)
+	}
+
+	switch c.TunnelAction {
+	case "":
+	case tunnel.ActionLog, tunnel.ActionRateLimit, tunnel.ActionBlock:
+		p.Upstream = &tunnel.Resolver{
+			MaxLabelLength: c.TunnelLabelLength,
+			MaxEntropy:     c.TunnelEntropy,
+			MaxTXTRate:     c.TunnelTXTRate,
+			Action:         c.TunnelAction,
+			RateLimit:      10,
+			OnDetect: func(d tunnel.Detection) {
+				log.Warning(d.String())
+				p.events.Emit(events.TunnelDetected, events.Data{
+					"client": d.Client,
+					"name":   d.Name,
+					"type":   d.Type,
+					"domain": d.Domain,
+					"reason": d.Reason,
+					"action": d.Action,
+				})
+			},
+			Upstream: p.Upstream,
+		}
+	default:
+		return fmt.Errorf("%s: invalid tunnel-detection action", c.TunnelAction)
+	}
+
+	switch c.PrivateRelay {
+	case "":
+	case privaterelay.ActionLog, privaterelay.ActionBlock:
+		p.Upstream = &privaterelay.Resolver{
+			Action: c.PrivateRelay,
+			OnDetect: func(d privaterelay.Detection) {
+				log.Info(d.String())
+				p.events.Emit(events.PrivateRelayDetected, events.Data{
+					"client": d.Client,
+					"name":   d.Name,
+					"action": d.Action,
+				})
+			},
+			Upstream: p.Upstream,
+		}
+	default:
+		return fmt.Errorf("%s: invalid private-relay action", c.PrivateRelay)
+	}
+
+	if c.Script != "" {
+		sc, err := script.Load(c.Script)
+		if err != nil {
+			return fmt.Errorf("script: %v", err)
+		}
+		r := &script.Resolver{
+			Script:    sc,
+			Upstream:  p.Upstream,
+			Upstreams: map[string]resolver.Resolver{},
+			Log: func(msg string) {
+				log.Infof("Script: %s", msg)
+			},
+			ErrorLog: func(err error) {
+				log.Errorf("Script: %v", err)
+			},
+		}
+		for _, u := range c.Upstreams {
+			ur, err := u.Resolver()
+			if err != nil {
+				return fmt.Errorf("script: upstream %s: %v", u.Name, err)
+			}
+			if dr, ok := ur.(*resolver.DNS); ok {
+				dr.Manager.Dialer = fwdDialer
+				dr.Manager.ECH = ech
+			}
+			r.Upstreams[u.Name] = ur
+		}
+		p.Upstream = r
+	}
+
+	if sched != nil {
+		sched.Upstream = p.Upstream
+		p.Upstream = sched
+		p.OnInit = append(p.OnInit, sched.Start)
+		if schedProfiles {
+			p.FilterBypass = func(q resolver.Query) bool {
+				prof, ok := sched.Profile(q.PeerIP, q.MAC, time.Now())
+				return ok && prof == schedule.ProfileOff
+			}
+		}
+	}
+	if paused != nil {
+		bypass := p.FilterBypass
+		p.FilterBypass = func(q resolver.Query) bool {
+			return paused.Paused(q.PeerIP, q.MAC) || bypass != nil && bypass(q)
+		}
+	}
+	noFilter := map[string]bool{}
+	for _, inst := range c.Instances {
+		if inst.NoFilter {
+			noFilter[inst.Name] = true
+		}
+	}
+	if len(noFilter) > 0 {
+		bypass := p.FilterBypass
+		p.FilterBypass = func(q resolver.Query) bool {
+			return noFilter[q.Instance] || bypass != nil && bypass(q)
+		}
+	}
+
+	var rewrites []func(q resolver.Query, buf []byte, n int) (int, error)
+	if len(c.BlockAAAA) > 0 {
+		blockAAAA, err := setupBlockAAAA(c.BlockAAAA)
+		if err != nil {
+			return fmt.Errorf("block-aaaa: %v", err)
+		}
+		rewrites = append(rewrites, blockAAAA)
+	}
+	if len(c.SVCBStrip) > 0 {
+		var keys []dnsmessage.SVCParamKey
+		for _, name := range c.SVCBStrip {
+			key, err := rewrite.ParseSVCParamKey(name)
+			if err != nil {
+				return fmt.Errorf("svcb-strip: %v", err)
+			}
+			keys = append(keys, key)
+		}
+		rewrites = append(rewrites, func(q resolver.Query, buf []byte, n int) (int, error) {
+			return rewrite.StripSVCParams(keys, buf, n)
+		})
+	}
+	if len(c.ResponseRewrites) > 0 {
+		rules := c.ResponseRewrites
+		rewrites = append(rewrites, func(q resolver.Query, buf []byte, n int) (int, error) {
+			return rewrite.RewriteResponse(rules, buf, n)
+		})
+	}
+	if c.TTLMin > 0 || c.TTLMax > 0 {
+		if c.TTLMax > 0 && c.TTLMin > c.TTLMax {
+			return errors.New("ttl-min: greater than ttl-max")
+		}
+		// Applied last, so the TTLs set by the response rewrite rules are
+		// clamped too.
+		clamp := rewrite.TTLClamp{
+			Min:     uint32(c.TTLMin / time.Second),
+			Max:     uint32(c.TTLMax / time.Second),
+			Exclude: c.TTLExclude,
+		}
+		rewrites = append(rewrites, func(q resolver.Query, buf []byte, n int) (int, error) {
+			return clamp.Apply(buf, n)
+		})
+	}
+	if len(rewrites) > 0 {
+		p.RewriteResponse = func(q resolver.Query, buf []byte, n int) (_ int, err error) {
+			for _, rw := range rewrites {
+				if n, err = rw(q, buf, n); err != nil {
+					return n, err
+				}
+			}
+			return n, nil
+		}
+	}
+
+	if len(c.Provenance) > 0 {
+		if p.Provenance, err = clientMatcher(c.Provenance); err != nil {
+			return fmt.Errorf("provenance: %v", err)
+		}
+	}
+
+	logPolicy := &privacy.Policy{Anonymize: c.LogAnonymize, Exclude: c.LogExclude}
+	if err := logPolicy.Validate(); err != nil {
+		return fmt.Errorf("log-anonymize: %v", err)
+	}
+	// recorded wraps the query log function f recording queries locally, so
+	// the log policy applies.
+	recorded := func(f func(proxy.QueryInfo)) func(proxy.QueryInfo) {
+		return func(q proxy.QueryInfo) {
+			if q, ok := logPolicy.Apply(q); ok {
+				f(q)
+			}
+		}
+	}
+
+	var queryLogs []func(proxy.QueryInfo)
+	if c.LogQueries {
+		queryLogs = append(queryLogs, recorded(func(q proxy.QueryInfo) {
+			var errStr string
+			if q.Error != nil {
+				errStr = ": " + q.Error.Error()
+			}
+			client := clientID(q, c.StableClientID)
+			if q.DeviceName != "" {
+				client += " (" + q.DeviceName + ")"
+			}
+			var details string
+			if q.Attempts > 1 {
+				details = fmt.Sprintf(" (%d attempts)", q.Attempts)
+			}
+			if timing := q.UpstreamTiming.String(); timing != "" {
+				details += " [" + timing + "]"
+			}
+			if q.EDE >= 0 {
+				details += fmt.Sprintf(" (EDE %d %s)", q.EDE, proxy.ExtendedErrorName(q.EDE))
+			}
+			log.Infof("Query %s %s %s %s (qry=%d/res=%d) %dms %s%s%s",
+				client,
+				q.Protocol,
+				q.Type,
+				q.Name,
+				q.QuerySize,
+				q.ResponseSize,
+				q.Duration/time.Millisecond,
+				q.UpstreamTransport,
+				details,
+				errStr)
+		}))
+	}
+	if c.QueryHistory != "" {
+		h := &history.Store{
+			Dir:       c.QueryHistory,
+			Retention: c.HistoryRetention,
+			ErrorLog: func(err error) {
+				log.Error(err)
+			},
+		}
+		if err := h.Init(); err != nil {
+			return fmt.Errorf("query-history: %v", err)
+		}
+		p.OnInit = append(p.OnInit, h.Start)
+		var off int32
+		memoryShed["query history"] = func() {
+			atomic.StoreInt32(&off, 1)
+		}
+		queryLogs = append(queryLogs, recorded(func(q proxy.QueryInfo) {
+			if atomic.LoadInt32(&off) != 0 {
+				return
+			}
+			r := history.Record{
+				Time:         time.Now().Add(-q.Duration),
+				Client:       q.PeerIP.String(),
+				Device:       q.DeviceName,
+				Protocol:     q.Protocol,
+				Name:         strings.TrimSuffix(q.Name, "."),
+				Type:         q.Type,
+				ResponseSize: q.ResponseSize,
+				Duration:     float64(q.Duration) / float64(time.Millisecond),
+				Transport:    q.UpstreamTransport,
+				RCode:        q.RCode,
+			}
+			if q.MAC != nil {
+				r.MAC = q.MAC.String()
+			}
+			if q.Error != nil {
+				r.Error = q.Error.Error()
+			}
+			h.Record(r)
+		}))
+	}
+	budget := &privacy.Budget{DomainCap: c.ShareDomainCap, AggregateClients: c.ShareAggregate}
+	if p.ctl != nil {
+		p.ctl.Command("shared", func(args []string) (interface{}, error) {
+			return budget.Report(), nil
+		})
+	}
+	if c.SLOP50 > 0 || c.SLOP95 > 0 || c.SLOErrorRate > 0 {
+		m := &slo.Monitor{
+			Window:     c.SLOWindow,
+			P50:        c.SLOP50,
+			P95:        c.SLOP95,
+			ErrorRate:  c.SLOErrorRate / 100,
+			MinSamples: 20,
+			OnAlert: func(a slo.Alert) {
+				typ := events.SLOBreached
+				if a.Resolved {
+					log.Info(a.String())
+					typ = events.SLORestored
+				} else {
+					log.Warning(a.String())
+				}
+				p.events.Emit(typ, events.Data{"stats": a.Stats, "violations": a.Violations})
+				if c.SLOWebhook != "" {
+					evidence := a.Evidence
+					a.Evidence = nil
+					for _, s := range evidence {
+						if budget.Domain(s.Name) {
+							a.Evidence = append(a.Evidence, s)
+						}
+					}
+					go func() {
+						if err := postWebhook(budget, "slo", c.SLOWebhook, a); err != nil {
+							log.Errorf("SLO webhook: %v", err)
+						}
+					}()
+				}
+			},
+		}
+		p.OnInit = append(p.OnInit, m.Start)
+		queryLogs = append(queryLogs, func(q proxy.QueryInfo) {
+			if q.UpstreamTransport == "" && q.Error == nil {
+				// Answered locally.
+				return
+			}
+			s := slo.Sample{Name: q.Name, Type: q.Type, Duration: q.Duration}
+			if q.Error != nil {
+				s.Error = q.Error.Error()
+			}
+			m.Record(s)
+		})
+	}
+	var dg *downgrade.Monitor
+	if c.DowngradeAlert > 0 {
+		dg = setupDowngrade(p, c.DowngradeAlert, c.DowngradeWebhook, budget)
+	}
+	var hd *hijack.Detector
+	if c.HijackCheck > 0 {
+		hd = setupHijack(p, c.HijackCheck)
+	}
+	if len(c.HealthLEDs) > 0 || c.HealthCommand != "" || c.HealthCheck != "" {
+		m := &health.Monitor{
+			OnChange: func(s health.State) {
+				log.Infof("Health: %s", s)
+			},
+		}
+		if len(c.HealthLEDs) > 0 || c.HealthCommand != "" {
+			ind := &health.Indicator{
+				LEDs:    c.HealthLEDs,
+				Command: c.HealthCommand,
+				ErrorLog: func(err error) {
+					log.Errorf("Health indicator: %v", err)
+				},
+			}
+			m.OnChange = func(s health.State) {
+				log.Infof("Health: %s", s)
+				ind.Set(s)
+			}
+			p.OnInit = append(p.OnInit, func(ctx context.Context) {
+				ind.Set(m.State())
+				<-ctx.Done()
+				ind.Off()
+			})
+		}
+		if c.HealthCheck != "" {
+			p.OnInit = append(p.OnInit, func(ctx context.Context) {
+				log.Infof("Starting health check server on %s", c.HealthCheck)
+				if err := m.ListenAndServe(ctx, c.HealthCheck); err != nil && !errors.Is(err, context.Canceled) {
+					log.Errorf("Health check server: %v", err)
+				}
+			})
+		}
+		if mgr := p.resolver.Manager; mgr != nil {
+			onChange := mgr.OnChange
+			mgr.OnChange = func(e endpoint.Endpoint) {
+				if onChange != nil {
+					onChange(e)
+				}
+				m.SetFallback(e.Protocol() == endpoint.ProtocolDNS)
+			}
+		}
+		queryLogs = append(queryLogs, func(q proxy.QueryInfo) {
+			if q.UpstreamTransport == "" && q.Error == nil {
+				// Answered locally.
+				return
+			}
+			m.Record(q.Error)
+		})
+	}
+	if c.AnomalyThreshold > 0 {
+		d := &anomaly.Detector{
+			Interval:  c.AnomalyInterval,
+			Threshold: c.AnomalyThreshold,
+			Sample:    c.AnomalySample,
+			OnAnomaly: func(a anomaly.Anomaly) {
+				log.Warning(a.String())
+				p.events.Emit(events.AnomalyDetected, events.Data{
+					"client":    a.Client,
+					"name":      a.Name,
+					"metric":    a.Metric,
+					"value":     a.Value,
+					"baseline":  a.Baseline,
+					"deviation": a.Deviation,
+					"domains":   a.Domains,
+				})
+				if c.AnomalyWebhook != "" {
+					a.Client = budget.Client(a.Client)
+					if budget.AggregateClients {
+						a.Name = ""
+					}
+					a.Domains = budget.Domains(a.Domains)
+					go func() {
+						if err := postWebhook(budget, "anomaly", c.AnomalyWebhook, a); err != nil {
+							log.Errorf("Anomaly webhook: %v", err)
+						}
+					}()
+				}
+			},
+		}
+		p.OnInit = append(p.OnInit, d.Start)
+		var off int32
+		memoryShed["anomaly detection"] = func() {
+			atomic.StoreInt32(&off, 1)
+			d.Reset()
+		}
+		queryLogs = append(queryLogs, func(q proxy.QueryInfo) {
+			if q.PeerIP == nil || q.PeerIP.IsLoopback() || atomic.LoadInt32(&off) != 0 {
+				return
+			}
+			d.Record(clientID(q, c.StableClientID), q.DeviceName, q.Name)
+		})
+	}
+	if len(c.WatchDomains) > 0 {
+		w := &answerwatch.Watcher{
+			Domains:  c.WatchDomains,
+			Interval: c.WatchInterval,
+			Upstream: upstream,
+			OnChange: func(ch answerwatch.Change) {
+				log.Warning(ch.String())
+				p.events.Emit(events.AnswerChanged, events.Data{
+					"name": ch.Name,
+					"type": ch.Type,
+					"old":  ch.Old,
+					"new":  ch.New,
+				})
+				if c.WatchWebhook != "" {
+					go func() {
+						if err := postWebhook(budget, "watch", c.WatchWebhook, ch); err != nil {
+							log.Errorf("Watch webhook: %v", err)
+						}
+					}()
+				}
+			},
+			ErrorLog: func(err error) {
+				log.Error(err)
+			},
+		}
+		p.OnInit = append(p.OnInit, func(ctx context.Context) {
+			w.Start(resolver.ContextWithRetryPolicy(ctx, p.Retry))
+		})
+	}
+	if c.WebUI != "" {
+		record, reset, err := setupWebUI(p, &c, "nextdns "+cmd, args, useStorage)
+		if err != nil {
+			return fmt.Errorf("web-ui: %v", err)
+		}
+		var off int32
+		memoryShed["web-ui statistics"] = func() {
+			atomic.StoreInt32(&off, 1)
+			reset()
+		}
+		queryLogs = append(queryLogs, recorded(func(q proxy.QueryInfo) {
+			if atomic.LoadInt32(&off) == 0 {
+				record(q)
+			}
+		}))
+	}
+	if p.ctl != nil {
+		r := &top.Rollup{}
+		var off int32
+		memoryShed["top statistics"] = func() {
+			atomic.StoreInt32(&off, 1)
+			r.Reset()
+		}
+		queryLogs = append(queryLogs, recorded(func(q proxy.QueryInfo) {
+			if atomic.LoadInt32(&off) != 0 {
+				return
+			}
+			client := q.PeerIP.String()
+			if q.DeviceName != "" {
+				client += " (" + q.DeviceName + ")"
+			}
+			rcode := q.RCode
+			if rcode == "" {
+				// No response was sent.
+				rcode = "ERROR"
+			}
+			r.Record(q.Name, client, rcode, q.Duration)
+		}))
+		p.ctl.Command("top", func(args []string) (interface{}, error) {
+			n := 10
+			if len(args) > 0 {
+				var err error
+				if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+					return nil, fmt.Errorf("%s: invalid count", args[0])
+				}
+			}
+			return r.Top(n), nil
+		})
+		queryLogs = append(queryLogs, setupStatus(p, dg, hd, paused, cacheOnly, failover))
+		setupConfigCommands(p, &c, "nextdns "+cmd, args, useStorage)
+	}
+	if c.API != "" {
+		h := &ctl.HTTPServer{
+			Server:        p.ctl,
+			Addr:          c.API,
+			Token:         c.APIToken,
+			ReadOnlyToken: c.APIReadOnlyToken,
+			CertFile:      c.APICert,
+			KeyFile:       c.APIKey,
+		}
+		if err := h.Validate(); err != nil {
+			return fmt.Errorf("api: %v", err)
+		}
+		p.OnInit = append(p.OnInit, func(ctx context.Context) {
+			log.Infof("Serving management API on %s", h.Addr)
+			if err := h.ListenAndServe(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Errorf("Management API: %v", err)
+			}
+		})
+	}
+	if c.GOGC != 0 {
+		debug.SetGCPercent(c.GOGC)
+	}
+	if c.GCBallast > 0 {
+		gcBallast = make([]byte, c.GCBallast<<20)
+	}
+	if c.MemoryLimit > 0 {
+		// Steps from the least to the most disruptive.
+		steps := []memlimit.Step{{Name: "gc", Shed: func() {
+			debug.SetGCPercent(25)
+		}}}
+		for _, name := range []string{"negative cache", "web-ui statistics", "top statistics", "anomaly detection", "query history"} {
+			if f := memoryShed[name]; f != nil {
+				steps = append(steps, memlimit.Step{Name: name, Shed: f})
+			}
+		}
+		setupMemoryLimit(p, uint64(c.MemoryLimit)<<20, steps)
+	}
+	if c.QuietMaintenance {
+		queryLogs = append(queryLogs, setupMaintenance(p, maintenanceTasks, c.QueryHistory))
+	}
+	if len(queryLogs) > 0 {
+		p.QueryLog = func(q proxy.QueryInfo) {
+			for _, f := range queryLogs {
+				f(q)
+			}
+		}
+	}
+	p.InfoLog = func(msg string) {
+		log.Info(msg)
+	}
+	p.ErrorLog = func(err error) {
+		log.Error(err)
+		p.events.Emit(events.Error, events.Data{"error": err.Error()})
+	}
+	localhostMode := isLocalhostMode(&c)
+	disco := &discovery.Resolver{}
+	if !localhostMode && len(forwarders) == 0 && (c.ReportClientInfo || c.DiscoveryPTR || c.StableClientID || schedNames || clientsFile != nil || len(leaseFiles) > 0) {
+		// Only enable discovery if configured to listen to requests outside
+		// the local host.
+		if c.Docker {
+			disco.Register("docker", discovery.ConfidenceDHCP, dockerResolver)
+		}
+		setupDiscovery(p, disco, c.ClientNames, clientsFile, leaseFiles)
+		p.ClientMAC = disco.LookupMAC
+	}
+	if schedNames {
+		sched.ClientName = func(ip net.IP, mac net.HardwareAddr) string {
+			var name string
+			if mac != nil {
+				name = disco.Lookup(mac.String())
+			}
+			if name == "" && ip != nil {
+				name = disco.Lookup(ip.String())
+			}
+			return name
+		}
+	}
+	if c.ReportClientInfo {
+		setupClientReporting(p, &c.Conf, disco)
+	} else if (clientsFile != nil || len(leaseFiles) > 0) && !localhostMode {
+		// Name the clients in the logs without reporting them upstream.
+		p.DeviceInfo = func(ip net.IP, mac net.HardwareAddr) (name, model string) {
+			if mac != nil {
+				name = disco.Lookup(mac.String())
+			}
+			if name == "" && ip != nil {
+				name = disco.Lookup(ip.String())
+			}
+			return name, ""
+		}
+	}
+	if c.DiscoveryPTR {
+		p.LocalPTR = func(ip net.IP) string {
+			if name := disco.Lookup(ip.String()); name != "" {
+				return name + "."
+			}
+			return ""
+		}
+	}
+	if c.Portal != "" {
+		if err := setupPortal(p, &c); err != nil {
+			return err
+		}
+	}
+	// When the network changes, connections to the endpoints are likely stale
+	// and another endpoint may be better: close the idle connections and
+	// trigger a re-negotiation of the best endpoint sooner than later.
+	p.OnInit = append(p.OnInit, func(ctx context.Context) {
+		netChange := make(chan netstatus.Change, 1)
+		netstatus.Notify(netChange)
+		defer netstatus.Stop(netChange)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case c := <-netChange:
+				log.Infof("Network change detected: %s", c)
+				p.events.Emit(events.NetworkChanged, events.Data{"change": c.String()})
+				if localhostMode {
+					// If only listening on localhost, we may be running on a
+					// laptop or other sort of device that might change network
+					// from time to time. Reset the startup time so plain DNS
+					// fallback happen again (useful for captive portals).
+					startup = time.Now()
+				}
+				p.resolver.Manager.CloseIdleConnections()
+				if err := p.resolver.Manager.Test(ctx); err != nil {
+					log.Errorf("Test after network change failed: %v", err)
+				}
+			}
+		}
+	})
+	if failover != nil {
+		setupFailover(p, failover, proxies)
+	}
+	setupOfflineStart(p, func() {
+		// Give plain DNS fallback its delay from the time the network is
+		// reachable, so the time can still be synced with NTP.
+		startup = time.Now()
+	})
+	if c.SteeringInterval > 0 {
+		setupSteering(p, c.SteeringInterval)
+	}
+
+	if c.User != "" || c.Group != "" {
+		if c.SetupRouter || c.AutoActivate {
+			return errors.New("user and group cannot be used with setup-router or auto-activate")
+		}
+		if len(c.Instances) > 0 {
+			// The addresses of the instances are bound when serving.
+			return errors.New("user and group cannot be used with instance")
+		}
+		if len(p.Files) == 0 {
+			if p.Files, err = p.Bind(); err != nil {
+				return err
+			}
+		}
+		if err := host.DropPrivileges(c.User, c.Group); errors.Is(err, host.ErrCapsNotKept) {
+			log.Warningf("Dropping privileges: %v", err)
+		} else if err != nil {
+			return fmt.Errorf("dropping privileges: %v", err)
+		}
+		log.Infof("Running as user=%s group=%s", c.User, c.Group)
+	}
+
+	return service.Run("nextdns", p)
+}
+
+// mdnsServices returns the DNS-SD services of the listen addresses reachable
+// from the network.
+func mdnsServices(listen string) []mdns.Service {
+	var services []mdns.Service
+	seen := map[string]bool{}
+	for _, a := range strings.Split(listen, ",") {
+		network := ""
+		if idx := strings.Index(a, "://"); idx != -1 {
+			network, a = strings.TrimSpace(a[:idx]), a[idx+3:]
+		}
+		a = strings.TrimSpace(a)
+		if a == "" || isLoopbackAddr(a) {
+			continue
+		}
+		_, port, err := net.SplitHostPort(a)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			continue
+		}
+		for _, proto := range []string{"udp", "tcp"} {
+			typ := "_dns._" + proto
+			if (network == "" || network == proto) && !seen[typ] {
+				seen[typ] = true
+				services = append(services, mdns.Service{
+					Type: typ,
+					Port: uint16(n),
+					TXT:  []string{"version=" + version},
+				})
+			}
+		}
+	}
+	return services
+}
+
+// listensOnPort53 returns true if one of the listen addresses is reachable from
+// the network on the standard DNS port, as announced DNS servers have no port.
+func listensOnPort53(listen string) bool {
+	for _, a := range proxy.SplitAddr(listen) {
+		if isLoopbackAddr(a) {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(a); err == nil && port == "53" {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalhostMode returns true if listen is only listening for the local host.
+func isLocalhostMode(c *config.Config) bool {
+	if c.SetupRouter {
+		// The listen arg is irrelevant when in router mode.
+		return false
+	}
+	addrs := proxy.SplitAddr(c.Listen)
+	for _, addr := range addrs {
+		if !isLoopbackAddr(addr) {
+			return false
+		}
+	}
+	return len(addrs) > 0
+}
+
+// isLoopbackAddr returns true if the listen address addr is only reachable
+// from the local host.
+func isLoopbackAddr(addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		switch host {
+		case "localhost", "127.0.0.1", "::1":
+			return true
+		}
+		if ips := hosts.LookupHost(host); len(ips) > 0 {
+			for _, ip := range ips {
+				if !net.ParseIP(ip).IsLoopback() {
+					return false
+				}
+			}
+			return true
+		}
+		return net.ParseIP(host).IsLoopback()
+	}
+	return false
+}
+
+// setupBlockAAAA returns a response rewrite suppressing the AAAA answers and
+// IPv6 hints sent to clients, an IP, CIDR or MAC address, or all clients.
+func setupBlockAAAA(clients []string) (func(q resolver.Query, buf []byte, n int) (int, error), error) {
+	blocked, err := clientMatcher(clients)
+	if err != nil {
+		return nil, err
+	}
+	return func(q resolver.Query, buf []byte, n int) (int, error) {
+		if (q.Type != "AAAA" && q.Type != "HTTPS" && q.Type != "SVCB") || !blocked(q) {
+			return n, nil
+		}
+		return rewrite.DropAAAA(buf, n)
+	}, nil
+}
+
+// clientMatcher returns a function reporting the queries sent by clients, an
+// IP, CIDR or MAC address, or all clients.
+func clientMatcher(clients []string) (func(q resolver.Query) bool, error) {
+	all := false
+	var nets []*net.IPNet
+	var macs []net.HardwareAddr
+	for _, client := range clients {
+		if client == "all" {
+			all = true
+			continue
+		}
+		n, mac, err := priority.ParseClient(client)
+		if err != nil {
+			return nil, err
+		}
+		if mac != nil {
+			macs = append(macs, mac)
+		} else {
+			nets = append(nets, n)
+		}
+	}
+	return func(q resolver.Query) bool {
+		if all {
+			return true
+		}
+		for _, n := range nets {
+			if n.Contains(q.PeerIP) {
+				return true
+			}
+		}
+		for _, mac := range macs {
+			if bytes.Equal(mac, q.MAC) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// setupSteering periodically measures the RTT of the NextDNS endpoints to
+// steer queries to the fastest one, and measures them again right away when
+// the network changes.
+func setupSteering(p *proxySvc, interval time.Duration) {
+	mgr := p.resolver.Manager
+	mgr.OnSteer = func(from, to endpoint.Endpoint, fromRTT, toRTT time.Duration) {
+		p.log.Infof("Steering to faster endpoint: %s (%dms) -> %s (%dms)",
+			from, fromRTT/time.Millisecond, to, toRTT/time.Millisecond)
+	}
+	p.OnInit = append(p.OnInit, func(ctx context.Context) {
+		netChange := make(chan netstatus.Change, 1)
+		netstatus.Notify(netChange)
+		defer netstatus.Stop(netChange)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-netChange:
+				mgr.ResetSteering()
+			case <-t.C:
+			}
+			if err := mgr.Steer(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				p.log.Warningf("Endpoint steering: %v", err)
+			}
+		}
+	})
+	if p.ctl != nil {
+		p.ctl.Command("rtt", func(args []string) (interface{}, error) {
+			rtts := map[string]int{}
+			for e, rtt := range mgr.RTTs() {
+				rtts[e] = int(rtt / time.Millisecond)
+			}
+			return rtts, nil
+		})
+	}
+}
+
+// postWebhook posts v to the webhook url, after recording it in the report of
+// the payloads shared off-box.
+func postWebhook(budget *privacy.Budget, kind, url string, v interface{}) error {
+	budget.Record(kind, url, v)
+	return webhook.Post(context.Background(), url, v)
+}
+
+// setupDowngrade reports queries answered over the plain DNS fallback for
+// longer than threshold in the logs, the event stream and to webhookURL if not
+// empty.
+func setupDowngrade(p *proxySvc, threshold time.Duration, webhookURL string, budget *privacy.Budget) *downgrade.Monitor {
+	m := &downgrade.Monitor{
+		Threshold: threshold,
+		OnAlert: func(a downgrade.Alert) {
+			typ := events.DowngradeResolved
+			if a.Resolved {
+				p.log.Info(a.String())
+			} else {
+				p.log.Warning(a.String())
+				typ = events.DowngradeDetected
+			}
+			p.events.Emit(typ, events.Data{"endpoint": a.Endpoint, "since": a.Since, "duration_s": int(a.Duration / time.Second)})
+			if webhookURL != "" {
+				if err := postWebhook(budget, "downgrade", webhookURL, a); err != nil {
+					p.log.Errorf("Downgrade webhook: %v", err)
+				}
+			}
+		},
+	}
+	if mgr := p.resolver.Manager; mgr != nil {
+		onChange := mgr.OnChange
+		mgr.OnChange = func(e endpoint.Endpoint) {
+			if onChange != nil {
+				onChange(e)
+			}
+			m.SetEndpoint(e.String(), e.Protocol() == endpoint.ProtocolDNS)
+		}
+	}
+	return m
+}
+
+// Bounds of the backoff between the tests of the upstream while it is
+// unreachable after an offline start.
+const (
+	minOfflineBackoff = time.Second
+	maxOfflineBackoff = time.Minute
+)
+
+// setupOfflineStart tests the upstream each time the proxy starts and, while
+// it is unreachable (i.e. on a router starting before its WAN link), keeps
+// testing it with an exponential backoff instead of waiting for queries to
+// trigger tests. Meanwhile, queries are still answered from the cache and the
+// local records. onReachable is called when the upstream becomes reachable
+// after an offline start.
+func setupOfflineStart(p *proxySvc, onReachable func()) {
+	p.OnInit = append(p.OnInit, func(ctx context.Context) {
+		m := p.resolver.Manager
+		test := func() error {
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			return m.Do(ctx, func(e endpoint.Endpoint) error {
+				return e.Test(ctx, endpoint.TestDomain)
+			})
+		}
+		err := test()
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		offline := time.Now()
+		p.log.Warningf("Upstream unreachable, serving cached and local answers only: %v", err)
+		p.events.Emit(events.UpstreamOffline, events.Data{"error": err.Error()})
+		for backoff := minOfflineBackoff; ; {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if err = test(); err == nil {
+				break
+			}
+			backoff = nextBackoff(backoff, maxOfflineBackoff)
+		}
+		d := time.Since(offline).Round(time.Second)
+		p.log.Infof("Upstream reachable after %v", d)
+		p.events.Emit(events.UpstreamOnline, events.Data{"offline": d.String()})
+		onReachable()
+		// The active endpoint may be a fallback selected while offline.
+		if err := m.Test(ctx); err != nil {
+			p.log.Errorf("Test after offline start failed: %v", err)
+		}
+	})
+}
+
+// setupFailover checks the path to NextDNS through the primary upstream
+// interface and moves the upstream connections to the backup interface while
+// it is down.
+func setupFailover(p *proxySvc, f *outbound.Failover, proxies outbound.Proxies) {
+	var probe *endpoint.DOHEndpoint
+	f.Check = func(ctx context.Context, d *net.Dialer) error {
+		if probe == nil {
+			probe = &endpoint.DOHEndpoint{
+				Hostname:  "dns1.nextdns.io",
+				Bootstrap: []string{"45.90.28.0", "2a07:a8c0::"},
+				Dialer:    d,
+				Proxy:     proxies.Get("dns1.nextdns.io"),
+			}
+		}
+		return probe.Test(ctx, endpoint.TestDomain)
+	}
+	f.OnChange = func(iface string, err error) {
+		if err != nil {
+			p.log.Warningf("Upstream path through %s down, switching to %s: %v", f.Primary, iface, err)
+			p.events.Emit(events.UpstreamPath, events.Data{"interface": iface, "backup": true, "error": err.Error()})
+		} else {
+			p.log.Infof("Upstream path through %s recovered, switching back", iface)
+			p.events.Emit(events.UpstreamPath, events.Data{"interface": iface, "backup": false})
+		}
+		// The next queries open connections through the new path.
+		p.resolver.Manager.CloseIdleConnections()
+	}
+	p.OnInit = append(p.OnInit, f.Start)
+}
+
+// setupHijack tests whether plain DNS is intercepted every interval and
+// reports changes in the logs and the event stream.
+func setupHijack(p *proxySvc, interval time.Duration) *hijack.Detector {
+	secure := &resolver.DNS{
+		Manager: &endpoint.Manager{
+			Providers: []endpoint.Provider{
+				endpoint.StaticProvider{endpoint.MustNew("https://dns.nextdns.io#45.90.28.0")},
+			},
+		},
+	}
+	d := &hijack.Detector{
+		Exchange: resolver.DNS53{}.Exchange,
+		Secure:   secure,
+		Server:   "45.90.28.0:53",
+		Domain:   endpoint.TestDomain,
+		Interval: interval,
+		OnChange: func(r hijack.Result) {
+			typ := events.HijackResolved
+			if r.Intercepted {
+				p.log.Warning(r.String())
+				typ = events.HijackDetected
+			} else {
+				p.log.Info(r.String())
+			}
+			p.events.Emit(typ, events.Data{"detail": r.Detail})
+		},
+	}
+	p.OnInit = append(p.OnInit, d.Start)
+	p.ctl.Command("hijack", func(args []string) (interface{}, error) {
+		if r, ok := d.Last(); ok {
+			return r, nil
+		}
+		return nil, errors.New("not checked yet")
+	})
+	return d
+}
+
+// setupMemoryLimit applies steps in order when the memory usage gets close to
+// limit bytes.
+func setupMemoryLimit(p *proxySvc, limit uint64, steps []memlimit.Step) {
+	g := &memlimit.Guard{
+		Limit: limit,
+		Steps: steps,
+		OnShed: func(step string, usage uint64) {
+			p.log.Warningf("Memory usage %dMB close to limit %dMB: shedding %s", usage>>20, limit>>20, step)
+		},
+		OnExceeded: func(usage uint64) {
+			p.log.Errorf("Memory usage %dMB exceeds limit %dMB with nothing left to shed", usage>>20, limit>>20)
+		},
+	}
+	p.OnInit = append(p.OnInit, g.Start)
+	if p.ctl != nil {
+		p.ctl.Command("memory", func(args []string) (interface{}, error) {
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			return map[string]interface{}{
+				"usage_mb": (m.Sys - m.HeapReleased) >> 20,
+				"limit_mb": limit >> 20,
+				"shed":     g.Applied(),
+			}, nil
+		})
+	}
+}
+
+// setupMaintenance runs tasks during the quiet hours learned from the query
+// volume, seeded from the query history in historyDir if not empty. It returns
+// the query log function feeding the scheduler.
+func setupMaintenance(p *proxySvc, tasks []maintenance.Task, historyDir string) func(proxy.QueryInfo) {
+	m := &maintenance.Scheduler{
+		Tasks: tasks,
+		InfoLog: func(msg string) {
+			p.log.Info(msg)
+		},
+		ErrorLog: func(err error) {
+			p.log.Error(err)
+		},
+	}
+	p.OnInit = append(p.OnInit, func(ctx context.Context) {
+		if historyDir != "" {
+			from := time.Now().AddDate(0, 0, -28)
+			err := m.Load(func(f func(time.Time)) error {
+				return history.Read(historyDir, from, time.Time{}, func(r history.Record) error {
+					f(r.Time)
+					return nil
+				})
+			})
+			if err != nil {
+				p.log.Errorf("Maintenance: cannot load query history: %v", err)
+			}
+		}
+		p.log.Infof("Maintenance scheduled at %02d:00 today", m.QuietHour(time.Now().Weekday()))
+		m.Start(ctx)
+	})
+	if p.ctl != nil {
+		p.ctl.Command("maintenance", func(args []string) (interface{}, error) {
+			hours := map[string]int{}
+			for d, h := range m.QuietHours() {
+				hours[time.Weekday(d).String()] = h
+			}
+			return hours, nil
+		})
+		p.ctl.Action("maintenance.run", func(args []string) (interface{}, error) {
+			m.Run(context.Background())
+			return nil, nil
+		})
+	}
+	return func(proxy.QueryInfo) {
+		m.Record()
+	}
+}
+
+// nextdnsEndpointManager returns a endpoint.Manager configured to connect to
+// NextDNS using different steering techniques.
+func nextdnsEndpointManager(log host.Logger, ev *events.Stream, hpm bool, dialer *net.Dialer, proxies outbound.Proxies, canFallback func() bool) *endpoint.Manager {
+	qs := "?stack=dual"
+	if hpm {
+		qs += "&hardened_privacy=1"
+	}
+	m := &endpoint.Manager{
+		Providers: []endpoint.Provider{
+			// Prefer unicast routing.
+			&endpoint.SourceURLProvider{
+				SourceURL: "https://router.nextdns.io" + qs,
+				Client: &http.Client{
+					Timeout: 5 * time.Second,
+					// Trick to avoid depending on DNS to contact the router API.
+					Transport: &endpoint.DOHEndpoint{Hostname: "router.nextdns.io", Bootstrap: []string{
+						"216.239.32.21",
+						"216.239.34.21",
+						"216.239.36.21",
+						"216.239.38.21",
+					}, Dialer: dialer, Proxy: proxies.Get("router.nextdns.io")},
+				},
+			},
+			// Fallback on anycast.
+			endpoint.StaticProvider([]endpoint.Endpoint{
+				endpoint.MustNew("https://dns1.nextdns.io#45.90.28.0,2a07:a8c0::"),
+				endpoint.MustNew("https://dns2.nextdns.io#45.90.30.0,2a07:a8c1::"),
+			}),
+		},
+		InitEndpoint: endpoint.MustNew("https://dns1.nextdns.io#45.90.28.0,2a07:a8c0::"),
+		Dialer:       dialer,
+		Proxy:        proxies.Get,
+		OnError: func(e endpoint.Endpoint, err error) {
+			log.Warningf("Endpoint failed: %v: %v", e, err)
+			ev.Emit(events.UpstreamFailed, events.Data{"endpoint": e.String(), "error": err.Error()})
+		},
+		OnProviderError: func(p endpoint.Provider, err error) {
+			log.Warningf("Endpoint provider failed: %v: %v", p, err)
+		},
+		OnConnect: func(ci *endpoint.ConnectInfo) {
+			log.Infof("Connected %s (con=%dms tls=%dms, %s)",
+				ci.ServerAddr,
+				ci.ConnectTimes[ci.ServerAddr]/time.Millisecond,
+				ci.TLSTime/time.Millisecond,
+				ci.TLSVersion)
+			ev.Emit(events.UpstreamConnected, events.Data{
+				"server":      ci.ServerAddr,
+				"connect_ms":  int(ci.ConnectTimes[ci.ServerAddr] / time.Millisecond),
+				"tls_ms":      int(ci.TLSTime / time.Millisecond),
+				"tls_version": ci.TLSVersion,
+			})
+		},
+		OnChange: func(e endpoint.Endpoint) {
+			log.Infof("Switching endpoint: %s", e)
+			ev.Emit(events.UpstreamSwitched, events.Data{"endpoint": e.String(), "protocol": e.Protocol().String()})
+		},
+	}
+	// Fallback on system DNS and set a short min test interval for when plain
+	// DNS protocol is used so we go back on safe DoH as soon as possible. This
+	// allows automatic handling of captive portals as well as NTP / DNS
+	// inter-dependency on some routers, when NTP needs DNS to sync the time,
+	// and DoH needs time properly set to establish a TLS session.
+	m.Providers = append(m.Providers, endpoint.ProviderFunc(func(ctx context.Context) ([]endpoint.Endpoint, error) {
+		if !canFallback() {
+			// Fallback disabled.
+			return nil, nil
+		}
+		ips := host.DNS()
+		endpoints := make([]endpoint.Endpoint, 0, len(ips)+1)
+		for _, ip := range ips {
+			endpoints = append(endpoints, &endpoint.DNSEndpoint{
+				Addr: net.JoinHostPort(ip, "53"),
 			})
 		}
 		// Add NextDNS anycast IP in case none of the system DNS works or we did
@@ -400,7 +2273,436 @@ func nextdnsEndpointManager(log host.Logger, hpm bool, canFallback func() bool)
 	return m
 }
 
-func setupClientReporting(p *proxySvc, conf *config.Configs, enableDiscovery bool) {
+// clientID returns the identifier of the client of q: its IP, or its MAC if
+// stable is true and the MAC is known so the IPv6 privacy addresses of a
+// device are aggregated.
+func clientID(q proxy.QueryInfo, stable bool) string {
+	if stable && q.MAC != nil {
+		return q.MAC.String()
+	}
+	return q.PeerIP.String()
+}
+
+// setupStatus registers the status and stats control commands and returns
+// the query log function counting queries.
+func setupStatus(p *proxySvc, dg *downgrade.Monitor, hd *hijack.Detector, paused *pause.State, cacheOnly *cacheonly.Switch, failover *outbound.Failover) func(proxy.QueryInfo) {
+	var queries, errs, local uint64
+	start := time.Now()
+	p.ctl.Command("status", func(args []string) (interface{}, error) {
+		st := map[string]interface{}{
+			"version":   version,
+			"platform":  platform,
+			"listen":    p.Addr,
+			"uptime":    int(time.Since(start) / time.Second),
+			"read_only": p.ctl.ReadOnly,
+		}
+		if dg != nil {
+			if since := dg.Downgraded(); !since.IsZero() {
+				st["downgraded_since"] = since
+			}
+		}
+		if hd != nil {
+			if r, _ := hd.Last(); r.Intercepted {
+				st["hijack"] = r.Detail
+			}
+		}
+		if paused != nil {
+			if pauses := paused.List(); len(pauses) > 0 {
+				st["paused"] = pauses
+			}
+		}
+		if cacheOnly != nil && cacheOnly.On() {
+			st["cache_only"] = true
+		}
+		if failover != nil {
+			st["upstream_interface"] = failover.Active()
+		}
+		return st, nil
+	})
+	p.ctl.Command("stats", func(args []string) (interface{}, error) {
+		return map[string]uint64{
+			"queries": atomic.LoadUint64(&queries),
+			"errors":  atomic.LoadUint64(&errs),
+			"local":   atomic.LoadUint64(&local),
+		}, nil
+	})
+	return func(q proxy.QueryInfo) {
+		atomic.AddUint64(&queries, 1)
+		if q.Error != nil {
+			atomic.AddUint64(&errs, 1)
+		} else if q.UpstreamTransport == "" {
+			atomic.AddUint64(&local, 1)
+		}
+	}
+}
+
+// setupConfigCommands registers the config.get action, returning the settings
+// with credentials redacted, and, when the configuration comes from the
+// storage, the config.set action which re-reads it with args, changes a
+// setting, saves it and restarts the service. Both are refused in read-only
+// mode as the configuration can reveal the network layout.
+func setupConfigCommands(p *proxySvc, c *config.Config, cmd string, args []string, useStorage bool) {
+	p.ctl.Action("config.get", func(names []string) (interface{}, error) {
+		if len(names) == 0 {
+			return nil, errors.New("usage: config.get NAME...")
+		}
+		settings := map[string][]string{}
+		for _, name := range names {
+			values, err := c.GetRedacted(name)
+			if err != nil {
+				return nil, err
+			}
+			settings[name] = values
+		}
+		return settings, nil
+	})
+	if !useStorage {
+		return
+	}
+	p.ctl.Action("config.set", func(cmdArgs []string) (interface{}, error) {
+		if len(cmdArgs) == 0 {
+			return nil, errors.New("usage: config.set NAME [VALUE...]")
+		}
+		var nc config.Config
+		nc.Parse(cmd, args, true)
+		if err := nc.Set(cmdArgs[0], cmdArgs[1:]...); err != nil {
+			return nil, err
+		}
+		if err := nc.Save(); err != nil {
+			return nil, err
+		}
+		restartAfterUpdate(p, "ctl")
+		return nil, nil
+	})
+}
+
+// restartAfterUpdate reports a configuration update from source and restarts
+// the service shortly after, giving the caller time to send its response.
+func restartAfterUpdate(p *proxySvc, source string) {
+	p.log.Infof("Configuration updated from %s", source)
+	p.events.Emit(events.ConfigUpdated, events.Data{"source": source})
+	go func() {
+		time.Sleep(time.Second)
+		if err := restartService(); err != nil {
+			p.log.Errorf("Restarting after configuration update: %v", err)
+		}
+	}()
+}
+
+// setupWebUI starts the web dashboard and returns the query log function
+// feeding it and a function resetting its statistics. Edits are only allowed when a password is set and the
+// configuration comes from the storage, which is then re-read with args before
+// saving the edits and restarting the service.
+func setupWebUI(p *proxySvc, c *config.Config, cmd string, args []string, useStorage bool) (func(proxy.QueryInfo), func(), error) {
+	var upstream atomic.Value
+	if mgr := p.resolver.Manager; mgr != nil {
+		if mgr.InitEndpoint != nil {
+			upstream.Store(mgr.InitEndpoint.String())
+		}
+		onChange := mgr.OnChange
+		mgr.OnChange = func(e endpoint.Endpoint) {
+			if onChange != nil {
+				onChange(e)
+			}
+			upstream.Store(e.String())
+		}
+	}
+	start := time.Now()
+	s := &webui.Server{
+		Addr:     c.WebUI,
+		Password: c.WebUIPassword,
+		Status: func() map[string]interface{} {
+			return map[string]interface{}{
+				"version":  version,
+				"platform": platform,
+				"listen":   p.Addr,
+				"uptime":   time.Since(start).Round(time.Second).String(),
+				"upstream": upstream.Load(),
+			}
+		},
+		Config: func() webui.Config {
+			return webui.Config{
+				Forwarders: c.Forwarders.Strings(),
+				Rewrites:   c.Rewrites.Strings(),
+			}
+		},
+	}
+	if c.WebUIPassword != "" && useStorage {
+		s.SaveConfig = func(wc webui.Config) error {
+			var nc config.Config
+			nc.Parse(cmd, args, true)
+			nc.Forwarders, nc.Rewrites = nil, nil
+			for _, v := range wc.Forwarders {
+				if err := nc.Forwarders.Set(v); err != nil {
+					return fmt.Errorf("forwarder %s: %v", v, err)
+				}
+			}
+			if err := nc.Forwarders.SetUpstreams(nc.Upstreams); err != nil {
+				return fmt.Errorf("forwarder: %v", err)
+			}
+			for _, v := range wc.Rewrites {
+				if err := nc.Rewrites.Set(v); err != nil {
+					return fmt.Errorf("rewrite %s: %v", v, err)
+				}
+			}
+			if err := nc.Save(); err != nil {
+				return err
+			}
+			restartAfterUpdate(p, "web-ui")
+			return nil
+		}
+	}
+	if err := s.Validate(); err != nil {
+		return nil, nil, err
+	}
+	p.OnInit = append(p.OnInit, func(ctx context.Context) {
+		p.log.Infof("Serving web UI on %s", s.Addr)
+		if err := s.ListenAndServe(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			p.log.Errorf("Web UI: %v", err)
+		}
+	})
+	return func(q proxy.QueryInfo) {
+		wq := webui.Query{
+			Time:      time.Now(),
+			Client:    clientID(q, c.StableClientID),
+			Name:      strings.TrimSuffix(q.Name, "."),
+			Type:      q.Type,
+			Duration:  float64(q.Duration) / float64(time.Millisecond),
+			Transport: q.UpstreamTransport,
+		}
+		if q.DeviceName != "" {
+			wq.Client = q.DeviceName + " (" + wq.Client + ")"
+		}
+		if q.Error != nil {
+			wq.Error = q.Error.Error()
+		}
+		s.Record(wq)
+	}, s.Reset, nil
+}
+
+func setupPortal(p *proxySvc, c *config.Config) error {
+	ip := net.ParseIP(c.Portal)
+	if ip == nil {
+		return fmt.Errorf("%s: invalid portal address", c.Portal)
+	}
+	if p.ctl == nil {
+		return errors.New("portal requires the control socket to approve devices")
+	}
+	pt := &portal.Portal{
+		IP:        ip,
+		StatePath: c.PortalStateFile,
+		DeviceName: func(ip net.IP, mac net.HardwareAddr) string {
+			if p.DeviceInfo == nil {
+				return ""
+			}
+			name, _ := p.DeviceInfo(ip, mac)
+			return name
+		},
+		OnNewDevice: func(d portal.Device) {
+			p.log.Infof("Portal: new device %s (%s %s) pending approval", d.MAC, d.IP, d.Name)
+			p.events.Emit(events.PortalPending, events.Data{
+				"mac":  d.MAC,
+				"ip":   d.IP,
+				"name": d.Name,
+			})
+		},
+		Upstream: p.Upstream,
+	}
+	if err := pt.Load(); err != nil {
+		return fmt.Errorf("portal: %v", err)
+	}
+	p.Upstream = pt
+	p.ctl.Command("portal.pending", func(args []string) (interface{}, error) {
+		return pt.Pending(), nil
+	})
+	p.ctl.Command("portal.approved", func(args []string) (interface{}, error) {
+		return pt.Approved(), nil
+	})
+	p.ctl.Action("portal.approve", func(args []string) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, errors.New("missing MAC address")
+		}
+		for _, mac := range args {
+			if err := pt.Approve(mac); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	p.ctl.Action("portal.revoke", func(args []string) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, errors.New("missing MAC address")
+		}
+		for _, mac := range args {
+			if err := pt.Revoke(mac); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return nil
+}
+
+// setupPause registers the control commands pausing and resuming filtering.
+func setupPause(p *proxySvc, paused *pause.State) {
+	p.ctl.Action("pause", func(args []string) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, errors.New("missing duration")
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return nil, err
+		}
+		var client string
+		if len(args) > 1 {
+			client = args[1]
+		}
+		ps, err := paused.Pause(client, d)
+		if err != nil {
+			return nil, err
+		}
+		who := "all clients"
+		if ps.Client != "" {
+			who = ps.Client
+		}
+		p.log.Infof("Filtering paused for %s until %s", who, ps.Until.Format(time.RFC3339))
+		return ps, nil
+	})
+	p.ctl.Action("resume", func(args []string) (interface{}, error) {
+		var client string
+		if len(args) > 0 {
+			client = args[0]
+		}
+		found, err := paused.Resume(client)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			p.log.Info("Filtering resumed")
+		}
+		return map[string]bool{"resumed": found}, nil
+	})
+	p.ctl.Command("pause.list", func(args []string) (interface{}, error) {
+		return paused.List(), nil
+	})
+}
+
+// setupProfiling registers the control commands collecting the profiles and
+// runtime metrics of the daemon, so they are only reachable locally.
+func setupProfiling(p *proxySvc) {
+	p.ctl.Action("pprof", func(args []string) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, errors.New("missing profile type")
+		}
+		d := defaultProfileDuration
+		if len(args) > 1 {
+			var err error
+			if d, err = time.ParseDuration(args[1]); err != nil {
+				return nil, err
+			}
+		}
+		p.log.Infof("Collecting %s profile", args[0])
+		return captureProfile(args[0], d)
+	})
+	p.ctl.Command("runtime", func(args []string) (interface{}, error) {
+		return runtimeMetrics(), nil
+	})
+}
+
+func setupBlockPage(p *proxySvc, c *config.Config) error {
+	ip := net.ParseIP(c.BlockPage)
+	if ip == nil {
+		return fmt.Errorf("%s: invalid block-page address", c.BlockPage)
+	}
+	bp := &blockpage.Server{
+		IP:            ip,
+		Password:      c.BlockPagePassword,
+		AllowDuration: c.BlockPageAllow,
+		Upstream:      p.Upstream,
+		ErrorLog: func(err error) {
+			p.log.Errorf("Block page: %v", err)
+		},
+	}
+	if f := p.Filter; f != nil {
+		if ip.To4() != nil {
+			f.Response = filter.Response{IPv4: ip.To4()}
+		} else {
+			f.Response = filter.Response{IPv6: ip}
+		}
+		bp.Reason = func(host string) string {
+			if rule, blocked := f.MatchRule(host); blocked {
+				return "blocked by rule " + rule
+			}
+			return ""
+		}
+		bp.Allow = func(host string, client net.IP, d time.Duration) {
+			until := f.AllowFor(host, d)
+			p.log.Infof("Block page: %s allowed until %s by %s", host, until.Format(time.RFC3339), client)
+			p.events.Emit(events.BlockPageAllowed, events.Data{
+				"domain": host,
+				"client": client.String(),
+				"until":  until,
+			})
+		}
+	}
+	p.Upstream = bp
+	p.OnInit = append(p.OnInit, func(ctx context.Context) {
+		p.log.Infof("Serving block page on %s", ip)
+		if err := bp.ListenAndServe(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			p.log.Errorf("Block page: %v", err)
+		}
+	})
+	return nil
+}
+
+// setupDiscovery registers the LAN client discovery sources on r with the
+// names set by the user and in file if not nil, and starts them with the
+// proxy.
+func setupDiscovery(p *proxySvc, r *discovery.Resolver, names config.ClientNames, file *discovery.File, leases []discovery.LeaseFile) {
+	if len(names) > 0 {
+		static := discovery.Static{}
+		for _, n := range names {
+			static[n.Addr] = n.Name
+		}
+		r.Register("static", discovery.ConfidenceStatic, static)
+	}
+	if file != nil {
+		r.Register("file", discovery.ConfidenceStatic, file)
+	}
+	r.Register("hosts", discovery.ConfidenceStatic, &discovery.Hosts{})
+	r.Register("dhcp", discovery.ConfidenceDHCP, &discovery.DHCP{Files: leases})
+	r.Register("ubus", discovery.ConfidenceDHCP, &discovery.UBUS{})
+	r.Register("mdns", discovery.ConfidenceMDNS, &discovery.MDNS{})
+	r.Register("dns", discovery.ConfidenceDNS, &discovery.DNS{})
+	r.Register("llmnr", discovery.ConfidenceHeuristic, &discovery.LLMNR{})
+	r.Register("netbios", discovery.ConfidenceHeuristic, &discovery.NetBIOS{})
+	if p.ctl != nil {
+		p.ctl.Command("clients", func(args []string) (interface{}, error) {
+			if len(args) == 0 {
+				return r.Clients(), nil
+			}
+			clients := make([]discovery.Client, 0, len(args))
+			for _, addr := range args {
+				clients = append(clients, r.Resolve(addr))
+			}
+			return clients, nil
+		})
+	}
+	p.OnInit = append(p.OnInit, func(ctx context.Context) {
+		p.log.Info("Starting discovery resolver")
+		ctx = discovery.WithTrace(ctx, discovery.Trace{
+			OnDiscover: func(addr, host, source string) {
+				p.log.Infof("Discovered(%s) %s = %s", source, addr, host)
+			},
+			OnWarning: func(msg string) {
+				p.log.Warningf("Discovery: %s", msg)
+			},
+		})
+		r.Start(ctx)
+	})
+}
+
+func setupClientReporting(p *proxySvc, conf *config.Configs, r *discovery.Resolver) {
 	deviceName, _ := host.Name()
 	deviceID, _ := machineid.ProtectedID("NextDNS")
 	if len(deviceID) > 5 {
@@ -408,42 +2710,33 @@ func setupClientReporting(p *proxySvc, conf *config.Configs, enableDiscovery boo
 		deviceID = deviceID[:5]
 	}
 
-	r := &discovery.Resolver{}
-	if enableDiscovery {
-		r.Register(&discovery.Hosts{})
-		r.Register(&discovery.MDNS{})
-		r.Register(&discovery.DHCP{})
-		r.Register(&discovery.DNS{})
-		p.OnInit = append(p.OnInit, func(ctx context.Context) {
-			p.log.Info("Starting discovery resolver")
-			ctx = discovery.WithTrace(ctx, discovery.Trace{
-				OnDiscover: func(addr, host, source string) {
-					p.log.Infof("Discovered(%s) %s = %s", source, addr, host)
-				},
-				OnWarning: func(msg string) {
-					p.log.Warningf("Discovery: %s", msg)
-				},
-			})
-			r.Start(ctx)
-		})
+	deviceInfo := func(ip net.IP, mac net.HardwareAddr) (name, model string) {
+		if ip.IsLoopback() {
+			return deviceName, ""
+		}
+		name = r.Lookup(ip.String())
+		if mac != nil {
+			hex := mac.String()
+			if len(hex) >= 8 {
+				// Only send the manufacturer part of the MAC.
+				model = "mac:" + hex[:8]
+			}
+			if name == "" {
+				name = r.Lookup(hex)
+			}
+		}
+		return name, model
 	}
+	p.DeviceInfo = deviceInfo
 
 	p.resolver.DOH.ClientInfo = func(q resolver.Query) (ci resolver.ClientInfo) {
 		if !q.PeerIP.IsLoopback() {
 			// When acting as router, try to guess as much info as possible from
 			// LAN client.
 			ci.IP = q.PeerIP.String()
-			ci.Name = r.Lookup(q.PeerIP.String())
+			ci.Name, ci.Model = deviceInfo(q.PeerIP, q.MAC)
 			if q.MAC != nil {
 				ci.ID = shortID(conf.Get(q.PeerIP, q.MAC), q.MAC)
-				hex := q.MAC.String()
-				if len(hex) >= 8 {
-					// Only send the manufacturer part of the MAC.
-					ci.Model = "mac:" + hex[:8]
-				}
-				if ci.Name == "" {
-					ci.Name = r.Lookup(hex)
-				}
 			}
 			if ci.ID == "" {
 				ci.ID = shortID(conf.Get(q.PeerIP, q.MAC), q.PeerIP)
@@ -482,3 +2775,34 @@ func shortID(confID string, deviceID []byte) string {
 	}
 	return string(buf)
 }
+
+// setupCacheOnly registers the control commands enabling and disabling the
+// cache-only mode.
+func setupCacheOnly(p *proxySvc, s *cacheonly.Switch) {
+	p.ctl.Action("cache-only", func(args []string) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, errors.New("missing mode")
+		}
+		var on bool
+		switch args[0] {
+		case "on":
+			on = true
+		case "off":
+		default:
+			return nil, fmt.Errorf("%s: invalid mode, expected on or off", args[0])
+		}
+		if s.Set(on) {
+			if on {
+				p.log.Info("Cache-only mode enabled, upstreams are not contacted")
+				p.events.Emit(events.CacheOnlyEnabled, nil)
+			} else {
+				p.log.Info("Cache-only mode disabled")
+				p.events.Emit(events.CacheOnlyDisabled, nil)
+			}
+		}
+		return map[string]bool{"enabled": on}, nil
+	})
+	p.ctl.Command("cache-only.status", func(args []string) (interface{}, error) {
+		return map[string]bool{"enabled": s.On()}, nil
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_nextBackoff(t *testing.T) {
+	var got []time.Duration
+	for d := minStartBackoff; len(got) < 12; d = nextBackoff(d, maxStartBackoff) {
+		got = append(got, d)
+	}
+	want := []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond,
+		1600 * time.Millisecond, 3200 * time.Millisecond, 6400 * time.Millisecond, 12800 * time.Millisecond,
+		25600 * time.Millisecond, 30 * time.Second, 30 * time.Second, 30 * time.Second,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("backoffs = %v, want %v", got, want)
+	}
+	if got := nextBackoff(maxOfflineBackoff, maxOfflineBackoff); got != maxOfflineBackoff {
+		t.Errorf("nextBackoff(max) = %v, want %v", got, maxOfflineBackoff)
+	}
+	if got := nextBackoff(1<<62, maxOfflineBackoff); got != maxOfflineBackoff {
+		t.Errorf("nextBackoff(overflow) = %v, want %v", got, maxOfflineBackoff)
+	}
+}
+
+func Test_isErrNetNotReady(t *testing.T) {
+	sysErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "listen", Net: "udp", Err: os.NewSyscallError("bind", errno)}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unreachable", sysErr(syscall.ENETUNREACH), true},
+		{"down", sysErr(syscall.ENETDOWN), true},
+		{"addr not available", sysErr(syscall.EADDRNOTAVAIL), true},
+		{"wrapped", fmt.Errorf("listen: %w", sysErr(syscall.EADDRNOTAVAIL)), true},
+		{"unreachable message", errors.New("dial udp: connect: network is unreachable"), true},
+		{"dns", &net.OpError{Op: "listen", Err: &net.DNSError{Err: "no such host", Name: "router.lan"}}, true},
+		{"in use", sysErr(syscall.EADDRINUSE), false},
+		{"permission", sysErr(syscall.EACCES), false},
+		{"other", errors.New("invalid config"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isErrNetNotReady(tt.err); got != tt.want {
+				t.Errorf("isErrNetNotReady(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
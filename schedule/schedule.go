@@ -0,0 +1,414 @@
+// Package schedule implements time based resolution policies: domains only
+// resolvable during some hours of some days, or NextDNS configurations used
+// during some hours, optionally scoped to some clients.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/filter"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ProfileOff is the profile of rules disabling filtering during their window.
+const ProfileOff = "off"
+
+// Rule restricts the resolution of some domains to a time window, or switches
+// the NextDNS configuration of clients during a time window.
+type Rule struct {
+	// Domains lists the domains the rule applies to, including their
+	// sub-domains, and the paths or URLs of domain lists.
+	Domains []string
+
+	// Profile is the NextDNS configuration ID used during the window, or
+	// ProfileOff to disable filtering. Rules with a profile have no domains.
+	Profile string
+
+	// Days is the set of days of the window. All days if empty.
+	Days []time.Weekday
+
+	// Start and End are the offsets from midnight between which the domains
+	// are allowed, or the profile used. If End is before Start, the window
+	// spans midnight.
+	Start time.Duration
+	End   time.Duration
+
+	// Location is the time zone the window is evaluated in. Local time if
+	// nil.
+	Location *time.Location
+
+	// Clients restricts the rule to clients in these networks. All clients
+	// if empty.
+	Clients []*net.IPNet
+
+	// MACs restricts the rule to clients with these MAC addresses.
+	MACs []net.HardwareAddr
+
+	// Names restricts the rule to clients with these names, as discovered on
+	// the LAN (lower case).
+	Names []string
+}
+
+// ParseRule parses a rule definition composed of space separated key=value
+// parameters:
+//
+//	domains=netflix.com,/etc/streaming.txt    domains and domain lists
+//	profile=abcdef                            configuration ID or off
+//	days=mon-fri                              days of the window
+//	hours=18:00-23:00                         hours of the window
+//	tz=Europe/Paris                           time zone (default local time)
+//	clients=192.168.1.0/24,aa:bb:cc:dd:ee:ff  clients the rule applies to
+//
+// One of domains or profile is required. Clients are IPs, subnets, MAC
+// addresses or discovered device names.
+func ParseRule(s string) (Rule, error) {
+	var r Rule
+	hasHours := false
+	for _, f := range strings.Fields(s) {
+		idx := strings.IndexByte(f, '=')
+		if idx == -1 {
+			return Rule{}, fmt.Errorf("%s: invalid schedule parameter", f)
+		}
+		k, v := f[:idx], f[idx+1:]
+		var err error
+		switch k {
+		case "domains":
+			r.Domains = strings.Split(v, ",")
+		case "profile":
+			r.Profile = v
+		case "days":
+			r.Days, err = parseDays(v)
+		case "hours":
+			r.Start, r.End, err = parseHours(v)
+			hasHours = true
+		case "tz":
+			r.Location, err = time.LoadLocation(v)
+		case "clients":
+			for _, c := range strings.Split(v, ",") {
+				if mac, err := net.ParseMAC(c); err == nil {
+					r.MACs = append(r.MACs, mac)
+					continue
+				}
+				if isName(c) {
+					r.Names = append(r.Names, strings.ToLower(c))
+					continue
+				}
+				n, err := parseNet(c)
+				if err != nil {
+					return Rule{}, err
+				}
+				r.Clients = append(r.Clients, n)
+			}
+		default:
+			return Rule{}, fmt.Errorf("%s: unknown schedule parameter", k)
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("%s: %v", f, err)
+		}
+	}
+	if (len(r.Domains) == 0) == (r.Profile == "") {
+		return Rule{}, fmt.Errorf("%s: invalid schedule: one of domains or profile is required", s)
+	}
+	if !hasHours {
+		r.End = 24 * time.Hour
+	}
+	return r, nil
+}
+
+func parseDays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, d := range strings.Split(strings.ToLower(s), ",") {
+		from, to := d, d
+		if idx := strings.IndexByte(d, '-'); idx != -1 {
+			from, to = d[:idx], d[idx+1:]
+		}
+		fd, ok1 := weekdays[from]
+		td, ok2 := weekdays[to]
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%s: invalid day", d)
+		}
+		for wd := fd; ; wd = (wd + 1) % 7 {
+			days = append(days, wd)
+			if wd == td {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseHours(s string) (start, end time.Duration, err error) {
+	idx := strings.IndexByte(s, '-')
+	if idx == -1 {
+		return 0, 0, fmt.Errorf("%s: invalid hours: missing -", s)
+	}
+	if start, err = parseClock(s[:idx]); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseClock(s[idx+1:]); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("%s: invalid time", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// isName returns true if the client s is a device name rather than an IP or
+// subnet.
+func isName(s string) bool {
+	if strings.ContainsAny(s, "/:") || net.ParseIP(s) != nil {
+		return false
+	}
+	return strings.IndexFunc(s, func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+	}) != -1
+}
+
+func parseNet(s string) (*net.IPNet, error) {
+	if strings.IndexByte(s, '/') != -1 {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid client", s)
+		}
+		return n, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%s: invalid client", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func (r Rule) String() string {
+	var s []string
+	if len(r.Domains) > 0 {
+		s = append(s, "domains="+strings.Join(r.Domains, ","))
+	}
+	if r.Profile != "" {
+		s = append(s, "profile="+r.Profile)
+	}
+	if len(r.Days) > 0 {
+		names := make([]string, 0, len(r.Days))
+		for _, d := range r.Days {
+			names = append(names, strings.ToLower(d.String()[:3]))
+		}
+		s = append(s, "days="+strings.Join(names, ","))
+	}
+	if r.Start != 0 || r.End != 24*time.Hour {
+		s = append(s, fmt.Sprintf("hours=%s-%s", clock(r.Start), clock(r.End)))
+	}
+	if r.Location != nil {
+		s = append(s, "tz="+r.Location.String())
+	}
+	var clients []string
+	for _, n := range r.Clients {
+		clients = append(clients, n.String())
+	}
+	for _, mac := range r.MACs {
+		clients = append(clients, mac.String())
+	}
+	clients = append(clients, r.Names...)
+	if len(clients) > 0 {
+		s = append(s, "clients="+strings.Join(clients, ","))
+	}
+	return strings.Join(s, " ")
+}
+
+func clock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+// Allowed returns true if now is within the window.
+func (r Rule) Allowed(now time.Time) bool {
+	if r.Location != nil {
+		now = now.In(r.Location)
+	}
+	y, m, d := now.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	t := now.Sub(midnight)
+	if r.Start <= r.End {
+		return r.day(now.Weekday()) && t >= r.Start && t < r.End
+	}
+	// The window spans midnight: the early hours belong to the window of the
+	// previous day.
+	return (r.day(now.Weekday()) && t >= r.Start) ||
+		(r.day((now.Weekday()+6)%7) && t < r.End)
+}
+
+func (r Rule) day(wd time.Weekday) bool {
+	if len(r.Days) == 0 {
+		return true
+	}
+	for _, d := range r.Days {
+		if d == wd {
+			return true
+		}
+	}
+	return false
+}
+
+// matchClient returns true if the rule applies to the client with the given
+// IP and MAC. The name of the client is only looked up if needed.
+func (r Rule) matchClient(ip net.IP, mac net.HardwareAddr, name func() string) bool {
+	if len(r.Clients) == 0 && len(r.MACs) == 0 && len(r.Names) == 0 {
+		return true
+	}
+	for _, n := range r.Clients {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	for _, m := range r.MACs {
+		if mac != nil && m.String() == mac.String() {
+			return true
+		}
+	}
+	if len(r.Names) > 0 {
+		n := strings.ToLower(name())
+		for _, rn := range r.Names {
+			if n != "" && n == rn {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Resolver answers queries for domains outside of their allowed window with
+// NXDOMAIN and sends other queries to Upstream. The rules with a profile are
+// evaluated by Profile.
+type Resolver struct {
+	// Rules is the list of schedule rules.
+	Rules []Rule
+
+	// ClientName specifies an optional function returning the discovered name
+	// of a client, used by the rules scoped to client names.
+	ClientName func(ip net.IP, mac net.HardwareAddr) string
+
+	// RefreshInterval specifies how often domain lists are reloaded.
+	RefreshInterval time.Duration
+
+	// InfoLog and ErrorLog are optional log functions used when lists are
+	// loaded.
+	InfoLog  func(string)
+	ErrorLog func(error)
+
+	// Upstream is the resolver used for allowed queries.
+	Upstream resolver.Resolver
+
+	once    sync.Once
+	filters []*filter.Filter
+}
+
+// Start loads the domain lists of all rules and refreshes them every
+// RefreshInterval until ctx is cancelled.
+func (r *Resolver) Start(ctx context.Context) {
+	for _, f := range r.getFilters() {
+		if f != nil {
+			go f.Start(ctx)
+		}
+	}
+}
+
+// Reload reloads the domain lists of all rules.
+func (r *Resolver) Reload(ctx context.Context) {
+	for _, f := range r.getFilters() {
+		if f != nil {
+			f.Reload(ctx)
+		}
+	}
+}
+
+// getFilters returns the filters matching the domains of each rule.
+func (r *Resolver) getFilters() []*filter.Filter {
+	r.once.Do(func() {
+		for _, rule := range r.Rules {
+			if len(rule.Domains) == 0 {
+				r.filters = append(r.filters, nil)
+				continue
+			}
+			f := &filter.Filter{
+				RefreshInterval: r.RefreshInterval,
+				InfoLog:         r.InfoLog,
+				ErrorLog:        r.ErrorLog,
+			}
+			for _, d := range rule.Domains {
+				if strings.HasPrefix(d, "http://") || strings.HasPrefix(d, "https://") || strings.ContainsAny(d, `/\`) {
+					f.Blocklists = append(f.Blocklists, d)
+					continue
+				}
+				f.BlockRules = append(f.BlockRules, "||"+d+"^")
+			}
+			r.filters = append(r.filters, f)
+		}
+	})
+	return r.filters
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	now := time.Now()
+	filters := r.getFilters()
+	name := r.clientName(q.PeerIP, q.MAC)
+	for j, rule := range r.Rules {
+		if filters[j] == nil || !rule.matchClient(q.PeerIP, q.MAC, name) {
+			continue
+		}
+		if filters[j].Match(q.Name) && !rule.Allowed(now) {
+			return filters[j].Reply(q, buf)
+		}
+	}
+	return r.Upstream.Resolve(ctx, q, buf)
+}
+
+// Profile returns the profile of the first rule with a profile applying to
+// the client with the given IP and MAC whose window includes now. It returns
+// false if there is none.
+func (r *Resolver) Profile(ip net.IP, mac net.HardwareAddr, now time.Time) (string, bool) {
+	name := r.clientName(ip, mac)
+	for _, rule := range r.Rules {
+		if rule.Profile != "" && rule.matchClient(ip, mac, name) && rule.Allowed(now) {
+			return rule.Profile, true
+		}
+	}
+	return "", false
+}
+
+// clientName returns a function looking up the name of the client once.
+func (r *Resolver) clientName(ip net.IP, mac net.HardwareAddr) func() string {
+	var name *string
+	return func() string {
+		if name == nil {
+			n := ""
+			if r.ClientName != nil {
+				n = r.ClientName(ip, mac)
+			}
+			name = &n
+		}
+		return *name
+	}
+}
@@ -0,0 +1,123 @@
+package schedule
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		def  string
+		want string
+		err  bool
+	}{
+		{"domains=netflix.com", "domains=netflix.com", false},
+		{"domains=netflix.com,youtube.com days=mon-fri hours=18:00-23:00 tz=UTC",
+			"domains=netflix.com,youtube.com days=mon,tue,wed,thu,fri hours=18:00-23:00 tz=UTC", false},
+		{"domains=netflix.com days=fri-mon clients=10.0.0.1,192.168.0.0/24,aa:bb:cc:dd:ee:ff",
+			"domains=netflix.com days=fri,sat,sun,mon clients=10.0.0.1/32,192.168.0.0/24,aa:bb:cc:dd:ee:ff", false},
+		{"days=mon", "", true},
+		{"domains=netflix.com hours=18:00", "", true},
+		{"domains=netflix.com hours=25:00-23:00", "", true},
+		{"domains=netflix.com days=monday", "", true},
+		{"domains=netflix.com clients=10.0.0.300", "", true},
+		{"domains=netflix.com clients=Kids-iPad", "domains=netflix.com clients=kids-ipad", false},
+		{"profile=abcdef days=mon-fri hours=16:00-19:00 clients=kids-ipad,10.0.0.2",
+			"profile=abcdef days=mon,tue,wed,thu,fri hours=16:00-19:00 clients=10.0.0.2/32,kids-ipad", false},
+		{"profile=off hours=20:00-21:00", "profile=off hours=20:00-21:00", false},
+		{"domains=netflix.com profile=abcdef", "", true},
+		{"domains=netflix.com foo=bar", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.def, func(t *testing.T) {
+			r, err := ParseRule(tt.def)
+			if (err != nil) != tt.err {
+				t.Fatalf("ParseRule() err = %v, want err %v", err, tt.err)
+			}
+			if err == nil && r.String() != tt.want {
+				t.Errorf("ParseRule() = %q, want %q", r.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRule_Allowed(t *testing.T) {
+	// 2020-01-06 is a Monday.
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2020, 1, 6+day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		def  string
+		now  time.Time
+		want bool
+	}{
+		{"domains=a days=mon-fri hours=18:00-23:00 tz=UTC", at(0, 18, 0), true},
+		{"domains=a days=mon-fri hours=18:00-23:00 tz=UTC", at(0, 23, 0), false},
+		{"domains=a days=mon-fri hours=18:00-23:00 tz=UTC", at(0, 12, 0), false},
+		{"domains=a days=mon-fri hours=18:00-23:00 tz=UTC", at(5, 19, 0), false},
+		{"domains=a days=sat,sun tz=UTC", at(6, 12, 0), true},
+		{"domains=a days=sat,sun tz=UTC", at(0, 12, 0), false},
+		// Window spanning midnight.
+		{"domains=a days=fri hours=22:00-02:00 tz=UTC", at(4, 23, 0), true},
+		{"domains=a days=fri hours=22:00-02:00 tz=UTC", at(5, 1, 0), true},
+		{"domains=a days=fri hours=22:00-02:00 tz=UTC", at(4, 1, 0), false},
+		{"domains=a days=fri hours=22:00-02:00 tz=UTC", at(5, 3, 0), false},
+		// Evaluated in the rule time zone.
+		{"domains=a hours=09:00-17:00 tz=Asia/Tokyo", at(0, 1, 0), true},
+		{"domains=a hours=09:00-17:00 tz=Asia/Tokyo", at(0, 12, 0), false},
+	}
+	for _, tt := range tests {
+		r, err := ParseRule(tt.def)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Allowed(tt.now); got != tt.want {
+			t.Errorf("%q.Allowed(%v) = %v, want %v", tt.def, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestResolver_Profile(t *testing.T) {
+	var rules []Rule
+	for _, def := range []string{
+		"profile=homework days=mon-fri hours=16:00-19:00 tz=UTC clients=kids-ipad",
+		"profile=off hours=20:00-21:00 tz=UTC clients=10.0.0.2",
+	} {
+		rule, err := ParseRule(def)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	r := &Resolver{
+		Rules: rules,
+		ClientName: func(ip net.IP, mac net.HardwareAddr) string {
+			if ip.Equal(net.ParseIP("10.0.0.1")) {
+				return "Kids-iPad"
+			}
+			return ""
+		},
+	}
+	// 2020-01-06 is a Monday.
+	at := func(hour int) time.Time {
+		return time.Date(2020, 1, 6, hour, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		ip          string
+		now         time.Time
+		wantProfile string
+		wantOK      bool
+	}{
+		{"10.0.0.1", at(17), "homework", true},
+		{"10.0.0.1", at(12), "", false},
+		{"10.0.0.3", at(17), "", false},
+		{"10.0.0.2", at(20), "off", true},
+	}
+	for _, tt := range tests {
+		profile, ok := r.Profile(net.ParseIP(tt.ip), nil, tt.now)
+		if profile != tt.wantProfile || ok != tt.wantOK {
+			t.Errorf("Profile(%s, %v) = %q, %v, want %q, %v", tt.ip, tt.now, profile, ok, tt.wantProfile, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// DefaultTimeout is the time a script is allowed to run for a query or a
+// response if Resolver.Timeout is not set.
+const DefaultTimeout = 20 * time.Millisecond
+
+// localTTL is the TTL of the records answered by the script.
+const localTTL = 60
+
+// Actions returned by the scripts.
+const (
+	ActionBlock   = "block"
+	ActionAnswer  = "answer"
+	ActionRewrite = "rewrite"
+	ActionRoute   = "route"
+)
+
+// Resolver resolves the queries as decided by Script, sending them to Upstream
+// unless they are answered by the script or routed to one of Upstreams. A
+// query is resolved as if the script returned nothing when it fails or times
+// out.
+type Resolver struct {
+	Script *Script
+
+	// Upstream is the resolver of the queries passed by the script.
+	Upstream resolver.Resolver
+
+	// Upstreams are the named resolvers the script can route queries to.
+	Upstreams map[string]resolver.Resolver
+
+	// Timeout is the time the script is allowed to run for each query and
+	// response. DefaultTimeout is used if zero.
+	Timeout time.Duration
+
+	// Log specifies an optional function receiving the messages logged by the
+	// script with the log function.
+	Log func(string)
+
+	// ErrorLog specifies an optional log function for script errors.
+	ErrorLog func(error)
+
+	states sync.Pool
+}
+
+type action struct {
+	name     string
+	addrs    []net.IP
+	target   string
+	upstream string
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	up := r.Upstream
+	a, err := r.call(ctx, func(st *state) (*lua.LFunction, []lua.LValue) {
+		return st.query, []lua.LValue{queryTable(st.L, q)}
+	})
+	if err != nil {
+		r.logErr(fmt.Errorf("query %s %s: %v", q.Name, q.Type, err))
+	}
+	switch a.name {
+	case ActionBlock:
+		resolver.Tracef(ctx, "script", "blocked")
+		n, i, err = replyNXDomain(q, buf)
+		i.Source = "script"
+		return n, i, err
+	case ActionAnswer:
+		resolver.Tracef(ctx, "script", "answered")
+		n, i, err = replyAddrs(q, a.addrs, buf)
+		i.Source = "script"
+		return n, i, err
+	case ActionRewrite:
+		resolver.Tracef(ctx, "script", "rewritten to %s", a.target)
+		return r.resolveTarget(ctx, q, a.target, buf)
+	case ActionRoute:
+		u := r.Upstreams[a.upstream]
+		if u == nil {
+			r.logErr(fmt.Errorf("query %s %s: %s: unknown upstream", q.Name, q.Type, a.upstream))
+			break
+		}
+		resolver.Tracef(ctx, "script", "routed to %s", a.upstream)
+		up = u
+	}
+	n, i, err = up.Resolve(ctx, q, buf)
+	if err != nil || n <= 0 {
+		return n, i, err
+	}
+	return r.filterResponse(ctx, q, buf, n, i)
+}
+
+// filterResponse passes the response in buf[:n] to the response function of
+// the script, and replaces it as decided.
+func (r *Resolver) filterResponse(ctx context.Context, q resolver.Query, buf []byte, n int, i resolver.ResolveInfo) (int, resolver.ResolveInfo, error) {
+	a, err := r.call(ctx, func(st *state) (*lua.LFunction, []lua.LValue) {
+		if st.response == nil {
+			return nil, nil
+		}
+		rt, err := responseTable(st.L, buf[:n])
+		if err != nil {
+			return nil, nil
+		}
+		return st.response, []lua.LValue{queryTable(st.L, q), rt}
+	})
+	if err != nil {
+		r.logErr(fmt.Errorf("response %s %s: %v", q.Name, q.Type, err))
+		return n, i, nil
+	}
+	switch a.name {
+	case ActionBlock:
+		resolver.Tracef(ctx, "script", "response blocked")
+		n, _, err = replyNXDomain(q, buf)
+		i.Source = "script"
+	case ActionAnswer:
+		resolver.Tracef(ctx, "script", "response answered")
+		n, _, err = replyAddrs(q, a.addrs, buf)
+		i.Source = "script"
+	case ActionRewrite, ActionRoute:
+		r.logErr(fmt.Errorf("response %s %s: %s: action not supported for responses", q.Name, q.Type, a.name))
+	}
+	return n, i, err
+}
+
+// call calls the function returned by args for a state with its arguments,
+// and returns the action returned. No action is returned if the function is
+// nil.
+func (r *Resolver) call(ctx context.Context, args func(st *state) (*lua.LFunction, []lua.LValue)) (action, error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	st, _ := r.states.Get().(*state)
+	if st == nil {
+		var err error
+		if st, err = r.Script.newState(ctx, timeout, r.Log); err != nil {
+			return action{}, err
+		}
+	}
+	fn, argv := args(st)
+	if fn == nil {
+		r.states.Put(st)
+		return action{}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	st.L.SetContext(ctx)
+	err := st.L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, argv...)
+	st.L.RemoveContext()
+	if err != nil {
+		// The state is not reused as an interrupted script could leave it
+		// inconsistent.
+		st.L.Close()
+		return action{}, err
+	}
+	ret := st.L.Get(-1)
+	st.L.Pop(1)
+	r.states.Put(st)
+	return parseAction(ret)
+}
+
+func (r *Resolver) logErr(err error) {
+	if r.ErrorLog != nil {
+		r.ErrorLog(err)
+	}
+}
+
+func queryTable(L *lua.LState, q resolver.Query) *lua.LTable {
+	t := L.CreateTable(0, 5)
+	t.RawSetString("name", lua.LString(q.Name))
+	t.RawSetString("type", lua.LString(q.Type))
+	if q.PeerIP != nil {
+		t.RawSetString("client", lua.LString(q.PeerIP.String()))
+	}
+	if q.MAC != nil {
+		t.RawSetString("mac", lua.LString(q.MAC.String()))
+	}
+	if q.Instance != "" {
+		t.RawSetString("instance", lua.LString(q.Instance))
+	}
+	return t
+}
+
+func responseTable(L *lua.LState, resp []byte) (*lua.LTable, error) {
+	var m dnsmessage.Message
+	if err := m.Unpack(resp); err != nil {
+		return nil, err
+	}
+	t := L.CreateTable(0, 2)
+	t.RawSetString("rcode", lua.LString(rcodeName(m.Header.RCode)))
+	addrs := L.CreateTable(len(m.Answers), 0)
+	for _, rr := range m.Answers {
+		switch b := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs.Append(lua.LString(net.IP(b.A[:]).String()))
+		case *dnsmessage.AAAAResource:
+			addrs.Append(lua.LString(net.IP(b.AAAA[:]).String()))
+		}
+	}
+	t.RawSetString("addrs", addrs)
+	return t, nil
+}
+
+// parseAction parses the value returned by a script function.
+func parseAction(v lua.LValue) (action, error) {
+	if v == lua.LNil {
+		return action{}, nil
+	}
+	t, ok := v.(*lua.LTable)
+	if !ok {
+		return action{}, fmt.Errorf("unexpected %s returned, want a table", v.Type())
+	}
+	a := action{name: lua.LVAsString(t.RawGetString("action"))}
+	switch a.name {
+	case ActionBlock:
+	case ActionAnswer:
+		addrs, ok := t.RawGetString("addrs").(*lua.LTable)
+		if !ok {
+			return action{}, errors.New("answer: missing addrs")
+		}
+		var err error
+		addrs.ForEach(func(_, v lua.LValue) {
+			ip := net.ParseIP(lua.LVAsString(v))
+			if ip == nil {
+				err = fmt.Errorf("answer: %s: invalid address", v)
+				return
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			a.addrs = append(a.addrs, ip)
+		})
+		if err != nil {
+			return action{}, err
+		}
+	case ActionRewrite:
+		if a.target = lua.LVAsString(t.RawGetString("name")); a.target == "" {
+			return action{}, errors.New("rewrite: missing name")
+		}
+		if !strings.HasSuffix(a.target, ".") {
+			a.target += "."
+		}
+	case ActionRoute:
+		if a.upstream = lua.LVAsString(t.RawGetString("upstream")); a.upstream == "" {
+			return action{}, errors.New("route: missing upstream")
+		}
+	case "":
+		return action{}, errors.New("missing action")
+	default:
+		return action{}, fmt.Errorf("%s: unknown action", a.name)
+	}
+	return a, nil
+}
+
+// resolveTarget resolves target instead of the query name and returns the
+// result prefixed by a CNAME from the query name to target.
+func (r *Resolver) resolveTarget(ctx context.Context, q resolver.Query, target string, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var qm dnsmessage.Message
+	if err = qm.Unpack(q.Payload); err != nil {
+		return 0, i, err
+	}
+	if len(qm.Questions) == 0 {
+		return 0, i, errors.New("script: no question")
+	}
+	orig := qm.Questions[0]
+	tn, err := dnsmessage.NewName(target)
+	if err != nil {
+		return 0, i, fmt.Errorf("script: %s: %v", target, err)
+	}
+	qm.Questions[0].Name = tn
+	payload, err := qm.Pack()
+	if err != nil {
+		return 0, i, err
+	}
+	tq := q
+	tq.Name = target
+	tq.Payload = payload
+	n, i, err = r.Upstream.Resolve(ctx, tq, buf)
+	if err != nil || n <= 0 {
+		return n, i, err
+	}
+
+	var m dnsmessage.Message
+	if err = m.Unpack(buf[:n]); err != nil {
+		return 0, i, err
+	}
+	if len(m.Questions) == 0 {
+		return n, i, nil
+	}
+	m.Questions[0] = orig
+	cname := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: orig.Name, Class: orig.Class, TTL: localTTL},
+		Body:   &dnsmessage.CNAMEResource{CNAME: tn},
+	}
+	m.Answers = append([]dnsmessage.Resource{cname}, m.Answers...)
+	n, err = pack(&m, buf)
+	return n, i, err
+}
+
+func replyAddrs(q resolver.Query, addrs []net.IP, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var m dnsmessage.Message
+	if err = m.Unpack(q.Payload); err != nil {
+		return 0, i, err
+	}
+	if len(m.Questions) == 0 {
+		return 0, i, errors.New("script: no question")
+	}
+	q1 := m.Questions[0]
+	m.Header.Response = true
+	m.Header.RecursionAvailable = true
+	m.Header.RCode = dnsmessage.RCodeSuccess
+	m.Answers, m.Authorities = nil, nil
+	hdr := dnsmessage.ResourceHeader{Name: q1.Name, Class: q1.Class, TTL: localTTL}
+	for _, ip := range addrs {
+		switch {
+		case q1.Type == dnsmessage.TypeA && len(ip) == net.IPv4len:
+			var a [4]byte
+			copy(a[:], ip)
+			m.Answers = append(m.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AResource{A: a}})
+		case q1.Type == dnsmessage.TypeAAAA && len(ip) == net.IPv6len:
+			var aaaa [16]byte
+			copy(aaaa[:], ip)
+			m.Answers = append(m.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: aaaa}})
+		}
+	}
+	n, err = pack(&m, buf)
+	return n, i, err
+}
+
+func replyNXDomain(q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return -1, i, err
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return -1, i, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = dnsmessage.RCodeNameError
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	buf, err = b.Finish()
+	return len(buf), i, err
+}
+
+func pack(m *dnsmessage.Message, buf []byte) (int, error) {
+	b, err := m.AppendPack(buf[:0])
+	if err != nil {
+		return 0, err
+	}
+	if len(b) > len(buf) {
+		return 0, errors.New("script: response too large")
+	}
+	return len(b), nil
+}
+
+// rcodeName returns the mnemonic of the response code rcode (RFC 1035).
+func rcodeName(rcode dnsmessage.RCode) string {
+	switch rcode {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
@@ -0,0 +1,177 @@
+// Package script lets a Lua script decide how queries are resolved: blocked,
+// answered, rewritten or routed to another upstream, and inspect their
+// responses.
+//
+// The script defines a query function called with each query, and optionally
+// a response function called with the responses of the queries it passed:
+//
+//	function query(q)
+//	  -- q.name, q.type, q.client, q.mac and q.instance
+//	  if q.name:find("%.ads%.example%.com%.$") then
+//	    return {action = "block"}
+//	  end
+//	  if q.client == "192.168.1.20" then
+//	    return {action = "route", upstream = "work"}
+//	  end
+//	end
+//
+//	function response(q, r)
+//	  -- r.rcode and r.addrs, the addresses of the A and AAAA answers
+//	end
+//
+// Returning nothing resolves the query (or keeps the response) unchanged.
+// Otherwise the returned table holds the action and its parameters:
+//
+//	{action = "block"}                          answer with NXDOMAIN
+//	{action = "answer", addrs = {"10.0.0.1"}}   answer with the addresses
+//	{action = "rewrite", name = "example.net"}  resolve another name, returned
+//	                                            as a CNAME (query only)
+//	{action = "route", upstream = "name"}       resolve with a named upstream
+//	                                            (query only)
+//
+// Scripts run in a sandbox limited to the base, string, table and math
+// libraries, without access to files, the network or other Lua modules, and
+// are interrupted after a timeout. Queries are handled by several Lua states
+// concurrently: global variables are not shared between queries and must not
+// be used to keep state.
+package script
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Script is a compiled script.
+type Script struct {
+	name  string
+	proto *lua.FunctionProto
+}
+
+// Load reads and compiles the script at path, and checks that it defines a
+// query or response function.
+func Load(path string) (*Script, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(filepath.Base(path), b)
+}
+
+// Parse compiles the script src, named name in error messages.
+func Parse(name string, src []byte) (*Script, error) {
+	chunk, err := parse.Parse(bytes.NewReader(src), name)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, err
+	}
+	s := &Script{name: name, proto: proto}
+	st, err := s.newState(context.Background(), DefaultTimeout, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer st.L.Close()
+	if st.query == nil && st.response == nil {
+		return nil, errors.New(name + ": no query or response function defined")
+	}
+	return s, nil
+}
+
+// allowedGlobals are the globals left once the libraries are opened.
+var allowedGlobals = map[string]bool{
+	"_G": true, "_VERSION": true,
+	"assert": true, "error": true, "ipairs": true, "next": true,
+	"pairs": true, "pcall": true, "rawequal": true, "rawget": true,
+	"rawset": true, "select": true, "getmetatable": true,
+	"setmetatable": true, "tonumber": true, "tostring": true, "type": true,
+	"unpack": true, "xpcall": true,
+	"string": true, "table": true, "math": true,
+}
+
+// state is a Lua state running the script.
+type state struct {
+	L        *lua.LState
+	query    *lua.LFunction
+	response *lua.LFunction
+}
+
+// newState returns a state where the script was run, defining its functions.
+// Calls to the log function of the script are passed to log.
+func (s *Script) newState(ctx context.Context, timeout time.Duration, log func(string)) (st *state, err error) {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   64,
+		RegistrySize:    1024,
+		RegistryMaxSize: 64 * 1024,
+	})
+	defer func() {
+		if err != nil {
+			L.Close()
+		}
+	}()
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.StringLibName, lua.OpenString},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		if err := L.CallByParam(lua.P{Fn: L.NewFunction(lib.open), NRet: 0, Protect: true}, lua.LString(lib.name)); err != nil {
+			return nil, err
+		}
+	}
+	globals := L.Get(lua.GlobalsIndex).(*lua.LTable)
+	var denied []lua.LValue
+	globals.ForEach(func(k, _ lua.LValue) {
+		if !allowedGlobals[k.String()] {
+			denied = append(denied, k)
+		}
+	})
+	for _, k := range denied {
+		globals.RawSet(k, lua.LNil)
+	}
+	// string.rep allocates its result at once, before the timeout can
+	// interrupt the script.
+	L.GetGlobal(lua.StringLibName).(*lua.LTable).RawSetString("rep", lua.LNil)
+	L.SetGlobal("log", L.NewFunction(func(L *lua.LState) int {
+		if log != nil {
+			log(L.CheckString(1))
+		}
+		return 0
+	}))
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		return nil, err
+	}
+	st = &state{L: L}
+	for _, f := range []struct {
+		name string
+		fn   **lua.LFunction
+	}{{"query", &st.query}, {"response", &st.response}} {
+		switch v := L.GetGlobal(f.name).(type) {
+		case *lua.LFunction:
+			*f.fn = v
+		case *lua.LNilType:
+		default:
+			return nil, fmt.Errorf("%s: %s is a %s, not a function", s.name, f.name, v.Type())
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,237 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// upstream answers A queries with addr, recording the names it resolves.
+type upstream struct {
+	addr  [4]byte
+	mu    sync.Mutex
+	names []string
+}
+
+func (u *upstream) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	u.mu.Lock()
+	u.names = append(u.names, q.Name)
+	u.mu.Unlock()
+	var m dnsmessage.Message
+	if err := m.Unpack(q.Payload); err != nil {
+		return 0, resolver.ResolveInfo{}, err
+	}
+	m.Header.Response = true
+	q1 := m.Questions[0]
+	if q1.Type == dnsmessage.TypeA {
+		m.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q1.Name, Class: q1.Class, TTL: 300},
+			Body:   &dnsmessage.AResource{A: u.addr},
+		}}
+	}
+	n, err := pack(&m, buf)
+	return n, resolver.ResolveInfo{Transport: "test"}, err
+}
+
+func newQuery(t *testing.T, name string, qtype dnsmessage.Type, peerIP string) resolver.Query {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := resolver.NewQuery(payload, net.ParseIP(peerIP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// summary returns the rcode and the answers of the response in b.
+func summary(t *testing.T, b []byte) string {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	s := []string{rcodeName(m.Header.RCode)}
+	for _, rr := range m.Answers {
+		switch b := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			s = append(s, net.IP(b.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			s = append(s, net.IP(b.AAAA[:]).String())
+		case *dnsmessage.CNAMEResource:
+			s = append(s, fmt.Sprintf("%s=%s", rr.Header.Name, b.CNAME))
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+func TestResolver(t *testing.T) {
+	const src = `
+function query(q)
+  if q.name:find("%.ads%.example%.com%.$") then
+    return {action = "block"}
+  end
+  if q.name == "nas.lan." then
+    return {action = "answer", addrs = {"192.168.1.2", "fd00::2"}}
+  end
+  if q.name == "printer.lan." then
+    return {action = "rewrite", name = "printer.example.com"}
+  end
+  if q.client == "10.0.0.2" then
+    return {action = "route", upstream = "work"}
+  end
+  if q.client == "10.0.0.3" then
+    return {action = "route", upstream = "unknown"}
+  end
+  if q.name == "bad.example.com." then
+    return {action = "explode"}
+  end
+  if q.name == "loop.example.com." then
+    while true do end
+  end
+  if q.name == "sandbox.example.com." and (os or io or require or load or dofile) then
+    return {action = "block"}
+  end
+end
+
+function response(q, r)
+  if r.rcode == "NOERROR" and r.addrs[1] == "10.0.0.66" then
+    log("blocked " .. q.name)
+    return {action = "block"}
+  end
+end
+`
+	s, err := Parse("test.lua", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := &upstream{addr: [4]byte{1, 2, 3, 4}}
+	work := &upstream{addr: [4]byte{10, 0, 0, 66}}
+	var mu sync.Mutex
+	var logs, errs []string
+	r := &Resolver{
+		Script:    s,
+		Upstream:  def,
+		Upstreams: map[string]resolver.Resolver{"work": work},
+		Log: func(msg string) {
+			mu.Lock()
+			logs = append(logs, msg)
+			mu.Unlock()
+		},
+		ErrorLog: func(err error) {
+			mu.Lock()
+			errs = append(errs, err.Error())
+			mu.Unlock()
+		},
+	}
+	tests := []struct {
+		name    string
+		qtype   dnsmessage.Type
+		peerIP  string
+		want    string
+		wantErr string
+	}{
+		{"www.example.com.", dnsmessage.TypeA, "10.0.0.1", "NOERROR 1.2.3.4", ""},
+		{"tracker.ads.example.com.", dnsmessage.TypeA, "10.0.0.1", "NXDOMAIN", ""},
+		{"nas.lan.", dnsmessage.TypeA, "10.0.0.1", "NOERROR 192.168.1.2", ""},
+		{"nas.lan.", dnsmessage.TypeAAAA, "10.0.0.1", "NOERROR fd00::2", ""},
+		{"printer.lan.", dnsmessage.TypeA, "10.0.0.1", "NOERROR printer.lan.=printer.example.com. 1.2.3.4", ""},
+		{"www.example.com.", dnsmessage.TypeA, "10.0.0.2", "NXDOMAIN", ""},
+		{"www.example.com.", dnsmessage.TypeAAAA, "10.0.0.2", "NOERROR", ""},
+		{"www.example.com.", dnsmessage.TypeA, "10.0.0.3", "NOERROR 1.2.3.4", "unknown: unknown upstream"},
+		{"bad.example.com.", dnsmessage.TypeA, "10.0.0.1", "NOERROR 1.2.3.4", "explode: unknown action"},
+		{"loop.example.com.", dnsmessage.TypeA, "10.0.0.1", "NOERROR 1.2.3.4", "context deadline exceeded"},
+		{"sandbox.example.com.", dnsmessage.TypeA, "10.0.0.1", "NOERROR 1.2.3.4", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+tt.qtype.String()+tt.peerIP, func(t *testing.T) {
+			errs = nil
+			buf := make([]byte, 512)
+			n, _, err := r.Resolve(context.Background(), newQuery(t, tt.name, tt.qtype, tt.peerIP), buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := summary(t, buf[:n]); got != tt.want {
+				t.Errorf("response = %q, want %q", got, tt.want)
+			}
+			gotErr := strings.Join(errs, "; ")
+			if (tt.wantErr == "") != (gotErr == "") || !strings.Contains(gotErr, tt.wantErr) {
+				t.Errorf("errors = %q, want %q", gotErr, tt.wantErr)
+			}
+		})
+	}
+	if got := strings.Join(logs, "; "); got != "blocked www.example.com." {
+		t.Errorf("logs = %q", got)
+	}
+	if got := strings.Join(work.names, " "); got != "www.example.com. www.example.com." {
+		t.Errorf("work upstream names = %q", got)
+	}
+}
+
+func TestResolver_Concurrent(t *testing.T) {
+	s, err := Parse("test.lua", []byte(`
+function query(q)
+  count = (count or 0) + 1
+  if count > 1 then
+    return {action = "block"}
+  end
+end
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Resolver{Script: s, Upstream: &upstream{}, Timeout: time.Second}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 512)
+			if _, _, err := r.Resolve(context.Background(), newQuery(t, "example.com.", dnsmessage.TypeA, "10.0.0.1"), buf); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src     string
+		wantErr string
+	}{
+		{"function query(q) end", ""},
+		{"function response(q, r) end", ""},
+		{"x = 1", "no query or response function defined"},
+		{"query = 1", "query is a number, not a function"},
+		{"function query(q)", "EOF"},
+		{"os.exit(1)", "attempt to index a non-table object(nil)"},
+		{"while true do end", "context deadline exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := Parse("test.lua", []byte(tt.src))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Parse() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
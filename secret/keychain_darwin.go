@@ -0,0 +1,52 @@
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// service is the keychain service the secrets are stored under.
+const service = "nextdns"
+
+func init() {
+	Register("keychain", Keychain{})
+}
+
+// Keychain stores secrets as generic passwords of the macOS keychain.
+type Keychain struct{}
+
+// Lookup implements Provider.
+func (Keychain) Lookup(name string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", name, "-w").Output()
+	if err != nil {
+		return "", fmt.Errorf("security: %v", err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// Store implements Storer. The command is fed to security -i on its standard
+// input so the value is not visible in the process list.
+func (k Keychain) Store(name, value string) error {
+	if strings.ContainsAny(name+value, "\r\n") {
+		return errors.New("security: line breaks are not supported")
+	}
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		quote(service), quote(name), quote(value)))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("security: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	// security -i exits successfully even when the command fails.
+	if v, err := k.Lookup(name); err != nil || v != value {
+		return fmt.Errorf("security: secret not stored: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// quote quotes s as an argument of a security -i command line.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
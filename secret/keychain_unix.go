@@ -0,0 +1,39 @@
+// +build !darwin,!windows
+
+package secret
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// service is the attribute identifying the secrets of nextdns.
+const service = "nextdns"
+
+func init() {
+	Register("keychain", Keychain{})
+}
+
+// Keychain stores secrets with libsecret (i.e. GNOME Keyring or KWallet)
+// using the secret-tool command.
+type Keychain struct{}
+
+// Lookup implements Provider.
+func (Keychain) Lookup(name string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", service, "account", name).Output()
+	if err != nil {
+		return "", fmt.Errorf("secret-tool: %v", err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// Store implements Storer.
+func (Keychain) Store(name, value string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "NextDNS "+name, "service", service, "account", name)
+	cmd.Stdin = strings.NewReader(value)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
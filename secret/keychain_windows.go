@@ -0,0 +1,83 @@
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+func init() {
+	Register("keychain", Keychain{})
+}
+
+const (
+	cryptProtectUIForbidden  = 0x1
+	cryptProtectLocalMachine = 0x4
+)
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+// Keychain stores secrets in files encrypted with DPAPI for the local
+// machine, so they can be read by the service, under
+// %ProgramData%\NextDNS\secrets.
+type Keychain struct{}
+
+func (Keychain) path(name string) string {
+	return filepath.Join(os.Getenv("ProgramData"), "NextDNS", "secrets", filepath.Base(name))
+}
+
+// Lookup implements Provider.
+func (k Keychain) Lookup(name string) (string, error) {
+	b, err := ioutil.ReadFile(k.path(name))
+	if err != nil {
+		return "", err
+	}
+	v, err := dpapi(procCryptUnprotectData, b)
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// Store implements Storer.
+func (k Keychain) Store(name, value string) error {
+	b, err := dpapi(procCryptProtectData, []byte(value))
+	if err != nil {
+		return err
+	}
+	p := k.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, b, 0600)
+}
+
+type dataBlob struct {
+	cbData uint32
+	pbData *byte
+}
+
+// dpapi calls CryptProtectData or CryptUnprotectData with data.
+func dpapi(proc *syscall.LazyProc, data []byte) ([]byte, error) {
+	var in, out dataBlob
+	if len(data) > 0 {
+		in = dataBlob{cbData: uint32(len(data)), pbData: &data[0]}
+	}
+	r, _, err := proc.Call(uintptr(unsafe.Pointer(&in)), 0, 0, 0, 0,
+		cryptProtectUIForbidden|cryptProtectLocalMachine, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.pbData)))
+	b := make([]byte, out.cbData)
+	copy(b, (*[1 << 30]byte)(unsafe.Pointer(out.pbData))[:out.cbData:out.cbData])
+	return b, nil
+}
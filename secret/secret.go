@@ -0,0 +1,100 @@
+// Package secret resolves the secrets referenced by the configuration (i.e.
+// configuration IDs, tokens or keys) from pluggable providers, so they don't
+// have to be stored in plain text in the configuration.
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Provider retrieves secrets by name.
+type Provider interface {
+	// Lookup returns the value of the secret name.
+	Lookup(name string) (string, error)
+}
+
+// Storer is implemented by providers able to store secrets.
+type Storer interface {
+	// Store sets the value of the secret name.
+	Store(name, value string) error
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{
+		"env":  Env{},
+		"file": File{},
+	}
+)
+
+// Register makes p available under scheme. Registering an existing scheme
+// replaces its provider.
+func Register(scheme string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[scheme] = p
+}
+
+// Get returns the provider registered for scheme or nil.
+func Get(scheme string) Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+	return providers[scheme]
+}
+
+// Lookup resolves a secret reference of the form scheme:name, i.e.
+// env:NEXTDNS_ID, file:/etc/nextdns/id or keychain:id.
+func Lookup(ref string) (string, error) {
+	idx := strings.IndexByte(ref, ':')
+	if idx == -1 {
+		return "", fmt.Errorf("%s: invalid secret reference, must be scheme:name", ref)
+	}
+	scheme, name := ref[:idx], ref[idx+1:]
+	p := Get(scheme)
+	if p == nil {
+		return "", fmt.Errorf("%s: unknown secret provider", scheme)
+	}
+	v, err := p.Lookup(name)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %v", ref, err)
+	}
+	return v, nil
+}
+
+// Env reads secrets from environment variables.
+type Env struct{}
+
+// Lookup implements Provider.
+func (Env) Lookup(name string) (string, error) {
+	v, found := os.LookupEnv(name)
+	if !found {
+		return "", errors.New("environment variable not set")
+	}
+	return v, nil
+}
+
+// File reads secrets from files only readable by their owner. Trailing new
+// lines are removed.
+type File struct{}
+
+// Lookup implements Provider.
+func (File) Lookup(name string) (string, error) {
+	st, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if perm := st.Mode().Perm(); perm&0077 != 0 && runtime.GOOS != "windows" {
+		return "", fmt.Errorf("permissions %#o are too open, the file must only be accessible by its owner", perm)
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,49 @@
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(file, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := Lookup("file:" + file); err != nil || v != "s3cr3t" {
+		t.Errorf("Lookup() = %q, %v, want s3cr3t", v, err)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if err := os.Chmod(file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lookup("file:" + file); err == nil {
+		t.Error("Lookup() of a world readable file succeeded")
+	}
+}
+
+type mapProvider map[string]string
+
+func (p mapProvider) Lookup(name string) (string, error) {
+	return p[name], nil
+}
+
+func TestRegister(t *testing.T) {
+	Register("test", mapProvider{"id": "abcdef"})
+	if v, err := Lookup("test:id"); err != nil || v != "abcdef" {
+		t.Errorf("Lookup() = %q, %v, want abcdef", v, err)
+	}
+	if _, err := Lookup("id"); err == nil {
+		t.Error("Lookup() without scheme succeeded")
+	}
+}
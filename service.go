@@ -1,20 +1,42 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/ctl"
 	"github.com/nextdns/nextdns/host"
 	"github.com/nextdns/nextdns/host/service"
+	"github.com/nextdns/nextdns/i18n"
+	"github.com/nextdns/nextdns/pause"
 )
 
 func svc(args []string) error {
 	cmd := args[0]
 	args = args[1:]
+	if cmd == "log" && len(args) > 0 && args[0] == "query" {
+		return logQuery(args)
+	}
 	var c config.Config
-	if cmd == "install" {
+	var verify bool
+	switch cmd {
+	case "install":
 		c.Parse("nextdns "+cmd, args, true)
+	case "status":
+		// Only used to reach the control socket of the running daemon.
+		c.Parse("nextdns "+cmd, args, true)
+	case "uninstall":
+		fs := flag.NewFlagSet("nextdns "+cmd, flag.ExitOnError)
+		fs.BoolVar(&verify, "verify", false, "Check for and remove any residue left by the installation.")
+		_ = fs.Parse(args)
+		if verify {
+			// Load the stored configuration to know what to clean.
+			c.Parse("nextdns "+cmd, nil, true)
+		}
 	}
 
 	svcArgs := []string{"run"}
@@ -28,41 +50,66 @@ func svc(args []string) error {
 		Arguments:   svcArgs,
 	})
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return withCode(exitUnsupported, err)
 	}
 
 	switch cmd {
 	case "install":
+		changes, err := installChanges(s, c)
+		if err != nil {
+			return err
+		}
+		if checkMode || len(changes) == 0 {
+			if !checkMode && !jsonOutput {
+				i18n.Printf("NextDNS already installed and running using %s init\n", service.Name(s))
+			}
+			return reportChanges(changes)
+		}
 		_ = s.Stop()
 		_ = s.Uninstall()
 		if err := c.Save(); err != nil {
-			fmt.Printf("Cannot write config: %v\n", err)
-			os.Exit(1)
+			return withCode(exitConfig, fmt.Errorf("cannot write config: %w", err))
 		}
-		err := s.Install()
+		err = s.Install()
 		if err == nil {
+			if err := setupFirewall(c.Listen); err != nil {
+				i18n.Printf("Cannot setup firewall: %v\n", err)
+			}
 			err = s.Start()
 		}
-		fmt.Printf("NextDNS installed and started using %s init\n", service.Name(s))
-		return err
+		if err != nil {
+			return withCode(exitSystem, err)
+		}
+		if !jsonOutput {
+			i18n.Printf("NextDNS installed and started using %s init\n", service.Name(s))
+		}
+		return reportChanges(changes)
 	case "uninstall":
 		_ = deactivate()
 		_ = s.Stop()
-		return s.Uninstall()
+		_ = host.ResetFirewall()
+		err := s.Uninstall()
+		if !verify {
+			return withCode(exitSystem, err)
+		}
+		i18n.Println("Verifying uninstall:")
+		if residue := verifyUninstall(s, c); len(residue) > 0 {
+			return withCode(exitSystem, fmt.Errorf("%d residue(s) could not be reverted", len(residue)))
+		}
+		return nil
 	case "start":
-		return s.Start()
+		return controlError(s, s.Start())
 	case "stop":
-		return s.Stop()
+		return controlError(s, s.Stop())
 	case "restart":
-		return s.Restart()
+		return controlError(s, s.Restart())
 	case "status":
 		status := "unknown"
-		s, err := s.Status()
+		st, err := s.Status()
 		if err != nil {
-			return err
+			return withCode(exitSystem, err)
 		}
-		switch s {
+		switch st {
 		case service.StatusRunning:
 			status = "running"
 		case service.StatusStopped:
@@ -70,7 +117,44 @@ func svc(args []string) error {
 		case service.StatusNotInstalled:
 			status = "not installed"
 		}
+		var ds daemonStatus
+		if st == service.StatusRunning {
+			ds = runningStatus(c.Control)
+		}
+		if jsonOutput {
+			out := map[string]interface{}{"status": status}
+			if !ds.DowngradedSince.IsZero() {
+				out["downgraded_since"] = ds.DowngradedSince.Format(time.RFC3339)
+			}
+			if ds.Hijack != "" {
+				out["hijack"] = ds.Hijack
+			}
+			if len(ds.Paused) > 0 {
+				out["paused"] = ds.Paused
+			}
+			if ds.CacheOnly {
+				out["cache_only"] = true
+			}
+			return json.NewEncoder(os.Stdout).Encode(out)
+		}
+		// The status is read by scripts, it is not translated.
 		fmt.Println(status)
+		if !ds.DowngradedSince.IsZero() {
+			i18n.Printf("Warning: queries answered over plain DNS (unencrypted, unfiltered) since %s\n", ds.DowngradedSince.Local().Format(time.RFC1123))
+		}
+		if ds.Hijack != "" {
+			i18n.Printf("Warning: plain DNS is intercepted on this network (%s), the plain DNS fallback is unsafe\n", ds.Hijack)
+		}
+		if ds.CacheOnly {
+			i18n.Printf("Warning: cache-only mode, upstreams not contacted\n")
+		}
+		for _, p := range ds.Paused {
+			who := i18n.T("all clients")
+			if p.Client != "" {
+				who = p.Client
+			}
+			i18n.Printf("Filtering paused for %s until %s\n", who, p.Until.Local().Format(time.RFC1123))
+		}
 		return nil
 	case "log":
 		l, err := host.ReadLog("nextdns")
@@ -80,3 +164,75 @@ func svc(args []string) error {
 		panic("unknown cmd: " + cmd)
 	}
 }
+
+// daemonStatus holds the warnings reported by the status command of a running
+// daemon.
+type daemonStatus struct {
+	// DowngradedSince is the time since which queries are answered over the
+	// plain DNS fallback, or a zero time.
+	DowngradedSince time.Time `json:"downgraded_since"`
+
+	// Hijack describes how plain DNS is intercepted on the network, if it
+	// is.
+	Hijack string `json:"hijack"`
+
+	// Paused lists the pauses of filtering.
+	Paused []pause.Pause `json:"paused"`
+
+	// CacheOnly is true if the queries are answered from the cache and local
+	// records only.
+	CacheOnly bool `json:"cache_only"`
+}
+
+// runningStatus returns the status of the daemon listening on the control
+// socket, or a zero status if it cannot be reached.
+func runningStatus(control string) daemonStatus {
+	var st daemonStatus
+	if control == "" {
+		return st
+	}
+	b, err := ctl.Send(control, "status")
+	if err != nil {
+		return st
+	}
+	_ = json.Unmarshal(b, &st)
+	return st
+}
+
+// installChanges returns the changes installing s with the configuration c
+// would make to the system.
+func installChanges(s service.Service, c config.Config) ([]string, error) {
+	if err := checkUpgradeKey(c); err != nil {
+		return nil, withCode(exitConfig, err)
+	}
+	changes, err := configChanges(c)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.Status()
+	if err != nil {
+		return nil, withCode(exitSystem, err)
+	}
+	switch st {
+	case service.StatusRunning:
+		if len(changes) > 0 {
+			changes = append(changes, "restart service")
+		}
+	case service.StatusNotInstalled:
+		changes = append(changes, "install service", "start service")
+	default:
+		changes = append(changes, "start service")
+	}
+	return changes, nil
+}
+
+// controlError returns err with the exit code matching the state of s.
+func controlError(s service.Service, err error) error {
+	if err == nil {
+		return nil
+	}
+	if st, serr := s.Status(); serr == nil && st == service.StatusNotInstalled {
+		return withCode(exitNotInstalled, err)
+	}
+	return withCode(exitSystem, err)
+}
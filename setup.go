@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/proxy"
+	"github.com/nextdns/nextdns/resolver"
+	"github.com/nextdns/nextdns/router"
+)
+
+// configIDRe matches a NextDNS configuration ID.
+var configIDRe = regexp.MustCompile(`^[0-9a-f]{6}$`)
+
+// prompter asks questions on a terminal.
+type prompter struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+// ask asks question and returns the answer, or def if the answer is empty.
+func (p prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.w, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.w, "%s: ", question)
+	}
+	line, err := p.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+// confirm asks a yes/no question.
+func (p prompter) confirm(question string, def bool) (bool, error) {
+	d := "y/N"
+	if def {
+		d = "Y/n"
+	}
+	for {
+		a, err := p.ask(question+" ("+d+")", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(a) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// routerName returns the name of the router firmware detected, or an empty
+// string if not running on a supported router.
+func routerName(r router.Router) string {
+	name := strings.TrimPrefix(fmt.Sprintf("%T", r), "*")
+	if idx := strings.IndexByte(name, '.'); idx != -1 {
+		name = name[:idx]
+	}
+	if name == "generic" {
+		return ""
+	}
+	return name
+}
+
+// testUpstream sends a test query to the DoH endpoint of the configuration id.
+func testUpstream(id string) error {
+	r, err := resolver.New("https://dns.nextdns.io/" + id)
+	if err != nil {
+		return err
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 1, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName("nextdns.io."),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	payload, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	q, err := resolver.NewQuery(payload, nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _, err = r.Resolve(ctx, q, make([]byte, 1500))
+	return err
+}
+
+func setup(args []string) error {
+	var c config.Config
+	c.Parse("nextdns setup", args[1:], true)
+	p := prompter{r: bufio.NewReader(os.Stdin), w: os.Stdout}
+
+	fmt.Printf("NextDNS %s setup for %s\n\n", version, platform)
+
+	r := router.New()
+	rname := routerName(r)
+	if rname != "" {
+		fmt.Printf("Router detected: %s\n\n", rname)
+	}
+
+	// Configuration ID.
+	for {
+		id, err := p.ask("NextDNS configuration ID (found on the Setup tab of https://my.nextdns.io)", c.Conf.Get(nil, nil))
+		if err != nil {
+			return err
+		}
+		id = strings.ToLower(id)
+		if !configIDRe.MatchString(id) {
+			fmt.Println("Invalid configuration ID, it should look like abcdef.")
+			continue
+		}
+		fmt.Print("Testing connectivity... ")
+		if err := testUpstream(id); err != nil {
+			fmt.Printf("failed: %v\n", err)
+			if ok, err := p.confirm("Use this configuration ID anyway?", false); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+		} else {
+			fmt.Println("ok")
+		}
+		if err := c.Conf.Set(id); err != nil {
+			return err
+		}
+		break
+	}
+
+	// Listen addresses.
+	c.SetupRouter = false
+	if rname != "" {
+		ok, err := p.confirm(fmt.Sprintf("Setup NextDNS for the whole network as a %s router?", rname), true)
+		if err != nil {
+			return err
+		}
+		c.SetupRouter = ok
+	}
+	if c.SetupRouter {
+		ok, err := p.confirm("Report the name and model of LAN clients to NextDNS?", true)
+		if err != nil {
+			return err
+		}
+		c.ReportClientInfo = ok
+	} else {
+		for {
+			listen, err := p.ask("Listen addresses (comma separated IP:PORT or interface:PORT)", c.Listen)
+			if err != nil {
+				return err
+			}
+			if err := checkListen(listen); err != nil {
+				fmt.Println(err)
+				continue
+			}
+			c.Listen = listen
+			break
+		}
+		if !isLocalhostMode(&c) {
+			ok, err := p.confirm("Report the name and model of LAN clients to NextDNS?", c.ReportClientInfo)
+			if err != nil {
+				return err
+			}
+			c.ReportClientInfo = ok
+		}
+	}
+
+	// Activation.
+	ok, err := p.confirm("Configure this system to use NextDNS as its resolver?", true)
+	if err != nil {
+		return err
+	}
+	c.AutoActivate = ok
+
+	fmt.Println("\nConfiguration:")
+	_ = c.Write(os.Stdout)
+	fmt.Println()
+	if ok, err := p.confirm("Save this configuration?", true); err != nil {
+		return err
+	} else if !ok {
+		return errors.New("setup aborted")
+	}
+	if err := c.Save(); err != nil {
+		return fmt.Errorf("cannot write config: %v", err)
+	}
+	if ok, err := p.confirm("Install and start the NextDNS service now?", true); err != nil {
+		return err
+	} else if ok {
+		return svc([]string{"install"})
+	}
+	fmt.Println("Run \"nextdns install\" to install the service.")
+	return nil
+}
+
+// checkListen validates a comma separated list of listen addresses.
+func checkListen(listen string) error {
+	addrs := proxy.SplitAddr(listen)
+	if len(addrs) == 0 {
+		return errors.New("at least one listen address is required")
+	}
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("%s: invalid listen address: %v", addr, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nextdns/nextdns/router/generic"
+	"github.com/nextdns/nextdns/router/openwrt"
+)
+
+func TestPrompter(t *testing.T) {
+	var out bytes.Buffer
+	p := prompter{
+		r: bufio.NewReader(strings.NewReader("\n abcdef \nmaybe\nYES\n\nn")),
+		w: &out,
+	}
+	if a, err := p.ask("ID", "123456"); err != nil || a != "123456" {
+		t.Errorf("ask() = %q, %v, want the default", a, err)
+	}
+	if a, err := p.ask("ID", "123456"); err != nil || a != "abcdef" {
+		t.Errorf("ask() = %q, %v, want abcdef", a, err)
+	}
+	// Invalid answers are asked again.
+	if ok, err := p.confirm("Save?", false); err != nil || !ok {
+		t.Errorf("confirm() = %v, %v, want true", ok, err)
+	}
+	if ok, err := p.confirm("Save?", true); err != nil || !ok {
+		t.Errorf("confirm() = %v, %v, want the default", ok, err)
+	}
+	// A last answer without new line is accepted.
+	if ok, err := p.confirm("Save?", true); err != nil || ok {
+		t.Errorf("confirm() = %v, %v, want false", ok, err)
+	}
+	if _, err := p.ask("ID", ""); err != io.EOF {
+		t.Errorf("ask() err = %v, want EOF", err)
+	}
+	want := "ID [123456]: ID [123456]: Save? (y/N): Save? (y/N): Save? (Y/n): Save? (Y/n): ID: "
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func Test_routerName(t *testing.T) {
+	if name := routerName(&generic.Router{}); name != "" {
+		t.Errorf("routerName(generic) = %q, want none", name)
+	}
+	if name := routerName(&openwrt.Router{}); name != "openwrt" {
+		t.Errorf("routerName(openwrt) = %q, want openwrt", name)
+	}
+}
+
+func Test_checkListen(t *testing.T) {
+	tests := []struct {
+		listen  string
+		wantErr bool
+	}{
+		{"localhost:53", false},
+		{"127.0.0.1:53, [::1]:53", false},
+		{"udp://:53,tcp://br0:5353", false},
+		{"", true},
+		{" , ", true},
+		{"127.0.0.1", true},
+		{"127.0.0.1:53,::1", true},
+	}
+	for _, tt := range tests {
+		if err := checkListen(tt.listen); (err != nil) != tt.wantErr {
+			t.Errorf("checkListen(%q) err = %v, wantErr %v", tt.listen, err, tt.wantErr)
+		}
+	}
+}
+
+func Test_configIDRe(t *testing.T) {
+	for id, want := range map[string]bool{
+		"abcdef":  true,
+		"0a1b2c":  true,
+		"ABCDEF":  false,
+		"abcde":   false,
+		"abcdefg": false,
+		"abcdeg":  false,
+	} {
+		if got := configIDRe.MatchString(id); got != want {
+			t.Errorf("configIDRe.MatchString(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package slo implements a resolution latency and error rate monitor checking
+// a set of service level objectives over a sliding window.
+package slo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSamples is the maximum number of samples kept in the window.
+const maxSamples = 10000
+
+// maxEvidence is the maximum number of samples attached to an alert.
+const maxEvidence = 10
+
+// Sample is a single resolution measurement.
+type Sample struct {
+	Time     time.Time     `json:"time"`
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Stats are the statistics computed over the window.
+type Stats struct {
+	Count     int           `json:"count"`
+	P50       time.Duration `json:"p50"`
+	P95       time.Duration `json:"p95"`
+	ErrorRate float64       `json:"error_rate"`
+}
+
+// Alert is reported when objectives start or stop being breached.
+type Alert struct {
+	Time       time.Time     `json:"time"`
+	Resolved   bool          `json:"resolved"`
+	Window     time.Duration `json:"window"`
+	Stats      Stats         `json:"stats"`
+	Violations []string      `json:"violations,omitempty"`
+	// Evidence holds the slowest and failed recent samples.
+	Evidence []Sample `json:"evidence,omitempty"`
+}
+
+func (a Alert) String() string {
+	if a.Resolved {
+		return fmt.Sprintf("SLO restored: p50=%v p95=%v errors=%.2f%% (%d queries over %v)",
+			a.Stats.P50, a.Stats.P95, a.Stats.ErrorRate*100, a.Stats.Count, a.Window)
+	}
+	return fmt.Sprintf("SLO breached: %s (%d queries over %v)",
+		strings.Join(a.Violations, ", "), a.Stats.Count, a.Window)
+}
+
+// Monitor records resolution samples and checks them against the objectives.
+// A zero objective is not checked.
+type Monitor struct {
+	// Window is the duration of the sliding window statistics are computed
+	// over.
+	Window time.Duration
+
+	// P50 and P95 are the maximum allowed median and 95th percentile latencies.
+	P50 time.Duration
+	P95 time.Duration
+
+	// ErrorRate is the maximum allowed ratio of failed queries (0 to 1).
+	ErrorRate float64
+
+	// MinSamples is the minimum number of samples in the window for objectives
+	// to be checked.
+	MinSamples int
+
+	// OnAlert is called when objectives start or stop being breached.
+	OnAlert func(Alert)
+
+	mu       sync.Mutex
+	samples  []Sample
+	next     int
+	breached bool
+}
+
+// Record adds a sample to the window.
+func (m *Monitor) Record(s Sample) {
+	if s.Time.IsZero() {
+		s.Time = time.Now()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) < maxSamples {
+		m.samples = append(m.samples, s)
+		return
+	}
+	m.samples[m.next] = s
+	m.next = (m.next + 1) % maxSamples
+}
+
+// Start checks objectives every tenth of the window until ctx is cancelled.
+func (m *Monitor) Start(ctx context.Context) {
+	interval := m.Window / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			m.Check(now)
+		}
+	}
+}
+
+// Stats returns the statistics over the window ending at now.
+func (m *Monitor) Stats(now time.Time) Stats {
+	st, _ := m.stats(now)
+	return st
+}
+
+func (m *Monitor) stats(now time.Time) (Stats, []Sample) {
+	m.mu.Lock()
+	window := make([]Sample, 0, len(m.samples))
+	for _, s := range m.samples {
+		if now.Sub(s.Time) <= m.Window {
+			window = append(window, s)
+		}
+	}
+	m.mu.Unlock()
+
+	var st Stats
+	st.Count = len(window)
+	if st.Count == 0 {
+		return st, nil
+	}
+	durations := make([]time.Duration, 0, len(window))
+	errors := 0
+	for _, s := range window {
+		if s.Error != "" {
+			errors++
+			continue
+		}
+		durations = append(durations, s.Duration)
+	}
+	st.ErrorRate = float64(errors) / float64(st.Count)
+	if len(durations) > 0 {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		st.P50 = percentile(durations, 50)
+		st.P95 = percentile(durations, 95)
+	}
+	return st, window
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	idx := (len(sorted)*p+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// Check computes the statistics over the window ending at now and calls
+// OnAlert if the breach state changed.
+func (m *Monitor) Check(now time.Time) {
+	st, window := m.stats(now)
+	if st.Count < m.MinSamples {
+		return
+	}
+	var violations []string
+	if m.P50 > 0 && st.P50 > m.P50 {
+		violations = append(violations, fmt.Sprintf("p50 %v > %v", st.P50, m.P50))
+	}
+	if m.P95 > 0 && st.P95 > m.P95 {
+		violations = append(violations, fmt.Sprintf("p95 %v > %v", st.P95, m.P95))
+	}
+	if m.ErrorRate > 0 && st.ErrorRate > m.ErrorRate {
+		violations = append(violations, fmt.Sprintf("error rate %.2f%% > %.2f%%", st.ErrorRate*100, m.ErrorRate*100))
+	}
+	breached := len(violations) > 0
+
+	m.mu.Lock()
+	changed := breached != m.breached
+	m.breached = breached
+	m.mu.Unlock()
+	if !changed || m.OnAlert == nil {
+		return
+	}
+	a := Alert{
+		Time:       now,
+		Resolved:   !breached,
+		Window:     m.Window,
+		Stats:      st,
+		Violations: violations,
+	}
+	if breached {
+		a.Evidence = evidence(window)
+	}
+	m.OnAlert(a)
+}
+
+// evidence returns the most recent failed samples followed by the slowest
+// ones.
+func evidence(window []Sample) []Sample {
+	sort.Slice(window, func(i, j int) bool {
+		ei, ej := window[i].Error != "", window[j].Error != ""
+		if ei != ej {
+			return ei
+		}
+		if ei {
+			return window[i].Time.After(window[j].Time)
+		}
+		return window[i].Duration > window[j].Duration
+	})
+	if len(window) > maxEvidence {
+		window = window[:maxEvidence]
+	}
+	return window
+}
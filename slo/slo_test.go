@@ -0,0 +1,57 @@
+package slo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonitor_Check(t *testing.T) {
+	var alerts []Alert
+	m := &Monitor{
+		Window:     time.Minute,
+		P95:        100 * time.Millisecond,
+		ErrorRate:  0.1,
+		MinSamples: 10,
+		OnAlert: func(a Alert) {
+			alerts = append(alerts, a)
+		},
+	}
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		m.Record(Sample{Time: now, Duration: 10 * time.Millisecond})
+	}
+	m.Check(now)
+	if len(alerts) != 0 {
+		t.Fatalf("unexpected alert: %v", alerts[0])
+	}
+
+	for i := 0; i < 20; i++ {
+		m.Record(Sample{Time: now, Name: "slow.example.", Duration: time.Second})
+	}
+	m.Check(now)
+	if len(alerts) != 1 || alerts[0].Resolved {
+		t.Fatalf("expected breach alert, got %v", alerts)
+	}
+	if got := alerts[0].Stats.P95; got != time.Second {
+		t.Errorf("P95 = %v, want 1s", got)
+	}
+	if got := alerts[0].Evidence[0].Name; got != "slow.example." {
+		t.Errorf("Evidence[0] = %v, want slow.example.", got)
+	}
+
+	// Still breached: no new alert.
+	m.Check(now)
+	if len(alerts) != 1 {
+		t.Fatalf("unexpected alert: %v", alerts[1])
+	}
+
+	// Samples leave the window.
+	later := now.Add(2 * time.Minute)
+	for i := 0; i < 10; i++ {
+		m.Record(Sample{Time: later, Duration: 10 * time.Millisecond})
+	}
+	m.Check(later)
+	if len(alerts) != 2 || !alerts[1].Resolved {
+		t.Fatalf("expected resolved alert, got %v", alerts)
+	}
+}
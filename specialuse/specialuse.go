@@ -0,0 +1,286 @@
+// Package specialuse handles queries for special-use and private domains
+// (RFC 6761) locally instead of leaking them to the upstream resolver.
+package specialuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// Actions applied to the queries of a zone.
+const (
+	// ActionNXDomain answers with NXDOMAIN.
+	ActionNXDomain = "nxdomain"
+	// ActionRefuse answers with REFUSED.
+	ActionRefuse = "refuse"
+	// ActionNoData answers with an empty NOERROR response.
+	ActionNoData = "nodata"
+	// ActionForward sends the query to the upstream resolver.
+	ActionForward = "forward"
+	// ActionLocal sends the query to the network provided DNS servers.
+	ActionLocal = "local"
+)
+
+// Rule defines the action applied to the queries for a zone and its
+// sub-domains.
+type Rule struct {
+	Zone   string
+	Action string
+}
+
+// DefaultRules are the built-in rules. They are overridden by the rules of
+// Resolver with the same zone.
+var DefaultRules = []Rule{
+	{"local.", ActionNXDomain},     // mDNS only (RFC 6762)
+	{"onion.", ActionNXDomain},     // Tor only (RFC 7686)
+	{"invalid.", ActionNXDomain},   // RFC 6761
+	{"home.arpa.", ActionNXDomain}, // RFC 8375
+	{"wpad.", ActionNXDomain},      // Unqualified WPAD lookups
+	{"10.in-addr.arpa.", ActionNXDomain},
+	{"16.172.in-addr.arpa.", ActionNXDomain},
+	{"17.172.in-addr.arpa.", ActionNXDomain},
+	{"18.172.in-addr.arpa.", ActionNXDomain},
+	{"19.172.in-addr.arpa.", ActionNXDomain},
+	{"20.172.in-addr.arpa.", ActionNXDomain},
+	{"21.172.in-addr.arpa.", ActionNXDomain},
+	{"22.172.in-addr.arpa.", ActionNXDomain},
+	{"23.172.in-addr.arpa.", ActionNXDomain},
+	{"24.172.in-addr.arpa.", ActionNXDomain},
+	{"25.172.in-addr.arpa.", ActionNXDomain},
+	{"26.172.in-addr.arpa.", ActionNXDomain},
+	{"27.172.in-addr.arpa.", ActionNXDomain},
+	{"28.172.in-addr.arpa.", ActionNXDomain},
+	{"29.172.in-addr.arpa.", ActionNXDomain},
+	{"30.172.in-addr.arpa.", ActionNXDomain},
+	{"31.172.in-addr.arpa.", ActionNXDomain},
+	{"168.192.in-addr.arpa.", ActionNXDomain},
+	{"254.169.in-addr.arpa.", ActionNXDomain},
+	{"d.f.ip6.arpa.", ActionNXDomain},
+	{"8.e.f.ip6.arpa.", ActionNXDomain},
+	{"9.e.f.ip6.arpa.", ActionNXDomain},
+	{"a.e.f.ip6.arpa.", ActionNXDomain},
+	{"b.e.f.ip6.arpa.", ActionNXDomain},
+}
+
+// CanaryRules are the rules signaling browsers not to enable their own DoH
+// resolver automatically on the network (use-application-dns.net, see
+// https://support.mozilla.org/kb/canary-domain-use-application-dnsnet).
+var CanaryRules = []Rule{
+	{"use-application-dns.net.", ActionNXDomain},
+}
+
+// DDRRules are the rules answering the Discovery of Designated Resolvers
+// queries (DDR, RFC 9462) with no designated resolver: the proxy has no
+// encrypted endpoint of its own, and those of the upstream resolver would let
+// clients bypass it.
+var DDRRules = []Rule{
+	{"resolver.arpa.", ActionNoData},
+}
+
+// BypassRules are the rules blocking the hostnames of well-known public DoH
+// resolvers, so clients cannot bypass the local resolver and its policy with
+// their own encrypted DNS.
+var BypassRules = []Rule{
+	{"dns.google.", ActionNXDomain},
+	{"dns.google.com.", ActionNXDomain},
+	{"cloudflare-dns.com.", ActionNXDomain},
+	{"one.one.one.one.", ActionNXDomain},
+	{"dns.quad9.net.", ActionNXDomain},
+	{"dns9.quad9.net.", ActionNXDomain},
+	{"dns10.quad9.net.", ActionNXDomain},
+	{"dns11.quad9.net.", ActionNXDomain},
+	{"doh.opendns.com.", ActionNXDomain},
+	{"doh.familyshield.opendns.com.", ActionNXDomain},
+	{"dns.adguard.com.", ActionNXDomain},
+	{"dns-family.adguard.com.", ActionNXDomain},
+	{"dns-unfiltered.adguard.com.", ActionNXDomain},
+	{"dns.adguard-dns.com.", ActionNXDomain},
+	{"family.adguard-dns.com.", ActionNXDomain},
+	{"unfiltered.adguard-dns.com.", ActionNXDomain},
+	{"doh.cleanbrowsing.org.", ActionNXDomain},
+	{"doh.mullvad.net.", ActionNXDomain},
+	{"dns.controld.com.", ActionNXDomain},
+	{"freedns.controld.com.", ActionNXDomain},
+	{"dns0.eu.", ActionNXDomain},
+	{"doh.dns.sb.", ActionNXDomain},
+	{"dns.alidns.com.", ActionNXDomain},
+	{"doh.pub.", ActionNXDomain},
+	{"doh.xfinity.com.", ActionNXDomain},
+	{"ordns.he.net.", ActionNXDomain},
+}
+
+// ParseRule parses a rule in the ZONE=ACTION form.
+func ParseRule(v string) (Rule, error) {
+	idx := strings.IndexByte(v, '=')
+	if idx == -1 {
+		return Rule{}, fmt.Errorf("%s: invalid special domain rule, expected ZONE=ACTION", v)
+	}
+	r := Rule{
+		Zone:   strings.ToLower(strings.TrimSpace(v[:idx])),
+		Action: strings.ToLower(strings.TrimSpace(v[idx+1:])),
+	}
+	if r.Zone == "" {
+		return Rule{}, fmt.Errorf("%s: missing zone", v)
+	}
+	if !strings.HasSuffix(r.Zone, ".") {
+		r.Zone += "."
+	}
+	switch r.Action {
+	case ActionNXDomain, ActionRefuse, ActionNoData, ActionForward, ActionLocal:
+	default:
+		return Rule{}, fmt.Errorf("%s: invalid action %q", v, r.Action)
+	}
+	return r, nil
+}
+
+func (r Rule) String() string {
+	return r.Zone + "=" + r.Action
+}
+
+// Match returns true if name is the zone of r or one of its sub-domains.
+func (r Rule) Match(name string) bool {
+	return name == r.Zone || strings.HasSuffix(name, "."+r.Zone)
+}
+
+// Resolver applies the action of the most specific rule matching the query
+// name, and sends the queries matching no rule to Upstream.
+type Resolver struct {
+	// Rules are the user defined rules, overriding CanaryRules, DDRRules and
+	// DefaultRules.
+	Rules []Rule
+
+	// Canary specifies that CanaryRules are applied.
+	Canary bool
+
+	// BlockDDR specifies that DDRRules are applied.
+	BlockDDR bool
+
+	// BlockBypass specifies that BypassRules are applied to the queries of
+	// the clients. The queries of the host itself are not affected, so the
+	// DoH forwarders can still be resolved.
+	BlockBypass bool
+
+	// Servers returns the addresses of the network provided DNS servers, as
+	// IP or IP:PORT, used by the local action.
+	Servers func() []string
+
+	// Upstream is the resolver used for the forward action and the queries
+	// matching no rule.
+	Upstream resolver.Resolver
+
+	dns53 resolver.DNS53
+}
+
+// Action returns the action applied to name for the clients, or
+// ActionForward if no rule matches.
+func (r *Resolver) Action(name string) string {
+	action, _ := r.action(name, r.BlockBypass)
+	return action
+}
+
+// action returns the action applied to name, applying BypassRules if bypass
+// is true, and whether the rule matching name is a BypassRules one.
+func (r *Resolver) action(name string, bypass bool) (string, bool) {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	best := Rule{Action: ActionForward}
+	var bestBypass bool
+	ruleSets := [][]Rule{r.Rules}
+	if r.Canary {
+		ruleSets = append(ruleSets, CanaryRules)
+	}
+	if r.BlockDDR {
+		ruleSets = append(ruleSets, DDRRules)
+	}
+	if bypass {
+		ruleSets = append(ruleSets, BypassRules)
+	}
+	ruleSets = append(ruleSets, DefaultRules)
+	for i, rules := range ruleSets {
+		for _, rule := range rules {
+			// Rules of the first list win for the same zone.
+			if rule.Match(name) && len(rule.Zone) > len(best.Zone) {
+				best = rule
+				bestBypass = bypass && i == len(ruleSets)-2
+			}
+		}
+	}
+	return best.Action, bestBypass
+}
+
+// Resolve implements the resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	bypass := r.BlockBypass && (q.PeerIP == nil || !q.PeerIP.IsLoopback())
+	action, blocked := r.action(q.Name, bypass)
+	if blocked {
+		n, i, err := reply(q, dnsmessage.RCodeNameError, buf)
+		i.Source = "blocked as a public DoH resolver"
+		return n, i, err
+	}
+	switch action {
+	case ActionNXDomain:
+		return reply(q, dnsmessage.RCodeNameError, buf)
+	case ActionRefuse:
+		return reply(q, dnsmessage.RCodeRefused, buf)
+	case ActionNoData:
+		return reply(q, dnsmessage.RCodeSuccess, buf)
+	case ActionLocal:
+		return r.resolveLocal(ctx, q, buf)
+	}
+	return r.Upstream.Resolve(ctx, q, buf)
+}
+
+func (r *Resolver) resolveLocal(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var servers []string
+	if r.Servers != nil {
+		servers = r.Servers()
+	}
+	if len(servers) == 0 {
+		return -1, i, errors.New("specialuse: no network DNS server")
+	}
+	payload := q.Payload
+	for _, s := range servers {
+		// Keep the payload intact for the next server.
+		q.Payload = append([]byte(nil), payload...)
+		addr := s
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			addr = net.JoinHostPort(s, "53")
+		}
+		if n, i, err = r.dns53.Exchange(ctx, q, buf, addr); err == nil {
+			return n, i, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return n, i, err
+}
+
+// reply writes a response to q with no answer and the given rcode to buf.
+func reply(q resolver.Query, rcode dnsmessage.RCode, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return -1, i, err
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return -1, i, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = rcode
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	buf, err = b.Finish()
+	return len(buf), i, err
+}
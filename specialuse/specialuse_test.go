@@ -0,0 +1,86 @@
+package specialuse
+
+import (
+	"testing"
+)
+
+func TestResolver_Action(t *testing.T) {
+	r := &Resolver{Rules: []Rule{
+		{"home.arpa.", ActionLocal},
+		{"corp.local.", ActionForward},
+	}, Canary: true, BlockDDR: true}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"printer.local.", ActionNXDomain},
+		{"LOCAL", ActionNXDomain},
+		{"fileserver.corp.local.", ActionForward},
+		{"nas.home.arpa.", ActionLocal},
+		{"example.onion.", ActionNXDomain},
+		{"wpad.", ActionNXDomain},
+		{"wpad.example.com.", ActionForward},
+		{"1.1.168.192.in-addr.arpa.", ActionNXDomain},
+		{"1.1.32.172.in-addr.arpa.", ActionForward},
+		{"example.com.", ActionForward},
+		{"notlocal.", ActionForward},
+		{"use-application-dns.net.", ActionNXDomain},
+		{"_dns.resolver.arpa.", ActionNoData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Action(tt.name); got != tt.want {
+				t.Errorf("Action(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver_Action_BlockBypass(t *testing.T) {
+	r := &Resolver{Rules: []Rule{
+		{"dns.google.", ActionForward},
+	}, BlockBypass: true}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"cloudflare-dns.com.", ActionNXDomain},
+		{"mozilla.cloudflare-dns.com.", ActionNXDomain},
+		{"DOH.OPENDNS.COM", ActionNXDomain},
+		{"dns.google.", ActionForward},
+		{"google.com.", ActionForward},
+		{"dns.nextdns.io.", ActionForward},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Action(tt.name); got != tt.want {
+				t.Errorf("Action(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    Rule
+		wantErr bool
+	}{
+		{"home.arpa=local", Rule{"home.arpa.", ActionLocal}, false},
+		{" Local. = NXDOMAIN ", Rule{"local.", ActionNXDomain}, false},
+		{"home.arpa", Rule{}, true},
+		{"=refuse", Rule{}, true},
+		{"local=drop", Rule{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.v, func(t *testing.T) {
+			got, err := ParseRule(tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRule() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nextdns/nextdns/history"
+)
+
+// stats summarizes the locally retained query history, optionally as a day of
+// the week by hour of the day heatmap.
+func stats(args []string) error {
+	fs := flag.NewFlagSet("nextdns stats", flag.ExitOnError)
+	heatmap := fs.Bool("heatmap", false, "Render the query volume and p95 latency as 24x7 heatmaps.")
+	from := fs.String("from", "168h", "Start of the range, as a date (2006-01-02), a RFC3339 time or a duration before\n"+
+		"now (i.e. 24h). The whole history is used if empty.")
+	to := fs.String("to", "", "End of the range (excluded), in the same formats as from. Up to now if empty.")
+	dir := fs.String("dir", "", "Directory of the query history. Defaults to the query-history setting.")
+	_ = fs.Parse(args[1:])
+
+	if err := historyDir("stats", dir); err != nil {
+		return err
+	}
+	now := time.Now()
+	start, err := parseExportTime(*from, now)
+	if err != nil {
+		return fmt.Errorf("from: %v", err)
+	}
+	end, err := parseExportTime(*to, now)
+	if err != nil {
+		return fmt.Errorf("to: %v", err)
+	}
+
+	var h history.Heatmap
+	if err := history.Read(*dir, start, end, h.Add); err != nil {
+		return err
+	}
+	queries, errs, p95 := h.Total()
+	fmt.Printf("Queries: %d\nErrors:  %d\np95:     %v\n", queries, errs, p95.Round(time.Millisecond))
+	if !*heatmap {
+		return nil
+	}
+	for _, metric := range []string{history.MetricQueries, history.MetricP95} {
+		fmt.Println()
+		if err := h.Render(os.Stdout, metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/ctl"
+	"github.com/nextdns/nextdns/top"
+)
+
+// topCmd shows a live view of the queries served by the daemon, refreshed
+// from the rollups it maintains in memory.
+func topCmd(args []string) error {
+	fs := flag.NewFlagSet("nextdns top", flag.ExitOnError)
+	addr := ctl.DefaultAddr
+	if runtime.GOOS == "windows" {
+		addr = ""
+	}
+	fs.StringVar(&addr, "control", addr, "Path to the control socket of the daemon.")
+	n := fs.Int("n", 10, "Number of domains and clients to show.")
+	interval := fs.Duration("interval", 2*time.Second, "Refresh interval.")
+	once := fs.Bool("once", false, "Show the current view once and exit.")
+	_ = fs.Parse(args[1:])
+	if addr == "" {
+		return withCode(exitUsage, errors.New("missing control socket path"))
+	}
+	if *n <= 0 {
+		return withCode(exitUsage, fmt.Errorf("%d: invalid number of entries", *n))
+	}
+	if *interval < 100*time.Millisecond {
+		*interval = 100 * time.Millisecond
+	}
+	st, _ := os.Stdout.Stat()
+	tty := st != nil && st.Mode()&os.ModeCharDevice != 0
+	for {
+		data, err := sendControl(addr, "top", strconv.Itoa(*n))
+		if err != nil {
+			return err
+		}
+		var s top.Snapshot
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		if jsonOutput {
+			if err := json.NewEncoder(os.Stdout).Encode(s); err != nil {
+				return err
+			}
+		} else {
+			if tty && !*once {
+				// Clear the screen.
+				fmt.Print("\033[H\033[2J")
+			}
+			printTop(s, *n)
+		}
+		if *once {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// printTop prints s with the domains and clients side by side.
+func printTop(s top.Snapshot, n int) {
+	fmt.Printf("Window %v, %d queries, %.1f qps, latency p50=%dms p95=%dms p99=%dms\n",
+		s.Window.Round(time.Second), s.Queries, s.QPS, s.P50.Milliseconds(), s.P95.Milliseconds(), s.P99.Milliseconds())
+	rcodes := make([]string, 0, len(s.RCodes))
+	for _, c := range s.RCodes {
+		rcodes = append(rcodes, fmt.Sprintf("%s %d", c.Key, c.Count))
+	}
+	fmt.Printf("Responses: %s\n\n", strings.Join(rcodes, ", "))
+	fmt.Printf("%-40s %8s   %-30s %8s\n", "DOMAIN", "QUERIES", "CLIENT", "QUERIES")
+	for i := 0; i < n && (i < len(s.Domains) || i < len(s.Clients)); i++ {
+		var domain, client top.Count
+		if i < len(s.Domains) {
+			domain = s.Domains[i]
+		}
+		if i < len(s.Clients) {
+			client = s.Clients[i]
+		}
+		line := fmt.Sprintf("%-40s %8s   %-30s %8s", truncate(domain.Key, 40), countString(domain),
+			truncate(client.Key, 30), countString(client))
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+}
+
+func countString(c top.Count) string {
+	if c.Key == "" {
+		return ""
+	}
+	return strconv.FormatUint(c.Count, 10)
+}
+
+// truncate shortens s to n bytes, marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
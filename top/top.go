@@ -0,0 +1,230 @@
+// Package top maintains sliding window rollups of the queries (most queried
+// domains, most active clients, response codes and latencies), cheap enough
+// to be always on, so the traffic can be watched live without query logging.
+package top
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Window is the period covered by the rollups.
+	Window = time.Minute
+
+	// buckets is the number of one second buckets of the window.
+	buckets = int(Window / time.Second)
+
+	// maxKeys is the maximum number of domains or clients counted per
+	// bucket. Once reached, new ones are counted as Other.
+	maxKeys = 1000
+)
+
+// Other is the key counting the domains and clients beyond maxKeys in a
+// bucket.
+const Other = "(other)"
+
+// latencyBounds are the upper bounds of the latency histogram bins.
+var latencyBounds = [...]time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+}
+
+// Count is the number of queries of a key.
+type Count struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// Snapshot is the rollup of the queries of the window.
+type Snapshot struct {
+	// Window is the duration covered, less than Window until a full window
+	// elapsed.
+	Window  time.Duration `json:"window"`
+	Queries uint64        `json:"queries"`
+	QPS     float64       `json:"qps"`
+	Domains []Count       `json:"domains"`
+	Clients []Count       `json:"clients"`
+	RCodes  []Count       `json:"rcodes"`
+	// The latency percentiles are the upper bound of their histogram bin.
+	P50 time.Duration `json:"p50"`
+	P95 time.Duration `json:"p95"`
+	P99 time.Duration `json:"p99"`
+}
+
+// Rollup counts the queries over the last Window in one second buckets.
+type Rollup struct {
+	mu      sync.Mutex
+	start   time.Time
+	buckets [buckets]bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	sec     int64
+	queries uint64
+	domains map[string]uint64
+	clients map[string]uint64
+	rcodes  map[string]uint64
+	latency [len(latencyBounds) + 1]uint64
+	max     time.Duration
+}
+
+func (b *bucket) reset(sec int64) {
+	b.sec = sec
+	b.queries = 0
+	for _, m := range []map[string]uint64{b.domains, b.clients, b.rcodes} {
+		for k := range m {
+			delete(m, k)
+		}
+	}
+	b.latency = [len(latencyBounds) + 1]uint64{}
+	b.max = 0
+}
+
+func (r *Rollup) timeNow() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+// Record counts a query for domain from client, answered with rcode after d.
+func (r *Rollup) Record(domain, client, rcode string, d time.Duration) {
+	now := r.timeNow()
+	sec := now.Unix()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.start.IsZero() {
+		r.start = now
+	}
+	b := &r.buckets[sec%int64(buckets)]
+	if b.domains == nil {
+		b.domains, b.clients, b.rcodes = map[string]uint64{}, map[string]uint64{}, map[string]uint64{}
+	}
+	if b.sec != sec {
+		b.reset(sec)
+	}
+	b.queries++
+	count(b.domains, domain)
+	count(b.clients, client)
+	b.rcodes[rcode]++
+	i := sort.Search(len(latencyBounds), func(i int) bool { return d <= latencyBounds[i] })
+	b.latency[i]++
+	if d > b.max {
+		b.max = d
+	}
+}
+
+func count(m map[string]uint64, key string) {
+	if _, found := m[key]; !found && len(m) >= maxKeys {
+		key = Other
+	}
+	m[key]++
+}
+
+// Reset forgets the recorded queries.
+func (r *Rollup) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.start = time.Time{}
+	r.buckets = [buckets]bucket{}
+}
+
+// Top returns the rollup of the last Window, with the n most queried domains
+// and most active clients.
+func (r *Rollup) Top(n int) Snapshot {
+	now := r.timeNow()
+	sec := now.Unix()
+	domains, clients, rcodes := map[string]uint64{}, map[string]uint64{}, map[string]uint64{}
+	var latency [len(latencyBounds) + 1]uint64
+	var max time.Duration
+	s := Snapshot{Window: Window}
+	r.mu.Lock()
+	if elapsed := now.Sub(r.start); elapsed < Window {
+		s.Window = elapsed
+	}
+	for i := range r.buckets {
+		b := &r.buckets[i]
+		if b.queries == 0 || sec-b.sec >= int64(buckets) || b.sec > sec {
+			continue
+		}
+		s.Queries += b.queries
+		merge(domains, b.domains)
+		merge(clients, b.clients)
+		merge(rcodes, b.rcodes)
+		for j, c := range b.latency {
+			latency[j] += c
+		}
+		if b.max > max {
+			max = b.max
+		}
+	}
+	r.mu.Unlock()
+	if s.Window >= time.Second {
+		s.QPS = float64(s.Queries) / s.Window.Seconds()
+	}
+	s.Domains, s.Clients, s.RCodes = top(domains, n), top(clients, n), top(rcodes, 0)
+	s.P50 = percentile(latency, s.Queries, 0.50, max)
+	s.P95 = percentile(latency, s.Queries, 0.95, max)
+	s.P99 = percentile(latency, s.Queries, 0.99, max)
+	return s
+}
+
+func merge(dst, src map[string]uint64) {
+	for k, v := range src {
+		dst[k] += v
+	}
+}
+
+// top returns the n keys of m with the highest counts, or all of them if n is
+// zero.
+func top(m map[string]uint64, n int) []Count {
+	counts := make([]Count, 0, len(m))
+	for k, v := range m {
+		counts = append(counts, Count{Key: k, Count: v})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// percentile returns the upper bound of the bin of the latency histogram
+// holding the p percentile of total, or max for the last bin.
+func percentile(latency [len(latencyBounds) + 1]uint64, total uint64, p float64, max time.Duration) time.Duration {
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(p*float64(total) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var cum uint64
+	for i, c := range latency {
+		cum += c
+		if cum >= rank {
+			if i < len(latencyBounds) && latencyBounds[i] < max {
+				return latencyBounds[i]
+			}
+			return max
+		}
+	}
+	return max
+}
@@ -0,0 +1,58 @@
+package top
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRollup(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &Rollup{now: func() time.Time { return now }}
+	record := func(domain, client, rcode string, ms int, n int) {
+		for i := 0; i < n; i++ {
+			r.Record(domain, client, rcode, time.Duration(ms)*time.Millisecond)
+		}
+	}
+	record("old.com.", "10.0.0.9", "NOERROR", 1, 50)
+	now = now.Add(30 * time.Second)
+	record("a.com.", "10.0.0.1", "NOERROR", 3, 40)
+	record("b.com.", "10.0.0.1", "NXDOMAIN", 8, 20)
+	now = now.Add(40 * time.Second)
+	record("c.com.", "10.0.0.2", "SERVFAIL", 1500, 10)
+
+	s := r.Top(2)
+	if s.Window != Window || s.Queries != 70 || s.QPS != 70.0/60 {
+		t.Errorf("window %v, queries %d, qps %v; want %v, 70, %v", s.Window, s.Queries, s.QPS, Window, 70.0/60)
+	}
+	if want := []Count{{"a.com.", 40}, {"b.com.", 20}}; !reflect.DeepEqual(s.Domains, want) {
+		t.Errorf("domains = %v, want %v", s.Domains, want)
+	}
+	if want := []Count{{"10.0.0.1", 60}, {"10.0.0.2", 10}}; !reflect.DeepEqual(s.Clients, want) {
+		t.Errorf("clients = %v, want %v", s.Clients, want)
+	}
+	if want := []Count{{"NOERROR", 40}, {"NXDOMAIN", 20}, {"SERVFAIL", 10}}; !reflect.DeepEqual(s.RCodes, want) {
+		t.Errorf("rcodes = %v, want %v", s.RCodes, want)
+	}
+	if s.P50 != 5*time.Millisecond || s.P95 != 1500*time.Millisecond || s.P99 != 1500*time.Millisecond {
+		t.Errorf("p50 %v, p95 %v, p99 %v", s.P50, s.P95, s.P99)
+	}
+
+	now = now.Add(Window)
+	if s := r.Top(2); s.Queries != 0 || len(s.Domains) != 0 {
+		t.Errorf("got %d queries after the window", s.Queries)
+	}
+}
+
+func TestRollup_maxKeys(t *testing.T) {
+	now := time.Now()
+	r := &Rollup{now: func() time.Time { return now }}
+	for i := 0; i < maxKeys+10; i++ {
+		r.Record(fmt.Sprintf("%d.com.", i), "10.0.0.1", "NOERROR", time.Millisecond)
+	}
+	s := r.Top(1)
+	if len(s.Domains) != 1 || s.Domains[0] != (Count{Other, 10}) {
+		t.Errorf("domains = %v, want %s counting 10 queries", s.Domains, Other)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/ctl"
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/proxy"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// traceTypes are the query types accepted by the trace command.
+var traceTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"TXT":   dnsmessage.TypeTXT,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SRV":   dnsmessage.TypeSRV,
+	"SOA":   dnsmessage.TypeSOA,
+	"SVCB":  dnsmessage.TypeSVCB,
+	"HTTPS": dnsmessage.TypeHTTPS,
+	"ANY":   dnsmessage.TypeALL,
+}
+
+// traceResult is the outcome of a traced query.
+type traceResult struct {
+	Name     string               `json:"name"`
+	Type     string               `json:"type"`
+	Client   string               `json:"client"`
+	Steps    []resolver.TraceStep `json:"steps"`
+	RCode    string               `json:"rcode,omitempty"`
+	Answers  []string             `json:"answers,omitempty"`
+	Error    string               `json:"error,omitempty"`
+	Duration time.Duration        `json:"duration"`
+}
+
+// traceQuery sends a query for name and typ from client through the live
+// pipeline of p, recording the decision of each stage.
+func traceQuery(p *proxySvc, name, typ, client string, noCache bool) (traceResult, error) {
+	qtype, found := traceTypes[strings.ToUpper(typ)]
+	if !found {
+		return traceResult{}, fmt.Errorf("%s: unsupported query type", typ)
+	}
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return traceResult{}, fmt.Errorf("%s: invalid name", name)
+	}
+	peer := net.IPv4(127, 0, 0, 1)
+	if client != "" {
+		if peer = net.ParseIP(client); peer == nil {
+			return traceResult{}, fmt.Errorf("%s: invalid client IP", client)
+		}
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET})
+	payload, err := b.Finish()
+	if err != nil {
+		return traceResult{}, err
+	}
+	q, err := resolver.NewQuery(payload, peer)
+	if err != nil {
+		return traceResult{}, err
+	}
+	res := traceResult{Name: q.Name, Type: q.Type, Client: peer.String()}
+	t := &resolver.Trace{NoCache: noCache}
+	buf := make([]byte, 65535)
+	start := time.Now()
+	n, _, err := p.Proxy.Trace(context.Background(), q, buf, t)
+	res.Duration = time.Since(start)
+	res.Steps = t.Steps()
+	if err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(buf[:n]); err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	res.RCode = proxy.RCodeName(m.RCode)
+	for _, rr := range m.Answers {
+		res.Answers = append(res.Answers, traceRR(rr))
+	}
+	return res, nil
+}
+
+// traceRR formats the answer rr for display.
+func traceRR(rr dnsmessage.Resource) string {
+	var data string
+	switch b := rr.Body.(type) {
+	case *dnsmessage.AResource:
+		data = net.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		data = net.IP(b.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		data = b.CNAME.String()
+	case *dnsmessage.PTRResource:
+		data = b.PTR.String()
+	case *dnsmessage.NSResource:
+		data = b.NS.String()
+	case *dnsmessage.MXResource:
+		data = fmt.Sprintf("%d %s", b.Pref, b.MX)
+	case *dnsmessage.TXTResource:
+		data = fmt.Sprintf("%q", strings.Join(b.TXT, ""))
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %d %s %s", rr.Header.Name, rr.Header.TTL, strings.TrimPrefix(rr.Header.Type.String(), "Type"), data))
+}
+
+// setupTrace registers the control command tracing queries.
+func setupTrace(p *proxySvc) {
+	p.ctl.Command("trace", func(args []string) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, errors.New("missing name")
+		}
+		typ, client, noCache := "A", "", false
+		if len(args) > 1 && args[1] != "" {
+			typ = args[1]
+		}
+		if len(args) > 2 {
+			client = args[2]
+		}
+		if len(args) > 3 {
+			noCache = args[3] == "no-cache"
+		}
+		return traceQuery(p, args[0], typ, client, noCache)
+	})
+}
+
+// traceCmd sends a query through the running daemon and prints the decision
+// of each stage.
+func traceCmd(args []string) error {
+	fs := flag.NewFlagSet("nextdns trace", flag.ExitOnError)
+	addr := ctl.DefaultAddr
+	if runtime.GOOS == "windows" {
+		addr = ""
+	}
+	fs.StringVar(&addr, "control", addr, "Path to the control socket of the daemon.")
+	client := fs.String("client", "", "IP address of the client to send the query as, applying its configuration.")
+	noCache := fs.Bool("no-cache", false, "Do not answer from the cache, so the upstream decisions are traced.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: nextdns trace <name> [type] [-client ip] [-no-cache]\n\n")
+		fmt.Fprintf(fs.Output(), "Send a query through the running daemon and print the decision of each stage.\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return withCode(exitUsage, errors.New("missing name"))
+	}
+	name := fs.Arg(0)
+	typ := "A"
+	rest := fs.Args()[1:]
+	if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		typ, rest = strings.ToUpper(rest[0]), rest[1:]
+	}
+	// The name and type can be followed by flags.
+	_ = fs.Parse(rest)
+	if fs.NArg() > 0 {
+		return withCode(exitUsage, fmt.Errorf("%s: unexpected argument", fs.Arg(0)))
+	}
+	if _, found := traceTypes[typ]; !found {
+		return withCode(exitUsage, fmt.Errorf("%s: unsupported query type", typ))
+	}
+	if addr == "" {
+		return withCode(exitUsage, errors.New("missing control socket path"))
+	}
+	cacheArg := ""
+	if *noCache {
+		cacheArg = "no-cache"
+	}
+	data, err := sendControl(addr, "trace", name, typ, *client, cacheArg)
+	if err != nil {
+		return err
+	}
+	var res traceResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+	if jsonOutput {
+		return json.NewEncoder(os.Stdout).Encode(res)
+	}
+	fmt.Printf("Tracing %s %s from %s\n\n", res.Name, res.Type, res.Client)
+	for _, s := range res.Steps {
+		fmt.Printf("%8.1fms  %-15s %s\n", float64(s.Elapsed)/float64(time.Millisecond), s.Stage, s.Message)
+	}
+	fmt.Println()
+	if res.Error != "" {
+		fmt.Printf("Error: %s (%v)\n", res.Error, res.Duration.Round(time.Millisecond/10))
+		return nil
+	}
+	fmt.Printf("%s with %d answers in %v\n", res.RCode, len(res.Answers), res.Duration.Round(time.Millisecond/10))
+	for _, a := range res.Answers {
+		fmt.Printf("  %s\n", a)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nextdns/nextdns/config"
+)
+
+// tuneSysctlFile is where the applied kernel settings are persisted so they
+// survive reboots.
+const tuneSysctlFile = "/etc/sysctl.d/90-nextdns.conf"
+
+// tuneRecommendation is a setting to change for high query rates.
+type tuneRecommendation struct {
+	// Kind is either sysctl for a kernel setting or daemon for a setting of
+	// the daemon configuration.
+	Kind        string `json:"kind"`
+	Name        string `json:"name"`
+	Current     string `json:"current"`
+	Recommended string `json:"recommended"`
+	Reason      string `json:"reason"`
+}
+
+// tuneHost describes the resources of the host.
+type tuneHost struct {
+	CPUs int `json:"cpus"`
+	// MemoryMB is the total memory of the host, or zero if unknown.
+	MemoryMB int `json:"memory_mb"`
+}
+
+// tune inspects the host and prints, or applies, the kernel and daemon
+// settings recommended for high query rates.
+func tune(args []string) error {
+	fs := flag.NewFlagSet("nextdns tune", flag.ExitOnError)
+	apply := fs.Bool("apply", false, "Apply the recommended settings. Kernel settings are persisted in "+tuneSysctlFile+".")
+	_ = fs.Parse(args[1:])
+
+	var c config.Config
+	c.Parse("nextdns tune", nil, true)
+	h := tuneHost{CPUs: runtime.NumCPU(), MemoryMB: hostMemoryMB()}
+	recs := tuneRecommendations(h, readSysctl, c)
+
+	if !*apply {
+		if jsonOutput {
+			if recs == nil {
+				recs = []tuneRecommendation{}
+			}
+			return json.NewEncoder(os.Stdout).Encode(struct {
+				Host            tuneHost             `json:"host"`
+				Recommendations []tuneRecommendation `json:"recommendations"`
+			}{h, recs})
+		}
+		mem := "unknown memory"
+		if h.MemoryMB > 0 {
+			mem = fmt.Sprintf("%d MB of memory", h.MemoryMB)
+		}
+		fmt.Printf("Host: %d CPUs, %s\n", h.CPUs, mem)
+		if len(recs) == 0 {
+			fmt.Println("No changes recommended.")
+			return nil
+		}
+		fmt.Printf("\n%-38s %-10s %-12s %s\n", "SETTING", "CURRENT", "RECOMMENDED", "REASON")
+		for _, r := range recs {
+			fmt.Printf("%-38s %-10s %-12s %s\n", r.Kind+" "+r.Name, r.Current, r.Recommended, r.Reason)
+		}
+		fmt.Println("\nRun \"nextdns tune -apply\" to apply them.")
+		return nil
+	}
+
+	var changes []string
+	var daemonArgs []string
+	sysctls := map[string]string{}
+	for _, r := range recs {
+		switch r.Kind {
+		case "sysctl":
+			sysctls[r.Name] = r.Recommended
+			changes = append(changes, fmt.Sprintf("set sysctl %s=%s", r.Name, r.Recommended))
+		case "daemon":
+			daemonArgs = append(daemonArgs, "-"+r.Name+"="+r.Recommended)
+		}
+	}
+	if len(daemonArgs) > 0 {
+		c.Parse("nextdns tune", daemonArgs, true)
+		daemonChanges, err := configChanges(c)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, daemonChanges...)
+	}
+	if checkMode || len(changes) == 0 {
+		return reportChanges(changes)
+	}
+	if len(sysctls) > 0 {
+		for name, value := range sysctls {
+			if err := writeSysctl(name, value); err != nil {
+				return withCode(exitSystem, fmt.Errorf("sysctl %s: %v", name, err))
+			}
+		}
+		if err := persistSysctls(tuneSysctlFile, sysctls); err != nil {
+			return withCode(exitSystem, err)
+		}
+	}
+	if len(daemonArgs) > 0 {
+		if err := c.Save(); err != nil {
+			return withCode(exitConfig, err)
+		}
+		if err := reloadService(); err != nil {
+			return withCode(exitSystem, err)
+		}
+	}
+	if !jsonOutput {
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		return nil
+	}
+	return reportChanges(changes)
+}
+
+// tuneRecommendations returns the recommended settings for the host h, with
+// sysctl returning the current value of a kernel setting if present, and c
+// the daemon configuration.
+func tuneRecommendations(h tuneHost, sysctl func(name string) (int64, bool), c config.Config) []tuneRecommendation {
+	var recs []tuneRecommendation
+	atLeast := func(name string, min int64, reason string) {
+		if v, ok := sysctl(name); ok && v < min {
+			recs = append(recs, tuneRecommendation{
+				Kind:        "sysctl",
+				Name:        name,
+				Current:     strconv.FormatInt(v, 10),
+				Recommended: strconv.FormatInt(min, 10),
+				Reason:      reason,
+			})
+		}
+	}
+	atLeast("net.core.rmem_max", 4<<20, "UDP receive buffers absorbing query bursts")
+	atLeast("net.core.wmem_max", 4<<20, "UDP send buffers absorbing response bursts")
+	atLeast("net.core.somaxconn", 1024, "backlog of the TCP listeners")
+	atLeast("net.core.netdev_max_backlog", 5000, "packets queued by the NICs before being processed")
+	if h.MemoryMB > 0 {
+		// Each UDP query tracked by conntrack costs about 300 bytes, allow
+		// up to 2% of the memory.
+		entries := int64(h.MemoryMB) * 64
+		if entries < 65536 {
+			entries = 65536
+		} else if entries > 1<<20 {
+			entries = 1 << 20
+		}
+		atLeast("net.netfilter.nf_conntrack_max", entries, "connections tracked, each query being one")
+	}
+
+	daemon := func(name, current, recommended, reason string) {
+		recs = append(recs, tuneRecommendation{Kind: "daemon", Name: name, Current: current, Recommended: recommended, Reason: reason})
+	}
+	if c.Cache == "" && (h.MemoryMB == 0 || h.MemoryMB >= 64) {
+		daemon("cache", "(none)", "memory", "answers served without upstream round trips")
+	}
+	if !c.CoalesceQueries {
+		daemon("coalesce-queries", "false", "true", "identical queries in flight sent upstream once")
+	}
+	if h.MemoryMB > 0 && h.MemoryMB < 512 && c.MemoryLimit == 0 {
+		daemon("memory-limit", "0", strconv.Itoa(h.MemoryMB/4), "optional features shed before running out of memory")
+	}
+	if h.MemoryMB > 0 && h.MemoryMB < 256 {
+		if c.MaxInflight == 0 {
+			daemon("max-inflight", "0", "256", "memory used by queries in flight bounded when the upstream is slow")
+		}
+		if c.Cache == "memory" && c.CacheMaxMemory == 0 {
+			daemon("cache-max-memory", "0", strconv.Itoa(h.MemoryMB/16), "cache memory bounded by size rather than number of answers")
+		}
+	}
+	return recs
+}
+
+// readSysctl returns the value of the numeric kernel setting name, and false
+// if it does not exist on the host.
+func readSysctl(name string) (int64, bool) {
+	if runtime.GOOS != "linux" {
+		return 0, false
+	}
+	b, err := ioutil.ReadFile(sysctlPath(name))
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(fields[0], 10, 64)
+	return v, err == nil
+}
+
+// writeSysctl sets the kernel setting name to value.
+func writeSysctl(name, value string) error {
+	if runtime.GOOS != "linux" {
+		return errors.New("not supported on this platform")
+	}
+	return ioutil.WriteFile(sysctlPath(name), []byte(value+"\n"), 0644)
+}
+
+func sysctlPath(name string) string {
+	return filepath.Join("/proc/sys", strings.Replace(name, ".", "/", -1))
+}
+
+// persistSysctls merges the settings into the sysctl.d file, keeping the
+// other settings it holds.
+func persistSysctls(file string, settings map[string]string) error {
+	merged := map[string]string{}
+	if b, err := ioutil.ReadFile(file); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if idx := strings.IndexByte(line, '='); idx != -1 && !strings.HasPrefix(line, "#") {
+				merged[strings.TrimSpace(line[:idx])] = strings.TrimSpace(line[idx+1:])
+			}
+		}
+	}
+	for name, value := range settings {
+		merged[name] = value
+	}
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("# Written by nextdns tune.\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s = %s\n", name, merged[name])
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(b.String()), 0644)
+}
+
+// hostMemoryMB returns the total memory of the host in MB, or zero if
+// unknown.
+func hostMemoryMB() int {
+	if runtime.GOOS != "linux" {
+		return 0
+	}
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.Atoi(fields[1])
+			return kb / 1024
+		}
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nextdns/nextdns/config"
+)
+
+func Test_tuneRecommendations(t *testing.T) {
+	low := map[string]int64{
+		"net.core.rmem_max":              212992,
+		"net.core.wmem_max":              212992,
+		"net.core.somaxconn":             128,
+		"net.core.netdev_max_backlog":    1000,
+		"net.netfilter.nf_conntrack_max": 32768,
+	}
+	high := map[string]int64{
+		"net.core.rmem_max":              8 << 20,
+		"net.core.wmem_max":              8 << 20,
+		"net.core.somaxconn":             4096,
+		"net.core.netdev_max_backlog":    10000,
+		"net.netfilter.nf_conntrack_max": 1 << 20,
+	}
+	sysctl := func(m map[string]int64) func(string) (int64, bool) {
+		return func(name string) (int64, bool) {
+			v, ok := m[name]
+			return v, ok
+		}
+	}
+	tuned := config.Config{Cache: "memory", CoalesceQueries: true}
+	tests := []struct {
+		name   string
+		h      tuneHost
+		sysctl map[string]int64
+		c      config.Config
+		want   []string
+	}{
+		{"tuned", tuneHost{CPUs: 8, MemoryMB: 16384}, high, tuned, nil},
+		{"no sysctl", tuneHost{CPUs: 1}, nil, tuned, nil},
+		{"default kernel", tuneHost{CPUs: 4, MemoryMB: 4096}, low, tuned, []string{
+			"sysctl net.core.rmem_max=4194304",
+			"sysctl net.core.wmem_max=4194304",
+			"sysctl net.core.somaxconn=1024",
+			"sysctl net.core.netdev_max_backlog=5000",
+			"sysctl net.netfilter.nf_conntrack_max=262144",
+		}},
+		{"conntrack floor", tuneHost{CPUs: 1, MemoryMB: 512}, map[string]int64{"net.netfilter.nf_conntrack_max": 32768}, tuned, []string{
+			"sysctl net.netfilter.nf_conntrack_max=65536",
+		}},
+		{"conntrack cap", tuneHost{CPUs: 64, MemoryMB: 65536}, map[string]int64{"net.netfilter.nf_conntrack_max": 65536}, tuned, []string{
+			"sysctl net.netfilter.nf_conntrack_max=1048576",
+		}},
+		{"default daemon", tuneHost{CPUs: 4, MemoryMB: 4096}, high, config.Config{}, []string{
+			"daemon cache=memory",
+			"daemon coalesce-queries=true",
+		}},
+		{"small router", tuneHost{CPUs: 1, MemoryMB: 128}, nil, config.Config{Cache: "memory"}, []string{
+			"daemon coalesce-queries=true",
+			"daemon memory-limit=32",
+			"daemon max-inflight=256",
+			"daemon cache-max-memory=8",
+		}},
+		{"tiny router", tuneHost{CPUs: 1, MemoryMB: 32}, nil, config.Config{CoalesceQueries: true, MemoryLimit: 8, MaxInflight: 64}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range tuneRecommendations(tt.h, sysctl(tt.sysctl), tt.c) {
+				got = append(got, r.Kind+" "+r.Name+"="+r.Recommended)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tuneRecommendations() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_persistSysctls(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sysctl.d", "90-nextdns.conf")
+	if err := persistSysctls(file, map[string]string{"net.core.somaxconn": "1024", "net.core.rmem_max": "4194304"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := persistSysctls(file, map[string]string{"net.core.somaxconn": "2048"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Written by nextdns tune.\n" +
+		"net.core.rmem_max = 4194304\n" +
+		"net.core.somaxconn = 2048\n"
+	if string(b) != want {
+		t.Errorf("persistSysctls() wrote %q, want %q", b, want)
+	}
+}
@@ -0,0 +1,249 @@
+// Package tunnel implements heuristics detecting DNS tunneling: data
+// exfiltration or command and control channels encoded in DNS queries.
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// Actions taken on suspicious queries.
+const (
+	// ActionLog only reports suspicious queries.
+	ActionLog = "log"
+
+	// ActionRateLimit refuses suspicious queries above RateLimit per minute
+	// for a given client and domain.
+	ActionRateLimit = "rate-limit"
+
+	// ActionBlock refuses all suspicious queries.
+	ActionBlock = "block"
+)
+
+// typeNULL is the NULL record type, commonly used by tunneling tools.
+const typeNULL = dnsmessage.Type(10)
+
+// minEntropyLen is the minimum length of a sub-domain for its entropy to be
+// checked: entropy of short strings is not significant.
+const minEntropyLen = 20
+
+// maxTracked is the maximum number of client/domain pairs tracked per
+// minute.
+const maxTracked = 50000
+
+// Detection is reported the first time in a minute a client sends a
+// suspicious query for a domain.
+type Detection struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	Name   string    `json:"name"`
+	Type   string    `json:"type"`
+	Domain string    `json:"domain"`
+	Reason string    `json:"reason"`
+	Action string    `json:"action"`
+}
+
+func (d Detection) String() string {
+	return fmt.Sprintf("Possible DNS tunneling from %s to %s (%s): %s %s, action: %s",
+		d.Client, d.Domain, d.Reason, d.Type, d.Name, d.Action)
+}
+
+// Resolver checks queries for tunneling patterns before sending them to
+// Upstream. A zero threshold disables the corresponding heuristic.
+type Resolver struct {
+	// MaxLabelLength is the maximum length of a label.
+	MaxLabelLength int
+
+	// MaxEntropy is the maximum Shannon entropy (in bits per character) of
+	// the sub-domain part of names.
+	MaxEntropy float64
+
+	// MaxTXTRate is the maximum number of TXT and NULL queries per minute sent
+	// by a client for the same domain.
+	MaxTXTRate int
+
+	// Action is the action taken on suspicious queries. Default is ActionLog.
+	Action string
+
+	// RateLimit is the number of suspicious queries allowed per minute for a
+	// client and domain with ActionRateLimit.
+	RateLimit int
+
+	// OnDetect is called when a client starts sending suspicious queries for
+	// a domain.
+	OnDetect func(Detection)
+
+	// Upstream is the resolver used to resolve queries.
+	Upstream resolver.Resolver
+
+	mu     sync.Mutex
+	minute int64
+	counts map[string]*counter
+}
+
+type counter struct {
+	txt        int
+	suspicious int
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(q.Payload); err != nil {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	name := strings.ToLower(strings.TrimSuffix(q1.Name.String(), "."))
+	domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	sub := strings.TrimSuffix(strings.TrimSuffix(name, domain), ".")
+
+	reason := r.check(sub)
+	client := q.PeerIP.String()
+	now := time.Now()
+	refuse := false
+	var report bool
+	r.mu.Lock()
+	c := r.counter(now, client+"/"+domain)
+	if c != nil {
+		if q1.Type == dnsmessage.TypeTXT || q1.Type == typeNULL {
+			c.txt++
+			if reason == "" && r.MaxTXTRate > 0 && c.txt > r.MaxTXTRate {
+				reason = fmt.Sprintf("more than %d TXT/NULL queries per minute", r.MaxTXTRate)
+			}
+		}
+		if reason != "" {
+			c.suspicious++
+			report = c.suspicious == 1
+			switch r.Action {
+			case ActionBlock:
+				refuse = true
+			case ActionRateLimit:
+				refuse = c.suspicious > r.RateLimit
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	if report && r.OnDetect != nil {
+		action := r.Action
+		if action == "" {
+			action = ActionLog
+		}
+		typ := q.Type
+		if q1.Type == typeNULL {
+			typ = "NULL"
+		}
+		r.OnDetect(Detection{
+			Time:   now,
+			Client: client,
+			Name:   q1.Name.String(),
+			Type:   typ,
+			Domain: domain,
+			Reason: reason,
+			Action: action,
+		})
+	}
+	if refuse {
+		n, i, err := replyRefused(q, buf)
+		if r.Action == ActionRateLimit {
+			i.Source = "rate limited by tunnel detection"
+		} else {
+			i.Source = "blocked by tunnel detection"
+		}
+		return n, i, err
+	}
+	return r.Upstream.Resolve(ctx, q, buf)
+}
+
+// check returns the reason why the sub-domain sub looks like tunneled data or
+// an empty string.
+func (r *Resolver) check(sub string) string {
+	if r.MaxLabelLength > 0 {
+		for _, label := range strings.Split(sub, ".") {
+			if len(label) > r.MaxLabelLength {
+				return fmt.Sprintf("label longer than %d characters", r.MaxLabelLength)
+			}
+		}
+	}
+	if r.MaxEntropy > 0 {
+		s := strings.Replace(sub, ".", "", -1)
+		if len(s) >= minEntropyLen {
+			if e := entropy(s); e > r.MaxEntropy {
+				return fmt.Sprintf("sub-domain entropy %.2f > %.2f", e, r.MaxEntropy)
+			}
+		}
+	}
+	return ""
+}
+
+// counter returns the counter for key in the current minute or nil if too
+// many keys are tracked. Counters are reset every minute. Must be called with
+// r.mu held.
+func (r *Resolver) counter(now time.Time, key string) *counter {
+	if m := now.Unix() / 60; m != r.minute || r.counts == nil {
+		r.minute = m
+		r.counts = map[string]*counter{}
+	}
+	c := r.counts[key]
+	if c == nil {
+		if len(r.counts) >= maxTracked {
+			return nil
+		}
+		c = &counter{}
+		r.counts[key] = c
+	}
+	return c
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	var freq [256]int
+	for i := 0; i < len(s); i++ {
+		freq[s[i]]++
+	}
+	var e float64
+	l := float64(len(s))
+	for _, f := range freq {
+		if f == 0 {
+			continue
+		}
+		p := float64(f) / l
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+func replyRefused(q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(q.Payload)
+	if err != nil {
+		return 0, i, err
+	}
+	q1, err := p.Question()
+	if err != nil {
+		return 0, i, err
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = dnsmessage.RCodeRefused
+	b := dnsmessage.NewBuilder(buf[:0], h)
+	_ = b.StartQuestions()
+	_ = b.Question(q1)
+	buf, err = b.Finish()
+	return len(buf), i, err
+}
@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+type nopResolver struct{}
+
+func (nopResolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+	return 0, resolver.ResolveInfo{Transport: "upstream"}, nil
+}
+
+func query(t *testing.T, name string, typ dnsmessage.Type) resolver.Query {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolver.Query{Name: name, PeerIP: net.IPv4(192, 168, 0, 2), Payload: payload}
+}
+
+func TestResolver(t *testing.T) {
+	tests := []struct {
+		name       string
+		typ        dnsmessage.Type
+		count      int
+		wantDetect bool
+	}{
+		{"www.example.com.", dnsmessage.TypeA, 1, false},
+		{"a-long-but-legit-hostname.example.com.", dnsmessage.TypeA, 1, false},
+		{"0123456789abcdef0123456789abcdef0123456789abcdef0123456789ab.t.example.com.", dnsmessage.TypeA, 1, true},
+		{"mzxw6ytboi2xk4dfmfzwk3tfon2gk4tt.example.com.", dnsmessage.TypeA, 1, true},
+		{"a.example.com.", dnsmessage.TypeTXT, 10, false},
+		{"a.example.com.", dnsmessage.TypeTXT, 11, true},
+		{"a.example.com.", typeNULL, 11, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Detection
+			r := &Resolver{
+				MaxLabelLength: 50,
+				MaxEntropy:     4,
+				MaxTXTRate:     10,
+				Action:         ActionBlock,
+				OnDetect:       func(d Detection) { got = append(got, d) },
+				Upstream:       nopResolver{},
+			}
+			var i resolver.ResolveInfo
+			for n := 0; n < tt.count; n++ {
+				_, i, _ = r.Resolve(context.Background(), query(t, tt.name, tt.typ), make([]byte, 512))
+			}
+			if detected := len(got) > 0; detected != tt.wantDetect {
+				t.Errorf("detected = %v, want %v (%v)", detected, tt.wantDetect, got)
+			}
+			if blocked := i.Transport == ""; blocked != tt.wantDetect {
+				t.Errorf("blocked = %v, want %v", blocked, tt.wantDetect)
+			}
+			if len(got) > 1 {
+				t.Errorf("got %d detections, want 1", len(got))
+			}
+		})
+	}
+}
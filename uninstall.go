@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/host"
+	"github.com/nextdns/nextdns/host/service"
+	"github.com/nextdns/nextdns/i18n"
+	"github.com/nextdns/nextdns/router"
+)
+
+// verifyUninstall checks for and removes any residue left on the system by a
+// previous installation: service, firewall rules, DNS configuration, router
+// configuration and state files. It returns the list of residues that could
+// not be reverted.
+func verifyUninstall(s service.Service, c config.Config) (residue []string) {
+	check := func(what string, err error) {
+		if err != nil {
+			residue = append(residue, fmt.Sprintf("%s: %v", what, err))
+			i18n.Printf("  %-10s FAILED: %v\n", what, err)
+			return
+		}
+		i18n.Printf("  %-10s ok\n", what)
+	}
+
+	// Service
+	if st, _ := s.Status(); st != service.StatusNotInstalled {
+		_ = s.Stop()
+		_ = s.Uninstall()
+	}
+	var err error
+	if st, _ := s.Status(); st != service.StatusNotInstalled {
+		err = fmt.Errorf("%s service still installed", service.Name(s))
+	}
+	check("service", err)
+
+	// DNS
+	err = nil
+	if len(host.DNSResidue()) > 0 {
+		_ = host.ResetDNS()
+		if r := host.DNSResidue(); len(r) > 0 {
+			err = fmt.Errorf("not restored: %v", r)
+		}
+	}
+	check("dns", err)
+
+	// Firewall
+	check("firewall", host.ResetFirewall())
+
+	// Router
+	err = nil
+	if c.SetupRouter {
+		err = restoreRouter(router.New(), c)
+	}
+	check("router", err)
+
+	// State
+	err = nil
+	if c.DNSSECAnchorFile != "" {
+		if e := os.Remove(c.DNSSECAnchorFile); e != nil && !os.IsNotExist(e) {
+			err = e
+		}
+	}
+	if cs, ok := s.(interface{ RemoveConfig() error }); ok && err == nil {
+		err = cs.RemoveConfig()
+	}
+	check("state", err)
+
+	return residue
+}
+
+// restoreRouter restores the router configuration changed by a previous run.
+// The router is configured first with c so it restores the settings it has
+// actually applied (listen port, takeover mode, saved state).
+func restoreRouter(r router.Router, c config.Config) error {
+	if err := r.Configure(&c); err != nil {
+		return err
+	}
+	return r.Restore()
+}
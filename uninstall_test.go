@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nextdns/nextdns/config"
+)
+
+type fakeRouter struct {
+	port     string
+	restored string
+	err      error
+}
+
+func (r *fakeRouter) Configure(c *config.Config) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.port = "5342"
+	c.Listen = "127.0.0.1:" + r.port
+	return nil
+}
+
+func (r *fakeRouter) Setup() error {
+	return nil
+}
+
+func (r *fakeRouter) Restore() error {
+	// Like the real routers, Restore depends on the state set by Configure.
+	if r.port == "" {
+		return errors.New("not configured")
+	}
+	r.restored = r.port
+	return nil
+}
+
+func Test_restoreRouter(t *testing.T) {
+	c := config.Config{Listen: "localhost:53", SetupRouter: true}
+	r := &fakeRouter{}
+	if err := restoreRouter(r, c); err != nil {
+		t.Fatalf("restoreRouter() err = %v", err)
+	}
+	if r.restored != "5342" {
+		t.Errorf("restored port = %q, want 5342", r.restored)
+	}
+	if c.Listen != "localhost:53" {
+		t.Errorf("restoreRouter changed the config listen to %q", c.Listen)
+	}
+
+	r = &fakeRouter{err: errors.New("configure failed")}
+	if err := restoreRouter(r, c); err == nil {
+		t.Error("restoreRouter() err = nil, want configure error")
+	}
+	if r.restored != "" {
+		t.Error("restored despite a configure error")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"time"
+
+	"github.com/nextdns/nextdns/config"
+	"github.com/nextdns/nextdns/events"
+	"github.com/nextdns/nextdns/host"
+	"github.com/nextdns/nextdns/host/service"
+	"github.com/nextdns/nextdns/upgrade"
+)
+
+// autoUpgradeInterval is the interval between two release checks when
+// auto-upgrade is enabled.
+const autoUpgradeInterval = 24 * time.Hour
+
+func upgradeCmd(args []string) error {
+	args = args[1:]
+	if len(args) > 0 && args[0] == "sign" {
+		return upgradeSign(args[1:])
+	}
+	fs := flag.NewFlagSet("nextdns upgrade", flag.ExitOnError)
+	channel := fs.String("channel", upgrade.ChannelStable, "Release channel, stable or beta.")
+	key := fs.String("key", "", "Base64 encoded ed25519 public key releases are signed with.")
+	check := fs.Bool("check", false, "Only check if a new release is available.")
+	_ = fs.Parse(args)
+	if *key == "" && releaseKey == "" {
+		return withCode(exitUsage, errors.New("this build has no release key, set -key"))
+	}
+
+	u := newUpdater(*channel, *key)
+	r, err := u.Check(context.Background())
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		fmt.Printf("nextdns %s is up to date\n", version)
+		return nil
+	}
+	if *check {
+		fmt.Printf("nextdns %s is available (current %s)\n", r.Version, version)
+		return nil
+	}
+	if err := u.Upgrade(context.Background(), r); err != nil {
+		return err
+	}
+	fmt.Printf("nextdns upgraded from %s to %s\n", version, r.Version)
+	return withCode(exitSystem, restartService())
+}
+
+// upgradeSign writes the detached signature of a release archive next to it.
+func upgradeSign(args []string) error {
+	fs := flag.NewFlagSet("nextdns upgrade sign", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "Path to the file containing the base64 encoded private key.")
+	_ = fs.Parse(args)
+	if *keyFile == "" || fs.NArg() != 1 {
+		return withCode(exitUsage, errors.New("usage: upgrade sign -key-file FILE ARCHIVE"))
+	}
+	key, err := ioutil.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+	archive, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	sig, err := upgrade.Sign(archive, string(key))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fs.Arg(0)+upgrade.SignatureExt, []byte(sig+"\n"), 0644)
+}
+
+// checkUpgradeKey returns an error if auto-upgrade is enabled in c without a
+// key to verify the releases with, as when the binary was not built with the
+// key of the official releases.
+func checkUpgradeKey(c config.Config) error {
+	if c.AutoUpgrade && c.UpgradeKey == "" && releaseKey == "" {
+		return errors.New("auto-upgrade requires upgrade-key, this build has no release key")
+	}
+	return nil
+}
+
+func newUpdater(channel, key string) *upgrade.Updater {
+	if key == "" {
+		key = releaseKey
+	}
+	return &upgrade.Updater{
+		Channel: channel,
+		Key:     key,
+		Version: version,
+	}
+}
+
+// restartService restarts the service if it is running.
+func restartService() error {
+	s, err := host.NewService(service.Config{Name: "nextdns"})
+	if err != nil {
+		return err
+	}
+	if st, err := s.Status(); err != nil || st != service.StatusRunning {
+		return err
+	}
+	return s.Restart()
+}
+
+// setupAutoUpgrade checks for new releases at startup and every
+// autoUpgradeInterval, and restarts the service on the new binary once
+// upgraded.
+func setupAutoUpgrade(p *proxySvc, c config.Config) {
+	u := newUpdater(c.UpgradeChannel, c.UpgradeKey)
+	p.OnInit = append(p.OnInit, func(ctx context.Context) {
+		// Spread the checks of a fleet of devices started at the same time.
+		delay := time.Minute + time.Duration(rand.Int63n(int64(time.Hour)))
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = autoUpgradeInterval
+			r, err := u.Check(ctx)
+			if err == nil && r != nil {
+				p.log.Infof("Upgrading from %s to %s", version, r.Version)
+				if err = u.Upgrade(ctx, r); err == nil {
+					p.events.Emit(events.ServiceUpgraded, events.Data{"from": version, "to": r.Version})
+					if service.CurrentRunMode() != service.RunModeService {
+						p.log.Warning("Not running as a service, restart nextdns to use the new version")
+						return
+					}
+					if err = restartService(); err == nil {
+						return
+					}
+				}
+			}
+			if err != nil {
+				p.log.Errorf("Auto upgrade: %v", err)
+				p.events.Emit(events.Error, events.Data{"error": err.Error(), "op": "upgrade"})
+			}
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package upgrade
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// binaryName returns the name of the binary in the release archives.
+func binaryName(archiveURL string) string {
+	if strings.HasSuffix(archiveURL, ".zip") {
+		return "nextdns.exe"
+	}
+	return "nextdns"
+}
+
+// extract returns the content of the nextdns binary found in archive.
+func extract(archive []byte, archiveURL string) ([]byte, error) {
+	name := binaryName(archiveURL)
+	if strings.HasSuffix(archiveURL, ".zip") {
+		return extractZip(archive, name)
+	}
+	return extractTarGz(archive, name)
+}
+
+func extractTarGz(archive []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("archive: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("archive: %v", err)
+		}
+		if h.Typeflag == tar.TypeReg && path.Base(h.Name) == name {
+			return ioutil.ReadAll(io.LimitReader(tr, maxArchiveSize))
+		}
+	}
+	return nil, fmt.Errorf("archive: %s not found", name)
+}
+
+func extractZip(archive []byte, name string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("archive: %v", err)
+	}
+	for _, f := range zr.File {
+		if path.Base(f.Name) != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("archive: %v", err)
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(io.LimitReader(rc, maxArchiveSize))
+	}
+	return nil, fmt.Errorf("archive: %s not found", name)
+}
+
+// version is a MAJOR.MINOR.PATCH[-PRERELEASE] version.
+type version struct {
+	parts [3]int
+	pre   string
+}
+
+func parseVersion(s string) (version, error) {
+	var v version
+	s = strings.TrimPrefix(s, "v")
+	if idx := strings.IndexByte(s, '-'); idx != -1 {
+		s, v.pre = s[:idx], s[idx+1:]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, errors.New("invalid version")
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, errors.New("invalid version")
+		}
+		v.parts[i] = n
+	}
+	return v, nil
+}
+
+// less returns true if v is older than v2. A pre-release is older than the
+// release of the same version.
+func (v version) less(v2 version) bool {
+	for i := range v.parts {
+		if v.parts[i] != v2.parts[i] {
+			return v.parts[i] < v2.parts[i]
+		}
+	}
+	switch {
+	case v.pre == v2.pre:
+		return false
+	case v.pre == "":
+		return false
+	case v2.pre == "":
+		return true
+	}
+	return v.pre < v2.pre
+}
+
+func (v version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.parts[0], v.parts[1], v.parts[2])
+	if v.pre != "" {
+		s += "-" + v.pre
+	}
+	return s
+}
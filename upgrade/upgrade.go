@@ -0,0 +1,292 @@
+// Package upgrade implements the upgrade of the nextdns binary to the latest
+// release of a channel. Release archives must come with a detached ed25519
+// signature so a compromised download location cannot push a binary.
+package upgrade
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// DefaultAPI is the default URL of the releases API.
+const DefaultAPI = "https://api.github.com/repos/nextdns/nextdns/releases"
+
+// Channels.
+const (
+	ChannelStable = "stable"
+	ChannelBeta   = "beta"
+)
+
+// SignatureExt is the extension of the detached signature files published
+// along with release archives.
+const SignatureExt = ".sig"
+
+// ErrInvalidSignature is returned when a release archive does not match its
+// signature or the release key is invalid.
+var ErrInvalidSignature = errors.New("invalid release signature")
+
+// maxArchiveSize is the maximum size of a downloaded release archive.
+const maxArchiveSize = 64 << 20
+
+// Release is a release available for the current platform.
+type Release struct {
+	Version      string
+	URL          string
+	SignatureURL string
+}
+
+// Updater upgrades the nextdns binary.
+type Updater struct {
+	// Channel is the release channel, stable or beta. Beta includes
+	// pre-releases.
+	Channel string
+
+	// Key is the base64 encoded ed25519 public key release archives are
+	// signed with.
+	Key string
+
+	// Version is the currently running version.
+	Version string
+
+	// Binary is the path of the binary to replace. If empty, the path of the
+	// running executable is used.
+	Binary string
+
+	// API is the URL of the releases API. If empty, DefaultAPI is used.
+	API string
+
+	// Client is the HTTP client used for all requests. If nil, a client with
+	// a 5 minutes timeout is used.
+	Client *http.Client
+}
+
+type apiRelease struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	Assets     []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// Check returns the latest release of the channel if newer than the current
+// version, or nil if the current version is up to date.
+func (u *Updater) Check(ctx context.Context) (*Release, error) {
+	current, err := parseVersion(u.Version)
+	if err != nil {
+		return nil, fmt.Errorf("cannot upgrade version %s", u.Version)
+	}
+	api := u.API
+	if api == "" {
+		api = DefaultAPI
+	}
+	var releases []apiRelease
+	switch u.Channel {
+	case ChannelStable, "":
+		var r apiRelease
+		if err := u.getJSON(ctx, api+"/latest", &r); err != nil {
+			return nil, err
+		}
+		releases = append(releases, r)
+	case ChannelBeta:
+		if err := u.getJSON(ctx, api, &releases); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s: invalid release channel", u.Channel)
+	}
+	var latest *apiRelease
+	var latestVersion version
+	for i, r := range releases {
+		if r.Draft {
+			continue
+		}
+		v, err := parseVersion(r.TagName)
+		if err != nil {
+			continue
+		}
+		if latest == nil || latestVersion.less(v) {
+			latest, latestVersion = &releases[i], v
+		}
+	}
+	if latest == nil || !current.less(latestVersion) {
+		return nil, nil
+	}
+	rel := &Release{Version: latestVersion.String()}
+	name := archiveName(rel.Version)
+	for _, a := range latest.Assets {
+		switch a.Name {
+		case name:
+			rel.URL = a.URL
+		case name + SignatureExt:
+			rel.SignatureURL = a.URL
+		}
+	}
+	if rel.URL == "" {
+		return nil, fmt.Errorf("%s: no release archive for %s/%s", rel.Version, runtime.GOOS, runtime.GOARCH)
+	}
+	if rel.SignatureURL == "" {
+		return nil, fmt.Errorf("%s: release is not signed", rel.Version)
+	}
+	return rel, nil
+}
+
+// archiveName returns the name of the release archive of version v for the
+// current platform.
+func archiveName(v string) string {
+	ext := ".tar.gz"
+	if runtime.GOOS == "windows" {
+		ext = ".zip"
+	}
+	return fmt.Sprintf("nextdns_%s_%s_%s%s", v, runtime.GOOS, runtime.GOARCH, ext)
+}
+
+// Upgrade downloads the release r, verifies its signature and atomically
+// replaces the binary with the one it contains.
+func (u *Updater) Upgrade(ctx context.Context, r *Release) error {
+	sig, err := u.get(ctx, r.SignatureURL, 1024)
+	if err != nil {
+		return fmt.Errorf("signature: %w", err)
+	}
+	archive, err := u.get(ctx, r.URL, maxArchiveSize)
+	if err != nil {
+		return fmt.Errorf("archive: %w", err)
+	}
+	if err := Verify(archive, string(sig), u.Key); err != nil {
+		return err
+	}
+	bin, err := extract(archive, r.URL)
+	if err != nil {
+		return err
+	}
+	path := u.Binary
+	if path == "" {
+		if path, err = os.Executable(); err != nil {
+			return err
+		}
+		if path, err = filepath.EvalSymlinks(path); err != nil {
+			return err
+		}
+	}
+	return install(bin, path)
+}
+
+// Sign returns the base64 encoded signature of archive made with the base64
+// encoded privateKey.
+func Sign(archive []byte, privateKey string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(privateKey))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return "", errors.New("invalid private key")
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(ed25519.PrivateKey(key), archive)), nil
+}
+
+// Verify checks the base64 encoded signature of archive against the base64
+// encoded publicKey.
+func Verify(archive []byte, signature, publicKey string) error {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: invalid release key", ErrInvalidSignature)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), archive, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// install atomically replaces the file at path with bin, keeping its mode.
+func install(bin []byte, path string) error {
+	mode := os.FileMode(0755)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp := path + ".new"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bin)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		// A running executable cannot be replaced on Windows but it can be
+		// renamed.
+		old := path + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(path, old); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (u *Updater) client() *http.Client {
+	if u.Client != nil {
+		return u.Client
+	}
+	return &http.Client{Timeout: 5 * time.Minute}
+}
+
+func (u *Updater) get(ctx context.Context, url string, max int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := u.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", res.StatusCode)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, errors.New("file too large")
+	}
+	return b, nil
+}
+
+func (u *Updater) getJSON(ctx context.Context, url string, v interface{}) error {
+	b, err := u.get(ctx, url, 1<<20)
+	if err != nil {
+		return fmt.Errorf("releases: %w", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("releases: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package upgrade
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nextdns/nextdns/config"
+)
+
+func Test_version_less(t *testing.T) {
+	tests := []struct {
+		v1, v2 string
+		want   bool
+	}{
+		{"1.2.3", "1.2.4", true},
+		{"v1.2.3", "1.10.0", true},
+		{"1.2.3", "1.2.3", false},
+		{"2.0.0", "1.9.9", false},
+		{"1.3.0-beta.1", "1.3.0", true},
+		{"1.3.0", "1.3.0-beta.1", false},
+		{"1.3.0-beta.1", "1.3.0-beta.2", true},
+	}
+	for _, tt := range tests {
+		v1, err := parseVersion(tt.v1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v2, err := parseVersion(tt.v2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := v1.less(v2); got != tt.want {
+			t.Errorf("%s < %s = %v, want %v", tt.v1, tt.v2, got, tt.want)
+		}
+	}
+	if _, err := parseVersion("dev"); err == nil {
+		t.Error("dev version parsed")
+	}
+}
+
+func tarGz(t *testing.T, name string, content []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestUpdater(t *testing.T) {
+	pub, priv, err := config.GenerateBundleKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tarGz(t, "nextdns", []byte("new binary"))
+	sig, err := Sign(archive, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := archiveName("1.3.0")
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/releases/latest":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"tag_name": "v1.3.0",
+				"assets": []map[string]string{
+					{"name": name, "browser_download_url": srv.URL + "/" + name},
+					{"name": name + SignatureExt, "browser_download_url": srv.URL + "/" + name + SignatureExt},
+				},
+			})
+		case "/" + name:
+			_, _ = w.Write(archive)
+		case "/" + name + SignatureExt:
+			_, _ = w.Write([]byte(sig))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "nextdns")
+	if err := ioutil.WriteFile(bin, []byte("old binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Updater{
+		Channel: ChannelStable,
+		Key:     pub,
+		Version: "1.3.0",
+		Binary:  bin,
+		API:     srv.URL + "/releases",
+	}
+	r, err := u.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil {
+		t.Fatalf("Check() = %v, want up to date", r)
+	}
+
+	u.Version = "1.2.0"
+	if r, err = u.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || r.Version != "1.3.0" {
+		t.Fatalf("Check() = %v, want 1.3.0", r)
+	}
+
+	// Wrong key.
+	otherPub, _, _ := config.GenerateBundleKey()
+	u.Key = otherPub
+	if err := u.Upgrade(context.Background(), r); err == nil {
+		t.Fatal("Upgrade() with invalid signature succeeded")
+	}
+	if b, _ := ioutil.ReadFile(bin); string(b) != "old binary" {
+		t.Fatalf("binary replaced despite invalid signature: %q", b)
+	}
+
+	u.Key = pub
+	if err := u.Upgrade(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(bin); string(b) != "new binary" {
+		t.Errorf("binary = %q, want %q", b, "new binary")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/slo"
+)
+
+// watchResult is the result of a single watch probe.
+type watchResult struct {
+	Time         time.Time     `json:"time"`
+	Protocol     string        `json:"protocol"`
+	Target       string        `json:"target"`
+	Duration     time.Duration `json:"duration"`
+	Error        string        `json:"error,omitempty"`
+	Availability float64       `json:"availability"`
+	P50          time.Duration `json:"p50"`
+	P95          time.Duration `json:"p95"`
+}
+
+// watch periodically queries a remote proxy and reports its availability
+// and latency.
+func watch(args []string) error {
+	fs := flag.NewFlagSet("nextdns watch", flag.ExitOnError)
+	target := fs.String("target", "", "Address of the DNS server to monitor (i.e. 192.168.1.1).")
+	protocols := fs.String("protocol", "do53", "Comma separated list of protocols to probe: do53, dot.")
+	domain := fs.String("domain", "nextdns.io", "Domain name to query.")
+	interval := fs.Duration("interval", 10*time.Second, "Interval between probes.")
+	timeout := fs.Duration("timeout", 2*time.Second, "Maximum duration of a probe before considering it failed.")
+	window := fs.Duration("window", time.Hour, "Window over which availability and latency percentiles are computed.")
+	insecure := fs.Bool("insecure", false, "Do not verify the DoT server certificate.")
+	jsonOut := fs.Bool("json", false, "Output results as newline delimited JSON.")
+	_ = fs.Parse(args[1:])
+	if *target == "" {
+		fs.Usage()
+		return errors.New("missing target")
+	}
+	host, port, err := net.SplitHostPort(*target)
+	if err != nil {
+		host, port = *target, ""
+	}
+
+	type probe struct {
+		proto string
+		addr  string
+		mon   *slo.Monitor
+	}
+	var probes []probe
+	for _, proto := range strings.Split(*protocols, ",") {
+		proto = strings.TrimSpace(proto)
+		p := probe{proto: proto, mon: &slo.Monitor{Window: *window}}
+		switch proto {
+		case "do53":
+			if port == "" {
+				port = "53"
+			}
+			p.addr = net.JoinHostPort(host, port)
+		case "dot":
+			p.addr = net.JoinHostPort(host, "853")
+		default:
+			return fmt.Errorf("%s: unsupported protocol", proto)
+		}
+		probes = append(probes, p)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: *insecure}
+	for {
+		for _, p := range probes {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			start := time.Now()
+			err := watchProbe(ctx, p.proto, p.addr, *domain, tlsConfig)
+			cancel()
+			r := watchResult{
+				Time:     start,
+				Protocol: p.proto,
+				Target:   p.addr,
+				Duration: time.Since(start),
+			}
+			s := slo.Sample{Time: start, Name: *domain, Duration: r.Duration}
+			if err != nil {
+				r.Error = err.Error()
+				s.Error = r.Error
+			}
+			p.mon.Record(s)
+			st := p.mon.Stats(time.Now())
+			r.Availability = (1 - st.ErrorRate) * 100
+			r.P50, r.P95 = st.P50, st.P95
+			if *jsonOut {
+				_ = enc.Encode(r)
+				continue
+			}
+			status := "ok"
+			if err != nil {
+				status = "FAILED: " + r.Error
+			}
+			fmt.Printf("%s %-4s %s %s %dms (availability=%.2f%% p50=%dms p95=%dms)\n",
+				r.Time.Format(time.RFC3339), r.Protocol, r.Target, status,
+				r.Duration/time.Millisecond, r.Availability,
+				r.P50/time.Millisecond, r.P95/time.Millisecond)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// watchProbe sends an A query for domain to addr using proto and checks the
+// response.
+func watchProbe(ctx context.Context, proto, addr, domain string, tlsConfig *tls.Config) error {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(domain, ".") + ".")
+	if err != nil {
+		return err
+	}
+	id := uint16(rand.Int())
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{ID: id, RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	q, err := b.Finish()
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	var c net.Conn
+	switch proto {
+	case "dot":
+		c, err = d.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			c = tls.Client(c, tlsConfig)
+		}
+	default:
+		c, err = d.DialContext(ctx, "udp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.SetDeadline(deadline)
+	}
+
+	buf := make([]byte, 65535)
+	var n int
+	if proto == "dot" {
+		msg := make([]byte, 2, len(q)+2)
+		binary.BigEndian.PutUint16(msg, uint16(len(q)))
+		if _, err = c.Write(append(msg, q...)); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(c, buf[:2]); err != nil {
+			return err
+		}
+		n = int(binary.BigEndian.Uint16(buf[:2]))
+		if _, err = io.ReadFull(c, buf[:n]); err != nil {
+			return err
+		}
+	} else {
+		if _, err = c.Write(q); err != nil {
+			return err
+		}
+		if n, err = c.Read(buf); err != nil {
+			return err
+		}
+	}
+
+	var p dnsmessage.Parser
+	h, err := p.Start(buf[:n])
+	if err != nil {
+		return err
+	}
+	if h.ID != id {
+		return errors.New("response ID mismatch")
+	}
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("rcode %s", h.RCode)
+	}
+	return nil
+}
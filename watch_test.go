@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// watchReply turns the query q into the response of the test servers: the
+// rcode is set to rcode and the ID is altered if badID is true.
+func watchReply(q []byte, rcode byte, badID bool) []byte {
+	q[2] |= 0x80 // QR
+	q[3] = q[3]&0xf0 | rcode
+	if badID {
+		q[0]++
+	}
+	return q
+}
+
+func watchUDPServer(t *testing.T, rcode byte, badID bool) string {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = c.WriteTo(watchReply(buf[:n], rcode, badID), addr)
+		}
+	}()
+	return c.LocalAddr().String()
+}
+
+func watchDoTServer(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 514)
+				if _, err := io.ReadFull(c, buf[:2]); err != nil {
+					return
+				}
+				n := int(binary.BigEndian.Uint16(buf))
+				if _, err := io.ReadFull(c, buf[2:2+n]); err != nil {
+					return
+				}
+				watchReply(buf[2:2+n], 0, false)
+				_, _ = c.Write(buf[:2+n])
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func Test_watchProbe(t *testing.T) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	tests := []struct {
+		name    string
+		proto   string
+		addr    string
+		wantErr string
+	}{
+		{"do53", "do53", watchUDPServer(t, 0, false), ""},
+		{"do53 servfail", "do53", watchUDPServer(t, 2, false), "rcode RCodeServerFailure"},
+		{"do53 bad id", "do53", watchUDPServer(t, 0, true), "response ID mismatch"},
+		{"dot", "dot", watchDoTServer(t), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := watchProbe(ctx, tt.proto, tt.addr, "example.com", tlsConfig)
+			if got := errString(err); got != tt.wantErr {
+				t.Errorf("watchProbe() err = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_watchProbe_Timeout(t *testing.T) {
+	// A UDP socket never answering.
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := watchProbe(ctx, "do53", c.LocalAddr().String(), "example.com", nil); err == nil {
+		t.Error("watchProbe() err = nil, want a timeout")
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
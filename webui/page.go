@@ -0,0 +1,67 @@
+package webui
+
+// page is the single page of the UI. It uses the JSON API and the query
+// stream, so it has no dependency to serve.
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>NextDNS</title>
+<style>
+body{font-family:sans-serif;margin:0 auto;max-width:1100px;padding:1em;color:#222}
+h1{font-size:1.4em}h2{font-size:1.1em;margin-top:1.5em}
+table{border-collapse:collapse;width:100%;font-size:.9em}
+td,th{text-align:left;padding:.2em .5em;border-bottom:1px solid #eee}
+.grid{display:grid;grid-template-columns:repeat(auto-fit,minmax(300px,1fr));gap:1em}
+.err{color:#c00}#queries{max-height:400px;overflow:auto}
+textarea{width:100%;height:8em;font-family:monospace}
+</style>
+</head>
+<body>
+<h1>NextDNS</h1>
+<div class="grid">
+<div><h2>Status</h2><table id="status"></table></div>
+<div><h2>Top clients</h2><table id="clients"></table></div>
+<div><h2>Top domains</h2><table id="domains"></table></div>
+</div>
+<h2>Queries</h2>
+<div id="queries"><table><thead><tr><th>Time</th><th>Client</th><th>Name</th><th>Type</th><th>ms</th><th>Transport</th></tr></thead><tbody id="qbody"></tbody></table></div>
+<h2>Configuration</h2>
+<form id="config">
+<p>Forwarders (one per line, i.e. <code>corp.example.com=10.0.0.1</code>)</p>
+<textarea name="forwarders"></textarea>
+<p>Local records (one per line, i.e. <code>nas.lan=192.168.1.10</code>)</p>
+<textarea name="rewrites"></textarea>
+<p><button type="submit">Save and restart</button> <span id="msg"></span></p>
+</form>
+<script>
+function text(v){return document.createTextNode(v==null?"":String(v))}
+function row(cells,cls){var tr=document.createElement("tr");if(cls)tr.className=cls;cells.forEach(function(c){var td=document.createElement("td");td.appendChild(text(c));tr.appendChild(td)});return tr}
+function fill(id,rows){var t=document.getElementById(id);t.textContent="";rows.forEach(function(r){t.appendChild(row(r))})}
+function lines(v){return v.split("\n").map(function(l){return l.trim()}).filter(function(l){return l})}
+function refresh(){
+fetch("api/status").then(function(r){return r.json()}).then(function(s){
+var rows=[];Object.keys(s).forEach(function(k){if(k!="stats"&&k!="read_only")rows.push([k,s[k]])});
+Object.keys(s.stats).forEach(function(k){rows.push([k,s.stats[k]])});fill("status",rows);
+document.querySelector("#config button").disabled=s.read_only});
+fetch("api/top").then(function(r){return r.json()}).then(function(t){
+fill("clients",t.clients.map(function(c){return[c.key,c.count]}));
+fill("domains",t.domains.map(function(c){return[c.key,c.count]}))});
+}
+refresh();setInterval(refresh,5000);
+var qbody=document.getElementById("qbody");
+new EventSource("api/queries").onmessage=function(e){var q=JSON.parse(e.data);
+qbody.insertBefore(row([new Date(q.time).toLocaleTimeString(),q.client,q.name,q.type,q.duration_ms.toFixed(1),q.error||q.transport||"local"],q.error?"err":""),qbody.firstChild);
+while(qbody.children.length>200)qbody.removeChild(qbody.lastChild)};
+var form=document.getElementById("config"),msg=document.getElementById("msg");
+fetch("api/config").then(function(r){return r.json()}).then(function(c){
+form.forwarders.value=(c.forwarders||[]).join("\n");form.rewrites.value=(c.rewrites||[]).join("\n")});
+form.onsubmit=function(e){e.preventDefault();msg.textContent="Saving...";
+fetch("api/config",{method:"POST",headers:{"Content-Type":"application/json"},
+body:JSON.stringify({forwarders:lines(form.forwarders.value),rewrites:lines(form.rewrites.value)})})
+.then(function(r){return r.ok?"Saved":r.text()}).then(function(t){msg.textContent=t})};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,334 @@
+// Package webui serves a small web dashboard showing the live queries, top
+// clients and domains, cache and upstream statistics of the proxy, and
+// allowing basic configuration edits.
+package webui
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// recentQueries is the number of queries kept to be sent to new query
+// stream subscribers.
+const recentQueries = 100
+
+// maxTracked is the maximum number of clients or domains counted before the
+// counts are decayed.
+const maxTracked = 10000
+
+// topCount is the number of entries returned for top clients and domains.
+const topCount = 10
+
+// Query is a query shown in the live query stream.
+type Query struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Duration  float64   `json:"duration_ms"`
+	Transport string    `json:"transport,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Config holds the settings editable from the UI.
+type Config struct {
+	Forwarders []string `json:"forwarders"`
+	Rewrites   []string `json:"rewrites"`
+}
+
+// Stats are the query counters shown by the UI.
+type Stats struct {
+	Queries   uint64 `json:"queries"`
+	Errors    uint64 `json:"errors"`
+	Local     uint64 `json:"local"`
+	CacheHits uint64 `json:"cache_hits"`
+}
+
+// Count is the number of queries of a client or domain.
+type Count struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// Server serves the web UI on Addr.
+type Server struct {
+	// Addr specifies the TCP address to listen to.
+	Addr string
+
+	// Password specifies the password required with HTTP basic
+	// authentication (with any user name). It is required when Addr is not a
+	// loopback address or SaveConfig is set.
+	Password string
+
+	// Status specifies an optional function returning information added to
+	// the status shown by the UI (i.e. version, upstream endpoint).
+	Status func() map[string]interface{}
+
+	// Config returns the current editable settings.
+	Config func() Config
+
+	// SaveConfig specifies an optional function saving edited settings. The
+	// configuration is read-only if nil.
+	SaveConfig func(Config) error
+
+	mu      sync.Mutex
+	stats   Stats
+	recent  []Query
+	next    int
+	subs    map[chan Query]struct{}
+	clients map[string]uint64
+	domains map[string]uint64
+}
+
+// Validate checks the server is not exposed or editable without a password.
+func (s *Server) Validate() error {
+	if s.Password != "" {
+		return nil
+	}
+	if s.SaveConfig != nil {
+		return errors.New("a password is required to edit the configuration")
+	}
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%s: a password is required to listen on a non loopback address", s.Addr)
+	}
+	return nil
+}
+
+// Record adds q to the live query stream and statistics.
+func (s *Server) Record(q Query) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Queries++
+	switch {
+	case q.Error != "":
+		s.stats.Errors++
+	case q.Transport == "cache":
+		s.stats.CacheHits++
+	case q.Transport == "":
+		s.stats.Local++
+	}
+	if len(s.recent) < recentQueries {
+		s.recent = append(s.recent, q)
+	} else {
+		s.recent[s.next] = q
+		s.next = (s.next + 1) % recentQueries
+	}
+	if s.clients == nil {
+		s.clients = map[string]uint64{}
+		s.domains = map[string]uint64{}
+	}
+	count(s.clients, q.Client)
+	count(s.domains, q.Name)
+	for c := range s.subs {
+		select {
+		case c <- q:
+		default:
+			// Slow subscriber, drop the query.
+		}
+	}
+}
+
+// Reset forgets the recent queries and the top clients and domains.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	s.recent, s.next = nil, 0
+	s.clients, s.domains = nil, nil
+	s.mu.Unlock()
+}
+
+// count increments the count of key in m, halving all the counts to make
+// room when m is full so recent activity wins over old entries.
+func count(m map[string]uint64, key string) {
+	if _, found := m[key]; !found && len(m) >= maxTracked {
+		for k, v := range m {
+			if v /= 2; v == 0 {
+				delete(m, k)
+			} else {
+				m[k] = v
+			}
+		}
+	}
+	m[key]++
+}
+
+// top returns the topCount keys of m with the highest counts.
+func top(m map[string]uint64) []Count {
+	l := make([]Count, 0, len(m))
+	for k, v := range m {
+		l = append(l, Count{k, v})
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Count != l[j].Count {
+			return l[i].Count > l[j].Count
+		}
+		return l[i].Key < l[j].Key
+	})
+	if len(l) > topCount {
+		l = l[:topCount]
+	}
+	return l
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Password != "" {
+		_, pass, _ := r.BasicAuth()
+		if subtle.ConstantTimeCompare([]byte(pass), []byte(s.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="NextDNS"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	w.Header().Set("X-Frame-Options", "DENY")
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = io.WriteString(w, page)
+	case "/api/status":
+		s.serveStatus(w)
+	case "/api/top":
+		s.mu.Lock()
+		v := map[string][]Count{"clients": top(s.clients), "domains": top(s.domains)}
+		s.mu.Unlock()
+		writeJSON(w, v)
+	case "/api/queries":
+		s.serveQueries(w, r)
+	case "/api/config":
+		s.serveConfig(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveStatus(w http.ResponseWriter) {
+	v := map[string]interface{}{}
+	if s.Status != nil {
+		v = s.Status()
+	}
+	s.mu.Lock()
+	v["stats"] = s.stats
+	s.mu.Unlock()
+	v["read_only"] = s.SaveConfig == nil
+	writeJSON(w, v)
+}
+
+// serveQueries streams the queries as server-sent events, starting with the
+// recent ones.
+func (s *Server) serveQueries(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	c := make(chan Query, recentQueries)
+	s.mu.Lock()
+	for i := range s.recent {
+		c <- s.recent[(s.next+i)%len(s.recent)]
+	}
+	if s.subs == nil {
+		s.subs = map[chan Query]struct{}{}
+	}
+	s.subs[c] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subs, c)
+		s.mu.Unlock()
+	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case q := <-c:
+			b, _ := json.Marshal(q)
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+			f.Flush()
+		}
+	}
+}
+
+func (s *Server) serveConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var c Config
+		if s.Config != nil {
+			c = s.Config()
+		}
+		writeJSON(w, c)
+	case http.MethodPost:
+		if s.SaveConfig == nil {
+			http.Error(w, "Configuration is read-only", http.StatusForbidden)
+			return
+		}
+		// Requiring a JSON content type prevents cross-site form posts as
+		// browsers do not send it cross-origin without a preflight request.
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		var c Config
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.SaveConfig(c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// ListenAndServe serves the UI on Addr until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:     s,
+		ReadTimeout: 10 * time.Second,
+		// No write timeout so the query stream can stay open.
+		IdleTimeout: time.Minute,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	if err = srv.Serve(l); err == http.ErrServerClosed {
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServer_Validate(t *testing.T) {
+	save := func(Config) error { return nil }
+	tests := []struct {
+		name    string
+		s       *Server
+		wantErr bool
+	}{
+		{"Localhost", &Server{Addr: "localhost:8053"}, false},
+		{"Loopback", &Server{Addr: "127.0.0.1:8053"}, false},
+		{"Network", &Server{Addr: ":8053"}, true},
+		{"NetworkPassword", &Server{Addr: ":8053", Password: "secret"}, false},
+		{"Editable", &Server{Addr: "localhost:8053", SaveConfig: save}, true},
+		{"EditablePassword", &Server{Addr: "localhost:8053", Password: "secret", SaveConfig: save}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServer_Record(t *testing.T) {
+	s := &Server{}
+	for _, q := range []Query{
+		{Client: "10.0.0.1", Name: "a.com", Transport: "DOH"},
+		{Client: "10.0.0.1", Name: "b.com", Transport: "cache"},
+		{Client: "10.0.0.2", Name: "a.com"},
+		{Client: "10.0.0.1", Name: "a.com", Error: "timeout"},
+	} {
+		s.Record(q)
+	}
+	if want := (Stats{Queries: 4, Errors: 1, Local: 1, CacheHits: 1}); s.stats != want {
+		t.Errorf("stats = %+v, want %+v", s.stats, want)
+	}
+	if got, want := top(s.clients), []Count{{"10.0.0.1", 3}, {"10.0.0.2", 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("top clients = %v, want %v", got, want)
+	}
+	if got, want := top(s.domains), []Count{{"a.com", 3}, {"b.com", 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("top domains = %v, want %v", got, want)
+	}
+}
+
+func TestServer_ServeHTTP(t *testing.T) {
+	var saved Config
+	s := &Server{
+		Password:   "secret",
+		SaveConfig: func(c Config) error { saved = c; return nil },
+	}
+	tests := []struct {
+		name        string
+		method      string
+		password    string
+		contentType string
+		want        int
+	}{
+		{"NoAuth", "GET", "", "", http.StatusUnauthorized},
+		{"BadAuth", "GET", "wrong", "", http.StatusUnauthorized},
+		{"Get", "GET", "secret", "", http.StatusOK},
+		{"PostForm", "POST", "secret", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"Post", "POST", "secret", "application/json", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/config", strings.NewReader(`{"forwarders":["lan=10.0.0.1"]}`))
+			if tt.password != "" {
+				req.SetBasicAuth("admin", tt.password)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+	if len(saved.Forwarders) != 1 || saved.Forwarders[0] != "lan=10.0.0.1" {
+		t.Errorf("saved = %+v", saved)
+	}
+}
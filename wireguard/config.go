@@ -0,0 +1,165 @@
+package wireguard
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config is the configuration of a tunnel with a single peer.
+type Config struct {
+	// PrivateKey is the private key of the interface.
+	PrivateKey [32]byte
+
+	// Addresses are the addresses of the interface in the tunnel, used as
+	// the source of the queries.
+	Addresses []net.IP
+
+	// MTU is the MTU of the interface, 1420 if zero.
+	MTU int
+
+	// PeerPublicKey is the public key of the peer.
+	PeerPublicKey [32]byte
+
+	// PresharedKey is the optional pre-shared key of the peer.
+	PresharedKey [32]byte
+
+	// Endpoint is the IP:PORT address of the peer.
+	Endpoint *net.UDPAddr
+}
+
+// LoadConfig reads the wg-quick configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// ParseConfig parses a configuration in the wg-quick format, with an
+// [Interface] section and a single [Peer] section:
+//
+//	[Interface]
+//	PrivateKey = BASE64
+//	Address = 10.64.0.2/32, fd00::2/128
+//	MTU = 1420
+//
+//	[Peer]
+//	PublicKey = BASE64
+//	PresharedKey = BASE64
+//	Endpoint = 203.0.113.1:51820
+//
+// The endpoint must be an IP address as resolving its name could require the
+// tunnel. Other keys (i.e. DNS or AllowedIPs) are ignored.
+func ParseConfig(r io.Reader) (*Config, error) {
+	c := &Config{}
+	var section string
+	var peers int
+	var hasKey, hasPeerKey bool
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		l := s.Text()
+		if idx := strings.IndexByte(l, '#'); idx != -1 {
+			l = l[:idx]
+		}
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		if strings.HasPrefix(l, "[") && strings.HasSuffix(l, "]") {
+			section = strings.ToLower(strings.TrimSpace(l[1 : len(l)-1]))
+			if section == "peer" {
+				if peers++; peers > 1 {
+					return nil, fmt.Errorf("line %d: only one peer is supported", line)
+				}
+			}
+			continue
+		}
+		idx := strings.IndexByte(l, '=')
+		if idx == -1 {
+			return nil, fmt.Errorf("line %d: expected KEY = VALUE", line)
+		}
+		k, v := strings.ToLower(strings.TrimSpace(l[:idx])), strings.TrimSpace(l[idx+1:])
+		var err error
+		switch section + "." + k {
+		case "interface.privatekey":
+			err = parseKey(&c.PrivateKey, v)
+			hasKey = true
+		case "interface.address":
+			for _, a := range strings.Split(v, ",") {
+				a = strings.TrimSpace(a)
+				ip, _, err2 := net.ParseCIDR(a)
+				if err2 != nil {
+					ip = net.ParseIP(a)
+				}
+				if ip == nil {
+					err = fmt.Errorf("%s: invalid address", a)
+					break
+				}
+				if ip4 := ip.To4(); ip4 != nil {
+					ip = ip4
+				}
+				c.Addresses = append(c.Addresses, ip)
+			}
+		case "interface.mtu":
+			c.MTU, err = strconv.Atoi(v)
+			if err != nil || c.MTU < 1280 || c.MTU > 65535 {
+				err = fmt.Errorf("%s: invalid MTU", v)
+			}
+		case "peer.publickey":
+			err = parseKey(&c.PeerPublicKey, v)
+			hasPeerKey = true
+		case "peer.presharedkey":
+			err = parseKey(&c.PresharedKey, v)
+		case "peer.endpoint":
+			host, port, err2 := net.SplitHostPort(v)
+			if err2 != nil {
+				err = err2
+				break
+			}
+			if net.ParseIP(host) == nil {
+				err = fmt.Errorf("%s: endpoint must be an IP address", v)
+				break
+			}
+			c.Endpoint, err = net.ResolveUDPAddr("udp", net.JoinHostPort(host, port))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	switch {
+	case !hasKey:
+		return nil, errors.New("missing interface private key")
+	case len(c.Addresses) == 0:
+		return nil, errors.New("missing interface address")
+	case !hasPeerKey:
+		return nil, errors.New("missing peer public key")
+	case c.Endpoint == nil:
+		return nil, errors.New("missing peer endpoint")
+	}
+	return c, nil
+}
+
+func parseKey(key *[32]byte, s string) error {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != len(key) {
+		return fmt.Errorf("%s: invalid key", s)
+	}
+	copy(key[:], b)
+	return nil
+}
@@ -0,0 +1,106 @@
+package wireguard
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testKey1 = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	testKey2 = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    string
+		want    string
+		wantErr string
+	}{
+		{"valid", `
+# Home
+[Interface]
+PrivateKey = ` + testKey1 + `
+Address = 10.64.0.2/32, fd00::2/128
+DNS = 10.64.0.1
+MTU = 1420
+
+[Peer]
+PublicKey = ` + testKey2 + `
+AllowedIPs = 0.0.0.0/0, ::/0
+Endpoint = 203.0.113.1:51820
+PersistentKeepalive = 25
+`, "[10.64.0.2 fd00::2] 203.0.113.1:51820", ""},
+		{"IPv6 endpoint", `
+[Interface]
+PrivateKey = ` + testKey1 + `
+Address = 10.64.0.2
+[Peer]
+PublicKey = ` + testKey2 + `
+Endpoint = [2001:db8::1]:51820
+`, "[10.64.0.2] [2001:db8::1]:51820", ""},
+		{"endpoint name", `
+[Interface]
+PrivateKey = ` + testKey1 + `
+Address = 10.64.0.2
+[Peer]
+PublicKey = ` + testKey2 + `
+Endpoint = vpn.example.com:51820
+`, "", "endpoint must be an IP address"},
+		{"invalid key", `
+[Interface]
+PrivateKey = abcd
+`, "", "line 3: abcd: invalid key"},
+		{"invalid MTU", `
+[Interface]
+PrivateKey = ` + testKey1 + `
+MTU = 576
+`, "", "line 4: 576: invalid MTU"},
+		{"missing address", `
+[Interface]
+PrivateKey = ` + testKey1 + `
+[Peer]
+PublicKey = ` + testKey2 + `
+Endpoint = 203.0.113.1:51820
+`, "", "missing interface address"},
+		{"missing peer", `
+[Interface]
+PrivateKey = ` + testKey1 + `
+Address = 10.64.0.2
+`, "", "missing peer public key"},
+		{"two peers", `
+[Peer]
+[Peer]
+`, "", "line 3: only one peer is supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseConfig(strings.NewReader(tt.conf))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join([]string{fmtIPs(c), c.Endpoint.String()}, " "); got != tt.want {
+				t.Errorf("ParseConfig() = %q, want %q", got, tt.want)
+			}
+			tun, err := NewTunnel(c, nil)
+			if err != nil {
+				t.Fatalf("NewTunnel() error = %v", err)
+			}
+			tun.Close()
+		})
+	}
+}
+
+func fmtIPs(c *Config) string {
+	var s []string
+	for _, ip := range c.Addresses {
+		s = append(s, ip.String())
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
@@ -0,0 +1,49 @@
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// serverTimeout is the time a server is given to answer before the next one
+// is tried.
+const serverTimeout = 2 * time.Second
+
+// Resolver sends the queries over UDP to DNS servers reachable through
+// Tunnel, with the spoofing protections of resolver.DNS53. Truncated
+// responses are returned as is.
+type Resolver struct {
+	Tunnel *Tunnel
+
+	// Servers are the IP:PORT addresses of the DNS servers, the next one
+	// being used when a server did not answer within two seconds.
+	Servers []string
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	if len(r.Servers) > 1 {
+		// A failed attempt can partially write the response into buf, which
+		// can share its memory with the payload.
+		q.Payload = append([]byte(nil), q.Payload...)
+	}
+	d := resolver.DNS53{Dialer: r.Tunnel}
+	err = errors.New("no server")
+	for j, addr := range r.Servers {
+		sctx, cancel := ctx, context.CancelFunc(func() {})
+		if j < len(r.Servers)-1 {
+			sctx, cancel = context.WithTimeout(ctx, serverTimeout)
+		}
+		n, i, err = d.Exchange(sctx, q, buf, addr)
+		cancel()
+		i.Transport = "WireGuard"
+		i.Endpoint = addr
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	return n, i, err
+}
@@ -0,0 +1,91 @@
+package wireguard
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// defaultMTU is the MTU of the interface when not set in the configuration,
+// the one of wg-quick.
+const defaultMTU = 1420
+
+// Tunnel is a WireGuard interface with a single peer run in user space, with
+// its own network stack: the connections dialed through it are not visible
+// from the system.
+type Tunnel struct {
+	dev *device.Device
+	net *netstack.Net
+}
+
+// NewTunnel starts a tunnel to the peer of c. The handshake is made when the
+// first packet is sent. Errors of the tunnel are reported to errorLog if not
+// nil.
+func NewTunnel(c *Config, errorLog func(error)) (*Tunnel, error) {
+	var addrs []netip.Addr
+	for _, ip := range c.Addresses {
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			return nil, fmt.Errorf("%s: invalid address", ip)
+		}
+		addrs = append(addrs, addr.Unmap())
+	}
+	mtu := c.MTU
+	if mtu == 0 {
+		mtu = defaultMTU
+	}
+	tdev, tnet, err := netstack.CreateNetTUN(addrs, nil, mtu)
+	if err != nil {
+		return nil, err
+	}
+	logger := &device.Logger{Verbosef: device.DiscardLogf, Errorf: device.DiscardLogf}
+	if errorLog != nil {
+		logger.Errorf = func(format string, args ...interface{}) {
+			errorLog(fmt.Errorf(format, args...))
+		}
+	}
+	dev := device.NewDevice(tdev, conn.NewDefaultBind(), logger)
+	if err := dev.IpcSet(c.uapi()); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	return &Tunnel{dev: dev, net: tnet}, nil
+}
+
+// DialContext dials address through the tunnel. Only IP addresses are
+// supported.
+func (t *Tunnel) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return t.net.DialContext(ctx, network, address)
+}
+
+// Close closes the tunnel.
+func (t *Tunnel) Close() error {
+	t.dev.Close()
+	return nil
+}
+
+// uapi returns c in the format of the configuration protocol of WireGuard,
+// all the traffic being routed to the peer.
+func (c *Config) uapi() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "private_key=%s\n", hex.EncodeToString(c.PrivateKey[:]))
+	fmt.Fprintf(&b, "public_key=%s\n", hex.EncodeToString(c.PeerPublicKey[:]))
+	if c.PresharedKey != [32]byte{} {
+		fmt.Fprintf(&b, "preshared_key=%s\n", hex.EncodeToString(c.PresharedKey[:]))
+	}
+	fmt.Fprintf(&b, "endpoint=%s\n", c.Endpoint)
+	b.WriteString("allowed_ip=0.0.0.0/0\n")
+	b.WriteString("allowed_ip=::/0\n")
+	return b.String()
+}
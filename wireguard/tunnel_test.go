@@ -0,0 +1,144 @@
+package wireguard
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+func newKey(t *testing.T) (priv, pub [32]byte) {
+	t.Helper()
+	if _, err := rand.Read(priv[:]); err != nil {
+		t.Fatal(err)
+	}
+	p, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(pub[:], p)
+	return priv, pub
+}
+
+// startPeer starts a WireGuard peer listening on the loopback with a DNS
+// server at 10.64.0.1:53 answering 192.0.2.1 to A queries. It returns the
+// port of the peer.
+func startPeer(t *testing.T, priv, clientPub [32]byte) int {
+	t.Helper()
+	tdev, tnet, err := netstack.CreateNetTUN([]netip.Addr{netip.MustParseAddr("10.64.0.1")}, nil, defaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := device.NewDevice(tdev, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+	t.Cleanup(dev.Close)
+	err = dev.IpcSet(fmt.Sprintf("private_key=%s\nlisten_port=0\npublic_key=%s\nallowed_ip=10.64.0.2/32\n",
+		hex.EncodeToString(priv[:]), hex.EncodeToString(clientPub[:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Up(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := tnet.ListenUDP(&net.UDPAddr{IP: net.ParseIP("10.64.0.1"), Port: 53})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var m dnsmessage.Message
+			if err := m.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			m.Header.Response = true
+			m.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: m.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+			}}
+			resp, err := m.Pack()
+			if err != nil {
+				continue
+			}
+			_, _ = c.WriteTo(resp, addr)
+		}
+	}()
+	conf, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range strings.Split(conf, "\n") {
+		if strings.HasPrefix(l, "listen_port=") {
+			var port int
+			_, _ = fmt.Sscan(strings.TrimPrefix(l, "listen_port="), &port)
+			return port
+		}
+	}
+	t.Fatal("no listen port")
+	return 0
+}
+
+func TestResolver(t *testing.T) {
+	peerPriv, peerPub := newKey(t)
+	priv, pub := newKey(t)
+	port := startPeer(t, peerPriv, pub)
+	tun, err := NewTunnel(&Config{
+		PrivateKey:    priv,
+		Addresses:     []net.IP{net.ParseIP("10.64.0.2").To4()},
+		PeerPublicKey: peerPub,
+		Endpoint:      &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+	}, func(err error) { t.Log(err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	_ = b.StartQuestions()
+	_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := resolver.NewQuery(payload, net.ParseIP("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first server is not answering, the query must be sent to the
+	// second one.
+	r := &Resolver{Tunnel: tun, Servers: []string{"10.64.0.3:53", "10.64.0.1:53"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	buf := make([]byte, 512)
+	n, i, err := r.Resolve(ctx, q, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Transport != "WireGuard" || i.Endpoint != "10.64.0.1:53" {
+		t.Errorf("ResolveInfo = %+v, want WireGuard from 10.64.0.1:53", i)
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Answers) != 1 || m.Answers[0].Body.(*dnsmessage.AResource).A != [4]byte{192, 0, 2, 1} {
+		t.Errorf("answers = %v, want 192.0.2.1", m.Answers)
+	}
+}
@@ -0,0 +1,451 @@
+package zone
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// parser reads a zone file in the RFC 1035 master file format.
+type parser struct {
+	s      *bufio.Scanner
+	line   int
+	origin string
+	ttl    uint32 // $TTL, or the last explicit TTL without $TTL
+	hasTTL bool
+	dollar bool // $TTL was found
+	owner  string // owner of the previous record
+}
+
+// Parse reads the records of a zone file from r. Relative names are completed
+// with origin until an $ORIGIN directive is found. The zone origin is the
+// owner of its SOA record.
+func Parse(r io.Reader, origin string) (*Zone, error) {
+	p := &parser{s: bufio.NewScanner(r)}
+	if origin != "" {
+		p.origin = fqdn(strings.ToLower(origin))
+	}
+	z := &Zone{rrs: map[string][]dnsmessage.Resource{}}
+	var rrs []dnsmessage.Resource
+	for {
+		fields, indented, err := p.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", p.line, err)
+		}
+		rr, err := p.record(fields, indented)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", p.line, err)
+		}
+		if rr.Body != nil {
+			rrs = append(rrs, rr)
+		}
+	}
+	for _, rr := range rrs {
+		if rr.Header.Type == dnsmessage.TypeSOA {
+			if z.origin != "" {
+				return nil, errors.New("multiple SOA records")
+			}
+			z.origin = rr.Header.Name.String()
+			z.soa = rr
+		}
+	}
+	if z.origin == "" {
+		return nil, errors.New("missing SOA record")
+	}
+	for _, rr := range rrs {
+		if err := z.add(rr); err != nil {
+			return nil, err
+		}
+	}
+	return z, nil
+}
+
+// next returns the fields of the next non empty logical line, joining lines
+// within parentheses, and whether it starts with a blank (no owner).
+func (p *parser) next() (fields []string, indented bool, err error) {
+	depth := 0
+	first := true
+	for p.s.Scan() {
+		p.line++
+		line := p.s.Text()
+		if first {
+			indented = len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+		}
+		fs, d, err := splitLine(line)
+		if err != nil {
+			return nil, false, err
+		}
+		depth += d
+		if depth < 0 {
+			return nil, false, errors.New("unbalanced parentheses")
+		}
+		fields = append(fields, fs...)
+		if depth > 0 {
+			first = len(fields) == 0 && first
+			continue
+		}
+		if len(fields) > 0 {
+			return fields, indented, nil
+		}
+	}
+	if err := p.s.Err(); err != nil {
+		return nil, false, err
+	}
+	if depth > 0 {
+		return nil, false, errors.New("unbalanced parentheses")
+	}
+	return nil, false, io.EOF
+}
+
+// splitLine splits line into fields, removing comments and parentheses, and
+// returns the change of parentheses depth. Quoted strings are returned with
+// their quotes.
+func splitLine(line string) (fields []string, depth int, err error) {
+	var cur strings.Builder
+	quoted := false
+	flush := func() {
+		if cur.Len() > 0 {
+			fields = append(fields, cur.String())
+			cur.Reset()
+		}
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			cur.WriteByte(c)
+			cur.WriteByte(line[i+1])
+			i++
+		case c == '"':
+			cur.WriteByte(c)
+			if quoted {
+				flush()
+			}
+			quoted = !quoted
+		case quoted:
+			cur.WriteByte(c)
+		case c == ';':
+			flush()
+			return fields, depth, nil
+		case c == '(' || c == ')':
+			flush()
+			if c == '(' {
+				depth++
+			} else {
+				depth--
+			}
+		case c == ' ' || c == '\t':
+			flush()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if quoted {
+		return nil, 0, errors.New("unterminated quoted string")
+	}
+	flush()
+	return fields, depth, nil
+}
+
+// record parses a directive or a record. Directives return an empty record.
+func (p *parser) record(fields []string, indented bool) (rr dnsmessage.Resource, err error) {
+	switch strings.ToUpper(fields[0]) {
+	case "$ORIGIN":
+		if len(fields) != 2 {
+			return rr, errors.New("$ORIGIN: expected a domain")
+		}
+		if p.origin, err = p.name(fields[1]); err != nil {
+			return rr, err
+		}
+		return rr, nil
+	case "$TTL":
+		if len(fields) != 2 {
+			return rr, errors.New("$TTL: expected a TTL")
+		}
+		if p.ttl, err = parseTTL(fields[1]); err != nil {
+			return rr, err
+		}
+		p.hasTTL, p.dollar = true, true
+		return rr, nil
+	case "$INCLUDE", "$GENERATE":
+		return rr, fmt.Errorf("%s: unsupported directive", fields[0])
+	}
+
+	if !indented {
+		if p.owner, err = p.name(fields[0]); err != nil {
+			return rr, err
+		}
+		fields = fields[1:]
+	} else if p.owner == "" {
+		return rr, errors.New("missing owner name")
+	}
+
+	// TTL and class are optional and can be in any order.
+	ttl, hasTTL := p.ttl, p.hasTTL
+	for len(fields) > 0 {
+		if strings.EqualFold(fields[0], "IN") {
+			fields = fields[1:]
+			continue
+		}
+		if t, err := parseTTL(fields[0]); err == nil {
+			ttl, hasTTL = t, true
+			if !p.dollar {
+				p.ttl, p.hasTTL = t, true
+			}
+			fields = fields[1:]
+			continue
+		}
+		break
+	}
+	if len(fields) == 0 {
+		return rr, errors.New("missing record type")
+	}
+	typ, data := strings.ToUpper(fields[0]), fields[1:]
+	name, err := dnsmessage.NewName(p.owner)
+	if err != nil {
+		return rr, fmt.Errorf("%s: %v", p.owner, err)
+	}
+	rr.Header = dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: ttl}
+	if rr.Body, err = p.body(typ, data); err != nil {
+		return rr, fmt.Errorf("%s %s: %v", p.owner, typ, err)
+	}
+	if !hasTTL {
+		soa, ok := rr.Body.(*dnsmessage.SOAResource)
+		if !ok {
+			return rr, errors.New("missing TTL: add a $TTL directive")
+		}
+		rr.Header.TTL = soa.MinTTL
+		p.ttl, p.hasTTL = soa.MinTTL, true
+	}
+	rr.Header.Type = typeOf(rr.Body)
+	return rr, nil
+}
+
+func (p *parser) body(typ string, data []string) (dnsmessage.ResourceBody, error) {
+	want := map[string]int{"A": 1, "AAAA": 1, "CNAME": 1, "NS": 1, "PTR": 1, "MX": 2, "SRV": 4, "SOA": 7}
+	if n, found := want[typ]; found && len(data) != n {
+		return nil, fmt.Errorf("expected %d fields, got %d", n, len(data))
+	}
+	switch typ {
+	case "A", "AAAA":
+		ip := net.ParseIP(data[0])
+		if ip4 := ip.To4(); typ == "A" && ip4 != nil {
+			var a [4]byte
+			copy(a[:], ip4)
+			return &dnsmessage.AResource{A: a}, nil
+		} else if typ == "AAAA" && ip != nil && ip4 == nil {
+			var aaaa [16]byte
+			copy(aaaa[:], ip)
+			return &dnsmessage.AAAAResource{AAAA: aaaa}, nil
+		}
+		return nil, fmt.Errorf("%s: invalid address", data[0])
+	case "CNAME", "NS", "PTR":
+		n, err := p.dnsName(data[0])
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case "CNAME":
+			return &dnsmessage.CNAMEResource{CNAME: n}, nil
+		case "NS":
+			return &dnsmessage.NSResource{NS: n}, nil
+		}
+		return &dnsmessage.PTRResource{PTR: n}, nil
+	case "MX":
+		pref, err := parseUint16(data[0])
+		if err != nil {
+			return nil, err
+		}
+		n, err := p.dnsName(data[1])
+		if err != nil {
+			return nil, err
+		}
+		return &dnsmessage.MXResource{Pref: pref, MX: n}, nil
+	case "SRV":
+		var v [3]uint16
+		for i := range v {
+			var err error
+			if v[i], err = parseUint16(data[i]); err != nil {
+				return nil, err
+			}
+		}
+		n, err := p.dnsName(data[3])
+		if err != nil {
+			return nil, err
+		}
+		return &dnsmessage.SRVResource{Priority: v[0], Weight: v[1], Port: v[2], Target: n}, nil
+	case "TXT":
+		if len(data) == 0 {
+			return nil, errors.New("missing text")
+		}
+		txt := make([]string, 0, len(data))
+		for _, d := range data {
+			txt = append(txt, unquote(d))
+		}
+		return &dnsmessage.TXTResource{TXT: txt}, nil
+	case "SOA":
+		ns, err := p.dnsName(data[0])
+		if err != nil {
+			return nil, err
+		}
+		mbox, err := p.dnsName(data[1])
+		if err != nil {
+			return nil, err
+		}
+		serial, err := strconv.ParseUint(data[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid serial", data[2])
+		}
+		var v [4]uint32
+		for i := range v {
+			if v[i], err = parseTTL(data[3+i]); err != nil {
+				return nil, err
+			}
+		}
+		return &dnsmessage.SOAResource{NS: ns, MBox: mbox, Serial: uint32(serial),
+			Refresh: v[0], Retry: v[1], Expire: v[2], MinTTL: v[3]}, nil
+	}
+	return nil, errors.New("unsupported record type")
+}
+
+// name returns the lower case absolute form of s, relative to the current
+// origin.
+func (p *parser) name(s string) (string, error) {
+	s = strings.ToLower(s)
+	if s == "@" {
+		if p.origin == "" {
+			return "", errors.New("@ used without origin")
+		}
+		return p.origin, nil
+	}
+	if strings.HasSuffix(s, ".") {
+		return s, nil
+	}
+	if p.origin == "" {
+		return "", fmt.Errorf("%s: relative name without origin", s)
+	}
+	if p.origin == "." {
+		return s + ".", nil
+	}
+	return s + "." + p.origin, nil
+}
+
+func (p *parser) dnsName(s string) (dnsmessage.Name, error) {
+	name, err := p.name(s)
+	if err != nil {
+		return dnsmessage.Name{}, err
+	}
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return dnsmessage.Name{}, fmt.Errorf("%s: %v", name, err)
+	}
+	return n, nil
+}
+
+// parseTTL parses a TTL in seconds, or with units (i.e. 1h30m, 1d or 1w).
+func parseTTL(s string) (uint32, error) {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, fmt.Errorf("%s: invalid TTL", s)
+	}
+	if v, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(v), nil
+	}
+	var total, cur uint64
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= '0' && c <= '9' {
+			cur = cur*10 + uint64(c-'0')
+			continue
+		}
+		var unit uint64
+		switch c | 0x20 {
+		case 's':
+			unit = 1
+		case 'm':
+			unit = 60
+		case 'h':
+			unit = 3600
+		case 'd':
+			unit = 86400
+		case 'w':
+			unit = 604800
+		default:
+			return 0, fmt.Errorf("%s: invalid TTL", s)
+		}
+		total += cur * unit
+		cur = 0
+		if total > 1<<31-1 {
+			return 0, fmt.Errorf("%s: invalid TTL", s)
+		}
+	}
+	if cur != 0 {
+		return 0, fmt.Errorf("%s: invalid TTL", s)
+	}
+	return uint32(total), nil
+}
+
+func parseUint16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid number", s)
+	}
+	return uint16(v), nil
+}
+
+// unquote removes the quotes and escapes of a character string.
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	if strings.IndexByte(s, '\\') == -1 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func typeOf(b dnsmessage.ResourceBody) dnsmessage.Type {
+	switch b.(type) {
+	case *dnsmessage.AResource:
+		return dnsmessage.TypeA
+	case *dnsmessage.AAAAResource:
+		return dnsmessage.TypeAAAA
+	case *dnsmessage.CNAMEResource:
+		return dnsmessage.TypeCNAME
+	case *dnsmessage.NSResource:
+		return dnsmessage.TypeNS
+	case *dnsmessage.PTRResource:
+		return dnsmessage.TypePTR
+	case *dnsmessage.MXResource:
+		return dnsmessage.TypeMX
+	case *dnsmessage.SRVResource:
+		return dnsmessage.TypeSRV
+	case *dnsmessage.TXTResource:
+		return dnsmessage.TypeTXT
+	case *dnsmessage.SOAResource:
+		return dnsmessage.TypeSOA
+	}
+	return 0
+}
+
+func fqdn(s string) string {
+	if !strings.HasSuffix(s, ".") {
+		s += "."
+	}
+	return s
+}
@@ -0,0 +1,200 @@
+package zone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+// checkInterval is the interval at which Resolver checks whether the zone
+// files were modified.
+const checkInterval = 30 * time.Second
+
+// maxCNAMEChain is the maximum number of CNAME records followed within the
+// zones.
+const maxCNAMEChain = 8
+
+// typeIXFR is the type of incremental zone transfer queries (RFC 1995).
+const typeIXFR dnsmessage.Type = 251
+
+// Resolver answers authoritatively the queries for the names of the zones of
+// Files and sends other queries to Upstream. Zone transfers are refused.
+type Resolver struct {
+	Files []*File
+
+	// Upstream resolves the queries out of the zones, and the targets of
+	// CNAME records pointing out of the zones.
+	Upstream resolver.Resolver
+
+	// ErrorLog is called with the errors reloading modified zone files.
+	ErrorLog func(error)
+}
+
+// Start reloads the zone files when they are modified until ctx is cancelled.
+func (r *Resolver) Start(ctx context.Context) {
+	t := time.NewTicker(checkInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			for _, f := range r.Files {
+				if err := f.Load(); err != nil && r.ErrorLog != nil {
+					r.ErrorLog(err)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// zone returns the zone with the longest origin containing name, or nil.
+func (r *Resolver) zone(name string) *Zone {
+	var best *Zone
+	for _, f := range r.Files {
+		z := f.Zone()
+		if z != nil && inZone(name, z.origin) && (best == nil || len(z.origin) > len(best.origin)) {
+			best = z
+		}
+	}
+	return best
+}
+
+// Resolve implements resolver.Resolver interface.
+func (r *Resolver) Resolve(ctx context.Context, q resolver.Query, buf []byte) (n int, i resolver.ResolveInfo, err error) {
+	name := fqdn(strings.ToLower(q.Name))
+	z := r.zone(name)
+	if z == nil {
+		return r.Upstream.Resolve(ctx, q, buf)
+	}
+	i.Source = "zone " + z.origin
+
+	var m dnsmessage.Message
+	if err = m.Unpack(q.Payload); err != nil {
+		return 0, i, err
+	}
+	if len(m.Questions) == 0 {
+		return 0, i, errors.New("zone: no question")
+	}
+	q1 := m.Questions[0]
+	m.Header.Response = true
+	m.Header.Authoritative = true
+	m.Header.RecursionAvailable = true
+	m.Header.RCode = dnsmessage.RCodeSuccess
+	m.Answers, m.Authorities = nil, nil
+	additionals := m.Additionals[:0]
+	for _, rr := range m.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			additionals = append(additionals, rr)
+		}
+	}
+	m.Additionals = additionals
+
+	if q1.Type == dnsmessage.TypeAXFR || q1.Type == typeIXFR {
+		m.Header.Authoritative = false
+		m.Header.RCode = dnsmessage.RCodeRefused
+		return pack(&m, buf, i)
+	}
+
+	target := name
+	for hops := 0; ; hops++ {
+		rrs, found := z.lookup(target)
+		if !found {
+			m.Header.RCode = dnsmessage.RCodeNameError
+			m.Authorities = []dnsmessage.Resource{z.negativeSOA()}
+			break
+		}
+		if cname := cnameOf(rrs); cname != nil && q1.Type != dnsmessage.TypeCNAME {
+			m.Answers = append(m.Answers, *cname)
+			target = strings.ToLower(cname.Body.(*dnsmessage.CNAMEResource).CNAME.String())
+			if hops >= maxCNAMEChain {
+				break
+			}
+			if z = r.zone(target); z == nil {
+				return r.resolveTarget(ctx, q, &m, target, buf, i)
+			}
+			continue
+		}
+		for _, rr := range rrs {
+			if q1.Type == dnsmessage.TypeALL || rr.Header.Type == q1.Type {
+				m.Answers = append(m.Answers, rr)
+			}
+		}
+		if len(m.Answers) == 0 {
+			m.Authorities = []dnsmessage.Resource{z.negativeSOA()}
+		}
+		break
+	}
+	return pack(&m, buf, i)
+}
+
+// resolveTarget completes the response m, ending with a CNAME to target out
+// of the zones, with the answers of Upstream for target.
+func (r *Resolver) resolveTarget(ctx context.Context, q resolver.Query, m *dnsmessage.Message, target string, buf []byte, i resolver.ResolveInfo) (int, resolver.ResolveInfo, error) {
+	var qm dnsmessage.Message
+	if err := qm.Unpack(q.Payload); err != nil {
+		return 0, i, err
+	}
+	tn, err := dnsmessage.NewName(target)
+	if err != nil {
+		return 0, i, fmt.Errorf("zone: %s: %v", target, err)
+	}
+	qm.Questions[0].Name = tn
+	payload, err := qm.Pack()
+	if err != nil {
+		return 0, i, err
+	}
+	tq := q
+	tq.Name = target
+	tq.Payload = payload
+	n, ui, err := r.Upstream.Resolve(ctx, tq, buf)
+	if err != nil || n <= 0 {
+		return n, ui, err
+	}
+	var um dnsmessage.Message
+	if err := um.Unpack(buf[:n]); err != nil {
+		return 0, ui, err
+	}
+	// The upstream part of the answer is not authoritative.
+	m.Header.Authoritative = false
+	m.Header.RCode = um.Header.RCode
+	m.Answers = append(m.Answers, um.Answers...)
+	m.Authorities = um.Authorities
+	ui.Source = i.Source
+	return pack(m, buf, ui)
+}
+
+// negativeSOA returns the SOA record sent with negative answers, with the
+// negative caching TTL (RFC 2308).
+func (z *Zone) negativeSOA() dnsmessage.Resource {
+	soa := z.soa
+	if min := soa.Body.(*dnsmessage.SOAResource).MinTTL; min < soa.Header.TTL {
+		soa.Header.TTL = min
+	}
+	return soa
+}
+
+func cnameOf(rrs []dnsmessage.Resource) *dnsmessage.Resource {
+	for i := range rrs {
+		if rrs[i].Header.Type == dnsmessage.TypeCNAME {
+			return &rrs[i]
+		}
+	}
+	return nil
+}
+
+func pack(m *dnsmessage.Message, buf []byte, i resolver.ResolveInfo) (int, resolver.ResolveInfo, error) {
+	b, err := m.AppendPack(buf[:0])
+	if err != nil {
+		return 0, i, err
+	}
+	if len(b) > len(buf) {
+		return 0, i, errors.New("zone: response too large")
+	}
+	return len(b), i, nil
+}
@@ -0,0 +1,152 @@
+// Package zone serves local authoritative zones loaded from RFC 1035 zone
+// files, so names of the LAN can be managed without running a separate
+// authoritative server.
+package zone
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+)
+
+// Zone holds the records of an authoritative zone.
+type Zone struct {
+	origin string
+	soa    dnsmessage.Resource
+	rrs    map[string][]dnsmessage.Resource
+}
+
+// Origin returns the name of the zone apex.
+func (z *Zone) Origin() string {
+	return z.origin
+}
+
+// add adds rr to the zone and registers the ancestors of its owner as empty
+// non-terminals.
+func (z *Zone) add(rr dnsmessage.Resource) error {
+	name := rr.Header.Name.String()
+	if !inZone(name, z.origin) {
+		return fmt.Errorf("%s: out of zone %s", name, z.origin)
+	}
+	for _, o := range z.rrs[name] {
+		if (o.Header.Type == dnsmessage.TypeCNAME) != (rr.Header.Type == dnsmessage.TypeCNAME) {
+			return fmt.Errorf("%s: CNAME and other data", name)
+		}
+		if rr.Header.Type == dnsmessage.TypeCNAME {
+			return fmt.Errorf("%s: multiple CNAME records", name)
+		}
+	}
+	z.rrs[name] = append(z.rrs[name], rr)
+	for n := parent(name); inZone(n, z.origin); n = parent(n) {
+		if _, found := z.rrs[n]; !found {
+			z.rrs[n] = nil
+		}
+		if n == z.origin {
+			break
+		}
+	}
+	return nil
+}
+
+// lookup returns the records of name, synthesized from a wildcard (RFC 4592)
+// if name does not exist, and false if name does not exist.
+func (z *Zone) lookup(name string) ([]dnsmessage.Resource, bool) {
+	if rrs, found := z.rrs[name]; found {
+		return rrs, true
+	}
+	// The wildcard of the closest encloser applies.
+	for n := parent(name); inZone(n, z.origin); n = parent(n) {
+		if _, found := z.rrs[n]; !found {
+			continue
+		}
+		wrrs, found := z.rrs["*."+n]
+		if !found {
+			return nil, false
+		}
+		qn, err := dnsmessage.NewName(name)
+		if err != nil {
+			return nil, false
+		}
+		rrs := make([]dnsmessage.Resource, 0, len(wrrs))
+		for _, rr := range wrrs {
+			rr.Header.Name = qn
+			rrs = append(rrs, rr)
+		}
+		return rrs, true
+	}
+	return nil, false
+}
+
+// inZone returns true if name is origin or one of its sub-domains.
+func inZone(name, origin string) bool {
+	return name == origin || origin == "." || strings.HasSuffix(name, "."+origin)
+}
+
+// parent returns name without its first label.
+func parent(name string) string {
+	if idx := strings.IndexByte(name, '.'); idx != -1 && idx+1 < len(name) {
+		return name[idx+1:]
+	}
+	return "."
+}
+
+// File is a zone loaded from a zone file.
+type File struct {
+	Path string
+
+	// Origin is the initial origin of relative names, if the file does not
+	// start with an $ORIGIN directive.
+	Origin string
+
+	mu      sync.RWMutex
+	zone    *Zone
+	modTime time.Time
+}
+
+// ParseFileSpec returns the File defined by spec, a path optionally prefixed
+// with the origin and an equal sign (i.e. home.example=/etc/home.zone).
+func ParseFileSpec(spec string) *File {
+	if idx := strings.IndexByte(spec, '='); idx != -1 && !strings.ContainsAny(spec[:idx], `/\`) {
+		return &File{Path: spec[idx+1:], Origin: spec[:idx]}
+	}
+	return &File{Path: spec}
+}
+
+// Load reads the file if it was modified since the last load. On error, the
+// previously loaded zone is kept.
+func (f *File) Load() error {
+	fi, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+	f.mu.RLock()
+	unchanged := fi.ModTime().Equal(f.modTime)
+	f.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	z, err := Parse(r, f.Origin)
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.Path, err)
+	}
+	f.mu.Lock()
+	f.zone, f.modTime = z, fi.ModTime()
+	f.mu.Unlock()
+	return nil
+}
+
+// Zone returns the last loaded zone, or nil.
+func (f *File) Zone() *Zone {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.zone
+}
@@ -0,0 +1,169 @@
+package zone
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/nextdns/nextdns/internal/dnsmessage"
+	"github.com/nextdns/nextdns/resolver"
+)
+
+const testZone = `$ORIGIN home.example.
+$TTL 1h
+@       IN SOA ns.home.example. admin.home.example. (
+                2024010101 ; serial
+                1d 2h 4w 300 )
+        IN NS  ns
+ns      IN A   192.168.1.1
+nas     300 IN A 192.168.1.10
+        IN AAAA fd00::10
+www     IN CNAME nas
+ext     IN CNAME www.example.com.
+*.lab   IN A   192.168.2.1
+txt     IN TXT "hello world" "v=1"
+srv._tcp.svc IN SRV 10 5 443 nas
+`
+
+func TestParse_errors(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+	}{
+		{"no soa", "$ORIGIN a.\n$TTL 60\nwww A 10.0.0.1\n"},
+		{"no ttl", "$ORIGIN a.\nwww A 10.0.0.1\n@ 60 SOA ns admin 1 1 1 1 1\n"},
+		{"out of zone", "$ORIGIN a.\n$TTL 60\n@ SOA ns admin 1 1 1 1 1\nwww.b. A 10.0.0.1\n"},
+		{"cname and other", "$ORIGIN a.\n$TTL 60\n@ SOA ns admin 1 1 1 1 1\nwww CNAME a.\nwww A 10.0.0.1\n"},
+		{"bad address", "$ORIGIN a.\n$TTL 60\n@ SOA ns admin 1 1 1 1 1\nwww A ::1\n"},
+		{"unbalanced", "$ORIGIN a.\n$TTL 60\n@ SOA ns admin ( 1 1 1 1 1\n"},
+		{"no origin", "$TTL 60\n@ SOA ns admin 1 1 1 1 1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.zone), ""); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestResolver(t *testing.T) {
+	z, err := Parse(strings.NewReader(testZone), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &File{zone: z}
+	var upstreamName string
+	r := &Resolver{
+		Files: []*File{f},
+		Upstream: resolver.ResolverFunc(func(ctx context.Context, q resolver.Query, buf []byte) (int, resolver.ResolveInfo, error) {
+			upstreamName = q.Name
+			var m dnsmessage.Message
+			if err := m.Unpack(q.Payload); err != nil {
+				return 0, resolver.ResolveInfo{}, err
+			}
+			m.Header.Response = true
+			m.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: m.Questions[0].Name, Class: dnsmessage.ClassINET, TTL: 10},
+				Body:   &dnsmessage.AResource{A: [4]byte{203, 0, 113, 1}},
+			}}
+			b, err := m.AppendPack(buf[:0])
+			return len(b), resolver.ResolveInfo{}, err
+		}),
+	}
+	tests := []struct {
+		name     string
+		qtype    dnsmessage.Type
+		want     string
+		upstream string
+	}{
+		{"nas.home.example.", dnsmessage.TypeA, "NOERROR aa nas.home.example./A/192.168.1.10/300", ""},
+		{"NAS.Home.Example.", dnsmessage.TypeAAAA, "NOERROR aa nas.home.example./AAAA/fd00::10/3600", ""},
+		{"nas.home.example.", dnsmessage.TypeMX, "NOERROR aa soa/300", ""},
+		{"missing.home.example.", dnsmessage.TypeA, "NXDOMAIN aa soa/300", ""},
+		{"svc.home.example.", dnsmessage.TypeA, "NOERROR aa soa/300", ""},
+		{"www.home.example.", dnsmessage.TypeA, "NOERROR aa www.home.example./CNAME/nas.home.example. nas.home.example./A/192.168.1.10/300", ""},
+		{"host.lab.home.example.", dnsmessage.TypeA, "NOERROR aa host.lab.home.example./A/192.168.2.1/3600", ""},
+		{"a.host.lab.home.example.", dnsmessage.TypeA, "NOERROR aa a.host.lab.home.example./A/192.168.2.1/3600", ""},
+		{"a.nas.home.example.", dnsmessage.TypeA, "NXDOMAIN aa soa/300", ""},
+		{"home.example.", dnsmessage.TypeNS, "NOERROR aa home.example./NS/ns.home.example.", ""},
+		{"txt.home.example.", dnsmessage.TypeTXT, "NOERROR aa txt.home.example./TXT/hello world|v=1", ""},
+		{"srv._tcp.svc.home.example.", dnsmessage.TypeSRV, "NOERROR aa srv._tcp.svc.home.example./SRV/443 nas.home.example.", ""},
+		{"ext.home.example.", dnsmessage.TypeA, "NOERROR ext.home.example./CNAME/www.example.com. www.example.com./A/203.0.113.1/10", "www.example.com."},
+		{"home.example.", dnsmessage.TypeAXFR, "REFUSED", ""},
+		{"other.example.", dnsmessage.TypeA, "NOERROR other.example./A/203.0.113.1/10", "other.example."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+tt.qtype.String(), func(t *testing.T) {
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+			_ = b.StartQuestions()
+			_ = b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(tt.name), Type: tt.qtype, Class: dnsmessage.ClassINET})
+			payload, err := b.Finish()
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := resolver.NewQuery(payload, net.ParseIP("192.168.1.2"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			upstreamName = ""
+			buf := make([]byte, 1232)
+			n, _, err := r.Resolve(context.Background(), q, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := summary(t, buf[:n]); got != tt.want {
+				t.Errorf("response = %q, want %q", got, tt.want)
+			}
+			if upstreamName != tt.upstream {
+				t.Errorf("upstream name = %q, want %q", upstreamName, tt.upstream)
+			}
+		})
+	}
+}
+
+func summary(t *testing.T, b []byte) string {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	s := []string{strings.TrimPrefix(m.Header.RCode.String(), "RCode")}
+	switch m.Header.RCode {
+	case dnsmessage.RCodeSuccess:
+		s[0] = "NOERROR"
+	case dnsmessage.RCodeNameError:
+		s[0] = "NXDOMAIN"
+	case dnsmessage.RCodeRefused:
+		s[0] = "REFUSED"
+	}
+	if m.Header.Authoritative {
+		s = append(s, "aa")
+	}
+	for _, rr := range m.Answers {
+		var v string
+		switch b := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			v = fmt.Sprintf("A/%s/%d", net.IP(b.A[:]), rr.Header.TTL)
+		case *dnsmessage.AAAAResource:
+			v = fmt.Sprintf("AAAA/%s/%d", net.IP(b.AAAA[:]), rr.Header.TTL)
+		case *dnsmessage.CNAMEResource:
+			v = "CNAME/" + b.CNAME.String()
+		case *dnsmessage.NSResource:
+			v = "NS/" + b.NS.String()
+		case *dnsmessage.TXTResource:
+			v = "TXT/" + strings.Join(b.TXT, "|")
+		case *dnsmessage.SRVResource:
+			v = fmt.Sprintf("SRV/%d %s", b.Port, b.Target)
+		}
+		s = append(s, rr.Header.Name.String()+"/"+v)
+	}
+	for _, rr := range m.Authorities {
+		if rr.Header.Type == dnsmessage.TypeSOA {
+			s = append(s, fmt.Sprintf("soa/%d", rr.Header.TTL))
+		}
+	}
+	return strings.Join(s, " ")
+}